@@ -0,0 +1,87 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+)
+
+// usageSummary summarizes the GPU usage of a reservation
+type usageSummary struct {
+	processes    int
+	maxMemoryMiB uint64
+	gpuTime      time.Duration
+}
+
+// accountReservations logs a usage summary of the previous reservation of
+// every device in deviceIDs and starts accounting for the new reservation.
+// Nomad does not notify device plugins when a reservation is released, so a
+// reservation is considered released when its device is reserved again.
+func (d *NvidiaDevice) accountReservations(deviceIDs []string) {
+	if !d.accounting {
+		return
+	}
+
+	d.accountingLock.Lock()
+	defer d.accountingLock.Unlock()
+
+	if d.reservedAt == nil {
+		d.reservedAt = make(map[string]time.Time)
+	}
+
+	now := d.now()
+	for _, id := range deviceIDs {
+		if reservedAt, ok := d.reservedAt[id]; ok {
+			stats, err := d.collector.GetAccountingStats(id)
+			if err != nil {
+				d.logger.Warn("failed to get device accounting stats", "uuid", id, "error", err)
+			} else {
+				summary := summarizeUsage(stats, reservedAt)
+				d.logger.Info("reservation usage summary", "uuid", id,
+					"reserved_at", reservedAt, "released_before", now,
+					"processes", summary.processes,
+					"max_memory_mib", summary.maxMemoryMiB,
+					"gpu_time", summary.gpuTime)
+			}
+		}
+
+		if err := d.startAccounting(id); err != nil {
+			if errors.Is(err, nvml.ErrNotSupported) {
+				d.logger.Debug("device does not support accounting", "uuid", id)
+			} else {
+				d.logger.Warn("failed to start device accounting", "uuid", id, "error", err)
+			}
+			delete(d.reservedAt, id)
+			continue
+		}
+		d.reservedAt[id] = now
+	}
+}
+
+// startAccounting enables accounting on the device and clears the data of
+// previous reservations
+func (d *NvidiaDevice) startAccounting(id string) error {
+	if err := d.collector.EnableAccounting(id); err != nil {
+		return err
+	}
+	return d.collector.ClearAccounting(id)
+}
+
+// summarizeUsage summarizes the accounting stats of processes started since
+// reservedAt
+func summarizeUsage(stats []*nvml.AccountingStats, reservedAt time.Time) usageSummary {
+	var summary usageSummary
+	for _, s := range stats {
+		if s.StartTime.Before(reservedAt) {
+			continue
+		}
+		summary.processes++
+		summary.maxMemoryMiB = max(summary.maxMemoryMiB, s.MaxMemoryMiB)
+		summary.gpuTime += s.GPUTime
+	}
+	return summary
+}
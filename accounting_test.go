@@ -0,0 +1,68 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/shoenig/test/must"
+)
+
+func TestSummarizeUsage(t *testing.T) {
+	reservedAt := time.Unix(1000, 0)
+	stats := []*nvml.AccountingStats{
+		{
+			PID:          1,
+			MaxMemoryMiB: 512,
+			GPUTime:      time.Second,
+			StartTime:    reservedAt.Add(-time.Second),
+		},
+		{
+			PID:          2,
+			MaxMemoryMiB: 256,
+			GPUTime:      2 * time.Second,
+			StartTime:    reservedAt,
+		},
+		{
+			PID:          3,
+			MaxMemoryMiB: 1024,
+			GPUTime:      3 * time.Second,
+			StartTime:    reservedAt.Add(time.Minute),
+		},
+	}
+
+	must.Eq(t, usageSummary{
+		processes:    2,
+		maxMemoryMiB: 1024,
+		gpuTime:      5 * time.Second,
+	}, summarizeUsage(stats, reservedAt))
+}
+
+func TestAccountReservations(t *testing.T) {
+	client := &MockNvmlClient{}
+	d := &NvidiaDevice{
+		collector:  client,
+		accounting: true,
+		logger:     hclog.NewNullLogger(),
+	}
+
+	// the first reservation only starts accounting
+	d.accountReservations([]string{"UUID1"})
+	must.Eq(t, []string{"enable:UUID1", "clear:UUID1"}, client.AccountingCalls)
+	must.MapContainsKey(t, d.reservedAt, "UUID1")
+
+	// the next one summarizes the previous reservation first
+	client.AccountingCalls = nil
+	d.accountReservations([]string{"UUID1"})
+	must.Eq(t, []string{"stats:UUID1", "enable:UUID1", "clear:UUID1"}, client.AccountingCalls)
+
+	// nothing is accounted when disabled
+	client.AccountingCalls = nil
+	d.accounting = false
+	d.accountReservations([]string{"UUID1"})
+	must.SliceEmpty(t, client.AccountingCalls)
+}
@@ -0,0 +1,211 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	// Actions run when a device enters a fatal state, the device is marked
+	// unhealthy in every case
+	fatalActionUnhealthy = "unhealthy"
+	fatalActionScript    = "script"
+	fatalActionWebhook   = "webhook"
+)
+
+// FatalErrorActionConfig configures the action run when a device enters a
+// fatal state, such as uncorrectable ECC errors or falling off the bus
+type FatalErrorActionConfig struct {
+	// Action is one of "unhealthy" (the default), "script" or "webhook"
+	Action string `codec:"action"`
+
+	// Command and Args are executed by the "script" action
+	Command string   `codec:"command"`
+	Args    []string `codec:"args"`
+
+	// WebhookURL receives a POST request from the "webhook" action
+	WebhookURL string `codec:"webhook_url"`
+
+	// Retries is how many times a failed action is retried, waiting
+	// RetryInterval between attempts. Each attempt is bounded by Timeout.
+	Retries       int    `codec:"retries"`
+	RetryInterval string `codec:"retry_interval"`
+	Timeout       string `codec:"timeout"`
+}
+
+// fatalErrorEvent describes a device that entered a fatal state, it is sent
+// as the body of webhooks and on the standard input of scripts
+type fatalErrorEvent struct {
+	UUID     string    `json:"uuid"`
+	Reason   string    `json:"reason"`
+	Hostname string    `json:"hostname"`
+	Time     time.Time `json:"time"`
+}
+
+// fatalErrorAction runs the configured action when a device enters a fatal
+// state, retrying failed attempts and logging every attempt for auditing
+type fatalErrorAction struct {
+	action        string
+	command       string
+	args          []string
+	webhookURL    string
+	retries       int
+	retryInterval time.Duration
+	timeout       time.Duration
+
+	httpClient *http.Client
+	now        func() time.Time
+	logger     hclog.Logger
+}
+
+// newFatalErrorAction validates config and returns the action it describes,
+// whose events are timestamped with now
+func newFatalErrorAction(config FatalErrorActionConfig, now func() time.Time, logger hclog.Logger) (*fatalErrorAction, error) {
+	a := &fatalErrorAction{
+		action:     config.Action,
+		command:    config.Command,
+		args:       config.Args,
+		webhookURL: config.WebhookURL,
+		retries:    config.Retries,
+		httpClient: &http.Client{},
+		now:        now,
+		logger:     logger.Named("fatal_error_action"),
+	}
+
+	switch a.action {
+	case "":
+		a.action = fatalActionUnhealthy
+	case fatalActionUnhealthy:
+	case fatalActionScript:
+		if a.command == "" {
+			return nil, fmt.Errorf("fatal error action %q requires a command", a.action)
+		}
+	case fatalActionWebhook:
+		if a.webhookURL == "" {
+			return nil, fmt.Errorf("fatal error action %q requires a webhook_url", a.action)
+		}
+	default:
+		return nil, fmt.Errorf("invalid fatal error action %q, must be one of %q, %q or %q",
+			a.action, fatalActionUnhealthy, fatalActionScript, fatalActionWebhook)
+	}
+
+	if a.retries < 0 {
+		return nil, fmt.Errorf("invalid fatal error action retries %d, must not be negative", a.retries)
+	}
+
+	var err error
+	if config.RetryInterval != "" {
+		if a.retryInterval, err = time.ParseDuration(config.RetryInterval); err != nil {
+			return nil, fmt.Errorf("failed to parse fatal error action retry interval %q: %v", config.RetryInterval, err)
+		}
+	}
+	if config.Timeout != "" {
+		if a.timeout, err = time.ParseDuration(config.Timeout); err != nil {
+			return nil, fmt.Errorf("failed to parse fatal error action timeout %q: %v", config.Timeout, err)
+		}
+	}
+	return a, nil
+}
+
+// run runs the action for the device with the given UUID until it succeeds,
+// all retries failed or ctx is done
+func (a *fatalErrorAction) run(ctx context.Context, uuid, reason string) {
+	logger := a.logger.With("uuid", uuid, "reason", reason, "action", a.action)
+	if a.action == fatalActionUnhealthy {
+		logger.Info("device entered a fatal state, marked unhealthy")
+		return
+	}
+
+	hostname, _ := os.Hostname()
+	event := &fatalErrorEvent{
+		UUID:     uuid,
+		Reason:   reason,
+		Hostname: hostname,
+		Time:     a.now(),
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("failed to encode fatal error event", "error", err)
+		return
+	}
+
+	for attempt := 1; attempt <= a.retries+1; attempt++ {
+		logger.Info("running fatal error action", "attempt", attempt)
+		err := a.execute(ctx, uuid, reason, body)
+		if err == nil {
+			logger.Info("fatal error action succeeded", "attempt", attempt)
+			return
+		}
+		logger.Warn("fatal error action failed", "attempt", attempt, "error", err)
+
+		if attempt <= a.retries {
+			timer := time.NewTimer(a.retryInterval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				logger.Warn("fatal error action canceled, plugin is shutting down", "attempts", attempt)
+				return
+			case <-timer.C:
+			}
+		}
+	}
+	logger.Error("fatal error action failed, giving up", "attempts", a.retries+1)
+}
+
+// execute makes a single attempt at running the action
+func (a *fatalErrorAction) execute(ctx context.Context, uuid, reason string, body []byte) error {
+	if a.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.timeout)
+		defer cancel()
+	}
+
+	switch a.action {
+	case fatalActionScript:
+		cmd := exec.CommandContext(ctx, a.command, a.args...)
+		cmd.Env = append(os.Environ(),
+			"NVIDIA_GPU_UUID="+uuid,
+			"NVIDIA_GPU_FATAL_ERROR="+reason,
+		)
+		cmd.Stdin = bytes.NewReader(body)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+
+	case fatalActionWebhook:
+		return postJSON(ctx, a.httpClient, a.webhookURL, body)
+	}
+	return nil
+}
+
+// postJSON sends body as a JSON POST request to url and fails unless the
+// response status is 2xx
+func postJSON(ctx context.Context, client *http.Client, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %q", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/shoenig/test/must"
+)
+
+func TestNewFatalErrorAction(t *testing.T) {
+	for _, testCase := range []struct {
+		Name          string
+		Config        FatalErrorActionConfig
+		ExpectedError string
+	}{
+		{
+			Name:   "defaults to unhealthy",
+			Config: FatalErrorActionConfig{},
+		},
+		{
+			Name:   "script",
+			Config: FatalErrorActionConfig{Action: fatalActionScript, Command: "/bin/true"},
+		},
+		{
+			Name:          "script without command",
+			Config:        FatalErrorActionConfig{Action: fatalActionScript},
+			ExpectedError: `fatal error action "script" requires a command`,
+		},
+		{
+			Name:          "webhook without url",
+			Config:        FatalErrorActionConfig{Action: fatalActionWebhook},
+			ExpectedError: `fatal error action "webhook" requires a webhook_url`,
+		},
+		{
+			Name:          "unknown action",
+			Config:        FatalErrorActionConfig{Action: "drain"},
+			ExpectedError: `invalid fatal error action "drain", must be one of "unhealthy", "script" or "webhook"`,
+		},
+		{
+			Name:          "negative retries",
+			Config:        FatalErrorActionConfig{Retries: -1},
+			ExpectedError: "invalid fatal error action retries -1, must not be negative",
+		},
+		{
+			Name:          "invalid retry interval",
+			Config:        FatalErrorActionConfig{RetryInterval: "soon"},
+			ExpectedError: `failed to parse fatal error action retry interval "soon": time: invalid duration "soon"`,
+		},
+	} {
+		t.Run(testCase.Name, func(t *testing.T) {
+			_, err := newFatalErrorAction(testCase.Config, time.Now, hclog.NewNullLogger())
+			if testCase.ExpectedError == "" {
+				must.NoError(t, err)
+			} else {
+				must.EqError(t, err, testCase.ExpectedError)
+			}
+		})
+	}
+}
+
+func TestFatalErrorActionWebhookRetries(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	var requests atomic.Int32
+	var event fatalErrorEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		must.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+	}))
+	defer server.Close()
+
+	action, err := newFatalErrorAction(FatalErrorActionConfig{
+		Action:     fatalActionWebhook,
+		WebhookURL: server.URL,
+		Retries:    2,
+	}, func() time.Time { return now }, hclog.NewNullLogger())
+	must.NoError(t, err)
+
+	action.run(context.Background(), "UUID1", "GPU has fallen off the bus")
+	must.Eq(t, 2, requests.Load())
+	must.Eq(t, "UUID1", event.UUID)
+	must.Eq(t, "GPU has fallen off the bus", event.Reason)
+	must.True(t, now.Equal(event.Time))
+}
+
+func TestFatalErrorActionCanceled(t *testing.T) {
+	var requests atomic.Int32
+	failed := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		failed <- struct{}{}
+	}))
+	defer server.Close()
+
+	action, err := newFatalErrorAction(FatalErrorActionConfig{
+		Action:        fatalActionWebhook,
+		WebhookURL:    server.URL,
+		Retries:       5,
+		RetryInterval: "1h",
+	}, time.Now, hclog.NewNullLogger())
+	must.NoError(t, err)
+
+	// retries stop once the plugin shuts down
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		action.run(ctx, "UUID1", "GPU has fallen off the bus")
+		close(done)
+	}()
+	select {
+	case <-failed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fatal error action did not run")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fatal error action did not stop")
+	}
+	must.Eq(t, 1, requests.Load())
+}
+
+func TestFatalErrorActionScript(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	action, err := newFatalErrorAction(FatalErrorActionConfig{
+		Action:  fatalActionScript,
+		Command: "/bin/sh",
+		Args:    []string{"-c", `echo "$NVIDIA_GPU_UUID: $NVIDIA_GPU_FATAL_ERROR" > ` + out},
+	}, time.Now, hclog.NewNullLogger())
+	must.NoError(t, err)
+
+	action.run(context.Background(), "UUID1", "2 uncorrectable ECC errors")
+	content, err := os.ReadFile(out)
+	must.NoError(t, err)
+	must.Eq(t, "UUID1: 2 uncorrectable ECC errors\n", string(content))
+}
@@ -0,0 +1,194 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+)
+
+const (
+	// Stats attributes of the PCIe errors reported by the kernel Advanced
+	// Error Reporting (AER) driver for the PCI device of a GPU
+	PCIeCorrectableErrorsAttr   = "PCIe correctable errors"
+	PCIeCorrectableErrorsDesc   = "PCIe errors corrected by the hardware since the driver was loaded"
+	PCIeUncorrectableErrorsAttr = "PCIe uncorrectable errors"
+	PCIeUncorrectableErrorsDesc = "Fatal and non-fatal uncorrectable PCIe errors since the driver was loaded"
+)
+
+// sysfsRoot is the mount point of sysfs, it is replaced in tests
+var sysfsRoot = "/sys"
+
+// aerCounters are the PCIe error counters of a PCI device
+type aerCounters struct {
+	correctable   uint64
+	uncorrectable uint64
+}
+
+// sysfsPCIAddress converts a PCI bus ID reported by NVML, such as
+// "00000000:3B:00.0", to the address of the device in sysfs, such as
+// "0000:3b:00.0"
+func sysfsPCIAddress(busID string) (string, error) {
+	domain, rest, ok := strings.Cut(busID, ":")
+	if !ok {
+		return "", fmt.Errorf("invalid PCI bus ID %q", busID)
+	}
+	domainNumber, err := strconv.ParseUint(domain, 16, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid PCI bus ID %q: %v", busID, err)
+	}
+	return fmt.Sprintf("%04x:%s", domainNumber, strings.ToLower(rest)), nil
+}
+
+// readAERCounters reads the AER counters of the PCI device with the given
+// bus ID. The counter files do not exist when the kernel or the platform do
+// not support AER.
+func readAERCounters(busID string) (*aerCounters, error) {
+	address, err := sysfsPCIAddress(busID)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(sysfsRoot, "bus", "pci", "devices", address)
+
+	correctable, err := readAERTotal(filepath.Join(dir, "aer_dev_correctable"), "TOTAL_ERR_COR")
+	if err != nil {
+		return nil, err
+	}
+	fatal, err := readAERTotal(filepath.Join(dir, "aer_dev_fatal"), "TOTAL_ERR_FATAL")
+	if err != nil {
+		return nil, err
+	}
+	nonFatal, err := readAERTotal(filepath.Join(dir, "aer_dev_nonfatal"), "TOTAL_ERR_NONFATAL")
+	if err != nil {
+		return nil, err
+	}
+	return &aerCounters{
+		correctable:   correctable,
+		uncorrectable: fatal + nonFatal,
+	}, nil
+}
+
+// readAERTotal returns the value of the total counter of an AER counter
+// file, which lists one "<error> <count>" pair per line
+func readAERTotal(path, total string) (uint64, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if !ok || name != total {
+			continue
+		}
+		count, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s counter in %q: %v", total, path, err)
+		}
+		return count, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no %s counter in %q", total, path)
+}
+
+// addPCIeErrorStats adds the PCIe error counters of every device to its
+// stats, unless left out by enabled_metrics, and logs a warning whenever the
+// uncorrectable errors of a device increase, as they often precede the GPU
+// falling off the bus. MIG devices report the counters of their physical
+// GPU. Devices without AER counters report no errors stats.
+func (d *NvidiaDevice) addPCIeErrorStats(groups []*device.DeviceGroupStats) {
+	_, correctableEnabled := d.statsOptions.enabledMetrics[PCIeCorrectableErrorsAttr]
+	_, uncorrectableEnabled := d.statsOptions.enabledMetrics[PCIeUncorrectableErrorsAttr]
+	correctableEnabled = correctableEnabled || d.statsOptions.enabledMetrics == nil
+	uncorrectableEnabled = uncorrectableEnabled || d.statsOptions.enabledMetrics == nil
+
+	d.deviceLock.RLock()
+	busIDs := make(map[string]string, len(d.pciBusIDs))
+	for _, group := range groups {
+		for uuid := range group.InstanceStats {
+			id := uuid
+			if parent, ok := d.migParents[uuid]; ok {
+				id = parent
+			}
+			if busID, ok := d.pciBusIDs[id]; ok {
+				busIDs[uuid] = busID
+			}
+		}
+	}
+	d.deviceLock.RUnlock()
+
+	seen := make(map[string]struct{})
+	for _, group := range groups {
+		for uuid, deviceStats := range group.InstanceStats {
+			busID, ok := busIDs[uuid]
+			if !ok {
+				continue
+			}
+			counters, err := readAERCounters(busID)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				d.errorLog.Error(d.logger, "failed to read device PCIe error counters", err, "uuid", uuid)
+				continue
+			}
+			seen[uuid] = struct{}{}
+			d.warnPCIeErrors(uuid, busID, counters)
+
+			if deviceStats.Stats == nil {
+				deviceStats.Stats = &structs.StatObject{}
+			}
+			if deviceStats.Stats.Attributes == nil {
+				deviceStats.Stats.Attributes = make(map[string]*structs.StatValue)
+			}
+			if correctableEnabled {
+				deviceStats.Stats.Attributes[PCIeCorrectableErrorsAttr] = &structs.StatValue{
+					Unit:            UnitCount,
+					Desc:            PCIeCorrectableErrorsDesc,
+					IntNumeratorVal: pointer.Of(int64(counters.correctable)),
+				}
+			}
+			if uncorrectableEnabled {
+				deviceStats.Stats.Attributes[PCIeUncorrectableErrorsAttr] = &structs.StatValue{
+					Unit:            UnitCount,
+					Desc:            PCIeUncorrectableErrorsDesc,
+					IntNumeratorVal: pointer.Of(int64(counters.uncorrectable)),
+				}
+			}
+		}
+	}
+
+	for uuid := range d.lastPCIeErrors {
+		if _, ok := seen[uuid]; !ok {
+			delete(d.lastPCIeErrors, uuid)
+		}
+	}
+}
+
+// warnPCIeErrors logs a warning when the uncorrectable PCIe errors of the
+// device with the given UUID increased since the previous stats collection
+func (d *NvidiaDevice) warnPCIeErrors(uuid, busID string, counters *aerCounters) {
+	if d.lastPCIeErrors == nil {
+		d.lastPCIeErrors = make(map[string]uint64)
+	}
+
+	last, ok := d.lastPCIeErrors[uuid]
+	d.lastPCIeErrors[uuid] = counters.uncorrectable
+	if ok && counters.uncorrectable > last {
+		d.logger.Warn("uncorrectable PCIe errors detected on device", "uuid", uuid,
+			"pci_bus_id", busID, "new_errors", counters.uncorrectable-last, "total_errors", counters.uncorrectable)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+	"github.com/shoenig/test/must"
+)
+
+// setupSysfsRoot points sysfsRoot to a temporary directory holding the AER
+// counter files of the given PCI devices, keyed by sysfs address
+func setupSysfsRoot(t *testing.T, counters map[string]map[string]string) {
+	root := t.TempDir()
+	for address, files := range counters {
+		dir := filepath.Join(root, "bus", "pci", "devices", address)
+		must.NoError(t, os.MkdirAll(dir, 0o755))
+		for name, content := range files {
+			must.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+		}
+	}
+
+	old := sysfsRoot
+	sysfsRoot = root
+	t.Cleanup(func() { sysfsRoot = old })
+}
+
+// aerFiles returns the AER counter files of a device with the given totals
+func aerFiles(correctable, fatal, nonFatal string) map[string]string {
+	return map[string]string{
+		"aer_dev_correctable": "RxErr 0\nBadTLP " + correctable + "\nTOTAL_ERR_COR " + correctable + "\n",
+		"aer_dev_fatal":       "Undefined 0\nDLP 0\nTOTAL_ERR_FATAL " + fatal + "\n",
+		"aer_dev_nonfatal":    "Undefined 0\nCmpltTO " + nonFatal + "\nTOTAL_ERR_NONFATAL " + nonFatal + "\n",
+	}
+}
+
+func TestSysfsPCIAddress(t *testing.T) {
+	cases := []struct {
+		Name     string
+		BusID    string
+		Expected string
+		Err      string
+	}{
+		{
+			Name:     "8 digit domain",
+			BusID:    "00000000:3B:00.0",
+			Expected: "0000:3b:00.0",
+		},
+		{
+			Name:     "4 digit domain",
+			BusID:    "0000:3B:00.0",
+			Expected: "0000:3b:00.0",
+		},
+		{
+			Name:     "wide domain",
+			BusID:    "00010000:01:00.0",
+			Expected: "10000:01:00.0",
+		},
+		{
+			Name:  "missing domain",
+			BusID: "3B00.0",
+			Err:   `invalid PCI bus ID "3B00.0"`,
+		},
+		{
+			Name:  "invalid domain",
+			BusID: "0000000g:3B:00.0",
+			Err:   `invalid PCI bus ID "0000000g:3B:00.0"`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			address, err := sysfsPCIAddress(c.BusID)
+			if c.Err != "" {
+				must.ErrorContains(t, err, c.Err)
+				return
+			}
+			must.NoError(t, err)
+			must.Eq(t, c.Expected, address)
+		})
+	}
+}
+
+func TestReadAERCounters(t *testing.T) {
+	setupSysfsRoot(t, map[string]map[string]string{
+		"0000:3b:00.0": aerFiles("12", "1", "2"),
+		"0000:af:00.0": {
+			"aer_dev_correctable": "RxErr 0\n",
+			"aer_dev_fatal":       "TOTAL_ERR_FATAL 0\n",
+			"aer_dev_nonfatal":    "TOTAL_ERR_NONFATAL 0\n",
+		},
+	})
+
+	counters, err := readAERCounters("00000000:3B:00.0")
+	must.NoError(t, err)
+	must.Eq(t, &aerCounters{correctable: 12, uncorrectable: 3}, counters)
+
+	_, err = readAERCounters("00000000:AF:00.0")
+	must.ErrorContains(t, err, "no TOTAL_ERR_COR counter")
+
+	// platforms without AER have no counter files
+	_, err = readAERCounters("00000000:D8:00.0")
+	must.True(t, os.IsNotExist(err))
+}
+
+func TestAddPCIeErrorStats(t *testing.T) {
+	setupSysfsRoot(t, map[string]map[string]string{
+		"0000:3b:00.0": aerFiles("12", "0", "1"),
+	})
+
+	d := &NvidiaDevice{
+		logger:   hclog.NewNullLogger(),
+		errorLog: newErrorLogLimiter(0, nil),
+		pciBusIDs: map[string]string{
+			"UUID1": "00000000:3B:00.0",
+			"UUID2": "00000000:D8:00.0",
+		},
+		migParents: map[string]string{"MIG1": "UUID1"},
+	}
+	groups := []*device.DeviceGroupStats{{
+		InstanceStats: map[string]*device.DeviceStats{
+			"UUID1": {Stats: &structs.StatObject{}},
+			"UUID2": {Stats: &structs.StatObject{}},
+			"MIG1":  {Stats: &structs.StatObject{}},
+		},
+	}}
+	d.addPCIeErrorStats(groups)
+
+	stats := groups[0].InstanceStats
+	must.Eq(t, int64(12), *stats["UUID1"].Stats.Attributes[PCIeCorrectableErrorsAttr].IntNumeratorVal)
+	must.Eq(t, int64(1), *stats["UUID1"].Stats.Attributes[PCIeUncorrectableErrorsAttr].IntNumeratorVal)
+	must.Eq(t, int64(1), *stats["MIG1"].Stats.Attributes[PCIeUncorrectableErrorsAttr].IntNumeratorVal)
+	must.MapEmpty(t, stats["UUID2"].Stats.Attributes)
+	must.Eq(t, map[string]uint64{"UUID1": 1, "MIG1": 1}, d.lastPCIeErrors)
+
+	// the stats are left out when not enabled
+	d.statsOptions.enabledMetrics = map[string]struct{}{PCIeUncorrectableErrorsAttr: {}}
+	stats["UUID1"].Stats.Attributes = nil
+	d.addPCIeErrorStats(groups)
+	must.MapNotContainsKey(t, stats["UUID1"].Stats.Attributes, PCIeCorrectableErrorsAttr)
+	must.MapContainsKey(t, stats["UUID1"].Stats.Attributes, PCIeUncorrectableErrorsAttr)
+}
@@ -0,0 +1,30 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/hashicorp/nomad/plugins/device"
+)
+
+// supportedAPIVersions are the versions of the device plugin API served by
+// the plugin. Nomad agents select the highest version they also support and
+// pass it to SetConfig, so that a single plugin binary serves agents of
+// different Nomad versions. Nomad 1.4 through 1.9 all use v0.1.0.
+var supportedAPIVersions = []string{device.ApiVersion010}
+
+// negotiatedAPIVersion validates the device plugin API version selected by
+// the Nomad agent. Agents that do not report the version they selected are
+// served the initial version.
+func negotiatedAPIVersion(version string) (string, error) {
+	if version == "" {
+		return device.ApiVersion010, nil
+	}
+	if !slices.Contains(supportedAPIVersions, version) {
+		return "", fmt.Errorf("unsupported device plugin API version %q, must be one of %q", version, supportedAPIVersions)
+	}
+	return version, nil
+}
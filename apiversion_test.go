@@ -0,0 +1,47 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"testing"
+
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/shoenig/test/must"
+)
+
+func TestNegotiatedAPIVersion(t *testing.T) {
+	cases := []struct {
+		Name          string
+		Version       string
+		Expected      string
+		ExpectedError string
+	}{
+		{
+			Name:     "supported",
+			Version:  device.ApiVersion010,
+			Expected: device.ApiVersion010,
+		},
+		{
+			Name:     "not reported",
+			Version:  "",
+			Expected: device.ApiVersion010,
+		},
+		{
+			Name:          "unsupported",
+			Version:       "v0.2.0",
+			ExpectedError: `unsupported device plugin API version "v0.2.0", must be one of ["v0.1.0"]`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			version, err := negotiatedAPIVersion(c.Version)
+			if c.ExpectedError != "" {
+				must.EqError(t, err, c.ExpectedError)
+				return
+			}
+			must.NoError(t, err)
+			must.Eq(t, c.Expected, version)
+		})
+	}
+}
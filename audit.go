@@ -0,0 +1,329 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/device"
+)
+
+// auditMinKeySize is the minimum size of the key audit records are signed
+// with
+const auditMinKeySize = 16
+
+// AuditConfig configures the signed audit log of the fingerprint and stats
+// of the node
+type AuditConfig struct {
+	// File is the path of the audit log, records are appended to it
+	File string `codec:"file"`
+
+	// KeyFile is the path of the file holding the HMAC key records are signed
+	// with, surrounding whitespace is ignored
+	KeyFile string `codec:"key_file"`
+
+	// Interval is the minimum time between records
+	Interval string `codec:"interval"`
+}
+
+// auditRecord is a snapshot of the fingerprint and stats of the node. Every
+// record holds the signature of the record before it, so that removed or
+// reordered records are detected.
+type auditRecord struct {
+	Sequence    uint64              `json:"sequence"`
+	Timestamp   time.Time           `json:"timestamp"`
+	Hostname    string              `json:"hostname"`
+	Previous    string              `json:"previous"`
+	Fingerprint []*auditDeviceGroup `json:"fingerprint"`
+	Stats       *statsSnapshot      `json:"stats"`
+}
+
+// auditDeviceGroup is a device group as fingerprinted, with its attributes
+// formatted along with their unit
+type auditDeviceGroup struct {
+	Vendor     string            `json:"vendor"`
+	Type       string            `json:"type"`
+	Name       string            `json:"name"`
+	Devices    []*auditDevice    `json:"devices"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// auditDevice is a fingerprinted device and its health
+type auditDevice struct {
+	ID         string `json:"id"`
+	Healthy    bool   `json:"healthy"`
+	HealthDesc string `json:"health_desc,omitempty"`
+}
+
+// signedAuditRecord is a line of the audit log. The signature is the HMAC
+// SHA-256 of the record as written, hex encoded.
+type signedAuditRecord struct {
+	Record    json.RawMessage `json:"record"`
+	Signature string          `json:"signature"`
+}
+
+// auditLog appends signed records of the fingerprint and stats of the node
+// to the audit file
+type auditLog struct {
+	path     string
+	key      []byte
+	interval time.Duration
+	hostname string
+
+	lock        sync.Mutex
+	fingerprint []*auditDeviceGroup
+	lastRecord  time.Time
+
+	// sequence and previous are the sequence number and signature of the
+	// last record, resumed from the audit file on the first record
+	sequence uint64
+	previous string
+	resumed  bool
+}
+
+// newAuditLog validates config and returns the audit log, or nil when no
+// audit file is configured
+func newAuditLog(config AuditConfig) (*auditLog, error) {
+	if config.File == "" {
+		return nil, nil
+	}
+	if config.KeyFile == "" {
+		return nil, errors.New("audit key_file must be set with audit file")
+	}
+	key, err := readAuditKey(config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &auditLog{path: config.File, key: key}
+	if config.Interval != "" {
+		interval, err := time.ParseDuration(config.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse audit interval %q: %v", config.Interval, err)
+		}
+		if interval < 0 {
+			return nil, fmt.Errorf("invalid audit interval %q, must not be negative", config.Interval)
+		}
+		a.interval = interval
+	}
+	a.hostname, _ = os.Hostname()
+	return a, nil
+}
+
+// readAuditKey reads the HMAC key audit records are signed with from path
+func readAuditKey(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit key file %q: %v", path, err)
+	}
+	key := bytes.TrimSpace(content)
+	if len(key) < auditMinKeySize {
+		return nil, fmt.Errorf("invalid audit key file %q, must hold at least %d bytes", path, auditMinKeySize)
+	}
+	return key, nil
+}
+
+// setFingerprint records the device groups sent to Nomad, which are part of
+// the following records
+func (a *auditLog) setFingerprint(groups []*device.DeviceGroup) {
+	if a == nil {
+		return
+	}
+
+	fingerprint := make([]*auditDeviceGroup, 0, len(groups))
+	for _, group := range groups {
+		auditGroup := &auditDeviceGroup{
+			Vendor:     group.Vendor,
+			Type:       group.Type,
+			Name:       group.Name,
+			Devices:    make([]*auditDevice, 0, len(group.Devices)),
+			Attributes: make(map[string]string, len(group.Attributes)),
+		}
+		for _, dev := range group.Devices {
+			auditGroup.Devices = append(auditGroup.Devices, &auditDevice{
+				ID:         dev.ID,
+				Healthy:    dev.Healthy,
+				HealthDesc: dev.HealthDesc,
+			})
+		}
+		for attr, value := range group.Attributes {
+			auditGroup.Attributes[attr] = value.GoString()
+		}
+		fingerprint = append(fingerprint, auditGroup)
+	}
+	sort.Slice(fingerprint, func(i, j int) bool {
+		return fingerprint[i].Name < fingerprint[j].Name
+	})
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.fingerprint = fingerprint
+}
+
+// record appends a record of the last fingerprint and of the stats groups
+// collected at timestamp to the audit file, unless the last record is more
+// recent than the audit interval
+func (a *auditLog) record(groups []*device.DeviceGroupStats, timestamp time.Time) error {
+	if a == nil {
+		return nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if !a.lastRecord.IsZero() && timestamp.Sub(a.lastRecord) < a.interval {
+		return nil
+	}
+	if !a.resumed {
+		if err := a.resume(); err != nil {
+			return err
+		}
+	}
+
+	record := &auditRecord{
+		Sequence:    a.sequence + 1,
+		Timestamp:   timestamp,
+		Hostname:    a.hostname,
+		Previous:    a.previous,
+		Fingerprint: a.fingerprint,
+		Stats:       statsSnapshotOf(groups, timestamp),
+	}
+	content, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %v", err)
+	}
+	signature := signAuditRecord(a.key, content)
+	line, err := json.Marshal(&signedAuditRecord{
+		Record:    content,
+		Signature: signature,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %v", err)
+	}
+
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file %q: %v", a.path, err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write audit file %q: %v", a.path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write audit file %q: %v", a.path, err)
+	}
+
+	a.sequence = record.Sequence
+	a.previous = signature
+	a.lastRecord = timestamp
+	return nil
+}
+
+// resume continues the chain of records of an existing audit file, so that
+// records stay chained across restarts of the plugin
+func (a *auditLog) resume() error {
+	var last *signedAuditRecord
+	err := readAuditFile(a.path, func(_ int, line *signedAuditRecord) error {
+		last = line
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if last != nil {
+		var record auditRecord
+		if err := json.Unmarshal(last.Record, &record); err != nil {
+			return fmt.Errorf("failed to decode last record of audit file %q: %v", a.path, err)
+		}
+		a.sequence = record.Sequence
+		a.previous = last.Signature
+	}
+	a.resumed = true
+	return nil
+}
+
+// signAuditRecord returns the hex encoded HMAC SHA-256 of content with key
+func signAuditRecord(key, content []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(content)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// readAuditFile calls fn with every line of the audit file at path, numbered
+// from 1, and stops at the first error
+func readAuditFile(path string, fn func(int, *signedAuditRecord) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	number := 0
+	for scanner.Scan() {
+		number++
+		var line signedAuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return fmt.Errorf("line %d: failed to decode audit record: %v", number, err)
+		}
+		if err := fn(number, &line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read audit file %q: %v", path, err)
+	}
+	return nil
+}
+
+// VerifyAuditFile verifies the signature of every record of the audit file
+// at path with the key held in keyFile, and that every record follows the
+// one before it, so that modified, removed or reordered records are
+// detected. The first record is trusted to start the chain, as older records
+// may have been rotated out. It returns the number of records verified.
+func VerifyAuditFile(path, keyFile string) (int, error) {
+	key, err := readAuditKey(keyFile)
+	if err != nil {
+		return 0, err
+	}
+
+	verified := 0
+	var sequence uint64
+	var previous string
+	err = readAuditFile(path, func(number int, line *signedAuditRecord) error {
+		if !hmac.Equal([]byte(signAuditRecord(key, line.Record)), []byte(line.Signature)) {
+			return fmt.Errorf("line %d: invalid signature", number)
+		}
+
+		var record auditRecord
+		if err := json.Unmarshal(line.Record, &record); err != nil {
+			return fmt.Errorf("line %d: failed to decode audit record: %v", number, err)
+		}
+		if verified != 0 {
+			if record.Sequence != sequence+1 {
+				return fmt.Errorf("line %d: record %d follows record %d", number, record.Sequence, sequence)
+			}
+			if record.Previous != previous {
+				return fmt.Errorf("line %d: record %d is not chained to record %d", number, record.Sequence, sequence)
+			}
+		}
+		sequence = record.Sequence
+		previous = line.Signature
+		verified++
+		return nil
+	})
+	return verified, err
+}
@@ -0,0 +1,157 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+	"github.com/shoenig/test/must"
+)
+
+// writeAuditKey writes an audit key file in a temporary directory and
+// returns its path
+func writeAuditKey(t *testing.T, key string) string {
+	path := filepath.Join(t.TempDir(), "audit.key")
+	must.NoError(t, os.WriteFile(path, []byte(key), 0o600))
+	return path
+}
+
+func TestAuditLog(t *testing.T) {
+	keyFile := writeAuditKey(t, "0123456789abcdef0123456789abcdef\n")
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := newAuditLog(AuditConfig{File: path, KeyFile: keyFile, Interval: "5m"})
+	must.NoError(t, err)
+
+	audit.setFingerprint([]*device.DeviceGroup{{
+		Vendor:  "nvidia",
+		Type:    "gpu",
+		Name:    "Tesla T4",
+		Devices: []*device.Device{{ID: "UUID1", Healthy: true}},
+		Attributes: map[string]*structs.Attribute{
+			MemoryAttr: {Int: pointer.Of(int64(15360)), Unit: structs.UnitMiB},
+		},
+	}})
+	stats := []*device.DeviceGroupStats{{
+		Name: "Tesla T4",
+		InstanceStats: map[string]*device.DeviceStats{
+			"UUID1": {Summary: &structs.StatValue{IntNumeratorVal: pointer.Of(int64(42)), Unit: UnitPercent}},
+		},
+	}}
+
+	start := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	must.NoError(t, audit.record(stats, start))
+	// records are written at most once per interval
+	must.NoError(t, audit.record(stats, start.Add(time.Minute)))
+	must.NoError(t, audit.record(stats, start.Add(5*time.Minute)))
+
+	verified, err := VerifyAuditFile(path, keyFile)
+	must.NoError(t, err)
+	must.Eq(t, 2, verified)
+
+	content, err := os.ReadFile(path)
+	must.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	must.Len(t, 2, lines)
+
+	var line signedAuditRecord
+	must.NoError(t, json.Unmarshal([]byte(lines[0]), &line))
+	var record auditRecord
+	must.NoError(t, json.Unmarshal(line.Record, &record))
+	must.Eq(t, 1, record.Sequence)
+	must.Eq(t, "", record.Previous)
+	must.Eq(t, "15360MiB", record.Fingerprint[0].Attributes[MemoryAttr])
+	must.Eq(t, 42.0, *record.Stats.Groups[0].Instances[0].Summary.Value)
+
+	// a restarted plugin continues the chain of the audit file
+	restarted, err := newAuditLog(AuditConfig{File: path, KeyFile: keyFile, Interval: "5m"})
+	must.NoError(t, err)
+	must.NoError(t, restarted.record(stats, start.Add(10*time.Minute)))
+	verified, err = VerifyAuditFile(path, keyFile)
+	must.NoError(t, err)
+	must.Eq(t, 3, verified)
+
+	// records signed with another key are rejected
+	_, err = VerifyAuditFile(path, writeAuditKey(t, "fedcba9876543210fedcba9876543210"))
+	must.EqError(t, err, "line 1: invalid signature")
+}
+
+func TestVerifyAuditFileTampering(t *testing.T) {
+	keyFile := writeAuditKey(t, "0123456789abcdef0123456789abcdef")
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := newAuditLog(AuditConfig{File: path, KeyFile: keyFile})
+	must.NoError(t, err)
+
+	start := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		must.NoError(t, audit.record(nil, start.Add(time.Duration(i)*time.Minute)))
+	}
+	content, err := os.ReadFile(path)
+	must.NoError(t, err)
+	lines := strings.SplitAfter(string(content), "\n")
+
+	cases := []struct {
+		Name          string
+		Content       string
+		ExpectedError string
+	}{
+		{
+			Name:          "modified record",
+			Content:       lines[0] + strings.Replace(lines[1], `"sequence":2`, `"sequence":7`, 1) + lines[2],
+			ExpectedError: "line 2: invalid signature",
+		},
+		{
+			Name:          "removed record",
+			Content:       lines[0] + lines[2],
+			ExpectedError: "line 2: record 3 follows record 1",
+		},
+		{
+			Name:          "reordered records",
+			Content:       lines[1] + lines[0] + lines[2],
+			ExpectedError: "line 2: record 1 follows record 2",
+		},
+		{
+			Name:    "rotated records",
+			Content: lines[1] + lines[2],
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			tampered := filepath.Join(t.TempDir(), "audit.log")
+			must.NoError(t, os.WriteFile(tampered, []byte(c.Content), 0o600))
+			_, err := VerifyAuditFile(tampered, keyFile)
+			if c.ExpectedError == "" {
+				must.NoError(t, err)
+				return
+			}
+			must.EqError(t, err, c.ExpectedError)
+		})
+	}
+}
+
+func TestNewAuditLog(t *testing.T) {
+	audit, err := newAuditLog(AuditConfig{})
+	must.NoError(t, err)
+	must.Nil(t, audit)
+
+	_, err = newAuditLog(AuditConfig{File: "audit.log"})
+	must.ErrorContains(t, err, "audit key_file must be set")
+
+	_, err = newAuditLog(AuditConfig{File: "audit.log", KeyFile: writeAuditKey(t, "short\n")})
+	must.ErrorContains(t, err, "must hold at least 16 bytes")
+
+	_, err = newAuditLog(AuditConfig{
+		File:     "audit.log",
+		KeyFile:  writeAuditKey(t, "0123456789abcdef"),
+		Interval: "-1m",
+	})
+	must.ErrorContains(t, err, `invalid audit interval "-1m"`)
+}
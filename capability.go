@@ -0,0 +1,115 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+)
+
+const (
+	// ComputeCapabilityAttr is the CUDA compute capability of the devices,
+	// such as "8.0", and CUDADriverVersionAttr the highest CUDA version
+	// supported by the driver, such as "12.4". Jobs constrain on them to
+	// run where their CUDA binaries can.
+	ComputeCapabilityAttr = "compute_capability"
+	CUDADriverVersionAttr = "cuda_driver_version"
+)
+
+// incompatibleError is returned by Reserve for device IDs that do not meet
+// the CUDA requirements of the reservation block
+type incompatibleError struct {
+	ids    []string
+	reason string
+}
+
+func (e *incompatibleError) Error() string {
+	return fmt.Sprintf("%s device IDs: %s: %s", ReservationErrIncompatible, strings.Join(e.ids, ","), e.reason)
+}
+
+// Code returns the reservation error code
+func (e *incompatibleError) Code() string {
+	return ReservationErrIncompatible
+}
+
+// validateVersionRequirement returns an error if the reservation requirement
+// named name is neither empty nor a dotted numeric version
+func validateVersionRequirement(name, version string) error {
+	if version == "" {
+		return nil
+	}
+	if _, ok := versionOlder(version, version); !ok {
+		return fmt.Errorf("invalid reservation %s %q, must be a version such as \"8.0\"", name, version)
+	}
+	return nil
+}
+
+// setCapabilities records the compute capability of the fingerprinted
+// devices and the CUDA version supported by the driver, which reservations
+// are checked against
+func (d *NvidiaDevice) setCapabilities(devices []*nvml.FingerprintDeviceData, cudaDriverVersion string) {
+	capabilities := make(map[string]string, len(devices))
+	for _, dev := range devices {
+		if dev.ComputeCapability != nil {
+			capabilities[dev.UUID] = *dev.ComputeCapability
+		}
+	}
+
+	d.deviceLock.Lock()
+	defer d.deviceLock.Unlock()
+	d.computeCapabilities = capabilities
+	d.cudaDriverVersion = cudaDriverVersion
+}
+
+// validateCapabilities returns an incompatibleError if the driver does not
+// support the minimum CUDA version of the reservation block, or any of
+// deviceIDs is older than its minimum compute capability. Capabilities that
+// are unknown are not rejected.
+func (d *NvidiaDevice) validateCapabilities(deviceIDs []string) error {
+	minCUDA := d.reservation.MinCUDAVersion
+	minCapability := d.reservation.MinComputeCapability
+	if minCUDA == "" && minCapability == "" {
+		return nil
+	}
+
+	d.deviceLock.RLock()
+	defer d.deviceLock.RUnlock()
+
+	if minCUDA != "" {
+		if older, ok := versionOlder(d.cudaDriverVersion, minCUDA); ok && older {
+			return &incompatibleError{
+				ids:    deviceIDs,
+				reason: fmt.Sprintf("driver supports CUDA %s, %s is required", d.cudaDriverVersion, minCUDA),
+			}
+		}
+	}
+
+	if minCapability != "" {
+		var ids, capabilities []string
+		for _, id := range deviceIDs {
+			capability, ok := d.computeCapabilities[id]
+			if !ok {
+				continue
+			}
+			if older, ok := versionOlder(capability, minCapability); ok && older {
+				ids = append(ids, id)
+				if !slices.Contains(capabilities, capability) {
+					capabilities = append(capabilities, capability)
+				}
+			}
+		}
+		if len(ids) != 0 {
+			slices.Sort(capabilities)
+			return &incompatibleError{
+				ids: ids,
+				reason: fmt.Sprintf("compute capability %s is older than the required %s",
+					strings.Join(capabilities, ", "), minCapability),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"testing"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/shoenig/test/must"
+)
+
+func TestValidateCapabilities(t *testing.T) {
+	d := &NvidiaDevice{}
+	d.setCapabilities([]*nvml.FingerprintDeviceData{
+		{DeviceData: &nvml.DeviceData{UUID: "UUID1"}, ComputeCapability: pointer.Of("7.5")},
+		{DeviceData: &nvml.DeviceData{UUID: "UUID2"}, ComputeCapability: pointer.Of("8.0")},
+		{DeviceData: &nvml.DeviceData{UUID: "UUID3"}},
+	}, "12.4")
+
+	cases := []struct {
+		Name          string
+		MinCapability string
+		MinCUDA       string
+		IDs           []string
+		ExpectedError string
+	}{
+		{
+			Name: "no requirements",
+			IDs:  []string{"UUID1"},
+		},
+		{
+			Name:          "capability met",
+			MinCapability: "8.0",
+			MinCUDA:       "12.0",
+			IDs:           []string{"UUID2"},
+		},
+		{
+			Name:          "capability too old",
+			MinCapability: "8.0",
+			IDs:           []string{"UUID1", "UUID2"},
+			ExpectedError: "incompatible device IDs: UUID1: compute capability 7.5 is older than the required 8.0",
+		},
+		{
+			Name:          "unknown capability",
+			MinCapability: "8.0",
+			IDs:           []string{"UUID3"},
+		},
+		{
+			Name:          "cuda too old",
+			MinCUDA:       "12.6",
+			IDs:           []string{"UUID2", "UUID3"},
+			ExpectedError: "incompatible device IDs: UUID2,UUID3: driver supports CUDA 12.4, 12.6 is required",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			d.reservation = ReservationConfig{
+				MinComputeCapability: c.MinCapability,
+				MinCUDAVersion:       c.MinCUDA,
+			}
+			err := d.validateCapabilities(c.IDs)
+			if c.ExpectedError == "" {
+				must.NoError(t, err)
+				return
+			}
+			must.EqError(t, err, c.ExpectedError)
+			must.Eq(t, ReservationErrIncompatible, err.(*incompatibleError).Code())
+		})
+	}
+
+	// drivers that do not report their CUDA version are not rejected
+	d.setCapabilities(nil, "")
+	d.reservation = ReservationConfig{MinCUDAVersion: "12.6"}
+	must.NoError(t, d.validateCapabilities([]string{"UUID1"}))
+}
+
+func TestCapabilityRequirementsConfig(t *testing.T) {
+	d := newReplayDevice(t)
+	must.NoError(t, setPluginConfig(t, d, `
+config {
+  reservation {
+    min_compute_capability = "7.5"
+    min_cuda_version       = "12.2"
+  }
+}
+`))
+	must.Eq(t, "7.5", d.reservation.MinComputeCapability)
+	must.Eq(t, "12.2", d.reservation.MinCUDAVersion)
+
+	err := setPluginConfig(t, d, `
+config {
+  reservation {
+    min_cuda_version = "twelve"
+  }
+}
+`)
+	must.ErrorContains(t, err, `invalid reservation min_cuda_version "twelve"`)
+}
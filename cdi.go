@@ -0,0 +1,261 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+)
+
+const (
+	// cdiKind is the kind of the CDI specs describing Nvidia GPUs, as
+	// generated by nvidia-ctk
+	cdiKind = "nvidia.com/gpu"
+
+	// cdiAllDevices is the CDI device nvidia-ctk generates to reference all
+	// GPUs at once, it is not fingerprinted
+	cdiAllDevices = "all"
+
+	// Annotations of CDI devices read by the plugin, they use the names of
+	// the labels of the Nvidia GPU feature discovery
+	cdiProductAnnotation = "nvidia.com/gpu.product"
+	cdiMemoryAnnotation  = "nvidia.com/gpu.memory"
+)
+
+// cdiSpec is the part of a CDI spec file read by the plugin
+type cdiSpec struct {
+	Kind           string            `json:"kind"`
+	Devices        []cdiDevice       `json:"devices"`
+	ContainerEdits cdiContainerEdits `json:"containerEdits"`
+}
+
+// cdiDevice is the part of a CDI device read by the plugin
+type cdiDevice struct {
+	Name           string            `json:"name"`
+	Annotations    map[string]string `json:"annotations"`
+	ContainerEdits cdiContainerEdits `json:"containerEdits"`
+}
+
+// cdiContainerEdits are the device nodes and mounts a CDI spec or device
+// adds to containers. Environment variables and hooks are not read.
+type cdiContainerEdits struct {
+	DeviceNodes []cdiDeviceNode `json:"deviceNodes"`
+	Mounts      []cdiMount      `json:"mounts"`
+}
+
+// cdiDeviceNode is a device node of a CDI container edit, HostPath defaults
+// to Path when empty
+type cdiDeviceNode struct {
+	Path     string `json:"path"`
+	HostPath string `json:"hostPath"`
+}
+
+// cdiMount is a mount of a CDI container edit
+type cdiMount struct {
+	HostPath      string   `json:"hostPath"`
+	ContainerPath string   `json:"containerPath"`
+	Options       []string `json:"options"`
+}
+
+// cdiClient implements nvml.NvmlClient by fingerprinting the devices of the
+// CDI spec files in a directory instead of querying NVML. Devices are
+// identified by their fully qualified CDI name, such as nvidia.com/gpu=0, so
+// that reservations pass CDI names to the container runtime. No stats or
+// device management are available.
+type cdiClient struct {
+	specDir string
+}
+
+func newCDIClient(specDir string) *cdiClient {
+	return &cdiClient{specDir: specDir}
+}
+
+// GetFingerprintData reads the CDI spec files on every call, so that specs
+// regenerated by nvidia-ctk are picked up on the next fingerprint
+func (c *cdiClient) GetFingerprintData() (*nvml.FingerprintData, error) {
+	paths, err := filepath.Glob(filepath.Join(c.specDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var devices []*nvml.FingerprintDeviceData
+	for _, path := range paths {
+		specDevices, err := readCDISpec(path)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, specDevices...)
+	}
+	return &nvml.FingerprintData{Devices: devices}, nil
+}
+
+// parseCDISpec parses the CDI spec file at path
+func parseCDISpec(path string) (*cdiSpec, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CDI spec %q: %v", path, err)
+	}
+	var spec cdiSpec
+	if err := json.Unmarshal(content, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse CDI spec %q: %v", path, err)
+	}
+	return &spec, nil
+}
+
+// readCDISpec returns the Nvidia GPUs described by the CDI spec file at path
+func readCDISpec(path string) ([]*nvml.FingerprintDeviceData, error) {
+	spec, err := parseCDISpec(path)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Kind != cdiKind {
+		return nil, nil
+	}
+
+	var devices []*nvml.FingerprintDeviceData
+	for _, specDevice := range spec.Devices {
+		if specDevice.Name == "" || specDevice.Name == cdiAllDevices {
+			continue
+		}
+
+		deviceData := &nvml.FingerprintDeviceData{
+			DeviceData: &nvml.DeviceData{
+				UUID: spec.Kind + "=" + specDevice.Name,
+			},
+		}
+		if product, ok := specDevice.Annotations[cdiProductAnnotation]; ok && product != "" {
+			deviceData.DeviceName = &product
+		}
+		if memory, ok := specDevice.Annotations[cdiMemoryAnnotation]; ok {
+			memoryMiB, err := strconv.ParseUint(memory, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s annotation %q of CDI device %q in %q: %v",
+					cdiMemoryAnnotation, memory, specDevice.Name, path, err)
+			}
+			deviceData.MemoryMiB = &memoryMiB
+		}
+		devices = append(devices, deviceData)
+	}
+	return devices, nil
+}
+
+// readCDIContainerEdits returns the container edits of the CDI devices with
+// the given fully qualified names, along with the edits of the spec files
+// describing them, which hold the control device nodes and the driver
+// libraries every GPU container needs
+func readCDIContainerEdits(specDir string, names []string) (*cdiContainerEdits, error) {
+	paths, err := filepath.Glob(filepath.Join(specDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	wanted := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		wanted[name] = struct{}{}
+	}
+
+	edits := &cdiContainerEdits{}
+	for _, path := range paths {
+		spec, err := parseCDISpec(path)
+		if err != nil {
+			return nil, err
+		}
+		if spec.Kind != cdiKind {
+			continue
+		}
+
+		found := false
+		for _, specDevice := range spec.Devices {
+			name := spec.Kind + "=" + specDevice.Name
+			if _, ok := wanted[name]; !ok {
+				continue
+			}
+			delete(wanted, name)
+			found = true
+			edits.DeviceNodes = append(edits.DeviceNodes, specDevice.ContainerEdits.DeviceNodes...)
+			edits.Mounts = append(edits.Mounts, specDevice.ContainerEdits.Mounts...)
+		}
+		if found {
+			edits.DeviceNodes = append(edits.DeviceNodes, spec.ContainerEdits.DeviceNodes...)
+			edits.Mounts = append(edits.Mounts, spec.ContainerEdits.Mounts...)
+		}
+	}
+
+	if len(wanted) != 0 {
+		missing := make([]string, 0, len(wanted))
+		for name := range wanted {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("CDI devices %v not found in %q", missing, specDir)
+	}
+	return edits, nil
+}
+
+// GetStatsData returns no stats, CDI specs do not describe device usage
+func (c *cdiClient) GetStatsData() ([]*nvml.StatsData, error) {
+	return nil, nil
+}
+
+func (c *cdiClient) ResetDevice(string, bool) error {
+	return nvml.ErrNotSupported
+}
+
+// GetComputeProcesses reports no processes, they are unknown without NVML
+func (c *cdiClient) GetComputeProcesses(string) ([]int, error) {
+	return nil, nil
+}
+
+func (c *cdiClient) EnableAccounting(string) error {
+	return nvml.ErrNotSupported
+}
+
+func (c *cdiClient) GetAccountingStats(string) ([]*nvml.AccountingStats, error) {
+	return nil, nvml.ErrNotSupported
+}
+
+func (c *cdiClient) ClearAccounting(string) error {
+	return nvml.ErrNotSupported
+}
+
+func (c *cdiClient) GetEnergyConsumption(string) (uint64, error) {
+	return 0, nvml.ErrNotSupported
+}
+
+// GetFatalError reports no fatal errors, they are unknown without NVML
+func (c *cdiClient) GetFatalError(string) (string, error) {
+	return "", nil
+}
+
+// Preflight passes every device, CDI specs are trusted to describe usable
+// devices
+func (c *cdiClient) Preflight(string) error {
+	return nil
+}
+
+func (c *cdiClient) SetBreakerConfig(nvml.BreakerConfig) {}
+
+func (c *cdiClient) SetUtilizationSampling(bool) {}
+
+func (c *cdiClient) SetGPMMetrics(bool) {}
+
+func (c *cdiClient) SetErrorLogInterval(time.Duration) {}
+
+// SampleStats samples nothing, CDI specs do not describe device usage
+func (c *cdiClient) SampleStats() error {
+	return nil
+}
+
+func (c *cdiClient) Shutdown() error {
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/shoenig/test/must"
+)
+
+const testCDISpec = `{
+  "cdiVersion": "0.5.0",
+  "kind": "nvidia.com/gpu",
+  "devices": [
+    {
+      "name": "0",
+      "annotations": {
+        "nvidia.com/gpu.product": "Tesla T4",
+        "nvidia.com/gpu.memory": "15360"
+      },
+      "containerEdits": {"deviceNodes": [{"path": "/dev/nvidia0"}]}
+    },
+    {
+      "name": "1",
+      "containerEdits": {"deviceNodes": [{"path": "/dev/nvidia1"}]}
+    },
+    {
+      "name": "all",
+      "containerEdits": {"deviceNodes": [{"path": "/dev/nvidia0"}, {"path": "/dev/nvidia1"}]}
+    }
+  ]
+}`
+
+func TestCDIClient(t *testing.T) {
+	dir := t.TempDir()
+	must.NoError(t, os.WriteFile(filepath.Join(dir, "nvidia.json"), []byte(testCDISpec), 0o644))
+	// specs of other kinds and files that are not JSON are skipped
+	must.NoError(t, os.WriteFile(filepath.Join(dir, "other.json"), []byte(`{"kind": "vendor.com/device", "devices": [{"name": "0"}]}`), 0o644))
+	must.NoError(t, os.WriteFile(filepath.Join(dir, "nvidia.yaml"), []byte("kind: nvidia.com/gpu"), 0o644))
+
+	data, err := newCDIClient(dir).GetFingerprintData()
+	must.NoError(t, err)
+	must.Len(t, 2, data.Devices)
+
+	must.Eq(t, "nvidia.com/gpu=0", data.Devices[0].UUID)
+	must.Eq(t, "Tesla T4", *data.Devices[0].DeviceName)
+	must.Eq(t, uint64(15360), *data.Devices[0].MemoryMiB)
+
+	must.Eq(t, "nvidia.com/gpu=1", data.Devices[1].UUID)
+	must.Nil(t, data.Devices[1].DeviceName)
+	must.Nil(t, data.Devices[1].MemoryMiB)
+}
+
+func TestCDIClientInvalidSpec(t *testing.T) {
+	cases := []struct {
+		Name          string
+		Spec          string
+		ExpectedError string
+	}{
+		{
+			Name:          "invalid JSON",
+			Spec:          `{"kind": `,
+			ExpectedError: "failed to parse CDI spec",
+		},
+		{
+			Name:          "invalid memory annotation",
+			Spec:          `{"kind": "nvidia.com/gpu", "devices": [{"name": "0", "annotations": {"nvidia.com/gpu.memory": "16GB"}}]}`,
+			ExpectedError: "invalid nvidia.com/gpu.memory annotation",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			must.NoError(t, os.WriteFile(filepath.Join(dir, "nvidia.json"), []byte(c.Spec), 0o644))
+
+			_, err := newCDIClient(dir).GetFingerprintData()
+			must.ErrorContains(t, err, c.ExpectedError)
+		})
+	}
+}
+
+func TestCDIReservation(t *testing.T) {
+	dir := t.TempDir()
+	must.NoError(t, os.WriteFile(filepath.Join(dir, "nvidia.json"), []byte(testCDISpec), 0o644))
+
+	d := &NvidiaDevice{
+		enabled:       true,
+		devices:       make(map[string]struct{}),
+		ignoredGPUIDs: make(map[string]struct{}),
+		logger:        hclog.NewNullLogger(),
+	}
+	d.useCDISpecs(dir)
+
+	channel := make(chan *device.FingerprintResponse, 1)
+	d.writeFingerprintToChannel(channel, "")
+	result := <-channel
+	must.NoError(t, result.Error)
+	must.Len(t, 2, result.Devices)
+
+	// reservations pass CDI device names to the container runtime
+	reservation, err := d.Reserve([]string{"nvidia.com/gpu=0", "nvidia.com/gpu=1"})
+	must.NoError(t, err)
+	must.Eq(t, "nvidia.com/gpu=0,nvidia.com/gpu=1", reservation.Envs[NvidiaVisibleDevices])
+}
@@ -0,0 +1,35 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import "time"
+
+// Clock tells the current time to the plugin, which uses it for the
+// timestamps of stats, health transitions and reservations. Tests and replay
+// tools provide their own clock to control timestamps.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc is a Clock telling the time returned by the function
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// systemClock is the default Clock, telling the time of the system
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// now returns the current time of the clock of the plugin
+func (d *NvidiaDevice) now() time.Time {
+	if d.clock == nil {
+		return systemClock{}.Now()
+	}
+	return d.clock.Now()
+}
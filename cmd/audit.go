@@ -0,0 +1,34 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	nvidia "github.com/hashicorp/nomad-device-nvidia"
+)
+
+// verifyAudit verifies the signatures and chaining of the records of the
+// audit file given as argument, printing the outcome to stdout
+func verifyAudit(args []string) error {
+	flags := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+	keyFile := flags.String("key-file", "", "path of the file holding the HMAC key the records are signed with")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || *keyFile == "" {
+		return errors.New("usage: verify-audit -key-file <path> <audit file>")
+	}
+
+	verified, err := nvidia.VerifyAuditFile(flags.Arg(0), *keyFile)
+	if err != nil {
+		return fmt.Errorf("FAIL: %d records verified: %v", verified, err)
+	}
+	fmt.Printf("OK: %d records verified\n", verified)
+	return nil
+}
@@ -4,19 +4,48 @@
 package main
 
 import (
-	"context"
+	"fmt"
+	"os"
 
-	"github.com/hashicorp/go-hclog"
-	"github.com/hashicorp/nomad-device-nvidia"
-	"github.com/hashicorp/nomad/plugins"
+	"github.com/hashicorp/nomad-device-nvidia/pkg/plugin"
 )
 
 func main() {
-	// Serve the plugin
-	plugins.ServeCtx(factory)
-}
+	// Serve NVML calls when started as the worker of the isolated NVML mode
+	plugin.RunWorker()
+
+	// Record the responses of NVML to replay them in tests
+	if len(os.Args) > 1 && os.Args[1] == "record" {
+		if err := record(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-// factory returns a new instance of the Nvidia GPU plugin
-func factory(ctx context.Context, log hclog.Logger) interface{} {
-	return nvidia.NewNvidiaDevice(ctx, log)
+	// Check the GPUs of the node in image bake pipelines and node bootstrap
+	// scripts, exiting with a non-zero status when they fail expectations
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		ok, err := verify(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Verify that the records of an audit file were not tampered with
+	if len(os.Args) > 1 && os.Args[1] == "verify-audit" {
+		if err := verifyAudit(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Serve the plugin
+	plugin.Serve()
 }
@@ -0,0 +1,35 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+)
+
+// record writes the responses of the NVML library of the node to stdout as
+// JSON, to be replayed in tests on nodes without GPUs
+func record(args []string) error {
+	flags := flag.NewFlagSet("record", flag.ContinueOnError)
+	libraryPath := flags.String("nvml-library-path", "", "path of the NVML library, the default library search path is used when empty")
+	samples := flags.Int("samples", 3, "number of status samples recorded for every device")
+	interval := flags.Duration("interval", time.Second, "time between status samples")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	recording, err := nvml.Record(*libraryPath, *samples, *interval)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(recording)
+}
@@ -0,0 +1,49 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/hashicorp/go-hclog"
+	nvidia "github.com/hashicorp/nomad-device-nvidia"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+)
+
+// verify checks that the GPUs of the node meet the expectations given as
+// flags, printing the outcome to stdout, and reports whether they do
+func verify(args []string) (bool, error) {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	libraryPath := flags.String("nvml-library-path", "", "path of the NVML library, the default library search path is used when empty")
+	minGPUs := flags.Int("min-gpus", 1, "minimum number of GPUs, the MIG devices of a GPU count as one GPU")
+	minDriver := flags.String("min-driver", "", "minimum Nvidia driver version, such as 535 or 535.104.05")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	client, err := nvml.NewNvmlClient(*libraryPath, hclog.NewNullLogger())
+	if err != nil {
+		return false, fmt.Errorf("failed to initialize NVML: %v", err)
+	}
+	defer client.Shutdown()
+
+	result, err := nvidia.Verify(client, nvidia.VerifyExpectations{
+		MinGPUs:   *minGPUs,
+		MinDriver: *minDriver,
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, failure := range result.Failures {
+		fmt.Println("FAIL:", failure)
+	}
+	if len(result.Failures) != 0 {
+		return false, nil
+	}
+	fmt.Printf("OK: %d GPUs, driver version %s\n", result.GPUs, result.DriverVersion)
+	return true, nil
+}
@@ -0,0 +1,66 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+)
+
+// Collector collects the devices of the node, their data and their stats on
+// behalf of the plugin, and maps the data of devices to Nomad attributes.
+// Nvidia GPUs are collected through NVML or CDI specs. Devices of another
+// vendor, such as Jetson (L4T) devices that NVML does not support, are served
+// by the same plugin with a collector of their own, see WithCollector.
+type Collector interface {
+	nvml.NvmlClient
+
+	// DeviceAttributes returns the attributes of the device group of the
+	// given device
+	DeviceAttributes(*nvml.FingerprintDeviceData) map[string]*structs.Attribute
+}
+
+// VendorConfig describes the devices provided by the plugin
+type VendorConfig struct {
+	// Name is the vendor of the device groups
+	Name string
+
+	// DeviceType is the type of the device groups
+	DeviceType string
+
+	// VisibleDevicesEnv is the environment variable passing the reserved
+	// devices to the container runtime
+	VisibleDevicesEnv string
+}
+
+// Vendor describes the devices provided by the plugin. Forks serving devices
+// of another vendor replace it before the plugin is served.
+var Vendor = VendorConfig{
+	Name:              "nvidia",
+	DeviceType:        device.DeviceTypeGPU,
+	VisibleDevicesEnv: NvidiaVisibleDevices,
+}
+
+// vendor returns the vendor of the device groups, Vendor with the vendor name
+// and device type overridden by the plugin configuration
+func (d *NvidiaDevice) vendor() VendorConfig {
+	vendor := Vendor
+	if d.vendorName != "" {
+		vendor.Name = d.vendorName
+	}
+	if d.deviceType != "" {
+		vendor.DeviceType = d.deviceType
+	}
+	return vendor
+}
+
+// nvidiaCollector collects Nvidia GPUs with an NVML or CDI client
+type nvidiaCollector struct {
+	nvml.NvmlClient
+}
+
+func (nvidiaCollector) DeviceAttributes(d *nvml.FingerprintDeviceData) map[string]*structs.Attribute {
+	return attributesFromFingerprintDeviceData(d)
+}
@@ -0,0 +1,113 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+	"github.com/shoenig/test/must"
+)
+
+// jetsonCollector is a collector of another vendor, mapping devices to
+// attributes of its own
+type jetsonCollector struct {
+	*MockNvmlClient
+}
+
+func (jetsonCollector) DeviceAttributes(d *nvml.FingerprintDeviceData) map[string]*structs.Attribute {
+	return map[string]*structs.Attribute{
+		"soc": {String: pointer.Of("orin")},
+	}
+}
+
+func TestWithCollector(t *testing.T) {
+	old := Vendor
+	Vendor = VendorConfig{Name: "jetson", DeviceType: "gpu", VisibleDevicesEnv: "JETSON_VISIBLE_DEVICES"}
+	t.Cleanup(func() { Vendor = old })
+
+	d := NewNvidiaDevice(context.Background(), hclog.NewNullLogger(), WithCollector(jetsonCollector{&MockNvmlClient{}}))
+	d.devices["0"] = struct{}{}
+	d.enabled = true
+
+	group := d.deviceGroupFromFingerprintData("Orin", []*nvml.FingerprintDeviceData{
+		{DeviceData: &nvml.DeviceData{UUID: "0", DeviceName: pointer.Of("Orin")}},
+	}, nil)
+	must.Eq(t, "jetson", group.Vendor)
+	must.Eq(t, map[string]*structs.Attribute{
+		"soc":            {String: pointer.Of("orin")},
+		GroupDevicesAttr: {Int: pointer.Of(int64(1))},
+	}, group.Attributes)
+
+	reservation, err := d.Reserve([]string{"0"})
+	must.NoError(t, err)
+	must.Eq(t, "0", reservation.Envs["JETSON_VISIBLE_DEVICES"])
+}
+
+func TestVendorConfig(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := newReplayDevice(t)
+	must.NoError(t, setPluginConfig(t, d, `
+config {
+  vendor      = "acme"
+  device_type = "accelerator"
+  group_names {
+    "Tesla T4" = "t4"
+  }
+}
+`))
+	fingerprints, err := d.Fingerprint(ctx)
+	must.NoError(t, err)
+	fingerprint := <-fingerprints
+	must.NoError(t, fingerprint.Error)
+	must.Len(t, 1, fingerprint.Devices)
+	must.Eq(t, "acme", fingerprint.Devices[0].Vendor)
+	must.Eq(t, "accelerator", fingerprint.Devices[0].Type)
+	must.Eq(t, "t4", fingerprint.Devices[0].Name)
+
+	// stats are reported for the same device groups
+	stats, err := d.Stats(ctx, time.Minute)
+	must.NoError(t, err)
+	response := <-stats
+	must.NoError(t, response.Error)
+	must.Len(t, 1, response.Groups)
+	must.Eq(t, "acme", response.Groups[0].Vendor)
+	must.Eq(t, "accelerator", response.Groups[0].Type)
+	must.Eq(t, "t4", response.Groups[0].Name)
+
+	err = setPluginConfig(t, newReplayDevice(t), `
+config {
+  device_type = "gpu/shared"
+}
+`)
+	must.ErrorContains(t, err, `invalid device type "gpu/shared"`)
+
+	err = setPluginConfig(t, newReplayDevice(t), `
+config {
+  group_names {
+    "Tesla T4" = ""
+  }
+}
+`)
+	must.ErrorContains(t, err, `invalid group name "" of "Tesla T4"`)
+}
+
+func TestVendorDefault(t *testing.T) {
+	d := &NvidiaDevice{}
+	must.Eq(t, Vendor, d.vendor())
+	must.Eq(t, "Tesla T4", d.groupName(pointer.Of("Tesla T4")))
+
+	d.groupNames = map[string]string{FlatGroupName: "all", "Tesla T4": "t4"}
+	must.Eq(t, "t4", d.groupName(pointer.Of("Tesla T4")))
+	must.Eq(t, notAvailable, d.groupName(nil))
+	d.flattenGroups = true
+	must.Eq(t, "all", d.groupName(pointer.Of("Tesla T4")))
+}
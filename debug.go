@@ -0,0 +1,104 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// debugReadHeaderTimeout bounds the time debug endpoint clients may take to
+// send their request headers
+const debugReadHeaderTimeout = 10 * time.Second
+
+// debugServer serves the pprof profiles and expvar variables of the plugin
+// process, so that it can be profiled without being restarted, and the GPU
+// topology for topology aware placement tooling
+type debugServer struct {
+	// listen is the configured address the server listens on
+	listen   string
+	listener net.Listener
+	server   *http.Server
+}
+
+// startDebugServer starts serving the debug endpoint on listen, which must be
+// a loopback address since profiles expose the memory of the plugin. The GPU
+// topology is served by topology.
+func startDebugServer(listen string, topology http.Handler, logger hclog.Logger) (*debugServer, error) {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, fmt.Errorf("invalid debug listen address %q: %v", listen, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("debug listen address %q must be a loopback address, such as 127.0.0.1:6060", listen)
+	}
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on debug address %q: %v", listen, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/debug/topology", topology)
+
+	s := &debugServer{
+		listen:   listen,
+		listener: listener,
+		server:   &http.Server{Handler: mux, ReadHeaderTimeout: debugReadHeaderTimeout},
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("debug endpoint stopped", "address", listen, "error", err)
+		}
+	}()
+	logger.Info("serving pprof, expvar and topology debug endpoint", "address", listener.Addr().String())
+	return s, nil
+}
+
+// addr returns the address the server listens on
+func (s *debugServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+// close stops the server
+func (s *debugServer) close() error {
+	return s.server.Close()
+}
+
+// setDebugListen starts the debug endpoint on listen, replacing the running
+// one if it listens on another address. An empty address stops the endpoint.
+func (d *NvidiaDevice) setDebugListen(listen string) error {
+	if d.debugServer != nil {
+		if d.debugServer.listen == listen {
+			return nil
+		}
+		if err := d.debugServer.close(); err != nil {
+			d.logger.Warn("failed to stop debug endpoint", "address", d.debugServer.listen, "error", err)
+		}
+		d.debugServer = nil
+	}
+	if listen == "" {
+		return nil
+	}
+
+	server, err := startDebugServer(listen, http.HandlerFunc(d.serveTopology), d.logger)
+	if err != nil {
+		return err
+	}
+	d.debugServer = server
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/shoenig/test/must"
+)
+
+func TestSetDebugListen(t *testing.T) {
+	d := &NvidiaDevice{logger: hclog.NewNullLogger()}
+	t.Cleanup(func() { d.setDebugListen("") })
+
+	must.NoError(t, d.setDebugListen("127.0.0.1:0"))
+	must.NotNil(t, d.debugServer)
+	server := d.debugServer
+
+	for _, path := range []string{"/debug/pprof/", "/debug/vars"} {
+		resp, err := http.Get("http://" + server.addr() + path)
+		must.NoError(t, err)
+		resp.Body.Close()
+		must.Eq(t, http.StatusOK, resp.StatusCode, must.Sprint(path))
+	}
+
+	// the topology is served once devices are fingerprinted
+	resp, err := http.Get("http://" + server.addr() + "/debug/topology")
+	must.NoError(t, err)
+	resp.Body.Close()
+	must.Eq(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// the running endpoint is kept when the address does not change
+	must.NoError(t, d.setDebugListen("127.0.0.1:0"))
+	must.Eq(t, server, d.debugServer)
+
+	must.NoError(t, d.setDebugListen(""))
+	must.Nil(t, d.debugServer)
+	_, err = http.Get("http://" + server.addr() + "/debug/vars")
+	must.Error(t, err)
+}
+
+func TestSetDebugListenInvalid(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Listen string
+		Error  string
+	}{
+		{
+			Name:   "all interfaces",
+			Listen: ":6060",
+			Error:  "must be a loopback address",
+		},
+		{
+			Name:   "public address",
+			Listen: "10.0.0.1:6060",
+			Error:  "must be a loopback address",
+		},
+		{
+			Name:   "no port",
+			Listen: "127.0.0.1",
+			Error:  "invalid debug listen address",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			d := &NvidiaDevice{logger: hclog.NewNullLogger()}
+			must.ErrorContains(t, d.setDebugListen(c.Listen), c.Error)
+			must.Nil(t, d.debugServer)
+		})
+	}
+}
@@ -5,9 +5,12 @@ package nvidia
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
@@ -17,24 +20,33 @@ import (
 	"github.com/hashicorp/nomad/plugins/base"
 	"github.com/hashicorp/nomad/plugins/device"
 	"github.com/hashicorp/nomad/plugins/shared/hclspec"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
 )
 
 const (
 	// pluginName is the name of the plugin
 	pluginName = "nvidia-gpu"
 
-	// vendor is the vendor providing the devices
-	vendor = "nvidia"
-
-	// deviceType is the type of device being returned
-	deviceType = device.DeviceTypeGPU
-
 	// notAvailable value is returned to nomad server in case some properties were
 	// undetected by nvml driver
 	notAvailable = "N/A"
 
 	// Nvidia-container-runtime environment variable names
 	NvidiaVisibleDevices = "NVIDIA_VISIBLE_DEVICES"
+
+	// GPU reset modes applied to devices before they are reserved
+	gpuResetNone   = "none"
+	gpuResetClocks = "clocks"
+	gpuResetFull   = "full"
+
+	// Actions taken on compute processes left over on a device being reserved
+	leftoverProcessesLog       = "log"
+	leftoverProcessesUnhealthy = "unhealthy"
+	leftoverProcessesKill      = "kill"
+
+	// defaultStatsBuffer is the number of stats responses buffered by the
+	// stats channel unless set with WithStatsBuffer
+	defaultStatsBuffer = 4
 )
 
 var (
@@ -54,7 +66,7 @@ var (
 	// pluginInfo describes the plugin
 	pluginInfo = &base.PluginInfoResponse{
 		Type:              base.PluginTypeDevice,
-		PluginApiVersions: []string{device.ApiVersion010},
+		PluginApiVersions: supportedAPIVersions,
 		PluginVersion:     version.Version,
 		Name:              pluginName,
 	}
@@ -65,63 +77,744 @@ var (
 			hclspec.NewAttr("enabled", "bool", false),
 			hclspec.NewLiteral("true"),
 		),
+		"nvml_library_path": hclspec.NewDefault(
+			hclspec.NewAttr("nvml_library_path", "string", false),
+			hclspec.NewLiteral("\"\""),
+		),
+		"isolate_nvml": hclspec.NewDefault(
+			hclspec.NewAttr("isolate_nvml", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
+		"cdi_spec_dir": hclspec.NewDefault(
+			hclspec.NewAttr("cdi_spec_dir", "string", false),
+			hclspec.NewLiteral("\"\""),
+		),
+		"preflight_check": hclspec.NewDefault(
+			hclspec.NewAttr("preflight_check", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
+		"device_presence_check": hclspec.NewDefault(
+			hclspec.NewAttr("device_presence_check", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
+		"persistence_mode_warning": hclspec.NewDefault(
+			hclspec.NewAttr("persistence_mode_warning", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
 		"ignored_gpu_ids": hclspec.NewDefault(
 			hclspec.NewAttr("ignored_gpu_ids", "list(string)", false),
 			hclspec.NewLiteral("[]"),
 		),
+		"performance_scores": hclspec.NewBlockAttrs("performance_scores", "number", false),
+		"vendor": hclspec.NewDefault(
+			hclspec.NewAttr("vendor", "string", false),
+			hclspec.NewLiteral("\"\""),
+		),
+		"device_type": hclspec.NewDefault(
+			hclspec.NewAttr("device_type", "string", false),
+			hclspec.NewLiteral("\"\""),
+		),
+		"group_names": hclspec.NewBlockAttrs("group_names", "string", false),
+		"redact_attributes": hclspec.NewDefault(
+			hclspec.NewAttr("redact_attributes", "list(string)", false),
+			hclspec.NewLiteral("[]"),
+		),
+		"maintenance_gpu_ids": hclspec.NewDefault(
+			hclspec.NewAttr("maintenance_gpu_ids", "list(string)", false),
+			hclspec.NewLiteral("[]"),
+		),
+		"maintenance_file": hclspec.NewDefault(
+			hclspec.NewAttr("maintenance_file", "string", false),
+			hclspec.NewLiteral("\"\""),
+		),
+		"ignore_display_gpus": hclspec.NewDefault(
+			hclspec.NewAttr("ignore_display_gpus", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
+		"include_mig_parents": hclspec.NewDefault(
+			hclspec.NewAttr("include_mig_parents", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
+		"flatten_groups": hclspec.NewDefault(
+			hclspec.NewAttr("flatten_groups", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
 		"fingerprint_period": hclspec.NewDefault(
 			hclspec.NewAttr("fingerprint_period", "string", false),
 			hclspec.NewLiteral("\"1m\""),
 		),
+		"aggregate_stats": hclspec.NewDefault(
+			hclspec.NewAttr("aggregate_stats", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
+		"diagnostic_stats": hclspec.NewDefault(
+			hclspec.NewAttr("diagnostic_stats", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
+		"utilization_sampling": hclspec.NewDefault(
+			hclspec.NewAttr("utilization_sampling", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
+		"gpm_metrics": hclspec.NewDefault(
+			hclspec.NewAttr("gpm_metrics", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
+		"foreign_process_stats": hclspec.NewDefault(
+			hclspec.NewAttr("foreign_process_stats", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
+		"foreign_process_warning": hclspec.NewDefault(
+			hclspec.NewAttr("foreign_process_warning", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
+		"pcie_error_stats": hclspec.NewDefault(
+			hclspec.NewAttr("pcie_error_stats", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
+		"power_unit": hclspec.NewDefault(
+			hclspec.NewAttr("power_unit", "string", false),
+			hclspec.NewLiteral("\"W\""),
+		),
+		"error_log_interval": hclspec.NewDefault(
+			hclspec.NewAttr("error_log_interval", "string", false),
+			hclspec.NewLiteral("\"5m\""),
+		),
+		"bar1_degraded_threshold": hclspec.NewDefault(
+			hclspec.NewAttr("bar1_degraded_threshold", "number", false),
+			hclspec.NewLiteral("0"),
+		),
+		"unhealthy_samples": hclspec.NewDefault(
+			hclspec.NewAttr("unhealthy_samples", "number", false),
+			hclspec.NewLiteral("1"),
+		),
+		"healthy_samples": hclspec.NewDefault(
+			hclspec.NewAttr("healthy_samples", "number", false),
+			hclspec.NewLiteral("1"),
+		),
+		"circuit_breaker_threshold": hclspec.NewDefault(
+			hclspec.NewAttr("circuit_breaker_threshold", "number", false),
+			hclspec.NewLiteral("3"),
+		),
+		"circuit_breaker_cooldown": hclspec.NewDefault(
+			hclspec.NewAttr("circuit_breaker_cooldown", "string", false),
+			hclspec.NewLiteral("\"1m\""),
+		),
+		"quarantine_threshold": hclspec.NewDefault(
+			hclspec.NewAttr("quarantine_threshold", "number", false),
+			hclspec.NewLiteral("3"),
+		),
+		"quarantine_window": hclspec.NewDefault(
+			hclspec.NewAttr("quarantine_window", "string", false),
+			hclspec.NewLiteral("\"10m\""),
+		),
+		"gpu_reset": hclspec.NewDefault(
+			hclspec.NewAttr("gpu_reset", "string", false),
+			hclspec.NewLiteral("\"none\""),
+		),
+		"leftover_processes": hclspec.NewDefault(
+			hclspec.NewAttr("leftover_processes", "string", false),
+			hclspec.NewLiteral("\"log\""),
+		),
+		"isolation_check": hclspec.NewDefault(
+			hclspec.NewAttr("isolation_check", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
+		"accounting": hclspec.NewDefault(
+			hclspec.NewAttr("accounting", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
+		"energy_accounting": hclspec.NewDefault(
+			hclspec.NewAttr("energy_accounting", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
+		"idle_reserved_window": hclspec.NewDefault(
+			hclspec.NewAttr("idle_reserved_window", "string", false),
+			hclspec.NewLiteral("\"0\""),
+		),
+		"health_status_file": hclspec.NewDefault(
+			hclspec.NewAttr("health_status_file", "string", false),
+			hclspec.NewLiteral("\"\""),
+		),
+		"stats_snapshot_file": hclspec.NewDefault(
+			hclspec.NewAttr("stats_snapshot_file", "string", false),
+			hclspec.NewLiteral("\"\""),
+		),
+		"health_state_file": hclspec.NewDefault(
+			hclspec.NewAttr("health_state_file", "string", false),
+			hclspec.NewLiteral("\"\""),
+		),
+		"topology_file": hclspec.NewDefault(
+			hclspec.NewAttr("topology_file", "string", false),
+			hclspec.NewLiteral("\"\""),
+		),
+		"stats_warmup_timeout": hclspec.NewDefault(
+			hclspec.NewAttr("stats_warmup_timeout", "string", false),
+			hclspec.NewLiteral("\"10s\""),
+		),
+		"vgpu_license_warning": hclspec.NewDefault(
+			hclspec.NewAttr("vgpu_license_warning", "string", false),
+			hclspec.NewLiteral("\"168h\""),
+		),
+		"debug_listen": hclspec.NewDefault(
+			hclspec.NewAttr("debug_listen", "string", false),
+			hclspec.NewLiteral("\"\""),
+		),
+		"fatal_error_action": hclspec.NewBlock("fatal_error_action", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"action": hclspec.NewDefault(
+				hclspec.NewAttr("action", "string", false),
+				hclspec.NewLiteral("\"unhealthy\""),
+			),
+			"command":     hclspec.NewAttr("command", "string", false),
+			"args":        hclspec.NewAttr("args", "list(string)", false),
+			"webhook_url": hclspec.NewAttr("webhook_url", "string", false),
+			"retries": hclspec.NewDefault(
+				hclspec.NewAttr("retries", "number", false),
+				hclspec.NewLiteral("3"),
+			),
+			"retry_interval": hclspec.NewDefault(
+				hclspec.NewAttr("retry_interval", "string", false),
+				hclspec.NewLiteral("\"10s\""),
+			),
+			"timeout": hclspec.NewDefault(
+				hclspec.NewAttr("timeout", "string", false),
+				hclspec.NewLiteral("\"30s\""),
+			),
+		})),
+		"reservation": hclspec.NewBlock("reservation", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"mode": hclspec.NewDefault(
+				hclspec.NewAttr("mode", "string", false),
+				hclspec.NewLiteral("\"env\""),
+			),
+			"uid": hclspec.NewDefault(
+				hclspec.NewAttr("uid", "number", false),
+				hclspec.NewLiteral("-1"),
+			),
+			"gid": hclspec.NewDefault(
+				hclspec.NewAttr("gid", "number", false),
+				hclspec.NewLiteral("-1"),
+			),
+			"min_compute_capability": hclspec.NewDefault(
+				hclspec.NewAttr("min_compute_capability", "string", false),
+				hclspec.NewLiteral("\"\""),
+			),
+			"min_cuda_version": hclspec.NewDefault(
+				hclspec.NewAttr("min_cuda_version", "string", false),
+				hclspec.NewLiteral("\"\""),
+			),
+		})),
+		"notifications": hclspec.NewBlock("notifications", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"webhook_url": hclspec.NewAttr("webhook_url", "string", false),
+			"timeout": hclspec.NewDefault(
+				hclspec.NewAttr("timeout", "string", false),
+				hclspec.NewLiteral("\"10s\""),
+			),
+		})),
+		"audit": hclspec.NewBlock("audit", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"file":     hclspec.NewAttr("file", "string", false),
+			"key_file": hclspec.NewAttr("key_file", "string", false),
+			"interval": hclspec.NewDefault(
+				hclspec.NewAttr("interval", "string", false),
+				hclspec.NewLiteral("\"5m\""),
+			),
+		})),
+		"stats": hclspec.NewBlock("stats", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"enabled_metrics":  hclspec.NewAttr("enabled_metrics", "list(string)", false),
+			"temperature_unit": hclspec.NewAttr("temperature_unit", "string", false),
+			"ecc_counters":     hclspec.NewAttr("ecc_counters", "string", false),
+			"summary_metric":   hclspec.NewAttr("summary_metric", "string", false),
+			"metric_keys":      hclspec.NewAttr("metric_keys", "bool", false),
+			"sample_interval":  hclspec.NewAttr("sample_interval", "string", false),
+			"scale": hclspec.NewBlockList("scale", hclspec.NewObject(map[string]*hclspec.Spec{
+				"metric": hclspec.NewAttr("metric", "string", true),
+				"factor": hclspec.NewAttr("factor", "number", true),
+				"unit":   hclspec.NewAttr("unit", "string", false),
+			})),
+		})),
 	})
 )
 
 // Config contains configuration information for the plugin.
 type Config struct {
-	Enabled           bool     `codec:"enabled"`
-	IgnoredGPUIDs     []string `codec:"ignored_gpu_ids"`
-	FingerprintPeriod string   `codec:"fingerprint_period"`
+	Enabled                 bool                   `codec:"enabled"`
+	NVMLLibraryPath         string                 `codec:"nvml_library_path"`
+	IsolateNVML             bool                   `codec:"isolate_nvml"`
+	CDISpecDir              string                 `codec:"cdi_spec_dir"`
+	IgnoredGPUIDs           []string               `codec:"ignored_gpu_ids"`
+	IgnoreDisplayGPUs       bool                   `codec:"ignore_display_gpus"`
+	IncludeMIGParents       bool                   `codec:"include_mig_parents"`
+	MaintenanceGPUIDs       []string               `codec:"maintenance_gpu_ids"`
+	MaintenanceFile         string                 `codec:"maintenance_file"`
+	PreflightCheck          bool                   `codec:"preflight_check"`
+	DevicePresenceCheck     bool                   `codec:"device_presence_check"`
+	PersistenceModeWarning  bool                   `codec:"persistence_mode_warning"`
+	FingerprintPeriod       string                 `codec:"fingerprint_period"`
+	FlattenGroups           bool                   `codec:"flatten_groups"`
+	AggregateStats          bool                   `codec:"aggregate_stats"`
+	DiagnosticStats         bool                   `codec:"diagnostic_stats"`
+	PowerUnit               string                 `codec:"power_unit"`
+	ErrorLogInterval        string                 `codec:"error_log_interval"`
+	CircuitBreakerThreshold int                    `codec:"circuit_breaker_threshold"`
+	BAR1DegradedThreshold   int                    `codec:"bar1_degraded_threshold"`
+	PerformanceScores       map[string]int64       `codec:"performance_scores"`
+	Vendor                  string                 `codec:"vendor"`
+	DeviceType              string                 `codec:"device_type"`
+	GroupNames              map[string]string      `codec:"group_names"`
+	RedactAttributes        []string               `codec:"redact_attributes"`
+	UnhealthySamples        int                    `codec:"unhealthy_samples"`
+	HealthySamples          int                    `codec:"healthy_samples"`
+	CircuitBreakerCooldown  string                 `codec:"circuit_breaker_cooldown"`
+	QuarantineThreshold     int                    `codec:"quarantine_threshold"`
+	QuarantineWindow        string                 `codec:"quarantine_window"`
+	GPUReset                string                 `codec:"gpu_reset"`
+	LeftoverProcesses       string                 `codec:"leftover_processes"`
+	IsolationCheck          bool                   `codec:"isolation_check"`
+	Accounting              bool                   `codec:"accounting"`
+	EnergyAccounting        bool                   `codec:"energy_accounting"`
+	IdleReservedWindow      string                 `codec:"idle_reserved_window"`
+	HealthStatusFile        string                 `codec:"health_status_file"`
+	HealthStateFile         string                 `codec:"health_state_file"`
+	StatsSnapshotFile       string                 `codec:"stats_snapshot_file"`
+	TopologyFile            string                 `codec:"topology_file"`
+	UtilizationSampling     bool                   `codec:"utilization_sampling"`
+	GPMMetrics              bool                   `codec:"gpm_metrics"`
+	ForeignProcessStats     bool                   `codec:"foreign_process_stats"`
+	ForeignProcessWarning   bool                   `codec:"foreign_process_warning"`
+	PCIeErrorStats          bool                   `codec:"pcie_error_stats"`
+	StatsWarmupTimeout      string                 `codec:"stats_warmup_timeout"`
+	VGPULicenseWarning      string                 `codec:"vgpu_license_warning"`
+	DebugListen             string                 `codec:"debug_listen"`
+	FatalErrorAction        FatalErrorActionConfig `codec:"fatal_error_action"`
+	Reservation             ReservationConfig      `codec:"reservation"`
+	Notifications           NotificationsConfig    `codec:"notifications"`
+	Audit                   AuditConfig            `codec:"audit"`
+	Stats                   StatsConfig            `codec:"stats"`
+}
+
+// StatsConfig contains the configuration of emitted stats
+type StatsConfig struct {
+	// EnabledMetrics lists the metrics emitted for every device, all metrics
+	// are emitted when empty
+	EnabledMetrics []string `codec:"enabled_metrics"`
+
+	// TemperatureUnit is the unit of temperature values, either "C" (the
+	// default) or "F"
+	TemperatureUnit string `codec:"temperature_unit"`
+
+	// ECCCounters selects the ECC error counters to emit, either "volatile"
+	// (the default), "aggregate" or "both"
+	ECCCounters string `codec:"ecc_counters"`
+
+	// SummaryMetric is the metric reported as the summary of every device,
+	// "memory_state" by default
+	SummaryMetric string `codec:"summary_metric"`
+
+	// MetricKeys replaces the descriptions of stats values with stable
+	// metric keys, such as "gpu_utilization", for exporters parsing them
+	MetricKeys bool `codec:"metric_keys"`
+
+	// SampleInterval is the period at which GPU utilization, power usage and
+	// temperature are sampled between stats collections to report their
+	// percentiles, sampling is disabled when empty
+	SampleInterval string `codec:"sample_interval"`
+
+	// Scale lists factors applied to metric values before they are emitted
+	Scale []StatsScaleConfig `codec:"scale"`
+}
+
+// StatsScaleConfig scales the values of a metric
+type StatsScaleConfig struct {
+	Metric string  `codec:"metric"`
+	Factor float64 `codec:"factor"`
+	Unit   string  `codec:"unit"`
 }
 
 // NvidiaDevice contains all plugin specific data
 type NvidiaDevice struct {
+	// ctx is the context of the plugin, done once it is shut down. It bounds
+	// the work outliving a fingerprint or stats call, such as fatal error
+	// actions.
+	ctx context.Context
+
 	// enabled indicates whether the plugin should be enabled
 	enabled bool
 
-	// nvmlClient is used to get data from nvidia
-	nvmlClient nvml.NvmlClient
+	// collector is used to get data from the devices
+	collector Collector
 
 	// initErr holds an error retrieved during
-	// nvmlClient initialization
+	// collector initialization
 	initErr error
 
 	// ignoredGPUIDs is a set of UUIDs that would not be exposed to nomad
 	ignoredGPUIDs map[string]struct{}
 
+	// ignoreDisplayGPUs indicates whether devices with a display attached
+	// are excluded from fingerprint output
+	ignoreDisplayGPUs bool
+
+	// includeMIGParents indicates whether MIG enabled physical GPUs are
+	// advertised as unhealthy informational devices
+	includeMIGParents bool
+
 	// fingerprintPeriod is how often we should call nvml to get list of devices
 	fingerprintPeriod time.Duration
 
+	// flattenGroups indicates whether all devices are advertised in a single
+	// device group instead of a group per model
+	flattenGroups bool
+
 	// devices is the set of detected eligible devices
 	devices    map[string]struct{}
 	deviceLock sync.RWMutex
 
+	// displayGPUs is the set of devices excluded because a display is
+	// attached to them. It is guarded by deviceLock
+	displayGPUs map[string]struct{}
+
+	// migParents maps the UUIDs of MIG devices to the UUID of their physical
+	// GPU. It is guarded by deviceLock
+	migParents map[string]string
+
+	// migParentGPUs is the set of MIG enabled physical GPUs, which are not
+	// allocatable and have no stats by design. It is guarded by deviceLock
+	migParentGPUs map[string]struct{}
+
+	// pciBusIDs maps the UUIDs of devices to their PCI bus ID. It is guarded
+	// by deviceLock
+	pciBusIDs map[string]string
+
+	// topology is the topology of the GPUs at the latest fingerprint, nil
+	// before the first one. It is guarded by deviceLock
+	topology *topology
+
+	// unhealthy holds the devices marked unhealthy, keyed by UUID. It is
+	// guarded by deviceLock
+	unhealthy map[string]*deviceHealth
+
+	// unhealthySamples and healthySamples are the numbers of consecutive
+	// samples a device must fail or pass a health check for before it is
+	// marked unhealthy or healthy again. healthStreaks counts the consecutive
+	// samples that disagree with the current health of each device and
+	// check, it is guarded by deviceLock.
+	unhealthySamples int
+	healthySamples   int
+	healthStreaks    map[healthStreakKey]int
+
+	// fingerprintLock serializes the fingerprints of concurrent streams, and
+	// guards the state they share such as noDevices and sriovVFs
+	fingerprintLock sync.Mutex
+
+	// noDevices is set while NVML reports no devices at all. It is guarded by
+	// fingerprintLock
+	noDevices bool
+
+	// sriovVFs holds the number of SR-IOV virtual functions enabled on each
+	// physical GPU at the last fingerprint, keyed by PCI bus ID. It is
+	// guarded by fingerprintLock
+	sriovVFs map[string]uint
+
+	// checkedDriverVersion is the last driver version compared against
+	// minimumDriverVersion
+	checkedDriverVersion string
+
+	// maintenanceGPUIDs and the UUIDs listed in maintenanceFile are devices
+	// in maintenance, which are reported unhealthy so that they are not
+	// scheduled. The file is read on every fingerprint.
+	maintenanceGPUIDs map[string]struct{}
+	maintenanceFile   string
+
+	// preflightCheck indicates whether devices are checked before they are
+	// first advertised, preflightResults holds the result for every checked
+	// device
+	preflightCheck   bool
+	preflightResults map[string]error
+
+	// devicePresenceCheck indicates whether the devices reported by NVML are
+	// compared with the GPUs of the kernel driver on every fingerprint, and
+	// lastPresenceMismatch holds the result of the last comparison
+	devicePresenceCheck  bool
+	lastPresenceMismatch *presenceMismatch
+
+	// persistenceModeWarning indicates whether datacenter GPUs running with
+	// persistence mode disabled are reported with a health warning, and
+	// persistenceWarnings holds these devices. It is guarded by deviceLock
+	persistenceModeWarning bool
+	persistenceWarnings    map[string]struct{}
+
+	// vgpuLicenseWarning is how long before their license expires vGPUs are
+	// reported with a health warning, and licenseWarnings holds the warning
+	// of each vGPU whose license needs attention. It is guarded by deviceLock
+	vgpuLicenseWarning time.Duration
+	licenseWarnings    map[string]string
+
+	// toolkit describes the Nvidia container toolkit detected when
+	// fingerprinting started. It is guarded by fingerprintLock
+	toolkit *containerToolkit
+
+	// deviceAttributes holds the attributes of every device seen during the
+	// last fingerprint run and is used to detect attribute drift
+	deviceAttributes map[string]map[string]*structs.Attribute
+
+	// aggregateStats indicates whether a node level aggregate stats group
+	// should be emitted in addition to the per device stats
+	aggregateStats bool
+
+	// diagnosticStats indicates whether a diagnostics stats group reporting
+	// the duration of each stats collection should be emitted
+	diagnosticStats bool
+
+	// foreignProcessStats indicates whether the compute processes running
+	// outside of Nomad allocations are counted in the stats of every device,
+	// and foreignProcessWarning whether they are logged
+	foreignProcessStats   bool
+	foreignProcessWarning bool
+
+	// pcieErrorStats indicates whether the PCIe error counters of the kernel
+	// AER driver are added to the stats of every device
+	pcieErrorStats bool
+
+	// performanceScores overrides the default performance scores of device
+	// groups, keyed by group name
+	performanceScores map[string]int64
+
+	// vendorName and deviceType override the vendor and type of the device
+	// groups set in Vendor, and groupNames the names of the device groups,
+	// keyed by the name they replace. They are unset unless configured.
+	vendorName string
+	deviceType string
+	groupNames map[string]string
+
+	// redactedAttributes is the set of attributes left out of fingerprint
+	// responses, which may hold redactPCIBusID to leave out the PCI bus IDs
+	// of devices. They are still used by the plugin.
+	redactedAttributes map[string]struct{}
+
+	// bar1DegradedThreshold is the percentage of BAR1 memory in use at which
+	// a device is marked unhealthy, zero when disabled
+	bar1DegradedThreshold int
+
+	// statsSnapshotFile is the path of the file the stats of every collection
+	// are written to, empty when disabled
+	statsSnapshotFile string
+
+	// topologyFile is the path of the file the topology of the GPUs is
+	// written to on every fingerprint, empty when disabled
+	topologyFile string
+
+	// debugServer serves pprof profiles and expvar variables, nil when
+	// debug_listen is not set
+	debugServer *debugServer
+
+	// lastForeignProcesses holds the foreign processes of every device found
+	// by the last stats collection. It is only accessed by the stats goroutine
+	lastForeignProcesses map[string][]int
+
+	// lastPCIeErrors holds the uncorrectable PCIe errors of every device
+	// found by the last stats collection. It is only accessed by the stats
+	// goroutine
+	lastPCIeErrors map[string]uint64
+
+	// statsOptions controls how stats values are reported
+	statsOptions statsOptions
+
+	// statsWarmupTimeout is how long stats wait for the first fingerprint
+	// before being emitted
+	statsWarmupTimeout time.Duration
+
+	// statsSampleInterval is the period at which stats are sampled between
+	// collections, zero when disabled
+	statsSampleInterval time.Duration
+
+	// fingerprinted is closed once the first fingerprint completed, it is
+	// accessed through firstFingerprint
+	fingerprinted     chan struct{}
+	fingerprintedOnce sync.Once
+	fingerprintedMark sync.Once
+
+	// errorLog coalesces repeated NVML errors in logs
+	errorLog *errorLogLimiter
+
+	// gpuReset is the reset applied to devices before they are reserved
+	gpuReset string
+
+	// leftoverProcesses is the action taken on compute processes found on
+	// devices being reserved
+	leftoverProcesses string
+
+	// accounting indicates whether NVML accounting is used to log usage
+	// summaries of reservations
+	accounting bool
+
+	// energyAccounting indicates whether the energy consumed by reservations
+	// is logged when they are released and emitted as a stat
+	energyAccounting bool
+
+	// fatalErrorAction is run when a device enters a fatal state
+	fatalErrorAction *fatalErrorAction
+
+	// notifier sends health transition events, nil when not configured
+	notifier *healthNotifier
+
+	// audit appends signed records of the fingerprint and stats to the audit
+	// file, nil when not configured
+	audit *auditLog
+
+	// healthStatusFile is the path of the file the health of every device
+	// is written to, empty when disabled
+	healthStatusFile string
+
+	// healthStateFile is the path of the file persisting the devices marked
+	// unhealthy across plugin restarts, empty when disabled. healthStateLock
+	// serializes its writes.
+	healthStateFile string
+	healthStateLock sync.Mutex
+
+	// reservedAt holds when accounting started for each reserved device
+	reservedAt     map[string]time.Time
+	accountingLock sync.Mutex
+
+	// energyReservations holds the reservation of each device whose energy
+	// consumption is attributed
+	energyReservations map[string]*energyReservation
+	energyLock         sync.Mutex
+
+	// idleReservedWindow is how long reserved devices must be idle for the
+	// time they have been idle to be emitted as a stat, zero disables idle
+	// tracking. idleReservations holds the tracking of each reserved device.
+	idleReservedWindow time.Duration
+	idleReservations   map[string]*idleReservation
+	idleLock           sync.Mutex
+
+	// isolationCheck indicates whether the devices cgroup of tasks is checked
+	// to only allow their reserved GPUs, and isolationChecks holds the
+	// reservation of each device whose check is pending
+	isolationCheck  bool
+	isolationChecks map[string]*isolationCheck
+	isolationLock   sync.Mutex
+
+	// reservation configures what reservations hand to the task driver
+	reservation ReservationConfig
+
+	// computeCapabilities holds the CUDA compute capability of each
+	// fingerprinted device, and cudaDriverVersion the highest CUDA version
+	// supported by the driver, which reservations are checked against
+	computeCapabilities map[string]string
+	cudaDriverVersion   string
+
+	// cdiSpecDir is the directory of the CDI specs devices are fingerprinted
+	// from, empty when fingerprinting from NVML
+	cdiSpecDir string
+
+	// clock tells the current time, the system clock when nil
+	clock Clock
+
+	// statsBuffer is the buffer size of the stats channel
+	statsBuffer int
+
+	// statsDropped counts the stats responses dropped because the stats
+	// channel was full
+	statsDropped atomic.Uint64
+
+	// started is set once Fingerprint or Stats was called. The fingerprint
+	// and stats goroutines read the configuration without locking, so it can
+	// no longer be changed from then on.
+	started atomic.Bool
+
 	logger hclog.Logger
 }
 
-// NewNvidiaDevice returns a new nvidia device plugin.
-func NewNvidiaDevice(_ context.Context, log hclog.Logger) *NvidiaDevice {
-	nvmlClient, err := nvml.NewNvmlClient()
-	logger := log.Named(pluginName)
-	if err != nil && err.Error() != nvml.UnavailableLib.Error() {
-		logger.Error("unable to initialize Nvidia driver", "reason", err)
+// Option configures the device plugin returned by NewNvidiaDevice
+type Option func(*NvidiaDevice)
+
+// WithNvmlClient makes the plugin get data from the given NVML client instead
+// of loading the default NVML library. The nvml_library_path, isolate_nvml
+// and cdi_spec_dir options replace the client when set.
+func WithNvmlClient(client nvml.NvmlClient) Option {
+	return func(d *NvidiaDevice) {
+		d.collector = nvidiaCollector{client}
+	}
+}
+
+// WithCollector makes the plugin get data from the given collector instead of
+// NVML, for devices NVML does not support. The nvml_library_path,
+// isolate_nvml and cdi_spec_dir options replace the collector when set.
+func WithCollector(collector Collector) Option {
+	return func(d *NvidiaDevice) {
+		d.collector = collector
+	}
+}
+
+// WithClock makes the plugin tell the current time with the given clock
+// instead of the system clock
+func WithClock(clock Clock) Option {
+	return func(d *NvidiaDevice) {
+		d.clock = clock
+	}
+}
+
+// WithStatsBuffer sets the number of stats responses buffered by the channel
+// returned by Stats, 4 by default. Responses are dropped rather than delaying
+// collection when a slow reader lets the buffer fill up.
+func WithStatsBuffer(size int) Option {
+	return func(d *NvidiaDevice) {
+		d.statsBuffer = size
 	}
-	return &NvidiaDevice{
-		logger:        logger,
+}
+
+// NewNvidiaDevice returns a new nvidia device plugin. Unless an NVML client
+// or a collector is given, the NVML library is loaded by SetConfig, once it
+// is known which library to load and whether to isolate it.
+func NewNvidiaDevice(ctx context.Context, log hclog.Logger, opts ...Option) *NvidiaDevice {
+	d := &NvidiaDevice{
+		ctx:           ctx,
+		logger:        log.Named(pluginName),
 		devices:       make(map[string]struct{}),
 		ignoredGPUIDs: make(map[string]struct{}),
-		nvmlClient:    nvmlClient,
-		initErr:       err,
 	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// loadNVMLLibrary replaces the NVML client with one using the NVML library
+// at the given path, or the default library when empty, running in a
+// separate worker process when isolated is set. A missing default library is
+// not an error, the node simply has no Nvidia driver.
+func (d *NvidiaDevice) loadNVMLLibrary(path string, isolated bool) {
+	if d.initErr == nil && d.collector != nil {
+		if err := d.collector.Shutdown(); err != nil {
+			d.logger.Warn("failed to shutdown default NVML library", "error", err)
+		}
+	}
+
+	var nvmlClient nvml.NvmlClient
+	var err error
+	if isolated {
+		nvmlClient, err = nvml.NewIsolatedNvmlClient(path, d.logger)
+	} else {
+		nvmlClient, err = nvml.NewNvmlClient(path, d.logger.Named("nvml"))
+	}
+	if err != nil && (path != "" || isolated || !nvml.IsPermanent(err)) {
+		d.logger.Error("unable to initialize Nvidia driver", "reason", err, "nvml_library_path", path, "isolate_nvml", isolated)
+	}
+	d.collector = nvidiaCollector{nvmlClient}
+	d.initErr = err
+}
+
+// useCDISpecs replaces the NVML client with one fingerprinting the devices
+// described by the CDI spec files in specDir
+func (d *NvidiaDevice) useCDISpecs(specDir string) {
+	if d.initErr == nil && d.collector != nil {
+		if err := d.collector.Shutdown(); err != nil {
+			d.logger.Warn("failed to shutdown default NVML library", "error", err)
+		}
+	}
+
+	d.logger.Info("fingerprinting devices from CDI specs instead of NVML", "cdi_spec_dir", specDir)
+	d.cdiSpecDir = specDir
+	d.collector = nvidiaCollector{newCDIClient(specDir)}
+	d.initErr = nil
 }
 
 // PluginInfo returns information describing the plugin.
@@ -136,6 +829,10 @@ func (d *NvidiaDevice) ConfigSchema() (*hclspec.Spec, error) {
 
 // SetConfig is used to set the configuration of the plugin.
 func (d *NvidiaDevice) SetConfig(cfg *base.Config) error {
+	if d.started.Load() {
+		return fmt.Errorf("plugin configuration can not be changed once fingerprinting or stats started")
+	}
+
 	var config Config
 	if len(cfg.PluginConfig) != 0 {
 		if err := base.MsgPackDecode(cfg.PluginConfig, &config); err != nil {
@@ -143,8 +840,66 @@ func (d *NvidiaDevice) SetConfig(cfg *base.Config) error {
 		}
 	}
 
+	apiVersion, err := negotiatedAPIVersion(cfg.ApiVersion)
+	if err != nil {
+		return err
+	}
+	d.logger.Debug("serving device plugin API", "version", apiVersion)
+
 	d.enabled = config.Enabled
 
+	switch {
+	case config.CDISpecDir != "":
+		d.useCDISpecs(config.CDISpecDir)
+	case config.NVMLLibraryPath != "" || config.IsolateNVML:
+		d.loadNVMLLibrary(config.NVMLLibraryPath, config.IsolateNVML)
+	case d.collector == nil:
+		d.loadNVMLLibrary("", false)
+	}
+	d.aggregateStats = config.AggregateStats
+	d.diagnosticStats = config.DiagnosticStats
+	d.foreignProcessStats = config.ForeignProcessStats
+	d.statsSnapshotFile = config.StatsSnapshotFile
+	d.topologyFile = config.TopologyFile
+	d.foreignProcessWarning = config.ForeignProcessWarning
+	d.pcieErrorStats = config.PCIeErrorStats
+	d.accounting = config.Accounting
+	d.energyAccounting = config.EnergyAccounting
+	d.isolationCheck = config.IsolationCheck
+	d.warnNoDevicesCgroup()
+	d.ignoreDisplayGPUs = config.IgnoreDisplayGPUs
+	d.includeMIGParents = config.IncludeMIGParents
+	d.flattenGroups = config.FlattenGroups
+	d.preflightCheck = config.PreflightCheck
+	d.devicePresenceCheck = config.DevicePresenceCheck
+	d.persistenceModeWarning = config.PersistenceModeWarning
+	d.maintenanceFile = config.MaintenanceFile
+	d.maintenanceGPUIDs = make(map[string]struct{}, len(config.MaintenanceGPUIDs))
+	for _, uuid := range config.MaintenanceGPUIDs {
+		d.maintenanceGPUIDs[uuid] = struct{}{}
+	}
+	d.healthStatusFile = config.HealthStatusFile
+	d.healthStateFile = config.HealthStateFile
+	d.loadHealthState()
+
+	fatalErrorAction, err := newFatalErrorAction(config.FatalErrorAction, d.now, d.logger)
+	if err != nil {
+		return err
+	}
+	d.fatalErrorAction = fatalErrorAction
+
+	notifier, err := newHealthNotifier(config.Notifications, d.now, d.logger)
+	if err != nil {
+		return err
+	}
+	d.notifier = notifier
+
+	audit, err := newAuditLog(config.Audit)
+	if err != nil {
+		return err
+	}
+	d.audit = audit
+
 	for _, ignoredGPUId := range config.IgnoredGPUIDs {
 		d.ignoredGPUIDs[ignoredGPUId] = struct{}{}
 	}
@@ -155,6 +910,205 @@ func (d *NvidiaDevice) SetConfig(cfg *base.Config) error {
 	}
 	d.fingerprintPeriod = period
 
+	switch config.PowerUnit {
+	case structs.UnitW, structs.UnitmW:
+		d.statsOptions.powerUnit = config.PowerUnit
+	default:
+		return fmt.Errorf("invalid power unit %q, must be one of %q or %q", config.PowerUnit, structs.UnitW, structs.UnitmW)
+	}
+	// NVML is only queried on Linux, no other platform reports milliwatts
+	if config.PowerUnit == structs.UnitmW && runtime.GOOS != "linux" {
+		return fmt.Errorf("invalid power unit %q, milliwatts are only reported on Linux", config.PowerUnit)
+	}
+
+	enabledMetrics, err := parseEnabledMetrics(config.Stats.EnabledMetrics)
+	if err != nil {
+		return err
+	}
+	d.statsOptions.enabledMetrics = enabledMetrics
+
+	summary, err := parseSummaryMetric(config.Stats.SummaryMetric)
+	if err != nil {
+		return err
+	}
+	d.statsOptions.summary = summary
+	d.statsOptions.metricKeys = config.Stats.MetricKeys
+
+	transforms, err := parseStatTransforms(config.Stats)
+	if err != nil {
+		return err
+	}
+	d.statsOptions.transforms = transforms
+
+	switch config.Stats.ECCCounters {
+	case "", eccCountersVolatile:
+		d.statsOptions.eccCounters = eccCountersVolatile
+	case eccCountersAggregate, eccCountersBoth:
+		d.statsOptions.eccCounters = config.Stats.ECCCounters
+	default:
+		return fmt.Errorf("invalid ecc counters %q, must be one of %q, %q or %q",
+			config.Stats.ECCCounters, eccCountersVolatile, eccCountersAggregate, eccCountersBoth)
+	}
+
+	warmupTimeout, err := time.ParseDuration(config.StatsWarmupTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to parse stats warmup timeout %q: %v", config.StatsWarmupTimeout, err)
+	}
+	d.statsWarmupTimeout = warmupTimeout
+
+	vgpuLicenseWarning, err := time.ParseDuration(config.VGPULicenseWarning)
+	if err != nil {
+		return fmt.Errorf("failed to parse vgpu license warning %q: %v", config.VGPULicenseWarning, err)
+	}
+	if vgpuLicenseWarning < 0 {
+		return fmt.Errorf("invalid vgpu license warning %q, must not be negative", config.VGPULicenseWarning)
+	}
+	d.vgpuLicenseWarning = vgpuLicenseWarning
+
+	idleReservedWindow, err := time.ParseDuration(config.IdleReservedWindow)
+	if err != nil {
+		return fmt.Errorf("failed to parse idle reserved window %q: %v", config.IdleReservedWindow, err)
+	}
+	if idleReservedWindow < 0 {
+		return fmt.Errorf("invalid idle reserved window %q, must not be negative", config.IdleReservedWindow)
+	}
+	d.idleReservedWindow = idleReservedWindow
+
+	d.statsSampleInterval = 0
+	if config.Stats.SampleInterval != "" {
+		sampleInterval, err := time.ParseDuration(config.Stats.SampleInterval)
+		if err != nil {
+			return fmt.Errorf("failed to parse stats sample interval %q: %v", config.Stats.SampleInterval, err)
+		}
+		if sampleInterval <= 0 {
+			return fmt.Errorf("invalid stats sample interval %q, must be positive", config.Stats.SampleInterval)
+		}
+		d.statsSampleInterval = sampleInterval
+	}
+
+	errorLogInterval, err := time.ParseDuration(config.ErrorLogInterval)
+	if err != nil {
+		return fmt.Errorf("failed to parse error log interval %q: %v", config.ErrorLogInterval, err)
+	}
+	d.errorLog = newErrorLogLimiter(errorLogInterval, d.clock)
+
+	if config.BAR1DegradedThreshold < 0 || config.BAR1DegradedThreshold > 100 {
+		return fmt.Errorf("invalid bar1 degraded threshold %d, must be between 0 and 100", config.BAR1DegradedThreshold)
+	}
+	d.bar1DegradedThreshold = config.BAR1DegradedThreshold
+
+	for groupName, score := range config.PerformanceScores {
+		if score < 0 {
+			return fmt.Errorf("invalid performance score %d of %q, must not be negative", score, groupName)
+		}
+	}
+	d.performanceScores = config.PerformanceScores
+
+	for _, name := range []struct {
+		kind  string
+		value string
+	}{
+		{"vendor", config.Vendor},
+		{"device type", config.DeviceType},
+	} {
+		if strings.Contains(name.value, "/") {
+			return fmt.Errorf("invalid %s %q, must not contain \"/\"", name.kind, name.value)
+		}
+	}
+	for groupName, name := range config.GroupNames {
+		if name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("invalid group name %q of %q, must be non-empty and not contain \"/\"", name, groupName)
+		}
+	}
+	d.vendorName = config.Vendor
+	d.deviceType = config.DeviceType
+	d.groupNames = config.GroupNames
+
+	d.redactedAttributes = nil
+	for _, attr := range config.RedactAttributes {
+		if attr == "" {
+			return fmt.Errorf("invalid redacted attribute %q, must be non-empty", attr)
+		}
+		if d.redactedAttributes == nil {
+			d.redactedAttributes = make(map[string]struct{}, len(config.RedactAttributes))
+		}
+		d.redactedAttributes[attr] = struct{}{}
+	}
+
+	if config.UnhealthySamples < 1 {
+		return fmt.Errorf("invalid unhealthy samples %d, must be at least 1", config.UnhealthySamples)
+	}
+	if config.HealthySamples < 1 {
+		return fmt.Errorf("invalid healthy samples %d, must be at least 1", config.HealthySamples)
+	}
+	d.deviceLock.Lock()
+	d.unhealthySamples = config.UnhealthySamples
+	d.healthySamples = config.HealthySamples
+	d.deviceLock.Unlock()
+
+	if config.CircuitBreakerThreshold < 0 {
+		return fmt.Errorf("invalid circuit breaker threshold %d, must not be negative", config.CircuitBreakerThreshold)
+	}
+	breakerCooldown, err := time.ParseDuration(config.CircuitBreakerCooldown)
+	if err != nil {
+		return fmt.Errorf("failed to parse circuit breaker cooldown %q: %v", config.CircuitBreakerCooldown, err)
+	}
+	if config.QuarantineThreshold < 0 {
+		return fmt.Errorf("invalid quarantine threshold %d, must not be negative", config.QuarantineThreshold)
+	}
+	quarantineWindow, err := time.ParseDuration(config.QuarantineWindow)
+	if err != nil {
+		return fmt.Errorf("failed to parse quarantine window %q: %v", config.QuarantineWindow, err)
+	}
+	d.statsOptions.utilizationSampling = config.UtilizationSampling
+	if d.initErr == nil {
+		d.collector.SetBreakerConfig(nvml.BreakerConfig{
+			Threshold:           config.CircuitBreakerThreshold,
+			Cooldown:            breakerCooldown,
+			QuarantineThreshold: config.QuarantineThreshold,
+			QuarantineWindow:    quarantineWindow,
+		})
+		d.collector.SetUtilizationSampling(config.UtilizationSampling)
+		d.collector.SetGPMMetrics(config.GPMMetrics)
+		d.collector.SetErrorLogInterval(errorLogInterval)
+	}
+
+	switch config.GPUReset {
+	case gpuResetNone, gpuResetClocks, gpuResetFull:
+		d.gpuReset = config.GPUReset
+	default:
+		return fmt.Errorf("invalid gpu reset %q, must be one of %q, %q or %q", config.GPUReset, gpuResetNone, gpuResetClocks, gpuResetFull)
+	}
+
+	switch config.Reservation.Mode {
+	case "":
+		d.reservation = ReservationConfig{Mode: reservationModeEnv, UID: -1, GID: -1}
+	case reservationModeEnv, reservationModeDevices:
+		d.reservation = config.Reservation
+	default:
+		return fmt.Errorf("invalid reservation mode %q, must be one of %q or %q",
+			config.Reservation.Mode, reservationModeEnv, reservationModeDevices)
+	}
+	if err := validateVersionRequirement("min_compute_capability", d.reservation.MinComputeCapability); err != nil {
+		return err
+	}
+	if err := validateVersionRequirement("min_cuda_version", d.reservation.MinCUDAVersion); err != nil {
+		return err
+	}
+
+	switch config.LeftoverProcesses {
+	case leftoverProcessesLog, leftoverProcessesUnhealthy, leftoverProcessesKill:
+		d.leftoverProcesses = config.LeftoverProcesses
+	default:
+		return fmt.Errorf("invalid leftover processes action %q, must be one of %q, %q or %q",
+			config.LeftoverProcesses, leftoverProcessesLog, leftoverProcessesUnhealthy, leftoverProcessesKill)
+	}
+
+	// the debug endpoint is started last so that it does not outlive an
+	// invalid configuration
+	if err := d.setDebugListen(config.DebugListen); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -164,18 +1118,65 @@ func (d *NvidiaDevice) Fingerprint(ctx context.Context) (<-chan *device.Fingerpr
 	if !d.enabled {
 		return nil, device.ErrPluginDisabled
 	}
+	d.started.Store(true)
+
+	// NVML is not coming back without changes to the node, so let Nomad
+	// know that there is nothing to fingerprint instead of reporting errors
+	if d.initErr != nil && nvml.IsPermanent(d.initErr) {
+		d.logger.Info("NVML library or Nvidia driver not found, no devices will be fingerprinted", "reason", d.initErr)
+		return nil, device.ErrPluginDisabled
+	}
+
+	if d.notifier != nil {
+		go d.notifier.run(ctx)
+	}
+
+	toolkit := detectContainerToolkit()
+	if !toolkit.runtimeConfigured && d.reservation.Mode != reservationModeDevices {
+		d.logger.Warn("Nvidia container runtime is not configured for Docker or containerd, containers may not see GPUs",
+			"container_toolkit_version", toolkit.version)
+	}
+	d.fingerprintLock.Lock()
+	d.toolkit = toolkit
+	d.fingerprintLock.Unlock()
 
 	outCh := make(chan *device.FingerprintResponse)
 	go d.fingerprint(ctx, outCh)
 	return outCh, nil
 }
 
+// Reservation error codes identify why Reserve rejected device IDs
+const (
+	// ReservationErrUnknown is returned for IDs that were not fingerprinted
+	ReservationErrUnknown = "unknown"
+
+	// ReservationErrIgnored is returned for IDs listed in ignored_gpu_ids
+	ReservationErrIgnored = "ignored"
+
+	// ReservationErrUnhealthy is returned for IDs of unhealthy devices
+	ReservationErrUnhealthy = "unhealthy"
+
+	// ReservationErrBusy is returned for IDs of devices with compute
+	// processes left over by a previous allocation
+	ReservationErrBusy = "busy"
+
+	// ReservationErrIncompatible is returned for IDs of devices that do not
+	// meet the CUDA requirements of the reservation block
+	ReservationErrIncompatible = "incompatible"
+)
+
 type reservationError struct {
-	notExistingIDs []string
+	code string
+	ids  []string
 }
 
 func (e *reservationError) Error() string {
-	return fmt.Sprintf("unknown device IDs: %s", strings.Join(e.notExistingIDs, ","))
+	return fmt.Sprintf("%s device IDs: %s", e.code, strings.Join(e.ids, ","))
+}
+
+// Code returns the reservation error code
+func (e *reservationError) Code() string {
+	return e.code
 }
 
 // Reserve returns information on how to mount given devices.
@@ -201,23 +1202,81 @@ func (d *NvidiaDevice) Reserve(deviceIDs []string) (*device.ContainerReservation
 	// The latest and always valid version of fingerprinted ids are stored in
 	// d.devices map. To avoid this race condition an error is returned if
 	// any of provided deviceIDs is not found in d.devices map
-	d.deviceLock.RLock()
-	var notExistingIDs []string
+	if err := d.validateReservation(deviceIDs); err != nil {
+		return nil, err
+	}
+	if err := d.validateCapabilities(deviceIDs); err != nil {
+		return nil, err
+	}
+
+	reservation, err := d.containerReservation(deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.handleLeftoverProcesses(deviceIDs); err != nil {
+		return nil, err
+	}
+	d.checkMIGSpread(deviceIDs)
+	d.accountReservations(deviceIDs)
+	d.attributeReservationEnergy(deviceIDs)
+	d.trackIdleReservations(deviceIDs)
+	d.scheduleIsolationCheck(deviceIDs)
+	d.resetDevices(deviceIDs)
+
+	return reservation, nil
+}
+
+// resetDevices resets deviceIDs according to the configured gpu_reset mode.
+// Nomad does not notify device plugins when a reservation is released, so
+// devices are reset right before they are handed to the next tenant. Failures
+// are logged and do not prevent the reservation.
+func (d *NvidiaDevice) resetDevices(deviceIDs []string) {
+	if d.gpuReset == "" || d.gpuReset == gpuResetNone {
+		return
+	}
+
 	for _, id := range deviceIDs {
-		if _, deviceIDExists := d.devices[id]; !deviceIDExists {
-			notExistingIDs = append(notExistingIDs, id)
+		err := d.collector.ResetDevice(id, d.gpuReset == gpuResetFull)
+		switch {
+		case err == nil:
+			d.logger.Debug("reset device", "uuid", id, "mode", d.gpuReset)
+		case errors.Is(err, nvml.ErrNotSupported):
+			d.logger.Debug("device does not support reset", "uuid", id, "mode", d.gpuReset)
+		default:
+			d.logger.Warn("failed to reset device", "uuid", id, "mode", d.gpuReset, "error", err)
 		}
 	}
-	d.deviceLock.RUnlock()
-	if len(notExistingIDs) != 0 {
-		return nil, &reservationError{notExistingIDs}
+}
+
+// validateReservation returns a reservationError if any of deviceIDs is
+// ignored, unknown or unhealthy, checked in that order
+func (d *NvidiaDevice) validateReservation(deviceIDs []string) error {
+	d.deviceLock.RLock()
+	defer d.deviceLock.RUnlock()
+
+	var ignoredIDs, unknownIDs, unhealthyIDs []string
+	for _, id := range deviceIDs {
+		if _, ignored := d.ignoredGPUIDs[id]; ignored {
+			ignoredIDs = append(ignoredIDs, id)
+		} else if _, display := d.displayGPUs[id]; display {
+			ignoredIDs = append(ignoredIDs, id)
+		} else if _, deviceIDExists := d.devices[id]; !deviceIDExists {
+			unknownIDs = append(unknownIDs, id)
+		} else if _, unhealthy := d.unhealthy[id]; unhealthy {
+			unhealthyIDs = append(unhealthyIDs, id)
+		}
 	}
 
-	return &device.ContainerReservation{
-		Envs: map[string]string{
-			NvidiaVisibleDevices: strings.Join(deviceIDs, ","),
-		},
-	}, nil
+	switch {
+	case len(ignoredIDs) != 0:
+		return &reservationError{ReservationErrIgnored, ignoredIDs}
+	case len(unknownIDs) != 0:
+		return &reservationError{ReservationErrUnknown, unknownIDs}
+	case len(unhealthyIDs) != 0:
+		return &reservationError{ReservationErrUnhealthy, unhealthyIDs}
+	}
+	return nil
 }
 
 // Stats streams statistics for the detected devices.
@@ -225,8 +1284,13 @@ func (d *NvidiaDevice) Stats(ctx context.Context, interval time.Duration) (<-cha
 	if !d.enabled {
 		return nil, device.ErrPluginDisabled
 	}
+	d.started.Store(true)
 
-	outCh := make(chan *device.StatsResponse)
+	size := d.statsBuffer
+	if size < 1 {
+		size = defaultStatsBuffer
+	}
+	outCh := make(chan *device.StatsResponse, size)
 	go d.stats(ctx, outCh, interval)
 	return outCh, nil
 }
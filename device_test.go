@@ -4,11 +4,19 @@
 package nvidia
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
 	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
 	"github.com/shoenig/test/must"
 )
 
@@ -18,6 +26,36 @@ type MockNvmlClient struct {
 
 	StatsError            error
 	StatsResponseReturned []*nvml.StatsData
+
+	ResetError error
+	ResetCalls []string
+
+	ProcessesError    error
+	ProcessesReturned map[string][]int
+
+	AccountingError    error
+	AccountingReturned map[string][]*nvml.AccountingStats
+	AccountingCalls    []string
+
+	FatalErrorsReturned map[string]string
+
+	EnergyReturned map[string]uint64
+
+	BreakerConfig nvml.BreakerConfig
+
+	UtilizationSampling bool
+
+	GPMMetrics bool
+
+	ErrorLogInterval time.Duration
+
+	SampleStatsCalls int
+
+	PreflightErrors map[string]error
+}
+
+func (c *MockNvmlClient) DeviceAttributes(d *nvml.FingerprintDeviceData) map[string]*structs.Attribute {
+	return attributesFromFingerprintDeviceData(d)
 }
 
 func (c *MockNvmlClient) GetFingerprintData() (*nvml.FingerprintData, error) {
@@ -28,6 +66,71 @@ func (c *MockNvmlClient) GetStatsData() ([]*nvml.StatsData, error) {
 	return c.StatsResponseReturned, c.StatsError
 }
 
+func (c *MockNvmlClient) Shutdown() error {
+	return nil
+}
+
+func (c *MockNvmlClient) SetBreakerConfig(config nvml.BreakerConfig) {
+	c.BreakerConfig = config
+}
+
+func (c *MockNvmlClient) SetUtilizationSampling(enabled bool) {
+	c.UtilizationSampling = enabled
+}
+
+func (c *MockNvmlClient) SetGPMMetrics(enabled bool) {
+	c.GPMMetrics = enabled
+}
+
+func (c *MockNvmlClient) SetErrorLogInterval(interval time.Duration) {
+	c.ErrorLogInterval = interval
+}
+
+func (c *MockNvmlClient) SampleStats() error {
+	c.SampleStatsCalls++
+	return nil
+}
+
+func (c *MockNvmlClient) Preflight(uuid string) error {
+	return c.PreflightErrors[uuid]
+}
+
+func (c *MockNvmlClient) GetEnergyConsumption(uuid string) (uint64, error) {
+	energy, ok := c.EnergyReturned[uuid]
+	if !ok {
+		return 0, nvml.ErrNotSupported
+	}
+	return energy, nil
+}
+
+func (c *MockNvmlClient) GetFatalError(uuid string) (string, error) {
+	return c.FatalErrorsReturned[uuid], nil
+}
+
+func (c *MockNvmlClient) GetComputeProcesses(uuid string) ([]int, error) {
+	return c.ProcessesReturned[uuid], c.ProcessesError
+}
+
+func (c *MockNvmlClient) EnableAccounting(uuid string) error {
+	c.AccountingCalls = append(c.AccountingCalls, "enable:"+uuid)
+	return c.AccountingError
+}
+
+func (c *MockNvmlClient) GetAccountingStats(uuid string) ([]*nvml.AccountingStats, error) {
+	c.AccountingCalls = append(c.AccountingCalls, "stats:"+uuid)
+	return c.AccountingReturned[uuid], c.AccountingError
+}
+
+func (c *MockNvmlClient) ClearAccounting(uuid string) error {
+	c.AccountingCalls = append(c.AccountingCalls, "clear:"+uuid)
+	return c.AccountingError
+}
+
+func (c *MockNvmlClient) ResetDevice(uuid string, full bool) error {
+	c.ResetCalls = append(c.ResetCalls, fmt.Sprintf("%s:%v", uuid, full))
+	return c.ResetError
+}
+
 func TestReserve(t *testing.T) {
 	cases := []struct {
 		Name                string
@@ -39,7 +142,7 @@ func TestReserve(t *testing.T) {
 		{
 			Name:                "All RequestedIDs are not managed by Device",
 			ExpectedReservation: nil,
-			ExpectedError: &reservationError{[]string{
+			ExpectedError: &reservationError{ReservationErrUnknown, []string{
 				"UUID1",
 				"UUID2",
 				"UUID3",
@@ -57,19 +160,84 @@ func TestReserve(t *testing.T) {
 		{
 			Name:                "Some RequestedIDs are not managed by Device",
 			ExpectedReservation: nil,
-			ExpectedError: &reservationError{[]string{
+			ExpectedError: &reservationError{ReservationErrUnknown, []string{
+				"UUID1",
+				"UUID2",
+			}},
+			RequestedIDs: []string{
 				"UUID1",
 				"UUID2",
+				"UUID3",
+			},
+			Device: &NvidiaDevice{
+				devices: map[string]struct{}{
+					"UUID3": {},
+				},
+				logger:  hclog.NewNullLogger(),
+				enabled: true,
+			},
+		},
+		{
+			Name:                "Some RequestedIDs are ignored",
+			ExpectedReservation: nil,
+			ExpectedError: &reservationError{ReservationErrIgnored, []string{
+				"UUID2",
+			}},
+			RequestedIDs: []string{
+				"UUID1",
+				"UUID2",
+				"UUID3",
+			},
+			Device: &NvidiaDevice{
+				devices: map[string]struct{}{
+					"UUID1": {},
+				},
+				ignoredGPUIDs: map[string]struct{}{
+					"UUID2": {},
+				},
+				logger:  hclog.NewNullLogger(),
+				enabled: true,
+			},
+		},
+		{
+			Name:                "Some RequestedIDs have a display attached",
+			ExpectedReservation: nil,
+			ExpectedError: &reservationError{ReservationErrIgnored, []string{
+				"UUID2",
 			}},
 			RequestedIDs: []string{
 				"UUID1",
 				"UUID2",
+			},
+			Device: &NvidiaDevice{
+				devices: map[string]struct{}{
+					"UUID1": {},
+				},
+				displayGPUs: map[string]struct{}{
+					"UUID2": {},
+				},
+				logger:  hclog.NewNullLogger(),
+				enabled: true,
+			},
+		},
+		{
+			Name:                "Some RequestedIDs are unhealthy",
+			ExpectedReservation: nil,
+			ExpectedError: &reservationError{ReservationErrUnhealthy, []string{
+				"UUID3",
+			}},
+			RequestedIDs: []string{
+				"UUID1",
 				"UUID3",
 			},
 			Device: &NvidiaDevice{
 				devices: map[string]struct{}{
+					"UUID1": {},
 					"UUID3": {},
 				},
+				unhealthy: map[string]*deviceHealth{
+					"UUID3": {causes: map[string]string{healthCauseFatalError: "uncorrectable ECC errors"}},
+				},
 				logger:  hclog.NewNullLogger(),
 				enabled: true,
 			},
@@ -97,6 +265,27 @@ func TestReserve(t *testing.T) {
 				enabled: true,
 			},
 		},
+		{
+			Name: "MIG instances of both UUID schemes",
+			ExpectedReservation: &device.ContainerReservation{
+				Envs: map[string]string{
+					NvidiaVisibleDevices: "MIG-1a2b3c4d-5e6f-7a8b-9c0d-1e2f3a4b5c6d,MIG-GPU-5c89852c-d268-c3f3-1b07-005d5ae1dc3f/7/0",
+				},
+			},
+			ExpectedError: nil,
+			RequestedIDs: []string{
+				"MIG-1a2b3c4d-5e6f-7a8b-9c0d-1e2f3a4b5c6d",
+				"MIG-GPU-5c89852c-d268-c3f3-1b07-005d5ae1dc3f/7/0",
+			},
+			Device: &NvidiaDevice{
+				devices: map[string]struct{}{
+					"MIG-1a2b3c4d-5e6f-7a8b-9c0d-1e2f3a4b5c6d":         {},
+					"MIG-GPU-5c89852c-d268-c3f3-1b07-005d5ae1dc3f/7/0": {},
+				},
+				logger:  hclog.NewNullLogger(),
+				enabled: true,
+			},
+		},
 		{
 			Name:                "No IDs requested",
 			ExpectedReservation: &device.ContainerReservation{},
@@ -141,3 +330,162 @@ func TestReserve(t *testing.T) {
 		})
 	}
 }
+
+func TestReserveGPUReset(t *testing.T) {
+	for _, testCase := range []struct {
+		Name               string
+		GPUReset           string
+		ResetError         error
+		ExpectedResetCalls []string
+	}{
+		{
+			Name:               "no reset",
+			GPUReset:           gpuResetNone,
+			ExpectedResetCalls: nil,
+		},
+		{
+			Name:               "clocks reset",
+			GPUReset:           gpuResetClocks,
+			ExpectedResetCalls: []string{"UUID1:false", "UUID2:false"},
+		},
+		{
+			Name:               "full reset",
+			GPUReset:           gpuResetFull,
+			ExpectedResetCalls: []string{"UUID1:true", "UUID2:true"},
+		},
+		{
+			Name:               "reset failures do not fail the reservation",
+			GPUReset:           gpuResetFull,
+			ResetError:         errors.New("reset failed"),
+			ExpectedResetCalls: []string{"UUID1:true", "UUID2:true"},
+		},
+	} {
+		t.Run(testCase.Name, func(t *testing.T) {
+			client := &MockNvmlClient{ResetError: testCase.ResetError}
+			d := &NvidiaDevice{
+				devices: map[string]struct{}{
+					"UUID1": {},
+					"UUID2": {},
+				},
+				collector: client,
+				gpuReset:  testCase.GPUReset,
+				logger:    hclog.NewNullLogger(),
+				enabled:   true,
+			}
+
+			reservation, err := d.Reserve([]string{"UUID1", "UUID2"})
+			must.NoError(t, err)
+			must.Eq(t, "UUID1,UUID2", reservation.Envs[NvidiaVisibleDevices])
+			must.Eq(t, testCase.ExpectedResetCalls, client.ResetCalls)
+		})
+	}
+}
+
+// TestConcurrentFingerprintStatsReserve exercises the fingerprint and stats
+// loops together with reservations and health updates, it is meant to be run
+// with -race
+func TestConcurrentFingerprintStatsReserve(t *testing.T) {
+	d := &NvidiaDevice{
+		collector: &MockNvmlClient{
+			FingerprintResponseReturned: &nvml.FingerprintData{
+				DriverVersion: "1",
+				Devices: []*nvml.FingerprintDeviceData{
+					{DeviceData: &nvml.DeviceData{UUID: "UUID1", DeviceName: pointer.Of("Name")}},
+					{DeviceData: &nvml.DeviceData{UUID: "UUID2", DeviceName: pointer.Of("Name")}},
+				},
+			},
+			StatsResponseReturned: []*nvml.StatsData{
+				{DeviceData: &nvml.DeviceData{UUID: "UUID1", DeviceName: pointer.Of("Name")}},
+				{DeviceData: &nvml.DeviceData{UUID: "UUID2", DeviceName: pointer.Of("Name")}},
+			},
+		},
+		devices:           make(map[string]struct{}),
+		fingerprintPeriod: time.Millisecond,
+		aggregateStats:    true,
+		logger:            hclog.NewNullLogger(),
+		enabled:           true,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fingerprints, err := d.Fingerprint(ctx)
+	must.NoError(t, err)
+	stats, err := d.Stats(ctx, time.Millisecond)
+	must.NoError(t, err)
+
+	// drain both channels until the loops exit and close them
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for range fingerprints {
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range stats {
+		}
+	}()
+
+	for deadline := time.Now().Add(50 * time.Millisecond); time.Now().Before(deadline); {
+		d.setDeviceUnhealthy("UUID2", "test", "unhealthy")
+		_, _ = d.Reserve([]string{"UUID1", "UUID2"})
+		d.setDeviceHealthy("UUID2", "test")
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestLoadNVMLLibrary(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("NVML is only supported on Linux")
+	}
+
+	d := &NvidiaDevice{
+		logger:    hclog.NewNullLogger(),
+		collector: &MockNvmlClient{},
+	}
+	d.loadNVMLLibrary("/nonexistent/libnvidia-ml.so.1", false)
+	must.EqError(t, d.initErr, `could not load NVML library "/nonexistent/libnvidia-ml.so.1"`)
+	must.True(t, nvml.IsPermanent(d.initErr))
+}
+
+func TestNewNvidiaDeviceDefersNVML(t *testing.T) {
+	// the default library is not loaded before the config tells which one
+	d := NewNvidiaDevice(context.Background(), hclog.NewNullLogger())
+	must.Nil(t, d.collector)
+
+	must.NoError(t, setPluginConfig(t, d, fmt.Sprintf(`
+config {
+  cdi_spec_dir = %q
+}
+`, t.TempDir())))
+	must.NoError(t, d.initErr)
+	_, ok := d.collector.(nvidiaCollector).NvmlClient.(*cdiClient)
+	must.True(t, ok)
+}
+
+func TestNewNvidiaDeviceOptions(t *testing.T) {
+	client := &MockNvmlClient{}
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	d := NewNvidiaDevice(context.Background(), hclog.NewNullLogger(),
+		WithNvmlClient(client),
+		WithClock(ClockFunc(func() time.Time { return at })),
+		WithStatsBuffer(4),
+	)
+	must.NoError(t, d.initErr)
+	must.Eq(t, Collector(nvidiaCollector{client}), d.collector)
+	must.Eq(t, at, d.now())
+
+	d.setDeviceUnhealthy("UUID1", healthCauseFatalError, "GPU is lost")
+	must.Eq(t, at, d.unhealthy["UUID1"].since)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.enabled = true
+	stats, err := d.Stats(ctx, time.Minute)
+	must.NoError(t, err)
+	must.Eq(t, 4, cap(stats))
+}
@@ -0,0 +1,154 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+)
+
+const (
+	// Stats attribute of the energy consumed by the reservation of a device
+	ReservationEnergyAttr = "Reservation energy consumed"
+	ReservationEnergyDesc = "Energy consumed by this GPU since it was reserved"
+)
+
+// energyReservation tracks the energy consumed by the devices of a
+// reservation
+type energyReservation struct {
+	reservedAt time.Time
+
+	// startMJ holds the energy counter of each device when it was reserved
+	startMJ map[string]uint64
+}
+
+// attributeReservationEnergy logs the energy consumed by the previous
+// reservations of the devices in deviceIDs and starts attributing energy to
+// the new reservation. Nomad does not notify device plugins when a reservation
+// is released, so a reservation is considered released when any of its
+// devices is reserved again.
+func (d *NvidiaDevice) attributeReservationEnergy(deviceIDs []string) {
+	if !d.energyAccounting {
+		return
+	}
+
+	d.energyLock.Lock()
+	defer d.energyLock.Unlock()
+
+	if d.energyReservations == nil {
+		d.energyReservations = make(map[string]*energyReservation)
+	}
+
+	now := d.now()
+	for _, id := range deviceIDs {
+		if reservation, ok := d.energyReservations[id]; ok {
+			d.releaseEnergyReservation(reservation, now)
+		}
+	}
+
+	reservation := &energyReservation{
+		reservedAt: now,
+		startMJ:    make(map[string]uint64, len(deviceIDs)),
+	}
+	for _, id := range deviceIDs {
+		energy, err := d.collector.GetEnergyConsumption(id)
+		if err != nil {
+			if errors.Is(err, nvml.ErrNotSupported) {
+				d.logger.Debug("device does not support energy consumption", "uuid", id)
+			} else {
+				d.logger.Warn("failed to get device energy consumption", "uuid", id, "error", err)
+			}
+			continue
+		}
+		reservation.startMJ[id] = energy
+		d.energyReservations[id] = reservation
+	}
+}
+
+// releaseEnergyReservation logs the energy consumed by all devices of the
+// reservation and stops attributing energy to it. The energy lock must be
+// held.
+func (d *NvidiaDevice) releaseEnergyReservation(reservation *energyReservation, releasedBefore time.Time) {
+	uuids := slices.Sorted(maps.Keys(reservation.startMJ))
+
+	var consumedMJ uint64
+	for _, id := range uuids {
+		delete(d.energyReservations, id)
+		energy, err := d.collector.GetEnergyConsumption(id)
+		if err != nil {
+			d.logger.Warn("failed to get device energy consumption", "uuid", id, "error", err)
+			continue
+		}
+		consumedMJ += energyConsumed(reservation.startMJ[id], energy)
+	}
+
+	d.logger.Info("reservation energy summary", "uuids", uuids,
+		"reserved_at", reservation.reservedAt, "released_before", releasedBefore,
+		"energy_wh", wattHours(consumedMJ))
+}
+
+// addReservationEnergyStats adds the energy consumed by the reservation of
+// every reserved device in groups, computed from the energy counters of
+// statsData
+func (d *NvidiaDevice) addReservationEnergyStats(groups []*device.DeviceGroupStats, statsData []*nvml.StatsData) {
+	if _, enabled := d.statsOptions.enabledMetrics[ReservationEnergyAttr]; !enabled && d.statsOptions.enabledMetrics != nil {
+		return
+	}
+
+	energy := make(map[string]uint64, len(statsData))
+	for _, statsItem := range statsData {
+		if statsItem.EnergyMJ != nil {
+			energy[statsItem.UUID] = *statsItem.EnergyMJ
+		}
+	}
+
+	d.energyLock.Lock()
+	defer d.energyLock.Unlock()
+
+	for _, group := range groups {
+		for uuid, deviceStats := range group.InstanceStats {
+			reservation, ok := d.energyReservations[uuid]
+			if !ok {
+				continue
+			}
+			current, ok := energy[uuid]
+			if !ok {
+				continue
+			}
+
+			if deviceStats.Stats == nil {
+				deviceStats.Stats = &structs.StatObject{}
+			}
+			if deviceStats.Stats.Attributes == nil {
+				deviceStats.Stats.Attributes = make(map[string]*structs.StatValue)
+			}
+			deviceStats.Stats.Attributes[ReservationEnergyAttr] = &structs.StatValue{
+				Unit:              UnitWattHour,
+				Desc:              ReservationEnergyDesc,
+				FloatNumeratorVal: pointer.Of(wattHours(energyConsumed(reservation.startMJ[uuid], current))),
+			}
+		}
+	}
+}
+
+// energyConsumed returns the energy consumed between two readings of an
+// energy counter, which restarts from zero when the driver is reloaded
+func energyConsumed(startMJ, endMJ uint64) uint64 {
+	if endMJ < startMJ {
+		return endMJ
+	}
+	return endMJ - startMJ
+}
+
+// wattHours converts millijoules to watt hours
+func wattHours(mj uint64) float64 {
+	return float64(mj) / (3600 * 1000)
+}
@@ -0,0 +1,83 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+	"github.com/shoenig/test/must"
+)
+
+func TestAttributeReservationEnergy(t *testing.T) {
+	client := &MockNvmlClient{
+		EnergyReturned: map[string]uint64{"UUID1": 1000, "UUID2": 5000},
+	}
+	d := &NvidiaDevice{
+		collector:        client,
+		energyAccounting: true,
+		logger:           hclog.NewNullLogger(),
+	}
+
+	// devices without energy counter are not attributed energy
+	d.attributeReservationEnergy([]string{"UUID1", "UUID2", "UUID3"})
+	must.MapLen(t, 2, d.energyReservations)
+	must.Eq(t, d.energyReservations["UUID1"], d.energyReservations["UUID2"])
+	must.Eq(t, map[string]uint64{"UUID1": 1000, "UUID2": 5000}, d.energyReservations["UUID1"].startMJ)
+
+	// reserving one of its devices again releases the whole reservation
+	client.EnergyReturned["UUID1"] = 2000
+	d.attributeReservationEnergy([]string{"UUID1"})
+	must.MapLen(t, 1, d.energyReservations)
+	must.Eq(t, map[string]uint64{"UUID1": 2000}, d.energyReservations["UUID1"].startMJ)
+
+	// nothing is attributed when disabled
+	d.energyAccounting = false
+	d.attributeReservationEnergy([]string{"UUID2"})
+	must.MapNotContainsKey(t, d.energyReservations, "UUID2")
+}
+
+func TestAddReservationEnergyStats(t *testing.T) {
+	d := &NvidiaDevice{
+		collector: &MockNvmlClient{
+			EnergyReturned: map[string]uint64{"UUID1": 3600000},
+		},
+		energyAccounting: true,
+		logger:           hclog.NewNullLogger(),
+	}
+	d.attributeReservationEnergy([]string{"UUID1"})
+
+	groups := []*device.DeviceGroupStats{
+		{
+			Name: "Type1",
+			InstanceStats: map[string]*device.DeviceStats{
+				"UUID1": {},
+				"UUID2": {},
+			},
+		},
+	}
+	d.addReservationEnergyStats(groups, []*nvml.StatsData{
+		{DeviceData: &nvml.DeviceData{UUID: "UUID1"}, EnergyMJ: pointer.Of(uint64(12600000))},
+		{DeviceData: &nvml.DeviceData{UUID: "UUID2"}, EnergyMJ: pointer.Of(uint64(7200000))},
+	})
+	must.Eq(t, &structs.StatValue{
+		Unit:              UnitWattHour,
+		Desc:              ReservationEnergyDesc,
+		FloatNumeratorVal: pointer.Of(2.5),
+	}, groups[0].InstanceStats["UUID1"].Stats.Attributes[ReservationEnergyAttr])
+
+	// devices that are not reserved have no reservation energy
+	must.Nil(t, groups[0].InstanceStats["UUID2"].Stats)
+}
+
+func TestEnergyConsumed(t *testing.T) {
+	must.Eq(t, 500, energyConsumed(1000, 1500))
+
+	// the counter restarts from zero when the driver is reloaded
+	must.Eq(t, 200, energyConsumed(1000, 200))
+}
@@ -5,9 +5,20 @@ package nvidia
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad-device-nvidia/version"
 	"github.com/hashicorp/nomad/helper/pointer"
 	"github.com/hashicorp/nomad/plugins/device"
 	"github.com/hashicorp/nomad/plugins/shared/structs"
@@ -15,88 +26,544 @@ import (
 
 const (
 	// Attribute names and units for reporting Fingerprint output
-	MemoryAttr          = "memory"
-	PowerAttr           = "power"
-	BAR1Attr            = "bar1"
-	DriverVersionAttr   = "driver_version"
-	CoresClockAttr      = "cores_clock"
-	MemoryClockAttr     = "memory_clock"
-	PCIBandwidthAttr    = "pci_bandwidth"
-	DisplayStateAttr    = "display_state"
-	PersistenceModeAttr = "persistence_mode"
+	MemoryAttr                 = "memory"
+	PowerAttr                  = "power"
+	BAR1Attr                   = "bar1"
+	DriverVersionAttr          = "driver_version"
+	DriverBranchAttr           = "driver_branch"
+	NVMLVersionAttr            = "nvml_version"
+	NVMLBindingsVersionAttr    = "nvml_bindings_version"
+	PluginVersionAttr          = "plugin_version"
+	CoresClockAttr             = "cores_clock"
+	MemoryClockAttr            = "memory_clock"
+	ApplicationCoresClockAttr  = "application_cores_clock"
+	ApplicationMemoryClockAttr = "application_memory_clock"
+	PCIBandwidthAttr           = "pci_bandwidth"
+	DisplayStateAttr           = "display_state"
+	PersistenceModeAttr        = "persistence_mode"
+	PCIVendorIDAttr            = "pci_vendor_id"
+	PCIDeviceIDAttr            = "pci_device_id"
+	PCILinkGenerationAttr      = "pcie_link_generation"
+	PCILinkWidthAttr           = "pcie_link_width"
+	EncoderCapacityH264Attr    = "encoder_capacity_h264"
+	EncoderCapacityHEVCAttr    = "encoder_capacity_hevc"
+	EncoderCapacityAV1Attr     = "encoder_capacity_av1"
+	GSPFirmwareModeAttr        = "gsp_firmware_mode"
+	GSPFirmwareVersionAttr     = "gsp_firmware_version"
+	InfoROMVersionOEMAttr      = "inforom_oem_version"
+	InfoROMVersionECCAttr      = "inforom_ecc_version"
+	InfoROMVersionPowerAttr    = "inforom_power_version"
+	OperationModeAttr          = "operation_mode"
+	VirtualizationModeAttr     = "virtualization_mode"
+	BrandAttr                  = "brand"
+	FanCountAttr               = "fan_count"
+	FanSpeedMinAttr            = "fan_speed_min"
+	FanSpeedMaxAttr            = "fan_speed_max"
+	FanControlAttr             = "fan_control"
+	CoherentMemoryAttr         = "coherent_memory"
+	NUMANodeAttr               = "numa_node"
+	MemoryNUMANodeAttr         = "memory_numa_node"
+	C2CLinksAttr               = "c2c_links"
+	C2CBandwidthAttr           = "c2c_bandwidth"
+	MIGParentAttr              = "mig_parent"
+
+	// ArchitectureAttr is the architecture of the GPU, which sets the
+	// generation of its hardware video encoder (NVENC) and decoder (NVDEC)
+	ArchitectureAttr = "architecture"
+
+	// Codecs the GPU encodes and decodes in hardware, reported as booleans
+	H264EncodeAttr = "h264_encode"
+	HEVCEncodeAttr = "hevc_encode"
+	AV1EncodeAttr  = "av1_encode"
+	H264DecodeAttr = "h264_decode"
+	HEVCDecodeAttr = "hevc_decode"
+	AV1DecodeAttr  = "av1_decode"
+
+	// Temperature thresholds of the GPU, in degrees Celsius
+	MaxOperatingTemperatureAttr = "max_operating_temperature"
+	AcousticTemperatureAttr     = "acoustic_temperature"
+
+	// MIGProfilesAttr lists the MIG profiles supported by the physical GPU
+	// as comma separated "<profile>=<max instances>" pairs
+	MIGProfilesAttr = "mig_profiles"
+
+	// Attribute names of values that differ between the devices of a group,
+	// they are reported as comma separated "<UUID>=<value>" pairs
+	IndexAttr          = "index"
+	PCISubsystemIDAttr = "pci_subsystem_id"
+	ParentGPUUUIDAttr  = "parent_gpu_uuid"
+
+	// MIGParentGPUsAttr is the number of distinct physical GPUs the MIG
+	// devices of a group belong to
+	MIGParentGPUsAttr = "mig_parent_gpus"
+
+	// Attribute names of the capacity of a device group, the number of its
+	// devices and their total memory, so that policies do not have to
+	// multiply the device count by the memory of a device
+	GroupDevicesAttr = "group_devices"
+	GroupMemoryAttr  = "group_memory"
+
+	// FlatGroupName is the name of the single device group all devices are
+	// advertised in when groups are flattened, and ModelAttr the attribute
+	// reporting the model of its devices
+	FlatGroupName = "gpu"
+	ModelAttr     = "model"
+
+	// Attribute names summarizing all devices of the node, they are reported
+	// on every device group
+	DevicesTotalAttr   = "devices_total"
+	DevicesHealthyAttr = "devices_healthy"
+	DevicesIgnoredAttr = "devices_ignored"
+	DevicesMemoryAttr  = "devices_memory"
 )
 
+// minimumDriverVersion is the oldest driver version known to work with the
+// plugin, older drivers lack NVML functions the plugin relies on, such as MIG
+// support
+const minimumDriverVersion = "450.80.02"
+
+// nvmlBindingsModule is the module path of the NVML Go bindings
+const nvmlBindingsModule = "github.com/NVIDIA/go-nvml"
+
+// nvmlBindingsVersion returns the version of the NVML Go bindings the plugin
+// was built with, or an empty string if it is unknown
+var nvmlBindingsVersion = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == nvmlBindingsModule {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return ""
+})
+
 // fingerprint is the long running goroutine that detects hardware
 func (d *NvidiaDevice) fingerprint(ctx context.Context, devices chan<- *device.FingerprintResponse) {
 	defer close(devices)
 
+	// permanent initialization errors are handled by Fingerprint, the
+	// remaining ones may be resolved by restarting the plugin
 	if d.initErr != nil {
-		if d.initErr.Error() != nvml.UnavailableLib.Error() {
-			d.logger.Error("exiting fingerprinting due to problems with NVML loading", "error", d.initErr)
-			devices <- device.NewFingerprintError(d.initErr)
-		}
-
-		// Just close the channel to let server know that there are no working
-		// Nvidia GPU units
+		d.logger.Error("exiting fingerprinting due to problems with NVML loading", "error", d.initErr)
+		devices <- device.NewFingerprintError(d.initErr)
 		return
 	}
 
+	// lastHash is the content hash of the last response sent on this stream,
+	// so that every stream starts with a response. While the node has no
+	// devices, suspendedPCIDevices holds its Nvidia PCI devices at the time
+	// fingerprinting was suspended.
+	var lastHash string
+	var suspended bool
+	var suspendedPCIDevices []string
+
 	// Create a timer that will fire immediately for the first detection
 	ticker := time.NewTimer(0)
+	sriovTicker := time.NewTicker(sriovCheckPeriod)
+	defer sriovTicker.Stop()
+	hotplugTicker := time.NewTicker(hotplugCheckPeriod)
+	defer hotplugTicker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			ticker.Reset(d.fingerprintPeriod)
+		case <-hotplugTicker.C:
+			if !suspended || !d.hotplugged(suspendedPCIDevices) {
+				continue
+			}
+		case <-sriovTicker.C:
+			if !d.sriovVFsChanged() {
+				continue
+			}
+		}
+		lastHash = d.writeFingerprintToChannel(devices, lastHash)
+
+		// the empty fingerprint of a node without devices is final until
+		// devices are hotplugged
+		suspended = d.hasNoDevices()
+		if suspended {
+			ticker.Stop()
+			suspendedPCIDevices = nvidiaPCIDevices()
+			continue
 		}
-		d.writeFingerprintToChannel(devices)
+		ticker.Reset(d.fingerprintPeriod)
+	}
+}
+
+// firstFingerprint returns a channel that is closed once the first
+// fingerprint completed and the set of eligible devices is known
+func (d *NvidiaDevice) firstFingerprint() <-chan struct{} {
+	d.fingerprintedOnce.Do(func() {
+		d.fingerprinted = make(chan struct{})
+	})
+	return d.fingerprinted
+}
+
+// markFingerprinted records that the first fingerprint completed
+func (d *NvidiaDevice) markFingerprinted() {
+	d.firstFingerprint()
+	d.fingerprintedMark.Do(func() {
+		close(d.fingerprinted)
+	})
+}
+
+// writeFingerprintToChannel makes nvml call and writes response to channel,
+// unless the content hash of the response is lastHash. It returns the hash of
+// the last response written, which is empty after an error so that the next
+// successful response is always written.
+func (d *NvidiaDevice) writeFingerprintToChannel(devices chan<- *device.FingerprintResponse, lastHash string) string {
+	d.fingerprintLock.Lock()
+	response, hash := d.fingerprintResponse(lastHash)
+	d.fingerprintLock.Unlock()
+
+	// the lock is not held while sending, as the stream may no longer be
+	// read once Nomad opened another one
+	if response != nil {
+		devices <- response
 	}
+	return hash
+}
+
+// hasNoDevices reports whether NVML reported no devices at all on the last
+// fingerprint
+func (d *NvidiaDevice) hasNoDevices() bool {
+	d.fingerprintLock.Lock()
+	defer d.fingerprintLock.Unlock()
+	return d.noDevices
 }
 
-// writeFingerprintToChannel makes nvml call and writes response to channel
-func (d *NvidiaDevice) writeFingerprintToChannel(devices chan<- *device.FingerprintResponse) {
-	fingerprintData, err := d.nvmlClient.GetFingerprintData()
+// fingerprintResponse makes nvml call and returns the fingerprint response
+// along with its content hash, or a nil response when the hash is lastHash.
+// It must be called with fingerprintLock held.
+func (d *NvidiaDevice) fingerprintResponse(lastHash string) (*device.FingerprintResponse, string) {
+	d.errorLog.flush()
+
+	// check the devices known so far, as devices in a fatal state can make
+	// the fingerprint fail
+	d.checkFatalErrors()
+
+	fingerprintData, err := d.collector.GetFingerprintData()
 	if err != nil {
-		d.logger.Error("failed to get fingerprint nvidia devices", "error", err)
-		devices <- device.NewFingerprintError(err)
-		return
+		d.errorLog.Error(d.logger, "failed to get fingerprint nvidia devices", err)
+		return device.NewFingerprintError(err), ""
 	}
 
+	// log only once when the node has no devices rather than on every period
+	if len(fingerprintData.Devices) == 0 && !d.noDevices {
+		d.logger.Info("no Nvidia devices detected, suspending fingerprinting until devices are hotplugged")
+	} else if len(fingerprintData.Devices) != 0 && d.noDevices {
+		d.logger.Info("Nvidia devices detected", "count", len(fingerprintData.Devices))
+	}
+	d.noDevices = len(fingerprintData.Devices) == 0
+
 	// ignore devices from fingerprint output
 	fingerprintDevices := ignoreFingerprintedDevices(fingerprintData.Devices, d.ignoredGPUIDs)
-	// check if any device health was updated or any device was added to host
-	if !d.fingerprintChanged(fingerprintDevices) {
-		return
+	if d.ignoreDisplayGPUs {
+		fingerprintDevices = d.ignoreDisplayDevices(fingerprintDevices)
 	}
+	checkedCount := len(fingerprintDevices)
+	fingerprintDevices = d.preflightDevices(fingerprintDevices)
+	preflightFailedCount := checkedCount - len(fingerprintDevices)
+	// update the set of eligible devices used by Reserve and Stats
+	d.updateDevices(fingerprintDevices)
+	d.setMIGParentGPUs(fingerprintData.MIGParents)
+	d.setSRIOVVFs(fingerprintDevices)
+	d.setCapabilities(fingerprintDevices, fingerprintData.CUDADriverVersion)
+	d.markFingerprinted()
+	d.checkFailingDevices(fingerprintData.FailingDevices)
+	d.checkMaintenance()
+	d.checkInfoROM(fingerprintDevices)
+	persistenced := persistencedRunning()
+	d.checkPersistenceMode(fingerprintDevices, persistenced)
+	d.checkVGPULicense(fingerprintDevices)
+	// report devices whose attributes changed at runtime
+	d.detectAttributeDrift(fingerprintDevices)
+
+	d.checkDriverVersion(fingerprintData.DriverVersion)
 
 	commonAttributes := map[string]*structs.Attribute{
 		DriverVersionAttr: {
 			String: pointer.Of(fingerprintData.DriverVersion),
 		},
+		PluginVersionAttr: {
+			String: pointer.Of(version.Version),
+		},
+	}
+	if fingerprintData.NVMLVersion != "" {
+		commonAttributes[NVMLVersionAttr] = &structs.Attribute{
+			String: pointer.Of(fingerprintData.NVMLVersion),
+		}
+	}
+	if fingerprintData.CUDADriverVersion != "" {
+		commonAttributes[CUDADriverVersionAttr] = &structs.Attribute{
+			String: pointer.Of(fingerprintData.CUDADriverVersion),
+		}
+	}
+	if bindingsVersion := nvmlBindingsVersion(); bindingsVersion != "" {
+		commonAttributes[NVMLBindingsVersionAttr] = &structs.Attribute{
+			String: pointer.Of(bindingsVersion),
+		}
+	}
+	if branch, ok := driverBranch(fingerprintData.DriverVersion); ok {
+		commonAttributes[DriverBranchAttr] = &structs.Attribute{
+			String: pointer.Of(branch),
+		}
+	}
+	commonAttributes[PersistencedRunningAttr] = &structs.Attribute{
+		Bool: pointer.Of(persistenced),
+	}
+	gdsLoaded, gdsVersion := gdsModule()
+	commonAttributes[GDSSupportedAttr] = &structs.Attribute{
+		Bool: pointer.Of(gdsLoaded),
+	}
+	if gdsVersion != "" {
+		commonAttributes[GDSVersionAttr] = &structs.Attribute{
+			String: pointer.Of(gdsVersion),
+		}
+	}
+	if d.toolkit != nil {
+		if d.toolkit.version != "" {
+			commonAttributes[ContainerToolkitVersionAttr] = &structs.Attribute{
+				String: pointer.Of(d.toolkit.version),
+			}
+		}
+		commonAttributes[RuntimeConfiguredAttr] = &structs.Attribute{
+			Bool: pointer.Of(d.toolkit.runtimeConfigured),
+		}
 	}
 
 	// Group all FingerprintDevices by DeviceName attribute
 	deviceListByDeviceName := make(map[string][]*nvml.FingerprintDeviceData)
 	for _, device := range fingerprintDevices {
-		deviceName := device.DeviceName
-		if deviceName == nil {
-			// nvml driver was not able to detect device name. This kind
-			// of devices are placed to single group with 'notAvailable' name
-			notAvailableCopy := notAvailable
-			deviceName = &notAvailableCopy
-		}
-
-		deviceListByDeviceName[*deviceName] = append(deviceListByDeviceName[*deviceName], device)
+		groupName := d.groupName(device.DeviceName)
+		deviceListByDeviceName[groupName] = append(deviceListByDeviceName[groupName], device)
 	}
 
-	// Build Fingerprint response with computed groups and send it over the channel
+	// Build Fingerprint response with computed groups and send it over the
+	// channel, groups and their devices are sorted so that responses are
+	// deterministic
 	deviceGroups := make([]*device.DeviceGroup, 0, len(deviceListByDeviceName))
 	for groupName, devices := range deviceListByDeviceName {
-		deviceGroups = append(deviceGroups, deviceGroupFromFingerprintData(groupName, devices, commonAttributes))
+		sort.Slice(devices, func(i, j int) bool {
+			return devices[i].UUID < devices[j].UUID
+		})
+		deviceGroup := d.deviceGroupFromFingerprintData(groupName, devices, commonAttributes)
+		for attributeKey, attributeValue := range p2pAttributes(devices, fingerprintData.P2P) {
+			deviceGroup.Attributes[attributeKey] = attributeValue
+		}
+		for attributeKey, attributeValue := range d.sriovAttributes(devices) {
+			deviceGroup.Attributes[attributeKey] = attributeValue
+		}
+		deviceGroups = append(deviceGroups, deviceGroup)
+	}
+	sort.Slice(deviceGroups, func(i, j int) bool {
+		return deviceGroups[i].Name < deviceGroups[j].Name
+	})
+	d.recheckLeftoverProcesses()
+	d.checkIsolation()
+	d.applyDeviceHealth(deviceGroups)
+	d.writeHealthStatus(deviceGroups)
+	d.updateTopology(fingerprintData)
+
+	// Extend every group with the summary of all devices on this node
+	ignoredCount := len(fingerprintData.Devices) - len(fingerprintDevices)
+	summary := summaryAttributes(deviceGroups, fingerprintDevices, ignoredCount)
+	if d.preflightCheck {
+		summary[DevicesPreflightFailedAttr] = &structs.Attribute{
+			Int: pointer.Of(int64(preflightFailedCount)),
+		}
+	}
+	for attributeKey, attributeValue := range d.checkDevicePresence(slices.Concat(fingerprintData.Devices, fingerprintData.MIGParents)) {
+		summary[attributeKey] = attributeValue
+	}
+	// MIG parents are added once the summary is computed, so that they are
+	// not counted as devices of the node
+	if d.includeMIGParents {
+		deviceGroups = append(deviceGroups, d.migParentGroups(fingerprintData.MIGParents, commonAttributes)...)
+	}
+	for _, deviceGroup := range deviceGroups {
+		for attributeKey, attributeValue := range summary {
+			deviceGroup.Attributes[attributeKey] = attributeValue
+		}
+	}
+	d.redactAttributes(deviceGroups)
+	d.audit.setFingerprint(deviceGroups)
+
+	// only send the response if its content differs from the last one sent,
+	// so attribute changes are caught while redundant writes are suppressed
+	hash, err := hashDeviceGroups(deviceGroups)
+	if err != nil {
+		d.logger.Warn("failed to hash fingerprint response", "error", err)
+	} else if hash == lastHash {
+		return nil, lastHash
+	}
+	return device.NewFingerprint(deviceGroups...), hash
+}
+
+// groupName returns the name of the device group of devices with the given
+// name. Devices whose name NVML was not able to detect are placed in a single
+// group with the 'notAvailable' name, and all devices are placed in the
+// FlatGroupName group when groups are flattened. Group names are replaced by
+// the ones configured in group_names.
+func (d *NvidiaDevice) groupName(deviceName *string) string {
+	name := FlatGroupName
+	switch {
+	case d.flattenGroups:
+	case deviceName == nil:
+		name = notAvailable
+	default:
+		name = *deviceName
+	}
+	if renamed, ok := d.groupNames[name]; ok {
+		return renamed
+	}
+	return name
+}
+
+// driverBranch returns the release branch of the given driver version, such
+// as "R535" for "535.104.05", and whether the version could be parsed
+func driverBranch(version string) (string, bool) {
+	major, _, _ := strings.Cut(strings.TrimSpace(version), ".")
+	branch, err := strconv.ParseUint(major, 10, 32)
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("R%d", branch), true
+}
+
+// checkDriverVersion warns once per driver version when the installed driver
+// is older than minimumDriverVersion
+func (d *NvidiaDevice) checkDriverVersion(driverVersion string) {
+	if driverVersion == d.checkedDriverVersion {
+		return
+	}
+	d.checkedDriverVersion = driverVersion
+
+	if older, ok := versionOlder(driverVersion, minimumDriverVersion); ok && older {
+		d.logger.Warn("Nvidia driver is older than the minimum supported version, some features may not work",
+			"driver_version", driverVersion, "minimum_version", minimumDriverVersion)
+	}
+}
+
+// versionOlder reports whether the dotted numeric version a is older than b,
+// and whether both versions could be parsed
+func versionOlder(a, b string) (bool, bool) {
+	aParts := strings.Split(strings.TrimSpace(a), ".")
+	bParts := strings.Split(strings.TrimSpace(b), ".")
+	for i := 0; i < max(len(aParts), len(bParts)); i++ {
+		var aPart, bPart uint64
+		var err error
+		if i < len(aParts) {
+			if aPart, err = strconv.ParseUint(aParts[i], 10, 32); err != nil {
+				return false, false
+			}
+		}
+		if i < len(bParts) {
+			if bPart, err = strconv.ParseUint(bParts[i], 10, 32); err != nil {
+				return false, false
+			}
+		}
+		if aPart != bPart {
+			return aPart < bPart, true
+		}
+	}
+	return false, true
+}
+
+// nvdecCodecs are the codecs decoded by the NVDEC of each GPU architecture,
+// as documented in the Nvidia video codec SDK support matrix. Only some
+// Maxwell GPUs decode HEVC, so it is not reported for Maxwell.
+var nvdecCodecs = map[string][]string{
+	"kepler":    {H264DecodeAttr},
+	"maxwell":   {H264DecodeAttr},
+	"pascal":    {H264DecodeAttr, HEVCDecodeAttr},
+	"volta":     {H264DecodeAttr, HEVCDecodeAttr},
+	"turing":    {H264DecodeAttr, HEVCDecodeAttr},
+	"ampere":    {H264DecodeAttr, HEVCDecodeAttr, AV1DecodeAttr},
+	"ada":       {H264DecodeAttr, HEVCDecodeAttr, AV1DecodeAttr},
+	"hopper":    {H264DecodeAttr, HEVCDecodeAttr, AV1DecodeAttr},
+	"blackwell": {H264DecodeAttr, HEVCDecodeAttr, AV1DecodeAttr},
+}
+
+// codecSupport returns whether the device encodes and decodes each codec in
+// hardware, keyed by attribute name. Encoding is supported when NVML reports
+// an encoder capacity for the codec, which GPUs without NVENC such as the
+// A100 and H100 do not. Decoding is inferred from the architecture of the
+// device, and is not reported for unknown architectures.
+func codecSupport(d *nvml.FingerprintDeviceData) map[string]bool {
+	support := map[string]bool{
+		H264EncodeAttr: d.EncoderCapacityH264 != nil,
+		HEVCEncodeAttr: d.EncoderCapacityHEVC != nil,
+		AV1EncodeAttr:  d.EncoderCapacityAV1 != nil,
+	}
+	if d.Architecture == nil {
+		return support
+	}
+	codecs, ok := nvdecCodecs[*d.Architecture]
+	if !ok {
+		return support
+	}
+	for _, attr := range []string{H264DecodeAttr, HEVCDecodeAttr, AV1DecodeAttr} {
+		support[attr] = slices.Contains(codecs, attr)
+	}
+	return support
+}
+
+// hashDeviceGroups computes a content hash over the given device groups. The
+// groups are expected to be sorted; attribute maps are serialized with sorted
+// keys so equal content always produces an equal hash.
+func hashDeviceGroups(deviceGroups []*device.DeviceGroup) (string, error) {
+	b, err := json.Marshal(deviceGroups)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// summaryAttributes computes node level attributes summarizing the number of
+// exposed, healthy and ignored devices and the total memory of exposed devices
+func summaryAttributes(deviceGroups []*device.DeviceGroup, deviceData []*nvml.FingerprintDeviceData, ignoredCount int) map[string]*structs.Attribute {
+	var total, healthy int64
+	for _, deviceGroup := range deviceGroups {
+		for _, dev := range deviceGroup.Devices {
+			total++
+			if dev.Healthy {
+				healthy++
+			}
+		}
+	}
+
+	var memoryMiB uint64
+	for _, dev := range deviceData {
+		if dev.MemoryMiB != nil {
+			memoryMiB += *dev.MemoryMiB
+		}
+	}
+
+	return map[string]*structs.Attribute{
+		DevicesTotalAttr: {
+			Int: pointer.Of(total),
+		},
+		DevicesHealthyAttr: {
+			Int: pointer.Of(healthy),
+		},
+		DevicesIgnoredAttr: {
+			Int: pointer.Of(int64(ignoredCount)),
+		},
+		DevicesMemoryAttr: {
+			Int:  pointer.Of(int64(memoryMiB)),
+			Unit: structs.UnitMiB,
+		},
 	}
-	devices <- device.NewFingerprint(deviceGroups...)
 }
 
 // ignoreFingerprintedDevices excludes ignored devices from fingerprint output
@@ -110,38 +577,102 @@ func ignoreFingerprintedDevices(deviceData []*nvml.FingerprintDeviceData, ignore
 	return result
 }
 
-// fingerprintChanged checks if there are any previously unseen nvidia devices located
-// or any of fingerprinted nvidia devices disappeared since the last fingerprint run.
-// Also, this func updates device map on NvidiaDevice with the latest data
-func (d *NvidiaDevice) fingerprintChanged(allDevices []*nvml.FingerprintDeviceData) bool {
+// ignoreDisplayDevices excludes devices with a display attached from
+// fingerprint output and records them so that reserving them is rejected
+func (d *NvidiaDevice) ignoreDisplayDevices(deviceData []*nvml.FingerprintDeviceData) []*nvml.FingerprintDeviceData {
+	var result []*nvml.FingerprintDeviceData
+	displayGPUs := make(map[string]struct{})
+	for _, fingerprintDevice := range deviceData {
+		if fingerprintDevice.DisplayState == nvml.DisplayEnabled {
+			displayGPUs[fingerprintDevice.UUID] = struct{}{}
+			continue
+		}
+		result = append(result, fingerprintDevice)
+	}
+
 	d.deviceLock.Lock()
 	defer d.deviceLock.Unlock()
 
-	changeDetected := false
-	// check if every device in allDevices is in d.devices
-	for _, device := range allDevices {
-		if _, ok := d.devices[device.UUID]; !ok {
-			changeDetected = true
+	for uuid := range displayGPUs {
+		if _, ok := d.displayGPUs[uuid]; !ok {
+			d.logger.Info("ignoring device with a display attached", "uuid", uuid)
 		}
 	}
+	d.displayGPUs = displayGPUs
+	return result
+}
+
+// updateDevices records allDevices as the devices fingerprinted by the last
+// run, along with the physical parent of MIG devices and the PCI bus ID of
+// every device
+func (d *NvidiaDevice) updateDevices(allDevices []*nvml.FingerprintDeviceData) {
+	d.deviceLock.Lock()
+	defer d.deviceLock.Unlock()
 
-	// check if every device in d.devices is in allDevices
 	fingerprintDeviceMap := make(map[string]struct{})
+	migParents := make(map[string]string)
+	pciBusIDs := make(map[string]string)
 	for _, device := range allDevices {
 		fingerprintDeviceMap[device.UUID] = struct{}{}
-	}
-	for id := range d.devices {
-		if _, ok := fingerprintDeviceMap[id]; !ok {
-			changeDetected = true
+		if device.ParentUUID != "" {
+			migParents[device.UUID] = device.ParentUUID
+		}
+		if device.PCIBusID != "" {
+			pciBusIDs[device.UUID] = device.PCIBusID
 		}
 	}
 
 	d.devices = fingerprintDeviceMap
-	return changeDetected
+	d.migParents = migParents
+	d.pciBusIDs = pciBusIDs
+}
+
+// detectAttributeDrift compares the attributes of every fingerprinted device
+// with the ones seen during the previous fingerprint run and logs the devices
+// whose attributes changed, such as persistence mode or applications clocks.
+// The attributes seen are kept for the next run. The changes reach Nomad as
+// the attributes of the device groups reflect every device.
+func (d *NvidiaDevice) detectAttributeDrift(allDevices []*nvml.FingerprintDeviceData) {
+	latestAttributes := make(map[string]map[string]*structs.Attribute, len(allDevices))
+	for _, device := range allDevices {
+		attrs := d.collector.DeviceAttributes(device)
+		latestAttributes[device.UUID] = attrs
+
+		previousAttrs, ok := d.deviceAttributes[device.UUID]
+		if !ok {
+			continue
+		}
+		if changes := changedAttributes(previousAttrs, attrs); len(changes) != 0 {
+			d.logger.Info("device attributes changed", "uuid", device.UUID, "changes", changes)
+		}
+	}
+
+	d.deviceAttributes = latestAttributes
+}
+
+// changedAttributes returns a sorted description of every attribute that was
+// added, removed or modified between the old and new attribute maps
+func changedAttributes(oldAttrs, newAttrs map[string]*structs.Attribute) []string {
+	var changes []string
+	for name, oldAttr := range oldAttrs {
+		newAttr, ok := newAttrs[name]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("%s: %s -> <nil>", name, oldAttr.GoString()))
+		} else if oldAttr.GoString() != newAttr.GoString() {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, oldAttr.GoString(), newAttr.GoString()))
+		}
+	}
+	for name, newAttr := range newAttrs {
+		if _, ok := oldAttrs[name]; !ok {
+			changes = append(changes, fmt.Sprintf("%s: <nil> -> %s", name, newAttr.GoString()))
+		}
+	}
+	sort.Strings(changes)
+	return changes
 }
 
 // deviceGroupFromFingerprintData composes deviceGroup from FingerprintDeviceData slice
-func deviceGroupFromFingerprintData(groupName string, deviceList []*nvml.FingerprintDeviceData, commonAttributes map[string]*structs.Attribute) *device.DeviceGroup {
+func (d *NvidiaDevice) deviceGroupFromFingerprintData(groupName string, deviceList []*nvml.FingerprintDeviceData, commonAttributes map[string]*structs.Attribute) *device.DeviceGroup {
 	// deviceGroup without devices makes no sense -> return nil when no devices are provided
 	if len(deviceList) == 0 {
 		return nil
@@ -150,9 +681,7 @@ func deviceGroupFromFingerprintData(groupName string, deviceList []*nvml.Fingerp
 	devices := make([]*device.Device, len(deviceList))
 	for index, dev := range deviceList {
 		devices[index] = &device.Device{
-			ID: dev.UUID,
-			// all fingerprinted devices are "healthy" for now
-			// to get real health data -> dcgm bindings should be used
+			ID:      dev.UUID,
 			Healthy: true,
 			HwLocality: &device.DeviceLocality{
 				PciBusID: dev.PCIBusID,
@@ -161,13 +690,49 @@ func deviceGroupFromFingerprintData(groupName string, deviceList []*nvml.Fingerp
 	}
 
 	deviceGroup := &device.DeviceGroup{
-		Vendor:  vendor,
-		Type:    deviceType,
-		Name:    groupName,
-		Devices: devices,
-		// Assumption made that devices with the same DeviceName have the same
-		// attributes like amount of memory, power, bar1memory etc
-		Attributes: attributesFromFingerprintDeviceData(deviceList[0]),
+		Vendor:     d.vendor().Name,
+		Type:       d.vendor().DeviceType,
+		Name:       groupName,
+		Devices:    devices,
+		Attributes: d.groupAttributes(deviceList),
+	}
+
+	// Extend attribute map with per device attributes
+	if indexes := perDeviceAttribute(deviceList, func(dev *nvml.FingerprintDeviceData) (string, bool) {
+		if dev.Index == nil {
+			return "", false
+		}
+		return strconv.FormatUint(uint64(*dev.Index), 10), true
+	}); indexes != nil {
+		deviceGroup.Attributes[IndexAttr] = indexes
+	}
+	// OEM variants of the same GPU share a group but differ in subsystem ID
+	if subsystems := perDeviceAttribute(deviceList, func(dev *nvml.FingerprintDeviceData) (string, bool) {
+		if dev.PCISubsystemID == nil {
+			return "", false
+		}
+		return fmt.Sprintf("0x%08X", *dev.PCISubsystemID), true
+	}); subsystems != nil {
+		deviceGroup.Attributes[PCISubsystemIDAttr] = subsystems
+	}
+	// MIG devices are linked to their physical GPU for anti-affinity
+	if parents := perDeviceAttribute(deviceList, func(dev *nvml.FingerprintDeviceData) (string, bool) {
+		return dev.ParentUUID, dev.ParentUUID != ""
+	}); parents != nil {
+		deviceGroup.Attributes[ParentGPUUUIDAttr] = parents
+		deviceGroup.Attributes[MIGParentGPUsAttr] = &structs.Attribute{
+			Int: pointer.Of(int64(len(distinctParents(deviceList)))),
+		}
+	}
+
+	if score, ok := d.performanceScore(groupName, deviceList); ok {
+		deviceGroup.Attributes[PerformanceScoreAttr] = &structs.Attribute{
+			Int: pointer.Of(score),
+		}
+	}
+
+	for attributeKey, attributeValue := range groupCapacityAttributes(deviceList) {
+		deviceGroup.Attributes[attributeKey] = attributeValue
 	}
 
 	// Extend attribute map with common attributes
@@ -178,6 +743,130 @@ func deviceGroupFromFingerprintData(groupName string, deviceList []*nvml.Fingerp
 	return deviceGroup
 }
 
+// runtimeAttributes are the device attributes that can be changed at runtime,
+// such as with nvidia-smi, so they can differ between devices of the same
+// model
+var runtimeAttributes = map[string]struct{}{
+	PersistenceModeAttr:        {},
+	DisplayStateAttr:           {},
+	ApplicationCoresClockAttr:  {},
+	ApplicationMemoryClockAttr: {},
+}
+
+// groupAttributes returns the attributes of the devices of a group. Devices
+// with the same DeviceName are assumed to have the same attributes like amount
+// of memory, power, bar1memory etc, so the attributes of the first device are
+// used, except for runtimeAttributes which are encoded per device when they
+// differ. Flattened groups mix models, so every attribute that differs
+// between their devices is encoded per device, and their model is reported.
+func (d *NvidiaDevice) groupAttributes(deviceList []*nvml.FingerprintDeviceData) map[string]*structs.Attribute {
+	deviceAttributes := make(map[string]map[string]*structs.Attribute, len(deviceList))
+	keys := make(map[string]struct{})
+	for _, dev := range deviceList {
+		attrs := d.collector.DeviceAttributes(dev)
+		if d.flattenGroups {
+			model := notAvailable
+			if dev.DeviceName != nil {
+				model = *dev.DeviceName
+			}
+			attrs[ModelAttr] = &structs.Attribute{
+				String: pointer.Of(model),
+			}
+		}
+		deviceAttributes[dev.UUID] = attrs
+		for key := range attrs {
+			keys[key] = struct{}{}
+		}
+	}
+
+	merged := make(map[string]*structs.Attribute, len(keys))
+	for key := range keys {
+		first, shared := deviceAttributes[deviceList[0].UUID][key]
+		for _, dev := range deviceList[1:] {
+			if !shared {
+				break
+			}
+			attr, ok := deviceAttributes[dev.UUID][key]
+			shared = ok && attr.GoString() == first.GoString()
+		}
+		if shared {
+			merged[key] = first
+			continue
+		}
+		if _, ok := runtimeAttributes[key]; !ok && !d.flattenGroups {
+			if first != nil {
+				merged[key] = first
+			}
+			continue
+		}
+		merged[key] = perDeviceAttribute(deviceList, func(dev *nvml.FingerprintDeviceData) (string, bool) {
+			attr, ok := deviceAttributes[dev.UUID][key]
+			if !ok {
+				return "", false
+			}
+			return attr.GoString(), true
+		})
+	}
+	return merged
+}
+
+// groupCapacityAttributes computes the number of devices in deviceList and
+// their total memory. The memory is left out when no device reports it.
+func groupCapacityAttributes(deviceList []*nvml.FingerprintDeviceData) map[string]*structs.Attribute {
+	attrs := map[string]*structs.Attribute{
+		GroupDevicesAttr: {
+			Int: pointer.Of(int64(len(deviceList))),
+		},
+	}
+
+	var memoryMiB uint64
+	var memoryCount int
+	for _, dev := range deviceList {
+		if dev.MemoryMiB != nil {
+			memoryMiB += *dev.MemoryMiB
+			memoryCount++
+		}
+	}
+	if memoryCount != 0 {
+		attrs[GroupMemoryAttr] = &structs.Attribute{
+			Int:  pointer.Of(int64(memoryMiB)),
+			Unit: structs.UnitMiB,
+		}
+	}
+	return attrs
+}
+
+// distinctParents returns the set of physical GPU UUIDs of the MIG devices in
+// deviceList
+func distinctParents(deviceList []*nvml.FingerprintDeviceData) map[string]struct{} {
+	parents := make(map[string]struct{})
+	for _, dev := range deviceList {
+		if dev.ParentUUID != "" {
+			parents[dev.ParentUUID] = struct{}{}
+		}
+	}
+	return parents
+}
+
+// perDeviceAttribute encodes a value that differs between the devices of a
+// group, such as the device index, as a single string attribute made of comma
+// separated "<UUID>=<value>" pairs ordered like deviceList. Devices for which
+// value returns false are skipped, nil is returned when no device has a value
+func perDeviceAttribute(deviceList []*nvml.FingerprintDeviceData, value func(*nvml.FingerprintDeviceData) (string, bool)) *structs.Attribute {
+	pairs := make([]string, 0, len(deviceList))
+	for _, dev := range deviceList {
+		if v, ok := value(dev); ok {
+			pairs = append(pairs, dev.UUID+"="+v)
+		}
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+	return &structs.Attribute{
+		String: pointer.Of(strings.Join(pairs, ",")),
+	}
+}
+
 // attributesFromFingerprintDeviceData converts nvml.FingerprintDeviceData
 // struct to device.DeviceGroup.Attributes format (map[string]string)
 // this function performs all nil checks for FingerprintDeviceData pointers
@@ -189,6 +878,9 @@ func attributesFromFingerprintDeviceData(d *nvml.FingerprintDeviceData) map[stri
 		PersistenceModeAttr: {
 			String: pointer.Of(d.PersistenceMode),
 		},
+		MIGParentAttr: {
+			Bool: pointer.Of(d.MIGParent),
+		},
 	}
 
 	if d.MemoryMiB != nil {
@@ -221,12 +913,194 @@ func attributesFromFingerprintDeviceData(d *nvml.FingerprintDeviceData) map[stri
 			Unit: structs.UnitMHz,
 		}
 	}
+	if d.ApplicationCoresClockMHz != nil {
+		attrs[ApplicationCoresClockAttr] = &structs.Attribute{
+			Int:  pointer.Of(int64(*d.ApplicationCoresClockMHz)),
+			Unit: structs.UnitMHz,
+		}
+	}
+	if d.ApplicationMemoryClockMHz != nil {
+		attrs[ApplicationMemoryClockAttr] = &structs.Attribute{
+			Int:  pointer.Of(int64(*d.ApplicationMemoryClockMHz)),
+			Unit: structs.UnitMHz,
+		}
+	}
 	if d.PCIBandwidthMBPerS != nil {
 		attrs[PCIBandwidthAttr] = &structs.Attribute{
 			Int:  pointer.Of(int64(*d.PCIBandwidthMBPerS)),
 			Unit: structs.UnitMBPerS,
 		}
 	}
+	if d.PCIDeviceID != nil {
+		attrs[PCIVendorIDAttr] = &structs.Attribute{
+			String: pointer.Of(fmt.Sprintf("0x%04X", *d.PCIDeviceID&0xFFFF)),
+		}
+		attrs[PCIDeviceIDAttr] = &structs.Attribute{
+			String: pointer.Of(fmt.Sprintf("0x%04X", *d.PCIDeviceID>>16)),
+		}
+	}
+	if d.PCILinkGeneration != nil {
+		attrs[PCILinkGenerationAttr] = &structs.Attribute{
+			Int: pointer.Of(int64(*d.PCILinkGeneration)),
+		}
+	}
+	if d.PCILinkWidth != nil {
+		attrs[PCILinkWidthAttr] = &structs.Attribute{
+			Int: pointer.Of(int64(*d.PCILinkWidth)),
+		}
+	}
+	if d.EncoderCapacityH264 != nil {
+		attrs[EncoderCapacityH264Attr] = &structs.Attribute{
+			Int:  pointer.Of(int64(*d.EncoderCapacityH264)),
+			Unit: UnitPercent,
+		}
+	}
+	if d.EncoderCapacityHEVC != nil {
+		attrs[EncoderCapacityHEVCAttr] = &structs.Attribute{
+			Int:  pointer.Of(int64(*d.EncoderCapacityHEVC)),
+			Unit: UnitPercent,
+		}
+	}
+	if d.EncoderCapacityAV1 != nil {
+		attrs[EncoderCapacityAV1Attr] = &structs.Attribute{
+			Int:  pointer.Of(int64(*d.EncoderCapacityAV1)),
+			Unit: UnitPercent,
+		}
+	}
+	if d.GSPFirmwareMode != nil {
+		attrs[GSPFirmwareModeAttr] = &structs.Attribute{
+			String: pointer.Of(*d.GSPFirmwareMode),
+		}
+	}
+	if d.GSPFirmwareVersion != nil {
+		attrs[GSPFirmwareVersionAttr] = &structs.Attribute{
+			String: pointer.Of(*d.GSPFirmwareVersion),
+		}
+	}
+	if d.InfoROMVersionOEM != nil {
+		attrs[InfoROMVersionOEMAttr] = &structs.Attribute{
+			String: pointer.Of(*d.InfoROMVersionOEM),
+		}
+	}
+	if d.InfoROMVersionECC != nil {
+		attrs[InfoROMVersionECCAttr] = &structs.Attribute{
+			String: pointer.Of(*d.InfoROMVersionECC),
+		}
+	}
+	if d.InfoROMVersionPower != nil {
+		attrs[InfoROMVersionPowerAttr] = &structs.Attribute{
+			String: pointer.Of(*d.InfoROMVersionPower),
+		}
+	}
+	if d.OperationMode != nil {
+		attrs[OperationModeAttr] = &structs.Attribute{
+			String: pointer.Of(*d.OperationMode),
+		}
+	}
+	if d.VirtualizationMode != nil {
+		attrs[VirtualizationModeAttr] = &structs.Attribute{
+			String: pointer.Of(*d.VirtualizationMode),
+		}
+	}
+	if d.Brand != nil {
+		attrs[BrandAttr] = &structs.Attribute{
+			String: pointer.Of(*d.Brand),
+		}
+	}
+	if d.Architecture != nil {
+		attrs[ArchitectureAttr] = &structs.Attribute{
+			String: pointer.Of(*d.Architecture),
+		}
+		for attr, supported := range codecSupport(d) {
+			attrs[attr] = &structs.Attribute{
+				Bool: pointer.Of(supported),
+			}
+		}
+	}
+	if d.ComputeCapability != nil {
+		attrs[ComputeCapabilityAttr] = &structs.Attribute{
+			String: pointer.Of(*d.ComputeCapability),
+		}
+	}
+	if d.FanCount != nil {
+		attrs[FanCountAttr] = &structs.Attribute{
+			Int: pointer.Of(int64(*d.FanCount)),
+		}
+	}
+	if d.FanSpeedMin != nil {
+		attrs[FanSpeedMinAttr] = &structs.Attribute{
+			Int:  pointer.Of(int64(*d.FanSpeedMin)),
+			Unit: UnitPercent,
+		}
+	}
+	if d.FanSpeedMax != nil {
+		attrs[FanSpeedMaxAttr] = &structs.Attribute{
+			Int:  pointer.Of(int64(*d.FanSpeedMax)),
+			Unit: UnitPercent,
+		}
+	}
+	if d.FanControl != nil {
+		attrs[FanControlAttr] = &structs.Attribute{
+			String: pointer.Of(*d.FanControl),
+		}
+	}
+	if d.CoherentMemory != nil {
+		attrs[CoherentMemoryAttr] = &structs.Attribute{
+			Bool: pointer.Of(*d.CoherentMemory),
+		}
+	}
+	if d.NUMANode != nil {
+		attrs[NUMANodeAttr] = &structs.Attribute{
+			Int: pointer.Of(int64(*d.NUMANode)),
+		}
+	}
+	if d.MemoryNUMANode != nil {
+		attrs[MemoryNUMANodeAttr] = &structs.Attribute{
+			Int: pointer.Of(int64(*d.MemoryNUMANode)),
+		}
+	}
+	if d.C2CLinks != nil {
+		attrs[C2CLinksAttr] = &structs.Attribute{
+			Int: pointer.Of(int64(*d.C2CLinks)),
+		}
+	}
+	if d.C2CBandwidthMBps != nil {
+		attrs[C2CBandwidthAttr] = &structs.Attribute{
+			Int:  pointer.Of(int64(*d.C2CBandwidthMBps)),
+			Unit: structs.UnitMBPerS,
+		}
+	}
+	if d.MaxOperatingTemperatureC != nil {
+		attrs[MaxOperatingTemperatureAttr] = &structs.Attribute{
+			Int:  pointer.Of(int64(*d.MaxOperatingTemperatureC)),
+			Unit: UnitCelsius,
+		}
+	}
+	if d.AcousticTemperatureC != nil {
+		attrs[AcousticTemperatureAttr] = &structs.Attribute{
+			Int:  pointer.Of(int64(*d.AcousticTemperatureC)),
+			Unit: UnitCelsius,
+		}
+	}
+	if d.VGPULicensed != nil {
+		attrs[VGPULicensedAttr] = &structs.Attribute{
+			Bool: d.VGPULicensed,
+		}
+	}
+	if d.VGPULicenseExpiry != nil {
+		attrs[VGPULicenseExpiryAttr] = &structs.Attribute{
+			String: pointer.Of(d.VGPULicenseExpiry.UTC().Format(time.RFC3339)),
+		}
+	}
+	if len(d.MIGProfiles) != 0 {
+		profiles := make([]string, len(d.MIGProfiles))
+		for i, profile := range d.MIGProfiles {
+			profiles[i] = fmt.Sprintf("%s=%d", profile.Name, profile.InstanceCount)
+		}
+		attrs[MIGProfilesAttr] = &structs.Attribute{
+			String: pointer.Of(strings.Join(profiles, ",")),
+		}
+	}
 
 	return attrs
 }
@@ -6,11 +6,13 @@ package nvidia
 import (
 	"context"
 	"errors"
-	"sort"
+	"strconv"
 	"testing"
+	"time"
 
 	hclog "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad-device-nvidia/version"
 	"github.com/hashicorp/nomad/helper/pointer"
 	"github.com/hashicorp/nomad/plugins/device"
 	"github.com/hashicorp/nomad/plugins/shared/structs"
@@ -208,13 +210,36 @@ func TestIgnoreFingerprintedDevices(t *testing.T) {
 	}
 }
 
-func TestCheckFingerprintUpdates(t *testing.T) {
+func TestIgnoreDisplayDevices(t *testing.T) {
+	d := &NvidiaDevice{collector: &MockNvmlClient{}, logger: hclog.NewNullLogger()}
+	deviceData := []*nvml.FingerprintDeviceData{
+		{
+			DeviceData:   &nvml.DeviceData{UUID: "UUID1"},
+			DisplayState: "Disabled",
+		},
+		{
+			DeviceData:   &nvml.DeviceData{UUID: "UUID2"},
+			DisplayState: nvml.DisplayEnabled,
+		},
+	}
+
+	result := d.ignoreDisplayDevices(deviceData)
+	must.Eq(t, deviceData[:1], result)
+	must.Eq(t, map[string]struct{}{"UUID2": {}}, d.displayGPUs)
+
+	// the display is detached
+	deviceData[1].DisplayState = "Disabled"
+	result = d.ignoreDisplayDevices(deviceData)
+	must.Eq(t, deviceData, result)
+	must.MapEmpty(t, d.displayGPUs)
+}
+
+func TestUpdateDevices(t *testing.T) {
 	for _, testCase := range []struct {
 		Name                     string
 		Device                   *NvidiaDevice
 		AllDevices               []*nvml.FingerprintDeviceData
 		DeviceMapAfterMethodCall map[string]struct{}
-		ExpectedResult           bool
 	}{
 		{
 			Name: "No updates",
@@ -240,7 +265,6 @@ func TestCheckFingerprintUpdates(t *testing.T) {
 					},
 				},
 			},
-			ExpectedResult: false,
 			DeviceMapAfterMethodCall: map[string]struct{}{
 				"1": {},
 				"2": {},
@@ -276,7 +300,6 @@ func TestCheckFingerprintUpdates(t *testing.T) {
 					},
 				},
 			},
-			ExpectedResult: true,
 			DeviceMapAfterMethodCall: map[string]struct{}{
 				"1":        {},
 				"2":        {},
@@ -303,7 +326,6 @@ func TestCheckFingerprintUpdates(t *testing.T) {
 					},
 				},
 			},
-			ExpectedResult: true,
 			DeviceMapAfterMethodCall: map[string]struct{}{
 				"1": {},
 				"2": {},
@@ -329,7 +351,6 @@ func TestCheckFingerprintUpdates(t *testing.T) {
 					},
 				},
 			},
-			ExpectedResult: true,
 			DeviceMapAfterMethodCall: map[string]struct{}{
 				"1": {},
 				"2": {},
@@ -344,20 +365,263 @@ func TestCheckFingerprintUpdates(t *testing.T) {
 				"3": {},
 			}},
 			AllDevices:               nil,
-			ExpectedResult:           true,
 			DeviceMapAfterMethodCall: map[string]struct{}{},
 		},
 	} {
 		t.Run(testCase.Name, func(t *testing.T) {
-			actualResult := testCase.Device.fingerprintChanged(testCase.AllDevices)
-			// check that function returns valid "updated / not updated" state
-			must.Eq(t, testCase.ExpectedResult, actualResult)
-			// check that function propely updates devices map
+			testCase.Device.updateDevices(testCase.AllDevices)
 			must.Eq(t, testCase.Device.devices, testCase.DeviceMapAfterMethodCall)
 		})
 	}
 }
 
+func TestDetectAttributeDrift(t *testing.T) {
+	newDevice := func(uuid, persistenceMode string, appClock uint) *nvml.FingerprintDeviceData {
+		return &nvml.FingerprintDeviceData{
+			DeviceData: &nvml.DeviceData{
+				UUID:       uuid,
+				DeviceName: pointer.Of("Name"),
+			},
+			ApplicationCoresClockMHz: pointer.Of(appClock),
+			DisplayState:             "Enabled",
+			PersistenceMode:          persistenceMode,
+		}
+	}
+
+	d := &NvidiaDevice{collector: &MockNvmlClient{}, logger: hclog.NewNullLogger()}
+
+	// the attributes of every device are recorded for the next run
+	d.detectAttributeDrift([]*nvml.FingerprintDeviceData{
+		newDevice("1", "Enabled", 1000),
+		newDevice("2", "Enabled", 1000),
+	})
+	must.MapLen(t, 2, d.deviceAttributes)
+
+	// persistence mode changed on a device that is not the first of its group
+	d.detectAttributeDrift([]*nvml.FingerprintDeviceData{
+		newDevice("1", "Enabled", 1000),
+		newDevice("2", "Disabled", 1000),
+	})
+	must.Eq(t, "Disabled", *d.deviceAttributes["2"][PersistenceModeAttr].String)
+
+	// devices that are gone are forgotten
+	d.detectAttributeDrift([]*nvml.FingerprintDeviceData{
+		newDevice("1", "Enabled", 1200),
+	})
+	must.MapLen(t, 1, d.deviceAttributes)
+	must.Eq(t, int64(1200), *d.deviceAttributes["1"][ApplicationCoresClockAttr].Int)
+}
+
+func TestGroupAttributesDrift(t *testing.T) {
+	newDevice := func(uuid, persistenceMode string) *nvml.FingerprintDeviceData {
+		return &nvml.FingerprintDeviceData{
+			DeviceData: &nvml.DeviceData{
+				UUID:       uuid,
+				DeviceName: pointer.Of("Name"),
+			},
+			DisplayState:    "Enabled",
+			PersistenceMode: persistenceMode,
+		}
+	}
+
+	d := &NvidiaDevice{collector: &MockNvmlClient{}, logger: hclog.NewNullLogger()}
+
+	// attributes shared by every device are reported once
+	attrs := d.groupAttributes([]*nvml.FingerprintDeviceData{
+		newDevice("1", "Enabled"),
+		newDevice("2", "Enabled"),
+	})
+	must.Eq(t, "Enabled", *attrs[PersistenceModeAttr].String)
+	must.MapNotContainsKey(t, attrs, ModelAttr)
+
+	// a change on a device that is not the first of its group is reported
+	attrs = d.groupAttributes([]*nvml.FingerprintDeviceData{
+		newDevice("1", "Enabled"),
+		newDevice("2", "Disabled"),
+	})
+	must.Eq(t, "1=Enabled,2=Disabled", *attrs[PersistenceModeAttr].String)
+	must.Eq(t, "Enabled", *attrs[DisplayStateAttr].String)
+}
+
+func TestChangedAttributes(t *testing.T) {
+	oldAttrs := map[string]*structs.Attribute{
+		PersistenceModeAttr:       {String: pointer.Of("Enabled")},
+		CoresClockAttr:            {Int: pointer.Of(int64(1000)), Unit: structs.UnitMHz},
+		ApplicationCoresClockAttr: {Int: pointer.Of(int64(900)), Unit: structs.UnitMHz},
+	}
+	newAttrs := map[string]*structs.Attribute{
+		PersistenceModeAttr:        {String: pointer.Of("Disabled")},
+		CoresClockAttr:             {Int: pointer.Of(int64(1000)), Unit: structs.UnitMHz},
+		ApplicationMemoryClockAttr: {Int: pointer.Of(int64(800)), Unit: structs.UnitMHz},
+	}
+
+	must.Eq(t, []string{
+		"application_cores_clock: 900MHz -> <nil>",
+		"application_memory_clock: <nil> -> 800MHz",
+		"persistence_mode: Enabled -> Disabled",
+	}, changedAttributes(oldAttrs, newAttrs))
+	must.SliceEmpty(t, changedAttributes(oldAttrs, oldAttrs))
+}
+
+func TestDriverBranch(t *testing.T) {
+	for _, testCase := range []struct {
+		Version        string
+		ExpectedBranch string
+		ExpectedOK     bool
+	}{
+		{Version: "535.104.05", ExpectedBranch: "R535", ExpectedOK: true},
+		{Version: "550.54.14", ExpectedBranch: "R550", ExpectedOK: true},
+		{Version: "470.239.06", ExpectedBranch: "R470", ExpectedOK: true},
+		{Version: "560", ExpectedBranch: "R560", ExpectedOK: true},
+		{Version: " 570.86.15\n", ExpectedBranch: "R570", ExpectedOK: true},
+		{Version: "0418.40.04", ExpectedBranch: "R418", ExpectedOK: true},
+		{Version: "", ExpectedOK: false},
+		{Version: ".104.05", ExpectedOK: false},
+		{Version: "r535.104.05", ExpectedOK: false},
+		{Version: "-535.104.05", ExpectedOK: false},
+		{Version: "notAvailable", ExpectedOK: false},
+	} {
+		t.Run(testCase.Version, func(t *testing.T) {
+			branch, ok := driverBranch(testCase.Version)
+			must.Eq(t, testCase.ExpectedOK, ok)
+			must.Eq(t, testCase.ExpectedBranch, branch)
+		})
+	}
+}
+
+func TestCodecSupport(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Device   *nvml.FingerprintDeviceData
+		Expected map[string]bool
+	}{
+		{
+			Name: "ada with av1 encoder",
+			Device: &nvml.FingerprintDeviceData{
+				Architecture:        pointer.Of("ada"),
+				EncoderCapacityH264: pointer.Of(uint(100)),
+				EncoderCapacityHEVC: pointer.Of(uint(100)),
+				EncoderCapacityAV1:  pointer.Of(uint(0)),
+			},
+			Expected: map[string]bool{
+				H264EncodeAttr: true,
+				HEVCEncodeAttr: true,
+				AV1EncodeAttr:  true,
+				H264DecodeAttr: true,
+				HEVCDecodeAttr: true,
+				AV1DecodeAttr:  true,
+			},
+		},
+		{
+			Name: "hopper without encoder",
+			Device: &nvml.FingerprintDeviceData{
+				Architecture: pointer.Of("hopper"),
+			},
+			Expected: map[string]bool{
+				H264EncodeAttr: false,
+				HEVCEncodeAttr: false,
+				AV1EncodeAttr:  false,
+				H264DecodeAttr: true,
+				HEVCDecodeAttr: true,
+				AV1DecodeAttr:  true,
+			},
+		},
+		{
+			Name: "maxwell",
+			Device: &nvml.FingerprintDeviceData{
+				Architecture:        pointer.Of("maxwell"),
+				EncoderCapacityH264: pointer.Of(uint(100)),
+			},
+			Expected: map[string]bool{
+				H264EncodeAttr: true,
+				HEVCEncodeAttr: false,
+				AV1EncodeAttr:  false,
+				H264DecodeAttr: true,
+				HEVCDecodeAttr: false,
+				AV1DecodeAttr:  false,
+			},
+		},
+		{
+			Name: "unknown architecture",
+			Device: &nvml.FingerprintDeviceData{
+				Architecture:        pointer.Of("unknown(11)"),
+				EncoderCapacityH264: pointer.Of(uint(100)),
+			},
+			Expected: map[string]bool{
+				H264EncodeAttr: true,
+				HEVCEncodeAttr: false,
+				AV1EncodeAttr:  false,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			must.Eq(t, c.Expected, codecSupport(c.Device))
+		})
+	}
+}
+
+func TestVersionOlder(t *testing.T) {
+	for _, testCase := range []struct {
+		A, B           string
+		ExpectedOlder  bool
+		ExpectedParsed bool
+	}{
+		{A: "440.33.01", B: "450.80.02", ExpectedOlder: true, ExpectedParsed: true},
+		{A: "450.80.02", B: "450.80.02", ExpectedOlder: false, ExpectedParsed: true},
+		{A: "450.80.1", B: "450.80.02", ExpectedOlder: true, ExpectedParsed: true},
+		{A: "450.80", B: "450.80.02", ExpectedOlder: true, ExpectedParsed: true},
+		{A: "535.104.05", B: "450.80.02", ExpectedOlder: false, ExpectedParsed: true},
+		{A: "", B: "450.80.02", ExpectedParsed: false},
+		{A: "abc", B: "450.80.02", ExpectedParsed: false},
+	} {
+		t.Run(testCase.A, func(t *testing.T) {
+			older, parsed := versionOlder(testCase.A, testCase.B)
+			must.Eq(t, testCase.ExpectedParsed, parsed)
+			must.Eq(t, testCase.ExpectedOlder, older)
+		})
+	}
+}
+
+func TestSummaryAttributes(t *testing.T) {
+	deviceGroups := []*device.DeviceGroup{
+		{
+			Name: "Name1",
+			Devices: []*device.Device{
+				{ID: "1", Healthy: true},
+				{ID: "2", Healthy: false},
+			},
+		},
+		{
+			Name: "Name2",
+			Devices: []*device.Device{
+				{ID: "3", Healthy: true},
+			},
+		},
+	}
+	deviceData := []*nvml.FingerprintDeviceData{
+		{DeviceData: &nvml.DeviceData{UUID: "1", MemoryMiB: pointer.Of(uint64(1024))}},
+		{DeviceData: &nvml.DeviceData{UUID: "2", MemoryMiB: pointer.Of(uint64(1024))}},
+		{DeviceData: &nvml.DeviceData{UUID: "3", MemoryMiB: nil}},
+	}
+
+	must.Eq(t, map[string]*structs.Attribute{
+		DevicesTotalAttr: {
+			Int: pointer.Of(int64(3)),
+		},
+		DevicesHealthyAttr: {
+			Int: pointer.Of(int64(2)),
+		},
+		DevicesIgnoredAttr: {
+			Int: pointer.Of(int64(4)),
+		},
+		DevicesMemoryAttr: {
+			Int:  pointer.Of(int64(2048)),
+			Unit: structs.UnitMiB,
+		},
+	}, summaryAttributes(deviceGroups, deviceData, 4))
+}
+
 func TestAttributesFromFingerprintDeviceData(t *testing.T) {
 	for _, testCase := range []struct {
 		Name                  string
@@ -374,12 +638,47 @@ func TestAttributesFromFingerprintDeviceData(t *testing.T) {
 					PowerW:     pointer.Of(uint(2)),
 					BAR1MiB:    pointer.Of(uint64(256)),
 				},
-				PCIBusID:           "pciBusID1",
-				PCIBandwidthMBPerS: pointer.Of(uint(1)),
-				CoresClockMHz:      pointer.Of(uint(1)),
-				MemoryClockMHz:     pointer.Of(uint(1)),
-				DisplayState:       "Enabled",
-				PersistenceMode:    "Enabled",
+				PCIBusID:                  "pciBusID1",
+				PCIBandwidthMBPerS:        pointer.Of(uint(1)),
+				CoresClockMHz:             pointer.Of(uint(1)),
+				MemoryClockMHz:            pointer.Of(uint(1)),
+				ApplicationCoresClockMHz:  pointer.Of(uint(2)),
+				ApplicationMemoryClockMHz: pointer.Of(uint(3)),
+				PCIDeviceID:               pointer.Of(uint32(0x233010DE)),
+				PCISubsystemID:            pointer.Of(uint32(0x16C110DE)),
+				PCILinkGeneration:         pointer.Of(uint(5)),
+				PCILinkWidth:              pointer.Of(uint(16)),
+				EncoderCapacityH264:       pointer.Of(uint(100)),
+				EncoderCapacityHEVC:       pointer.Of(uint(100)),
+				GSPFirmwareMode:           pointer.Of("Enabled"),
+				GSPFirmwareVersion:        pointer.Of("550.54.15"),
+				InfoROMVersionOEM:         pointer.Of("2.1"),
+				InfoROMVersionECC:         pointer.Of("7.0"),
+				InfoROMVersionPower:       pointer.Of("N/A"),
+				OperationMode:             pointer.Of("all_on"),
+				VirtualizationMode:        pointer.Of("passthrough"),
+				Brand:                     pointer.Of("tesla"),
+				Architecture:              pointer.Of("ampere"),
+				ComputeCapability:         pointer.Of("8.0"),
+				FanCount:                  pointer.Of(uint(2)),
+				FanSpeedMin:               pointer.Of(uint(30)),
+				FanSpeedMax:               pointer.Of(uint(100)),
+				FanControl:                pointer.Of("automatic"),
+				CoherentMemory:            pointer.Of(true),
+				NUMANode:                  pointer.Of(uint(0)),
+				MemoryNUMANode:            pointer.Of(uint(1)),
+				C2CLinks:                  pointer.Of(uint(10)),
+				C2CBandwidthMBps:          pointer.Of(uint(447120)),
+				MaxOperatingTemperatureC:  pointer.Of(uint(87)),
+				AcousticTemperatureC:      pointer.Of(uint(80)),
+				VGPULicensed:              pointer.Of(true),
+				VGPULicenseExpiry:         pointer.Of(time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)),
+				DisplayState:              "Enabled",
+				PersistenceMode:           "Enabled",
+				MIGProfiles: []*nvml.MIGProfile{
+					{Name: "1g.10gb", SliceCount: 1, InstanceCount: 7, MemoryMiB: 9728},
+					{Name: "7g.80gb", SliceCount: 7, InstanceCount: 1, MemoryMiB: 80384},
+				},
 			},
 			ExpectedResult: map[string]*structs.Attribute{
 				MemoryAttr: {
@@ -406,12 +705,138 @@ func TestAttributesFromFingerprintDeviceData(t *testing.T) {
 					Int:  pointer.Of(int64(1)),
 					Unit: structs.UnitMHz,
 				},
+				ApplicationCoresClockAttr: {
+					Int:  pointer.Of(int64(2)),
+					Unit: structs.UnitMHz,
+				},
+				ApplicationMemoryClockAttr: {
+					Int:  pointer.Of(int64(3)),
+					Unit: structs.UnitMHz,
+				},
+				PCIVendorIDAttr: {
+					String: pointer.Of("0x10DE"),
+				},
+				PCIDeviceIDAttr: {
+					String: pointer.Of("0x2330"),
+				},
+				PCILinkGenerationAttr: {
+					Int: pointer.Of(int64(5)),
+				},
+				PCILinkWidthAttr: {
+					Int: pointer.Of(int64(16)),
+				},
+				EncoderCapacityH264Attr: {
+					Int:  pointer.Of(int64(100)),
+					Unit: UnitPercent,
+				},
+				EncoderCapacityHEVCAttr: {
+					Int:  pointer.Of(int64(100)),
+					Unit: UnitPercent,
+				},
+				MIGProfilesAttr: {
+					String: pointer.Of("1g.10gb=7,7g.80gb=1"),
+				},
+				GSPFirmwareModeAttr: {
+					String: pointer.Of("Enabled"),
+				},
+				GSPFirmwareVersionAttr: {
+					String: pointer.Of("550.54.15"),
+				},
+				InfoROMVersionOEMAttr: {
+					String: pointer.Of("2.1"),
+				},
+				InfoROMVersionECCAttr: {
+					String: pointer.Of("7.0"),
+				},
+				InfoROMVersionPowerAttr: {
+					String: pointer.Of("N/A"),
+				},
+				OperationModeAttr: {
+					String: pointer.Of("all_on"),
+				},
+				VirtualizationModeAttr: {
+					String: pointer.Of("passthrough"),
+				},
+				BrandAttr: {
+					String: pointer.Of("tesla"),
+				},
+				FanCountAttr: {
+					Int: pointer.Of(int64(2)),
+				},
+				FanSpeedMinAttr: {
+					Int:  pointer.Of(int64(30)),
+					Unit: UnitPercent,
+				},
+				FanSpeedMaxAttr: {
+					Int:  pointer.Of(int64(100)),
+					Unit: UnitPercent,
+				},
+				FanControlAttr: {
+					String: pointer.Of("automatic"),
+				},
+				CoherentMemoryAttr: {
+					Bool: pointer.Of(true),
+				},
+				NUMANodeAttr: {
+					Int: pointer.Of(int64(0)),
+				},
+				MemoryNUMANodeAttr: {
+					Int: pointer.Of(int64(1)),
+				},
+				C2CLinksAttr: {
+					Int: pointer.Of(int64(10)),
+				},
+				C2CBandwidthAttr: {
+					Int:  pointer.Of(int64(447120)),
+					Unit: structs.UnitMBPerS,
+				},
+				ArchitectureAttr: {
+					String: pointer.Of("ampere"),
+				},
+				H264EncodeAttr: {
+					Bool: pointer.Of(true),
+				},
+				HEVCEncodeAttr: {
+					Bool: pointer.Of(true),
+				},
+				AV1EncodeAttr: {
+					Bool: pointer.Of(false),
+				},
+				H264DecodeAttr: {
+					Bool: pointer.Of(true),
+				},
+				HEVCDecodeAttr: {
+					Bool: pointer.Of(true),
+				},
+				AV1DecodeAttr: {
+					Bool: pointer.Of(true),
+				},
+				ComputeCapabilityAttr: {
+					String: pointer.Of("8.0"),
+				},
+				MaxOperatingTemperatureAttr: {
+					Int:  pointer.Of(int64(87)),
+					Unit: UnitCelsius,
+				},
+				AcousticTemperatureAttr: {
+					Int:  pointer.Of(int64(80)),
+					Unit: UnitCelsius,
+				},
+				VGPULicensedAttr: {
+					Bool: pointer.Of(true),
+				},
+				VGPULicenseExpiryAttr: {
+					String: pointer.Of("2026-03-01T12:00:00Z"),
+				},
 				DisplayStateAttr: {
 					String: pointer.Of("Enabled"),
 				},
 				PersistenceModeAttr: {
 					String: pointer.Of("Enabled"),
 				},
+				MIGParentAttr: {
+					Bool: pointer.Of(false),
+				},
 			},
 		},
 		{
@@ -443,6 +868,9 @@ func TestAttributesFromFingerprintDeviceData(t *testing.T) {
 				PersistenceModeAttr: {
 					String: pointer.Of("Enabled"),
 				},
+				MIGParentAttr: {
+					Bool: pointer.Of(false),
+				},
 			},
 		},
 	} {
@@ -497,8 +925,8 @@ func TestDeviceGroupFromFingerprintData(t *testing.T) {
 				},
 			},
 			ExpectedResult: &device.DeviceGroup{
-				Vendor: vendor,
-				Type:   deviceType,
+				Vendor: Vendor.Name,
+				Type:   Vendor.DeviceType,
 				Name:   "Type1",
 				Devices: []*device.Device{
 					{
@@ -547,6 +975,16 @@ func TestDeviceGroupFromFingerprintData(t *testing.T) {
 					PersistenceModeAttr: {
 						String: pointer.Of("Enabled"),
 					},
+					MIGParentAttr: {
+						Bool: pointer.Of(false),
+					},
+					GroupDevicesAttr: {
+						Int: pointer.Of(int64(2)),
+					},
+					GroupMemoryAttr: {
+						Int:  pointer.Of(int64(200)),
+						Unit: structs.UnitMiB,
+					},
 				},
 			},
 		},
@@ -591,8 +1029,8 @@ func TestDeviceGroupFromFingerprintData(t *testing.T) {
 				},
 			},
 			ExpectedResult: &device.DeviceGroup{
-				Vendor: vendor,
-				Type:   deviceType,
+				Vendor: Vendor.Name,
+				Type:   Vendor.DeviceType,
 				Name:   "Type1",
 				Devices: []*device.Device{
 					{
@@ -641,6 +1079,16 @@ func TestDeviceGroupFromFingerprintData(t *testing.T) {
 					PersistenceModeAttr: {
 						String: pointer.Of("Enabled"),
 					},
+					MIGParentAttr: {
+						Bool: pointer.Of(false),
+					},
+					GroupDevicesAttr: {
+						Int: pointer.Of(int64(2)),
+					},
+					GroupMemoryAttr: {
+						Int:  pointer.Of(int64(200)),
+						Unit: structs.UnitMiB,
+					},
 					DriverVersionAttr: {
 						String: pointer.Of("1"),
 					},
@@ -660,13 +1108,17 @@ func TestDeviceGroupFromFingerprintData(t *testing.T) {
 		},
 	} {
 		t.Run(testCase.Name, func(t *testing.T) {
-			actualResult := deviceGroupFromFingerprintData(testCase.GroupName, testCase.Devices, testCase.CommonAttributes)
+			d := &NvidiaDevice{collector: &MockNvmlClient{}}
+			actualResult := d.deviceGroupFromFingerprintData(testCase.GroupName, testCase.Devices, testCase.CommonAttributes)
 			must.Eq(t, testCase.ExpectedResult, actualResult)
 		})
 	}
 }
 
 func TestWriteFingerprintToChannel(t *testing.T) {
+	// no nvidia-persistenced process runs
+	setupProcRoot(t, nil)
+
 	for _, testCase := range []struct {
 		Name                   string
 		Device                 *NvidiaDevice
@@ -675,7 +1127,7 @@ func TestWriteFingerprintToChannel(t *testing.T) {
 		{
 			Name: "Check that FingerprintError is handled properly",
 			Device: &NvidiaDevice{
-				nvmlClient: &MockNvmlClient{
+				collector: &MockNvmlClient{
 					FingerprintError: errors.New(""),
 				},
 				logger: hclog.NewNullLogger(),
@@ -687,7 +1139,7 @@ func TestWriteFingerprintToChannel(t *testing.T) {
 		{
 			Name: "Check ignore devices works correctly",
 			Device: &NvidiaDevice{
-				nvmlClient: &MockNvmlClient{
+				collector: &MockNvmlClient{
 					FingerprintResponseReturned: &nvml.FingerprintData{
 						DriverVersion: "1",
 						Devices: []*nvml.FingerprintDeviceData{
@@ -732,8 +1184,8 @@ func TestWriteFingerprintToChannel(t *testing.T) {
 			ExpectedWriteToChannel: &device.FingerprintResponse{
 				Devices: []*device.DeviceGroup{
 					{
-						Vendor: vendor,
-						Type:   deviceType,
+						Vendor: Vendor.Name,
+						Type:   Vendor.DeviceType,
 						Name:   "Name",
 						Devices: []*device.Device{
 							{
@@ -775,9 +1227,47 @@ func TestWriteFingerprintToChannel(t *testing.T) {
 							PersistenceModeAttr: {
 								String: pointer.Of("Enabled"),
 							},
+							MIGParentAttr: {
+								Bool: pointer.Of(false),
+							},
 							DriverVersionAttr: {
 								String: pointer.Of("1"),
 							},
+							DriverBranchAttr: {
+								String: pointer.Of("R1"),
+							},
+							PluginVersionAttr: {
+								String: pointer.Of(version.Version),
+							},
+							PersistencedRunningAttr: {
+								Bool: pointer.Of(false),
+							},
+							GDSSupportedAttr: {
+								Bool: pointer.Of(false),
+							},
+							GroupDevicesAttr: {
+								Int: pointer.Of(int64(1)),
+							},
+							GroupMemoryAttr: {
+								Int:  pointer.Of(int64(10)),
+								Unit: structs.UnitMiB,
+							},
+							NVMLBindingsVersionAttr: {
+								String: pointer.Of(nvmlBindingsVersion()),
+							},
+							DevicesTotalAttr: {
+								Int: pointer.Of(int64(1)),
+							},
+							DevicesHealthyAttr: {
+								Int: pointer.Of(int64(1)),
+							},
+							DevicesIgnoredAttr: {
+								Int: pointer.Of(int64(1)),
+							},
+							DevicesMemoryAttr: {
+								Int:  pointer.Of(int64(10)),
+								Unit: structs.UnitMiB,
+							},
 						},
 					},
 				},
@@ -786,7 +1276,7 @@ func TestWriteFingerprintToChannel(t *testing.T) {
 		{
 			Name: "Check devices are split to multiple device groups 1",
 			Device: &NvidiaDevice{
-				nvmlClient: &MockNvmlClient{
+				collector: &MockNvmlClient{
 					FingerprintResponseReturned: &nvml.FingerprintData{
 						DriverVersion: "1",
 						Devices: []*nvml.FingerprintDeviceData{
@@ -843,8 +1333,8 @@ func TestWriteFingerprintToChannel(t *testing.T) {
 			ExpectedWriteToChannel: &device.FingerprintResponse{
 				Devices: []*device.DeviceGroup{
 					{
-						Vendor: vendor,
-						Type:   deviceType,
+						Vendor: Vendor.Name,
+						Type:   Vendor.DeviceType,
 						Name:   "Name1",
 						Devices: []*device.Device{
 							{
@@ -886,14 +1376,52 @@ func TestWriteFingerprintToChannel(t *testing.T) {
 							PersistenceModeAttr: {
 								String: pointer.Of("Enabled"),
 							},
+							MIGParentAttr: {
+								Bool: pointer.Of(false),
+							},
 							DriverVersionAttr: {
 								String: pointer.Of("1"),
 							},
+							DriverBranchAttr: {
+								String: pointer.Of("R1"),
+							},
+							PluginVersionAttr: {
+								String: pointer.Of(version.Version),
+							},
+							PersistencedRunningAttr: {
+								Bool: pointer.Of(false),
+							},
+							GDSSupportedAttr: {
+								Bool: pointer.Of(false),
+							},
+							GroupDevicesAttr: {
+								Int: pointer.Of(int64(1)),
+							},
+							GroupMemoryAttr: {
+								Int:  pointer.Of(int64(10)),
+								Unit: structs.UnitMiB,
+							},
+							NVMLBindingsVersionAttr: {
+								String: pointer.Of(nvmlBindingsVersion()),
+							},
+							DevicesTotalAttr: {
+								Int: pointer.Of(int64(3)),
+							},
+							DevicesHealthyAttr: {
+								Int: pointer.Of(int64(3)),
+							},
+							DevicesIgnoredAttr: {
+								Int: pointer.Of(int64(0)),
+							},
+							DevicesMemoryAttr: {
+								Int:  pointer.Of(int64(33)),
+								Unit: structs.UnitMiB,
+							},
 						},
 					},
 					{
-						Vendor: vendor,
-						Type:   deviceType,
+						Vendor: Vendor.Name,
+						Type:   Vendor.DeviceType,
 						Name:   "Name2",
 						Devices: []*device.Device{
 							{
@@ -935,14 +1463,52 @@ func TestWriteFingerprintToChannel(t *testing.T) {
 							PersistenceModeAttr: {
 								String: pointer.Of("Enabled"),
 							},
+							MIGParentAttr: {
+								Bool: pointer.Of(false),
+							},
 							DriverVersionAttr: {
 								String: pointer.Of("1"),
 							},
+							DriverBranchAttr: {
+								String: pointer.Of("R1"),
+							},
+							PluginVersionAttr: {
+								String: pointer.Of(version.Version),
+							},
+							PersistencedRunningAttr: {
+								Bool: pointer.Of(false),
+							},
+							GDSSupportedAttr: {
+								Bool: pointer.Of(false),
+							},
+							GroupDevicesAttr: {
+								Int: pointer.Of(int64(1)),
+							},
+							GroupMemoryAttr: {
+								Int:  pointer.Of(int64(11)),
+								Unit: structs.UnitMiB,
+							},
+							NVMLBindingsVersionAttr: {
+								String: pointer.Of(nvmlBindingsVersion()),
+							},
+							DevicesTotalAttr: {
+								Int: pointer.Of(int64(3)),
+							},
+							DevicesHealthyAttr: {
+								Int: pointer.Of(int64(3)),
+							},
+							DevicesIgnoredAttr: {
+								Int: pointer.Of(int64(0)),
+							},
+							DevicesMemoryAttr: {
+								Int:  pointer.Of(int64(33)),
+								Unit: structs.UnitMiB,
+							},
 						},
 					},
 					{
-						Vendor: vendor,
-						Type:   deviceType,
+						Vendor: Vendor.Name,
+						Type:   Vendor.DeviceType,
 						Name:   "Name3",
 						Devices: []*device.Device{
 							{
@@ -984,9 +1550,47 @@ func TestWriteFingerprintToChannel(t *testing.T) {
 							PersistenceModeAttr: {
 								String: pointer.Of("Enabled"),
 							},
+							MIGParentAttr: {
+								Bool: pointer.Of(false),
+							},
 							DriverVersionAttr: {
 								String: pointer.Of("1"),
 							},
+							DriverBranchAttr: {
+								String: pointer.Of("R1"),
+							},
+							PluginVersionAttr: {
+								String: pointer.Of(version.Version),
+							},
+							PersistencedRunningAttr: {
+								Bool: pointer.Of(false),
+							},
+							GDSSupportedAttr: {
+								Bool: pointer.Of(false),
+							},
+							GroupDevicesAttr: {
+								Int: pointer.Of(int64(1)),
+							},
+							GroupMemoryAttr: {
+								Int:  pointer.Of(int64(12)),
+								Unit: structs.UnitMiB,
+							},
+							NVMLBindingsVersionAttr: {
+								String: pointer.Of(nvmlBindingsVersion()),
+							},
+							DevicesTotalAttr: {
+								Int: pointer.Of(int64(3)),
+							},
+							DevicesHealthyAttr: {
+								Int: pointer.Of(int64(3)),
+							},
+							DevicesIgnoredAttr: {
+								Int: pointer.Of(int64(0)),
+							},
+							DevicesMemoryAttr: {
+								Int:  pointer.Of(int64(33)),
+								Unit: structs.UnitMiB,
+							},
 						},
 					},
 				},
@@ -995,7 +1599,7 @@ func TestWriteFingerprintToChannel(t *testing.T) {
 		{
 			Name: "Check devices are split to multiple device groups 2",
 			Device: &NvidiaDevice{
-				nvmlClient: &MockNvmlClient{
+				collector: &MockNvmlClient{
 					FingerprintResponseReturned: &nvml.FingerprintData{
 						DriverVersion: "1",
 						Devices: []*nvml.FingerprintDeviceData{
@@ -1052,8 +1656,8 @@ func TestWriteFingerprintToChannel(t *testing.T) {
 			ExpectedWriteToChannel: &device.FingerprintResponse{
 				Devices: []*device.DeviceGroup{
 					{
-						Vendor: vendor,
-						Type:   deviceType,
+						Vendor: Vendor.Name,
+						Type:   Vendor.DeviceType,
 						Name:   "Name1",
 						Devices: []*device.Device{
 							{
@@ -1095,14 +1699,52 @@ func TestWriteFingerprintToChannel(t *testing.T) {
 							PersistenceModeAttr: {
 								String: pointer.Of("Enabled"),
 							},
+							MIGParentAttr: {
+								Bool: pointer.Of(false),
+							},
 							DriverVersionAttr: {
 								String: pointer.Of("1"),
 							},
+							DriverBranchAttr: {
+								String: pointer.Of("R1"),
+							},
+							PluginVersionAttr: {
+								String: pointer.Of(version.Version),
+							},
+							PersistencedRunningAttr: {
+								Bool: pointer.Of(false),
+							},
+							GDSSupportedAttr: {
+								Bool: pointer.Of(false),
+							},
+							GroupDevicesAttr: {
+								Int: pointer.Of(int64(1)),
+							},
+							GroupMemoryAttr: {
+								Int:  pointer.Of(int64(10)),
+								Unit: structs.UnitMiB,
+							},
+							NVMLBindingsVersionAttr: {
+								String: pointer.Of(nvmlBindingsVersion()),
+							},
+							DevicesTotalAttr: {
+								Int: pointer.Of(int64(3)),
+							},
+							DevicesHealthyAttr: {
+								Int: pointer.Of(int64(3)),
+							},
+							DevicesIgnoredAttr: {
+								Int: pointer.Of(int64(0)),
+							},
+							DevicesMemoryAttr: {
+								Int:  pointer.Of(int64(33)),
+								Unit: structs.UnitMiB,
+							},
 						},
 					},
 					{
-						Vendor: vendor,
-						Type:   deviceType,
+						Vendor: Vendor.Name,
+						Type:   Vendor.DeviceType,
 						Name:   "Name2",
 						Devices: []*device.Device{
 							{
@@ -1151,9 +1793,47 @@ func TestWriteFingerprintToChannel(t *testing.T) {
 							PersistenceModeAttr: {
 								String: pointer.Of("Enabled"),
 							},
+							MIGParentAttr: {
+								Bool: pointer.Of(false),
+							},
 							DriverVersionAttr: {
 								String: pointer.Of("1"),
 							},
+							DriverBranchAttr: {
+								String: pointer.Of("R1"),
+							},
+							PluginVersionAttr: {
+								String: pointer.Of(version.Version),
+							},
+							PersistencedRunningAttr: {
+								Bool: pointer.Of(false),
+							},
+							GDSSupportedAttr: {
+								Bool: pointer.Of(false),
+							},
+							GroupDevicesAttr: {
+								Int: pointer.Of(int64(2)),
+							},
+							GroupMemoryAttr: {
+								Int:  pointer.Of(int64(23)),
+								Unit: structs.UnitMiB,
+							},
+							NVMLBindingsVersionAttr: {
+								String: pointer.Of(nvmlBindingsVersion()),
+							},
+							DevicesTotalAttr: {
+								Int: pointer.Of(int64(3)),
+							},
+							DevicesHealthyAttr: {
+								Int: pointer.Of(int64(3)),
+							},
+							DevicesIgnoredAttr: {
+								Int: pointer.Of(int64(0)),
+							},
+							DevicesMemoryAttr: {
+								Int:  pointer.Of(int64(33)),
+								Unit: structs.UnitMiB,
+							},
 						},
 					},
 				},
@@ -1162,25 +1842,131 @@ func TestWriteFingerprintToChannel(t *testing.T) {
 	} {
 		t.Run(testCase.Name, func(t *testing.T) {
 			channel := make(chan *device.FingerprintResponse, 1)
-			testCase.Device.writeFingerprintToChannel(channel)
+			testCase.Device.writeFingerprintToChannel(channel, "")
 			actualResult := <-channel
-			// writeFingerprintToChannel iterates over map keys
-			// and insterts results to an array, so order of elements in output array
-			// may be different
-			// actualResult, expectedResult arrays has to be sorted firsted
-			sort.Slice(actualResult.Devices, func(i, j int) bool {
-				return actualResult.Devices[i].Name < actualResult.Devices[j].Name
-			})
-			sort.Slice(testCase.ExpectedWriteToChannel.Devices, func(i, j int) bool {
-				return testCase.ExpectedWriteToChannel.Devices[i].Name < testCase.ExpectedWriteToChannel.Devices[j].Name
-			})
 			must.Eq(t, testCase.ExpectedWriteToChannel, actualResult)
 		})
 	}
 }
 
+func TestWriteFingerprintToChannelSkipsUnchanged(t *testing.T) {
+	fingerprintData := &nvml.FingerprintData{
+		DriverVersion: "1",
+		Devices: []*nvml.FingerprintDeviceData{
+			{
+				DeviceData: &nvml.DeviceData{
+					UUID:       "1",
+					DeviceName: pointer.Of("Name1"),
+					MemoryMiB:  pointer.Of(uint64(10)),
+				},
+				PCIBusID:        "pciBusID1",
+				DisplayState:    "Enabled",
+				PersistenceMode: "Enabled",
+			},
+		},
+	}
+	client := &MockNvmlClient{FingerprintResponseReturned: fingerprintData}
+	d := &NvidiaDevice{
+		collector: client,
+		logger:    hclog.NewNullLogger(),
+	}
+
+	channel := make(chan *device.FingerprintResponse, 1)
+
+	// first fingerprint is always sent
+	hash := d.writeFingerprintToChannel(channel, "")
+	must.Eq(t, 1, len(channel))
+	<-channel
+
+	// identical fingerprint is suppressed
+	hash = d.writeFingerprintToChannel(channel, hash)
+	must.Eq(t, 0, len(channel))
+
+	// attribute change on an existing device is sent
+	fingerprintData.Devices[0].PersistenceMode = "Disabled"
+	hash = d.writeFingerprintToChannel(channel, hash)
+	must.Eq(t, 1, len(channel))
+	result := <-channel
+	must.Eq(t, "Disabled", *result.Devices[0].Attributes[PersistenceModeAttr].String)
+
+	// an error resets the last response so that the next one is sent again
+	client.FingerprintError = errors.New("nvml failure")
+	hash = d.writeFingerprintToChannel(channel, hash)
+	result = <-channel
+	must.Error(t, result.Error)
+
+	client.FingerprintError = nil
+	d.writeFingerprintToChannel(channel, hash)
+	must.Eq(t, 1, len(channel))
+}
+
+func TestWriteFingerprintToChannelMIGParents(t *testing.T) {
+	setupProcRoot(t, nil)
+
+	parent := &nvml.FingerprintDeviceData{
+		DeviceData: &nvml.DeviceData{
+			UUID:       "GPU-1",
+			DeviceName: pointer.Of("NVIDIA A100-SXM4-40GB"),
+			MemoryMiB:  pointer.Of(uint64(40960)),
+		},
+		DisplayState:    "Disabled",
+		PersistenceMode: "Enabled",
+		MIGParent:       true,
+	}
+	d := &NvidiaDevice{
+		collector: &MockNvmlClient{
+			FingerprintResponseReturned: &nvml.FingerprintData{
+				DriverVersion: "1",
+				Devices: []*nvml.FingerprintDeviceData{
+					{
+						DeviceData: &nvml.DeviceData{
+							UUID:       "MIG-1",
+							DeviceName: pointer.Of("NVIDIA A100-SXM4-40GB MIG 1g.5gb"),
+							MemoryMiB:  pointer.Of(uint64(4864)),
+						},
+						ParentUUID:      "GPU-1",
+						DisplayState:    "Disabled",
+						PersistenceMode: "Enabled",
+					},
+				},
+				MIGParents: []*nvml.FingerprintDeviceData{parent},
+			},
+		},
+		logger: hclog.NewNullLogger(),
+	}
+
+	// MIG parents are not advertised by default
+	channel := make(chan *device.FingerprintResponse, 1)
+	d.writeFingerprintToChannel(channel, "")
+	result := <-channel
+	must.Len(t, 1, result.Devices)
+
+	d.includeMIGParents = true
+	d.writeFingerprintToChannel(channel, "")
+	result = <-channel
+	must.Len(t, 2, result.Devices)
+
+	// MIG parent groups follow the groups of allocatable devices
+	group := result.Devices[1]
+	must.Eq(t, "NVIDIA A100-SXM4-40GB MIG parent", group.Name)
+	must.Eq(t, []*device.Device{{
+		ID:         "GPU-1",
+		Healthy:    false,
+		HealthDesc: migParentHealthDesc,
+		HwLocality: &device.DeviceLocality{},
+	}}, group.Devices)
+	must.True(t, *group.Attributes[MIGParentAttr].Bool)
+	must.Eq(t, 1, *group.Attributes[DevicesTotalAttr].Int)
+
+	// MIG parents can not be reserved
+	_, err := d.Reserve([]string{"GPU-1"})
+	must.Error(t, err)
+}
+
 // Test if nonworking driver returns empty fingerprint data
 func TestFingerprint(t *testing.T) {
+	setupProcRoot(t, nil)
+
 	for _, testCase := range []struct {
 		Name                   string
 		Device                 *NvidiaDevice
@@ -1190,7 +1976,7 @@ func TestFingerprint(t *testing.T) {
 			Name: "Check that working driver returns valid fingeprint data",
 			Device: &NvidiaDevice{
 				initErr: nil,
-				nvmlClient: &MockNvmlClient{
+				collector: &MockNvmlClient{
 					FingerprintResponseReturned: &nvml.FingerprintData{
 						DriverVersion: "1",
 						Devices: []*nvml.FingerprintDeviceData{
@@ -1247,8 +2033,8 @@ func TestFingerprint(t *testing.T) {
 			ExpectedWriteToChannel: &device.FingerprintResponse{
 				Devices: []*device.DeviceGroup{
 					{
-						Vendor: vendor,
-						Type:   deviceType,
+						Vendor: Vendor.Name,
+						Type:   Vendor.DeviceType,
 						Name:   "Name1",
 						Devices: []*device.Device{
 							{
@@ -1304,9 +2090,47 @@ func TestFingerprint(t *testing.T) {
 							PersistenceModeAttr: {
 								String: pointer.Of("Enabled"),
 							},
+							MIGParentAttr: {
+								Bool: pointer.Of(false),
+							},
 							DriverVersionAttr: {
 								String: pointer.Of("1"),
 							},
+							DriverBranchAttr: {
+								String: pointer.Of("R1"),
+							},
+							PluginVersionAttr: {
+								String: pointer.Of(version.Version),
+							},
+							PersistencedRunningAttr: {
+								Bool: pointer.Of(false),
+							},
+							GDSSupportedAttr: {
+								Bool: pointer.Of(false),
+							},
+							GroupDevicesAttr: {
+								Int: pointer.Of(int64(3)),
+							},
+							GroupMemoryAttr: {
+								Int:  pointer.Of(int64(30)),
+								Unit: structs.UnitMiB,
+							},
+							NVMLBindingsVersionAttr: {
+								String: pointer.Of(nvmlBindingsVersion()),
+							},
+							DevicesTotalAttr: {
+								Int: pointer.Of(int64(3)),
+							},
+							DevicesHealthyAttr: {
+								Int: pointer.Of(int64(3)),
+							},
+							DevicesIgnoredAttr: {
+								Int: pointer.Of(int64(0)),
+							},
+							DevicesMemoryAttr: {
+								Int:  pointer.Of(int64(30)),
+								Unit: structs.UnitMiB,
+							},
 						},
 					},
 				},
@@ -1316,7 +2140,7 @@ func TestFingerprint(t *testing.T) {
 			Name: "Check that not working driver returns error fingeprint data",
 			Device: &NvidiaDevice{
 				initErr: errors.New("foo"),
-				nvmlClient: &MockNvmlClient{
+				collector: &MockNvmlClient{
 					FingerprintResponseReturned: &nvml.FingerprintData{
 						DriverVersion: "1",
 						Devices: []*nvml.FingerprintDeviceData{
@@ -1350,6 +2174,16 @@ func TestFingerprint(t *testing.T) {
 				Error: errors.New("foo"),
 			},
 		},
+		{
+			Name: "Check that transient NVML initialization errors are returned",
+			Device: &NvidiaDevice{
+				initErr: errors.New("failed to initialize: Unknown Error"),
+				logger:  hclog.NewNullLogger(),
+			},
+			ExpectedWriteToChannel: &device.FingerprintResponse{
+				Error: errors.New("failed to initialize: Unknown Error"),
+			},
+		},
 	} {
 		t.Run(testCase.Name, func(t *testing.T) {
 			outCh := make(chan *device.FingerprintResponse)
@@ -1357,7 +2191,217 @@ func TestFingerprint(t *testing.T) {
 			go testCase.Device.fingerprint(ctx, outCh)
 			result := <-outCh
 			cancel()
+			// wait for the fingerprint goroutine to exit, so that it does not
+			// run into the next tests
+			for range outCh {
+			}
 			must.Eq(t, result, testCase.ExpectedWriteToChannel)
 		})
 	}
 }
+
+func TestFingerprintPermanentInitError(t *testing.T) {
+	d := &NvidiaDevice{
+		enabled: true,
+		initErr: nvml.UnavailableLib,
+		logger:  hclog.NewNullLogger(),
+	}
+
+	// NVML never becomes available, so the plugin reports itself disabled
+	// rather than fingerprinting errors
+	_, err := d.Fingerprint(context.Background())
+	must.ErrorIs(t, err, device.ErrPluginDisabled)
+}
+
+func TestFingerprintNoDevices(t *testing.T) {
+	client := &MockNvmlClient{
+		FingerprintResponseReturned: &nvml.FingerprintData{
+			DriverVersion: "1",
+		},
+	}
+	d := &NvidiaDevice{
+		collector:         client,
+		fingerprintPeriod: time.Minute,
+		logger:            hclog.NewNullLogger(),
+	}
+	channel := make(chan *device.FingerprintResponse, 1)
+
+	// the first empty fingerprint is sent
+	hash := d.writeFingerprintToChannel(channel, "")
+	result := <-channel
+	must.SliceEmpty(t, result.Devices)
+	must.True(t, d.noDevices)
+
+	// further empty fingerprints are not sent again
+	hash = d.writeFingerprintToChannel(channel, hash)
+	must.Eq(t, 0, len(channel))
+
+	// a hotplugged device is fingerprinted
+	client.FingerprintResponseReturned.Devices = []*nvml.FingerprintDeviceData{
+		{
+			DeviceData: &nvml.DeviceData{
+				UUID:       "1",
+				DeviceName: pointer.Of("Name1"),
+			},
+		},
+	}
+	d.writeFingerprintToChannel(channel, hash)
+	result = <-channel
+	must.Len(t, 1, result.Devices)
+	must.False(t, d.noDevices)
+}
+
+func TestPerDeviceAttribute(t *testing.T) {
+	index := func(dev *nvml.FingerprintDeviceData) (string, bool) {
+		if dev.Index == nil {
+			return "", false
+		}
+		return strconv.FormatUint(uint64(*dev.Index), 10), true
+	}
+
+	for _, testCase := range []struct {
+		Name           string
+		DeviceList     []*nvml.FingerprintDeviceData
+		ExpectedResult *structs.Attribute
+	}{
+		{
+			Name: "all devices have an index",
+			DeviceList: []*nvml.FingerprintDeviceData{
+				{DeviceData: &nvml.DeviceData{UUID: "1"}, Index: pointer.Of(uint(1))},
+				{DeviceData: &nvml.DeviceData{UUID: "2"}, Index: pointer.Of(uint(0))},
+			},
+			ExpectedResult: &structs.Attribute{
+				String: pointer.Of("1=1,2=0"),
+			},
+		},
+		{
+			Name: "devices without an index are skipped",
+			DeviceList: []*nvml.FingerprintDeviceData{
+				{DeviceData: &nvml.DeviceData{UUID: "1"}},
+				{DeviceData: &nvml.DeviceData{UUID: "2"}, Index: pointer.Of(uint(3))},
+			},
+			ExpectedResult: &structs.Attribute{
+				String: pointer.Of("2=3"),
+			},
+		},
+		{
+			Name: "no device has an index",
+			DeviceList: []*nvml.FingerprintDeviceData{
+				{DeviceData: &nvml.DeviceData{UUID: "1"}},
+			},
+			ExpectedResult: nil,
+		},
+	} {
+		t.Run(testCase.Name, func(t *testing.T) {
+			actualResult := perDeviceAttribute(testCase.DeviceList, index)
+			must.Eq(t, testCase.ExpectedResult, actualResult)
+		})
+	}
+}
+
+func TestDeviceGroupFromFingerprintDataPerDeviceAttributes(t *testing.T) {
+	devices := []*nvml.FingerprintDeviceData{
+		{
+			DeviceData: &nvml.DeviceData{
+				UUID:       "1",
+				DeviceName: pointer.Of("Type1"),
+			},
+			Index:          pointer.Of(uint(0)),
+			PCISubsystemID: pointer.Of(uint32(0x16C110DE)),
+		},
+		{
+			DeviceData: &nvml.DeviceData{
+				UUID:       "2",
+				DeviceName: pointer.Of("Type1"),
+			},
+			Index:          pointer.Of(uint(1)),
+			PCISubsystemID: pointer.Of(uint32(0x14591028)),
+		},
+		{
+			DeviceData: &nvml.DeviceData{
+				UUID:       "MIG-3",
+				DeviceName: pointer.Of("Type1"),
+			},
+			Index:      pointer.Of(uint(2)),
+			ParentUUID: "GPU-2",
+		},
+	}
+
+	d := &NvidiaDevice{collector: &MockNvmlClient{}}
+	group := d.deviceGroupFromFingerprintData("Type1", devices, nil)
+	must.Eq(t, &structs.Attribute{String: pointer.Of("1=0,2=1,MIG-3=2")}, group.Attributes[IndexAttr])
+	must.Eq(t, &structs.Attribute{String: pointer.Of("1=0x16C110DE,2=0x14591028")}, group.Attributes[PCISubsystemIDAttr])
+	must.Eq(t, &structs.Attribute{String: pointer.Of("MIG-3=GPU-2")}, group.Attributes[ParentGPUUUIDAttr])
+	must.Eq(t, &structs.Attribute{Int: pointer.Of(int64(1))}, group.Attributes[MIGParentGPUsAttr])
+}
+
+func TestDeviceGroupFromFingerprintDataFlattened(t *testing.T) {
+	devices := []*nvml.FingerprintDeviceData{
+		{
+			DeviceData: &nvml.DeviceData{
+				UUID:       "1",
+				DeviceName: pointer.Of("Type1"),
+				MemoryMiB:  pointer.Of(uint64(100)),
+				PowerW:     pointer.Of(uint(2)),
+			},
+			PCIBusID:        "pciBusID1",
+			PersistenceMode: "Enabled",
+		},
+		{
+			DeviceData: &nvml.DeviceData{
+				UUID:       "2",
+				DeviceName: pointer.Of("Type2"),
+				MemoryMiB:  pointer.Of(uint64(200)),
+				PowerW:     pointer.Of(uint(2)),
+			},
+			PCIBusID:           "pciBusID2",
+			PersistenceMode:    "Enabled",
+			VirtualizationMode: pointer.Of("none"),
+		},
+	}
+
+	d := &NvidiaDevice{collector: &MockNvmlClient{}, flattenGroups: true}
+	must.Eq(t, FlatGroupName, d.groupName(pointer.Of("Type1")))
+	must.Eq(t, FlatGroupName, d.groupName(nil))
+
+	group := d.deviceGroupFromFingerprintData(FlatGroupName, devices, nil)
+	must.Eq(t, FlatGroupName, group.Name)
+	must.Len(t, 2, group.Devices)
+
+	// attributes shared by all devices are kept as is
+	must.Eq(t, &structs.Attribute{Int: pointer.Of(int64(2)), Unit: structs.UnitW}, group.Attributes[PowerAttr])
+	must.Eq(t, &structs.Attribute{String: pointer.Of("Enabled")}, group.Attributes[PersistenceModeAttr])
+
+	// the others are reported per device
+	must.Eq(t, &structs.Attribute{String: pointer.Of("1=Type1,2=Type2")}, group.Attributes[ModelAttr])
+	must.Eq(t, &structs.Attribute{String: pointer.Of("1=100MiB,2=200MiB")}, group.Attributes[MemoryAttr])
+	must.Eq(t, &structs.Attribute{String: pointer.Of("2=none")}, group.Attributes[VirtualizationModeAttr])
+	must.Eq(t, &structs.Attribute{Int: pointer.Of(int64(300)), Unit: structs.UnitMiB}, group.Attributes[GroupMemoryAttr])
+
+	// devices are grouped by model unless flattened
+	d.flattenGroups = false
+	must.Eq(t, "Type1", d.groupName(pointer.Of("Type1")))
+	must.Eq(t, notAvailable, d.groupName(nil))
+}
+
+func TestFingerprintStreams(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := newReplayDevice(t)
+	must.NoError(t, setPluginConfig(t, d, pluginConfig))
+
+	// every stream starts with a response, such as the one of a Nomad client
+	// reattaching to the plugin after a restart
+	for i := 0; i < 2; i++ {
+		fingerprints, err := d.Fingerprint(ctx)
+		must.NoError(t, err)
+		select {
+		case fingerprint := <-fingerprints:
+			must.NoError(t, fingerprint.Error)
+			must.Len(t, 1, fingerprint.Devices)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for fingerprint")
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+)
+
+const (
+	// ForeignProcessCountAttr is the stats attribute counting the compute
+	// processes of a device that do not run in a Nomad allocation
+	ForeignProcessCountAttr = "Foreign process count"
+	ForeignProcessCountDesc = "Number of compute processes running outside of Nomad allocations"
+)
+
+// allocationCgroupRoots are the top level cgroups under which Nomad places
+// the processes of allocations, nomad.slice on cgroups v2 and nomad on
+// cgroups v1. Container task drivers using Nomad's cgroup parent, such as
+// the Docker driver on cgroups v2, also run their tasks below them, unlike
+// containers started with docker run.
+var allocationCgroupRoots = []string{"nomad.slice", "nomad"}
+
+// procRoot is the mount point of procfs, it is replaced in tests
+var procRoot = "/proc"
+
+// isForeignProcess reports whether the process with the given pid runs
+// outside of a Nomad allocation, such as a process started by a user logged
+// in over SSH. Processes of allocations are recognized by their cgroup.
+func isForeignProcess(pid int) (bool, error) {
+	content, err := os.ReadFile(filepath.Join(procRoot, fmt.Sprint(pid), "cgroup"))
+	if err != nil {
+		return false, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		// lines are formatted as hierarchy-ID:controllers:path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		root, _, _ := strings.Cut(strings.TrimPrefix(parts[2], "/"), "/")
+		if slices.Contains(allocationCgroupRoots, root) {
+			return false, nil
+		}
+	}
+	return true, scanner.Err()
+}
+
+// foreignProcesses returns the compute processes of the device with the
+// given UUID that run outside of a Nomad allocation. Processes that exited
+// since NVML listed them are left out.
+func (d *NvidiaDevice) foreignProcesses(uuid string) ([]int, error) {
+	pids, err := d.collector.GetComputeProcesses(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	var foreign []int
+	for _, pid := range pids {
+		ok, err := isForeignProcess(pid)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			foreign = append(foreign, pid)
+		}
+	}
+	slices.Sort(foreign)
+	return foreign, nil
+}
+
+// addForeignProcessStats adds the foreign process count of every device to
+// its stats, unless left out by enabled_metrics, and logs a warning whenever
+// the foreign processes of a device change if configured. Devices whose
+// processes can not be listed report no count.
+func (d *NvidiaDevice) addForeignProcessStats(groups []*device.DeviceGroupStats) {
+	_, enabled := d.statsOptions.enabledMetrics[ForeignProcessCountAttr]
+	enabled = enabled || d.statsOptions.enabledMetrics == nil
+
+	seen := make(map[string]struct{})
+	for _, group := range groups {
+		for uuid, deviceStats := range group.InstanceStats {
+			pids, err := d.foreignProcesses(uuid)
+			if err != nil {
+				d.errorLog.Error(d.logger, "failed to get device foreign processes", err, "uuid", uuid)
+				continue
+			}
+			seen[uuid] = struct{}{}
+			d.warnForeignProcesses(uuid, pids)
+
+			if !enabled {
+				continue
+			}
+			if deviceStats.Stats == nil {
+				deviceStats.Stats = &structs.StatObject{}
+			}
+			if deviceStats.Stats.Attributes == nil {
+				deviceStats.Stats.Attributes = make(map[string]*structs.StatValue)
+			}
+			deviceStats.Stats.Attributes[ForeignProcessCountAttr] = &structs.StatValue{
+				Unit:            UnitCount,
+				Desc:            ForeignProcessCountDesc,
+				IntNumeratorVal: pointer.Of(int64(len(pids))),
+			}
+		}
+	}
+
+	for uuid := range d.lastForeignProcesses {
+		if _, ok := seen[uuid]; !ok {
+			delete(d.lastForeignProcesses, uuid)
+		}
+	}
+}
+
+// warnForeignProcesses logs a warning when the foreign processes of the
+// device with the given UUID differ from those of the previous stats
+// collection, so that persistent out of band usage is logged only once
+func (d *NvidiaDevice) warnForeignProcesses(uuid string, pids []int) {
+	if !d.foreignProcessWarning {
+		return
+	}
+	if d.lastForeignProcesses == nil {
+		d.lastForeignProcesses = make(map[string][]int)
+	}
+
+	last := d.lastForeignProcesses[uuid]
+	d.lastForeignProcesses[uuid] = pids
+	if len(pids) == 0 || slices.Equal(last, pids) {
+		return
+	}
+	d.logger.Warn("found compute processes running outside of Nomad allocations on device", "uuid", uuid, "pids", pids)
+}
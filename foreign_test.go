@@ -0,0 +1,125 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+	"github.com/shoenig/test/must"
+)
+
+// setupProcRoot points procRoot to a temporary directory holding the cgroup
+// files of the given processes
+func setupProcRoot(t *testing.T, cgroups map[int]string) {
+	root := t.TempDir()
+	for pid, cgroup := range cgroups {
+		dir := filepath.Join(root, fmt.Sprint(pid))
+		must.NoError(t, os.Mkdir(dir, 0o755))
+		must.NoError(t, os.WriteFile(filepath.Join(dir, "cgroup"), []byte(cgroup), 0o644))
+	}
+
+	old := procRoot
+	procRoot = root
+	t.Cleanup(func() { procRoot = old })
+}
+
+func TestIsForeignProcess(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Cgroup   string
+		Expected bool
+	}{
+		{
+			Name:     "ssh session",
+			Cgroup:   "0::/user.slice/user-1000.slice/session-3.scope\n",
+			Expected: true,
+		},
+		{
+			Name:     "exec task",
+			Cgroup:   "0::/nomad.slice/share.slice/4d2c1e0a.train.scope\n",
+			Expected: false,
+		},
+		{
+			Name:     "cgroups v1 exec task",
+			Cgroup:   "12:memory:/nomad/share/4d2c1e0a.train\n11:devices:/nomad/share/4d2c1e0a.train\n",
+			Expected: false,
+		},
+		{
+			Name:     "docker task",
+			Cgroup:   "0::/nomad.slice/docker-8f1e2d3c.scope\n",
+			Expected: false,
+		},
+		{
+			Name:     "docker run",
+			Cgroup:   "0::/system.slice/docker-8f1e2d3c.scope\n",
+			Expected: true,
+		},
+		{
+			Name:     "cgroups v1 docker run",
+			Cgroup:   "12:memory:/docker/8f1e2d3c\n11:devices:/docker/8f1e2d3c\n",
+			Expected: true,
+		},
+		{
+			Name:     "service named after nomad",
+			Cgroup:   "0::/system.slice/nomad-exporter.service\n",
+			Expected: true,
+		},
+		{
+			Name:     "system service",
+			Cgroup:   "0::/system.slice/jupyter.service\n",
+			Expected: true,
+		},
+	}
+	for i, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			setupProcRoot(t, map[int]string{i: c.Cgroup})
+			foreign, err := isForeignProcess(i)
+			must.NoError(t, err)
+			must.Eq(t, c.Expected, foreign)
+		})
+	}
+}
+
+func TestAddForeignProcessStats(t *testing.T) {
+	setupProcRoot(t, map[int]string{
+		100: "0::/nomad.slice/share.slice/4d2c1e0a.train.scope\n",
+		200: "0::/user.slice/user-1000.slice/session-3.scope\n",
+	})
+
+	d := &NvidiaDevice{
+		logger:                hclog.NewNullLogger(),
+		foreignProcessWarning: true,
+		collector: &MockNvmlClient{
+			ProcessesReturned: map[string][]int{
+				// 300 exited since it was listed
+				"UUID1": {100, 200, 300},
+				"UUID2": {100},
+			},
+		},
+	}
+	groups := []*device.DeviceGroupStats{{
+		InstanceStats: map[string]*device.DeviceStats{
+			"UUID1": {Stats: &structs.StatObject{}},
+			"UUID2": {Stats: &structs.StatObject{}},
+		},
+	}}
+	d.addForeignProcessStats(groups)
+
+	stats := groups[0].InstanceStats
+	must.Eq(t, int64(1), *stats["UUID1"].Stats.Attributes[ForeignProcessCountAttr].IntNumeratorVal)
+	must.Eq(t, int64(0), *stats["UUID2"].Stats.Attributes[ForeignProcessCountAttr].IntNumeratorVal)
+	must.Eq(t, []int{200}, d.lastForeignProcesses["UUID1"])
+
+	// the stat is left out when not enabled
+	d.statsOptions.enabledMetrics = map[string]struct{}{TemperatureAttr: {}}
+	groups[0].InstanceStats["UUID1"].Stats.Attributes = nil
+	d.addForeignProcessStats(groups)
+	must.MapEmpty(t, groups[0].InstanceStats["UUID1"].Stats.Attributes)
+}
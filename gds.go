@@ -0,0 +1,36 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// GDSSupportedAttr is whether the nvidia-fs kernel module GPUDirect
+	// Storage relies on is loaded on the node, and GDSVersionAttr the version
+	// of the module. They are reported on every device group.
+	GDSSupportedAttr = "gds_supported"
+	GDSVersionAttr   = "gds_version"
+
+	// gdsModuleName is the name of the nvidia-fs kernel module in sysfs
+	gdsModuleName = "nvidia_fs"
+)
+
+// gdsModule reports whether the nvidia-fs kernel module is loaded, looking it
+// up in sysfs, along with its version, empty when the module does not report
+// it
+func gdsModule() (loaded bool, version string) {
+	dir := filepath.Join(sysfsRoot, "module", gdsModuleName)
+	if _, err := os.Stat(dir); err != nil {
+		return false, ""
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "version"))
+	if err != nil {
+		return true, ""
+	}
+	return true, strings.TrimSpace(string(content))
+}
@@ -0,0 +1,32 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestGDSModule(t *testing.T) {
+	setupSysfsRoot(t, nil)
+
+	loaded, version := gdsModule()
+	must.False(t, loaded)
+	must.Eq(t, "", version)
+
+	// the module is loaded without reporting its version
+	dir := filepath.Join(sysfsRoot, "module", gdsModuleName)
+	must.NoError(t, os.MkdirAll(dir, 0o755))
+	loaded, version = gdsModule()
+	must.True(t, loaded)
+	must.Eq(t, "", version)
+
+	must.NoError(t, os.WriteFile(filepath.Join(dir, "version"), []byte("2.17.5\n"), 0o444))
+	loaded, version = gdsModule()
+	must.True(t, loaded)
+	must.Eq(t, "2.17.5", version)
+}
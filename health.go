@@ -0,0 +1,394 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/plugins/device"
+)
+
+// deviceHealth describes why a device was marked unhealthy
+type deviceHealth struct {
+	// causes maps the checks that marked the device unhealthy to the reason
+	// they reported. Each check only clears its own cause, so the device is
+	// healthy again once every cause is cleared.
+	causes map[string]string
+
+	// since is when the device was marked unhealthy
+	since time.Time
+}
+
+// reason returns the device health description reported to Nomad, made of
+// the reasons of every cause
+func (h *deviceHealth) reason() string {
+	causes := slices.Sorted(maps.Keys(h.causes))
+	reasons := make([]string, len(causes))
+	for i, cause := range causes {
+		reasons[i] = h.causes[cause]
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// Causes of devices being marked unhealthy
+const (
+	healthCauseLeftoverProcesses = "leftover_processes"
+	healthCauseFatalError        = "fatal_error"
+	healthCauseCircuitBreaker    = "circuit_breaker"
+	healthCauseMaintenance       = "maintenance"
+	healthCauseBAR1Exhaustion    = "bar1_exhaustion"
+	healthCauseCorruptedInfoROM  = "corrupted_inforom"
+)
+
+// setDeviceUnhealthy marks the device with the given UUID unhealthy for
+// cause, so that it is reported as such on the next fingerprint and can not be
+// reserved until every cause is cleared. Fatal states are never cleared, as
+// they require an operator. It reports whether the device was newly marked
+// unhealthy for cause.
+func (d *NvidiaDevice) setDeviceUnhealthy(uuid, cause, reason string) bool {
+	marked, changed := d.addHealthCause(uuid, cause, reason)
+	if changed {
+		d.saveHealthState()
+	}
+	return marked
+}
+
+// addHealthCause records that the device with the given UUID is unhealthy
+// for cause. It reports whether the device was newly marked unhealthy for
+// cause, and whether its health changed at all.
+func (d *NvidiaDevice) addHealthCause(uuid, cause, reason string) (bool, bool) {
+	d.deviceLock.Lock()
+	defer d.deviceLock.Unlock()
+
+	if d.unhealthy == nil {
+		d.unhealthy = make(map[string]*deviceHealth)
+	}
+	health, ok := d.unhealthy[uuid]
+	if !ok {
+		d.notifier.notify(uuid, healthStateHealthy, healthStateUnhealthy, reason)
+		health = &deviceHealth{
+			causes: make(map[string]string),
+			since:  d.now(),
+		}
+		d.unhealthy[uuid] = health
+	}
+	previous, marked := health.causes[cause]
+	if marked && previous == reason {
+		return false, false
+	}
+	if !marked {
+		d.logger.Warn("device marked unhealthy", "uuid", uuid, "cause", cause, "reason", reason)
+	}
+	health.causes[cause] = reason
+	return !marked, true
+}
+
+// setDeviceHealthy clears the cause of the device with the given UUID being
+// unhealthy. The device is marked healthy once it has no cause left.
+func (d *NvidiaDevice) setDeviceHealthy(uuid, cause string) {
+	if d.removeHealthCause(uuid, cause) {
+		d.saveHealthState()
+	}
+}
+
+// removeHealthCause clears the cause of the device with the given UUID being
+// unhealthy, and reports whether the device was unhealthy for cause
+func (d *NvidiaDevice) removeHealthCause(uuid, cause string) bool {
+	d.deviceLock.Lock()
+	defer d.deviceLock.Unlock()
+
+	health, ok := d.unhealthy[uuid]
+	if !ok {
+		return false
+	}
+	if _, ok := health.causes[cause]; !ok {
+		return false
+	}
+	delete(health.causes, cause)
+	if len(health.causes) == 0 {
+		d.logger.Info("device marked healthy", "uuid", uuid)
+		d.notifier.notify(uuid, healthStateUnhealthy, healthStateHealthy, "")
+		delete(d.unhealthy, uuid)
+	}
+	return true
+}
+
+// hasHealthCause reports whether the device with the given UUID was marked
+// unhealthy for cause. It must be called with deviceLock held.
+func (d *NvidiaDevice) hasHealthCause(uuid, cause string) bool {
+	health, ok := d.unhealthy[uuid]
+	if !ok {
+		return false
+	}
+	_, ok = health.causes[cause]
+	return ok
+}
+
+// healthStreakKey identifies the health check of a device whose consecutive
+// samples are counted
+type healthStreakKey struct {
+	uuid  string
+	cause string
+}
+
+// observeDeviceHealth records a sample of the health check identified by
+// cause for the device with the given UUID. The device is marked unhealthy
+// once the check failed for unhealthySamples consecutive samples, and healthy
+// again once it passed for healthySamples consecutive samples, so that
+// transient spikes do not flap the health reported to the scheduler.
+func (d *NvidiaDevice) observeDeviceHealth(uuid, cause string, healthy bool, reason string) {
+	d.deviceLock.Lock()
+	marked := d.hasHealthCause(uuid, cause)
+	key := healthStreakKey{uuid: uuid, cause: cause}
+	if healthy != marked {
+		// the sample agrees with the current health of the device
+		delete(d.healthStreaks, key)
+		d.deviceLock.Unlock()
+		if marked {
+			d.setDeviceUnhealthy(uuid, cause, reason)
+		}
+		return
+	}
+
+	required := d.unhealthySamples
+	if marked {
+		required = d.healthySamples
+	}
+	if d.healthStreaks == nil {
+		d.healthStreaks = make(map[healthStreakKey]int)
+	}
+	d.healthStreaks[key]++
+	if d.healthStreaks[key] < required {
+		d.deviceLock.Unlock()
+		return
+	}
+	delete(d.healthStreaks, key)
+	d.deviceLock.Unlock()
+
+	if healthy {
+		d.setDeviceHealthy(uuid, cause)
+	} else {
+		d.setDeviceUnhealthy(uuid, cause, reason)
+	}
+}
+
+// unhealthyDevices returns the UUIDs of devices marked unhealthy by cause
+func (d *NvidiaDevice) unhealthyDevices(cause string) []string {
+	d.deviceLock.RLock()
+	defer d.deviceLock.RUnlock()
+
+	var uuids []string
+	for uuid := range d.unhealthy {
+		if d.hasHealthCause(uuid, cause) {
+			uuids = append(uuids, uuid)
+		}
+	}
+	sort.Strings(uuids)
+	return uuids
+}
+
+// checkFatalErrors marks the known devices that entered a fatal state
+// unhealthy and runs the fatal error action for each of them. Fatal states
+// require operator intervention, so such devices stay unhealthy.
+func (d *NvidiaDevice) checkFatalErrors() {
+	d.deviceLock.RLock()
+	var uuids []string
+	for uuid := range d.devices {
+		if d.hasHealthCause(uuid, healthCauseFatalError) {
+			continue
+		}
+		uuids = append(uuids, uuid)
+	}
+	d.deviceLock.RUnlock()
+	sort.Strings(uuids)
+
+	ctx := d.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for _, uuid := range uuids {
+		reason, err := d.collector.GetFatalError(uuid)
+		if err != nil {
+			d.errorLog.Error(d.logger, "failed to check device for fatal errors", err, "uuid", uuid)
+			continue
+		}
+		if reason == "" {
+			continue
+		}
+
+		// the action only runs when the device enters the fatal state
+		if d.setDeviceUnhealthy(uuid, healthCauseFatalError, reason) && d.fatalErrorAction != nil {
+			go d.fatalErrorAction.run(ctx, uuid, reason)
+		}
+	}
+}
+
+// checkFailingDevices marks the devices that are not queried because their
+// circuit breaker is open unhealthy, and the devices whose breaker closed
+// again healthy
+func (d *NvidiaDevice) checkFailingDevices(failing map[string]string) {
+	for _, uuid := range d.unhealthyDevices(healthCauseCircuitBreaker) {
+		if _, ok := failing[uuid]; !ok {
+			d.setDeviceHealthy(uuid, healthCauseCircuitBreaker)
+		}
+	}
+
+	uuids := make([]string, 0, len(failing))
+	for uuid := range failing {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+	for _, uuid := range uuids {
+		d.setDeviceUnhealthy(uuid, healthCauseCircuitBreaker, failing[uuid])
+	}
+}
+
+// checkBAR1Usage marks the devices whose BAR1 buffer usage reached the
+// degraded threshold unhealthy, and the devices whose usage went back below
+// it healthy, subject to the health sample counts. Running out of BAR1 breaks
+// GPUDirect and peer mappings, so new allocations are kept off degraded
+// devices. Devices without stats are left as is.
+func (d *NvidiaDevice) checkBAR1Usage(statsData []*nvml.StatsData) {
+	if d.bar1DegradedThreshold == 0 {
+		return
+	}
+
+	for _, statsItem := range statsData {
+		percent, ok := percentUsed(statsItem.BAR1UsedMiB, statsItem.BAR1MiB)
+		if !ok {
+			continue
+		}
+
+		d.observeDeviceHealth(statsItem.UUID, healthCauseBAR1Exhaustion, percent < uint64(d.bar1DegradedThreshold),
+			fmt.Sprintf("degraded: %d%% of BAR1 memory used, threshold is %d%%", percent, d.bar1DegradedThreshold))
+	}
+}
+
+// checkInfoROM marks the devices whose InfoROM failed validation unhealthy,
+// as a corrupted InfoROM silently misreports ECC errors, and the devices
+// whose InfoROM is valid again, after being reflashed, healthy, subject to
+// the health sample counts
+func (d *NvidiaDevice) checkInfoROM(devices []*nvml.FingerprintDeviceData) {
+	for _, dev := range devices {
+		d.observeDeviceHealth(dev.UUID, healthCauseCorruptedInfoROM, !dev.InfoROMCorrupted,
+			"InfoROM is corrupted, ECC error counts can not be trusted")
+	}
+}
+
+// applyDeviceHealth updates the health of the devices in deviceGroups with
+// the recorded unhealthy states
+func (d *NvidiaDevice) applyDeviceHealth(deviceGroups []*device.DeviceGroup) {
+	d.deviceLock.RLock()
+	defer d.deviceLock.RUnlock()
+
+	for _, deviceGroup := range deviceGroups {
+		for _, dev := range deviceGroup.Devices {
+			if health, ok := d.unhealthy[dev.ID]; ok {
+				dev.Healthy = false
+				dev.HealthDesc = health.reason()
+			} else if warning, ok := d.licenseWarnings[dev.ID]; ok {
+				dev.HealthDesc = warning
+			} else if _, ok := d.persistenceWarnings[dev.ID]; ok {
+				dev.HealthDesc = persistenceModeWarningDesc
+			}
+		}
+	}
+}
+
+// healthStatus is the content of the health status file
+type healthStatus struct {
+	// UpdatedAt is when the file was last written, watchdogs can use it to
+	// detect that the plugin stopped fingerprinting
+	UpdatedAt time.Time             `json:"updated_at"`
+	Devices   []*deviceHealthStatus `json:"devices"`
+}
+
+// deviceHealthStatus is the health of a single device in the health status
+// file
+type deviceHealthStatus struct {
+	UUID    string     `json:"uuid"`
+	Name    string     `json:"name"`
+	Healthy bool       `json:"healthy"`
+	Reason  string     `json:"reason,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+// healthStatusOf returns the health status of the devices in deviceGroups
+func (d *NvidiaDevice) healthStatusOf(deviceGroups []*device.DeviceGroup, now time.Time) *healthStatus {
+	d.deviceLock.RLock()
+	defer d.deviceLock.RUnlock()
+
+	status := &healthStatus{
+		UpdatedAt: now,
+		Devices:   []*deviceHealthStatus{},
+	}
+	for _, deviceGroup := range deviceGroups {
+		for _, dev := range deviceGroup.Devices {
+			devStatus := &deviceHealthStatus{
+				UUID:    dev.ID,
+				Name:    deviceGroup.Name,
+				Healthy: dev.Healthy,
+				Reason:  dev.HealthDesc,
+			}
+			if health, ok := d.unhealthy[dev.ID]; ok {
+				devStatus.Since = &health.since
+			}
+			status.Devices = append(status.Devices, devStatus)
+		}
+	}
+	return status
+}
+
+// writeHealthStatus writes the health of the devices in deviceGroups to the
+// health status file, if configured. The file is replaced atomically so that
+// readers never see a partial write.
+func (d *NvidiaDevice) writeHealthStatus(deviceGroups []*device.DeviceGroup) {
+	if d.healthStatusFile == "" {
+		return
+	}
+
+	if err := writeFileAtomic(d.healthStatusFile, d.healthStatusOf(deviceGroups, d.now())); err != nil {
+		d.errorLog.Error(d.logger, "failed to write health status file", err)
+	}
+}
+
+// writeFileAtomic writes v as JSON to a temporary file next to path and
+// renames it to path
+func writeFileAtomic(path string, v any) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %q: %v", path, err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create %q: %v", path, err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %q: %v", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write %q: %v", path, err)
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return fmt.Errorf("failed to write %q: %v", path, err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %q: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/shoenig/test/must"
+)
+
+func TestDeviceHealth(t *testing.T) {
+	d := &NvidiaDevice{
+		logger: hclog.NewNullLogger(),
+	}
+	groups := func() []*device.DeviceGroup {
+		return []*device.DeviceGroup{{
+			Devices: []*device.Device{
+				{ID: "UUID1", Healthy: true},
+				{ID: "UUID2", Healthy: true},
+			},
+		}}
+	}
+
+	d.setDeviceUnhealthy("UUID2", "test", "fallen off the bus")
+	actual := groups()
+	d.applyDeviceHealth(actual)
+	must.True(t, actual[0].Devices[0].Healthy)
+	must.False(t, actual[0].Devices[1].Healthy)
+	must.Eq(t, "fallen off the bus", actual[0].Devices[1].HealthDesc)
+
+	d.setDeviceHealthy("UUID2", "test")
+	actual = groups()
+	d.applyDeviceHealth(actual)
+	must.True(t, actual[0].Devices[1].Healthy)
+	must.Eq(t, "", actual[0].Devices[1].HealthDesc)
+}
+
+func TestReservationErrorCode(t *testing.T) {
+	err := &reservationError{ReservationErrUnhealthy, []string{"UUID1", "UUID2"}}
+	must.Eq(t, ReservationErrUnhealthy, err.Code())
+	must.EqError(t, err, "unhealthy device IDs: UUID1,UUID2")
+}
+
+func TestWriteHealthStatus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "health.json")
+	d := &NvidiaDevice{
+		logger:           hclog.NewNullLogger(),
+		healthStatusFile: path,
+	}
+	d.setDeviceUnhealthy("UUID2", "test", "fallen off the bus")
+
+	groups := []*device.DeviceGroup{{
+		Name: "Tesla T4",
+		Devices: []*device.Device{
+			{ID: "UUID1", Healthy: true},
+			{ID: "UUID2", Healthy: true},
+		},
+	}}
+	d.applyDeviceHealth(groups)
+	d.writeHealthStatus(groups)
+
+	content, err := os.ReadFile(path)
+	must.NoError(t, err)
+
+	var status healthStatus
+	must.NoError(t, json.Unmarshal(content, &status))
+	must.False(t, status.UpdatedAt.IsZero())
+	must.Len(t, 2, status.Devices)
+	must.Eq(t, &deviceHealthStatus{UUID: "UUID1", Name: "Tesla T4", Healthy: true}, status.Devices[0])
+	must.Eq(t, "UUID2", status.Devices[1].UUID)
+	must.False(t, status.Devices[1].Healthy)
+	must.Eq(t, "fallen off the bus", status.Devices[1].Reason)
+	must.NotNil(t, status.Devices[1].Since)
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	must.NoError(t, err)
+	must.Len(t, 1, entries)
+}
+
+func TestCheckFatalErrors(t *testing.T) {
+	d := &NvidiaDevice{
+		devices: map[string]struct{}{
+			"UUID1": {},
+			"UUID2": {},
+		},
+		collector: &MockNvmlClient{
+			FatalErrorsReturned: map[string]string{
+				"UUID2": "GPU has fallen off the bus",
+			},
+		},
+		logger: hclog.NewNullLogger(),
+	}
+
+	d.checkFatalErrors()
+	must.Eq(t, []string{"UUID2"}, d.unhealthyDevices(healthCauseFatalError))
+	must.Eq(t, "GPU has fallen off the bus", d.unhealthy["UUID2"].reason())
+}
+
+func TestCheckFatalErrorsCircuitBreaker(t *testing.T) {
+	requests := make(chan fatalErrorEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event fatalErrorEvent
+		must.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		requests <- event
+	}))
+	defer server.Close()
+
+	action, err := newFatalErrorAction(FatalErrorActionConfig{
+		Action:     fatalActionWebhook,
+		WebhookURL: server.URL,
+	}, time.Now, hclog.NewNullLogger())
+	must.NoError(t, err)
+
+	d := &NvidiaDevice{
+		devices: map[string]struct{}{
+			"UUID1": {},
+		},
+		collector:        &MockNvmlClient{},
+		fatalErrorAction: action,
+		logger:           hclog.NewNullLogger(),
+	}
+	failing := map[string]string{"UUID1": "3 consecutive NVML queries failed"}
+
+	d.checkFailingDevices(failing)
+	must.Eq(t, []string{"UUID1"}, d.unhealthyDevices(healthCauseCircuitBreaker))
+
+	// a fatal error is recorded along with the open circuit breaker
+	d.collector = &MockNvmlClient{
+		FatalErrorsReturned: map[string]string{"UUID1": "GPU has fallen off the bus"},
+	}
+	d.checkFatalErrors()
+	must.Eq(t, []string{"UUID1"}, d.unhealthyDevices(healthCauseFatalError))
+
+	// and is not cleared by the circuit breaker closing
+	d.checkFailingDevices(failing)
+	must.Eq(t, []string{"UUID1"}, d.unhealthyDevices(healthCauseFatalError))
+	must.Eq(t, "3 consecutive NVML queries failed; GPU has fallen off the bus", d.unhealthy["UUID1"].reason())
+	d.checkFatalErrors()
+	d.checkFailingDevices(nil)
+	must.SliceEmpty(t, d.unhealthyDevices(healthCauseCircuitBreaker))
+	must.Eq(t, []string{"UUID1"}, d.unhealthyDevices(healthCauseFatalError))
+	must.Eq(t, "GPU has fallen off the bus", d.unhealthy["UUID1"].reason())
+	d.checkFatalErrors()
+
+	// the action only ran when the device entered the fatal state
+	select {
+	case event := <-requests:
+		must.Eq(t, "UUID1", event.UUID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("fatal error action did not run")
+	}
+	select {
+	case <-requests:
+		t.Fatal("fatal error action ran more than once")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestCheckFailingDevices(t *testing.T) {
+	d := &NvidiaDevice{
+		logger: hclog.NewNullLogger(),
+	}
+
+	d.checkFailingDevices(map[string]string{
+		"UUID1": "3 consecutive NVML queries failed",
+	})
+	must.Eq(t, []string{"UUID1"}, d.unhealthyDevices(healthCauseCircuitBreaker))
+	must.Eq(t, "3 consecutive NVML queries failed", d.unhealthy["UUID1"].reason())
+
+	d.checkFailingDevices(nil)
+	must.SliceEmpty(t, d.unhealthyDevices(healthCauseCircuitBreaker))
+}
+
+func TestCheckBAR1Usage(t *testing.T) {
+	d := &NvidiaDevice{
+		logger:                hclog.NewNullLogger(),
+		bar1DegradedThreshold: 90,
+	}
+	bar1Stats := func(uuid string, usedMiB uint64) *nvml.StatsData {
+		return &nvml.StatsData{
+			DeviceData:  &nvml.DeviceData{UUID: uuid, BAR1MiB: pointer.Of(uint64(256))},
+			BAR1UsedMiB: pointer.Of(usedMiB),
+		}
+	}
+	d.setDeviceUnhealthy("UUID3", healthCauseFatalError, "GPU has fallen off the bus")
+
+	d.checkBAR1Usage([]*nvml.StatsData{
+		bar1Stats("UUID1", 250),
+		bar1Stats("UUID2", 16),
+		bar1Stats("UUID3", 250),
+	})
+	must.Eq(t, []string{"UUID1", "UUID3"}, d.unhealthyDevices(healthCauseBAR1Exhaustion))
+	must.Eq(t, "degraded: 98% of BAR1 memory used, threshold is 90%", d.unhealthy["UUID1"].reason())
+
+	// devices unhealthy for another cause stay so once their usage is back
+	d.checkBAR1Usage([]*nvml.StatsData{
+		bar1Stats("UUID1", 128),
+		bar1Stats("UUID3", 16),
+	})
+	must.SliceEmpty(t, d.unhealthyDevices(healthCauseBAR1Exhaustion))
+	must.MapNotContainsKey(t, d.unhealthy, "UUID1")
+	must.Eq(t, []string{"UUID3"}, d.unhealthyDevices(healthCauseFatalError))
+}
+
+func TestCheckBAR1UsageHysteresis(t *testing.T) {
+	d := &NvidiaDevice{
+		logger:                hclog.NewNullLogger(),
+		bar1DegradedThreshold: 90,
+		unhealthySamples:      3,
+		healthySamples:        2,
+	}
+	check := func(usedMiB uint64) {
+		d.checkBAR1Usage([]*nvml.StatsData{{
+			DeviceData:  &nvml.DeviceData{UUID: "UUID1", BAR1MiB: pointer.Of(uint64(256))},
+			BAR1UsedMiB: pointer.Of(usedMiB),
+		}})
+	}
+
+	// a transient spike does not mark the device unhealthy
+	check(250)
+	check(250)
+	check(16)
+	check(250)
+	must.SliceEmpty(t, d.unhealthyDevices(healthCauseBAR1Exhaustion))
+	check(250)
+	check(250)
+	must.Eq(t, []string{"UUID1"}, d.unhealthyDevices(healthCauseBAR1Exhaustion))
+
+	// the device is marked healthy once clean for consecutive samples
+	check(16)
+	check(250)
+	check(16)
+	must.Eq(t, []string{"UUID1"}, d.unhealthyDevices(healthCauseBAR1Exhaustion))
+	check(16)
+	must.SliceEmpty(t, d.unhealthyDevices(healthCauseBAR1Exhaustion))
+}
+
+func TestCheckInfoROM(t *testing.T) {
+	d := &NvidiaDevice{
+		logger: hclog.NewNullLogger(),
+	}
+	inforom := func(uuid string, corrupted bool) *nvml.FingerprintDeviceData {
+		return &nvml.FingerprintDeviceData{
+			DeviceData:       &nvml.DeviceData{UUID: uuid},
+			InfoROMCorrupted: corrupted,
+		}
+	}
+	d.setDeviceUnhealthy("UUID3", healthCauseFatalError, "GPU has fallen off the bus")
+
+	d.checkInfoROM([]*nvml.FingerprintDeviceData{
+		inforom("UUID1", true),
+		inforom("UUID2", false),
+		inforom("UUID3", true),
+	})
+	must.Eq(t, []string{"UUID1", "UUID3"}, d.unhealthyDevices(healthCauseCorruptedInfoROM))
+	must.Eq(t, "InfoROM is corrupted, ECC error counts can not be trusted", d.unhealthy["UUID1"].reason())
+
+	// devices unhealthy for another cause stay so once reflashed
+	d.checkInfoROM([]*nvml.FingerprintDeviceData{
+		inforom("UUID1", false),
+		inforom("UUID3", false),
+	})
+	must.SliceEmpty(t, d.unhealthyDevices(healthCauseCorruptedInfoROM))
+	must.MapNotContainsKey(t, d.unhealthy, "UUID1")
+	must.Eq(t, []string{"UUID3"}, d.unhealthyDevices(healthCauseFatalError))
+}
+
+func TestDeviceHealthCauses(t *testing.T) {
+	d := &NvidiaDevice{
+		logger: hclog.NewNullLogger(),
+	}
+
+	must.True(t, d.setDeviceUnhealthy("UUID1", healthCauseCorruptedInfoROM, "InfoROM is corrupted"))
+	since := d.unhealthy["UUID1"].since
+
+	// another cause does not replace the first one
+	must.True(t, d.setDeviceUnhealthy("UUID1", healthCauseLeftoverProcesses, "leftover compute processes: 1234"))
+	must.False(t, d.setDeviceUnhealthy("UUID1", healthCauseLeftoverProcesses, "leftover compute processes: 1234"))
+	must.Eq(t, "InfoROM is corrupted; leftover compute processes: 1234", d.unhealthy["UUID1"].reason())
+
+	// the device stays unhealthy until every cause is cleared
+	d.setDeviceHealthy("UUID1", healthCauseLeftoverProcesses)
+	must.Eq(t, "InfoROM is corrupted", d.unhealthy["UUID1"].reason())
+	must.Eq(t, since, d.unhealthy["UUID1"].since)
+	d.setDeviceHealthy("UUID1", healthCauseMaintenance)
+	must.MapContainsKey(t, d.unhealthy, "UUID1")
+	d.setDeviceHealthy("UUID1", healthCauseCorruptedInfoROM)
+	must.MapEmpty(t, d.unhealthy)
+}
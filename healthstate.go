@@ -0,0 +1,125 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// healthState is the content of the health state file, which persists the
+// devices marked unhealthy across plugin restarts
+type healthState struct {
+	Devices []*deviceHealthRecord `json:"devices"`
+}
+
+// deviceHealthRecord is a cause of a device being marked unhealthy in the
+// health state file, devices marked unhealthy for several causes have a record
+// for each
+type deviceHealthRecord struct {
+	UUID   string    `json:"uuid"`
+	Cause  string    `json:"cause"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+// readHealthState returns the devices marked unhealthy recorded in the health
+// state file at path. A missing file records no devices.
+func readHealthState(path string) (map[string]*deviceHealth, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %v", path, err)
+	}
+
+	var state healthState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %v", path, err)
+	}
+	unhealthy := make(map[string]*deviceHealth, len(state.Devices))
+	for _, record := range state.Devices {
+		if record.UUID == "" {
+			continue
+		}
+		health, ok := unhealthy[record.UUID]
+		if !ok {
+			health = &deviceHealth{
+				causes: make(map[string]string),
+				since:  record.Since,
+			}
+			unhealthy[record.UUID] = health
+		}
+		health.causes[record.Cause] = record.Reason
+	}
+	return unhealthy, nil
+}
+
+// loadHealthState restores the devices marked unhealthy recorded in the
+// health state file, if configured. Checks whose cause is gone clear the
+// restored devices again, while devices in a fatal state stay unhealthy.
+func (d *NvidiaDevice) loadHealthState() {
+	if d.healthStateFile == "" {
+		return
+	}
+
+	unhealthy, err := readHealthState(d.healthStateFile)
+	if err != nil {
+		d.logger.Warn("failed to restore device health state", "error", err)
+		return
+	}
+
+	d.deviceLock.Lock()
+	defer d.deviceLock.Unlock()
+
+	if d.unhealthy == nil {
+		d.unhealthy = make(map[string]*deviceHealth)
+	}
+	for uuid, health := range unhealthy {
+		d.logger.Info("restored unhealthy device", "uuid", uuid, "reason", health.reason())
+		d.unhealthy[uuid] = health
+	}
+}
+
+// saveHealthState writes the devices marked unhealthy to the health state
+// file, if configured. The records are copied under deviceLock, which must
+// not be held, and written once it is released. Writes are serialized by
+// healthStateLock, so that the last write holds the latest records.
+func (d *NvidiaDevice) saveHealthState() {
+	if d.healthStateFile == "" {
+		return
+	}
+
+	d.healthStateLock.Lock()
+	defer d.healthStateLock.Unlock()
+
+	d.deviceLock.RLock()
+	state := &healthState{Devices: make([]*deviceHealthRecord, 0, len(d.unhealthy))}
+	for uuid, health := range d.unhealthy {
+		for cause, reason := range health.causes {
+			state.Devices = append(state.Devices, &deviceHealthRecord{
+				UUID:   uuid,
+				Cause:  cause,
+				Reason: reason,
+				Since:  health.since,
+			})
+		}
+	}
+	d.deviceLock.RUnlock()
+
+	sort.Slice(state.Devices, func(i, j int) bool {
+		if state.Devices[i].UUID != state.Devices[j].UUID {
+			return state.Devices[i].UUID < state.Devices[j].UUID
+		}
+		return state.Devices[i].Cause < state.Devices[j].Cause
+	})
+
+	if err := writeFileAtomic(d.healthStateFile, state); err != nil {
+		d.errorLog.Error(d.logger, "failed to write health state file", err)
+	}
+}
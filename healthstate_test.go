@@ -0,0 +1,65 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/shoenig/test/must"
+)
+
+func TestHealthState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "health-state.json")
+	newDevice := func() *NvidiaDevice {
+		d := &NvidiaDevice{
+			logger:          hclog.NewNullLogger(),
+			healthStateFile: path,
+		}
+		d.loadHealthState()
+		return d
+	}
+
+	// a missing file restores no devices
+	d := newDevice()
+	must.MapEmpty(t, d.unhealthy)
+
+	d.setDeviceUnhealthy("UUID1", healthCauseFatalError, "uncorrectable ECC error")
+	d.setDeviceUnhealthy("UUID2", healthCauseLeftoverProcesses, "leftover processes")
+	d.setDeviceUnhealthy("UUID2", healthCauseMaintenance, maintenanceReason)
+	since := d.unhealthy["UUID1"].since
+
+	// the unhealthy devices survive a restart
+	d = newDevice()
+	must.Eq(t, []string{"UUID1"}, d.unhealthyDevices(healthCauseFatalError))
+	must.Eq(t, []string{"UUID2"}, d.unhealthyDevices(healthCauseLeftoverProcesses))
+	must.Eq(t, []string{"UUID2"}, d.unhealthyDevices(healthCauseMaintenance))
+	must.Eq(t, "uncorrectable ECC error", d.unhealthy["UUID1"].reason())
+	must.True(t, since.Equal(d.unhealthy["UUID1"].since))
+
+	// devices marked healthy are removed from the file
+	d.setDeviceHealthy("UUID2", healthCauseLeftoverProcesses)
+	d.setDeviceHealthy("UUID2", healthCauseMaintenance)
+	d = newDevice()
+	must.MapLen(t, 1, d.unhealthy)
+	must.MapContainsKey(t, d.unhealthy, "UUID1")
+}
+
+func TestReadHealthState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "health-state.json")
+	must.NoError(t, os.WriteFile(path, []byte("{"), 0o644))
+
+	_, err := readHealthState(path)
+	must.ErrorContains(t, err, "failed to parse")
+
+	// a corrupt file is ignored
+	d := &NvidiaDevice{
+		logger:          hclog.NewNullLogger(),
+		healthStateFile: path,
+	}
+	d.loadHealthState()
+	must.MapEmpty(t, d.unhealthy)
+}
@@ -0,0 +1,51 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// hotplugCheckPeriod is the period at which the PCI devices of the node are
+// listed while fingerprinting is suspended, which is cheap enough to detect
+// hotplugged GPUs without querying NVML
+const hotplugCheckPeriod = 10 * time.Second
+
+// nvidiaPCIVendorID is the PCI vendor ID of Nvidia devices as reported in
+// sysfs
+const nvidiaPCIVendorID = "0x10de"
+
+// nvidiaPCIDevices returns the sorted sysfs PCI addresses of the Nvidia
+// devices of the node, whether or not a driver is bound to them
+func nvidiaPCIDevices() []string {
+	vendors, err := filepath.Glob(filepath.Join(sysfsRoot, "bus", "pci", "devices", "*", "vendor"))
+	if err != nil {
+		return nil
+	}
+	var addresses []string
+	for _, vendor := range vendors {
+		content, err := os.ReadFile(vendor)
+		if err != nil || string(bytes.TrimSpace(content)) != nvidiaPCIVendorID {
+			continue
+		}
+		addresses = append(addresses, filepath.Base(filepath.Dir(vendor)))
+	}
+	slices.Sort(addresses)
+	return addresses
+}
+
+// hotplugged reports whether suspended fingerprinting must resume because the
+// Nvidia PCI devices of the node differ from suspended, the devices present
+// when fingerprinting was suspended after NVML reported no devices at all
+func (d *NvidiaDevice) hotplugged(suspended []string) bool {
+	if slices.Equal(nvidiaPCIDevices(), suspended) {
+		return false
+	}
+	d.logger.Info("Nvidia PCI devices changed, resuming fingerprinting")
+	return true
+}
@@ -0,0 +1,41 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/shoenig/test/must"
+)
+
+func TestNvidiaPCIDevices(t *testing.T) {
+	setupSysfsRoot(t, map[string]map[string]string{
+		"0000:41:00.0": {"vendor": "0x10de\n"},
+		"0000:00:1f.0": {"vendor": "0x8086\n"},
+		"0000:21:00.0": {"vendor": "0x10de\n"},
+		"0000:22:00.0": {},
+	})
+	must.Eq(t, []string{"0000:21:00.0", "0000:41:00.0"}, nvidiaPCIDevices())
+}
+
+func TestHotplugged(t *testing.T) {
+	setupSysfsRoot(t, map[string]map[string]string{
+		"0000:00:1f.0": {"vendor": "0x8086\n"},
+	})
+	d := &NvidiaDevice{
+		logger: hclog.NewNullLogger(),
+	}
+
+	suspended := nvidiaPCIDevices()
+	must.False(t, d.hotplugged(suspended))
+
+	// a hotplugged Nvidia device resumes fingerprinting
+	dir := filepath.Join(sysfsRoot, "bus", "pci", "devices", "0000:41:00.0")
+	must.NoError(t, os.MkdirAll(dir, 0o755))
+	must.NoError(t, os.WriteFile(filepath.Join(dir, "vendor"), []byte("0x10de\n"), 0o644))
+	must.True(t, d.hotplugged(suspended))
+}
@@ -0,0 +1,140 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"time"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+)
+
+const (
+	// Stats attribute of the time a reserved device has been idle
+	IdleReservedAttr = "Idle reserved time"
+	IdleReservedDesc = "Time this reserved GPU has been idle, once idle for longer than the idle reserved window"
+
+	// idleMaxUtilization is the highest GPU utilization of devices that are
+	// considered idle
+	idleMaxUtilization = 1 // %
+)
+
+// idleReservation tracks the utilization of a reserved device
+type idleReservation struct {
+	// idleSince is when the device was last seen busy, or reserved
+	idleSince time.Time
+
+	// processSince is when compute processes were last seen on the device,
+	// or when it was reserved. processSeen indicates whether any was seen
+	// since the device was reserved.
+	processSince time.Time
+	processSeen  bool
+
+	// reported indicates whether the device being idle for longer than the
+	// window was logged
+	reported bool
+}
+
+// trackIdleReservations starts tracking the utilization of the reserved
+// devices in deviceIDs.
+//
+// Nomad does not notify device plugins when a reservation is released, so
+// this is guessed from the compute processes of the device: the idle time of
+// a device is only reported once a compute process was seen on it since it
+// was reserved, and the device is no longer tracked once no compute process
+// was seen on it for idleReservedWindow. Devices reserved by tasks that never
+// use them are thus not reported idle.
+func (d *NvidiaDevice) trackIdleReservations(deviceIDs []string) {
+	if d.idleReservedWindow == 0 {
+		return
+	}
+
+	d.idleLock.Lock()
+	defer d.idleLock.Unlock()
+
+	if d.idleReservations == nil {
+		d.idleReservations = make(map[string]*idleReservation)
+	}
+	now := d.now()
+	for _, id := range deviceIDs {
+		d.idleReservations[id] = &idleReservation{idleSince: now, processSince: now}
+	}
+}
+
+// addIdleReservedStats updates the idle time of every reserved device from
+// the GPU utilization of statsData, and adds it to the stats of the device in
+// groups once it exceeds idleReservedWindow, zero before. Devices that do not
+// report their utilization, such as MIG instances, have no idle time, and
+// devices whose reservation looks released are no longer tracked, see
+// trackIdleReservations.
+func (d *NvidiaDevice) addIdleReservedStats(groups []*device.DeviceGroupStats, statsData []*nvml.StatsData) {
+	_, enabled := d.statsOptions.enabledMetrics[IdleReservedAttr]
+	enabled = enabled || d.statsOptions.enabledMetrics == nil
+
+	d.idleLock.Lock()
+	defer d.idleLock.Unlock()
+
+	now := d.now()
+	idle := make(map[string]time.Duration, len(d.idleReservations))
+	for _, statsItem := range statsData {
+		reservation, ok := d.idleReservations[statsItem.UUID]
+		if !ok || statsItem.GPUUtilization == nil {
+			continue
+		}
+
+		pids, err := d.collector.GetComputeProcesses(statsItem.UUID)
+		if err != nil {
+			d.errorLog.Error(d.logger, "failed to get device compute processes", err, "uuid", statsItem.UUID)
+		} else if len(pids) != 0 {
+			reservation.processSince = now
+			reservation.processSeen = true
+		} else if now.Sub(reservation.processSince) >= d.idleReservedWindow {
+			delete(d.idleReservations, statsItem.UUID)
+			continue
+		}
+
+		if *statsItem.GPUUtilization > idleMaxUtilization {
+			reservation.idleSince = now
+			reservation.reported = false
+		}
+
+		idleFor := now.Sub(reservation.idleSince)
+		if !reservation.processSeen || idleFor < d.idleReservedWindow {
+			idle[statsItem.UUID] = 0
+			continue
+		}
+		idle[statsItem.UUID] = idleFor
+		if !reservation.reported {
+			reservation.reported = true
+			d.logger.Info("reserved device is idle", "uuid", statsItem.UUID,
+				"idle_since", reservation.idleSince, "window", d.idleReservedWindow)
+		}
+	}
+
+	if !enabled {
+		return
+	}
+	for _, group := range groups {
+		for uuid, deviceStats := range group.InstanceStats {
+			idleFor, ok := idle[uuid]
+			if !ok {
+				continue
+			}
+
+			if deviceStats.Stats == nil {
+				deviceStats.Stats = &structs.StatObject{}
+			}
+			if deviceStats.Stats.Attributes == nil {
+				deviceStats.Stats.Attributes = make(map[string]*structs.StatValue)
+			}
+			deviceStats.Stats.Attributes[IdleReservedAttr] = &structs.StatValue{
+				Unit:            UnitSeconds,
+				Desc:            IdleReservedDesc,
+				IntNumeratorVal: pointer.Of(int64(idleFor / time.Second)),
+			}
+		}
+	}
+}
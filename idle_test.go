@@ -0,0 +1,154 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+	"github.com/shoenig/test/must"
+)
+
+func TestAddIdleReservedStats(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	client := &MockNvmlClient{
+		ProcessesReturned: map[string][]int{
+			"UUID1": {1001},
+			"UUID2": {1002},
+		},
+	}
+	d := &NvidiaDevice{
+		logger:             hclog.NewNullLogger(),
+		clock:              ClockFunc(func() time.Time { return now }),
+		collector:          client,
+		idleReservedWindow: 30 * time.Minute,
+	}
+	d.trackIdleReservations([]string{"UUID1", "UUID2"})
+
+	collect := func(utilization map[string]uint) map[string]*structs.StatValue {
+		groups := []*device.DeviceGroupStats{{
+			Name: "Type1",
+			InstanceStats: map[string]*device.DeviceStats{
+				"UUID1": {},
+				"UUID2": {},
+				"UUID3": {},
+			},
+		}}
+		var statsData []*nvml.StatsData
+		for uuid, percent := range utilization {
+			statsData = append(statsData, &nvml.StatsData{
+				DeviceData:     &nvml.DeviceData{UUID: uuid},
+				GPUUtilization: pointer.Of(percent),
+			})
+		}
+		d.addIdleReservedStats(groups, statsData)
+
+		idle := make(map[string]*structs.StatValue)
+		for uuid, deviceStats := range groups[0].InstanceStats {
+			if deviceStats.Stats != nil {
+				idle[uuid] = deviceStats.Stats.Attributes[IdleReservedAttr]
+			}
+		}
+		return idle
+	}
+	idleStat := func(seconds int64) *structs.StatValue {
+		return &structs.StatValue{
+			Unit:            UnitSeconds,
+			Desc:            IdleReservedDesc,
+			IntNumeratorVal: pointer.Of(seconds),
+		}
+	}
+
+	// devices idle for less than the window report no idle time yet
+	now = now.Add(10 * time.Minute)
+	must.Eq(t, map[string]*structs.StatValue{
+		"UUID1": idleStat(0),
+		"UUID2": idleStat(0),
+	}, collect(map[string]uint{"UUID1": 0, "UUID2": 85, "UUID3": 0}))
+
+	// devices idle since they were reserved report the time since then,
+	// devices idle since they were last busy for less than the window do not
+	now = now.Add(25 * time.Minute)
+	must.Eq(t, map[string]*structs.StatValue{
+		"UUID1": idleStat(2100),
+		"UUID2": idleStat(0),
+	}, collect(map[string]uint{"UUID1": 1, "UUID2": 0, "UUID3": 0}))
+
+	// reserving a device again restarts its idle time
+	d.trackIdleReservations([]string{"UUID1"})
+	now = now.Add(time.Minute)
+	must.Eq(t, map[string]*structs.StatValue{
+		"UUID1": idleStat(0),
+	}, collect(map[string]uint{"UUID1": 0}))
+
+	// the stat can be left out by enabled_metrics
+	d.statsOptions.enabledMetrics = map[string]struct{}{GPUUtilizationAttr: {}}
+	now = now.Add(time.Hour)
+	must.MapEmpty(t, collect(map[string]uint{"UUID1": 0, "UUID2": 0}))
+}
+
+func TestAddIdleReservedStats_Released(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	client := &MockNvmlClient{
+		ProcessesReturned: map[string][]int{"UUID1": {1001}},
+	}
+	d := &NvidiaDevice{
+		logger:             hclog.NewNullLogger(),
+		clock:              ClockFunc(func() time.Time { return now }),
+		collector:          client,
+		idleReservedWindow: 30 * time.Minute,
+	}
+	d.trackIdleReservations([]string{"UUID1", "UUID2"})
+
+	collect := func() {
+		d.addIdleReservedStats(nil, []*nvml.StatsData{
+			{DeviceData: &nvml.DeviceData{UUID: "UUID1"}, GPUUtilization: pointer.Of(uint(0))},
+			{DeviceData: &nvml.DeviceData{UUID: "UUID2"}, GPUUtilization: pointer.Of(uint(0))},
+		})
+	}
+
+	// devices without compute processes are not reported idle
+	now = now.Add(10 * time.Minute)
+	collect()
+	must.MapContainsKeys(t, d.idleReservations, []string{"UUID1", "UUID2"})
+	must.True(t, d.idleReservations["UUID1"].processSeen)
+	must.False(t, d.idleReservations["UUID2"].processSeen)
+
+	// devices without compute processes for the window are no longer tracked
+	now = now.Add(25 * time.Minute)
+	collect()
+	must.MapContainsKeys(t, d.idleReservations, []string{"UUID1"})
+	must.MapNotContainsKey(t, d.idleReservations, "UUID2")
+
+	// the processes of released reservations exit
+	client.ProcessesReturned = nil
+	now = now.Add(20 * time.Minute)
+	collect()
+	must.MapContainsKeys(t, d.idleReservations, []string{"UUID1"})
+	now = now.Add(10 * time.Minute)
+	collect()
+	must.MapEmpty(t, d.idleReservations)
+}
+
+func TestIdleReservedWindowConfig(t *testing.T) {
+	d := newReplayDevice(t)
+	must.NoError(t, setPluginConfig(t, d, `
+config {
+  idle_reserved_window = "1h"
+}
+`))
+	must.Eq(t, time.Hour, d.idleReservedWindow)
+
+	err := setPluginConfig(t, d, `
+config {
+  idle_reserved_window = "-1h"
+}
+`)
+	must.ErrorContains(t, err, `invalid idle reserved window "-1h"`)
+}
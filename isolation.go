@@ -0,0 +1,290 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// nvidiaDeviceMajor is the major number of the /dev/nvidia* device nodes
+	nvidiaDeviceMajor = "195"
+
+	// nvidiaMaxGPUMinor is the highest minor number of a GPU device node,
+	// /dev/nvidia<minor>, higher minors are control devices such as
+	// /dev/nvidiactl, which every GPU process needs
+	nvidiaMaxGPUMinor = 253
+)
+
+// errNoDevicesCgroup is returned when a process is not in a cgroup v1
+// devices hierarchy. Device rules of cgroup v2 are eBPF programs, which can
+// not be inspected.
+var errNoDevicesCgroup = errors.New("process is not in a cgroup v1 devices hierarchy")
+
+// hasDevicesCgroup reports whether the node mounts a cgroup v1 devices
+// hierarchy, without which the GPU isolation of tasks can not be verified
+func hasDevicesCgroup() bool {
+	info, err := os.Stat(filepath.Join(sysfsRoot, "fs", "cgroup", "devices"))
+	return err == nil && info.IsDir()
+}
+
+// warnNoDevicesCgroup logs a warning when isolation checks are enabled on a
+// node without a cgroup v1 devices hierarchy, such as cgroup v2 only nodes,
+// as tasks are then never checked
+func (d *NvidiaDevice) warnNoDevicesCgroup() {
+	if d.isolationCheck && !hasDevicesCgroup() {
+		d.logger.Warn("GPU isolation of tasks can not be verified without a cgroup v1 devices hierarchy, isolation_check has no effect")
+	}
+}
+
+// isolationCheck is a reservation whose GPU isolation is verified once a
+// compute process runs on one of its devices
+type isolationCheck struct {
+	deviceIDs []string
+}
+
+// scheduleIsolationCheck records the reservation of deviceIDs so that the
+// device rules of its task are verified once the task uses its GPUs. Tasks
+// do not exist yet when devices are reserved.
+func (d *NvidiaDevice) scheduleIsolationCheck(deviceIDs []string) {
+	if !d.isolationCheck {
+		return
+	}
+
+	d.isolationLock.Lock()
+	defer d.isolationLock.Unlock()
+
+	if d.isolationChecks == nil {
+		d.isolationChecks = make(map[string]*isolationCheck)
+	}
+	check := &isolationCheck{deviceIDs: slices.Clone(deviceIDs)}
+	for _, id := range deviceIDs {
+		d.isolationChecks[id] = check
+	}
+}
+
+// checkIsolation verifies the pending isolation checks of the reservations
+// whose devices run compute processes, and logs a warning when such a
+// process can access GPUs its reservation does not include, which happens
+// with task drivers that leak all GPUs to every task.
+func (d *NvidiaDevice) checkIsolation() {
+	if !d.isolationCheck {
+		return
+	}
+
+	d.isolationLock.Lock()
+	defer d.isolationLock.Unlock()
+
+	checked := make(map[*isolationCheck]struct{})
+	for _, check := range d.isolationChecks {
+		if _, ok := checked[check]; ok {
+			continue
+		}
+		checked[check] = struct{}{}
+
+		pid, ok := d.reservationProcess(check)
+		if !ok {
+			continue
+		}
+		d.verifyIsolation(check, pid)
+		for _, id := range check.deviceIDs {
+			if d.isolationChecks[id] == check {
+				delete(d.isolationChecks, id)
+			}
+		}
+	}
+}
+
+// reservationProcess returns a compute process running on the devices of
+// the reservation, if any
+func (d *NvidiaDevice) reservationProcess(check *isolationCheck) (int, bool) {
+	for _, id := range check.deviceIDs {
+		pids, err := d.collector.GetComputeProcesses(id)
+		if err != nil {
+			d.errorLog.Error(d.logger, "failed to get device compute processes", err, "uuid", id)
+			continue
+		}
+		if len(pids) != 0 {
+			return pids[0], true
+		}
+	}
+	return 0, false
+}
+
+// verifyIsolation compares the GPUs the devices cgroup of the process with
+// the given pid allows with the GPUs of its reservation
+func (d *NvidiaDevice) verifyIsolation(check *isolationCheck, pid int) {
+	allowed, err := allowedGPUMinors(pid)
+	if errors.Is(err, errNoDevicesCgroup) {
+		d.logger.Debug("unable to verify GPU isolation of task", "pid", pid, "reason", err)
+		return
+	}
+	if err != nil {
+		d.logger.Warn("failed to verify GPU isolation of task", "pid", pid, "error", err)
+		return
+	}
+
+	reserved := make(map[int]struct{}, len(check.deviceIDs))
+	for _, id := range check.deviceIDs {
+		minor, err := d.gpuMinor(id)
+		if err != nil {
+			d.logger.Warn("failed to verify GPU isolation of task", "pid", pid, "uuid", id, "error", err)
+			return
+		}
+		reserved[minor] = struct{}{}
+	}
+
+	kernelAddresses, err := kernelGPUs()
+	if err != nil {
+		d.logger.Warn("failed to verify GPU isolation of task", "pid", pid, "error", err)
+		return
+	}
+	var leaked []string
+	for _, address := range kernelAddresses {
+		minor, err := deviceMinor(address)
+		if err != nil {
+			d.logger.Warn("failed to verify GPU isolation of task", "pid", pid, "error", err)
+			return
+		}
+		if _, ok := reserved[minor]; ok || !allowed(minor) {
+			continue
+		}
+		leaked = append(leaked, fmt.Sprintf("/dev/nvidia%d", minor))
+	}
+
+	if len(leaked) != 0 {
+		d.logger.Warn("task can access GPUs it did not reserve, its task driver does not isolate GPUs",
+			"uuids", check.deviceIDs, "pid", pid, "devices", leaked)
+		return
+	}
+	d.logger.Debug("verified GPU isolation of task", "uuids", check.deviceIDs, "pid", pid)
+}
+
+// gpuMinor returns the minor number of the device node of the GPU with the
+// given UUID, MIG devices use the device node of their physical GPU
+func (d *NvidiaDevice) gpuMinor(uuid string) (int, error) {
+	d.deviceLock.RLock()
+	id := uuid
+	if parent, ok := d.migParents[uuid]; ok {
+		id = parent
+	}
+	busID, ok := d.pciBusIDs[id]
+	d.deviceLock.RUnlock()
+	if !ok {
+		return 0, errors.New("unknown PCI bus ID")
+	}
+
+	address, err := sysfsPCIAddress(busID)
+	if err != nil {
+		return 0, err
+	}
+	return deviceMinor(address)
+}
+
+// deviceMinor reads the minor number of the device node of the GPU with the
+// given PCI address from /proc/driver/nvidia/gpus/<address>/information
+func deviceMinor(address string) (int, error) {
+	path := filepath.Join(procRoot, "driver", "nvidia", "gpus", address, "information")
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(name) != "Device Minor" {
+			continue
+		}
+		minor, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return 0, fmt.Errorf("invalid device minor in %q: %v", path, err)
+		}
+		return minor, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no device minor in %q", path)
+}
+
+// allowedGPUMinors returns a function reporting whether the devices cgroup
+// of the process with the given pid allows access to the GPU device node
+// with a given minor number
+func allowedGPUMinors(pid int) (func(int) bool, error) {
+	content, err := os.ReadFile(filepath.Join(procRoot, fmt.Sprint(pid), "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+
+	var cgroup string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		// lines are formatted as hierarchy-ID:controllers:path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) == 3 && slices.Contains(strings.Split(parts[1], ","), "devices") {
+			cgroup = parts[2]
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if cgroup == "" {
+		return nil, errNoDevicesCgroup
+	}
+
+	rules, err := os.ReadFile(filepath.Join(sysfsRoot, "fs", "cgroup", "devices", cgroup, "devices.list"))
+	if err != nil {
+		return nil, err
+	}
+	return parseDeviceRules(rules), nil
+}
+
+// parseDeviceRules parses the devices.list file of a cgroup v1 devices
+// hierarchy, made of "<type> <major>:<minor> <access>" rules, and returns a
+// function reporting whether a GPU device node is allowed
+func parseDeviceRules(rules []byte) func(int) bool {
+	all := false
+	minors := make(map[int]struct{})
+
+	scanner := bufio.NewScanner(bytes.NewReader(rules))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "a" {
+			all = true
+			continue
+		}
+		major, minor, ok := strings.Cut(fields[1], ":")
+		if fields[0] != "c" || !ok || (major != "*" && major != nvidiaDeviceMajor) {
+			continue
+		}
+		if minor == "*" {
+			all = true
+			continue
+		}
+		if n, err := strconv.Atoi(minor); err == nil && n <= nvidiaMaxGPUMinor {
+			minors[n] = struct{}{}
+		}
+	}
+
+	return func(minor int) bool {
+		if all {
+			return true
+		}
+		_, ok := minors[minor]
+		return ok
+	}
+}
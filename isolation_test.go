@@ -0,0 +1,151 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/shoenig/test/must"
+)
+
+// setupIsolation points procRoot and sysfsRoot to temporary directories in
+// which the kernel driver lists the GPUs with the given minor numbers, keyed
+// by PCI address, and the process with pid 100 runs in a cgroup v1 devices
+// hierarchy with the given rules
+func setupIsolation(t *testing.T, minors map[string]int, rules string) {
+	setupProcRoot(t, map[int]string{
+		100: "12:devices:/nomad/alloc1\n0::/nomad/alloc1\n",
+	})
+	for address, minor := range minors {
+		dir := filepath.Join(procRoot, "driver", "nvidia", "gpus", address)
+		must.NoError(t, os.MkdirAll(dir, 0o755))
+		information := fmt.Sprintf("Model: \t\t Tesla T4\nDevice Minor: \t %d\nBus Location: \t %s\n", minor, address)
+		must.NoError(t, os.WriteFile(filepath.Join(dir, "information"), []byte(information), 0o644))
+	}
+
+	setupSysfsRoot(t, nil)
+	dir := filepath.Join(sysfsRoot, "fs", "cgroup", "devices", "nomad", "alloc1")
+	must.NoError(t, os.MkdirAll(dir, 0o755))
+	must.NoError(t, os.WriteFile(filepath.Join(dir, "devices.list"), []byte(rules), 0o644))
+}
+
+func TestParseDeviceRules(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Rules   string
+		Allowed []int
+		Denied  []int
+	}{
+		{
+			Name:    "reserved GPU only",
+			Rules:   "c 1:3 rwm\nc 195:255 rw\nc 195:1 rw\n",
+			Allowed: []int{1},
+			Denied:  []int{0, 2},
+		},
+		{
+			Name:    "all GPUs",
+			Rules:   "c 195:* rwm\n",
+			Allowed: []int{0, 1, 2},
+		},
+		{
+			Name:    "all devices",
+			Rules:   "a *:* rwm\n",
+			Allowed: []int{0, 1},
+		},
+		{
+			Name:    "all character devices",
+			Rules:   "c *:* m\nb *:* m\n",
+			Allowed: []int{0, 1},
+		},
+		{
+			Name:   "control devices only",
+			Rules:  "c 195:254 rw\nc 195:255 rw\nb 195:0 rw\n",
+			Denied: []int{0, 1},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			allowed := parseDeviceRules([]byte(c.Rules))
+			for _, minor := range c.Allowed {
+				must.True(t, allowed(minor), must.Sprintf("minor %d", minor))
+			}
+			for _, minor := range c.Denied {
+				must.False(t, allowed(minor), must.Sprintf("minor %d", minor))
+			}
+		})
+	}
+}
+
+func TestAllowedGPUMinorsCgroupV2(t *testing.T) {
+	setupProcRoot(t, map[int]string{100: "0::/nomad.slice/share.slice/4d2c1e0a.train.scope\n"})
+	_, err := allowedGPUMinors(100)
+	must.ErrorIs(t, err, errNoDevicesCgroup)
+}
+
+func TestHasDevicesCgroup(t *testing.T) {
+	setupSysfsRoot(t, nil)
+	must.False(t, hasDevicesCgroup())
+
+	must.NoError(t, os.MkdirAll(filepath.Join(sysfsRoot, "fs", "cgroup", "devices"), 0o755))
+	must.True(t, hasDevicesCgroup())
+}
+
+func TestCheckIsolation(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Rules    string
+		Expected string
+		Leaked   []string
+	}{
+		{
+			Name:     "isolated",
+			Rules:    "c 195:255 rw\nc 195:0 rw\n",
+			Expected: "verified GPU isolation of task",
+		},
+		{
+			Name:     "leaking",
+			Rules:    "c 195:* rw\n",
+			Expected: "task can access GPUs it did not reserve",
+			Leaked:   []string{"/dev/nvidia1"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			setupIsolation(t, map[string]int{"0000:3b:00.0": 0, "0000:af:00.0": 1}, c.Rules)
+
+			var out bytes.Buffer
+			collector := &MockNvmlClient{}
+			d := &NvidiaDevice{
+				logger:         hclog.New(&hclog.LoggerOptions{Output: &out, Level: hclog.Debug}),
+				errorLog:       newErrorLogLimiter(0, nil),
+				collector:      collector,
+				isolationCheck: true,
+				pciBusIDs: map[string]string{
+					"UUID1": "00000000:3B:00.0",
+					"UUID2": "00000000:AF:00.0",
+				},
+			}
+			d.scheduleIsolationCheck([]string{"UUID1"})
+
+			// the check is pending until the task runs on its GPU
+			d.checkIsolation()
+			must.MapLen(t, 1, d.isolationChecks)
+			must.Eq(t, "", out.String())
+
+			collector.ProcessesReturned = map[string][]int{"UUID1": {100}}
+			d.checkIsolation()
+			must.MapEmpty(t, d.isolationChecks)
+			must.StrContains(t, out.String(), c.Expected)
+			for _, leaked := range c.Leaked {
+				must.StrContains(t, out.String(), leaked)
+			}
+			must.StrNotContains(t, out.String(), "/dev/nvidia0")
+		})
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+)
+
+const (
+	// VGPULicensedAttr is whether the vGPU software is licensed on the
+	// device, and VGPULicenseExpiryAttr when its license expires in RFC 3339
+	// format. They are only reported by vGPUs supporting licensing.
+	VGPULicensedAttr      = "vgpu_licensed"
+	VGPULicenseExpiryAttr = "vgpu_license_expiry"
+
+	// vgpuUnlicensedDesc is the health description of healthy vGPUs whose
+	// software is not licensed
+	vgpuUnlicensedDesc = "vGPU software is not licensed, performance is throttled"
+)
+
+// vgpuLicenseWarning returns the health description warning about the vGPU
+// license of dev at now, and false when its license needs no warning. The
+// license expiring within window is warned about, a zero window disables
+// expiry warnings.
+func vgpuLicenseWarning(dev *nvml.FingerprintDeviceData, window time.Duration, now time.Time) (string, bool) {
+	if dev.VGPULicensed == nil {
+		return "", false
+	}
+	if !*dev.VGPULicensed {
+		return vgpuUnlicensedDesc, true
+	}
+	if window <= 0 || dev.VGPULicenseExpiry == nil {
+		return "", false
+	}
+
+	expiry := dev.VGPULicenseExpiry.UTC().Format(time.RFC3339)
+	switch left := dev.VGPULicenseExpiry.Sub(now); {
+	case left <= 0:
+		return fmt.Sprintf("vGPU software license expired at %s", expiry), true
+	case left <= window:
+		return fmt.Sprintf("vGPU software license expires at %s", expiry), true
+	}
+	return "", false
+}
+
+// checkVGPULicense records the vGPUs whose software is not licensed or whose
+// license expires within vgpuLicenseWarning, which are reported healthy with
+// a warning as their health description, and logs a warning whenever the
+// warning of a device changes
+func (d *NvidiaDevice) checkVGPULicense(devices []*nvml.FingerprintDeviceData) {
+	d.deviceLock.RLock()
+	previous := d.licenseWarnings
+	d.deviceLock.RUnlock()
+
+	now := d.now()
+	warnings := make(map[string]string)
+	for _, dev := range devices {
+		warning, ok := vgpuLicenseWarning(dev, d.vgpuLicenseWarning, now)
+		if !ok {
+			continue
+		}
+		warnings[dev.UUID] = warning
+		if previous[dev.UUID] != warning {
+			d.logger.Warn("vGPU license needs attention", "uuid", dev.UUID, "warning", warning)
+		}
+	}
+
+	d.deviceLock.Lock()
+	d.licenseWarnings = warnings
+	d.deviceLock.Unlock()
+}
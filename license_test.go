@@ -0,0 +1,112 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/shoenig/test/must"
+)
+
+func TestVGPULicenseWarning(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		Name     string
+		Licensed *bool
+		Expiry   *time.Time
+		Window   time.Duration
+		Expected string
+	}{
+		{
+			Name:   "not a vGPU",
+			Window: 7 * 24 * time.Hour,
+		},
+		{
+			Name:     "unlicensed",
+			Licensed: pointer.Of(false),
+			Window:   7 * 24 * time.Hour,
+			Expected: vgpuUnlicensedDesc,
+		},
+		{
+			Name:     "permanent license",
+			Licensed: pointer.Of(true),
+			Window:   7 * 24 * time.Hour,
+		},
+		{
+			Name:     "license far from expiry",
+			Licensed: pointer.Of(true),
+			Expiry:   pointer.Of(now.Add(30 * 24 * time.Hour)),
+			Window:   7 * 24 * time.Hour,
+		},
+		{
+			Name:     "license near expiry",
+			Licensed: pointer.Of(true),
+			Expiry:   pointer.Of(now.Add(48 * time.Hour)),
+			Window:   7 * 24 * time.Hour,
+			Expected: "vGPU software license expires at 2026-03-03T12:00:00Z",
+		},
+		{
+			Name:     "license expired",
+			Licensed: pointer.Of(true),
+			Expiry:   pointer.Of(now.Add(-time.Hour)),
+			Window:   7 * 24 * time.Hour,
+			Expected: "vGPU software license expired at 2026-03-01T11:00:00Z",
+		},
+		{
+			Name:     "expiry warnings disabled",
+			Licensed: pointer.Of(true),
+			Expiry:   pointer.Of(now.Add(time.Hour)),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			dev := &nvml.FingerprintDeviceData{
+				DeviceData:        &nvml.DeviceData{UUID: "UUID1"},
+				VGPULicensed:      c.Licensed,
+				VGPULicenseExpiry: c.Expiry,
+			}
+			warning, ok := vgpuLicenseWarning(dev, c.Window, now)
+			must.Eq(t, c.Expected != "", ok)
+			must.Eq(t, c.Expected, warning)
+		})
+	}
+}
+
+func TestCheckVGPULicense(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	d := &NvidiaDevice{
+		logger:             hclog.NewNullLogger(),
+		clock:              ClockFunc(func() time.Time { return now }),
+		vgpuLicenseWarning: 7 * 24 * time.Hour,
+	}
+	devices := []*nvml.FingerprintDeviceData{
+		{DeviceData: &nvml.DeviceData{UUID: "UUID1"}, VGPULicensed: pointer.Of(true)},
+		{DeviceData: &nvml.DeviceData{UUID: "UUID2"}, VGPULicensed: pointer.Of(false)},
+	}
+	d.checkVGPULicense(devices)
+
+	groups := []*device.DeviceGroup{{
+		Devices: []*device.Device{
+			{ID: "UUID1", Healthy: true},
+			{ID: "UUID2", Healthy: true},
+		},
+	}}
+	d.applyDeviceHealth(groups)
+	must.Eq(t, "", groups[0].Devices[0].HealthDesc)
+	// unlicensed vGPUs remain schedulable with a warning
+	must.True(t, groups[0].Devices[1].Healthy)
+	must.Eq(t, vgpuUnlicensedDesc, groups[0].Devices[1].HealthDesc)
+
+	// the warning is cleared once the vGPU is licensed
+	devices[1].VGPULicensed = pointer.Of(true)
+	d.checkVGPULicense(devices)
+	groups[0].Devices[1].HealthDesc = ""
+	d.applyDeviceHealth(groups)
+	must.Eq(t, "", groups[0].Devices[1].HealthDesc)
+}
@@ -0,0 +1,52 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+)
+
+// errorLogLimiter coalesces repeated identical errors in logs. The first
+// occurrence of an error is logged right away, further occurrences within
+// interval are only counted and their number is reported with the next log
+// line for that error once the interval has elapsed, or by flush once the
+// error stopped. Errors are identical when their message, error and
+// arguments, such as the UUID of the device, are.
+type errorLogLimiter struct {
+	limiter *nvml.LogLimiter
+}
+
+// newErrorLogLimiter returns an errorLogLimiter coalescing errors logged
+// within the given interval, a zero interval disables coalescing
+func newErrorLogLimiter(interval time.Duration, clock Clock) *errorLogLimiter {
+	var now func() time.Time
+	if clock != nil {
+		now = clock.Now
+	}
+	return &errorLogLimiter{limiter: nvml.NewLogLimiter(interval, now)}
+}
+
+// Error logs msg and err at error level unless the same error was already
+// logged within the limiter interval. A nil limiter logs every error.
+func (l *errorLogLimiter) Error(logger hclog.Logger, msg string, err error, args ...interface{}) {
+	key := fmt.Sprint(append([]interface{}{msg, err}, args...)...)
+	args = append([]interface{}{"error", err}, args...)
+	if l == nil {
+		logger.Error(msg, args...)
+		return
+	}
+	l.limiter.Log(logger, hclog.Error, key, msg, args...)
+}
+
+// flush logs the number of suppressed occurrences of the errors that stopped
+func (l *errorLogLimiter) flush() {
+	if l == nil {
+		return
+	}
+	l.limiter.Flush()
+}
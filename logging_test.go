@@ -0,0 +1,95 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/shoenig/test/must"
+)
+
+func TestErrorLogLimiter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{Output: &buf})
+
+	now := time.Unix(0, 0)
+	limiter := newErrorLogLimiter(time.Minute, ClockFunc(func() time.Time { return now }))
+
+	errUtilization := errors.New("failed to get device utilization: Unknown Error")
+	errTemperature := errors.New("failed to get device temperature: Unknown Error")
+
+	// the first occurrence of an error is logged
+	limiter.Error(logger, "failed to get nvidia stats", errUtilization)
+	must.Eq(t, 1, strings.Count(buf.String(), "\n"))
+
+	// repeated occurrences within the interval are suppressed
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Second)
+		limiter.Error(logger, "failed to get nvidia stats", errUtilization)
+	}
+	must.Eq(t, 1, strings.Count(buf.String(), "\n"))
+
+	// a different error is logged right away
+	limiter.Error(logger, "failed to get nvidia stats", errTemperature)
+	must.Eq(t, 2, strings.Count(buf.String(), "\n"))
+
+	// once the interval elapsed the error is logged with the suppressed count
+	now = now.Add(time.Minute)
+	buf.Reset()
+	limiter.Error(logger, "failed to get nvidia stats", errUtilization)
+	must.StrContains(t, buf.String(), "suppressed=10")
+}
+
+func TestErrorLogLimiterDevices(t *testing.T) {
+	var buf bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{Output: &buf})
+
+	now := time.Unix(0, 0)
+	limiter := newErrorLogLimiter(time.Minute, ClockFunc(func() time.Time { return now }))
+	errUtilization := errors.New("failed to get device utilization: Unknown Error")
+
+	// the same error of another device is logged right away
+	limiter.Error(logger, "failed to get nvidia device stats", errUtilization, "uuid", "UUID1")
+	limiter.Error(logger, "failed to get nvidia device stats", errUtilization, "uuid", "UUID2")
+	must.Eq(t, 2, strings.Count(buf.String(), "\n"))
+
+	// suppressed errors are reported once the error stopped
+	now = now.Add(time.Second)
+	limiter.Error(logger, "failed to get nvidia device stats", errUtilization, "uuid", "UUID1")
+	limiter.flush()
+	must.Eq(t, 2, strings.Count(buf.String(), "\n"))
+
+	now = now.Add(time.Minute)
+	buf.Reset()
+	limiter.flush()
+	must.Eq(t, 1, strings.Count(buf.String(), "\n"))
+	must.StrContains(t, buf.String(), "uuid=UUID1")
+	must.StrContains(t, buf.String(), "suppressed=1")
+
+	// errors occurring again after they stopped are logged right away
+	buf.Reset()
+	limiter.Error(logger, "failed to get nvidia device stats", errUtilization, "uuid", "UUID1")
+	must.Eq(t, 1, strings.Count(buf.String(), "\n"))
+	must.StrNotContains(t, buf.String(), "suppressed")
+}
+
+func TestErrorLogLimiterDisabled(t *testing.T) {
+	var buf bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{Output: &buf})
+
+	// a nil limiter or a zero interval logs every error
+	var nilLimiter *errorLogLimiter
+	for _, limiter := range []*errorLogLimiter{nilLimiter, newErrorLogLimiter(0, nil)} {
+		buf.Reset()
+		for i := 0; i < 3; i++ {
+			limiter.Error(logger, "failed to get nvidia stats", errors.New("failure"))
+		}
+		must.Eq(t, 3, strings.Count(buf.String(), "\n"))
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"sort"
+	"strings"
+)
+
+// maintenanceReason is the health description of devices in maintenance
+const maintenanceReason = "maintenance"
+
+// maintenanceDevices returns the UUIDs of the devices in maintenance, listed
+// in the config and in the maintenance file
+func (d *NvidiaDevice) maintenanceDevices() (map[string]struct{}, error) {
+	uuids := make(map[string]struct{}, len(d.maintenanceGPUIDs))
+	for uuid := range d.maintenanceGPUIDs {
+		uuids[uuid] = struct{}{}
+	}
+	if d.maintenanceFile == "" {
+		return uuids, nil
+	}
+
+	fileUUIDs, err := readMaintenanceFile(d.maintenanceFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, uuid := range fileUUIDs {
+		uuids[uuid] = struct{}{}
+	}
+	return uuids, nil
+}
+
+// readMaintenanceFile returns the device UUIDs listed in the maintenance
+// file, one per line. Blank lines and lines starting with # are skipped, and
+// a missing file lists no devices.
+func readMaintenanceFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var uuids []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		uuids = append(uuids, line)
+	}
+	return uuids, scanner.Err()
+}
+
+// checkMaintenance marks the devices in maintenance unhealthy, so that they
+// are not scheduled, and clears the maintenance cause of the devices taken
+// out of maintenance, which stay unhealthy if marked so for another cause.
+func (d *NvidiaDevice) checkMaintenance() {
+	uuids, err := d.maintenanceDevices()
+	if err != nil {
+		d.errorLog.Error(d.logger, "failed to read maintenance file", err, "path", d.maintenanceFile)
+		return
+	}
+
+	for _, uuid := range d.unhealthyDevices(healthCauseMaintenance) {
+		if _, ok := uuids[uuid]; !ok {
+			d.setDeviceHealthy(uuid, healthCauseMaintenance)
+		}
+	}
+
+	sorted := make([]string, 0, len(uuids))
+	for uuid := range uuids {
+		sorted = append(sorted, uuid)
+	}
+	sort.Strings(sorted)
+	for _, uuid := range sorted {
+		d.setDeviceUnhealthy(uuid, healthCauseMaintenance, maintenanceReason)
+	}
+}
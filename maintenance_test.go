@@ -0,0 +1,61 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/shoenig/test/must"
+)
+
+func TestReadMaintenanceFile(t *testing.T) {
+	dir := t.TempDir()
+
+	uuids, err := readMaintenanceFile(filepath.Join(dir, "missing"))
+	must.NoError(t, err)
+	must.SliceEmpty(t, uuids)
+
+	path := filepath.Join(dir, "maintenance")
+	must.NoError(t, os.WriteFile(path, []byte("# firmware update\nGPU-1\n\n  GPU-2  \n"), 0o644))
+	uuids, err = readMaintenanceFile(path)
+	must.NoError(t, err)
+	must.Eq(t, []string{"GPU-1", "GPU-2"}, uuids)
+}
+
+func TestCheckMaintenance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maintenance")
+	d := &NvidiaDevice{
+		maintenanceGPUIDs: map[string]struct{}{"GPU-1": {}},
+		maintenanceFile:   path,
+		logger:            hclog.NewNullLogger(),
+	}
+
+	d.checkMaintenance()
+	must.Eq(t, []string{"GPU-1"}, d.unhealthyDevices(healthCauseMaintenance))
+	must.Eq(t, maintenanceReason, d.unhealthy["GPU-1"].reason())
+
+	// devices are put in and taken out of maintenance through the file
+	must.NoError(t, os.WriteFile(path, []byte("GPU-3\n"), 0o644))
+	d.checkMaintenance()
+	must.Eq(t, []string{"GPU-1", "GPU-3"}, d.unhealthyDevices(healthCauseMaintenance))
+
+	must.NoError(t, os.WriteFile(path, nil, 0o644))
+	d.checkMaintenance()
+	must.Eq(t, []string{"GPU-1"}, d.unhealthyDevices(healthCauseMaintenance))
+
+	// devices unhealthy for another cause stay so after their maintenance
+	d.setDeviceUnhealthy("GPU-2", healthCauseLeftoverProcesses, "leftover processes: 1234")
+	must.NoError(t, os.WriteFile(path, []byte("GPU-2\n"), 0o644))
+	d.checkMaintenance()
+	must.Eq(t, []string{"GPU-1", "GPU-2"}, d.unhealthyDevices(healthCauseMaintenance))
+
+	must.NoError(t, os.WriteFile(path, nil, 0o644))
+	d.checkMaintenance()
+	must.Eq(t, []string{"GPU-1"}, d.unhealthyDevices(healthCauseMaintenance))
+	must.Eq(t, []string{"GPU-2"}, d.unhealthyDevices(healthCauseLeftoverProcesses))
+	must.Eq(t, "leftover processes: 1234", d.unhealthy["GPU-2"].reason())
+}
@@ -0,0 +1,153 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+)
+
+const (
+	// migPrefix prefixes the UUID of every MIG instance
+	migPrefix = "MIG-"
+
+	// legacyMIGPrefix prefixes MIG instance UUIDs reported by drivers older
+	// than R470, which have the form MIG-GPU-<GPU UUID>/<GI>/<CI>
+	legacyMIGPrefix = "MIG-GPU-"
+
+	// migParentGroupSuffix is appended to the name of the groups of MIG
+	// enabled physical GPUs, so that they are not mixed with the groups of
+	// allocatable GPUs of the same model
+	migParentGroupSuffix = " MIG parent"
+
+	// migParentHealthDesc is the health description of MIG enabled physical
+	// GPUs, which are reported unhealthy so that Nomad never allocates them
+	migParentHealthDesc = "MIG enabled physical GPU, only its MIG instances are allocatable"
+)
+
+// visibleDeviceID returns the identifier of the device with the given ID in
+// the format expected by NVIDIA_VISIBLE_DEVICES. Full GPUs and MIG instances
+// of current drivers are identified by their UUID, MIG instances of legacy
+// drivers by their parent GPU UUID and GPU/compute instance IDs.
+func visibleDeviceID(id string) (string, error) {
+	if !strings.HasPrefix(id, migPrefix) {
+		return id, nil
+	}
+
+	if !strings.HasPrefix(id, legacyMIGPrefix) {
+		if len(id) == len(migPrefix) {
+			return "", fmt.Errorf("invalid MIG device ID %q: missing UUID", id)
+		}
+		return id, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(id, legacyMIGPrefix), "/")
+	if len(parts) != 3 || parts[0] == "" {
+		return "", fmt.Errorf("invalid MIG device ID %q: expected MIG-GPU-<UUID>/<GI>/<CI>", id)
+	}
+	gi, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid MIG device ID %q: bad GPU instance ID: %v", id, err)
+	}
+	ci, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid MIG device ID %q: bad compute instance ID: %v", id, err)
+	}
+
+	return fmt.Sprintf("%sGPU-%s/%d/%d", migPrefix, parts[0], gi, ci), nil
+}
+
+// visibleDevices returns the NVIDIA_VISIBLE_DEVICES value for deviceIDs
+func visibleDevices(deviceIDs []string) (string, error) {
+	ids := make([]string, len(deviceIDs))
+	for i, id := range deviceIDs {
+		visibleID, err := visibleDeviceID(id)
+		if err != nil {
+			return "", err
+		}
+		ids[i] = visibleID
+	}
+	return strings.Join(ids, ","), nil
+}
+
+// checkMIGSpread logs a warning when several of the reserved MIG devices
+// belong to the same physical GPU. Nomad picks the reserved instances of a
+// group, so the plugin can only report reservations with poor fault
+// isolation; jobs can constrain on the mig_parent_gpus attribute to require
+// groups that span several physical GPUs.
+func (d *NvidiaDevice) checkMIGSpread(deviceIDs []string) {
+	d.deviceLock.RLock()
+	byParent := make(map[string][]string)
+	for _, id := range deviceIDs {
+		if parent, ok := d.migParents[id]; ok {
+			byParent[parent] = append(byParent[parent], id)
+		}
+	}
+	d.deviceLock.RUnlock()
+
+	for parent, ids := range byParent {
+		if len(ids) > 1 {
+			d.logger.Warn("reserved MIG devices share a physical GPU", "parent_uuid", parent, "uuids", ids)
+		}
+	}
+}
+
+// setMIGParentGPUs records the MIG enabled physical GPUs of the node, so that
+// they are tracked even though only their MIG devices are allocatable
+func (d *NvidiaDevice) setMIGParentGPUs(parents []*nvml.FingerprintDeviceData) {
+	migParentGPUs := make(map[string]struct{}, len(parents))
+	for _, parent := range parents {
+		migParentGPUs[parent.UUID] = struct{}{}
+	}
+
+	d.deviceLock.Lock()
+	defer d.deviceLock.Unlock()
+	d.migParentGPUs = migParentGPUs
+}
+
+// noStatsByDesign reports whether the device with the given UUID has no stats
+// by design: NVML reports no stats for MIG devices and their physical parents.
+// It must be called with deviceLock held.
+func (d *NvidiaDevice) noStatsByDesign(uuid string) bool {
+	if _, ok := d.migParents[uuid]; ok {
+		return true
+	}
+	_, ok := d.migParentGPUs[uuid]
+	return ok
+}
+
+// migParentGroups returns the device groups advertising the MIG enabled
+// physical GPUs as informational devices, so that the inventory of physical
+// GPUs is visible while only MIG instances are allocatable. Their devices are
+// unhealthy, which keeps Nomad from placing allocations on them.
+func (d *NvidiaDevice) migParentGroups(parents []*nvml.FingerprintDeviceData, commonAttributes map[string]*structs.Attribute) []*device.DeviceGroup {
+	byName := make(map[string][]*nvml.FingerprintDeviceData)
+	for _, parent := range ignoreFingerprintedDevices(parents, d.ignoredGPUIDs) {
+		groupName := d.groupName(parent.DeviceName) + migParentGroupSuffix
+		byName[groupName] = append(byName[groupName], parent)
+	}
+
+	groups := make([]*device.DeviceGroup, 0, len(byName))
+	for groupName, devices := range byName {
+		sort.Slice(devices, func(i, j int) bool {
+			return devices[i].UUID < devices[j].UUID
+		})
+		group := d.deviceGroupFromFingerprintData(groupName, devices, commonAttributes)
+		for _, dev := range group.Devices {
+			dev.Healthy = false
+			dev.HealthDesc = migParentHealthDesc
+		}
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
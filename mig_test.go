@@ -0,0 +1,85 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/shoenig/test/must"
+)
+
+func TestVisibleDeviceID(t *testing.T) {
+	for _, testCase := range []struct {
+		Name           string
+		ID             string
+		ExpectedResult string
+		ExpectedError  bool
+	}{
+		{
+			Name:           "full GPU",
+			ID:             "GPU-5c89852c-d268-c3f3-1b07-005d5ae1dc3f",
+			ExpectedResult: "GPU-5c89852c-d268-c3f3-1b07-005d5ae1dc3f",
+		},
+		{
+			Name:           "MIG instance",
+			ID:             "MIG-1a2b3c4d-5e6f-7a8b-9c0d-1e2f3a4b5c6d",
+			ExpectedResult: "MIG-1a2b3c4d-5e6f-7a8b-9c0d-1e2f3a4b5c6d",
+		},
+		{
+			Name:           "legacy MIG instance",
+			ID:             "MIG-GPU-5c89852c-d268-c3f3-1b07-005d5ae1dc3f/7/0",
+			ExpectedResult: "MIG-GPU-5c89852c-d268-c3f3-1b07-005d5ae1dc3f/7/0",
+		},
+		{
+			Name:           "legacy MIG instance with padded IDs",
+			ID:             "MIG-GPU-5c89852c-d268-c3f3-1b07-005d5ae1dc3f/07/00",
+			ExpectedResult: "MIG-GPU-5c89852c-d268-c3f3-1b07-005d5ae1dc3f/7/0",
+		},
+		{
+			Name:          "MIG instance without UUID",
+			ID:            "MIG-",
+			ExpectedError: true,
+		},
+		{
+			Name:          "legacy MIG instance without compute instance",
+			ID:            "MIG-GPU-5c89852c-d268-c3f3-1b07-005d5ae1dc3f/7",
+			ExpectedError: true,
+		},
+		{
+			Name:          "legacy MIG instance with bad GPU instance",
+			ID:            "MIG-GPU-5c89852c-d268-c3f3-1b07-005d5ae1dc3f/x/0",
+			ExpectedError: true,
+		},
+	} {
+		t.Run(testCase.Name, func(t *testing.T) {
+			actualResult, err := visibleDeviceID(testCase.ID)
+			if testCase.ExpectedError {
+				must.Error(t, err)
+				return
+			}
+			must.NoError(t, err)
+			must.Eq(t, testCase.ExpectedResult, actualResult)
+		})
+	}
+}
+
+func TestCheckMIGSpread(t *testing.T) {
+	var out bytes.Buffer
+	d := &NvidiaDevice{
+		migParents: map[string]string{
+			"MIG-1": "GPU-1",
+			"MIG-2": "GPU-1",
+			"MIG-3": "GPU-2",
+		},
+		logger: hclog.New(&hclog.LoggerOptions{Output: &out}),
+	}
+
+	d.checkMIGSpread([]string{"MIG-1", "MIG-3"})
+	must.StrNotContains(t, out.String(), "share a physical GPU")
+
+	d.checkMIGSpread([]string{"MIG-1", "MIG-2", "MIG-3"})
+	must.StrContains(t, out.String(), "reserved MIG devices share a physical GPU: parent_uuid=GPU-1")
+}
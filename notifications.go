@@ -0,0 +1,137 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	// Health states reported in health transition events
+	healthStateHealthy   = "healthy"
+	healthStateUnhealthy = "unhealthy"
+
+	// notificationQueueSize bounds the number of health transition events
+	// waiting to be sent, further events are dropped
+	notificationQueueSize = 64
+)
+
+// NotificationsConfig configures where health transition events are sent
+type NotificationsConfig struct {
+	// WebhookURL receives a POST request for every health transition
+	WebhookURL string `codec:"webhook_url"`
+
+	// Timeout bounds each webhook request
+	Timeout string `codec:"timeout"`
+}
+
+// healthEvent describes a device health transition
+type healthEvent struct {
+	UUID      string    `json:"uuid"`
+	OldHealth string    `json:"old_health"`
+	NewHealth string    `json:"new_health"`
+	Reason    string    `json:"reason,omitempty"`
+	Hostname  string    `json:"hostname"`
+	Time      time.Time `json:"time"`
+}
+
+// healthNotifier sends health transition events to a webhook. Events are
+// queued and sent in order by a single goroutine, so that callers holding
+// locks are never blocked by slow webhooks.
+type healthNotifier struct {
+	webhookURL string
+	timeout    time.Duration
+	hostname   string
+	httpClient *http.Client
+	events     chan *healthEvent
+	now        func() time.Time
+	logger     hclog.Logger
+}
+
+// newHealthNotifier validates config and returns a notifier, or nil when no
+// webhook is configured. Events are timestamped with now. The notifier must be
+// started with run.
+func newHealthNotifier(config NotificationsConfig, now func() time.Time, logger hclog.Logger) (*healthNotifier, error) {
+	if config.WebhookURL == "" {
+		return nil, nil
+	}
+	if _, err := url.ParseRequestURI(config.WebhookURL); err != nil {
+		return nil, fmt.Errorf("invalid notifications webhook url %q: %v", config.WebhookURL, err)
+	}
+
+	n := &healthNotifier{
+		webhookURL: config.WebhookURL,
+		httpClient: &http.Client{},
+		events:     make(chan *healthEvent, notificationQueueSize),
+		now:        now,
+		logger:     logger.Named("notifications"),
+	}
+	if config.Timeout != "" {
+		timeout, err := time.ParseDuration(config.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse notifications timeout %q: %v", config.Timeout, err)
+		}
+		n.timeout = timeout
+	}
+	n.hostname, _ = os.Hostname()
+	return n, nil
+}
+
+// notify queues an event for the health transition of the device with the
+// given UUID. It never blocks, events are dropped when the queue is full.
+func (n *healthNotifier) notify(uuid, oldHealth, newHealth, reason string) {
+	if n == nil {
+		return
+	}
+
+	event := &healthEvent{
+		UUID:      uuid,
+		OldHealth: oldHealth,
+		NewHealth: newHealth,
+		Reason:    reason,
+		Hostname:  n.hostname,
+		Time:      n.now(),
+	}
+	select {
+	case n.events <- event:
+	default:
+		n.logger.Warn("notification queue is full, dropping health event", "uuid", uuid, "new_health", newHealth)
+	}
+}
+
+// run sends queued events until ctx is done
+func (n *healthNotifier) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-n.events:
+			if err := n.send(ctx, event); err != nil {
+				n.logger.Warn("failed to send health event", "uuid", event.UUID, "error", err)
+			}
+		}
+	}
+}
+
+// send posts a single event to the webhook
+func (n *healthNotifier) send(ctx context.Context, event *healthEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if n.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.timeout)
+		defer cancel()
+	}
+	return postJSON(ctx, n.httpClient, n.webhookURL, body)
+}
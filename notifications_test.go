@@ -0,0 +1,77 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/shoenig/test/must"
+)
+
+func TestNewHealthNotifier(t *testing.T) {
+	notifier, err := newHealthNotifier(NotificationsConfig{}, time.Now, hclog.NewNullLogger())
+	must.NoError(t, err)
+	must.Nil(t, notifier)
+
+	_, err = newHealthNotifier(NotificationsConfig{WebhookURL: "not a url"}, time.Now, hclog.NewNullLogger())
+	must.ErrorContains(t, err, `invalid notifications webhook url "not a url"`)
+
+	_, err = newHealthNotifier(NotificationsConfig{WebhookURL: "http://localhost", Timeout: "soon"}, time.Now, hclog.NewNullLogger())
+	must.EqError(t, err, `failed to parse notifications timeout "soon": time: invalid duration "soon"`)
+}
+
+func TestHealthNotifications(t *testing.T) {
+	events := make(chan *healthEvent, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event healthEvent
+		must.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events <- &event
+	}))
+	defer server.Close()
+
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	notifier, err := newHealthNotifier(NotificationsConfig{WebhookURL: server.URL},
+		func() time.Time { return now }, hclog.NewNullLogger())
+	must.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go notifier.run(ctx)
+
+	d := &NvidiaDevice{
+		logger:   hclog.NewNullLogger(),
+		notifier: notifier,
+	}
+	d.setDeviceUnhealthy("UUID1", "test", "fallen off the bus")
+	// reason updates are not transitions
+	d.setDeviceUnhealthy("UUID1", "test", "still off the bus")
+	d.setDeviceHealthy("UUID1", "test")
+
+	for _, expected := range []*healthEvent{
+		{UUID: "UUID1", OldHealth: healthStateHealthy, NewHealth: healthStateUnhealthy, Reason: "fallen off the bus"},
+		{UUID: "UUID1", OldHealth: healthStateUnhealthy, NewHealth: healthStateHealthy},
+	} {
+		select {
+		case event := <-events:
+			must.Eq(t, expected.UUID, event.UUID)
+			must.Eq(t, expected.OldHealth, event.OldHealth)
+			must.Eq(t, expected.NewHealth, event.NewHealth)
+			must.Eq(t, expected.Reason, event.Reason)
+			must.True(t, now.Equal(event.Time))
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for health event")
+		}
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected health event %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvml
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BreakerConfig configures the circuit breakers guarding the NVML queries of
+// each device
+type BreakerConfig struct {
+	// Threshold is the number of consecutive failed queries of a device after
+	// which it is no longer queried, zero disables the circuit breakers
+	Threshold int
+
+	// Cooldown is how long a device is not queried before trying again
+	Cooldown time.Duration
+
+	// QuarantineThreshold is the number of queries of a device failing
+	// because the device did not respond within QuarantineWindow after which
+	// the device is quarantined, zero disables the quarantine
+	QuarantineThreshold int
+	QuarantineWindow    time.Duration
+}
+
+// errBreakerOpen is returned instead of querying a device whose circuit
+// breaker is open
+var errBreakerOpen = errors.New("circuit breaker is open")
+
+type breakerState int
+
+const (
+	// breakerClosed lets all queries of the device through
+	breakerClosed breakerState = iota
+
+	// breakerOpen blocks all queries of the device until the cooldown ends
+	breakerOpen
+
+	// breakerHalfOpen lets a single trial query through, which closes the
+	// breaker on success and opens it again on failure
+	breakerHalfOpen
+)
+
+// circuitBreaker tracks the failed queries of a single device
+type circuitBreaker struct {
+	state    breakerState
+	failures int
+	openedAt time.Time
+	lastErr  error
+}
+
+// circuitBreakers holds the circuit breakers of all devices. A nil
+// circuitBreakers lets all queries through.
+type circuitBreakers struct {
+	config BreakerConfig
+
+	// now returns the current time, it is replaced in tests
+	now func() time.Time
+
+	lock     sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func newCircuitBreakers(config BreakerConfig) *circuitBreakers {
+	return &circuitBreakers{
+		config:   config,
+		now:      time.Now,
+		breakers: make(map[string]*circuitBreaker),
+	}
+}
+
+func (b *circuitBreakers) enabled() bool {
+	return b != nil && b.config.Threshold > 0
+}
+
+func (b *circuitBreakers) breaker(uuid string) *circuitBreaker {
+	breaker, ok := b.breakers[uuid]
+	if !ok {
+		breaker = &circuitBreaker{}
+		b.breakers[uuid] = breaker
+	}
+	return breaker
+}
+
+// allow reports whether the device with the given UUID may be queried. Once
+// the cooldown of an open breaker ended, a single trial query is allowed.
+func (b *circuitBreakers) allow(uuid string) bool {
+	if !b.enabled() {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	breaker := b.breaker(uuid)
+	switch breaker.state {
+	case breakerOpen:
+		if b.now().Sub(breaker.openedAt) < b.config.Cooldown {
+			return false
+		}
+		breaker.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// the trial query is in progress
+		return false
+	}
+	return true
+}
+
+// success records a successful query of the device, closing its breaker
+func (b *circuitBreakers) success(uuid string) {
+	if !b.enabled() {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.breakers, uuid)
+}
+
+// failure records a failed query of the device and reports whether its
+// breaker is open as a result
+func (b *circuitBreakers) failure(uuid string, err error) bool {
+	if !b.enabled() {
+		return false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	breaker := b.breaker(uuid)
+	breaker.failures++
+	breaker.lastErr = err
+	if breaker.state == breakerHalfOpen || breaker.failures >= b.config.Threshold {
+		breaker.state = breakerOpen
+		breaker.openedAt = b.now()
+		return true
+	}
+	return false
+}
+
+// reason describes why the device with the given UUID is not queried
+func (b *circuitBreakers) reason(uuid string) string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	breaker := b.breaker(uuid)
+	return fmt.Sprintf("%d consecutive NVML queries failed, retrying every %s: %v",
+		breaker.failures, b.config.Cooldown, breaker.lastErr)
+}
@@ -0,0 +1,120 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvml
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestCircuitBreakers(t *testing.T) {
+	errQuery := errors.New("query failed")
+
+	type step struct {
+		// advance moves the clock before the step
+		advance time.Duration
+		// fail is whether the query fails, if it is allowed
+		fail    bool
+		allowed bool
+		state   breakerState
+	}
+
+	cases := []struct {
+		Name  string
+		Steps []step
+	}{
+		{
+			Name: "opens after consecutive failures",
+			Steps: []step{
+				{fail: true, allowed: true, state: breakerClosed},
+				{fail: true, allowed: true, state: breakerClosed},
+				{fail: true, allowed: true, state: breakerOpen},
+				{allowed: false, state: breakerOpen},
+			},
+		},
+		{
+			Name: "success resets the failure count",
+			Steps: []step{
+				{fail: true, allowed: true, state: breakerClosed},
+				{fail: true, allowed: true, state: breakerClosed},
+				{allowed: true, state: breakerClosed},
+				{fail: true, allowed: true, state: breakerClosed},
+				{fail: true, allowed: true, state: breakerClosed},
+			},
+		},
+		{
+			Name: "closes after successful trial",
+			Steps: []step{
+				{fail: true, allowed: true},
+				{fail: true, allowed: true},
+				{fail: true, allowed: true, state: breakerOpen},
+				{advance: 30 * time.Second, allowed: false, state: breakerOpen},
+				{advance: 30 * time.Second, allowed: true, state: breakerClosed},
+				{allowed: true, state: breakerClosed},
+			},
+		},
+		{
+			Name: "reopens after failed trial",
+			Steps: []step{
+				{fail: true, allowed: true},
+				{fail: true, allowed: true},
+				{fail: true, allowed: true, state: breakerOpen},
+				{advance: time.Minute, fail: true, allowed: true, state: breakerOpen},
+				{advance: 59 * time.Second, allowed: false, state: breakerOpen},
+				{advance: time.Second, allowed: true, state: breakerClosed},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			now := time.Now()
+			breakers := newCircuitBreakers(BreakerConfig{Threshold: 3, Cooldown: time.Minute})
+			breakers.now = func() time.Time { return now }
+
+			for i, step := range c.Steps {
+				now = now.Add(step.advance)
+				allowed := breakers.allow("UUID1")
+				must.Eq(t, step.allowed, allowed, must.Sprintf("step %d", i))
+				if allowed {
+					if step.fail {
+						breakers.failure("UUID1", errQuery)
+					} else {
+						breakers.success("UUID1")
+					}
+				}
+				must.Eq(t, step.state, breakers.breaker("UUID1").state, must.Sprintf("step %d", i))
+			}
+		})
+	}
+}
+
+func TestCircuitBreakersHalfOpen(t *testing.T) {
+	now := time.Now()
+	breakers := newCircuitBreakers(BreakerConfig{Threshold: 1, Cooldown: time.Minute})
+	breakers.now = func() time.Time { return now }
+
+	must.True(t, breakers.failure("UUID1", errors.New("query failed")))
+	now = now.Add(time.Minute)
+
+	// only a single trial query is let through
+	must.True(t, breakers.allow("UUID1"))
+	must.False(t, breakers.allow("UUID1"))
+	must.True(t, breakers.allow("UUID2"))
+}
+
+func TestCircuitBreakersDisabled(t *testing.T) {
+	var nilBreakers *circuitBreakers
+	must.True(t, nilBreakers.allow("UUID1"))
+	must.False(t, nilBreakers.failure("UUID1", errors.New("query failed")))
+
+	breakers := newCircuitBreakers(BreakerConfig{})
+	for i := 0; i < 10; i++ {
+		must.False(t, breakers.failure("UUID1", errors.New("query failed")))
+	}
+	must.True(t, breakers.allow("UUID1"))
+}
@@ -0,0 +1,130 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvml
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// callTimeout bounds the time a driver call may take unless the client
+	// sets another timeout
+	callTimeout = 30 * time.Second
+
+	// gpuResetTimeout bounds the time a full GPU reset may take
+	gpuResetTimeout = time.Minute
+)
+
+// ErrCallTimeout is returned by driver calls that did not complete before
+// the deadline of their context
+var ErrCallTimeout = errors.New("NVML call did not complete in time")
+
+// ErrCallPending is returned by driver calls made while an earlier call to the
+// same device, or to the driver itself, was abandoned and is still running
+var ErrCallPending = errors.New("previous NVML call did not complete yet")
+
+// callWithContext runs call in its own goroutine and returns its results, or
+// an error once ctx is done. NVML calls can not be interrupted, a call hung in
+// the NVIDIA driver keeps its goroutine but no longer blocks its caller.
+func callWithContext[T any](ctx context.Context, call func() (T, error)) (T, error) {
+	return callPending(ctx, nil, "", call, nil)
+}
+
+// pendingCalls counts the calls abandoned while hung in the NVIDIA driver,
+// by the UUID of the device they query or by an empty UUID for calls to the
+// driver itself. No further call is made to a device or to the driver while
+// one of its abandoned calls is still running, so that hung calls do not pile
+// up. A nil pendingCalls does not track calls.
+type pendingCalls struct {
+	lock  sync.Mutex
+	uuids map[string]int
+}
+
+// pending reports whether an abandoned call of uuid is still running
+func (p *pendingCalls) pending(uuid string) bool {
+	if p == nil {
+		return false
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.uuids[uuid] != 0
+}
+
+// add records an abandoned call of uuid
+func (p *pendingCalls) add(uuid string) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.uuids == nil {
+		p.uuids = make(map[string]int)
+	}
+	p.uuids[uuid]++
+}
+
+// done records that an abandoned call of uuid completed
+func (p *pendingCalls) done(uuid string) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.uuids[uuid]--; p.uuids[uuid] <= 0 {
+		delete(p.uuids, uuid)
+	}
+}
+
+// callPending is callWithContext for a call of the device with the given
+// UUID, or of the driver when empty, which fails right away with
+// ErrCallPending while an abandoned call of that device is still running.
+// The results of the call are passed to abandoned, if not nil, when the call
+// completes after being abandoned.
+func callPending[T any](ctx context.Context, p *pendingCalls, uuid string, call func() (T, error), abandoned func(T, error)) (T, error) {
+	var zero T
+	if p.pending(uuid) {
+		return zero, ErrCallPending
+	}
+
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := call()
+		done <- result{value: value, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+	}
+
+	p.add(uuid)
+	go func() {
+		r := <-done
+		if abandoned != nil {
+			abandoned(r.value, r.err)
+		}
+		p.done(uuid)
+	}()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return zero, ErrCallTimeout
+	}
+	return zero, ctx.Err()
+}
+
+// callContext returns the context of a driver call, which is abandoned once
+// timeout elapsed, or callTimeout when timeout is zero
+func callContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = callTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
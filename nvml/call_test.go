@@ -0,0 +1,90 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvml
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestCallWithContext(t *testing.T) {
+	value, err := callWithContext(context.Background(), func() (string, error) {
+		return "550.54.15", nil
+	})
+	must.NoError(t, err)
+	must.Eq(t, "550.54.15", value)
+
+	errQuery := errors.New("query failed")
+	_, err = callWithContext(context.Background(), func() (string, error) { return "", errQuery })
+	must.ErrorIs(t, err, errQuery)
+
+	// a hung call is abandoned once the deadline passes
+	hung := make(chan struct{})
+	defer close(hung)
+	ctx, cancel := callContext(10 * time.Millisecond)
+	defer cancel()
+	value, err = callWithContext(ctx, func() (string, error) {
+		<-hung
+		return "550.54.15", nil
+	})
+	must.ErrorIs(t, err, ErrCallTimeout)
+	must.Eq(t, "", value)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = callWithContext(ctx, func() (string, error) {
+		<-hung
+		return "", nil
+	})
+	must.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCallPending(t *testing.T) {
+	var pending pendingCalls
+	hung := make(chan struct{})
+	abandoned := make(chan string, 1)
+
+	ctx, cancel := callContext(10 * time.Millisecond)
+	defer cancel()
+	_, err := callPending(ctx, &pending, "UUID1", func() (string, error) {
+		<-hung
+		return "550.54.15", nil
+	}, func(value string, _ error) {
+		abandoned <- value
+	})
+	must.ErrorIs(t, err, ErrCallTimeout)
+
+	// further calls of the device fail fast while the hung call runs, calls
+	// of other devices are made
+	called := false
+	_, err = callPending(context.Background(), &pending, "UUID1", func() (string, error) {
+		called = true
+		return "", nil
+	}, nil)
+	must.ErrorIs(t, err, ErrCallPending)
+	must.False(t, called)
+	must.True(t, unresponsive(err))
+
+	value, err := callPending(context.Background(), &pending, "UUID2", func() (string, error) {
+		return "550.54.15", nil
+	}, nil)
+	must.NoError(t, err)
+	must.Eq(t, "550.54.15", value)
+
+	// the results of the hung call are passed on once it completes
+	close(hung)
+	must.Eq(t, "550.54.15", <-abandoned)
+	for pending.pending("UUID1") {
+		time.Sleep(time.Millisecond)
+	}
+	value, err = callPending(context.Background(), &pending, "UUID1", func() (string, error) {
+		return "550.54.15", nil
+	}, nil)
+	must.NoError(t, err)
+	must.Eq(t, "550.54.15", value)
+}
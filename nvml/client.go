@@ -5,8 +5,15 @@ package nvml
 
 import (
 	"cmp"
+	"context"
+	"errors"
 	"fmt"
 	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
 )
 
 // DeviceData represents common fields for Nvidia device
@@ -23,25 +30,85 @@ type DeviceData struct {
 // nvml queries during fingerprinting call
 type FingerprintDeviceData struct {
 	*DeviceData
-	PCIBandwidthMBPerS *uint
-	CoresClockMHz      *uint
-	MemoryClockMHz     *uint
-	DisplayState       string
-	PersistenceMode    string
-	PCIBusID           string
+	Index                     *uint
+	ParentUUID                string
+	PCIBandwidthMBPerS        *uint
+	PCIDeviceID               *uint32
+	PCISubsystemID            *uint32
+	PCILinkGeneration         *uint
+	PCILinkWidth              *uint
+	CoresClockMHz             *uint
+	MemoryClockMHz            *uint
+	ApplicationCoresClockMHz  *uint
+	ApplicationMemoryClockMHz *uint
+	DisplayState              string
+	PersistenceMode           string
+	PCIBusID                  string
+	MIGProfiles               []*MIGProfile
+	EncoderCapacityH264       *uint // %
+	EncoderCapacityHEVC       *uint // %
+	EncoderCapacityAV1        *uint // %
+	GSPFirmwareMode           *string
+	GSPFirmwareVersion        *string
+	InfoROMVersionOEM         *string
+	InfoROMVersionECC         *string
+	InfoROMVersionPower       *string
+	InfoROMCorrupted          bool
+	OperationMode             *string
+	VirtualizationMode        *string
+	Brand                     *string
+	Architecture              *string
+	ComputeCapability         *string
+	FanCount                  *uint
+	FanSpeedMin               *uint // %
+	FanSpeedMax               *uint // %
+	FanControl                *string
+	CoherentMemory            *bool
+	NUMANode                  *uint
+	MemoryNUMANode            *uint
+	C2CLinks                  *uint
+	C2CBandwidthMBps          *uint
+	MaxOperatingTemperatureC  *uint
+	AcousticTemperatureC      *uint
+	VGPULicensed              *bool
+	VGPULicenseExpiry         *time.Time
+
+	// MIGParent is set for MIG enabled physical GPUs, which only their MIG
+	// devices can be allocated from
+	MIGParent bool
 }
 
 // FingerprintData represets attributes of driver/devices
 type FingerprintData struct {
 	Devices       []*FingerprintDeviceData
 	DriverVersion string
+	NVMLVersion   string
+
+	// CUDADriverVersion is the highest CUDA version supported by the driver,
+	// such as "12.4", empty when the driver does not report it
+	CUDADriverVersion string
+
+	// FailingDevices holds the reasons devices were not queried because
+	// they are quarantined or their circuit breaker is open, keyed by UUID. Such devices are reported
+	// with the data of their last successful query.
+	FailingDevices map[string]string
+
+	// MIGParents holds the MIG enabled physical GPUs, which are not part of
+	// Devices as they are not allocatable and have no stats by design
+	MIGParents []*FingerprintDeviceData
+
+	// P2P holds the peer-to-peer capabilities between every pair of physical
+	// GPUs queried, pairs that could not be queried are left out
+	P2P []*P2PStatus
 }
 
 // StatsData is a superset of DeviceData
 // it represents statistics data returned for every Nvidia device
 type StatsData struct {
 	*DeviceData
+	PowerMW            *uint
 	PowerUsageW        *uint
+	PowerUsageMW       *uint
 	GPUUtilization     *uint
 	MemoryUtilization  *uint
 	EncoderUtilization *uint
@@ -52,25 +119,182 @@ type StatsData struct {
 	ECCErrorsL1Cache   *uint64
 	ECCErrorsL2Cache   *uint64
 	ECCErrorsDevice    *uint64
+
+	ECCErrorsL1CacheAggregate *uint64
+	ECCErrorsL2CacheAggregate *uint64
+	ECCErrorsDeviceAggregate  *uint64
+
+	// Utilization of the JPEG decoders and of the optical flow accelerator,
+	// nil for devices without them
+	JPEGUtilization *uint
+	OFAUtilization  *uint
+
+	// Average and maximum GPU utilization sampled by NVML since the previous
+	// stats query, nil unless utilization sampling is enabled
+	GPUUtilizationAverage *uint
+	GPUUtilizationMax     *uint
+
+	// Average power usage since the previous stats query, computed from the
+	// energy counter, and energy consumed since the driver was last loaded
+	AveragePowerUsageMW *uint
+	EnergyMJ            *uint64
+
+	// Speed of the slowest fan and highest target speed of the fans of the
+	// device, nil for passively cooled devices
+	FanSpeed       *uint // %
+	FanTargetSpeed *uint // %
+
+	// NVLink data counters of the device in KiB, and the throughput computed
+	// from them since the previous stats query in KiB/s, nil for devices
+	// without NVLinks
+	NVLinkTxKiB   *uint64
+	NVLinkRxKiB   *uint64
+	NVLinkTxKiBps *uint64
+	NVLinkRxKiBps *uint64
+
+	// Distribution of the GPU utilization, power usage and temperature
+	// sampled since the previous stats query, nil unless stats are sampled
+	GPUUtilizationHistogram *Histogram
+	PowerUsageMWHistogram   *Histogram
+	TemperatureCHistogram   *Histogram
+
+	// GPM holds the GPU Performance Monitoring metrics since the previous
+	// stats query, nil unless GPM metrics are enabled and supported by the
+	// device
+	GPM *GPMMetrics
+
+	// QueryDuration is how long querying the device took
+	QueryDuration time.Duration
+
+	// Error describes why the stats of the device could not be queried, in
+	// which case only the UUID and the name of the device are set
+	Error string
 }
 
 // NvmlClient describes how users would use nvml library
 type NvmlClient interface {
 	GetFingerprintData() (*FingerprintData, error)
 	GetStatsData() ([]*StatsData, error)
+	ResetDevice(uuid string, full bool) error
+	GetComputeProcesses(uuid string) ([]int, error)
+	EnableAccounting(uuid string) error
+	GetAccountingStats(uuid string) ([]*AccountingStats, error)
+	ClearAccounting(uuid string) error
+	GetEnergyConsumption(uuid string) (uint64, error)
+	GetFatalError(uuid string) (string, error)
+	Preflight(uuid string) error
+	SetBreakerConfig(config BreakerConfig)
+	SetUtilizationSampling(enabled bool)
+	SetGPMMetrics(enabled bool)
+	SetErrorLogInterval(interval time.Duration)
+	SampleStats() error
+	Shutdown() error
 }
 
 // nvmlClient implements NvmlClient
 // Users of this lib are expected to use this struct via NewNvmlClient func
 type nvmlClient struct {
 	driver NvmlDriver
+
+	// encoderCapacity holds the highest encoder capacity seen per device and
+	// codec. nvml reports the capacity left by running encoder sessions, the
+	// highest value is fingerprinted so that encoder load does not change it.
+	encoderLock     sync.Mutex
+	encoderCapacity map[encoderKey]uint
+
+	// breakers stop querying devices whose queries keep failing, such
+	// devices are fingerprinted with the data of their last successful query
+	breakers        *circuitBreakers
+	quarantine      *quarantine
+	fingerprintLock sync.Mutex
+	fingerprints    map[string]*FingerprintDeviceData
+
+	// utilizationSampling indicates whether the GPU utilization samples taken
+	// by NVML since the previous stats query are summarized in the stats,
+	// lastSamples holds the timestamp of the latest sample of each device
+	samplesLock         sync.Mutex
+	utilizationSampling bool
+	lastSamples         map[string]uint64
+
+	// gpmEnabled indicates whether the GPU Performance Monitoring metrics of
+	// the devices supporting them are collected with the stats
+	gpmLock    sync.Mutex
+	gpmEnabled bool
+
+	// p2p holds the peer-to-peer capabilities between the physical GPUs
+	// listed in p2pDevices. They do not change while the GPUs are attached,
+	// so they are only queried again when the set of GPUs changes.
+	p2pLock    sync.Mutex
+	p2pDevices string
+	p2p        []*P2PStatus
+
+	// lastEnergy holds the energy counter of each device at the previous
+	// stats query, from which the average power usage is computed
+	energyLock sync.Mutex
+	lastEnergy map[string]energyReading
+
+	// lastNVLink holds the NVLink data counters of each device at the
+	// previous stats query, keyed by UUID and direction, from which the
+	// NVLink throughput is computed
+	nvlinkLock sync.Mutex
+	lastNVLink map[string]counterReading
+
+	// statsSamples holds the values sampled by SampleStats for each device
+	// since the previous stats query
+	statsSamplesLock sync.Mutex
+	statsSamples     map[string]*deviceSamples
+
+	// callTimeout bounds the time every driver call may take, so that a GPU
+	// hung in the NVIDIA driver does not freeze fingerprinting and stats.
+	// callTimeout applies when zero.
+	callTimeout time.Duration
+
+	// clock returns the time energy counters are read at, time.Now when nil.
+	// It is replaced when replaying recordings.
+	clock func() time.Time
+}
+
+// now returns the current time of the clock of the client
+func (c *nvmlClient) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock()
+}
+
+// callContext returns the context of a driver call, which is abandoned once
+// the call timeout of the client elapsed
+func (c *nvmlClient) callContext() (context.Context, context.CancelFunc) {
+	return callContext(c.callTimeout)
+}
+
+// energyReading is the energy counter of a device at a given time
+type energyReading struct {
+	energyMJ uint64
+	at       time.Time
+}
+
+// counterReading is the value of a device counter at a given time
+type counterReading struct {
+	value uint64
+	at    time.Time
+}
+
+type encoderKey struct {
+	uuid  string
+	codec string
 }
 
 // NewNvmlClient function creates new nvmlClient with real
-// NvmlDriver implementation. Also, this func initializes NvmlDriver
-func NewNvmlClient() (*nvmlClient, error) {
-	driver := &nvmlDriver{}
-	err := driver.Initialize()
+// NvmlDriver implementation. Also, this func initializes NvmlDriver.
+// The NVML library is loaded from libraryPath, or from the default library
+// search path when empty. NVML calls are logged to logger at trace level, and
+// failed calls at debug level.
+func NewNvmlClient(libraryPath string, logger hclog.Logger) (*nvmlClient, error) {
+	driver := &nvmlDriver{libraryPath: libraryPath, logger: logger}
+	ctx, cancel := callContext(0)
+	defer cancel()
+	err := driver.Initialize(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -94,61 +318,328 @@ func (c *nvmlClient) GetFingerprintData() (*FingerprintData, error) {
 		9  - Memory, Cores Clock        # nvmlDeviceGetMaxClockInfo
 		10 - Display Mode               # nvmlDeviceGetDisplayMode
 		11 - Persistence Mode           # nvmlDeviceGetPersistenceMode
+		12 - Applications Clocks        # nvmlDeviceGetApplicationsClock
+		13 - Device Index               # nvmlDeviceGetHandleByIndex
+		14 - PCI Device/Subsystem ID    # nvmlDeviceGetPciInfo
+		15 - PCIe Link Generation/Width # nvmlDeviceGetMaxPcieLinkGeneration/Width
+		16 - MIG Profiles               # nvmlDeviceGetGpuInstanceProfileInfoV
+		17 - Encoder Capacity           # nvmlDeviceGetEncoderCapacity
+		18 - NVML Version               # nvmlSystemGetNVMLVersion
+		19 - GSP Firmware Mode/Version  # nvmlDeviceGetGspFirmwareMode/Version
+		20 - InfoROM Versions           # nvmlDeviceGetInforomVersion
+		21 - InfoROM Validation         # nvmlDeviceValidateInforom
+		22 - GPU Operation Mode         # nvmlDeviceGetGpuOperationMode
+		23 - Virtualization Mode        # nvmlDeviceGetVirtualizationMode
+		24 - Fans                       # nvmlDeviceGetNumFans
+		25 - Fan Speed Range            # nvmlDeviceGetMinMaxFanSpeed
+		26 - Fan Control Policy         # nvmlDeviceGetFanControlPolicy_v2
+		27 - Coherent Memory            # nvmlDeviceGetC2cModeInfoV
+		28 - NUMA Nodes                 # nvmlDeviceGetMemoryAffinity/NumaNodeId
+		29 - Brand                      # nvmlDeviceGetBrand
+		30 - C2C Links                  # nvmlDeviceGetFieldValues
+		31 - P2P Capabilities           # nvmlDeviceGetP2PStatus
+		32 - vGPU License               # nvmlDeviceGetGridLicensableFeatures
+		33 - CUDA Driver Version        # nvmlSystemGetCudaDriverVersion_v2
+		34 - Compute Capability         # nvmlDeviceGetCudaComputeCapability
 	*/
 
 	// Assumed that this method is called with receiver retrieved from
 	// NewNvmlClient because this method handles initialization of NVML library
 
-	driverVersion, err := c.driver.SystemDriverVersion()
+	ctx, cancel := c.callContext()
+	driverVersion, err := c.driver.SystemDriverVersion(ctx)
+	cancel()
 	if err != nil {
 		return nil, fmt.Errorf("nvidia nvml SystemDriverVersion() error: %v\n", err)
 	}
 
-	deviceUUIDs, err := c.driver.ListDeviceUUIDs()
+	ctx, cancel = c.callContext()
+	nvmlVersion, err := c.driver.SystemNVMLVersion(ctx)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("nvidia nvml SystemNVMLVersion() error: %v\n", err)
+	}
+
+	ctx, cancel = c.callContext()
+	cudaDriverVersion, err := c.driver.SystemCUDADriverVersion(ctx)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("nvidia nvml SystemCUDADriverVersion() error: %v\n", err)
+	}
+
+	ctx, cancel = c.callContext()
+	deviceUUIDs, err := c.driver.ListDeviceUUIDs(ctx)
+	cancel()
 	if err != nil {
 		return nil, fmt.Errorf("nvidia nvml ListDeviceUUIDs() error: %v\n", err)
 	}
 
 	allNvidiaGPUResources := make([]*FingerprintDeviceData, 0, len(deviceUUIDs))
+	var failingDevices map[string]string
+	var migParents []*FingerprintDeviceData
+	var physicalGPUs []string
 
-	for uuid, mode := range deviceUUIDs {
-		// do not care about phsyical parents of MIGs
-		if mode == parent {
+	for _, identity := range deviceUUIDs {
+		// physical parents of MIGs are reported apart, a parent that can not
+		// be queried only lacks from the report since it is not allocatable
+		if identity.Mode == parent {
+			if deviceInfo, err := c.deviceInfo(identity.UUID); err == nil {
+				parentData := c.fingerprintDeviceData(identity, deviceInfo)
+				parentData.MIGParent = true
+				migParents = append(migParents, parentData)
+				physicalGPUs = append(physicalGPUs, identity.UUID)
+			}
 			continue
 		}
 
-		deviceInfo, err := c.driver.DeviceInfoByUUID(uuid)
+		deviceInfo, err := c.deviceInfo(identity.UUID)
+		if err == errBreakerOpen || err == errQuarantined {
+			if failingDevices == nil {
+				failingDevices = make(map[string]string)
+			}
+			failingDevices[identity.UUID] = c.failureReason(identity.UUID, err)
+			allNvidiaGPUResources = c.appendLastFingerprint(allNvidiaGPUResources, identity.UUID)
+			continue
+		}
+		if unresponsive(err) {
+			// devices that did not respond keep their last fingerprint
+			// until they are quarantined
+			allNvidiaGPUResources = c.appendLastFingerprint(allNvidiaGPUResources, identity.UUID)
+			continue
+		}
 		if err != nil {
 			return nil, fmt.Errorf("nvidia nvml DeviceInfoByUUID() error: %v\n", err)
 		}
 
-		allNvidiaGPUResources = append(allNvidiaGPUResources, &FingerprintDeviceData{
-			DeviceData: &DeviceData{
-				DeviceName: deviceInfo.Name,
-				UUID:       deviceInfo.UUID,
-				MemoryMiB:  deviceInfo.MemoryMiB,
-				PowerW:     deviceInfo.PowerW,
-				BAR1MiB:    deviceInfo.BAR1MiB,
-			},
-			PCIBandwidthMBPerS: deviceInfo.PCIBandwidthMBPerS,
-			CoresClockMHz:      deviceInfo.CoresClockMHz,
-			MemoryClockMHz:     deviceInfo.MemoryClockMHz,
-			DisplayState:       deviceInfo.DisplayState,
-			PersistenceMode:    deviceInfo.PersistenceMode,
-			PCIBusID:           deviceInfo.PCIBusID,
-		})
+		deviceData := c.fingerprintDeviceData(identity, deviceInfo)
+		c.setLastFingerprint(deviceData)
+		allNvidiaGPUResources = append(allNvidiaGPUResources, deviceData)
+		if identity.Mode == normal {
+			physicalGPUs = append(physicalGPUs, identity.UUID)
+		}
+	}
 
-		slices.SortFunc(allNvidiaGPUResources, func(a, b *FingerprintDeviceData) int {
+	for _, devices := range [][]*FingerprintDeviceData{allNvidiaGPUResources, migParents} {
+		slices.SortFunc(devices, func(a, b *FingerprintDeviceData) int {
 			return cmp.Compare(a.DeviceData.UUID, b.DeviceData.UUID)
 		})
 	}
 
 	return &FingerprintData{
-		Devices:       allNvidiaGPUResources,
-		DriverVersion: driverVersion,
+		Devices:           allNvidiaGPUResources,
+		DriverVersion:     driverVersion,
+		NVMLVersion:       nvmlVersion,
+		CUDADriverVersion: cudaDriverVersion,
+		FailingDevices:    failingDevices,
+		MIGParents:        migParents,
+		P2P:               c.p2pStatus(physicalGPUs),
 	}, nil
 }
 
+// p2pStatus returns the peer-to-peer capabilities between every pair of the
+// given physical GPUs, from the previous query when the GPUs did not change.
+// Pairs that could not be queried are left out and queried again on the next
+// call, pairs whose capabilities are not supported are left out for good.
+func (c *nvmlClient) p2pStatus(uuids []string) []*P2PStatus {
+	slices.Sort(uuids)
+	devices := strings.Join(uuids, ",")
+
+	c.p2pLock.Lock()
+	defer c.p2pLock.Unlock()
+	if c.p2pDevices == devices {
+		return c.p2p
+	}
+
+	var statuses []*P2PStatus
+	complete := true
+	for i, uuid := range uuids {
+		for _, peerUUID := range uuids[i+1:] {
+			ctx, cancel := c.callContext()
+			status, err := c.driver.P2PStatusByUUID(ctx, uuid, peerUUID)
+			cancel()
+			if errors.Is(err, ErrNotSupported) {
+				continue
+			}
+			if err != nil {
+				complete = false
+				continue
+			}
+			statuses = append(statuses, status)
+		}
+	}
+	if complete {
+		c.p2pDevices = devices
+		c.p2p = statuses
+	}
+	return statuses
+}
+
+// fingerprintDeviceData returns the fingerprint of the device with the given
+// identity from the data NVML reported about it
+func (c *nvmlClient) fingerprintDeviceData(identity DeviceIdentity, deviceInfo *DeviceInfo) *FingerprintDeviceData {
+	return &FingerprintDeviceData{
+		DeviceData: &DeviceData{
+			DeviceName: deviceInfo.Name,
+			UUID:       deviceInfo.UUID,
+			MemoryMiB:  deviceInfo.MemoryMiB,
+			PowerW:     deviceInfo.PowerW,
+			BAR1MiB:    deviceInfo.BAR1MiB,
+		},
+		Index:                     &identity.Index,
+		ParentUUID:                identity.ParentUUID,
+		PCIBandwidthMBPerS:        deviceInfo.PCIBandwidthMBPerS,
+		PCIDeviceID:               deviceInfo.PCIDeviceID,
+		PCISubsystemID:            deviceInfo.PCISubsystemID,
+		PCILinkGeneration:         deviceInfo.PCILinkGeneration,
+		PCILinkWidth:              deviceInfo.PCILinkWidth,
+		CoresClockMHz:             deviceInfo.CoresClockMHz,
+		MemoryClockMHz:            deviceInfo.MemoryClockMHz,
+		ApplicationCoresClockMHz:  deviceInfo.ApplicationCoresClockMHz,
+		ApplicationMemoryClockMHz: deviceInfo.ApplicationMemoryClockMHz,
+		DisplayState:              deviceInfo.DisplayState,
+		PersistenceMode:           deviceInfo.PersistenceMode,
+		PCIBusID:                  deviceInfo.PCIBusID,
+		MIGProfiles:               deviceInfo.MIGProfiles,
+		EncoderCapacityH264:       c.maxEncoderCapacity(identity.UUID, "h264", deviceInfo.EncoderCapacityH264),
+		EncoderCapacityHEVC:       c.maxEncoderCapacity(identity.UUID, "hevc", deviceInfo.EncoderCapacityHEVC),
+		EncoderCapacityAV1:        c.maxEncoderCapacity(identity.UUID, "av1", deviceInfo.EncoderCapacityAV1),
+		GSPFirmwareMode:           deviceInfo.GSPFirmwareMode,
+		GSPFirmwareVersion:        deviceInfo.GSPFirmwareVersion,
+		InfoROMVersionOEM:         deviceInfo.InfoROMVersionOEM,
+		InfoROMVersionECC:         deviceInfo.InfoROMVersionECC,
+		InfoROMVersionPower:       deviceInfo.InfoROMVersionPower,
+		InfoROMCorrupted:          deviceInfo.InfoROMCorrupted,
+		OperationMode:             deviceInfo.OperationMode,
+		VirtualizationMode:        deviceInfo.VirtualizationMode,
+		Brand:                     deviceInfo.Brand,
+		Architecture:              deviceInfo.Architecture,
+		ComputeCapability:         deviceInfo.ComputeCapability,
+		FanCount:                  deviceInfo.FanCount,
+		FanSpeedMin:               deviceInfo.FanSpeedMin,
+		FanSpeedMax:               deviceInfo.FanSpeedMax,
+		FanControl:                deviceInfo.FanControl,
+		CoherentMemory:            deviceInfo.CoherentMemory,
+		NUMANode:                  deviceInfo.NUMANode,
+		MemoryNUMANode:            deviceInfo.MemoryNUMANode,
+		C2CLinks:                  deviceInfo.C2CLinks,
+		C2CBandwidthMBps:          deviceInfo.C2CBandwidthMBps,
+		MaxOperatingTemperatureC:  deviceInfo.MaxOperatingTemperatureC,
+		AcousticTemperatureC:      deviceInfo.AcousticTemperatureC,
+		VGPULicensed:              deviceInfo.VGPULicensed,
+		VGPULicenseExpiry:         deviceInfo.VGPULicenseExpiry,
+	}
+}
+
+// deviceInfo queries the device with the given UUID through its quarantine
+// and circuit breaker, returning errQuarantined or errBreakerOpen when the
+// device is not queried
+func (c *nvmlClient) deviceInfo(uuid string) (*DeviceInfo, error) {
+	if !c.quarantine.allow(uuid) {
+		return nil, errQuarantined
+	}
+	if !c.breakers.allow(uuid) {
+		return nil, errBreakerOpen
+	}
+	ctx, cancel := c.callContext()
+	defer cancel()
+	deviceInfo, err := c.driver.DeviceInfoByUUID(ctx, uuid)
+	if err != nil {
+		quarantined := c.quarantine.failure(uuid, err)
+		open := c.breakers.failure(uuid, err)
+		switch {
+		case quarantined:
+			return nil, errQuarantined
+		case open:
+			return nil, errBreakerOpen
+		}
+		return nil, err
+	}
+	c.breakers.success(uuid)
+	return deviceInfo, nil
+}
+
+// statsError returns the stats of the device with the given UUID whose query
+// failed with err, which only hold the error. The name of the device is taken
+// from its last fingerprint when it is unknown.
+func (c *nvmlClient) statsError(uuid string, name *string, start time.Time, err error) *StatsData {
+	if name == nil {
+		c.fingerprintLock.Lock()
+		if data, ok := c.fingerprints[uuid]; ok {
+			name = data.DeviceName
+		}
+		c.fingerprintLock.Unlock()
+	}
+	return &StatsData{
+		DeviceData:    &DeviceData{UUID: uuid, DeviceName: name},
+		QueryDuration: time.Since(start),
+		Error:         err.Error(),
+	}
+}
+
+// failureReason describes why the device with the given UUID is not
+// queried, given the error returned by deviceInfo
+func (c *nvmlClient) failureReason(uuid string, err error) string {
+	if err == errQuarantined {
+		return c.quarantine.reason(uuid)
+	}
+	return c.breakers.reason(uuid)
+}
+
+// queryFailed records a failed query of the device with the given UUID, and
+// reports whether the device is left out of the response rather than failing
+// it, which is the case when the device got quarantined, its circuit breaker
+// opened or it did not respond
+func (c *nvmlClient) queryFailed(uuid string, err error) bool {
+	quarantined := c.quarantine.failure(uuid, err)
+	open := c.breakers.failure(uuid, err)
+	return quarantined || open || unresponsive(err)
+}
+
+// setLastFingerprint records the data of a successful device query, to be
+// reported while the device is quarantined, unresponsive or its circuit
+// breaker is open, and to name the device when its stats query fails
+func (c *nvmlClient) setLastFingerprint(data *FingerprintDeviceData) {
+	c.fingerprintLock.Lock()
+	defer c.fingerprintLock.Unlock()
+
+	if c.fingerprints == nil {
+		c.fingerprints = make(map[string]*FingerprintDeviceData)
+	}
+	c.fingerprints[data.UUID] = data
+}
+
+// appendLastFingerprint appends the data of the last successful query of the
+// device with the given UUID to devices, if there was any
+func (c *nvmlClient) appendLastFingerprint(devices []*FingerprintDeviceData, uuid string) []*FingerprintDeviceData {
+	c.fingerprintLock.Lock()
+	defer c.fingerprintLock.Unlock()
+
+	if data, ok := c.fingerprints[uuid]; ok {
+		devices = append(devices, data)
+	}
+	return devices
+}
+
+// maxEncoderCapacity records the given encoder capacity of a device and codec
+// and returns the highest capacity recorded so far, or nil if the codec is
+// not supported
+func (c *nvmlClient) maxEncoderCapacity(uuid, codec string, capacity *uint) *uint {
+	if capacity == nil {
+		return nil
+	}
+
+	c.encoderLock.Lock()
+	defer c.encoderLock.Unlock()
+
+	if c.encoderCapacity == nil {
+		c.encoderCapacity = make(map[encoderKey]uint)
+	}
+	key := encoderKey{uuid: uuid, codec: codec}
+	highest := max(c.encoderCapacity[key], *capacity)
+	c.encoderCapacity[key] = highest
+	return &highest
+}
+
 // GetStatsData returns statistics data for all devices on this machine
 func (c *nvmlClient) GetStatsData() ([]*StatsData, error) {
 	/*
@@ -161,37 +652,84 @@ func (c *nvmlClient) GetStatsData() ([]*StatsData, error) {
 	   6  - Current GPU Temperature                # nvmlDeviceGetTemperature
 	   7  - Power Draw                             # nvmlDeviceGetPowerUsage
 	   8  - BAR1 Used memory                       # nvmlDeviceGetBAR1MemoryInfo
-	   9  - ECC Errors on requesting L1Cache       # nvmlDeviceGetMemoryErrorCounter
-	   10 - ECC Errors on requesting L2Cache       # nvmlDeviceGetMemoryErrorCounter
-	   11 - ECC Errors on requesting Device memory # nvmlDeviceGetMemoryErrorCounter
+	   9  - ECC Errors on requesting L1Cache       # nvmlDeviceGetFieldValues
+	   10 - ECC Errors on requesting L2Cache       # nvmlDeviceGetFieldValues
+	   11 - ECC Errors on requesting Device memory # nvmlDeviceGetFieldValues
+	   12 - Aggregate ECC Errors                   # nvmlDeviceGetFieldValues
+	   13 - Energy Consumption                     # nvmlDeviceGetTotalEnergyConsumption
+	   14 - Fan Speed                              # nvmlDeviceGetFanSpeed_v2
+	   15 - Fan Target Speed                       # nvmlDeviceGetTargetFanSpeed
+	   16 - NVLink Throughput                      # nvmlDeviceGetFieldValues
+
+	   ECC errors are read with a single batched query, or with
+	   nvmlDeviceGetDetailedEccErrors on drivers that do not support it
 	*/
 
 	// Assumed that this method is called with receiver retrieved from
 	// NewNvmlClient because this method handles initialization of NVML library
 
-	deviceUUIDs, err := c.driver.ListDeviceUUIDs()
+	ctx, cancel := c.callContext()
+	deviceUUIDs, err := c.driver.ListDeviceUUIDs(ctx)
+	cancel()
 	if err != nil {
 		return nil, fmt.Errorf("nvidia nvml ListDeviceUUIDs() error: %v\n", err)
 	}
 
 	allNvidiaGPUStats := make([]*StatsData, 0, len(deviceUUIDs))
 
-	for uuid, mode := range deviceUUIDs {
+	for _, identity := range deviceUUIDs {
 
 		// A30/A100 MIG devices have no stats.
 		//
 		// https://docs.nvidia.com/datacenter/tesla/mig-user-guide/#telemetry
 		//
 		// Is this fixed on H100 or later? Maybe?
-		if mode == mig || mode == parent {
+		if identity.Mode == mig || identity.Mode == parent {
 			continue
 		}
 
-		deviceInfo, deviceStatus, err := c.driver.DeviceInfoAndStatusByUUID(uuid)
+		// quarantined devices and devices whose circuit breaker is open have
+		// no stats
+		if !c.quarantine.allow(identity.UUID) || !c.breakers.allow(identity.UUID) {
+			continue
+		}
+
+		start := time.Now()
+		readAt := c.now()
+		ctx, cancel := c.callContext()
+		deviceInfo, deviceStatus, err := c.driver.DeviceInfoAndStatusByUUID(ctx, identity.UUID)
+		cancel()
+		if err != nil {
+			if c.queryFailed(identity.UUID, err) {
+				continue
+			}
+			allNvidiaGPUStats = append(allNvidiaGPUStats, c.statsError(identity.UUID, nil, start,
+				fmt.Errorf("nvidia nvml DeviceInfoAndStatusByUUID() error: %v", err)))
+			continue
+		}
+
+		utilizationAverage, utilizationMax, err := c.utilizationSamples(identity.UUID)
 		if err != nil {
-			return nil, fmt.Errorf("nvidia nvml DeviceInfoAndStatusByUUID() error: %v\n", err)
+			if c.queryFailed(identity.UUID, err) {
+				continue
+			}
+			allNvidiaGPUStats = append(allNvidiaGPUStats, c.statsError(identity.UUID, deviceInfo.Name, start,
+				fmt.Errorf("nvidia nvml UtilizationSamplesByUUID() error: %v", err)))
+			continue
 		}
 
+		gpm, err := c.gpmMetrics(identity.UUID)
+		if err != nil {
+			if c.queryFailed(identity.UUID, err) {
+				continue
+			}
+			allNvidiaGPUStats = append(allNvidiaGPUStats, c.statsError(identity.UUID, deviceInfo.Name, start,
+				fmt.Errorf("nvidia nvml GPMMetricsByUUID() error: %v", err)))
+			continue
+		}
+		c.breakers.success(identity.UUID)
+		utilizationHistogram, powerHistogram, temperatureHistogram := c.statsHistograms(identity.UUID, deviceStatus)
+
 		allNvidiaGPUStats = append(allNvidiaGPUStats, &StatsData{
 			DeviceData: &DeviceData{
 				DeviceName: deviceInfo.Name,
@@ -200,22 +738,267 @@ func (c *nvmlClient) GetStatsData() ([]*StatsData, error) {
 				PowerW:     deviceInfo.PowerW,
 				BAR1MiB:    deviceInfo.BAR1MiB,
 			},
+			PowerMW:            deviceInfo.PowerMW,
 			PowerUsageW:        deviceStatus.PowerUsageW,
+			PowerUsageMW:       deviceStatus.PowerUsageMW,
 			GPUUtilization:     deviceStatus.GPUUtilization,
 			MemoryUtilization:  deviceStatus.MemoryUtilization,
 			EncoderUtilization: deviceStatus.EncoderUtilization,
 			DecoderUtilization: deviceStatus.DecoderUtilization,
+			JPEGUtilization:    deviceStatus.JPEGUtilization,
+			OFAUtilization:     deviceStatus.OFAUtilization,
 			TemperatureC:       deviceStatus.TemperatureC,
 			UsedMemoryMiB:      deviceStatus.UsedMemoryMiB,
 			BAR1UsedMiB:        deviceStatus.BAR1UsedMiB,
 			ECCErrorsL1Cache:   deviceStatus.ECCErrorsL1Cache,
 			ECCErrorsL2Cache:   deviceStatus.ECCErrorsL2Cache,
 			ECCErrorsDevice:    deviceStatus.ECCErrorsDevice,
-		})
 
-		slices.SortFunc(allNvidiaGPUStats, func(a, b *StatsData) int {
-			return cmp.Compare(a.DeviceData.UUID, b.DeviceData.UUID)
+			ECCErrorsL1CacheAggregate: deviceStatus.ECCErrorsL1CacheAggregate,
+			ECCErrorsL2CacheAggregate: deviceStatus.ECCErrorsL2CacheAggregate,
+			ECCErrorsDeviceAggregate:  deviceStatus.ECCErrorsDeviceAggregate,
+
+			GPUUtilizationAverage: utilizationAverage,
+			GPUUtilizationMax:     utilizationMax,
+
+			AveragePowerUsageMW: c.averagePowerUsage(identity.UUID, deviceStatus.EnergyMJ, readAt),
+			EnergyMJ:            deviceStatus.EnergyMJ,
+
+			FanSpeed:       deviceStatus.FanSpeed,
+			FanTargetSpeed: deviceStatus.FanTargetSpeed,
+
+			NVLinkTxKiB:   deviceStatus.NVLinkTxKiB,
+			NVLinkRxKiB:   deviceStatus.NVLinkRxKiB,
+			NVLinkTxKiBps: c.nvlinkThroughput(identity.UUID+"/tx", deviceStatus.NVLinkTxKiB, readAt),
+			NVLinkRxKiBps: c.nvlinkThroughput(identity.UUID+"/rx", deviceStatus.NVLinkRxKiB, readAt),
+
+			GPUUtilizationHistogram: utilizationHistogram,
+			PowerUsageMWHistogram:   powerHistogram,
+			TemperatureCHistogram:   temperatureHistogram,
+
+			GPM: gpm,
+
+			QueryDuration: time.Since(start),
 		})
 	}
+
+	slices.SortFunc(allNvidiaGPUStats, func(a, b *StatsData) int {
+		return cmp.Compare(a.DeviceData.UUID, b.DeviceData.UUID)
+	})
 	return allNvidiaGPUStats, nil
 }
+
+// utilizationSamples returns the average and maximum of the GPU utilization
+// samples taken since the previous call for the device with the given UUID,
+// or nil values when sampling is disabled or no samples were taken
+func (c *nvmlClient) utilizationSamples(uuid string) (*uint, *uint, error) {
+	c.samplesLock.Lock()
+	defer c.samplesLock.Unlock()
+
+	if !c.utilizationSampling {
+		return nil, nil, nil
+	}
+	ctx, cancel := c.callContext()
+	defer cancel()
+	samples, latest, err := c.driver.UtilizationSamplesByUUID(ctx, uuid, c.lastSamples[uuid])
+	if err != nil {
+		return nil, nil, err
+	}
+	c.lastSamples[uuid] = latest
+	if len(samples) == 0 {
+		return nil, nil, nil
+	}
+
+	var sum, maximum uint
+	for _, sample := range samples {
+		sum += sample
+		maximum = max(maximum, sample)
+	}
+	average := (sum + uint(len(samples))/2) / uint(len(samples))
+	return &average, &maximum, nil
+}
+
+// gpmMetrics returns the GPU Performance Monitoring metrics of the device with
+// the given UUID since the previous call, or nil when GPM metrics are disabled,
+// not supported by the device, or on the first call
+func (c *nvmlClient) gpmMetrics(uuid string) (*GPMMetrics, error) {
+	c.gpmLock.Lock()
+	enabled := c.gpmEnabled
+	c.gpmLock.Unlock()
+	if !enabled {
+		return nil, nil
+	}
+
+	ctx, cancel := c.callContext()
+	defer cancel()
+	metrics, err := c.driver.GPMMetricsByUUID(ctx, uuid)
+	if errors.Is(err, ErrNotSupported) {
+		return nil, nil
+	}
+	return metrics, err
+}
+
+// averagePowerUsage returns the average power usage in milliwatts of the
+// device with the given UUID since the previous call, computed from its energy
+// counter read at the given time. Power usage spikes between stats queries, so
+// the average is steadier than the instantaneous power usage. Nil is returned
+// on the first call, and when the counter is not available or was reset by a
+// driver reload.
+func (c *nvmlClient) averagePowerUsage(uuid string, energyMJ *uint64, at time.Time) *uint {
+	c.energyLock.Lock()
+	defer c.energyLock.Unlock()
+
+	if energyMJ == nil {
+		delete(c.lastEnergy, uuid)
+		return nil
+	}
+	if c.lastEnergy == nil {
+		c.lastEnergy = make(map[string]energyReading)
+	}
+	last, ok := c.lastEnergy[uuid]
+	c.lastEnergy[uuid] = energyReading{energyMJ: *energyMJ, at: at}
+	elapsed := at.Sub(last.at)
+	if !ok || *energyMJ < last.energyMJ || elapsed <= 0 {
+		return nil
+	}
+
+	// millijoules per second are milliwatts
+	average := uint((*energyMJ - last.energyMJ) * uint64(time.Second) / uint64(elapsed))
+	return &average
+}
+
+// nvlinkThroughput returns the rate in KiB/s of the NVLink data counter with
+// the given key since the previous call, from its value in KiB read at the
+// given time. Nil is returned on the first call, and when the counter is not
+// available or was reset by a driver reload.
+func (c *nvmlClient) nvlinkThroughput(key string, counterKiB *uint64, at time.Time) *uint64 {
+	c.nvlinkLock.Lock()
+	defer c.nvlinkLock.Unlock()
+
+	if counterKiB == nil {
+		delete(c.lastNVLink, key)
+		return nil
+	}
+	if c.lastNVLink == nil {
+		c.lastNVLink = make(map[string]counterReading)
+	}
+	last, ok := c.lastNVLink[key]
+	c.lastNVLink[key] = counterReading{value: *counterKiB, at: at}
+	elapsed := at.Sub(last.at)
+	if !ok || *counterKiB < last.value || elapsed <= 0 {
+		return nil
+	}
+
+	throughput := (*counterKiB - last.value) * uint64(time.Second) / uint64(elapsed)
+	return &throughput
+}
+
+// ResetDevice resets the clocks of the device with the given UUID, or the
+// whole device when full is set
+func (c *nvmlClient) ResetDevice(uuid string, full bool) error {
+	if full {
+		// full resets run nvidia-smi, which takes longer than NVML calls
+		ctx, cancel := callContext(gpuResetTimeout)
+		defer cancel()
+		return c.driver.ResetDevice(ctx, uuid)
+	}
+	ctx, cancel := c.callContext()
+	defer cancel()
+	return c.driver.ResetDeviceClocks(ctx, uuid)
+}
+
+// GetComputeProcesses returns the PIDs of compute processes running on the
+// device with the given UUID
+func (c *nvmlClient) GetComputeProcesses(uuid string) ([]int, error) {
+	ctx, cancel := c.callContext()
+	defer cancel()
+	return c.driver.ComputeProcessesByUUID(ctx, uuid)
+}
+
+// EnableAccounting enables accounting mode on the device with the given UUID
+func (c *nvmlClient) EnableAccounting(uuid string) error {
+	ctx, cancel := c.callContext()
+	defer cancel()
+	return c.driver.EnableAccountingByUUID(ctx, uuid)
+}
+
+// GetAccountingStats returns the accounting data of the processes that ran on
+// the device with the given UUID since its accounting data was last cleared
+func (c *nvmlClient) GetAccountingStats(uuid string) ([]*AccountingStats, error) {
+	ctx, cancel := c.callContext()
+	defer cancel()
+	return c.driver.AccountingStatsByUUID(ctx, uuid)
+}
+
+// ClearAccounting clears the accounting data of the device with the given UUID
+func (c *nvmlClient) ClearAccounting(uuid string) error {
+	ctx, cancel := c.callContext()
+	defer cancel()
+	return c.driver.ClearAccountingByUUID(ctx, uuid)
+}
+
+// GetEnergyConsumption returns the energy consumed by the device with the
+// given UUID since the driver was last loaded, in millijoules
+func (c *nvmlClient) GetEnergyConsumption(uuid string) (uint64, error) {
+	ctx, cancel := c.callContext()
+	defer cancel()
+	return c.driver.EnergyConsumptionByUUID(ctx, uuid)
+}
+
+// GetFatalError returns a description of the fatal error the device with the
+// given UUID is in, or an empty string if it has none
+func (c *nvmlClient) GetFatalError(uuid string) (string, error) {
+	ctx, cancel := c.callContext()
+	defer cancel()
+	return c.driver.FatalErrorByUUID(ctx, uuid)
+}
+
+// Preflight runs sanity checks on the device with the given UUID, returning
+// an error describing the first failed check
+func (c *nvmlClient) Preflight(uuid string) error {
+	ctx, cancel := c.callContext()
+	defer cancel()
+	return c.driver.PreflightByUUID(ctx, uuid)
+}
+
+// SetBreakerConfig replaces the circuit breakers guarding the queries of each
+// device with ones using config
+func (c *nvmlClient) SetBreakerConfig(config BreakerConfig) {
+	c.breakers = newCircuitBreakers(config)
+	c.quarantine = newQuarantine(config)
+}
+
+// SetUtilizationSampling sets whether the GPU utilization samples taken by
+// NVML between stats queries are summarized in the stats
+func (c *nvmlClient) SetUtilizationSampling(enabled bool) {
+	c.samplesLock.Lock()
+	defer c.samplesLock.Unlock()
+
+	c.utilizationSampling = enabled
+	c.lastSamples = make(map[string]uint64)
+}
+
+// SetGPMMetrics sets whether the GPU Performance Monitoring metrics of the
+// devices supporting them are collected with the stats
+func (c *nvmlClient) SetGPMMetrics(enabled bool) {
+	c.gpmLock.Lock()
+	defer c.gpmLock.Unlock()
+
+	c.gpmEnabled = enabled
+}
+
+// SetErrorLogInterval sets the interval during which the logs of NVML calls
+// failing repeatedly with the same return code are coalesced, zero disables
+// coalescing
+func (c *nvmlClient) SetErrorLogInterval(interval time.Duration) {
+	if driver, ok := c.driver.(*nvmlDriver); ok {
+		driver.failedCalls.Store(NewLogLimiter(interval, nil))
+	}
+}
+
+// Shutdown releases the NVML library, the client must not be used afterwards
+func (c *nvmlClient) Shutdown() error {
+	ctx, cancel := c.callContext()
+	defer cancel()
+	return c.driver.Shutdown(ctx)
+}
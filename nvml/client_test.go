@@ -4,8 +4,10 @@
 package nvml
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/nomad/helper/pointer"
 	"github.com/shoenig/test/must"
@@ -18,43 +20,85 @@ type MockNVMLDriver struct {
 	listDeviceUUIDsSuccessful               bool
 	deviceInfoByUUIDCallSuccessful          bool
 	deviceInfoAndStatusByUUIDCallSuccessful bool
+	deviceInfoByUUIDErr                     error
 	driverVersion                           string
+	nvmlVersion                             string
+	cudaDriverVersion                       string
 	devices                                 []*DeviceInfo
 	deviceStatus                            []*DeviceStatus
 	modes                                   []mode
+	resetCalls                              []string
+	processes                               map[string][]int
+	fatalErrors                             map[string]string
+	preflightErrors                         map[string]error
+	utilizationSamples                      map[string][]uint
+	energy                                  map[string]uint64
+	samples                                 map[string][]*DeviceSample
+	gpmMetrics                              map[string]*GPMMetrics
+	p2p                                     map[[2]string]*P2PStatus
+	p2pErrors                               map[[2]string]error
+	p2pCalls                                int
 }
 
-func (m *MockNVMLDriver) Initialize() error {
+func (m *MockNVMLDriver) Initialize(context.Context) error {
 	return nil
 }
 
-func (m *MockNVMLDriver) Shutdown() error {
+func (m *MockNVMLDriver) Shutdown(context.Context) error {
 	return nil
 }
 
-func (m *MockNVMLDriver) SystemDriverVersion() (string, error) {
+func (m *MockNVMLDriver) SystemDriverVersion(context.Context) (string, error) {
 	if !m.systemDriverCallSuccessful {
 		return "", errors.New("failed to get system driver")
 	}
 	return m.driverVersion, nil
 }
 
-func (m *MockNVMLDriver) ListDeviceUUIDs() (map[string]mode, error) {
+func (m *MockNVMLDriver) SystemNVMLVersion(context.Context) (string, error) {
+	return m.nvmlVersion, nil
+}
+
+func (m *MockNVMLDriver) SystemCUDADriverVersion(context.Context) (string, error) {
+	return m.cudaDriverVersion, nil
+}
+
+func (m *MockNVMLDriver) ListDeviceUUIDs(context.Context) ([]DeviceIdentity, error) {
 	if !m.listDeviceUUIDsSuccessful {
 		return nil, errors.New("failed to get device length")
 	}
 
-	allNvidiaGPUUUIDs := make(map[string]mode)
-
+	// MIG devices follow their parent and share its index
+	var identities []DeviceIdentity
+	var index uint
+	var parentUUID string
 	for i, device := range m.devices {
-		allNvidiaGPUUUIDs[device.UUID] = m.modes[i]
+		identity := DeviceIdentity{
+			UUID:  device.UUID,
+			Index: index,
+			Mode:  m.modes[i],
+		}
+		switch m.modes[i] {
+		case parent:
+			parentUUID = device.UUID
+		case mig:
+			identity.Index--
+			identity.ParentUUID = parentUUID
+		}
+		if m.modes[i] != mig {
+			index++
+		}
+		identities = append(identities, identity)
 	}
 
-	return allNvidiaGPUUUIDs, nil
+	return identities, nil
 }
 
-func (m *MockNVMLDriver) DeviceInfoByUUID(uuid string) (*DeviceInfo, error) {
+func (m *MockNVMLDriver) DeviceInfoByUUID(_ context.Context, uuid string) (*DeviceInfo, error) {
 	if !m.deviceInfoByUUIDCallSuccessful {
+		if m.deviceInfoByUUIDErr != nil {
+			return nil, m.deviceInfoByUUIDErr
+		}
 		return nil, errors.New("failed to get device info by UUID")
 	}
 
@@ -67,7 +111,7 @@ func (m *MockNVMLDriver) DeviceInfoByUUID(uuid string) (*DeviceInfo, error) {
 	return nil, errors.New("failed to get device handle")
 }
 
-func (m *MockNVMLDriver) DeviceInfoAndStatusByUUID(uuid string) (*DeviceInfo, *DeviceStatus, error) {
+func (m *MockNVMLDriver) DeviceInfoAndStatusByUUID(_ context.Context, uuid string) (*DeviceInfo, *DeviceStatus, error) {
 	if !m.deviceInfoAndStatusByUUIDCallSuccessful {
 		return nil, nil, errors.New("failed to get device info and status by index")
 	}
@@ -81,6 +125,92 @@ func (m *MockNVMLDriver) DeviceInfoAndStatusByUUID(uuid string) (*DeviceInfo, *D
 	return nil, nil, errors.New("failed to get device handle")
 }
 
+func (m *MockNVMLDriver) ResetDeviceClocks(_ context.Context, uuid string) error {
+	m.resetCalls = append(m.resetCalls, "clocks:"+uuid)
+	return nil
+}
+
+func (m *MockNVMLDriver) ResetDevice(_ context.Context, uuid string) error {
+	m.resetCalls = append(m.resetCalls, "full:"+uuid)
+	return nil
+}
+
+func (m *MockNVMLDriver) ComputeProcessesByUUID(_ context.Context, uuid string) ([]int, error) {
+	return m.processes[uuid], nil
+}
+
+func (m *MockNVMLDriver) EnableAccountingByUUID(_ context.Context, uuid string) error {
+	return nil
+}
+
+func (m *MockNVMLDriver) AccountingStatsByUUID(_ context.Context, uuid string) ([]*AccountingStats, error) {
+	return nil, nil
+}
+
+func (m *MockNVMLDriver) ClearAccountingByUUID(_ context.Context, uuid string) error {
+	return nil
+}
+
+func (m *MockNVMLDriver) FatalErrorByUUID(_ context.Context, uuid string) (string, error) {
+	return m.fatalErrors[uuid], nil
+}
+
+func (m *MockNVMLDriver) PreflightByUUID(_ context.Context, uuid string) error {
+	return m.preflightErrors[uuid]
+}
+
+// UtilizationSamplesByUUID returns the samples of the device taken after
+// lastSeen, timestamps being the sample indexes starting from one
+func (m *MockNVMLDriver) UtilizationSamplesByUUID(_ context.Context, uuid string, lastSeen uint64) ([]uint, uint64, error) {
+	samples := m.utilizationSamples[uuid]
+	if lastSeen >= uint64(len(samples)) {
+		return nil, lastSeen, nil
+	}
+	return samples[lastSeen:], uint64(len(samples)), nil
+}
+
+func (m *MockNVMLDriver) EnergyConsumptionByUUID(_ context.Context, uuid string) (uint64, error) {
+	energy, ok := m.energy[uuid]
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return energy, nil
+}
+
+// SampleByUUID returns the samples of the device one after the other,
+// repeating the last one
+func (m *MockNVMLDriver) SampleByUUID(_ context.Context, uuid string) (*DeviceSample, error) {
+	samples := m.samples[uuid]
+	if len(samples) == 0 {
+		return &DeviceSample{}, nil
+	}
+	sample := samples[0]
+	if len(samples) > 1 {
+		m.samples[uuid] = samples[1:]
+	}
+	return sample, nil
+}
+
+func (m *MockNVMLDriver) GPMMetricsByUUID(_ context.Context, uuid string) (*GPMMetrics, error) {
+	metrics, ok := m.gpmMetrics[uuid]
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return metrics, nil
+}
+
+func (m *MockNVMLDriver) P2PStatusByUUID(_ context.Context, uuid, peerUUID string) (*P2PStatus, error) {
+	m.p2pCalls++
+	if err := m.p2pErrors[[2]string{uuid, peerUUID}]; err != nil {
+		return nil, err
+	}
+	status, ok := m.p2p[[2]string{uuid, peerUUID}]
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return status, nil
+}
+
 func TestGetFingerprintDataFromNVML(t *testing.T) {
 	for _, testCase := range []struct {
 		Name                string
@@ -146,7 +276,8 @@ func TestGetFingerprintDataFromNVML(t *testing.T) {
 			Name:          "successful outcome",
 			ExpectedError: false,
 			ExpectedResult: &FingerprintData{
-				DriverVersion: "driverVersion",
+				DriverVersion:     "driverVersion",
+				CUDADriverVersion: "12.4",
 				Devices: []*FingerprintDeviceData{
 					{
 						DeviceData: &DeviceData{
@@ -156,6 +287,7 @@ func TestGetFingerprintDataFromNVML(t *testing.T) {
 							PowerW:     pointer.Of(uint(100)),
 							BAR1MiB:    pointer.Of(uint64(100)),
 						},
+						Index:              pointer.Of(uint(0)),
 						PCIBusID:           "busId1",
 						PCIBandwidthMBPerS: pointer.Of(uint(100)),
 						CoresClockMHz:      pointer.Of(uint(100)),
@@ -170,6 +302,7 @@ func TestGetFingerprintDataFromNVML(t *testing.T) {
 							PowerW:     pointer.Of(uint(200)),
 							BAR1MiB:    pointer.Of(uint64(200)),
 						},
+						Index:              pointer.Of(uint(1)),
 						PCIBusID:           "busId2",
 						PCIBandwidthMBPerS: pointer.Of(uint(200)),
 						CoresClockMHz:      pointer.Of(uint(200)),
@@ -184,6 +317,7 @@ func TestGetFingerprintDataFromNVML(t *testing.T) {
 				listDeviceUUIDsSuccessful:      true,
 				deviceInfoByUUIDCallSuccessful: true,
 				driverVersion:                  "driverVersion",
+				cudaDriverVersion:              "12.4",
 				modes:                          []mode{normal, normal},
 				devices: []*DeviceInfo{
 					{
@@ -228,6 +362,7 @@ func TestGetFingerprintDataFromNVML(t *testing.T) {
 							PowerW:     pointer.Of(uint(100)),
 							BAR1MiB:    pointer.Of(uint64(100)),
 						},
+						Index:              pointer.Of(uint(0)),
 						PCIBusID:           "busId1",
 						PCIBandwidthMBPerS: pointer.Of(uint(100)),
 						CoresClockMHz:      pointer.Of(uint(100)),
@@ -243,6 +378,7 @@ func TestGetFingerprintDataFromNVML(t *testing.T) {
 							PowerW:     pointer.Of(uint(200)),
 							BAR1MiB:    pointer.Of(uint64(200)),
 						},
+						Index:              pointer.Of(uint(1)),
 						PCIBusID:           "busId2",
 						PCIBandwidthMBPerS: pointer.Of(uint(200)),
 						CoresClockMHz:      pointer.Of(uint(200)),
@@ -258,12 +394,33 @@ func TestGetFingerprintDataFromNVML(t *testing.T) {
 							PowerW:     pointer.Of(uint(200)),
 							BAR1MiB:    pointer.Of(uint64(200)),
 						},
+						Index:              pointer.Of(uint(2)),
+						ParentUUID:         "UUID3",
+						PCIBusID:           "busId3",
+						PCIBandwidthMBPerS: pointer.Of(uint(200)),
+						CoresClockMHz:      pointer.Of(uint(200)),
+						MemoryClockMHz:     pointer.Of(uint(200)),
+						DisplayState:       "Enabled",
+						PersistenceMode:    "Enabled",
+					},
+				},
+				MIGParents: []*FingerprintDeviceData{
+					{
+						DeviceData: &DeviceData{
+							DeviceName: pointer.Of("ModelName"),
+							UUID:       "UUID3",
+							MemoryMiB:  pointer.Of(uint64(8)),
+							PowerW:     pointer.Of(uint(200)),
+							BAR1MiB:    pointer.Of(uint64(200)),
+						},
+						Index:              pointer.Of(uint(2)),
 						PCIBusID:           "busId3",
 						PCIBandwidthMBPerS: pointer.Of(uint(200)),
 						CoresClockMHz:      pointer.Of(uint(200)),
 						MemoryClockMHz:     pointer.Of(uint(200)),
 						DisplayState:       "Enabled",
 						PersistenceMode:    "Enabled",
+						MIGParent:          true,
 					},
 				},
 			},
@@ -364,9 +521,18 @@ func TestGetStatsDataFromNVML(t *testing.T) {
 			},
 		},
 		{
-			Name:           "fail on DeviceInfoAndStatusByUUID call",
-			ExpectedError:  true,
-			ExpectedResult: nil,
+			Name:          "fail on DeviceInfoAndStatusByUUID call",
+			ExpectedError: false,
+			ExpectedResult: []*StatsData{
+				{
+					DeviceData: &DeviceData{UUID: "UUID1"},
+					Error:      "nvidia nvml DeviceInfoAndStatusByUUID() error: failed to get device info and status by index",
+				},
+				{
+					DeviceData: &DeviceData{UUID: "UUID2"},
+					Error:      "nvidia nvml DeviceInfoAndStatusByUUID() error: failed to get device info and status by index",
+				},
+			},
 			DriverConfiguration: &MockNVMLDriver{
 				systemDriverCallSuccessful:              true,
 				listDeviceUUIDsSuccessful:               true,
@@ -665,6 +831,392 @@ func TestGetStatsDataFromNVML(t *testing.T) {
 		if !testCase.ExpectedError && err != nil {
 			must.NoError(t, err)
 		}
+		// query durations vary between runs
+		for _, statsItem := range statsData {
+			statsItem.QueryDuration = 0
+		}
 		must.Eq(t, testCase.ExpectedResult, statsData)
 	}
 }
+
+func TestGetStatsDataPowerMilliwatts(t *testing.T) {
+	client := &nvmlClient{driver: &MockNVMLDriver{
+		listDeviceUUIDsSuccessful:               true,
+		deviceInfoAndStatusByUUIDCallSuccessful: true,
+		modes:                                   []mode{normal},
+		devices: []*DeviceInfo{
+			{
+				UUID:    "UUID1",
+				PowerW:  pointer.Of(uint(300)),
+				PowerMW: pointer.Of(uint(300000)),
+			},
+		},
+		deviceStatus: []*DeviceStatus{
+			{
+				PowerUsageW:  pointer.Of(uint(71)),
+				PowerUsageMW: pointer.Of(uint(71845)),
+			},
+		},
+	}}
+
+	statsData, err := client.GetStatsData()
+	must.NoError(t, err)
+	must.Len(t, 1, statsData)
+
+	// both precisions are reported so that consumers can choose
+	must.Eq(t, uint(300), *statsData[0].PowerW)
+	must.Eq(t, uint(300000), *statsData[0].PowerMW)
+	must.Eq(t, uint(71), *statsData[0].PowerUsageW)
+	must.Eq(t, uint(71845), *statsData[0].PowerUsageMW)
+}
+
+func TestGetStatsDataUtilizationSampling(t *testing.T) {
+	driver := &MockNVMLDriver{
+		listDeviceUUIDsSuccessful:               true,
+		deviceInfoAndStatusByUUIDCallSuccessful: true,
+		modes:                                   []mode{normal},
+		devices:                                 []*DeviceInfo{{UUID: "UUID1"}},
+		deviceStatus:                            []*DeviceStatus{{GPUUtilization: pointer.Of(uint(90))}},
+		utilizationSamples:                      map[string][]uint{"UUID1": {10, 20, 90}},
+	}
+	client := &nvmlClient{driver: driver}
+
+	// samples are not queried unless enabled
+	statsData, err := client.GetStatsData()
+	must.NoError(t, err)
+	must.Nil(t, statsData[0].GPUUtilizationAverage)
+	must.Nil(t, statsData[0].GPUUtilizationMax)
+
+	client.SetUtilizationSampling(true)
+	statsData, err = client.GetStatsData()
+	must.NoError(t, err)
+	must.Eq(t, uint(40), *statsData[0].GPUUtilizationAverage)
+	must.Eq(t, uint(90), *statsData[0].GPUUtilizationMax)
+
+	// only samples taken since the previous query are summarized
+	driver.utilizationSamples["UUID1"] = append(driver.utilizationSamples["UUID1"], 50, 51)
+	statsData, err = client.GetStatsData()
+	must.NoError(t, err)
+	must.Eq(t, uint(51), *statsData[0].GPUUtilizationAverage)
+	must.Eq(t, uint(51), *statsData[0].GPUUtilizationMax)
+
+	statsData, err = client.GetStatsData()
+	must.NoError(t, err)
+	must.Nil(t, statsData[0].GPUUtilizationAverage)
+}
+
+func TestGetStatsDataGPMMetrics(t *testing.T) {
+	metrics := &GPMMetrics{
+		SMActivity:     pointer.Of(75.5),
+		TensorActivity: pointer.Of(40.0),
+		DRAMBandwidth:  pointer.Of(62.5),
+	}
+	driver := &MockNVMLDriver{
+		listDeviceUUIDsSuccessful:               true,
+		deviceInfoAndStatusByUUIDCallSuccessful: true,
+		modes:                                   []mode{normal, normal},
+		devices:                                 []*DeviceInfo{{UUID: "UUID1"}, {UUID: "UUID2"}},
+		deviceStatus:                            []*DeviceStatus{{}, {}},
+		gpmMetrics:                              map[string]*GPMMetrics{"UUID1": metrics},
+	}
+	client := &nvmlClient{driver: driver}
+
+	// metrics are not queried unless enabled
+	statsData, err := client.GetStatsData()
+	must.NoError(t, err)
+	must.Nil(t, statsData[0].GPM)
+
+	// devices that do not support GPM have no metrics
+	client.SetGPMMetrics(true)
+	statsData, err = client.GetStatsData()
+	must.NoError(t, err)
+	must.Eq(t, metrics, statsData[0].GPM)
+	must.Nil(t, statsData[1].GPM)
+	must.Eq(t, "", statsData[1].Error)
+}
+
+func TestAveragePowerUsage(t *testing.T) {
+	client := &nvmlClient{}
+	start := time.Now()
+
+	// the first reading has nothing to average against
+	must.Nil(t, client.averagePowerUsage("UUID1", pointer.Of(uint64(1000000)), start))
+
+	average := client.averagePowerUsage("UUID1", pointer.Of(uint64(1300000)), start.Add(2*time.Second))
+	must.Eq(t, pointer.Of(uint(150000)), average)
+
+	// a driver reload resets the counter
+	must.Nil(t, client.averagePowerUsage("UUID1", pointer.Of(uint64(500)), start.Add(4*time.Second)))
+	average = client.averagePowerUsage("UUID1", pointer.Of(uint64(100500)), start.Add(5*time.Second))
+	must.Eq(t, pointer.Of(uint(100000)), average)
+
+	must.Nil(t, client.averagePowerUsage("UUID1", nil, start.Add(6*time.Second)))
+	must.Nil(t, client.averagePowerUsage("UUID1", pointer.Of(uint64(200500)), start.Add(7*time.Second)))
+}
+
+func TestNVLinkThroughput(t *testing.T) {
+	client := &nvmlClient{}
+	start := time.Now()
+
+	// transmit and receive counters are tracked separately
+	must.Nil(t, client.nvlinkThroughput("UUID1/tx", pointer.Of(uint64(4096)), start))
+	must.Nil(t, client.nvlinkThroughput("UUID1/rx", pointer.Of(uint64(0)), start))
+
+	throughput := client.nvlinkThroughput("UUID1/tx", pointer.Of(uint64(45056)), start.Add(10*time.Second))
+	must.Eq(t, pointer.Of(uint64(4096)), throughput)
+	throughput = client.nvlinkThroughput("UUID1/rx", pointer.Of(uint64(20480)), start.Add(10*time.Second))
+	must.Eq(t, pointer.Of(uint64(2048)), throughput)
+
+	// a driver reload resets the counters
+	must.Nil(t, client.nvlinkThroughput("UUID1/tx", pointer.Of(uint64(1024)), start.Add(20*time.Second)))
+	must.Nil(t, client.nvlinkThroughput("UUID1/tx", nil, start.Add(30*time.Second)))
+}
+
+func TestGetStatsDataHistograms(t *testing.T) {
+	driver := &MockNVMLDriver{
+		listDeviceUUIDsSuccessful:               true,
+		deviceInfoAndStatusByUUIDCallSuccessful: true,
+		modes:                                   []mode{normal},
+		devices:                                 []*DeviceInfo{{UUID: "UUID1"}},
+		deviceStatus: []*DeviceStatus{{
+			GPUUtilization: pointer.Of(uint(30)),
+			PowerUsageMW:   pointer.Of(uint(100000)),
+			TemperatureC:   pointer.Of(uint(50)),
+		}},
+		samples: map[string][]*DeviceSample{"UUID1": {
+			{GPUUtilization: pointer.Of(uint(10)), PowerUsageMW: pointer.Of(uint(90000)), TemperatureC: pointer.Of(uint(45))},
+			{GPUUtilization: pointer.Of(uint(100)), PowerUsageMW: pointer.Of(uint(300000)), TemperatureC: pointer.Of(uint(80))},
+			{GPUUtilization: pointer.Of(uint(20)), PowerUsageMW: pointer.Of(uint(95000))},
+		}},
+	}
+	client := &nvmlClient{driver: driver}
+
+	// histograms are not reported unless stats are sampled
+	statsData, err := client.GetStatsData()
+	must.NoError(t, err)
+	must.Nil(t, statsData[0].GPUUtilizationHistogram)
+
+	for range 3 {
+		must.NoError(t, client.SampleStats())
+	}
+	statsData, err = client.GetStatsData()
+	must.NoError(t, err)
+	must.Eq(t, &Histogram{P50: 20, P95: 100, Max: 100}, statsData[0].GPUUtilizationHistogram)
+	must.Eq(t, &Histogram{P50: 95000, P95: 300000, Max: 300000}, statsData[0].PowerUsageMWHistogram)
+	must.Eq(t, &Histogram{P50: 50, P95: 80, Max: 80}, statsData[0].TemperatureCHistogram)
+
+	// only the reading of the query is left once samples were reported
+	statsData, err = client.GetStatsData()
+	must.NoError(t, err)
+	must.Eq(t, &Histogram{P50: 30, P95: 30, Max: 30}, statsData[0].GPUUtilizationHistogram)
+}
+
+func TestHistogram(t *testing.T) {
+	must.Nil(t, histogram(nil))
+
+	samples := make([]uint, 0, 100)
+	for i := 100; i > 0; i-- {
+		samples = append(samples, uint(i))
+	}
+	must.Eq(t, &Histogram{P50: 50, P95: 95, Max: 100}, histogram(samples))
+	must.Eq(t, uint(100), samples[0])
+}
+
+func TestResetDevice(t *testing.T) {
+	driver := &MockNVMLDriver{}
+	client := &nvmlClient{driver: driver}
+
+	must.NoError(t, client.ResetDevice("UUID1", false))
+	must.NoError(t, client.ResetDevice("UUID2", true))
+	must.Eq(t, []string{"clocks:UUID1", "full:UUID2"}, driver.resetCalls)
+}
+
+func TestGetComputeProcesses(t *testing.T) {
+	client := &nvmlClient{driver: &MockNVMLDriver{
+		processes: map[string][]int{"UUID1": {1234}},
+	}}
+
+	pids, err := client.GetComputeProcesses("UUID1")
+	must.NoError(t, err)
+	must.Eq(t, []int{1234}, pids)
+}
+
+func TestGetFingerprintDataEncoderCapacity(t *testing.T) {
+	info := &DeviceInfo{
+		UUID:                "UUID1",
+		Name:                pointer.Of("ModelName1"),
+		EncoderCapacityH264: pointer.Of(uint(100)),
+	}
+	client := &nvmlClient{driver: &MockNVMLDriver{
+		systemDriverCallSuccessful:     true,
+		listDeviceUUIDsSuccessful:      true,
+		deviceInfoByUUIDCallSuccessful: true,
+		devices:                        []*DeviceInfo{info},
+		modes:                          []mode{normal},
+	}}
+
+	fingerprintData, err := client.GetFingerprintData()
+	must.NoError(t, err)
+	must.Eq(t, uint(100), *fingerprintData.Devices[0].EncoderCapacityH264)
+	must.Nil(t, fingerprintData.Devices[0].EncoderCapacityHEVC)
+
+	// running encoder sessions lower the capacity reported by nvml, the
+	// highest capacity seen is kept
+	info.EncoderCapacityH264 = pointer.Of(uint(40))
+	fingerprintData, err = client.GetFingerprintData()
+	must.NoError(t, err)
+	must.Eq(t, uint(100), *fingerprintData.Devices[0].EncoderCapacityH264)
+}
+
+func TestGetFingerprintDataCircuitBreaker(t *testing.T) {
+	driver := &MockNVMLDriver{
+		systemDriverCallSuccessful:              true,
+		listDeviceUUIDsSuccessful:               true,
+		deviceInfoByUUIDCallSuccessful:          true,
+		deviceInfoAndStatusByUUIDCallSuccessful: true,
+		devices: []*DeviceInfo{
+			{UUID: "UUID1", Name: pointer.Of("ModelName1")},
+		},
+		deviceStatus: []*DeviceStatus{{}},
+		modes:        []mode{normal},
+	}
+	client := &nvmlClient{driver: driver}
+	client.SetBreakerConfig(BreakerConfig{Threshold: 2, Cooldown: time.Hour})
+
+	_, err := client.GetFingerprintData()
+	must.NoError(t, err)
+
+	// failures below the threshold fail the fingerprint
+	driver.deviceInfoByUUIDCallSuccessful = false
+	_, err = client.GetFingerprintData()
+	must.Error(t, err)
+
+	// the device is reported with its last data once the breaker opens
+	fingerprintData, err := client.GetFingerprintData()
+	must.NoError(t, err)
+	must.Len(t, 1, fingerprintData.Devices)
+	must.Eq(t, "UUID1", fingerprintData.Devices[0].UUID)
+	must.MapContainsKey(t, fingerprintData.FailingDevices, "UUID1")
+
+	// devices whose breaker is open have no stats
+	stats, err := client.GetStatsData()
+	must.NoError(t, err)
+	must.SliceEmpty(t, stats)
+}
+
+func TestGetFingerprintDataQuarantine(t *testing.T) {
+	driver := &MockNVMLDriver{
+		systemDriverCallSuccessful:              true,
+		listDeviceUUIDsSuccessful:               true,
+		deviceInfoByUUIDCallSuccessful:          true,
+		deviceInfoAndStatusByUUIDCallSuccessful: true,
+		devices: []*DeviceInfo{
+			{UUID: "UUID1", Name: pointer.Of("ModelName1")},
+		},
+		deviceStatus: []*DeviceStatus{{}},
+		modes:        []mode{normal},
+	}
+	client := &nvmlClient{driver: driver}
+	client.SetBreakerConfig(BreakerConfig{QuarantineThreshold: 2, QuarantineWindow: time.Hour})
+
+	_, err := client.GetFingerprintData()
+	must.NoError(t, err)
+
+	// unresponsive devices keep their last data without failing the
+	// fingerprint
+	driver.deviceInfoByUUIDCallSuccessful = false
+	driver.deviceInfoByUUIDErr = ErrCallTimeout
+	fingerprintData, err := client.GetFingerprintData()
+	must.NoError(t, err)
+	must.Len(t, 1, fingerprintData.Devices)
+	must.MapNotContainsKey(t, fingerprintData.FailingDevices, "UUID1")
+
+	fingerprintData, err = client.GetFingerprintData()
+	must.NoError(t, err)
+	must.Len(t, 1, fingerprintData.Devices)
+	must.StrContains(t, fingerprintData.FailingDevices["UUID1"], "quarantined")
+
+	// quarantined devices are neither queried again nor have stats
+	driver.deviceInfoByUUIDCallSuccessful = true
+	fingerprintData, err = client.GetFingerprintData()
+	must.NoError(t, err)
+	must.MapContainsKey(t, fingerprintData.FailingDevices, "UUID1")
+
+	stats, err := client.GetStatsData()
+	must.NoError(t, err)
+	must.SliceEmpty(t, stats)
+}
+
+func TestGetFingerprintDataP2P(t *testing.T) {
+	driver := &MockNVMLDriver{
+		systemDriverCallSuccessful:              true,
+		listDeviceUUIDsSuccessful:               true,
+		deviceInfoByUUIDCallSuccessful:          true,
+		deviceInfoAndStatusByUUIDCallSuccessful: true,
+		devices: []*DeviceInfo{
+			{UUID: "UUID1", Name: pointer.Of("ModelName1")},
+			{UUID: "UUID2", Name: pointer.Of("ModelName1")},
+			{UUID: "UUID3", Name: pointer.Of("ModelName1")},
+			{UUID: "UUID4", Name: pointer.Of("ModelName1")},
+		},
+		deviceStatus: []*DeviceStatus{{}, {}, {}, {}},
+		modes:        []mode{normal, normal, normal, mig},
+		p2p: map[[2]string]*P2PStatus{
+			{"UUID1", "UUID2"}: {UUID: "UUID1", PeerUUID: "UUID2", Read: true, Write: true, NVLink: true},
+			{"UUID1", "UUID3"}: {UUID: "UUID1", PeerUUID: "UUID3", Read: true},
+		},
+		p2pErrors: map[[2]string]error{
+			{"UUID2", "UUID3"}: ErrCallTimeout,
+		},
+	}
+	client := &nvmlClient{driver: driver}
+
+	// MIG devices are not queried, pairs that could not be queried are left
+	// out
+	fingerprintData, err := client.GetFingerprintData()
+	must.NoError(t, err)
+	must.Eq(t, []*P2PStatus{driver.p2p[[2]string{"UUID1", "UUID2"}], driver.p2p[[2]string{"UUID1", "UUID3"}]}, fingerprintData.P2P)
+	must.Eq(t, 3, driver.p2pCalls)
+
+	// incomplete capabilities are queried again
+	delete(driver.p2pErrors, [2]string{"UUID2", "UUID3"})
+	fingerprintData, err = client.GetFingerprintData()
+	must.NoError(t, err)
+	must.Len(t, 2, fingerprintData.P2P)
+	must.Eq(t, 6, driver.p2pCalls)
+
+	// complete capabilities are kept while the GPUs do not change
+	fingerprintData, err = client.GetFingerprintData()
+	must.NoError(t, err)
+	must.Len(t, 2, fingerprintData.P2P)
+	must.Eq(t, 6, driver.p2pCalls)
+}
+
+func TestIsPermanent(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Err      error
+		Expected bool
+	}{
+		{
+			Name:     "library not found",
+			Err:      UnavailableLib,
+			Expected: true,
+		},
+		{
+			Name:     "configured library not found",
+			Err:      &permanentError{errors.New(`could not load NVML library "/opt/libnvidia-ml.so.1"`)},
+			Expected: true,
+		},
+		{
+			Name:     "initialization failure",
+			Err:      errors.New("failed to initialize: Unknown Error"),
+			Expected: false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			must.Eq(t, c.Expected, IsPermanent(c.Err))
+		})
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build linux
+
+package nvml
+
+import (
+	"context"
+	"time"
+)
+
+// The methods below implement NvmlDriver by running the NVML queries of the
+// driver with callDriver, so that a query hung in the NVIDIA driver is
+// abandoned once the deadline of its context passes.
+
+// callDriver runs the query of the given operation with callPending and logs
+// it, along with the UUID of the device it queries if any. Queries of a device,
+// or of the driver itself, fail fast while an abandoned query of theirs is
+// still hung in the NVIDIA driver.
+func callDriver[T any](ctx context.Context, n *nvmlDriver, operation, uuid string, call func() (T, error)) (T, error) {
+	return callDriverAbandoned(ctx, n, operation, uuid, call, nil)
+}
+
+// callDriverAbandoned is callDriver passing the results of a query completing
+// after being abandoned to abandoned
+func callDriverAbandoned[T any](ctx context.Context, n *nvmlDriver, operation, uuid string, call func() (T, error), abandoned func(T, error)) (T, error) {
+	start := time.Now()
+	value, err := callPending(ctx, &n.pending, uuid, call, abandoned)
+	n.logCall(operation, uuid, time.Since(start), err)
+	return value, err
+}
+
+// runDriver is callDriver for queries that only return an error
+func runDriver(ctx context.Context, n *nvmlDriver, operation, uuid string, call func() error) error {
+	_, err := callDriver(ctx, n, operation, uuid, func() (struct{}, error) {
+		return struct{}{}, call()
+	})
+	return err
+}
+
+// Initialize loads the NVML library. An initialization completing after it
+// was abandoned is undone, as the caller considers it failed and retries it.
+func (n *nvmlDriver) Initialize(ctx context.Context) error {
+	_, err := callDriverAbandoned(ctx, n, "Initialize", "", func() (struct{}, error) {
+		return struct{}{}, n.initialize()
+	}, func(_ struct{}, err error) {
+		if err != nil {
+			return
+		}
+		if err := n.shutdown(); err != nil && n.logger != nil {
+			n.logger.Warn("failed to shutdown NVML after abandoned initialization", "error", err)
+		}
+	})
+	return err
+}
+
+// Shutdown stops any further interaction with nvml
+func (n *nvmlDriver) Shutdown(ctx context.Context) error {
+	return runDriver(ctx, n, "Shutdown", "", n.shutdown)
+}
+
+// SystemDriverVersion returns installed driver version
+func (n *nvmlDriver) SystemDriverVersion(ctx context.Context) (string, error) {
+	return callDriver(ctx, n, "SystemDriverVersion", "", n.systemDriverVersion)
+}
+
+// SystemNVMLVersion returns the version of the loaded NVML library
+func (n *nvmlDriver) SystemNVMLVersion(ctx context.Context) (string, error) {
+	return callDriver(ctx, n, "SystemNVMLVersion", "", n.systemNVMLVersion)
+}
+
+// SystemCUDADriverVersion returns the highest CUDA version supported by the
+// installed driver
+func (n *nvmlDriver) SystemCUDADriverVersion(ctx context.Context) (string, error) {
+	return callDriver(ctx, n, "SystemCUDADriverVersion", "", n.systemCUDADriverVersion)
+}
+
+// ListDeviceUUIDs lists all compute device UUIDs in the system
+func (n *nvmlDriver) ListDeviceUUIDs(ctx context.Context) ([]DeviceIdentity, error) {
+	return callDriver(ctx, n, "ListDeviceUUIDs", "", n.listDeviceUUIDs)
+}
+
+// DeviceInfoByUUID returns DeviceInfo for the given GPU's UUID
+func (n *nvmlDriver) DeviceInfoByUUID(ctx context.Context, uuid string) (*DeviceInfo, error) {
+	return callDriver(ctx, n, "DeviceInfoByUUID", uuid, func() (*DeviceInfo, error) {
+		return n.deviceInfoByUUID(uuid)
+	})
+}
+
+// DeviceInfoAndStatusByUUID returns DeviceInfo and DeviceStatus for the given
+// GPU's UUID
+func (n *nvmlDriver) DeviceInfoAndStatusByUUID(ctx context.Context, uuid string) (*DeviceInfo, *DeviceStatus, error) {
+	type infoAndStatus struct {
+		info   *DeviceInfo
+		status *DeviceStatus
+	}
+	result, err := callDriver(ctx, n, "DeviceInfoAndStatusByUUID", uuid, func() (infoAndStatus, error) {
+		info, status, err := n.deviceInfoAndStatusByUUID(uuid)
+		return infoAndStatus{info: info, status: status}, err
+	})
+	return result.info, result.status, err
+}
+
+// ResetDeviceClocks resets the locked and applications clocks of the GPU
+// matching the given UUID to their defaults
+func (n *nvmlDriver) ResetDeviceClocks(ctx context.Context, uuid string) error {
+	return runDriver(ctx, n, "ResetDeviceClocks", uuid, func() error {
+		return n.resetDeviceClocks(uuid)
+	})
+}
+
+// ResetDevice performs a full reset of the GPU matching the given UUID
+func (n *nvmlDriver) ResetDevice(ctx context.Context, uuid string) error {
+	return runDriver(ctx, n, "ResetDevice", uuid, func() error {
+		return n.resetDevice(ctx, uuid)
+	})
+}
+
+// ComputeProcessesByUUID returns the PIDs of compute processes running on the
+// GPU or MIG instance matching the given UUID
+func (n *nvmlDriver) ComputeProcessesByUUID(ctx context.Context, uuid string) ([]int, error) {
+	return callDriver(ctx, n, "ComputeProcessesByUUID", uuid, func() ([]int, error) {
+		return n.computeProcessesByUUID(uuid)
+	})
+}
+
+// EnableAccountingByUUID enables accounting mode on the GPU matching the
+// given UUID
+func (n *nvmlDriver) EnableAccountingByUUID(ctx context.Context, uuid string) error {
+	return runDriver(ctx, n, "EnableAccountingByUUID", uuid, func() error {
+		return n.enableAccountingByUUID(uuid)
+	})
+}
+
+// AccountingStatsByUUID returns accounting data of the processes that ran on
+// the GPU matching the given UUID
+func (n *nvmlDriver) AccountingStatsByUUID(ctx context.Context, uuid string) ([]*AccountingStats, error) {
+	return callDriver(ctx, n, "AccountingStatsByUUID", uuid, func() ([]*AccountingStats, error) {
+		return n.accountingStatsByUUID(uuid)
+	})
+}
+
+// ClearAccountingByUUID clears the accounting data of the GPU matching the
+// given UUID
+func (n *nvmlDriver) ClearAccountingByUUID(ctx context.Context, uuid string) error {
+	return runDriver(ctx, n, "ClearAccountingByUUID", uuid, func() error {
+		return n.clearAccountingByUUID(uuid)
+	})
+}
+
+// EnergyConsumptionByUUID returns the energy consumed by the GPU matching the
+// given UUID since the driver was last loaded, in millijoules
+func (n *nvmlDriver) EnergyConsumptionByUUID(ctx context.Context, uuid string) (uint64, error) {
+	return callDriver(ctx, n, "EnergyConsumptionByUUID", uuid, func() (uint64, error) {
+		return n.energyConsumptionByUUID(uuid)
+	})
+}
+
+// SampleByUUID returns the GPU utilization, power usage and temperature of
+// the GPU matching the given UUID
+func (n *nvmlDriver) SampleByUUID(ctx context.Context, uuid string) (*DeviceSample, error) {
+	return callDriver(ctx, n, "SampleByUUID", uuid, func() (*DeviceSample, error) {
+		return n.sampleByUUID(uuid)
+	})
+}
+
+// FatalErrorByUUID returns a description of the fatal error the GPU matching
+// the given UUID is in, or an empty string if it has none
+func (n *nvmlDriver) FatalErrorByUUID(ctx context.Context, uuid string) (string, error) {
+	return callDriver(ctx, n, "FatalErrorByUUID", uuid, func() (string, error) {
+		return n.fatalErrorByUUID(uuid)
+	})
+}
+
+// PreflightByUUID runs sanity checks on the device matching the given UUID
+func (n *nvmlDriver) PreflightByUUID(ctx context.Context, uuid string) error {
+	return runDriver(ctx, n, "PreflightByUUID", uuid, func() error {
+		return n.preflightByUUID(uuid)
+	})
+}
+
+// UtilizationSamplesByUUID returns the GPU utilization samples of the GPU
+// matching the given UUID taken after lastSeen, and the timestamp of the
+// latest one
+func (n *nvmlDriver) UtilizationSamplesByUUID(ctx context.Context, uuid string, lastSeen uint64) ([]uint, uint64, error) {
+	type samples struct {
+		values []uint
+		latest uint64
+	}
+	result, err := callDriver(ctx, n, "UtilizationSamplesByUUID", uuid, func() (samples, error) {
+		values, latest, err := n.utilizationSamplesByUUID(uuid, lastSeen)
+		return samples{values: values, latest: latest}, err
+	})
+	return result.values, result.latest, err
+}
+
+// GPMMetricsByUUID returns the GPU Performance Monitoring metrics of the GPU
+// matching the given UUID since the previous call
+func (n *nvmlDriver) GPMMetricsByUUID(ctx context.Context, uuid string) (*GPMMetrics, error) {
+	return callDriver(ctx, n, "GPMMetricsByUUID", uuid, func() (*GPMMetrics, error) {
+		return n.gpmMetricsByUUID(uuid)
+	})
+}
+
+// P2PStatusByUUID returns the peer-to-peer capabilities between the GPUs
+// matching the given UUIDs
+func (n *nvmlDriver) P2PStatusByUUID(ctx context.Context, uuid, peerUUID string) (*P2PStatus, error) {
+	return callDriver(ctx, n, "P2PStatusByUUID", uuid, func() (*P2PStatus, error) {
+		return n.p2pStatusByUUID(uuid, peerUUID)
+	})
+}
@@ -5,32 +5,119 @@
 
 package nvml
 
+import "context"
+
+// gpmSamples holds the GPU Performance Monitoring samples of the devices,
+// which are only taken on Linux
+type gpmSamples struct{}
+
 // Initialize nvml library by locating nvml shared object file and calling ldopen
-func (n *nvmlDriver) Initialize() error {
+func (n *nvmlDriver) Initialize(ctx context.Context) error {
 	return UnavailableLib
 }
 
 // Shutdown stops any further interaction with nvml
-func (n *nvmlDriver) Shutdown() error {
+func (n *nvmlDriver) Shutdown(ctx context.Context) error {
 	return UnavailableLib
 }
 
 // SystemDriverVersion returns installed driver version
-func (n *nvmlDriver) SystemDriverVersion() (string, error) {
+func (n *nvmlDriver) SystemDriverVersion(ctx context.Context) (string, error) {
+	return "", UnavailableLib
+}
+
+// SystemNVMLVersion returns the version of the loaded NVML library
+func (n *nvmlDriver) SystemNVMLVersion(ctx context.Context) (string, error) {
+	return "", UnavailableLib
+}
+
+// SystemCUDADriverVersion returns the highest CUDA version supported by the
+// installed driver
+func (n *nvmlDriver) SystemCUDADriverVersion(ctx context.Context) (string, error) {
 	return "", UnavailableLib
 }
 
 // ListDeviceUUIDs reports number of available GPU devices
-func (n *nvmlDriver) ListDeviceUUIDs() (map[string]mode, error) {
+func (n *nvmlDriver) ListDeviceUUIDs(ctx context.Context) ([]DeviceIdentity, error) {
 	return nil, UnavailableLib
 }
 
 // DeviceInfoByUUID returns DeviceInfo for the GPU matching the given UUID
-func (n *nvmlDriver) DeviceInfoByUUID(uuid string) (*DeviceInfo, error) {
+func (n *nvmlDriver) DeviceInfoByUUID(ctx context.Context, uuid string) (*DeviceInfo, error) {
 	return nil, UnavailableLib
 }
 
 // DeviceInfoAndStatusByUUID returns DeviceInfo and DeviceStatus for the GPU matching the given UUID
-func (n *nvmlDriver) DeviceInfoAndStatusByUUID(uuid string) (*DeviceInfo, *DeviceStatus, error) {
+func (n *nvmlDriver) DeviceInfoAndStatusByUUID(ctx context.Context, uuid string) (*DeviceInfo, *DeviceStatus, error) {
 	return nil, nil, UnavailableLib
 }
+
+// ResetDeviceClocks resets the locked and applications clocks of the GPU matching the given UUID
+func (n *nvmlDriver) ResetDeviceClocks(ctx context.Context, uuid string) error {
+	return UnavailableLib
+}
+
+// ResetDevice performs a full reset of the GPU matching the given UUID
+func (n *nvmlDriver) ResetDevice(ctx context.Context, uuid string) error {
+	return UnavailableLib
+}
+
+// ComputeProcessesByUUID returns the PIDs of compute processes running on the GPU matching the given UUID
+func (n *nvmlDriver) ComputeProcessesByUUID(ctx context.Context, uuid string) ([]int, error) {
+	return nil, UnavailableLib
+}
+
+// EnableAccountingByUUID enables accounting mode on the GPU matching the given UUID
+func (n *nvmlDriver) EnableAccountingByUUID(ctx context.Context, uuid string) error {
+	return UnavailableLib
+}
+
+// AccountingStatsByUUID returns accounting data of processes that ran on the GPU matching the given UUID
+func (n *nvmlDriver) AccountingStatsByUUID(ctx context.Context, uuid string) ([]*AccountingStats, error) {
+	return nil, UnavailableLib
+}
+
+// ClearAccountingByUUID clears accounting data of the GPU matching the given UUID
+func (n *nvmlDriver) ClearAccountingByUUID(ctx context.Context, uuid string) error {
+	return UnavailableLib
+}
+
+// FatalErrorByUUID returns a description of the fatal error the GPU matching the given UUID is in
+func (n *nvmlDriver) FatalErrorByUUID(ctx context.Context, uuid string) (string, error) {
+	return "", UnavailableLib
+}
+
+// PreflightByUUID runs sanity checks on the device matching the given UUID
+func (n *nvmlDriver) PreflightByUUID(ctx context.Context, uuid string) error {
+	return UnavailableLib
+}
+
+// UtilizationSamplesByUUID returns the GPU utilization samples of the device
+// matching the given UUID
+func (n *nvmlDriver) UtilizationSamplesByUUID(ctx context.Context, uuid string, lastSeen uint64) ([]uint, uint64, error) {
+	return nil, 0, UnavailableLib
+}
+
+// EnergyConsumptionByUUID returns the energy consumed by the GPU matching the
+// given UUID
+func (n *nvmlDriver) EnergyConsumptionByUUID(ctx context.Context, uuid string) (uint64, error) {
+	return 0, UnavailableLib
+}
+
+// SampleByUUID returns the GPU utilization, power usage and temperature of
+// the GPU matching the given UUID
+func (n *nvmlDriver) SampleByUUID(ctx context.Context, uuid string) (*DeviceSample, error) {
+	return nil, UnavailableLib
+}
+
+// GPMMetricsByUUID returns the GPU Performance Monitoring metrics of the GPU
+// matching the given UUID since the previous call
+func (n *nvmlDriver) GPMMetricsByUUID(ctx context.Context, uuid string) (*GPMMetrics, error) {
+	return nil, UnavailableLib
+}
+
+// P2PStatusByUUID returns the peer-to-peer capabilities between the GPUs
+// matching the given UUIDs
+func (n *nvmlDriver) P2PStatusByUUID(ctx context.Context, uuid, peerUUID string) (*P2PStatus, error) {
+	return nil, UnavailableLib
+}
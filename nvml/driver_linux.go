@@ -6,33 +6,107 @@
 package nvml
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/hashicorp/go-hclog"
 )
 
 func decode(msg string, code nvml.Return) error {
-	return fmt.Errorf("%s: %s", msg, nvml.ErrorString(code))
+	return &callError{msg: msg, code: code}
 }
 
-// Initialize nvml library by locating nvml shared object file and calling ldopen
-func (n *nvmlDriver) Initialize() error {
-	if code := nvml.Init(); code != nvml.SUCCESS {
+// callError is an error returned by an NVML library call, which keeps the
+// return code of the call so that it can be logged
+type callError struct {
+	msg  string
+	code nvml.Return
+}
+
+func (e *callError) Error() string {
+	return fmt.Sprintf("%s: %s", e.msg, nvml.ErrorString(e.code))
+}
+
+// Is matches NVML timeouts and interrupt issues with errUnresponsive
+func (e *callError) Is(target error) bool {
+	return target == errUnresponsive && (e.code == nvml.ERROR_TIMEOUT || e.code == nvml.ERROR_IRQ_ISSUE)
+}
+
+// logCall logs the NVML call of the given operation, and the UUID of the
+// device it queried if any, at trace level when it succeeded and at debug
+// level when it failed. The return code of failed NVML calls is logged, and
+// calls of an operation on a device failing with the same return code are
+// coalesced.
+func (n *nvmlDriver) logCall(operation, uuid string, duration time.Duration, err error) {
+	failedCalls := n.failedCalls.Load()
+	failedCalls.Flush()
+	if n.logger == nil || (err == nil && !n.logger.IsTrace()) || !n.logger.IsDebug() {
+		return
+	}
+
+	args := []interface{}{"operation", operation, "duration", duration}
+	if uuid != "" {
+		args = append(args, "uuid", uuid)
+	}
+	if err == nil {
+		n.logger.Trace("NVML call succeeded", args...)
+		return
+	}
+	key := operation + "/" + uuid + "/" + err.Error()
+	var callErr *callError
+	if errors.As(err, &callErr) {
+		args = append(args, "code", int32(callErr.code))
+		key = fmt.Sprintf("%s/%s/%d", operation, uuid, int32(callErr.code))
+	}
+	failedCalls.Log(n.logger, hclog.Debug, key, "NVML call failed", append(args, "error", err)...)
+}
+
+// initialize nvml library by locating nvml shared object file and calling ldopen
+func (n *nvmlDriver) initialize() error {
+	if n.libraryPath != "" {
+		if err := nvml.SetLibraryOptions(nvml.WithLibraryPath(n.libraryPath)); err != nil {
+			return fmt.Errorf("failed to set NVML library path %q: %v", n.libraryPath, err)
+		}
+	}
+
+	code := nvml.Init()
+	switch code {
+	case nvml.SUCCESS:
+		return nil
+	case nvml.ERROR_LIBRARY_NOT_FOUND:
+		// An explicitly configured library is expected to exist
+		if n.libraryPath != "" {
+			return &permanentError{fmt.Errorf("could not load NVML library %q", n.libraryPath)}
+		}
+		return UnavailableLib
+	case nvml.ERROR_DRIVER_NOT_LOADED:
+		// The node has no Nvidia driver installed, which is expected on
+		// nodes without GPUs that still deploy the plugin.
+		return UnavailableLib
+	default:
 		return decode("failed to initialize", code)
 	}
-	return nil
 }
 
-// Shutdown stops any further interaction with nvml
-func (n *nvmlDriver) Shutdown() error {
+// shutdown stops any further interaction with nvml
+func (n *nvmlDriver) shutdown() error {
+	n.gpm.free()
 	if code := nvml.Shutdown(); code != nvml.SUCCESS {
 		return decode("failed to shutdown", code)
 	}
 	return nil
 }
 
-// SystemDriverVersion returns installed driver version
-func (n *nvmlDriver) SystemDriverVersion() (string, error) {
+// systemDriverVersion returns installed driver version
+func (n *nvmlDriver) systemDriverVersion() (string, error) {
 	version, code := nvml.SystemGetDriverVersion()
 	if code != nvml.SUCCESS {
 		return "", decode("failed to get system driver version", code)
@@ -40,16 +114,40 @@ func (n *nvmlDriver) SystemDriverVersion() (string, error) {
 	return version, nil
 }
 
-// List all compute device UUIDs in the system.
+// systemNVMLVersion returns the version of the loaded NVML library
+func (n *nvmlDriver) systemNVMLVersion() (string, error) {
+	version, code := nvml.SystemGetNVMLVersion()
+	if code != nvml.SUCCESS {
+		return "", decode("failed to get system nvml version", code)
+	}
+	return version, nil
+}
+
+// systemCUDADriverVersion returns the highest CUDA version supported by the
+// installed driver, such as "12.4", or an empty string if the driver does not
+// report it
+func (n *nvmlDriver) systemCUDADriverVersion() (string, error) {
+	version, code := nvml.SystemGetCudaDriverVersion_v2()
+	switch code {
+	case nvml.SUCCESS:
+	case nvml.ERROR_NOT_SUPPORTED:
+		return "", nil
+	default:
+		return "", decode("failed to get system cuda driver version", code)
+	}
+	return fmt.Sprintf("%d.%d", version/1000, version%1000/10), nil
+}
+
+// listDeviceUUIDs lists all compute device UUIDs in the system, ordered by nvml index.
 // Includes all instances, including normal GPUs, MIGs, and their physical parents.
 // Each UUID is associated with a mode indication which type it is.
-func (n *nvmlDriver) ListDeviceUUIDs() (map[string]mode, error) {
+func (n *nvmlDriver) listDeviceUUIDs() ([]DeviceIdentity, error) {
 	count, code := nvml.DeviceGetCount()
 	if code != nvml.SUCCESS {
 		return nil, decode("failed to get device count", code)
 	}
 
-	uuids := make(map[string]mode)
+	var identities []DeviceIdentity
 
 	for i := 0; i < int(count); i++ {
 		device, code := nvml.DeviceGetHandleByIndex(int(i))
@@ -67,8 +165,16 @@ func (n *nvmlDriver) ListDeviceUUIDs() (map[string]mode, error) {
 			if code != nvml.SUCCESS {
 				return nil, decode("failed to get device %d uuid", code)
 			}
+			uuid, err := parseUUID(uuid)
+			if err != nil {
+				return nil, fmt.Errorf("device %d: %v", i, err)
+			}
 
-			uuids[uuid] = normal
+			identities = append(identities, DeviceIdentity{
+				UUID:  uuid,
+				Index: uint(i),
+				Mode:  normal,
+			})
 			continue
 		}
 		if code != nvml.SUCCESS {
@@ -80,9 +186,17 @@ func (n *nvmlDriver) ListDeviceUUIDs() (map[string]mode, error) {
 			return nil, decode("failed to get device MIG device count", code)
 		}
 
-		uuid, code := nvml.DeviceGetUUID(device)
+		parentUUID, code := nvml.DeviceGetUUID(device)
 		if code == nvml.SUCCESS {
-			uuids[uuid] = parent
+			parentUUID, err := parseUUID(parentUUID)
+			if err != nil {
+				return nil, fmt.Errorf("device %d: %v", i, err)
+			}
+			identities = append(identities, DeviceIdentity{
+				UUID:  parentUUID,
+				Index: uint(i),
+				Mode:  parent,
+			})
 		}
 
 		for j := 0; j < int(migCount); j++ {
@@ -98,19 +212,32 @@ func (n *nvmlDriver) ListDeviceUUIDs() (map[string]mode, error) {
 			if code != nvml.SUCCESS {
 				return nil, decode(fmt.Sprintf("failed to get mig device uuid %d", j), code)
 			}
-			uuids[uuid] = mig
+			uuid, err := parseUUID(uuid)
+			if err != nil {
+				return nil, fmt.Errorf("device %d: mig device %d: %v", i, j, err)
+			}
+			identities = append(identities, DeviceIdentity{
+				UUID:       uuid,
+				Index:      uint(i),
+				Mode:       mig,
+				ParentUUID: parentUUID,
+			})
 		}
 	}
 
-	return uuids, nil
+	return identities, nil
+}
+
+func pointerOf[T any](v T) *T {
+	return &v
 }
 
 func bytesToMegabytes(size uint64) uint64 {
 	return size / (1 << 20)
 }
 
-// DeviceInfoByUUID returns DeviceInfo for the given GPU's UUID.
-func (n *nvmlDriver) DeviceInfoByUUID(uuid string) (*DeviceInfo, error) {
+// deviceInfoByUUID returns DeviceInfo for the given GPU's UUID.
+func (n *nvmlDriver) deviceInfoByUUID(uuid string) (*DeviceInfo, error) {
 	device, code := nvml.DeviceGetHandleByUUID(uuid)
 	if code != nvml.SUCCESS {
 		return nil, decode("failed to get device handle", code)
@@ -133,12 +260,23 @@ func (n *nvmlDriver) DeviceInfoByUUID(uuid string) (*DeviceInfo, error) {
 	} else if code != nvml.SUCCESS {
 		return nil, decode("failed to get device parent device handle", code)
 	} else {
+		// The memory of MIG devices is the framebuffer size of their GPU
+		// instance profile, which the memory info does not match on all
+		// drivers.
+		instanceMemory, err := migInstanceMemoryMiB(device, parentDevice)
+		if err != nil {
+			return nil, err
+		}
+		if instanceMemory != nil {
+			memoryTotal = *instanceMemory
+		}
+
 		// Device is a MIG device, and get the auxilary properties (such as PCIE
 		// bandwidth) from the parent device.
 		device = parentDevice
 	}
 
-	power, code := nvml.DeviceGetPowerUsage(device)
+	power, code := nvml.DeviceGetPowerManagementLimit(device)
 	if code != nvml.SUCCESS {
 		if code == nvml.ERROR_NOT_SUPPORTED {
 			power = 0
@@ -146,7 +284,8 @@ func (n *nvmlDriver) DeviceInfoByUUID(uuid string) (*DeviceInfo, error) {
 			return nil, decode("failed to get device power info", code)
 		}
 	}
-	powerU := uint(power) / 1000
+	powerMW := uint(power)
+	powerU := powerMW / 1000
 
 	bar1, code := nvml.DeviceGetBAR1MemoryInfo(device)
 	if code != nvml.SUCCESS {
@@ -190,20 +329,45 @@ func (n *nvmlDriver) DeviceInfoByUUID(uuid string) (*DeviceInfo, error) {
 		bandwidth = uint(linkWidth) * (1 << 10)
 	}
 
-	busID := buildID(pci.BusId)
+	// a malformed bus ID is left empty rather than failing the device, it
+	// only describes its locality
+	busID, _ := parsePCIBusID(cString(pci.BusId[:]))
+
+	var linkGenerationU, linkWidthU *uint
+	if linkGeneration != 0 && linkWidth != 0 {
+		linkGenerationU = pointerOf(uint(linkGeneration))
+		linkWidthU = pointerOf(uint(linkWidth))
+	}
 
-	coreClock, code := nvml.DeviceGetClockInfo(device, nvml.CLOCK_GRAPHICS)
+	// Maximum clocks are used rather than current clocks, which change with
+	// load and would otherwise cause a new fingerprint on every period.
+	coreClock, code := nvml.DeviceGetMaxClockInfo(device, nvml.CLOCK_GRAPHICS)
 	if code != nvml.SUCCESS {
 		return nil, decode("failed to get device core clock", code)
 	}
 	coreClockU := uint(coreClock)
 
-	memClock, code := nvml.DeviceGetClockInfo(device, nvml.CLOCK_MEM)
+	memClock, code := nvml.DeviceGetMaxClockInfo(device, nvml.CLOCK_MEM)
 	if code != nvml.SUCCESS {
 		return nil, decode("failed to get device mem clock", code)
 	}
 	memClockU := uint(memClock)
 
+	var appCoreClockU, appMemClockU *uint
+	appCoreClock, code := nvml.DeviceGetApplicationsClock(device, nvml.CLOCK_GRAPHICS)
+	if code == nvml.SUCCESS {
+		appCoreClockU = pointerOf(uint(appCoreClock))
+	} else if code != nvml.ERROR_NOT_SUPPORTED {
+		return nil, decode("failed to get device applications core clock", code)
+	}
+
+	appMemClock, code := nvml.DeviceGetApplicationsClock(device, nvml.CLOCK_MEM)
+	if code == nvml.SUCCESS {
+		appMemClockU = pointerOf(uint(appMemClock))
+	} else if code != nvml.ERROR_NOT_SUPPORTED {
+		return nil, decode("failed to get device applications mem clock", code)
+	}
+
 	mode, code := nvml.DeviceGetDisplayMode(device)
 	if code != nvml.SUCCESS {
 		return nil, decode("failed to get device display mode", code)
@@ -214,32 +378,660 @@ func (n *nvmlDriver) DeviceInfoByUUID(uuid string) (*DeviceInfo, error) {
 		return nil, decode("failed to get device persistence mode", code)
 	}
 
-	return &DeviceInfo{
+	// the attributes below are optional, a failure to query one of them is
+	// logged and leaves it unset rather than failing the device, which would
+	// fail the fingerprint of every device of the node
+	migProfiles, err := migProfiles(device)
+	n.optionalAttribute(uuid, err)
+	encoderH264, err := encoderCapacity(device, nvml.ENCODER_QUERY_H264)
+	n.optionalAttribute(uuid, err)
+	encoderHEVC, err := encoderCapacity(device, nvml.ENCODER_QUERY_HEVC)
+	n.optionalAttribute(uuid, err)
+	encoderAV1, err := encoderCapacity(device, nvml.ENCODER_QUERY_AV1)
+	n.optionalAttribute(uuid, err)
+	gspMode, gspVersion, err := gspFirmware(device)
+	n.optionalAttribute(uuid, err)
+	inforomOEM, err := inforomVersion(device, nvml.INFOROM_OEM)
+	n.optionalAttribute(uuid, err)
+	inforomECC, err := inforomVersion(device, nvml.INFOROM_ECC)
+	n.optionalAttribute(uuid, err)
+	inforomPower, err := inforomVersion(device, nvml.INFOROM_POWER)
+	n.optionalAttribute(uuid, err)
+	inforomCorrupted, err := inforomCorrupted(device)
+	n.optionalAttribute(uuid, err)
+	operationMode, err := operationMode(device)
+	n.optionalAttribute(uuid, err)
+	virtualizationMode, err := virtualizationMode(device)
+	n.optionalAttribute(uuid, err)
+	brand, err := brand(device)
+	n.optionalAttribute(uuid, err)
+	architecture, err := architecture(device)
+	n.optionalAttribute(uuid, err)
+	computeCapability, err := computeCapability(device)
+	n.optionalAttribute(uuid, err)
+
+	info := &DeviceInfo{
 		UUID:               uuid,
 		Name:               &name,
 		MemoryMiB:          &memoryTotal,
 		PowerW:             &powerU,
+		PowerMW:            &powerMW,
 		BAR1MiB:            &bar1total,
 		PCIBandwidthMBPerS: &bandwidth,
 		PCIBusID:           busID,
+		PCIDeviceID:        &pci.PciDeviceId,
+		PCISubsystemID:     &pci.PciSubSystemId,
+		PCILinkGeneration:  linkGenerationU,
+		PCILinkWidth:       linkWidthU,
 		CoresClockMHz:      &coreClockU,
 		MemoryClockMHz:     &memClockU,
-		DisplayState:       fmt.Sprintf("%v", mode),
-		PersistenceMode:    fmt.Sprintf("%v", persistence),
-	}, nil
+		DisplayState:       enableState(mode),
+		PersistenceMode:    enableState(persistence),
+
+		ApplicationCoresClockMHz:  appCoreClockU,
+		ApplicationMemoryClockMHz: appMemClockU,
+		MIGProfiles:               migProfiles,
+		EncoderCapacityH264:       encoderH264,
+		EncoderCapacityHEVC:       encoderHEVC,
+		EncoderCapacityAV1:        encoderAV1,
+		GSPFirmwareMode:           gspMode,
+		GSPFirmwareVersion:        gspVersion,
+		InfoROMVersionOEM:         inforomOEM,
+		InfoROMVersionECC:         inforomECC,
+		InfoROMVersionPower:       inforomPower,
+		InfoROMCorrupted:          inforomCorrupted,
+		OperationMode:             operationMode,
+		VirtualizationMode:        virtualizationMode,
+		Brand:                     brand,
+		Architecture:              architecture,
+		ComputeCapability:         computeCapability,
+	}
+	n.optionalAttribute(uuid, setFanPolicy(device, info))
+	n.optionalAttribute(uuid, setNUMAAffinity(device, info))
+	n.optionalAttribute(uuid, setC2CLinks(device, info))
+	n.optionalAttribute(uuid, setTemperatureThresholds(device, info))
+	n.optionalAttribute(uuid, setVGPULicense(device, info))
+	return info, nil
+}
+
+// optionalAttribute logs the failure to query an optional attribute of the
+// device with the given UUID, failures repeated on every fingerprint are
+// coalesced
+func (n *nvmlDriver) optionalAttribute(uuid string, err error) {
+	if err == nil || n.logger == nil {
+		return
+	}
+	n.failedCalls.Load().Log(n.logger, hclog.Warn, "optional/"+uuid+"/"+err.Error(),
+		"failed to query optional device attribute, leaving it unset", "uuid", uuid, "error", err)
+}
+
+// hasSymbol reports whether the loaded NVML library exports the function of
+// the given name. The bindings do not check that functions exist before
+// calling them and calling a missing one aborts the plugin, so functions newer
+// than the R450 drivers supported by the plugin are looked up before being
+// called.
+func hasSymbol(name string) bool {
+	return nvml.Extensions().LookupSymbol(name) == nil
+}
+
+// maxNUMANodes is the number of NUMA nodes covered by memory affinity queries
+const maxNUMANodes = 1024
+
+// setNUMAAffinity sets the coherent memory capability and NUMA nodes of info.
+// On systems with coherent memory, such as Grace Hopper, the memory of the GPU
+// is exposed as a NUMA node without CPUs, next to the NUMA node of the Grace
+// CPU it is attached to.
+func setNUMAAffinity(device nvml.Device, info *DeviceInfo) error {
+	if hasSymbol("nvmlDeviceGetC2cModeInfoV") {
+		c2c, code := nvml.DeviceGetC2cModeInfoV(device).V1()
+		switch code {
+		case nvml.SUCCESS:
+			info.CoherentMemory = pointerOf(c2c.IsC2cEnabled != 0)
+		case nvml.ERROR_NOT_SUPPORTED, nvml.ERROR_INVALID_ARGUMENT:
+		default:
+			return decode("failed to get device c2c mode", code)
+		}
+	}
+
+	if info.CoherentMemory != nil && *info.CoherentMemory && hasSymbol("nvmlDeviceGetNumaNodeId") {
+		node, code := nvml.DeviceGetNumaNodeId(device)
+		switch code {
+		case nvml.SUCCESS:
+			info.MemoryNUMANode = pointerOf(uint(node))
+		case nvml.ERROR_NOT_SUPPORTED:
+		default:
+			return decode("failed to get device numa node", code)
+		}
+	}
+
+	if !hasSymbol("nvmlDeviceGetMemoryAffinity") {
+		return nil
+	}
+	nodeSet, code := nvml.DeviceGetMemoryAffinity(device, maxNUMANodes, nvml.AFFINITY_SCOPE_NODE)
+	switch code {
+	case nvml.SUCCESS:
+		info.NUMANode = closestNUMANode(nodeSet, info.MemoryNUMANode)
+	case nvml.ERROR_NOT_SUPPORTED:
+	default:
+		return decode("failed to get device memory affinity", code)
+	}
+	return nil
+}
+
+// setC2CLinks sets the number and total bandwidth of the active NVLink-C2C
+// links of devices with coherent memory, such as the GPU of a Grace Hopper
+// superchip. Other devices have no C2C links and are not queried.
+func setC2CLinks(device nvml.Device, info *DeviceInfo) error {
+	if info.CoherentMemory == nil || !*info.CoherentMemory {
+		return nil
+	}
+
+	count := []nvml.FieldValue{{FieldId: nvml.FI_DEV_C2C_LINK_COUNT}}
+	switch code := nvml.DeviceGetFieldValues(device, count); code {
+	case nvml.SUCCESS:
+	case nvml.ERROR_NOT_SUPPORTED:
+		return nil
+	default:
+		return decode("failed to get device c2c link count", code)
+	}
+	links, ok := fieldValue(count[0])
+	if !ok || links == 0 {
+		return nil
+	}
+
+	// the status and bandwidth of every link, scoped by link ID
+	values := make([]nvml.FieldValue, 0, 2*links)
+	for link := uint32(0); link < uint32(links); link++ {
+		values = append(values,
+			nvml.FieldValue{FieldId: nvml.FI_DEV_C2C_LINK_GET_STATUS, ScopeId: link},
+			nvml.FieldValue{FieldId: nvml.FI_DEV_C2C_LINK_GET_MAX_BW, ScopeId: link},
+		)
+	}
+	if code := nvml.DeviceGetFieldValues(device, values); code != nvml.SUCCESS {
+		return decode("failed to get device c2c links", code)
+	}
+
+	var active, bandwidth uint
+	for i := 0; i < len(values); i += 2 {
+		if status, ok := fieldValue(values[i]); !ok || status == 0 {
+			continue
+		}
+		active++
+		if linkBandwidth, ok := fieldValue(values[i+1]); ok {
+			bandwidth += linkBandwidth
+		}
+	}
+	info.C2CLinks = &active
+	info.C2CBandwidthMBps = &bandwidth
+	return nil
+}
+
+// setTemperatureThresholds sets the maximum operating temperature and the
+// acoustic temperature threshold of info, and leaves them nil if the device or
+// driver does not report them.
+func setTemperatureThresholds(device nvml.Device, info *DeviceInfo) error {
+	for _, threshold := range []struct {
+		kind  nvml.TemperatureThresholds
+		name  string
+		value **uint
+	}{
+		{nvml.TEMPERATURE_THRESHOLD_GPU_MAX, "max operating", &info.MaxOperatingTemperatureC},
+		{nvml.TEMPERATURE_THRESHOLD_ACOUSTIC_CURR, "acoustic", &info.AcousticTemperatureC},
+	} {
+		temperature, code := nvml.DeviceGetTemperatureThreshold(device, threshold.kind)
+		switch code {
+		case nvml.SUCCESS:
+			*threshold.value = pointerOf(uint(temperature))
+		case nvml.ERROR_NOT_SUPPORTED, nvml.ERROR_INVALID_ARGUMENT:
+		default:
+			return decode(fmt.Sprintf("failed to get device %s temperature threshold", threshold.name), code)
+		}
+	}
+	return nil
+}
+
+// setVGPULicense sets whether the vGPU software is licensed on the device and
+// when its license expires, from its enabled licensable feature. They are
+// left nil on devices that do not support vGPU licensing, such as bare metal
+// and passthrough GPUs.
+func setVGPULicense(device nvml.Device, info *DeviceInfo) error {
+	features, code := nvml.DeviceGetGridLicensableFeatures(device)
+	switch code {
+	case nvml.SUCCESS:
+	case nvml.ERROR_NOT_SUPPORTED:
+		return nil
+	default:
+		return decode("failed to get device grid licensable features", code)
+	}
+	if features.IsGridLicenseSupported == 0 {
+		return nil
+	}
+
+	count := min(int(features.LicensableFeaturesCount), len(features.GridLicensableFeatures))
+	for _, feature := range features.GridLicensableFeatures[:count] {
+		if feature.FeatureEnabled == 0 {
+			continue
+		}
+		info.VGPULicensed = pointerOf(feature.FeatureState != 0)
+		if expiry := feature.LicenseExpiry; expiry.Status == nvml.GRID_LICENSE_EXPIRY_VALID {
+			info.VGPULicenseExpiry = pointerOf(time.Date(int(expiry.Year), time.Month(expiry.Month), int(expiry.Day),
+				int(expiry.Hour), int(expiry.Min), int(expiry.Sec), 0, time.UTC))
+		}
+		return nil
+	}
+	return nil
+}
+
+// setFanPolicy sets the fan count, speed range and control of info from the
+// fans of the device, and leaves them nil if the device has no fans, such as
+// passively cooled GPUs.
+func setFanPolicy(device nvml.Device, info *DeviceInfo) error {
+	if !hasSymbol("nvmlDeviceGetNumFans") {
+		return nil
+	}
+	fans, code := nvml.DeviceGetNumFans(device)
+	if code == nvml.ERROR_NOT_SUPPORTED || (code == nvml.SUCCESS && fans == 0) {
+		return nil
+	} else if code != nvml.SUCCESS {
+		return decode("failed to get device fan count", code)
+	}
+	info.FanCount = pointerOf(uint(fans))
+
+	if !hasSymbol("nvmlDeviceGetMinMaxFanSpeed") || !hasSymbol("nvmlDeviceGetFanControlPolicy_v2") {
+		return nil
+	}
+	minSpeed, maxSpeed, code := nvml.DeviceGetMinMaxFanSpeed(device)
+	if code == nvml.SUCCESS {
+		info.FanSpeedMin = pointerOf(uint(minSpeed))
+		info.FanSpeedMax = pointerOf(uint(maxSpeed))
+	} else if code != nvml.ERROR_NOT_SUPPORTED {
+		return decode("failed to get device min max fan speed", code)
+	}
+
+	control := "automatic"
+	for fan := 0; fan < fans; fan++ {
+		policy, code := nvml.DeviceGetFanControlPolicy_v2(device, fan)
+		if code == nvml.ERROR_NOT_SUPPORTED {
+			return nil
+		} else if code != nvml.SUCCESS {
+			return decode("failed to get device fan control policy", code)
+		}
+		if policy == nvml.FAN_POLICY_MANUAL {
+			control = "manual"
+		}
+	}
+	info.FanControl = &control
+	return nil
+}
+
+// engineUtilization returns the utilization of the JPEG decoders and of the
+// optical flow accelerator (OFA) of the device, or nil values for devices and
+// drivers without them, such as GPUs older than Ampere.
+func engineUtilization(device nvml.Device) (*uint, *uint, error) {
+	var jpeg, ofa *uint
+	if !hasSymbol("nvmlDeviceGetJpgUtilization") || !hasSymbol("nvmlDeviceGetOfaUtilization") {
+		return nil, nil, nil
+	}
+	utilization, _, code := nvml.DeviceGetJpgUtilization(device)
+	switch code {
+	case nvml.SUCCESS:
+		jpeg = pointerOf(uint(utilization))
+	case nvml.ERROR_NOT_SUPPORTED:
+	default:
+		return nil, nil, decode("failed to get device jpeg utilization", code)
+	}
+
+	utilization, _, code = nvml.DeviceGetOfaUtilization(device)
+	switch code {
+	case nvml.SUCCESS:
+		ofa = pointerOf(uint(utilization))
+	case nvml.ERROR_NOT_SUPPORTED:
+	default:
+		return nil, nil, decode("failed to get device ofa utilization", code)
+	}
+	return jpeg, ofa, nil
+}
+
+// fanSpeeds returns the speed of the slowest fan of the device, which shows
+// failed fans, and the highest target speed of its fans, or nil values if the
+// device has no fans.
+func fanSpeeds(device nvml.Device) (*uint, *uint, error) {
+	if !hasSymbol("nvmlDeviceGetNumFans") || !hasSymbol("nvmlDeviceGetTargetFanSpeed") {
+		return nil, nil, nil
+	}
+	fans, code := nvml.DeviceGetNumFans(device)
+	if code == nvml.ERROR_NOT_SUPPORTED || (code == nvml.SUCCESS && fans == 0) {
+		return nil, nil, nil
+	} else if code != nvml.SUCCESS {
+		return nil, nil, decode("failed to get device fan count", code)
+	}
+
+	var speed, target *uint
+	for fan := 0; fan < fans; fan++ {
+		fanSpeed, code := nvml.DeviceGetFanSpeed_v2(device, fan)
+		if code == nvml.SUCCESS {
+			if speed == nil || uint(fanSpeed) < *speed {
+				speed = pointerOf(uint(fanSpeed))
+			}
+		} else if code != nvml.ERROR_NOT_SUPPORTED {
+			return nil, nil, decode("failed to get device fan speed", code)
+		}
+
+		targetSpeed, code := nvml.DeviceGetTargetFanSpeed(device, fan)
+		if code == nvml.SUCCESS {
+			if target == nil || uint(targetSpeed) > *target {
+				target = pointerOf(uint(targetSpeed))
+			}
+		} else if code != nvml.ERROR_NOT_SUPPORTED {
+			return nil, nil, decode("failed to get device target fan speed", code)
+		}
+	}
+	return speed, target, nil
+}
+
+// operationModes are the names of the GPU operation modes
+var operationModes = map[nvml.GpuOperationMode]string{
+	nvml.GOM_ALL_ON:  "all_on",
+	nvml.GOM_COMPUTE: "compute",
+	nvml.GOM_LOW_DP:  "low_dp",
+}
+
+// operationMode returns the current GPU operation mode of the device, or nil
+// if the device does not support operation modes.
+func operationMode(device nvml.Device) (*string, error) {
+	current, _, code := nvml.DeviceGetGpuOperationMode(device)
+	switch code {
+	case nvml.SUCCESS:
+	case nvml.ERROR_NOT_SUPPORTED, nvml.ERROR_INVALID_ARGUMENT:
+		return nil, nil
+	default:
+		return nil, decode("failed to get device gpu operation mode", code)
+	}
+	if name, ok := operationModes[current]; ok {
+		return &name, nil
+	}
+	return pointerOf(fmt.Sprintf("unknown(%d)", current)), nil
+}
+
+// virtualizationModes are the names of the GPU virtualization modes
+var virtualizationModes = map[nvml.GpuVirtualizationMode]string{
+	nvml.GPU_VIRTUALIZATION_MODE_NONE:        "none",
+	nvml.GPU_VIRTUALIZATION_MODE_PASSTHROUGH: "passthrough",
+	nvml.GPU_VIRTUALIZATION_MODE_VGPU:        "vgpu",
+	nvml.GPU_VIRTUALIZATION_MODE_HOST_VGPU:   "host_vgpu",
+	nvml.GPU_VIRTUALIZATION_MODE_HOST_VSGA:   "host_vsga",
+}
+
+// virtualizationMode returns the virtualization mode of the device, or nil
+// if the device does not report it.
+func virtualizationMode(device nvml.Device) (*string, error) {
+	mode, code := nvml.DeviceGetVirtualizationMode(device)
+	switch code {
+	case nvml.SUCCESS:
+	case nvml.ERROR_NOT_SUPPORTED, nvml.ERROR_INVALID_ARGUMENT:
+		return nil, nil
+	default:
+		return nil, decode("failed to get device virtualization mode", code)
+	}
+	if name, ok := virtualizationModes[mode]; ok {
+		return &name, nil
+	}
+	return pointerOf(fmt.Sprintf("unknown(%d)", mode)), nil
+}
+
+// brands are the names of the device brands
+var brands = map[nvml.BrandType]string{
+	nvml.BRAND_QUADRO:              "quadro",
+	nvml.BRAND_TESLA:               "tesla",
+	nvml.BRAND_NVS:                 "nvs",
+	nvml.BRAND_GRID:                "grid",
+	nvml.BRAND_GEFORCE:             "geforce",
+	nvml.BRAND_TITAN:               "titan",
+	nvml.BRAND_NVIDIA_VAPPS:        "nvidia_vapps",
+	nvml.BRAND_NVIDIA_VPC:          "nvidia_vpc",
+	nvml.BRAND_NVIDIA_VCS:          "nvidia_vcs",
+	nvml.BRAND_NVIDIA_VWS:          "nvidia_vws",
+	nvml.BRAND_NVIDIA_CLOUD_GAMING: "nvidia_cloud_gaming",
+	nvml.BRAND_QUADRO_RTX:          "quadro_rtx",
+	nvml.BRAND_NVIDIA_RTX:          "nvidia_rtx",
+	nvml.BRAND_NVIDIA:              "nvidia",
+	nvml.BRAND_GEFORCE_RTX:         "geforce_rtx",
+	nvml.BRAND_TITAN_RTX:           "titan_rtx",
+}
+
+// brand returns the brand of the device, or nil if it is unknown.
+func brand(device nvml.Device) (*string, error) {
+	brandType, code := nvml.DeviceGetBrand(device)
+	switch code {
+	case nvml.SUCCESS:
+	case nvml.ERROR_NOT_SUPPORTED, nvml.ERROR_INVALID_ARGUMENT:
+		return nil, nil
+	default:
+		return nil, decode("failed to get device brand", code)
+	}
+	if brandType == nvml.BRAND_UNKNOWN {
+		return nil, nil
+	}
+	if name, ok := brands[brandType]; ok {
+		return &name, nil
+	}
+	return pointerOf(fmt.Sprintf("unknown(%d)", brandType)), nil
+}
+
+// deviceArchBlackwell is the architecture of Blackwell GPUs, which the NVML
+// bindings do not define yet
+const deviceArchBlackwell nvml.DeviceArchitecture = 10
+
+// architectures are the names of the GPU architectures
+var architectures = map[nvml.DeviceArchitecture]string{
+	nvml.DEVICE_ARCH_KEPLER:  "kepler",
+	nvml.DEVICE_ARCH_MAXWELL: "maxwell",
+	nvml.DEVICE_ARCH_PASCAL:  "pascal",
+	nvml.DEVICE_ARCH_VOLTA:   "volta",
+	nvml.DEVICE_ARCH_TURING:  "turing",
+	nvml.DEVICE_ARCH_AMPERE:  "ampere",
+	nvml.DEVICE_ARCH_ADA:     "ada",
+	nvml.DEVICE_ARCH_HOPPER:  "hopper",
+	deviceArchBlackwell:      "blackwell",
+}
+
+// architecture returns the architecture of the device, or nil if it is
+// unknown.
+func architecture(device nvml.Device) (*string, error) {
+	arch, code := nvml.DeviceGetArchitecture(device)
+	switch code {
+	case nvml.SUCCESS:
+	case nvml.ERROR_NOT_SUPPORTED, nvml.ERROR_INVALID_ARGUMENT:
+		return nil, nil
+	default:
+		return nil, decode("failed to get device architecture", code)
+	}
+	if arch == nvml.DEVICE_ARCH_UNKNOWN {
+		return nil, nil
+	}
+	if name, ok := architectures[arch]; ok {
+		return &name, nil
+	}
+	return pointerOf(fmt.Sprintf("unknown(%d)", arch)), nil
+}
+
+// computeCapability returns the CUDA compute capability of the device, such
+// as "8.0", or nil if it is unknown.
+func computeCapability(device nvml.Device) (*string, error) {
+	major, minor, code := nvml.DeviceGetCudaComputeCapability(device)
+	switch code {
+	case nvml.SUCCESS:
+	case nvml.ERROR_NOT_SUPPORTED, nvml.ERROR_INVALID_ARGUMENT:
+		return nil, nil
+	default:
+		return nil, decode("failed to get device cuda compute capability", code)
+	}
+	return pointerOf(fmt.Sprintf("%d.%d", major, minor)), nil
+}
+
+// inforomVersion returns the version of the given InfoROM object of the
+// device, or nil if the device has no InfoROM or does not have the object.
+func inforomVersion(device nvml.Device, object nvml.InforomObject) (*string, error) {
+	version, code := nvml.DeviceGetInforomVersion(device, object)
+	switch code {
+	case nvml.SUCCESS:
+		return &version, nil
+	case nvml.ERROR_NOT_SUPPORTED, nvml.ERROR_INVALID_ARGUMENT:
+		return nil, nil
+	}
+	return nil, decode("failed to get device inforom version", code)
+}
+
+// inforomCorrupted validates the checksums of the InfoROM of the device and
+// reports whether it is corrupted. Devices without InfoROM are not corrupted.
+func inforomCorrupted(device nvml.Device) (bool, error) {
+	switch code := nvml.DeviceValidateInforom(device); code {
+	case nvml.SUCCESS, nvml.ERROR_NOT_SUPPORTED, nvml.ERROR_INVALID_ARGUMENT:
+		return false, nil
+	case nvml.ERROR_CORRUPTED_INFOROM:
+		return true, nil
+	default:
+		return false, decode("failed to validate device inforom", code)
+	}
+}
+
+// gspFirmware returns the GSP firmware mode of the device, and the version of
+// its GSP firmware when enabled. Nil values are returned for devices and
+// drivers that do not support GSP firmware, such as MIG devices.
+func gspFirmware(device nvml.Device) (*string, *string, error) {
+	if !hasSymbol("nvmlDeviceGetGspFirmwareMode") || !hasSymbol("nvmlDeviceGetGspFirmwareVersion") {
+		return nil, nil, nil
+	}
+
+	enabled, _, code := nvml.DeviceGetGspFirmwareMode(device)
+	switch code {
+	case nvml.SUCCESS:
+	case nvml.ERROR_NOT_SUPPORTED, nvml.ERROR_INVALID_ARGUMENT:
+		return nil, nil, nil
+	default:
+		return nil, nil, decode("failed to get device gsp firmware mode", code)
+	}
+	if !enabled {
+		return pointerOf("Disabled"), nil, nil
+	}
+
+	version, code := nvml.DeviceGetGspFirmwareVersion(device)
+	switch code {
+	case nvml.SUCCESS:
+		return pointerOf("Enabled"), pointerOf(version), nil
+	case nvml.ERROR_NOT_SUPPORTED:
+		return pointerOf("Enabled"), nil, nil
+	}
+	return nil, nil, decode("failed to get device gsp firmware version", code)
+}
+
+// encoderCapacity returns the remaining capacity of the device's encoder for
+// the given codec, or nil if the device has no encoder or does not support it.
+func encoderCapacity(device nvml.Device, codec nvml.EncoderType) (*uint, error) {
+	capacity, code := nvml.DeviceGetEncoderCapacity(device, codec)
+	if code == nvml.ERROR_NOT_SUPPORTED || code == nvml.ERROR_INVALID_ARGUMENT {
+		return nil, nil
+	}
+	if code != nvml.SUCCESS {
+		return nil, decode("failed to get device encoder capacity", code)
+	}
+	return pointerOf(uint(capacity)), nil
+}
+
+// enableState formats an nvml feature state as "Enabled" or "Disabled"
+func enableState(state nvml.EnableState) string {
+	if state == nvml.FEATURE_ENABLED {
+		return "Enabled"
+	}
+	return "Disabled"
+}
+
+// migProfiles returns the GPU instance profiles supported by the given
+// physical device, or nil if the device does not support MIG.
+func migProfiles(device nvml.Device) ([]*MIGProfile, error) {
+	if !hasSymbol("nvmlDeviceGetGpuInstanceProfileInfoV") {
+		return nil, nil
+	}
+
+	var profiles []*MIGProfile
+	for i := 0; i < nvml.GPU_INSTANCE_PROFILE_COUNT; i++ {
+		info, code := nvml.DeviceGetGpuInstanceProfileInfoV(device, i).V2()
+		if code == nvml.ERROR_NOT_SUPPORTED || code == nvml.ERROR_INVALID_ARGUMENT {
+			// The device does not support MIG or this profile.
+			continue
+		}
+		if code != nvml.SUCCESS {
+			return nil, decode("failed to get device gpu instance profile info", code)
+		}
+		if info.InstanceCount == 0 {
+			continue
+		}
+
+		profiles = append(profiles, &MIGProfile{
+			Name:          profileName(info.Name),
+			SliceCount:    uint(info.SliceCount),
+			InstanceCount: uint(info.InstanceCount),
+			MemoryMiB:     info.MemorySizeMB,
+		})
+	}
+	return profiles, nil
 }
 
-func buildID(id [32]int8) string {
-	b := make([]byte, len(id))
-	for i := 0; i < len(id); i++ {
-		b[i] = byte(id[i])
+// migInstanceMemoryMiB returns the memory size of the GPU instance profile of
+// the given MIG device, or nil if its GPU instance can not be queried, such as
+// by unprivileged users on some drivers.
+func migInstanceMemoryMiB(migDevice, parentDevice nvml.Device) (*uint64, error) {
+	unavailable := func(code nvml.Return) bool {
+		return code == nvml.ERROR_NOT_SUPPORTED || code == nvml.ERROR_NO_PERMISSION ||
+			code == nvml.ERROR_INVALID_ARGUMENT || code == nvml.ERROR_NOT_FOUND
+	}
+
+	id, code := nvml.DeviceGetGpuInstanceId(migDevice)
+	if unavailable(code) {
+		return nil, nil
+	} else if code != nvml.SUCCESS {
+		return nil, decode("failed to get MIG device gpu instance id", code)
+	}
+	instance, code := nvml.DeviceGetGpuInstanceById(parentDevice, id)
+	if unavailable(code) {
+		return nil, nil
+	} else if code != nvml.SUCCESS {
+		return nil, decode("failed to get MIG device gpu instance", code)
+	}
+	info, code := nvml.GpuInstanceGetInfo(instance)
+	if unavailable(code) {
+		return nil, nil
+	} else if code != nvml.SUCCESS {
+		return nil, decode("failed to get MIG device gpu instance info", code)
+	}
+
+	if !hasSymbol("nvmlDeviceGetGpuInstanceProfileInfoV") {
+		return nil, nil
 	}
-	return string(b)
+	for i := 0; i < nvml.GPU_INSTANCE_PROFILE_COUNT; i++ {
+		profile, code := nvml.DeviceGetGpuInstanceProfileInfoV(parentDevice, i).V2()
+		if unavailable(code) {
+			continue
+		}
+		if code != nvml.SUCCESS {
+			return nil, decode("failed to get device gpu instance profile info", code)
+		}
+		if profile.Id == info.ProfileId {
+			return pointerOf(profile.MemorySizeMB), nil
+		}
+	}
+	return nil, nil
+}
+
+// profileName converts a NUL terminated profile name returned by nvml, such as
+// "MIG 1g.5gb", to its short form "1g.5gb".
+func profileName(name [96]int8) string {
+	return strings.TrimPrefix(cString(name[:]), "MIG ")
 }
 
-// DeviceInfoAndStatusByUUID returns DeviceInfo and DeviceStatus for index GPU in system device list.
-func (n *nvmlDriver) DeviceInfoAndStatusByUUID(uuid string) (*DeviceInfo, *DeviceStatus, error) {
-	di, err := n.DeviceInfoByUUID(uuid)
+// deviceInfoAndStatusByUUID returns DeviceInfo and DeviceStatus for index GPU in system device list.
+func (n *nvmlDriver) deviceInfoAndStatusByUUID(uuid string) (*DeviceInfo, *DeviceStatus, error) {
+	di, err := n.deviceInfoByUUID(uuid)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -274,7 +1066,11 @@ func (n *nvmlDriver) DeviceInfoAndStatusByUUID(uuid string) (*DeviceInfo, *Devic
 	// MIG devices don't have temperature, power usage or utilization properties
 	// so just nil them out.
 	utzGPU, utzMem, utzEncU, utzDecU := uint(0), uint(0), uint(0), uint(0)
-	powerU, tempU := uint(0), uint(0)
+	powerMW, tempU := uint(0), uint(0)
+	var energyMJ *uint64
+	var fanSpeed, fanTargetSpeed *uint
+	var jpegUtilization, ofaUtilization *uint
+	var nvlinkTx, nvlinkRx *uint64
 	if !isMig {
 		utz, code := nvml.DeviceGetUtilizationRates(device)
 		if code != nvml.SUCCESS {
@@ -295,6 +1091,11 @@ func (n *nvmlDriver) DeviceInfoAndStatusByUUID(uuid string) (*DeviceInfo, *Devic
 		}
 		utzDecU = uint(utzDec)
 
+		jpegUtilization, ofaUtilization, err = engineUtilization(device)
+		if err != nil {
+			return nil, nil, err
+		}
+
 		temp, code := nvml.DeviceGetTemperature(device, nvml.TEMPERATURE_GPU)
 		if code != nvml.SUCCESS {
 			if code == nvml.ERROR_NOT_SUPPORTED {
@@ -313,17 +1114,32 @@ func (n *nvmlDriver) DeviceInfoAndStatusByUUID(uuid string) (*DeviceInfo, *Devic
 				return nil, nil, decode("failed to get device power usage", code)
 			}
 		}
-		powerU = uint(power)
-	}
+		// nvml reports power usage in milliwatts
+		powerMW = uint(power)
 
-	ecc, code := nvml.DeviceGetDetailedEccErrors(device, nvml.MEMORY_ERROR_TYPE_CORRECTED, nvml.VOLATILE_ECC)
-	if code != nvml.SUCCESS {
-		if code == nvml.ERROR_NOT_SUPPORTED {
-			ecc = nvml.EccErrorCounts{}
-		} else {
-			return nil, nil, decode("failed to get device ecc error counts", code)
+		energy, code := nvml.DeviceGetTotalEnergyConsumption(device)
+		if code == nvml.SUCCESS {
+			energyMJ = &energy
+		} else if code != nvml.ERROR_NOT_SUPPORTED {
+			return nil, nil, decode("failed to get device total energy consumption", code)
+		}
+
+		fanSpeed, fanTargetSpeed, err = fanSpeeds(device)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		nvlinkTx, nvlinkRx, err = n.nvlinkCounters(device)
+		if err != nil {
+			return nil, nil, err
 		}
 	}
+	powerU := powerMW / 1000
+
+	ecc, eccAggregate, err := n.eccErrorCounts(device)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	return di, &DeviceStatus{
 		TemperatureC:          &tempU,
@@ -331,12 +1147,731 @@ func (n *nvmlDriver) DeviceInfoAndStatusByUUID(uuid string) (*DeviceInfo, *Devic
 		MemoryUtilization:     &utzMem,
 		EncoderUtilization:    &utzEncU,
 		DecoderUtilization:    &utzDecU,
+		JPEGUtilization:       jpegUtilization,
+		OFAUtilization:        ofaUtilization,
 		UsedMemoryMiB:         &memUsedU,
 		PowerUsageW:           &powerU,
+		PowerUsageMW:          &powerMW,
 		BAR1UsedMiB:           &barUsed,
 		ECCErrorsDevice:       &ecc.DeviceMemory,
 		ECCErrorsL1Cache:      &ecc.L1Cache,
 		ECCErrorsL2Cache:      &ecc.L2Cache,
 		ECCErrorsRegisterFile: &ecc.RegisterFile,
+
+		ECCErrorsL1CacheAggregate: &eccAggregate.L1Cache,
+		ECCErrorsL2CacheAggregate: &eccAggregate.L2Cache,
+		ECCErrorsDeviceAggregate:  &eccAggregate.DeviceMemory,
+
+		EnergyMJ:       energyMJ,
+		FanSpeed:       fanSpeed,
+		FanTargetSpeed: fanTargetSpeed,
+		NVLinkTxKiB:    nvlinkTx,
+		NVLinkRxKiB:    nvlinkRx,
 	}, nil
 }
+
+// nvlinkCounters returns the data sent and received over all NVLinks of
+// device in KiB, read along with the NVLink count so that devices without
+// NVLinks, such as PCIe GPUs, report nil counters
+func (n *nvmlDriver) nvlinkCounters(device nvml.Device) (*uint64, *uint64, error) {
+	if n.fieldValuesUnsupported.Load() {
+		return nil, nil, nil
+	}
+
+	// a scope of MaxUint32 sums the data counters of all links
+	values := []nvml.FieldValue{
+		{FieldId: nvml.FI_DEV_NVLINK_LINK_COUNT},
+		{FieldId: nvml.FI_DEV_NVLINK_THROUGHPUT_DATA_TX, ScopeId: math.MaxUint32},
+		{FieldId: nvml.FI_DEV_NVLINK_THROUGHPUT_DATA_RX, ScopeId: math.MaxUint32},
+	}
+	switch code := nvml.DeviceGetFieldValues(device, values); code {
+	case nvml.SUCCESS:
+	case nvml.ERROR_NOT_SUPPORTED:
+		return nil, nil, nil
+	default:
+		return nil, nil, decode("failed to get device nvlink throughput", code)
+	}
+
+	links, ok := fieldValue(values[0])
+	if !ok || links == 0 {
+		return nil, nil, nil
+	}
+	tx, txOK := fieldValue(values[1])
+	rx, rxOK := fieldValue(values[2])
+	if !txOK || !rxOK {
+		return nil, nil, nil
+	}
+	return pointerOf(uint64(tx)), pointerOf(uint64(rx)), nil
+}
+
+// fieldValue returns the value of a field queried with
+// nvmlDeviceGetFieldValues, and false when the field failed
+func fieldValue(value nvml.FieldValue) (uint, bool) {
+	if nvml.Return(value.NvmlReturn) != nvml.SUCCESS {
+		return 0, false
+	}
+	return sampleValue(nvml.ValueType(value.ValueType), value.Value)
+}
+
+// eccFields are the field IDs of the corrected ECC error counters, in the
+// order they are read by eccErrorCountsFromFields
+var eccFields = []uint32{
+	nvml.FI_DEV_ECC_SBE_VOL_L1,
+	nvml.FI_DEV_ECC_SBE_VOL_L2,
+	nvml.FI_DEV_ECC_SBE_VOL_DEV,
+	nvml.FI_DEV_ECC_SBE_VOL_REG,
+	nvml.FI_DEV_ECC_SBE_AGG_L1,
+	nvml.FI_DEV_ECC_SBE_AGG_L2,
+	nvml.FI_DEV_ECC_SBE_AGG_DEV,
+}
+
+// eccErrorCounts returns the volatile and aggregate corrected ECC error
+// counts of device. They are read with a single batched field values query,
+// falling back to a query per counter type on drivers that do not support it.
+// Counters the device does not support are zero.
+func (n *nvmlDriver) eccErrorCounts(device nvml.Device) (nvml.EccErrorCounts, nvml.EccErrorCounts, error) {
+	if !n.fieldValuesUnsupported.Load() {
+		volatile, aggregate, code := eccErrorCountsFromFields(device)
+		switch code {
+		case nvml.SUCCESS:
+			return volatile, aggregate, nil
+		case nvml.ERROR_NOT_SUPPORTED:
+			n.fieldValuesUnsupported.Store(true)
+		}
+	}
+
+	volatile, code := nvml.DeviceGetDetailedEccErrors(device, nvml.MEMORY_ERROR_TYPE_CORRECTED, nvml.VOLATILE_ECC)
+	if code != nvml.SUCCESS {
+		if code == nvml.ERROR_NOT_SUPPORTED {
+			volatile = nvml.EccErrorCounts{}
+		} else {
+			return nvml.EccErrorCounts{}, nvml.EccErrorCounts{}, decode("failed to get device ecc error counts", code)
+		}
+	}
+
+	aggregate, code := nvml.DeviceGetDetailedEccErrors(device, nvml.MEMORY_ERROR_TYPE_CORRECTED, nvml.AGGREGATE_ECC)
+	if code != nvml.SUCCESS {
+		if code == nvml.ERROR_NOT_SUPPORTED {
+			aggregate = nvml.EccErrorCounts{}
+		} else {
+			return nvml.EccErrorCounts{}, nvml.EccErrorCounts{}, decode("failed to get device aggregate ecc error counts", code)
+		}
+	}
+	return volatile, aggregate, nil
+}
+
+// eccErrorCountsFromFields reads the ECC error counts of device with a single
+// field values query. A code other than SUCCESS is returned when the query or
+// one of its fields failed, in which case the counts must be queried
+// individually.
+func eccErrorCountsFromFields(device nvml.Device) (nvml.EccErrorCounts, nvml.EccErrorCounts, nvml.Return) {
+	values := make([]nvml.FieldValue, len(eccFields))
+	for i, field := range eccFields {
+		values[i].FieldId = field
+	}
+	if code := nvml.DeviceGetFieldValues(device, values); code != nvml.SUCCESS {
+		return nvml.EccErrorCounts{}, nvml.EccErrorCounts{}, code
+	}
+
+	counts := make([]uint64, len(values))
+	for i, value := range values {
+		switch code := nvml.Return(value.NvmlReturn); code {
+		case nvml.SUCCESS:
+			count, ok := sampleValue(nvml.ValueType(value.ValueType), value.Value)
+			if !ok {
+				return nvml.EccErrorCounts{}, nvml.EccErrorCounts{}, nvml.ERROR_UNKNOWN
+			}
+			counts[i] = uint64(count)
+		case nvml.ERROR_NOT_SUPPORTED:
+		default:
+			return nvml.EccErrorCounts{}, nvml.EccErrorCounts{}, code
+		}
+	}
+
+	volatile := nvml.EccErrorCounts{
+		L1Cache:      counts[0],
+		L2Cache:      counts[1],
+		DeviceMemory: counts[2],
+		RegisterFile: counts[3],
+	}
+	aggregate := nvml.EccErrorCounts{
+		L1Cache:      counts[4],
+		L2Cache:      counts[5],
+		DeviceMemory: counts[6],
+	}
+	return volatile, aggregate, nvml.SUCCESS
+}
+
+// fullGPUHandle returns the handle of the GPU with the given UUID, or
+// ErrNotSupported if the UUID belongs to a MIG instance, as resetting its
+// parent would affect every other instance of the GPU.
+func fullGPUHandle(uuid string) (nvml.Device, error) {
+	device, code := nvml.DeviceGetHandleByUUID(uuid)
+	if code != nvml.SUCCESS {
+		return nil, decode("failed to get device handle", code)
+	}
+
+	_, code = nvml.DeviceGetDeviceHandleFromMigDeviceHandle(device)
+	if code == nvml.SUCCESS {
+		return nil, ErrNotSupported
+	} else if code != nvml.ERROR_NOT_FOUND && code != nvml.ERROR_INVALID_ARGUMENT {
+		return nil, decode("failed to get device parent device handle", code)
+	}
+	return device, nil
+}
+
+// resetDeviceClocks resets the locked and applications clocks of the GPU
+// matching the given UUID to their defaults
+func (n *nvmlDriver) resetDeviceClocks(uuid string) error {
+	device, err := fullGPUHandle(uuid)
+	if err != nil {
+		return err
+	}
+
+	if code := nvml.DeviceResetGpuLockedClocks(device); code != nvml.SUCCESS && code != nvml.ERROR_NOT_SUPPORTED {
+		return decode("failed to reset locked clocks", code)
+	}
+	if code := nvml.DeviceResetMemoryLockedClocks(device); code != nvml.SUCCESS && code != nvml.ERROR_NOT_SUPPORTED {
+		return decode("failed to reset locked memory clocks", code)
+	}
+	if code := nvml.DeviceResetApplicationsClocks(device); code != nvml.SUCCESS && code != nvml.ERROR_NOT_SUPPORTED {
+		return decode("failed to reset applications clocks", code)
+	}
+	return nil
+}
+
+// resetDevice performs a full reset of the GPU matching the given UUID. NVML
+// does not expose GPU resets, so nvidia-smi is used, which requires that no
+// process is using the GPU. nvidia-smi is killed once ctx is done.
+func (n *nvmlDriver) resetDevice(ctx context.Context, uuid string) error {
+	if _, err := fullGPUHandle(uuid); err != nil {
+		return err
+	}
+
+	out, err := exec.CommandContext(ctx, "nvidia-smi", "--gpu-reset", "-i", uuid).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to reset device: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// computeProcessesByUUID returns the PIDs of compute processes running on the
+// GPU or MIG instance matching the given UUID
+func (n *nvmlDriver) computeProcessesByUUID(uuid string) ([]int, error) {
+	device, code := nvml.DeviceGetHandleByUUID(uuid)
+	if code != nvml.SUCCESS {
+		return nil, decode("failed to get device handle", code)
+	}
+
+	processes, code := nvml.DeviceGetComputeRunningProcesses(device)
+	if code != nvml.SUCCESS {
+		return nil, decode("failed to get device compute processes", code)
+	}
+
+	pids := make([]int, len(processes))
+	for i, process := range processes {
+		pids[i] = int(process.Pid)
+	}
+	return pids, nil
+}
+
+// accountingHandle returns the handle of the GPU with the given UUID, or
+// ErrNotSupported if the GPU does not support accounting
+func accountingHandle(uuid string) (nvml.Device, error) {
+	device, code := nvml.DeviceGetHandleByUUID(uuid)
+	if code != nvml.SUCCESS {
+		return nil, decode("failed to get device handle", code)
+	}
+
+	_, code = nvml.DeviceGetAccountingMode(device)
+	if code == nvml.ERROR_NOT_SUPPORTED {
+		return nil, ErrNotSupported
+	} else if code != nvml.SUCCESS {
+		return nil, decode("failed to get device accounting mode", code)
+	}
+	return device, nil
+}
+
+// enableAccountingByUUID enables accounting mode on the GPU matching the
+// given UUID
+func (n *nvmlDriver) enableAccountingByUUID(uuid string) error {
+	device, err := accountingHandle(uuid)
+	if err != nil {
+		return err
+	}
+
+	if code := nvml.DeviceSetAccountingMode(device, nvml.FEATURE_ENABLED); code != nvml.SUCCESS {
+		return decode("failed to enable device accounting mode", code)
+	}
+	return nil
+}
+
+// accountingStatsByUUID returns accounting data of the processes that ran on
+// the GPU matching the given UUID since accounting was last cleared
+func (n *nvmlDriver) accountingStatsByUUID(uuid string) ([]*AccountingStats, error) {
+	device, err := accountingHandle(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	pids, code := nvml.DeviceGetAccountingPids(device)
+	if code != nvml.SUCCESS {
+		return nil, decode("failed to get device accounting pids", code)
+	}
+
+	stats := make([]*AccountingStats, 0, len(pids))
+	for _, pid := range pids {
+		s, code := nvml.DeviceGetAccountingStats(device, uint32(pid))
+		if code == nvml.ERROR_NOT_FOUND {
+			// the process was removed from the accounting buffer meanwhile
+			continue
+		} else if code != nvml.SUCCESS {
+			return nil, decode(fmt.Sprintf("failed to get device accounting stats of pid %d", pid), code)
+		}
+		stats = append(stats, &AccountingStats{
+			PID:               pid,
+			GPUUtilization:    uint(s.GpuUtilization),
+			MemoryUtilization: uint(s.MemoryUtilization),
+			MaxMemoryMiB:      bytesToMegabytes(s.MaxMemoryUsage),
+			GPUTime:           time.Duration(s.Time) * time.Millisecond,
+			StartTime:         time.UnixMicro(int64(s.StartTime)),
+			Running:           s.IsRunning != 0,
+		})
+	}
+	return stats, nil
+}
+
+// clearAccountingByUUID clears the accounting data of the GPU matching the
+// given UUID
+func (n *nvmlDriver) clearAccountingByUUID(uuid string) error {
+	device, err := accountingHandle(uuid)
+	if err != nil {
+		return err
+	}
+
+	if code := nvml.DeviceClearAccountingPids(device); code != nvml.SUCCESS {
+		return decode("failed to clear device accounting pids", code)
+	}
+	return nil
+}
+
+// energyConsumptionByUUID returns the energy consumed by the GPU matching the
+// given UUID since the driver was last loaded, in millijoules, or
+// ErrNotSupported if the GPU has no energy counter, such as MIG instances and
+// GPUs older than Volta
+func (n *nvmlDriver) energyConsumptionByUUID(uuid string) (uint64, error) {
+	device, code := nvml.DeviceGetHandleByUUID(uuid)
+	if code != nvml.SUCCESS {
+		return 0, decode("failed to get device handle", code)
+	}
+
+	energy, code := nvml.DeviceGetTotalEnergyConsumption(device)
+	switch code {
+	case nvml.SUCCESS:
+		return energy, nil
+	case nvml.ERROR_NOT_SUPPORTED, nvml.ERROR_INVALID_ARGUMENT:
+		return 0, ErrNotSupported
+	}
+	return 0, decode("failed to get device total energy consumption", code)
+}
+
+// sampleByUUID returns the GPU utilization, power usage and temperature of
+// the GPU matching the given UUID, with only the queries needed for them so
+// that it can be sampled often. Values the GPU does not support are nil.
+func (n *nvmlDriver) sampleByUUID(uuid string) (*DeviceSample, error) {
+	device, code := nvml.DeviceGetHandleByUUID(uuid)
+	if code != nvml.SUCCESS {
+		return nil, decode("failed to get device handle", code)
+	}
+
+	sample := &DeviceSample{}
+	utz, code := nvml.DeviceGetUtilizationRates(device)
+	if code == nvml.SUCCESS {
+		sample.GPUUtilization = pointerOf(uint(utz.Gpu))
+	} else if code != nvml.ERROR_NOT_SUPPORTED {
+		return nil, decode("failed to get device utilization", code)
+	}
+
+	power, code := nvml.DeviceGetPowerUsage(device)
+	if code == nvml.SUCCESS {
+		sample.PowerUsageMW = pointerOf(uint(power))
+	} else if code != nvml.ERROR_NOT_SUPPORTED {
+		return nil, decode("failed to get device power usage", code)
+	}
+
+	temp, code := nvml.DeviceGetTemperature(device, nvml.TEMPERATURE_GPU)
+	if code == nvml.SUCCESS {
+		sample.TemperatureC = pointerOf(uint(temp))
+	} else if code != nvml.ERROR_NOT_SUPPORTED {
+		return nil, decode("failed to get device temperature", code)
+	}
+	return sample, nil
+}
+
+// gpmSamples holds the previous GPU Performance Monitoring sample of each
+// device, GPM metrics being computed from two samples
+type gpmSamples struct {
+	lock    sync.Mutex
+	samples map[string]nvml.GpmSample
+}
+
+// swap stores sample as the latest sample of the device with the given UUID
+// and returns the previous one, or nil if there is none
+func (g *gpmSamples) swap(uuid string, sample nvml.GpmSample) nvml.GpmSample {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.samples == nil {
+		g.samples = make(map[string]nvml.GpmSample)
+	}
+	previous := g.samples[uuid]
+	g.samples[uuid] = sample
+	return previous
+}
+
+// free releases the samples of all devices
+func (g *gpmSamples) free() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	for _, sample := range g.samples {
+		sample.Free()
+	}
+	g.samples = nil
+}
+
+// gpmMetricIDs are the GPM metrics queried by gpmMetricsByUUID, in the order
+// they are read
+var gpmMetricIDs = []nvml.GpmMetricId{
+	nvml.GPM_METRIC_SM_UTIL,
+	nvml.GPM_METRIC_ANY_TENSOR_UTIL,
+	nvml.GPM_METRIC_DRAM_BW_UTIL,
+	nvml.GPM_METRIC_NVLINK_TOTAL_RX_PER_SEC,
+	nvml.GPM_METRIC_NVLINK_TOTAL_TX_PER_SEC,
+}
+
+// p2pStatusByUUID returns the peer-to-peer capabilities between the GPUs
+// matching the given UUIDs. Capabilities the driver does not report are
+// reported as unsupported.
+func (n *nvmlDriver) p2pStatusByUUID(uuid, peerUUID string) (*P2PStatus, error) {
+	device, code := nvml.DeviceGetHandleByUUID(uuid)
+	if code != nvml.SUCCESS {
+		return nil, decode("failed to get device handle", code)
+	}
+	peer, code := nvml.DeviceGetHandleByUUID(peerUUID)
+	if code != nvml.SUCCESS {
+		return nil, decode("failed to get peer device handle", code)
+	}
+
+	status := &P2PStatus{UUID: uuid, PeerUUID: peerUUID}
+	for _, capability := range []struct {
+		index     nvml.GpuP2PCapsIndex
+		supported *bool
+	}{
+		{nvml.P2P_CAPS_INDEX_READ, &status.Read},
+		{nvml.P2P_CAPS_INDEX_WRITE, &status.Write},
+		{nvml.P2P_CAPS_INDEX_NVLINK, &status.NVLink},
+	} {
+		p2pStatus, code := nvml.DeviceGetP2PStatus(device, peer, capability.index)
+		switch code {
+		case nvml.SUCCESS:
+			*capability.supported = p2pStatus == nvml.P2P_STATUS_OK
+		case nvml.ERROR_NOT_SUPPORTED:
+		default:
+			return nil, decode("failed to get device p2p status", code)
+		}
+	}
+
+	level, code := nvml.DeviceGetTopologyCommonAncestor(device, peer)
+	switch code {
+	case nvml.SUCCESS:
+		status.PCIePath = topologyLevels[level]
+	case nvml.ERROR_NOT_SUPPORTED:
+	default:
+		return nil, decode("failed to get device topology common ancestor", code)
+	}
+
+	nvlinks, err := nvlinksTo(device, peer)
+	if err != nil {
+		return nil, err
+	}
+	status.NVLinks = nvlinks
+	return status, nil
+}
+
+// topologyLevels names the levels of the PCIe tree at which two GPUs can be
+// connected, from the closest to the farthest
+var topologyLevels = map[nvml.GpuTopologyLevel]string{
+	nvml.TOPOLOGY_INTERNAL:   "internal",
+	nvml.TOPOLOGY_SINGLE:     "single",
+	nvml.TOPOLOGY_MULTIPLE:   "multiple",
+	nvml.TOPOLOGY_HOSTBRIDGE: "hostbridge",
+	nvml.TOPOLOGY_NODE:       "node",
+	nvml.TOPOLOGY_SYSTEM:     "system",
+}
+
+// nvlinksTo returns the number of active NVLinks of device whose remote end
+// is peer. Links to NVSwitches are not counted.
+func nvlinksTo(device, peer nvml.Device) (uint, error) {
+	peerPCI, code := nvml.DeviceGetPciInfo(peer)
+	if code != nvml.SUCCESS {
+		return 0, decode("failed to get peer device pci info", code)
+	}
+	peerBusID := cString(peerPCI.BusId[:])
+
+	var nvlinks uint
+	for link := 0; link < nvml.NVLINK_MAX_LINKS; link++ {
+		state, code := nvml.DeviceGetNvLinkState(device, link)
+		switch code {
+		case nvml.SUCCESS:
+		case nvml.ERROR_NOT_SUPPORTED, nvml.ERROR_INVALID_ARGUMENT:
+			// devices without NVLinks, or without more links
+			return nvlinks, nil
+		default:
+			return 0, decode("failed to get device nvlink state", code)
+		}
+		if state != nvml.FEATURE_ENABLED {
+			continue
+		}
+
+		remote, code := nvml.DeviceGetNvLinkRemotePciInfo(device, link)
+		if code != nvml.SUCCESS {
+			continue
+		}
+		if strings.EqualFold(cString(remote.BusId[:]), peerBusID) {
+			nvlinks++
+		}
+	}
+	return nvlinks, nil
+}
+
+// gpmMetricsByUUID returns the GPU Performance Monitoring metrics of the GPU
+// matching the given UUID since the previous call. Nil metrics are returned
+// on the first call, which only takes the first sample. GPM is only supported
+// by Hopper and later GPUs, ErrNotSupported is returned for others.
+func (n *nvmlDriver) gpmMetricsByUUID(uuid string) (*GPMMetrics, error) {
+	device, code := nvml.DeviceGetHandleByUUID(uuid)
+	if code != nvml.SUCCESS {
+		return nil, decode("failed to get device handle", code)
+	}
+
+	if !hasSymbol("nvmlGpmQueryDeviceSupport") {
+		return nil, ErrNotSupported
+	}
+	support, code := nvml.GpmQueryDeviceSupport(device)
+	switch code {
+	case nvml.SUCCESS:
+		if support.IsSupportedDevice == 0 {
+			return nil, ErrNotSupported
+		}
+	case nvml.ERROR_NOT_SUPPORTED:
+		return nil, ErrNotSupported
+	default:
+		return nil, decode("failed to query device gpm support", code)
+	}
+
+	sample, code := nvml.GpmSampleAlloc()
+	if code != nvml.SUCCESS {
+		return nil, decode("failed to allocate gpm sample", code)
+	}
+	if code := nvml.GpmSampleGet(device, sample); code != nvml.SUCCESS {
+		sample.Free()
+		return nil, decode("failed to get device gpm sample", code)
+	}
+	previous := n.gpm.swap(uuid, sample)
+	if previous == nil {
+		return nil, nil
+	}
+	defer previous.Free()
+
+	query := &nvml.GpmMetricsGetType{
+		NumMetrics: uint32(len(gpmMetricIDs)),
+		Sample1:    previous,
+		Sample2:    sample,
+	}
+	for i, id := range gpmMetricIDs {
+		query.Metrics[i].MetricId = uint32(id)
+	}
+	if code := nvml.GpmMetricsGet(query); code != nvml.SUCCESS {
+		return nil, decode("failed to get device gpm metrics", code)
+	}
+
+	values := make([]*float64, len(gpmMetricIDs))
+	for i := range gpmMetricIDs {
+		if nvml.Return(query.Metrics[i].NvmlReturn) == nvml.SUCCESS {
+			values[i] = pointerOf(query.Metrics[i].Value)
+		}
+	}
+	metrics := &GPMMetrics{
+		SMActivity:     values[0],
+		TensorActivity: values[1],
+		DRAMBandwidth:  values[2],
+	}
+	if values[3] != nil && values[4] != nil {
+		bandwidth, err := n.nvlinkBandwidth(device)
+		if err != nil {
+			return nil, err
+		}
+		metrics.NVLinkBandwidth = nvlinkBandwidthUtilization(*values[3], *values[4], bandwidth)
+	}
+	return metrics, nil
+}
+
+// nvlinkBandwidth returns the bandwidth of all NVLinks of device in MB/s per
+// direction, or zero when the device has no NVLinks
+func (n *nvmlDriver) nvlinkBandwidth(device nvml.Device) (uint64, error) {
+	if n.fieldValuesUnsupported.Load() {
+		return 0, nil
+	}
+
+	values := []nvml.FieldValue{
+		{FieldId: nvml.FI_DEV_NVLINK_LINK_COUNT},
+		{FieldId: nvml.FI_DEV_NVLINK_SPEED_MBPS_COMMON},
+	}
+	switch code := nvml.DeviceGetFieldValues(device, values); code {
+	case nvml.SUCCESS:
+	case nvml.ERROR_NOT_SUPPORTED:
+		return 0, nil
+	default:
+		return 0, decode("failed to get device nvlink speed", code)
+	}
+
+	links, linksOK := fieldValue(values[0])
+	speed, speedOK := fieldValue(values[1])
+	if !linksOK || !speedOK {
+		return 0, nil
+	}
+	return uint64(links) * uint64(speed), nil
+}
+
+// nvlinkBandwidthUtilization returns the percentage of the NVLink bandwidth
+// used by the given receive and transmit throughputs in MiB/s, or nil when the
+// device has no NVLink bandwidth
+func nvlinkBandwidthUtilization(rxMiBps, txMiBps float64, bandwidthMBps uint64) *float64 {
+	if bandwidthMBps == 0 {
+		return nil
+	}
+	const bytesPerMiB, bytesPerMB = 1 << 20, 1e6
+	used := (rxMiBps + txMiBps) * bytesPerMiB
+	available := 2 * float64(bandwidthMBps) * bytesPerMB
+	return pointerOf(min(100, used/available*100))
+}
+
+// fatalErrorByUUID returns a description of the fatal error the GPU matching
+// the given UUID is in, such as having fallen off the bus or uncorrectable ECC
+// errors, or an empty string if it has none. MIG instances report the errors
+// of their physical GPU.
+func (n *nvmlDriver) fatalErrorByUUID(uuid string) (string, error) {
+	device, code := nvml.DeviceGetHandleByUUID(uuid)
+	if code == nvml.ERROR_GPU_IS_LOST {
+		return "GPU has fallen off the bus", nil
+	}
+	if code != nvml.SUCCESS {
+		return "", decode("failed to get device handle", code)
+	}
+
+	parentDevice, code := nvml.DeviceGetDeviceHandleFromMigDeviceHandle(device)
+	if code == nvml.SUCCESS {
+		device = parentDevice
+	}
+
+	uncorrected, code := nvml.DeviceGetTotalEccErrors(device, nvml.MEMORY_ERROR_TYPE_UNCORRECTED, nvml.VOLATILE_ECC)
+	switch code {
+	case nvml.SUCCESS:
+		if uncorrected != 0 {
+			return fmt.Sprintf("%d uncorrectable ECC errors", uncorrected), nil
+		}
+	case nvml.ERROR_GPU_IS_LOST:
+		return "GPU has fallen off the bus", nil
+	case nvml.ERROR_NOT_SUPPORTED:
+	default:
+		return "", decode("failed to get device uncorrectable ecc errors", code)
+	}
+	return "", nil
+}
+
+// preflightByUUID runs sanity checks on the device matching the given UUID:
+// the device must be opened, report its memory and PCI information, and allow
+// compute work. Checks the device does not support are skipped.
+func (n *nvmlDriver) preflightByUUID(uuid string) error {
+	device, code := nvml.DeviceGetHandleByUUID(uuid)
+	if code != nvml.SUCCESS {
+		return decode("failed to open device", code)
+	}
+
+	memory, code := nvml.DeviceGetMemoryInfo(device)
+	switch code {
+	case nvml.SUCCESS:
+		if memory.Total == 0 {
+			return fmt.Errorf("device reports no memory")
+		}
+	case nvml.ERROR_NOT_SUPPORTED:
+	default:
+		return decode("failed to get device memory info", code)
+	}
+
+	if _, code := nvml.DeviceGetPciInfo(device); code != nvml.SUCCESS && code != nvml.ERROR_NOT_SUPPORTED {
+		return decode("failed to get device pci info", code)
+	}
+
+	computeMode, code := nvml.DeviceGetComputeMode(device)
+	switch code {
+	case nvml.SUCCESS:
+		if computeMode == nvml.COMPUTEMODE_PROHIBITED {
+			return fmt.Errorf("device compute mode prohibits compute work")
+		}
+	case nvml.ERROR_NOT_SUPPORTED:
+	default:
+		return decode("failed to get device compute mode", code)
+	}
+	return nil
+}
+
+// utilizationSamplesByUUID returns the GPU utilization samples, in percent,
+// NVML took on the device matching the given UUID after lastSeen, along with
+// the timestamp of the latest sample. Timestamps are in microseconds since
+// the epoch, zero returns all buffered samples. Devices that do not support
+// sampling return no samples.
+func (n *nvmlDriver) utilizationSamplesByUUID(uuid string, lastSeen uint64) ([]uint, uint64, error) {
+	device, code := nvml.DeviceGetHandleByUUID(uuid)
+	if code != nvml.SUCCESS {
+		return nil, 0, decode("failed to get device info", code)
+	}
+
+	valueType, samples, code := nvml.DeviceGetSamples(device, nvml.GPU_UTILIZATION_SAMPLES, lastSeen)
+	switch code {
+	case nvml.SUCCESS:
+	case nvml.ERROR_NOT_SUPPORTED, nvml.ERROR_NOT_FOUND:
+		// ERROR_NOT_FOUND is returned when no sample was taken since lastSeen
+		return nil, lastSeen, nil
+	default:
+		return nil, 0, decode("failed to get device utilization samples", code)
+	}
+
+	latest := lastSeen
+	values := make([]uint, 0, len(samples))
+	for _, sample := range samples {
+		if sample.TimeStamp <= lastSeen {
+			continue
+		}
+		value, ok := sampleValue(valueType, sample.SampleValue)
+		if !ok {
+			continue
+		}
+		values = append(values, value)
+		latest = max(latest, sample.TimeStamp)
+	}
+	return values, latest, nil
+}
+
+// sampleValue decodes the value of an NVML sample or field value of the given
+// type
+func sampleValue(valueType nvml.ValueType, value [8]byte) (uint, bool) {
+	switch valueType {
+	case nvml.VALUE_TYPE_UNSIGNED_INT, nvml.VALUE_TYPE_SIGNED_INT:
+		return uint(binary.NativeEndian.Uint32(value[:4])), true
+	case nvml.VALUE_TYPE_UNSIGNED_LONG, nvml.VALUE_TYPE_UNSIGNED_LONG_LONG, nvml.VALUE_TYPE_SIGNED_LONG_LONG:
+		return uint(binary.NativeEndian.Uint64(value[:])), true
+	}
+	return 0, false
+}
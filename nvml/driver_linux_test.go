@@ -0,0 +1,101 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build linux
+
+package nvml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/hashicorp/go-hclog"
+	"github.com/shoenig/test/must"
+)
+
+func TestLogCall(t *testing.T) {
+	var out bytes.Buffer
+	driver := &nvmlDriver{logger: hclog.New(&hclog.LoggerOptions{Output: &out, Level: hclog.Debug})}
+
+	// successful calls are only logged at trace level
+	driver.logCall("ListDeviceUUIDs", "", time.Millisecond, nil)
+	must.Eq(t, "", out.String())
+
+	err := decode("failed to get device handle", nvml.ERROR_TIMEOUT)
+	driver.logCall("DeviceInfoByUUID", "UUID1", 2*time.Second, err)
+	must.StrContains(t, out.String(), "NVML call failed")
+	must.StrContains(t, out.String(), "operation=DeviceInfoByUUID")
+	must.StrContains(t, out.String(), "uuid=UUID1")
+	must.StrContains(t, out.String(), "code=10")
+
+	out.Reset()
+	driver.logger.SetLevel(hclog.Trace)
+	driver.logCall("ListDeviceUUIDs", "", time.Millisecond, nil)
+	must.StrContains(t, out.String(), "NVML call succeeded")
+	must.StrNotContains(t, out.String(), "uuid=")
+
+	// drivers without logger do not log
+	(&nvmlDriver{}).logCall("ListDeviceUUIDs", "", time.Millisecond, err)
+}
+
+func TestLogCallCoalesced(t *testing.T) {
+	var out bytes.Buffer
+	driver := &nvmlDriver{logger: hclog.New(&hclog.LoggerOptions{Output: &out, Level: hclog.Debug})}
+	now := time.Unix(0, 0)
+	driver.failedCalls.Store(NewLogLimiter(time.Minute, func() time.Time { return now }))
+
+	// calls failing with the same return code are coalesced per device
+	for i := 0; i < 3; i++ {
+		driver.logCall("DeviceInfoByUUID", "UUID1", time.Millisecond,
+			decode(fmt.Sprintf("failed to get device %d", i), nvml.ERROR_UNKNOWN))
+	}
+	driver.logCall("DeviceInfoByUUID", "UUID2", time.Millisecond, decode("failed to get device", nvml.ERROR_UNKNOWN))
+	driver.logCall("DeviceInfoByUUID", "UUID1", time.Millisecond, decode("failed to get device", nvml.ERROR_GPU_IS_LOST))
+	must.Eq(t, 3, strings.Count(out.String(), "\n"))
+
+	// the suppressed calls are logged once the calls succeed again
+	out.Reset()
+	now = now.Add(time.Minute)
+	driver.logCall("DeviceInfoByUUID", "UUID1", time.Millisecond, nil)
+	must.Eq(t, 1, strings.Count(out.String(), "\n"))
+	must.StrContains(t, out.String(), "code=999")
+	must.StrContains(t, out.String(), "suppressed=2")
+}
+
+func TestOptionalAttribute(t *testing.T) {
+	var out bytes.Buffer
+	driver := &nvmlDriver{logger: hclog.New(&hclog.LoggerOptions{Output: &out})}
+
+	// successful queries are not logged
+	driver.optionalAttribute("UUID1", nil)
+	must.Eq(t, "", out.String())
+
+	// failed queries are logged as warnings
+	driver.optionalAttribute("UUID1", decode("failed to get device brand", nvml.ERROR_NO_PERMISSION))
+	must.StrContains(t, out.String(), "[WARN]")
+	must.StrContains(t, out.String(), "uuid=UUID1")
+	must.StrContains(t, out.String(), "ERROR_NO_PERMISSION")
+}
+
+func TestCallErrorUnresponsive(t *testing.T) {
+	must.True(t, unresponsive(decode("failed to get device handle", nvml.ERROR_TIMEOUT)))
+	must.True(t, unresponsive(decode("failed to get device handle", nvml.ERROR_IRQ_ISSUE)))
+	must.False(t, unresponsive(decode("failed to get device handle", nvml.ERROR_UNKNOWN)))
+	must.False(t, errors.Is(decode("failed to get device handle", nvml.ERROR_TIMEOUT), errQuarantined))
+}
+
+func TestNVLinkBandwidthUtilization(t *testing.T) {
+	// 18 links of 25000 MB/s per direction
+	utilization := nvlinkBandwidthUtilization(200000/1.048576, 250000/1.048576, 18*25000)
+	must.NotNil(t, utilization)
+	must.Eq(t, 50.0, math.Round(*utilization))
+
+	must.Eq(t, 100.0, *nvlinkBandwidthUtilization(1e6, 1e6, 1000))
+	must.Nil(t, nvlinkBandwidthUtilization(100, 100, 0))
+}
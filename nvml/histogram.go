@@ -0,0 +1,142 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvml
+
+import (
+	"fmt"
+	"slices"
+)
+
+// maxStatsSamples bounds the number of samples kept per device and metric
+// between stats queries, older samples are dropped first
+const maxStatsSamples = 3600
+
+// Histogram summarizes the values of a metric sampled between stats queries
+type Histogram struct {
+	P50 uint
+	P95 uint
+	Max uint
+}
+
+// DeviceSample is a reading of the metrics of a device summarized in
+// histograms, fields are nil when the device does not support them
+type DeviceSample struct {
+	GPUUtilization *uint // %
+	PowerUsageMW   *uint
+	TemperatureC   *uint
+}
+
+// deviceSamples holds the values of each metric of a device sampled since
+// the previous stats query
+type deviceSamples struct {
+	gpuUtilization []uint
+	powerUsageMW   []uint
+	temperatureC   []uint
+}
+
+// add appends the values of sample that are set
+func (s *deviceSamples) add(sample *DeviceSample) {
+	s.gpuUtilization = appendSample(s.gpuUtilization, sample.GPUUtilization)
+	s.powerUsageMW = appendSample(s.powerUsageMW, sample.PowerUsageMW)
+	s.temperatureC = appendSample(s.temperatureC, sample.TemperatureC)
+}
+
+func appendSample(samples []uint, value *uint) []uint {
+	if value == nil {
+		return samples
+	}
+	if len(samples) == maxStatsSamples {
+		samples = slices.Delete(samples, 0, 1)
+	}
+	return append(samples, *value)
+}
+
+// histogram returns the percentiles and maximum of samples, nil when there
+// are none. Percentiles use the nearest rank method, so they are values that
+// were actually sampled.
+func histogram(samples []uint) *Histogram {
+	if len(samples) == 0 {
+		return nil
+	}
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+	return &Histogram{
+		P50: sorted[nearestRank(len(sorted), 50)],
+		P95: sorted[nearestRank(len(sorted), 95)],
+		Max: sorted[len(sorted)-1],
+	}
+}
+
+// nearestRank returns the index of the given percentile in n sorted samples
+func nearestRank(n, percentile int) int {
+	rank := (percentile*n + 99) / 100
+	return max(rank, 1) - 1
+}
+
+// SampleStats samples the GPU utilization, power usage and temperature of
+// every device, so that the next stats query reports their distribution
+// since the previous one. Devices that are MIG instances, quarantined or whose
+// circuit breaker is open are not sampled.
+func (c *nvmlClient) SampleStats() error {
+	ctx, cancel := c.callContext()
+	deviceUUIDs, err := c.driver.ListDeviceUUIDs(ctx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("nvidia nvml ListDeviceUUIDs() error: %v\n", err)
+	}
+
+	for _, identity := range deviceUUIDs {
+		if identity.Mode == mig || identity.Mode == parent || !c.quarantine.allow(identity.UUID) || !c.breakers.allow(identity.UUID) {
+			continue
+		}
+		ctx, cancel := c.callContext()
+		sample, err := c.driver.SampleByUUID(ctx, identity.UUID)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("nvidia nvml SampleByUUID() error: %v\n", err)
+		}
+		c.addStatsSample(identity.UUID, sample)
+	}
+	return nil
+}
+
+// addStatsSample records sample for the device with the given UUID
+func (c *nvmlClient) addStatsSample(uuid string, sample *DeviceSample) {
+	c.statsSamplesLock.Lock()
+	defer c.statsSamplesLock.Unlock()
+
+	if c.statsSamples == nil {
+		c.statsSamples = make(map[string]*deviceSamples)
+	}
+	samples, ok := c.statsSamples[uuid]
+	if !ok {
+		samples = &deviceSamples{}
+		c.statsSamples[uuid] = samples
+	}
+	samples.add(sample)
+}
+
+// statsHistograms returns the histograms of the GPU utilization, power usage
+// and temperature of the device with the given UUID, including the values
+// of status read by the current stats query, and starts the samples of the
+// next query. They are nil for devices that were never sampled.
+func (c *nvmlClient) statsHistograms(uuid string, status *DeviceStatus) (gpuUtilization, powerUsageMW, temperatureC *Histogram) {
+	c.statsSamplesLock.Lock()
+	defer c.statsSamplesLock.Unlock()
+
+	samples, ok := c.statsSamples[uuid]
+	if !ok {
+		return nil, nil, nil
+	}
+	samples.add(&DeviceSample{
+		GPUUtilization: status.GPUUtilization,
+		PowerUsageMW:   status.PowerUsageMW,
+		TemperatureC:   status.TemperatureC,
+	})
+	gpuUtilization = histogram(samples.gpuUtilization)
+	powerUsageMW = histogram(samples.powerUsageMW)
+	temperatureC = histogram(samples.temperatureC)
+	c.statsSamples[uuid] = &deviceSamples{}
+	return gpuUtilization, powerUsageMW, temperatureC
+}
@@ -0,0 +1,56 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvml
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// uuidPattern matches the UUIDs of GPUs, such as
+	// GPU-5d6e0a7c-2b3f-4c7e-9d1a-0b2c3d4e5f60, and of MIG devices, such as
+	// MIG-5d6e0a7c-2b3f-4c7e-9d1a-0b2c3d4e5f60 or the legacy
+	// MIG-GPU-5d6e0a7c-2b3f-4c7e-9d1a-0b2c3d4e5f60/1/0 of drivers before R470
+	uuidPattern      = regexp.MustCompile(`^(GPU|MIG)-[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	legacyMIGPattern = regexp.MustCompile(`^MIG-GPU-[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}/[0-9]{1,3}/[0-9]{1,3}$`)
+
+	// pciBusIDPattern matches PCI bus IDs in the domain:bus:device.function
+	// format reported by NVML, such as 00000000:3B:00.0, or with the legacy
+	// 4 digits domain, such as 0000:3B:00.0
+	pciBusIDPattern = regexp.MustCompile(`^([0-9a-fA-F]{4}|[0-9a-fA-F]{8}):[0-9a-fA-F]{2}:[0-9a-fA-F]{2}\.[0-7]$`)
+)
+
+// cString converts a NUL terminated string returned by NVML in a fixed size
+// array, ignoring the bytes after the first NUL
+func cString(s []int8) string {
+	b := make([]byte, 0, len(s))
+	for _, c := range s {
+		if c == 0 {
+			break
+		}
+		b = append(b, byte(c))
+	}
+	return string(b)
+}
+
+// parseUUID validates a device UUID reported by NVML, which becomes the ID of
+// the device in Nomad
+func parseUUID(uuid string) (string, error) {
+	if !uuidPattern.MatchString(uuid) && !legacyMIGPattern.MatchString(uuid) {
+		return "", fmt.Errorf("malformed device UUID %q", uuid)
+	}
+	return uuid, nil
+}
+
+// parsePCIBusID validates a PCI bus ID reported by NVML, ignoring
+// surrounding whitespace
+func parsePCIBusID(busID string) (string, error) {
+	busID = strings.TrimSpace(busID)
+	if !pciBusIDPattern.MatchString(busID) {
+		return "", fmt.Errorf("malformed PCI bus ID %q", busID)
+	}
+	return busID, nil
+}
@@ -0,0 +1,200 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvml
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestParseUUID(t *testing.T) {
+	cases := []struct {
+		Name  string
+		UUID  string
+		Valid bool
+	}{
+		{
+			Name:  "gpu",
+			UUID:  "GPU-5d6e0a7c-2b3f-4c7e-9d1a-0b2c3d4e5f60",
+			Valid: true,
+		},
+		{
+			Name:  "mig",
+			UUID:  "MIG-5d6e0a7c-2b3f-4c7e-9d1a-0b2c3d4e5f60",
+			Valid: true,
+		},
+		{
+			Name:  "legacy mig",
+			UUID:  "MIG-GPU-5d6e0a7c-2b3f-4c7e-9d1a-0b2c3d4e5f60/1/0",
+			Valid: true,
+		},
+		{
+			Name: "legacy mig without instances",
+			UUID: "MIG-GPU-5d6e0a7c-2b3f-4c7e-9d1a-0b2c3d4e5f60",
+		},
+		{
+			Name: "empty",
+			UUID: "",
+		},
+		{
+			Name: "unknown prefix",
+			UUID: "VGPU-5d6e0a7c-2b3f-4c7e-9d1a-0b2c3d4e5f60",
+		},
+		{
+			Name: "truncated",
+			UUID: "GPU-5d6e0a7c-2b3f-4c7e-9d1a-0b2c3d4e",
+		},
+		{
+			Name: "trailing NUL",
+			UUID: "GPU-5d6e0a7c-2b3f-4c7e-9d1a-0b2c3d4e5f60\x00",
+		},
+		{
+			Name: "not hexadecimal",
+			UUID: "GPU-5d6e0a7c-2b3f-4c7e-9d1a-0b2c3d4e5fzz",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			uuid, err := parseUUID(c.UUID)
+			if c.Valid {
+				must.NoError(t, err)
+				must.Eq(t, c.UUID, uuid)
+			} else {
+				must.Error(t, err)
+				must.Eq(t, "", uuid)
+			}
+		})
+	}
+}
+
+func TestParsePCIBusID(t *testing.T) {
+	cases := []struct {
+		Name     string
+		BusID    string
+		Expected string
+		Valid    bool
+	}{
+		{
+			Name:     "8 digits domain",
+			BusID:    "00000000:3B:00.0",
+			Expected: "00000000:3B:00.0",
+			Valid:    true,
+		},
+		{
+			Name:     "4 digits domain",
+			BusID:    "0000:3b:00.1",
+			Expected: "0000:3b:00.1",
+			Valid:    true,
+		},
+		{
+			Name:     "surrounding whitespace",
+			BusID:    " 00000000:3B:00.0\n",
+			Expected: "00000000:3B:00.0",
+			Valid:    true,
+		},
+		{
+			Name:  "empty",
+			BusID: "",
+		},
+		{
+			Name:  "missing function",
+			BusID: "00000000:3B:00",
+		},
+		{
+			Name:  "invalid function",
+			BusID: "00000000:3B:00.8",
+		},
+		{
+			Name:  "embedded NUL",
+			BusID: "00000000:3B\x00:00.0",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			busID, err := parsePCIBusID(c.BusID)
+			if c.Valid {
+				must.NoError(t, err)
+			} else {
+				must.Error(t, err)
+			}
+			must.Eq(t, c.Expected, busID)
+		})
+	}
+}
+
+func TestCString(t *testing.T) {
+	var busID [32]int8
+	for i, c := range "00000000:3B:00.0" {
+		busID[i] = int8(c)
+	}
+	busID[20] = 'x'
+	must.Eq(t, "00000000:3B:00.0", cString(busID[:]))
+
+	must.Eq(t, "", cString(nil))
+	must.Eq(t, "abc", cString([]int8{'a', 'b', 'c'}))
+}
+
+// validID reports whether id is safe to hand to Nomad as an identifier
+func validID(id string) bool {
+	return id != "" && !strings.ContainsFunc(id, func(r rune) bool {
+		return r > unicode.MaxASCII || unicode.IsSpace(r) || unicode.IsControl(r)
+	})
+}
+
+func FuzzParseUUID(f *testing.F) {
+	f.Add("GPU-5d6e0a7c-2b3f-4c7e-9d1a-0b2c3d4e5f60")
+	f.Add("MIG-5d6e0a7c-2b3f-4c7e-9d1a-0b2c3d4e5f60")
+	f.Add("MIG-GPU-5d6e0a7c-2b3f-4c7e-9d1a-0b2c3d4e5f60/1/0")
+	f.Add("GPU-5d6e0a7c-2b3f-4c7e-9d1a-0b2c3d4e5f60\x00\x00")
+	f.Add("")
+	f.Fuzz(func(t *testing.T, s string) {
+		uuid, err := parseUUID(s)
+		if err != nil {
+			must.Eq(t, "", uuid)
+			return
+		}
+		must.True(t, validID(uuid), must.Sprintf("invalid UUID %q accepted", uuid))
+
+		again, err := parseUUID(uuid)
+		must.NoError(t, err)
+		must.Eq(t, uuid, again)
+	})
+}
+
+func FuzzParsePCIBusID(f *testing.F) {
+	f.Add("00000000:3B:00.0")
+	f.Add("0000:3b:00.1")
+	f.Add("00000000:3B:00.0\x00\x00\x00")
+	f.Add("")
+	f.Fuzz(func(t *testing.T, s string) {
+		busID, err := parsePCIBusID(s)
+		if err != nil {
+			must.Eq(t, "", busID)
+			return
+		}
+		must.True(t, validID(busID), must.Sprintf("invalid PCI bus ID %q accepted", busID))
+
+		again, err := parsePCIBusID(busID)
+		must.NoError(t, err)
+		must.Eq(t, busID, again)
+	})
+}
+
+func FuzzCString(f *testing.F) {
+	f.Add([]byte("00000000:3B:00.0\x00\x00garbage"))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		s := make([]int8, len(b))
+		for i, c := range b {
+			s[i] = int8(c)
+		}
+
+		str := cString(s)
+		must.False(t, strings.ContainsRune(str, 0))
+		must.True(t, strings.HasPrefix(string(b), str))
+	})
+}
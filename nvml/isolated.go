@@ -0,0 +1,514 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvml
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// isolatedWorkerEnv is set in the environment of worker processes started
+// by the isolated client
+const isolatedWorkerEnv = "NOMAD_DEVICE_NVIDIA_NVML_WORKER"
+
+// isolatedServiceName is the RPC service name of the worker
+const isolatedServiceName = "NVML"
+
+// IsIsolatedWorker reports whether the current process was started as the
+// NVML worker of an isolated client, in which case RunIsolatedWorker must be
+// called instead of serving the plugin
+func IsIsolatedWorker() bool {
+	return os.Getenv(isolatedWorkerEnv) == "1"
+}
+
+// RunIsolatedWorker serves NVML calls of the parent plugin process over the
+// standard input and output until the parent closes them
+func RunIsolatedWorker() error {
+	return serveWorker(stdioConn{}, func(args InitializeArgs) (NvmlClient, error) {
+		// the parent plugin relays JSON log lines written to stderr
+		logger := hclog.New(&hclog.LoggerOptions{
+			Name:       "nvml",
+			Level:      hclog.LevelFromString(args.LogLevel),
+			Output:     os.Stderr,
+			JSONFormat: true,
+		})
+		return NewNvmlClient(args.LibraryPath, logger)
+	})
+}
+
+// stdioConn is the connection of a worker to its parent process
+type stdioConn struct{}
+
+func (stdioConn) Read(p []byte) (int, error)  { return os.Stdin.Read(p) }
+func (stdioConn) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+func (stdioConn) Close() error                { return os.Stdin.Close() }
+
+// serveWorker serves NVML calls over conn, creating the NVML client with
+// newClient once the parent initializes the worker
+func serveWorker(conn io.ReadWriteCloser, newClient func(InitializeArgs) (NvmlClient, error)) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName(isolatedServiceName, &workerService{newClient: newClient}); err != nil {
+		return err
+	}
+	server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	return nil
+}
+
+// InitializeArgs are the arguments of the worker Initialize call
+type InitializeArgs struct {
+	LibraryPath         string
+	Breaker             BreakerConfig
+	UtilizationSampling bool
+	GPMMetrics          bool
+	ErrorLogInterval    time.Duration
+
+	// LogLevel is the level NVML calls are logged at by the worker
+	LogLevel string
+}
+
+// ResetDeviceArgs are the arguments of the worker ResetDevice call
+type ResetDeviceArgs struct {
+	UUID string
+	Full bool
+}
+
+// workerService exposes an NvmlClient over RPC
+type workerService struct {
+	newClient func(InitializeArgs) (NvmlClient, error)
+	client    NvmlClient
+}
+
+func (s *workerService) Initialize(args InitializeArgs, _ *struct{}) error {
+	if s.client != nil {
+		return nil
+	}
+	client, err := s.newClient(args)
+	if err != nil {
+		return err
+	}
+	client.SetBreakerConfig(args.Breaker)
+	client.SetUtilizationSampling(args.UtilizationSampling)
+	client.SetGPMMetrics(args.GPMMetrics)
+	client.SetErrorLogInterval(args.ErrorLogInterval)
+	s.client = client
+	return nil
+}
+
+func (s *workerService) initialized() error {
+	if s.client == nil {
+		return errors.New("NVML worker is not initialized")
+	}
+	return nil
+}
+
+func (s *workerService) GetFingerprintData(_ struct{}, reply *FingerprintData) error {
+	if err := s.initialized(); err != nil {
+		return err
+	}
+	data, err := s.client.GetFingerprintData()
+	if err != nil {
+		return err
+	}
+	*reply = *data
+	return nil
+}
+
+func (s *workerService) GetStatsData(_ struct{}, reply *[]*StatsData) error {
+	if err := s.initialized(); err != nil {
+		return err
+	}
+	data, err := s.client.GetStatsData()
+	*reply = data
+	return err
+}
+
+func (s *workerService) ResetDevice(args ResetDeviceArgs, _ *struct{}) error {
+	if err := s.initialized(); err != nil {
+		return err
+	}
+	return s.client.ResetDevice(args.UUID, args.Full)
+}
+
+func (s *workerService) GetComputeProcesses(uuid string, reply *[]int) error {
+	if err := s.initialized(); err != nil {
+		return err
+	}
+	pids, err := s.client.GetComputeProcesses(uuid)
+	*reply = pids
+	return err
+}
+
+func (s *workerService) EnableAccounting(uuid string, _ *struct{}) error {
+	if err := s.initialized(); err != nil {
+		return err
+	}
+	return s.client.EnableAccounting(uuid)
+}
+
+func (s *workerService) GetAccountingStats(uuid string, reply *[]*AccountingStats) error {
+	if err := s.initialized(); err != nil {
+		return err
+	}
+	stats, err := s.client.GetAccountingStats(uuid)
+	*reply = stats
+	return err
+}
+
+func (s *workerService) ClearAccounting(uuid string, _ *struct{}) error {
+	if err := s.initialized(); err != nil {
+		return err
+	}
+	return s.client.ClearAccounting(uuid)
+}
+
+func (s *workerService) GetEnergyConsumption(uuid string, reply *uint64) error {
+	if err := s.initialized(); err != nil {
+		return err
+	}
+	energy, err := s.client.GetEnergyConsumption(uuid)
+	*reply = energy
+	return err
+}
+
+func (s *workerService) SetBreakerConfig(config BreakerConfig, _ *struct{}) error {
+	if err := s.initialized(); err != nil {
+		return err
+	}
+	s.client.SetBreakerConfig(config)
+	return nil
+}
+
+func (s *workerService) SetUtilizationSampling(enabled bool, _ *struct{}) error {
+	if err := s.initialized(); err != nil {
+		return err
+	}
+	s.client.SetUtilizationSampling(enabled)
+	return nil
+}
+
+func (s *workerService) SetGPMMetrics(enabled bool, _ *struct{}) error {
+	if err := s.initialized(); err != nil {
+		return err
+	}
+	s.client.SetGPMMetrics(enabled)
+	return nil
+}
+
+func (s *workerService) SetErrorLogInterval(interval time.Duration, _ *struct{}) error {
+	if err := s.initialized(); err != nil {
+		return err
+	}
+	s.client.SetErrorLogInterval(interval)
+	return nil
+}
+
+func (s *workerService) SampleStats(_ struct{}, _ *struct{}) error {
+	if err := s.initialized(); err != nil {
+		return err
+	}
+	return s.client.SampleStats()
+}
+
+func (s *workerService) Preflight(uuid string, _ *struct{}) error {
+	if err := s.initialized(); err != nil {
+		return err
+	}
+	return s.client.Preflight(uuid)
+}
+
+func (s *workerService) GetFatalError(uuid string, reply *string) error {
+	if err := s.initialized(); err != nil {
+		return err
+	}
+	reason, err := s.client.GetFatalError(uuid)
+	*reply = reason
+	return err
+}
+
+// isolatedClient implements NvmlClient by forwarding every call to a worker
+// process, so that crashes of the NVML library do not take the plugin down.
+// A worker that exits is restarted on the next call.
+type isolatedClient struct {
+	libraryPath string
+
+	// logLevel is the level NVML calls are logged at by workers
+	logLevel string
+
+	// start starts a worker and returns the connection to it, closing the
+	// connection stops the worker
+	start func() (io.ReadWriteCloser, error)
+
+	lock                sync.Mutex
+	client              *rpc.Client
+	breaker             BreakerConfig
+	utilizationSampling bool
+	gpmMetrics          bool
+	errorLogInterval    time.Duration
+}
+
+// NewIsolatedNvmlClient creates an NvmlClient running NVML in a worker
+// process, which re-executes the plugin binary. The NVML library is loaded
+// from libraryPath, or from the default library search path when empty.
+// Workers log NVML calls at the level of logger.
+func NewIsolatedNvmlClient(libraryPath string, logger hclog.Logger) (*isolatedClient, error) {
+	c := &isolatedClient{
+		libraryPath: libraryPath,
+		logLevel:    logger.GetLevel().String(),
+		start:       startWorkerProcess,
+	}
+	if _, err := c.worker(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// workerProcess is the connection to a worker process
+type workerProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+}
+
+func (p *workerProcess) Read(b []byte) (int, error)  { return p.stdout.Read(b) }
+func (p *workerProcess) Write(b []byte) (int, error) { return p.stdin.Write(b) }
+
+// Close stops the worker process
+func (p *workerProcess) Close() error {
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+	return nil
+}
+
+// startWorkerProcess re-executes the plugin binary as an NVML worker
+func startWorkerProcess() (io.ReadWriteCloser, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find plugin executable: %v", err)
+	}
+
+	cmd := exec.Command(executable)
+	cmd.Env = append(os.Environ(), isolatedWorkerEnv+"=1")
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start NVML worker: %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start NVML worker: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start NVML worker: %v", err)
+	}
+	return &workerProcess{cmd: cmd, stdin: stdin, stdout: stdout}, nil
+}
+
+// worker returns the RPC client of the running worker, starting and
+// initializing a new worker if none is running
+func (c *isolatedClient) worker() (*rpc.Client, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.client != nil {
+		return c.client, nil
+	}
+
+	conn, err := c.start()
+	if err != nil {
+		return nil, err
+	}
+	client := jsonrpc.NewClient(conn)
+	args := InitializeArgs{
+		LibraryPath:         c.libraryPath,
+		Breaker:             c.breaker,
+		UtilizationSampling: c.utilizationSampling,
+		GPMMetrics:          c.gpmMetrics,
+		ErrorLogInterval:    c.errorLogInterval,
+		LogLevel:            c.logLevel,
+	}
+	if err := client.Call(isolatedServiceName+".Initialize", args, &struct{}{}); err != nil {
+		client.Close()
+		return nil, workerError(err)
+	}
+	c.client = client
+	return client, nil
+}
+
+// call calls method on the worker. When the worker exited, it is discarded so
+// that the next call starts a new one.
+func (c *isolatedClient) call(method string, args, reply any) error {
+	client, err := c.worker()
+	if err != nil {
+		return err
+	}
+
+	// errors other than those returned by the worker mean that the
+	// connection to the worker failed
+	err = client.Call(isolatedServiceName+"."+method, args, reply)
+	var serverErr rpc.ServerError
+	if err != nil && !errors.As(err, &serverErr) {
+		c.lock.Lock()
+		if c.client == client {
+			c.client = nil
+		}
+		c.lock.Unlock()
+		client.Close()
+		return fmt.Errorf("NVML worker exited, it will be restarted: %v", err)
+	}
+	return workerError(err)
+}
+
+// workerError converts errors returned by the worker back to the errors of
+// this package they stand for
+func workerError(err error) error {
+	var serverErr rpc.ServerError
+	if !errors.As(err, &serverErr) {
+		return err
+	}
+	switch msg := string(serverErr); {
+	case msg == UnavailableLib.Error():
+		return UnavailableLib
+	case msg == ErrNotSupported.Error():
+		return ErrNotSupported
+	case strings.HasPrefix(msg, UnavailableLib.Error()+" "):
+		// a configured library that could not be loaded
+		return &permanentError{errors.New(msg)}
+	}
+	return errors.New(string(serverErr))
+}
+
+func (c *isolatedClient) GetFingerprintData() (*FingerprintData, error) {
+	var reply FingerprintData
+	if err := c.call("GetFingerprintData", struct{}{}, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+func (c *isolatedClient) GetStatsData() ([]*StatsData, error) {
+	var reply []*StatsData
+	if err := c.call("GetStatsData", struct{}{}, &reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (c *isolatedClient) ResetDevice(uuid string, full bool) error {
+	return c.call("ResetDevice", ResetDeviceArgs{UUID: uuid, Full: full}, &struct{}{})
+}
+
+func (c *isolatedClient) GetComputeProcesses(uuid string) ([]int, error) {
+	var reply []int
+	if err := c.call("GetComputeProcesses", uuid, &reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (c *isolatedClient) EnableAccounting(uuid string) error {
+	return c.call("EnableAccounting", uuid, &struct{}{})
+}
+
+func (c *isolatedClient) GetAccountingStats(uuid string) ([]*AccountingStats, error) {
+	var reply []*AccountingStats
+	if err := c.call("GetAccountingStats", uuid, &reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (c *isolatedClient) ClearAccounting(uuid string) error {
+	return c.call("ClearAccounting", uuid, &struct{}{})
+}
+
+func (c *isolatedClient) GetEnergyConsumption(uuid string) (uint64, error) {
+	var reply uint64
+	if err := c.call("GetEnergyConsumption", uuid, &reply); err != nil {
+		return 0, err
+	}
+	return reply, nil
+}
+
+func (c *isolatedClient) GetFatalError(uuid string) (string, error) {
+	var reply string
+	if err := c.call("GetFatalError", uuid, &reply); err != nil {
+		return "", err
+	}
+	return reply, nil
+}
+
+func (c *isolatedClient) Preflight(uuid string) error {
+	return c.call("Preflight", uuid, &struct{}{})
+}
+
+// SetBreakerConfig sets the circuit breaker configuration of the running
+// worker and of the workers started later
+func (c *isolatedClient) SetBreakerConfig(config BreakerConfig) {
+	c.lock.Lock()
+	c.breaker = config
+	c.lock.Unlock()
+
+	// a worker that failed is initialized with the new config on restart
+	c.call("SetBreakerConfig", config, &struct{}{})
+}
+
+// SetUtilizationSampling sets whether utilization samples are summarized by
+// the running worker and by the workers started later
+func (c *isolatedClient) SetUtilizationSampling(enabled bool) {
+	c.lock.Lock()
+	c.utilizationSampling = enabled
+	c.lock.Unlock()
+
+	c.call("SetUtilizationSampling", enabled, &struct{}{})
+}
+
+// SetGPMMetrics sets whether GPM metrics are collected by the running worker
+// and by the workers started later
+func (c *isolatedClient) SetGPMMetrics(enabled bool) {
+	c.lock.Lock()
+	c.gpmMetrics = enabled
+	c.lock.Unlock()
+
+	c.call("SetGPMMetrics", enabled, &struct{}{})
+}
+
+// SetErrorLogInterval sets the interval during which the logs of failing NVML
+// calls are coalesced by the running worker and by the workers started later
+func (c *isolatedClient) SetErrorLogInterval(interval time.Duration) {
+	c.lock.Lock()
+	c.errorLogInterval = interval
+	c.lock.Unlock()
+
+	c.call("SetErrorLogInterval", interval, &struct{}{})
+}
+
+// SampleStats samples the stats of the devices in the worker, samples are
+// lost when the worker restarts
+func (c *isolatedClient) SampleStats() error {
+	return c.call("SampleStats", struct{}{}, &struct{}{})
+}
+
+// Shutdown stops the worker process, which releases the NVML library
+func (c *isolatedClient) Shutdown() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.client == nil {
+		return nil
+	}
+	err := c.client.Close()
+	c.client = nil
+	return err
+}
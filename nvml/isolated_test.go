@@ -0,0 +1,146 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvml
+
+import (
+	"io"
+	"net"
+	"net/rpc"
+	"testing"
+
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/shoenig/test/must"
+)
+
+// newTestIsolatedClient returns an isolated client whose workers run in
+// goroutines serving driver, along with the worker ends of their connections
+func newTestIsolatedClient(driver NvmlDriver) (*isolatedClient, *[]net.Conn) {
+	var conns []net.Conn
+	client := &isolatedClient{
+		start: func() (io.ReadWriteCloser, error) {
+			parent, worker := net.Pipe()
+			conns = append(conns, worker)
+			go serveWorker(worker, func(InitializeArgs) (NvmlClient, error) {
+				return &nvmlClient{driver: driver}, nil
+			})
+			return parent, nil
+		},
+	}
+	return client, &conns
+}
+
+func TestIsolatedClient(t *testing.T) {
+	driver := &MockNVMLDriver{
+		systemDriverCallSuccessful:     true,
+		listDeviceUUIDsSuccessful:      true,
+		deviceInfoByUUIDCallSuccessful: true,
+		driverVersion:                  "driverVersion",
+		devices: []*DeviceInfo{
+			{
+				UUID:               "UUID1",
+				Name:               pointer.Of("ModelName1"),
+				MemoryMiB:          pointer.Of(uint64(16)),
+				PCIBusID:           "busId1",
+				PowerW:             pointer.Of(uint(0)),
+				BAR1MiB:            pointer.Of(uint64(256)),
+				PCIBandwidthMBPerS: pointer.Of(uint(0)),
+				CoresClockMHz:      pointer.Of(uint(1)),
+				MemoryClockMHz:     pointer.Of(uint(1)),
+			},
+		},
+		modes:       []mode{normal},
+		processes:   map[string][]int{"UUID1": {1234}},
+		fatalErrors: map[string]string{"UUID1": "GPU is lost"},
+	}
+	client, _ := newTestIsolatedClient(driver)
+
+	data, err := client.GetFingerprintData()
+	must.NoError(t, err)
+	must.Eq(t, "driverVersion", data.DriverVersion)
+	must.Len(t, 1, data.Devices)
+	must.Eq(t, "UUID1", data.Devices[0].UUID)
+	must.Eq(t, uint64(256), *data.Devices[0].BAR1MiB)
+
+	// values that are zero must not be confused with unavailable ones
+	must.NotNil(t, data.Devices[0].PowerW)
+	must.Eq(t, uint(0), *data.Devices[0].PowerW)
+
+	pids, err := client.GetComputeProcesses("UUID1")
+	must.NoError(t, err)
+	must.Eq(t, []int{1234}, pids)
+
+	reason, err := client.GetFatalError("UUID1")
+	must.NoError(t, err)
+	must.Eq(t, "GPU is lost", reason)
+
+	must.NoError(t, client.ResetDevice("UUID1", true))
+	must.Eq(t, []string{"full:UUID1"}, driver.resetCalls)
+
+	must.NoError(t, client.Shutdown())
+}
+
+func TestIsolatedClientRestart(t *testing.T) {
+	client, conns := newTestIsolatedClient(&MockNVMLDriver{
+		processes: map[string][]int{"UUID1": {1234}},
+	})
+
+	_, err := client.GetComputeProcesses("UUID1")
+	must.NoError(t, err)
+	must.Len(t, 1, *conns)
+
+	// simulate a crash of the worker
+	(*conns)[0].Close()
+	_, err = client.GetComputeProcesses("UUID1")
+	must.ErrorContains(t, err, "NVML worker exited")
+
+	pids, err := client.GetComputeProcesses("UUID1")
+	must.NoError(t, err)
+	must.Eq(t, []int{1234}, pids)
+	must.Len(t, 2, *conns)
+}
+
+func TestIsolatedClientInitializeError(t *testing.T) {
+	client := &isolatedClient{
+		start: func() (io.ReadWriteCloser, error) {
+			parent, worker := net.Pipe()
+			go serveWorker(worker, func(InitializeArgs) (NvmlClient, error) {
+				return nil, UnavailableLib
+			})
+			return parent, nil
+		},
+	}
+
+	_, err := client.GetFingerprintData()
+	must.ErrorIs(t, err, UnavailableLib)
+}
+
+func TestWorkerError(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Err      rpc.ServerError
+		Expected error
+	}{
+		{
+			Name:     "unavailable library",
+			Err:      rpc.ServerError(UnavailableLib.Error()),
+			Expected: UnavailableLib,
+		},
+		{
+			Name:     "not supported",
+			Err:      rpc.ServerError(ErrNotSupported.Error()),
+			Expected: ErrNotSupported,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			must.ErrorIs(t, workerError(c.Err), c.Expected)
+		})
+	}
+
+	must.EqError(t, workerError(rpc.ServerError("boom")), "boom")
+
+	err := workerError(rpc.ServerError(`could not load NVML library "/opt/libnvidia-ml.so.1"`))
+	must.True(t, IsPermanent(err))
+	must.EqError(t, err, `could not load NVML library "/opt/libnvidia-ml.so.1"`)
+}
@@ -0,0 +1,124 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvml
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// LogLimiter coalesces repeated log lines. The first line of a key is logged
+// right away, further lines of that key within the interval are only counted.
+// Their number is logged with the next line of that key once the interval
+// elapsed, or by Flush once lines of that key stopped.
+type LogLimiter struct {
+	// interval during which lines of a key are coalesced, zero disables
+	// coalescing
+	interval time.Duration
+
+	// now is used to determine the current time
+	now func() time.Time
+
+	lock      sync.Mutex
+	entries   map[string]*logEntry
+	lastFlush time.Time
+}
+
+// logEntry tracks the lines of a single key
+type logEntry struct {
+	logger     hclog.Logger
+	level      hclog.Level
+	msg        string
+	args       []interface{}
+	lastLogged time.Time
+	lastSeen   time.Time
+	suppressed int
+}
+
+// NewLogLimiter returns a LogLimiter coalescing the lines of a key logged
+// within interval. The current time is determined with now, or with time.Now
+// when nil.
+func NewLogLimiter(interval time.Duration, now func() time.Time) *LogLimiter {
+	if now == nil {
+		now = time.Now
+	}
+	return &LogLimiter{
+		interval: interval,
+		now:      now,
+		entries:  make(map[string]*logEntry),
+	}
+}
+
+// Log logs msg and args to logger at level unless a line of key was already
+// logged within the limiter interval. A nil limiter logs every line.
+func (l *LogLimiter) Log(logger hclog.Logger, level hclog.Level, key, msg string, args ...interface{}) {
+	if l == nil || l.interval <= 0 {
+		logger.Log(level, msg, args...)
+		return
+	}
+
+	now := l.now()
+	l.lock.Lock()
+	entry, ok := l.entries[key]
+	if ok && now.Sub(entry.lastLogged) < l.interval {
+		entry.logger, entry.args = logger, args
+		entry.lastSeen = now
+		entry.suppressed++
+		l.lock.Unlock()
+		return
+	}
+	suppressed := 0
+	if ok {
+		suppressed = entry.suppressed
+	}
+	l.entries[key] = &logEntry{
+		logger:     logger,
+		level:      level,
+		msg:        msg,
+		args:       args,
+		lastLogged: now,
+		lastSeen:   now,
+	}
+	l.lock.Unlock()
+
+	if suppressed != 0 {
+		args = append(args, "suppressed", suppressed, "suppressed_interval", l.interval)
+	}
+	logger.Log(level, msg, args...)
+}
+
+// Flush logs the number of suppressed lines of every key no line was logged
+// for within the interval, and forgets such keys. Keys are checked at most
+// once per interval, so Flush is cheap enough to be called often.
+func (l *LogLimiter) Flush() {
+	if l == nil || l.interval <= 0 {
+		return
+	}
+
+	now := l.now()
+	l.lock.Lock()
+	if now.Sub(l.lastFlush) < l.interval {
+		l.lock.Unlock()
+		return
+	}
+	l.lastFlush = now
+	var stopped []*logEntry
+	for key, entry := range l.entries {
+		if now.Sub(entry.lastSeen) < l.interval {
+			continue
+		}
+		delete(l.entries, key)
+		if entry.suppressed != 0 {
+			stopped = append(stopped, entry)
+		}
+	}
+	l.lock.Unlock()
+
+	for _, entry := range stopped {
+		args := append(entry.args, "suppressed", entry.suppressed, "suppressed_interval", l.interval)
+		entry.logger.Log(entry.level, entry.msg, args...)
+	}
+}
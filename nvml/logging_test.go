@@ -0,0 +1,46 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/shoenig/test/must"
+)
+
+func TestLogLimiterFlush(t *testing.T) {
+	var out bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{Output: &out})
+	now := time.Unix(0, 0)
+	limiter := NewLogLimiter(time.Minute, func() time.Time { return now })
+
+	limiter.Log(logger, hclog.Error, "a", "failure", "uuid", "UUID1")
+	limiter.Log(logger, hclog.Error, "b", "failure", "uuid", "UUID2")
+	now = now.Add(time.Second)
+	limiter.Log(logger, hclog.Error, "a", "failure", "uuid", "UUID1")
+	must.Eq(t, 2, strings.Count(out.String(), "\n"))
+
+	// keys still logged are kept until they stop
+	now = now.Add(59 * time.Second)
+	limiter.Flush()
+	must.MapLen(t, 1, limiter.entries)
+	must.Eq(t, 2, strings.Count(out.String(), "\n"))
+
+	// flushes are throttled to one per interval
+	now = now.Add(30 * time.Second)
+	limiter.Flush()
+	must.MapLen(t, 1, limiter.entries)
+
+	// keys that stopped are forgotten, with their suppressed count logged
+	out.Reset()
+	now = now.Add(30 * time.Second)
+	limiter.Flush()
+	must.MapEmpty(t, limiter.entries)
+	must.Eq(t, 1, strings.Count(out.String(), "\n"))
+	must.StrContains(t, out.String(), "uuid=UUID1 suppressed=1")
+}
@@ -0,0 +1,25 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvml
+
+import "math/bits"
+
+// closestNUMANode returns the lowest NUMA node of a memory affinity node set
+// returned by NVML, a bitmask of nodes in words of the native size, ignoring
+// the NUMA node of the memory of the device itself. Nil is returned for empty
+// node sets.
+func closestNUMANode(nodeSet []uint, memoryNode *uint) *uint {
+	for word, mask := range nodeSet {
+		for mask != 0 {
+			bit := uint(bits.TrailingZeros(mask))
+			mask &^= 1 << bit
+			node := uint(word)*bits.UintSize + bit
+			if memoryNode != nil && node == *memoryNode {
+				continue
+			}
+			return &node
+		}
+	}
+	return nil
+}
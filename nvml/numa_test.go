@@ -0,0 +1,61 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvml
+
+import (
+	"math/bits"
+	"testing"
+
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/shoenig/test/must"
+)
+
+func TestClosestNUMANode(t *testing.T) {
+	cases := []struct {
+		Name       string
+		NodeSet    []uint
+		MemoryNode *uint
+		Expected   *uint
+	}{
+		{
+			Name:     "empty",
+			NodeSet:  []uint{0, 0},
+			Expected: nil,
+		},
+		{
+			Name:     "single node",
+			NodeSet:  []uint{1 << 1},
+			Expected: pointer.Of(uint(1)),
+		},
+		{
+			Name:     "lowest node",
+			NodeSet:  []uint{1<<2 | 1<<3},
+			Expected: pointer.Of(uint(2)),
+		},
+		{
+			Name:     "second word",
+			NodeSet:  []uint{0, 1},
+			Expected: pointer.Of(uint(bits.UintSize)),
+		},
+		{
+			// the GPU memory of Grace Hopper systems is a NUMA node of its
+			// own, the Grace CPU node is reported
+			Name:       "memory node ignored",
+			NodeSet:    []uint{1<<0 | 1<<1},
+			MemoryNode: pointer.Of(uint(0)),
+			Expected:   pointer.Of(uint(1)),
+		},
+		{
+			Name:       "only memory node",
+			NodeSet:    []uint{1 << 4},
+			MemoryNode: pointer.Of(uint(4)),
+			Expected:   nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			must.Eq(t, c.Expected, closestNUMANode(c.NodeSet, c.MemoryNode))
+		})
+	}
+}
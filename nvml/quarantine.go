@@ -0,0 +1,100 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvml
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// errUnresponsive is matched by the errors of NVML calls that failed with
+// NVML_ERROR_TIMEOUT or NVML_ERROR_IRQ_ISSUE, which failing GPUs return
+// intermittently
+var errUnresponsive = errors.New("device is unresponsive")
+
+// errQuarantined is returned instead of querying a quarantined device
+var errQuarantined = errors.New("device is quarantined")
+
+// unresponsive reports whether err shows that the queried device did not
+// respond: NVML timed out or reported an interrupt issue, or the call, or an
+// earlier call still running, did not complete before its deadline
+func unresponsive(err error) bool {
+	return errors.Is(err, errUnresponsive) || errors.Is(err, ErrCallTimeout) || errors.Is(err, ErrCallPending)
+}
+
+// quarantine tracks the queries of each device that failed because the device
+// did not respond. Devices that fail that way too often within a window are
+// quarantined: they are reported unhealthy and no longer queried until the
+// plugin restarts. A nil quarantine quarantines no device.
+type quarantine struct {
+	config BreakerConfig
+
+	// now returns the current time, it is replaced in tests
+	now func() time.Time
+
+	lock        sync.Mutex
+	occurrences map[string][]time.Time
+	quarantined map[string]error
+}
+
+func newQuarantine(config BreakerConfig) *quarantine {
+	return &quarantine{
+		config:      config,
+		now:         time.Now,
+		occurrences: make(map[string][]time.Time),
+		quarantined: make(map[string]error),
+	}
+}
+
+func (q *quarantine) enabled() bool {
+	return q != nil && q.config.QuarantineThreshold > 0
+}
+
+// allow reports whether the device with the given UUID may be queried
+func (q *quarantine) allow(uuid string) bool {
+	if !q.enabled() {
+		return true
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	_, ok := q.quarantined[uuid]
+	return !ok
+}
+
+// failure records a failed query of the device and reports whether the
+// device is quarantined as a result. Only errors showing that the device did
+// not respond count towards the quarantine threshold.
+func (q *quarantine) failure(uuid string, err error) bool {
+	if !q.enabled() || !unresponsive(err) {
+		return false
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := q.now()
+	occurrences := slices.DeleteFunc(q.occurrences[uuid], func(at time.Time) bool {
+		return now.Sub(at) >= q.config.QuarantineWindow
+	})
+	occurrences = append(occurrences, now)
+	if len(occurrences) < q.config.QuarantineThreshold {
+		q.occurrences[uuid] = occurrences
+		return false
+	}
+	delete(q.occurrences, uuid)
+	q.quarantined[uuid] = err
+	return true
+}
+
+// reason describes why the device with the given UUID is quarantined
+func (q *quarantine) reason(uuid string) string {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return fmt.Sprintf("device quarantined after %d unresponsive NVML queries within %s: %v",
+		q.config.QuarantineThreshold, q.config.QuarantineWindow, q.quarantined[uuid])
+}
@@ -0,0 +1,83 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvml
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestQuarantine(t *testing.T) {
+	type step struct {
+		// advance moves the clock before the step
+		advance     time.Duration
+		err         error
+		quarantined bool
+	}
+
+	cases := []struct {
+		Name  string
+		Steps []step
+	}{
+		{
+			Name: "quarantined after threshold within window",
+			Steps: []step{
+				{err: ErrCallTimeout},
+				{advance: time.Minute, err: ErrCallTimeout},
+				{advance: time.Minute, err: ErrCallTimeout, quarantined: true},
+			},
+		},
+		{
+			Name: "occurrences outside window are forgotten",
+			Steps: []step{
+				{err: ErrCallTimeout},
+				{advance: 5 * time.Minute, err: ErrCallTimeout},
+				{advance: 6 * time.Minute, err: ErrCallTimeout},
+				{advance: time.Minute, err: ErrCallTimeout, quarantined: true},
+			},
+		},
+		{
+			Name: "other errors are ignored",
+			Steps: []step{
+				{err: ErrCallTimeout},
+				{err: errors.New("query failed")},
+				{err: errors.New("query failed")},
+				{err: ErrCallTimeout},
+				{err: ErrCallTimeout, quarantined: true},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			now := time.Now()
+			q := newQuarantine(BreakerConfig{QuarantineThreshold: 3, QuarantineWindow: 10 * time.Minute})
+			q.now = func() time.Time { return now }
+
+			for i, step := range c.Steps {
+				now = now.Add(step.advance)
+				must.True(t, q.allow("UUID1"), must.Sprintf("step %d", i))
+				must.Eq(t, step.quarantined, q.failure("UUID1", step.err), must.Sprintf("step %d", i))
+			}
+			must.False(t, q.allow("UUID1"))
+			must.True(t, q.allow("UUID2"))
+			must.StrContains(t, q.reason("UUID1"), ErrCallTimeout.Error())
+		})
+	}
+}
+
+func TestQuarantineDisabled(t *testing.T) {
+	var nilQuarantine *quarantine
+	must.True(t, nilQuarantine.allow("UUID1"))
+	must.False(t, nilQuarantine.failure("UUID1", ErrCallTimeout))
+
+	q := newQuarantine(BreakerConfig{})
+	for i := 0; i < 10; i++ {
+		must.False(t, q.failure("UUID1", ErrCallTimeout))
+	}
+	must.True(t, q.allow("UUID1"))
+}
@@ -0,0 +1,315 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvml
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Recording holds the responses of the NVML library of a node, as recorded by
+// Record, so that they can be replayed without GPUs by NewReplayClient
+type Recording struct {
+	DriverVersion string
+	NVMLVersion   string
+
+	// CUDADriverVersion is empty in recordings made before it was recorded
+	CUDADriverVersion string
+
+	// Devices are the devices listed by NVML, in order
+	Devices []DeviceIdentity
+
+	// DeviceInfo holds the data of every device listed, keyed by UUID
+	DeviceInfo map[string]*DeviceInfo
+
+	// DeviceStatus holds the successive status samples of every device
+	// reporting stats, keyed by UUID. Samples are replayed in order and the
+	// last one is repeated once all were replayed.
+	DeviceStatus map[string][]*DeviceStatus
+
+	// Interval is the time between status samples
+	Interval time.Duration
+}
+
+// ReadRecording reads a recording from the JSON file at path
+func ReadRecording(path string) (*Recording, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read NVML recording %q: %v", path, err)
+	}
+	var recording Recording
+	if err := json.Unmarshal(content, &recording); err != nil {
+		return nil, fmt.Errorf("failed to parse NVML recording %q: %v", path, err)
+	}
+	return &recording, nil
+}
+
+// Record records the responses of the NVML library at libraryPath, or of the
+// default library when empty. The status of every device is sampled samples
+// times, interval apart.
+func Record(libraryPath string, samples int, interval time.Duration) (*Recording, error) {
+	driver := &nvmlDriver{libraryPath: libraryPath}
+	ctx, cancel := callContext(0)
+	defer cancel()
+	if err := driver.Initialize(ctx); err != nil {
+		return nil, err
+	}
+	defer func() {
+		ctx, cancel := callContext(0)
+		defer cancel()
+		driver.Shutdown(ctx)
+	}()
+
+	return record(driver, samples, interval)
+}
+
+func record(driver NvmlDriver, samples int, interval time.Duration) (*Recording, error) {
+	ctx, cancel := callContext(0)
+	defer cancel()
+	driverVersion, err := driver.SystemDriverVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record driver version: %v", err)
+	}
+	nvmlVersion, err := driver.SystemNVMLVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record NVML version: %v", err)
+	}
+	cudaDriverVersion, err := driver.SystemCUDADriverVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record CUDA driver version: %v", err)
+	}
+	devices, err := driver.ListDeviceUUIDs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record devices: %v", err)
+	}
+
+	recording := &Recording{
+		DriverVersion:     driverVersion,
+		NVMLVersion:       nvmlVersion,
+		CUDADriverVersion: cudaDriverVersion,
+		Devices:           devices,
+		DeviceInfo:        make(map[string]*DeviceInfo),
+		DeviceStatus:      make(map[string][]*DeviceStatus),
+		Interval:          interval,
+	}
+	for _, identity := range devices {
+		ctx, cancel := callContext(0)
+		info, err := driver.DeviceInfoByUUID(ctx, identity.UUID)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to record device %s: %v", identity.UUID, err)
+		}
+		recording.DeviceInfo[identity.UUID] = info
+	}
+
+	for i := 0; i < samples; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		for _, identity := range devices {
+			// MIG devices report no stats
+			if identity.Mode != normal {
+				continue
+			}
+			ctx, cancel := callContext(0)
+			_, status, err := driver.DeviceInfoAndStatusByUUID(ctx, identity.UUID)
+			cancel()
+			if err != nil {
+				return nil, fmt.Errorf("failed to record status of device %s: %v", identity.UUID, err)
+			}
+			recording.DeviceStatus[identity.UUID] = append(recording.DeviceStatus[identity.UUID], status)
+		}
+	}
+	return recording, nil
+}
+
+// NewReplayClient returns a client replaying the given recording instead of
+// querying NVML. Device management and accounting are not supported.
+func NewReplayClient(recording *Recording) NvmlClient {
+	driver := &replayDriver{
+		recording: recording,
+		samples:   make(map[string]int),
+	}
+	return &nvmlClient{
+		driver: driver,
+		clock:  driver.now,
+	}
+}
+
+// replayDriver implements NvmlDriver by replaying a recording
+type replayDriver struct {
+	recording *Recording
+
+	// samples holds the index of the next status sample of every device
+	lock    sync.Mutex
+	samples map[string]int
+}
+
+func (r *replayDriver) Initialize(context.Context) error {
+	return nil
+}
+
+func (r *replayDriver) Shutdown(context.Context) error {
+	return nil
+}
+
+func (r *replayDriver) SystemDriverVersion(context.Context) (string, error) {
+	return r.recording.DriverVersion, nil
+}
+
+func (r *replayDriver) SystemNVMLVersion(context.Context) (string, error) {
+	return r.recording.NVMLVersion, nil
+}
+
+func (r *replayDriver) SystemCUDADriverVersion(context.Context) (string, error) {
+	return r.recording.CUDADriverVersion, nil
+}
+
+func (r *replayDriver) ListDeviceUUIDs(context.Context) ([]DeviceIdentity, error) {
+	return r.recording.Devices, nil
+}
+
+func (r *replayDriver) DeviceInfoByUUID(_ context.Context, uuid string) (*DeviceInfo, error) {
+	info, ok := r.recording.DeviceInfo[uuid]
+	if !ok {
+		return nil, fmt.Errorf("device %s is not recorded", uuid)
+	}
+	return info, nil
+}
+
+// DeviceInfoAndStatusByUUID replays the next status sample of the device
+func (r *replayDriver) DeviceInfoAndStatusByUUID(ctx context.Context, uuid string) (*DeviceInfo, *DeviceStatus, error) {
+	info, err := r.DeviceInfoByUUID(ctx, uuid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	statuses := r.recording.DeviceStatus[uuid]
+	if len(statuses) == 0 {
+		return nil, nil, fmt.Errorf("status of device %s is not recorded", uuid)
+	}
+	sample := min(r.samples[uuid], len(statuses)-1)
+	r.samples[uuid] = sample + 1
+	return info, statuses[sample], nil
+}
+
+// now returns the time the status samples being replayed were recorded at,
+// relative to the zero time, so that replays are deterministic
+func (r *replayDriver) now() time.Time {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	round := -1
+	for uuid, statuses := range r.recording.DeviceStatus {
+		if len(statuses) == 0 {
+			continue
+		}
+		sample := min(r.samples[uuid], len(statuses)-1)
+		if round < 0 || sample < round {
+			round = sample
+		}
+	}
+	return time.Time{}.Add(time.Duration(max(round, 0)) * r.recording.Interval)
+}
+
+// lastStatus returns the last status sample of the device replayed, nil
+// before any
+func (r *replayDriver) lastStatus(uuid string) *DeviceStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	sample, ok := r.samples[uuid]
+	if !ok {
+		return nil
+	}
+	return r.recording.DeviceStatus[uuid][sample-1]
+}
+
+func (r *replayDriver) ResetDeviceClocks(context.Context, string) error {
+	return ErrNotSupported
+}
+
+func (r *replayDriver) ResetDevice(context.Context, string) error {
+	return ErrNotSupported
+}
+
+// ComputeProcessesByUUID reports no processes, they are not recorded
+func (r *replayDriver) ComputeProcessesByUUID(context.Context, string) ([]int, error) {
+	return nil, nil
+}
+
+func (r *replayDriver) EnableAccountingByUUID(context.Context, string) error {
+	return ErrNotSupported
+}
+
+func (r *replayDriver) AccountingStatsByUUID(context.Context, string) ([]*AccountingStats, error) {
+	return nil, ErrNotSupported
+}
+
+func (r *replayDriver) ClearAccountingByUUID(context.Context, string) error {
+	return ErrNotSupported
+}
+
+// FatalErrorByUUID reports no fatal errors, they are not recorded
+func (r *replayDriver) FatalErrorByUUID(context.Context, string) (string, error) {
+	return "", nil
+}
+
+func (r *replayDriver) PreflightByUUID(context.Context, string) error {
+	return nil
+}
+
+// UtilizationSamplesByUUID reports no samples, they are not recorded
+func (r *replayDriver) UtilizationSamplesByUUID(_ context.Context, _ string, lastSeen uint64) ([]uint, uint64, error) {
+	return nil, lastSeen, nil
+}
+
+// EnergyConsumptionByUUID returns the energy counter of the last status
+// sample replayed
+func (r *replayDriver) EnergyConsumptionByUUID(_ context.Context, uuid string) (uint64, error) {
+	status := r.lastStatus(uuid)
+	if status == nil || status.EnergyMJ == nil {
+		return 0, ErrNotSupported
+	}
+	return *status.EnergyMJ, nil
+}
+
+// SampleByUUID returns the values of the last status sample replayed, or of
+// the first one before any
+func (r *replayDriver) SampleByUUID(_ context.Context, uuid string) (*DeviceSample, error) {
+	status := r.lastStatus(uuid)
+	if status == nil {
+		r.lock.Lock()
+		statuses := r.recording.DeviceStatus[uuid]
+		r.lock.Unlock()
+		if len(statuses) == 0 {
+			return nil, fmt.Errorf("status of device %s is not recorded", uuid)
+		}
+		status = statuses[0]
+	}
+	return &DeviceSample{
+		GPUUtilization: status.GPUUtilization,
+		PowerUsageMW:   status.PowerUsageMW,
+		TemperatureC:   status.TemperatureC,
+	}, nil
+}
+
+// GPMMetricsByUUID reports GPM metrics as not supported, they are not
+// recorded
+func (r *replayDriver) GPMMetricsByUUID(context.Context, string) (*GPMMetrics, error) {
+	return nil, ErrNotSupported
+}
+
+// P2PStatusByUUID reports peer-to-peer capabilities as not supported, they
+// are not recorded
+func (r *replayDriver) P2PStatusByUUID(context.Context, string, string) (*P2PStatus, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,143 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvml
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/shoenig/test/must"
+)
+
+func TestRecordReplay(t *testing.T) {
+	driver := &MockNVMLDriver{
+		systemDriverCallSuccessful:              true,
+		listDeviceUUIDsSuccessful:               true,
+		deviceInfoByUUIDCallSuccessful:          true,
+		deviceInfoAndStatusByUUIDCallSuccessful: true,
+		driverVersion:                           "550.54.15",
+		nvmlVersion:                             "12.550.54.15",
+		devices: []*DeviceInfo{
+			{
+				UUID:      "UUID1",
+				Name:      pointer.Of("ModelName1"),
+				MemoryMiB: pointer.Of(uint64(16)),
+				PCIBusID:  "busId1",
+			},
+			{
+				UUID: "UUID2",
+				Name: pointer.Of("ModelName2"),
+			},
+			{
+				UUID:      "UUID3",
+				Name:      pointer.Of("ModelName2 MIG 1g.5gb"),
+				MemoryMiB: pointer.Of(uint64(5)),
+			},
+		},
+		deviceStatus: []*DeviceStatus{
+			{
+				TemperatureC:   pointer.Of(uint(42)),
+				GPUUtilization: pointer.Of(uint(10)),
+				EnergyMJ:       pointer.Of(uint64(1000)),
+			},
+			{},
+			{},
+		},
+		modes: []mode{normal, parent, mig},
+	}
+	live := &nvmlClient{driver: driver}
+
+	recording, err := record(driver, 1, 0)
+	must.NoError(t, err)
+	must.MapLen(t, 3, recording.DeviceInfo)
+	must.MapLen(t, 1, recording.DeviceStatus)
+
+	// recordings are replayed from JSON files
+	path := filepath.Join(t.TempDir(), "recording.json")
+	content, err := json.Marshal(recording)
+	must.NoError(t, err)
+	must.NoError(t, os.WriteFile(path, content, 0o644))
+	recording, err = ReadRecording(path)
+	must.NoError(t, err)
+	must.Eq(t, parent, recording.Devices[1].Mode)
+	replay := NewReplayClient(recording)
+
+	expectedFingerprint, err := live.GetFingerprintData()
+	must.NoError(t, err)
+	fingerprint, err := replay.GetFingerprintData()
+	must.NoError(t, err)
+	must.Eq(t, expectedFingerprint, fingerprint)
+
+	expectedStats, err := live.GetStatsData()
+	must.NoError(t, err)
+	stats, err := replay.GetStatsData()
+	must.NoError(t, err)
+	must.Len(t, 1, stats)
+	stats[0].QueryDuration = expectedStats[0].QueryDuration
+	must.Eq(t, expectedStats, stats)
+}
+
+func TestReplayStatusSamples(t *testing.T) {
+	recording := &Recording{
+		Devices: []DeviceIdentity{
+			{UUID: "UUID1", Mode: normal},
+			{UUID: "UUID2", Mode: normal},
+		},
+		DeviceInfo: map[string]*DeviceInfo{
+			"UUID1": {UUID: "UUID1"},
+			"UUID2": {UUID: "UUID2"},
+		},
+		DeviceStatus: map[string][]*DeviceStatus{
+			"UUID1": {
+				{EnergyMJ: pointer.Of(uint64(1000))},
+				{EnergyMJ: pointer.Of(uint64(11000))},
+			},
+			"UUID2": {
+				{EnergyMJ: pointer.Of(uint64(2000))},
+				{EnergyMJ: pointer.Of(uint64(7000))},
+			},
+		},
+		Interval: 10 * time.Second,
+	}
+	client := NewReplayClient(recording)
+
+	stats, err := client.GetStatsData()
+	must.NoError(t, err)
+	must.Eq(t, uint64(1000), *stats[0].EnergyMJ)
+	must.Nil(t, stats[0].AveragePowerUsageMW)
+
+	// the average power usage is computed over the recorded interval
+	stats, err = client.GetStatsData()
+	must.NoError(t, err)
+	must.Eq(t, uint64(11000), *stats[0].EnergyMJ)
+	must.Eq(t, uint(1000), *stats[0].AveragePowerUsageMW)
+	must.Eq(t, uint(500), *stats[1].AveragePowerUsageMW)
+
+	energy, err := client.GetEnergyConsumption("UUID2")
+	must.NoError(t, err)
+	must.Eq(t, uint64(7000), energy)
+
+	// the last sample is repeated
+	stats, err = client.GetStatsData()
+	must.NoError(t, err)
+	must.Eq(t, uint64(11000), *stats[0].EnergyMJ)
+}
+
+func TestModeText(t *testing.T) {
+	for _, m := range []mode{normal, parent, mig} {
+		text, err := m.MarshalText()
+		must.NoError(t, err)
+
+		var decoded mode
+		must.NoError(t, decoded.UnmarshalText(text))
+		must.Eq(t, m, decoded)
+	}
+
+	var decoded mode
+	must.ErrorContains(t, decoded.UnmarshalText([]byte("vgpu")), `unknown device mode "vgpu"`)
+}
@@ -3,13 +3,42 @@
 
 package nvml
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
 
 var (
 	// UnavailableLib is returned when the nvml library could not be loaded.
 	UnavailableLib = errors.New("could not load NVML library")
+
+	// ErrNotSupported is returned when an operation is not supported by the
+	// device, such as resetting a MIG instance.
+	ErrNotSupported = errors.New("operation not supported by device")
 )
 
+// permanentError marks initialization errors that retrying can not fix
+type permanentError struct {
+	error
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.error
+}
+
+// IsPermanent reports whether err is an initialization error that persists
+// until the node configuration changes, such as a missing driver, rather than
+// a transient failure
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.Is(err, UnavailableLib) || errors.As(err, &permanent)
+}
+
 type mode int
 
 const (
@@ -18,18 +47,140 @@ const (
 	mig
 )
 
+var modeNames = map[mode]string{
+	normal: "normal",
+	parent: "parent",
+	mig:    "mig",
+}
+
+// MarshalText encodes the mode by name in recordings
+func (m mode) MarshalText() ([]byte, error) {
+	name, ok := modeNames[m]
+	if !ok {
+		return nil, fmt.Errorf("unknown device mode %d", m)
+	}
+	return []byte(name), nil
+}
+
+func (m *mode) UnmarshalText(text []byte) error {
+	for value, name := range modeNames {
+		if name == string(text) {
+			*m = value
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown device mode %q", text)
+}
+
 // nvmlDriver implements NvmlDriver
 // Users are required to call Initialize method before using any other methods
-type nvmlDriver struct{}
+type nvmlDriver struct {
+	// libraryPath is the path of the NVML library to load, the default
+	// library search path is used when empty
+	libraryPath string
 
-// NvmlDriver represents set of methods to query nvml library
+	// fieldValuesUnsupported is set once the driver reported that it does not
+	// support batched field value queries, after which values are queried
+	// individually
+	fieldValuesUnsupported atomic.Bool
+
+	// logger logs every NVML call at trace level, and failed calls at debug
+	// level
+	logger hclog.Logger
+
+	// failedCalls coalesces the logs of calls failing repeatedly with the
+	// same return code, nil when not coalesced
+	failedCalls atomic.Pointer[LogLimiter]
+
+	// gpm holds the previous GPU Performance Monitoring sample of each
+	// device, from which GPM metrics are computed
+	gpm gpmSamples
+
+	// pending tracks the calls abandoned while hung in the NVIDIA driver
+	pending pendingCalls
+}
+
+// NvmlDriver represents set of methods to query nvml library. Calls return
+// once their context is done, even when the NVIDIA driver does not respond.
 type NvmlDriver interface {
-	Initialize() error
-	Shutdown() error
-	SystemDriverVersion() (string, error)
-	ListDeviceUUIDs() (map[string]mode, error)
-	DeviceInfoByUUID(string) (*DeviceInfo, error)
-	DeviceInfoAndStatusByUUID(string) (*DeviceInfo, *DeviceStatus, error)
+	Initialize(context.Context) error
+	Shutdown(context.Context) error
+	SystemDriverVersion(context.Context) (string, error)
+	SystemNVMLVersion(context.Context) (string, error)
+	SystemCUDADriverVersion(context.Context) (string, error)
+	ListDeviceUUIDs(context.Context) ([]DeviceIdentity, error)
+	DeviceInfoByUUID(context.Context, string) (*DeviceInfo, error)
+	DeviceInfoAndStatusByUUID(context.Context, string) (*DeviceInfo, *DeviceStatus, error)
+	ResetDeviceClocks(context.Context, string) error
+	ResetDevice(context.Context, string) error
+	ComputeProcessesByUUID(context.Context, string) ([]int, error)
+	EnableAccountingByUUID(context.Context, string) error
+	AccountingStatsByUUID(context.Context, string) ([]*AccountingStats, error)
+	ClearAccountingByUUID(context.Context, string) error
+	FatalErrorByUUID(context.Context, string) (string, error)
+	PreflightByUUID(context.Context, string) error
+	UtilizationSamplesByUUID(context.Context, string, uint64) ([]uint, uint64, error)
+	EnergyConsumptionByUUID(context.Context, string) (uint64, error)
+	SampleByUUID(context.Context, string) (*DeviceSample, error)
+	GPMMetricsByUUID(context.Context, string) (*GPMMetrics, error)
+	P2PStatusByUUID(context.Context, string, string) (*P2PStatus, error)
+}
+
+// P2PStatus holds the peer-to-peer capabilities between two physical GPUs,
+// and how they are connected
+type P2PStatus struct {
+	UUID     string
+	PeerUUID string
+	Read     bool
+	Write    bool
+	NVLink   bool
+
+	// PCIePath is the closest common ancestor of the GPUs in the PCIe tree,
+	// one of "internal", "single", "multiple", "hostbridge", "node" or
+	// "system", empty when unknown
+	PCIePath string
+
+	// NVLinks is the number of NVLinks directly connecting the GPUs, GPUs
+	// connected through NVSwitches have none
+	NVLinks uint
+}
+
+// GPMMetrics holds the GPU Performance Monitoring metrics of a device over the
+// interval between two GPM samples, in percent. Metrics the device does not
+// report are nil.
+type GPMMetrics struct {
+	SMActivity      *float64
+	TensorActivity  *float64
+	DRAMBandwidth   *float64
+	NVLinkBandwidth *float64
+}
+
+// AccountingStats represents nvml accounting data of a single process
+type AccountingStats struct {
+	PID               int
+	GPUUtilization    uint // %
+	MemoryUtilization uint // %
+	MaxMemoryMiB      uint64
+	GPUTime           time.Duration
+	StartTime         time.Time
+	Running           bool
+}
+
+// DeviceIdentity identifies a device listed by nvml
+// this struct is returned by NvmlDriver ListDeviceUUIDs method
+type DeviceIdentity struct {
+	UUID string
+
+	// Index is the nvml index of the device, MIG devices share the index of
+	// their physical parent
+	Index uint
+
+	// Mode tells whether the device is a normal GPU, a MIG enabled parent or
+	// a MIG device
+	Mode mode
+
+	// ParentUUID is the UUID of the physical parent of MIG devices
+	ParentUUID string
 }
 
 // DeviceInfo represents nvml device data
@@ -39,18 +190,127 @@ type DeviceInfo struct {
 	// The following fields are guaranteed to be retrieved from nvml
 	UUID            string
 	PCIBusID        string
-	DisplayState    string
-	PersistenceMode string
+	DisplayState    string // "Enabled" or "Disabled"
+	PersistenceMode string // "Enabled" or "Disabled"
 
 	// The following fields can be nil after call to nvml, because nvml was
 	// not able to retrieve this fields for specific nvidia card
 	Name               *string
 	MemoryMiB          *uint64
 	PowerW             *uint
+	PowerMW            *uint
 	BAR1MiB            *uint64
 	PCIBandwidthMBPerS *uint
 	CoresClockMHz      *uint
 	MemoryClockMHz     *uint
+
+	// PCI identifiers as reported by nvml, the low 16 bits hold the vendor ID
+	// and the high 16 bits the device ID
+	PCIDeviceID    *uint32
+	PCISubsystemID *uint32
+
+	// Maximum PCIe link generation and width supported by the device
+	PCILinkGeneration *uint
+	PCILinkWidth      *uint
+
+	// Applications clocks can be changed at runtime by the operator
+	ApplicationCoresClockMHz  *uint
+	ApplicationMemoryClockMHz *uint
+
+	// MIG profiles supported by the physical GPU, nil when the GPU does not
+	// support MIG
+	MIGProfiles []*MIGProfile
+
+	// Remaining encoder capacity per codec, as a percentage of the maximum
+	// encoder capacity of the device
+	EncoderCapacityH264 *uint // %
+	EncoderCapacityHEVC *uint // %
+	EncoderCapacityAV1  *uint // %
+
+	// GSP firmware mode, "Enabled" or "Disabled", and version of the GSP
+	// firmware running on the device when enabled
+	GSPFirmwareMode    *string
+	GSPFirmwareVersion *string
+
+	// Versions of the InfoROM objects of the device, nil when the device has
+	// no InfoROM
+	InfoROMVersionOEM   *string
+	InfoROMVersionECC   *string
+	InfoROMVersionPower *string
+
+	// InfoROMCorrupted is whether the InfoROM of the device failed checksum
+	// validation, in which case the ECC counters it stores can not be trusted
+	InfoROMCorrupted bool
+
+	// Current GPU operation mode of the device, one of "all_on", "compute" or
+	// "low_dp", nil when the device does not support operation modes
+	OperationMode *string
+
+	// Virtualization mode of the device, one of "none" on bare metal,
+	// "passthrough", "vgpu" in a vGPU guest, "host_vgpu" or "host_vsga"
+	VirtualizationMode *string
+
+	// Brand of the device, such as "tesla" or "nvidia" for datacenter GPUs and
+	// "geforce" for consumer GPUs, nil when unknown
+	Brand *string
+
+	// Architecture of the device, such as "ampere" or "hopper", nil when
+	// unknown
+	Architecture *string
+
+	// CUDA compute capability of the device, such as "8.0", nil when
+	// unknown. MIG devices report the capability of their parent GPU.
+	ComputeCapability *string
+
+	// Fans of the device, nil for passively cooled devices. FanControl is
+	// "manual" when the speed of any fan is set manually, "automatic"
+	// otherwise.
+	FanCount    *uint
+	FanSpeedMin *uint // %
+	FanSpeedMax *uint // %
+	FanControl  *string
+
+	// CoherentMemory is whether the GPU and the CPU share coherent memory
+	// over an NVLink-C2C link, such as on Grace Hopper systems, nil when the
+	// device does not support C2C
+	CoherentMemory *bool
+
+	// NUMANode is the NUMA node of the CPU memory closest to the device, and
+	// MemoryNUMANode the NUMA node the memory of the device is exposed as on
+	// systems with coherent memory. They are nil when unknown.
+	NUMANode       *uint
+	MemoryNUMANode *uint
+
+	// C2CLinks is the number of active NVLink-C2C links between the GPU and
+	// the CPU, and C2CBandwidthMBps their total bandwidth in MB/s. They are
+	// nil unless the device has coherent memory.
+	C2CLinks         *uint
+	C2CBandwidthMBps *uint
+
+	// MaxOperatingTemperatureC is the highest temperature the GPU is rated to
+	// operate at, and AcousticTemperatureC the temperature the fans are
+	// driven to keep the GPU under to limit their noise. They are nil when the
+	// device does not report them.
+	MaxOperatingTemperatureC *uint
+	AcousticTemperatureC     *uint
+
+	// VGPULicensed is whether the vGPU software is licensed on the device,
+	// and VGPULicenseExpiry when its license expires. They are nil unless the
+	// device is a vGPU supporting licensing, the expiry is also nil for
+	// permanent licenses.
+	VGPULicensed      *bool
+	VGPULicenseExpiry *time.Time
+}
+
+// DisplayEnabled is the DisplayState of devices with a display attached
+const DisplayEnabled = "Enabled"
+
+// MIGProfile represents a GPU instance profile supported by a MIG capable GPU
+type MIGProfile struct {
+	Name          string
+	SliceCount    uint
+	InstanceCount uint // maximum number of instances of this profile
+	MemoryMiB     uint64
 }
 
 // DeviceStatus represents nvml device status
@@ -59,15 +319,39 @@ type DeviceStatus struct {
 	// The following fields can be nil after call to nvml, because nvml was
 	// not able to retrieve this fields for specific nvidia card
 	PowerUsageW           *uint
+	PowerUsageMW          *uint
 	TemperatureC          *uint
 	GPUUtilization        *uint // %
 	MemoryUtilization     *uint // %
 	EncoderUtilization    *uint // %
 	DecoderUtilization    *uint // %
+	JPEGUtilization       *uint // %
+	OFAUtilization        *uint // %
 	BAR1UsedMiB           *uint64
 	UsedMemoryMiB         *uint64
 	ECCErrorsL1Cache      *uint64
 	ECCErrorsL2Cache      *uint64
 	ECCErrorsDevice       *uint64
 	ECCErrorsRegisterFile *uint64
+
+	// Aggregate ECC error counts persist across driver reloads and reboots,
+	// unlike the volatile counts above which are reset on driver load
+	ECCErrorsL1CacheAggregate *uint64
+	ECCErrorsL2CacheAggregate *uint64
+	ECCErrorsDeviceAggregate  *uint64
+
+	// EnergyMJ is the energy consumed by the device since the driver was
+	// last loaded, in millijoules
+	EnergyMJ *uint64
+
+	// Speed of the slowest fan of the device and highest target speed of its
+	// fans, nil for passively cooled devices
+	FanSpeed       *uint // %
+	FanTargetSpeed *uint // %
+
+	// NVLinkTxKiB and NVLinkRxKiB are the data sent and received over all
+	// NVLinks of the device since the driver was last loaded, in KiB, nil for
+	// devices without NVLinks
+	NVLinkTxKiB *uint64
+	NVLinkRxKiB *uint64
 }
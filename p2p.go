@@ -0,0 +1,59 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+)
+
+// Attributes reporting whether every pair of devices of a group supports
+// peer-to-peer reads, writes and NVLink transfers, so that multi-GPU jobs
+// such as NCCL jobs can be placed on devices able to reach each other
+const (
+	P2PReadAttr   = "p2p_read"
+	P2PWriteAttr  = "p2p_write"
+	P2PNVLinkAttr = "p2p_nvlink"
+)
+
+// p2pAttributes returns the peer-to-peer attributes of a group of devices
+// from the capabilities between every pair of physical GPUs. Nil is returned
+// for groups of a single device, for groups of MIG devices, which do not
+// support peer-to-peer transfers, and when the capabilities of a pair of
+// devices are unknown.
+func p2pAttributes(deviceList []*nvml.FingerprintDeviceData, statuses []*nvml.P2PStatus) map[string]*structs.Attribute {
+	if len(deviceList) < 2 {
+		return nil
+	}
+	for _, dev := range deviceList {
+		if dev.ParentUUID != "" {
+			return nil
+		}
+	}
+
+	pairs := make(map[[2]string]*nvml.P2PStatus, 2*len(statuses))
+	for _, status := range statuses {
+		pairs[[2]string{status.UUID, status.PeerUUID}] = status
+		pairs[[2]string{status.PeerUUID, status.UUID}] = status
+	}
+
+	read, write, nvlink := true, true, true
+	for i, dev := range deviceList {
+		for _, peer := range deviceList[i+1:] {
+			status, ok := pairs[[2]string{dev.UUID, peer.UUID}]
+			if !ok {
+				return nil
+			}
+			read = read && status.Read
+			write = write && status.Write
+			nvlink = nvlink && status.NVLink
+		}
+	}
+	return map[string]*structs.Attribute{
+		P2PReadAttr:   {Bool: pointer.Of(read)},
+		P2PWriteAttr:  {Bool: pointer.Of(write)},
+		P2PNVLinkAttr: {Bool: pointer.Of(nvlink)},
+	}
+}
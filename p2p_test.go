@@ -0,0 +1,69 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"testing"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+	"github.com/shoenig/test/must"
+)
+
+func TestP2PAttributes(t *testing.T) {
+	gpu := func(uuid, parentUUID string) *nvml.FingerprintDeviceData {
+		return &nvml.FingerprintDeviceData{
+			DeviceData: &nvml.DeviceData{UUID: uuid},
+			ParentUUID: parentUUID,
+		}
+	}
+	statuses := []*nvml.P2PStatus{
+		{UUID: "UUID1", PeerUUID: "UUID2", Read: true, Write: true, NVLink: true},
+		{UUID: "UUID1", PeerUUID: "UUID3", Read: true, Write: true},
+		{UUID: "UUID2", PeerUUID: "UUID3", Read: true},
+	}
+
+	cases := []struct {
+		Name     string
+		Devices  []*nvml.FingerprintDeviceData
+		Expected map[string]*structs.Attribute
+	}{
+		{
+			Name:    "nvlink pair",
+			Devices: []*nvml.FingerprintDeviceData{gpu("UUID2", ""), gpu("UUID1", "")},
+			Expected: map[string]*structs.Attribute{
+				P2PReadAttr:   {Bool: pointer.Of(true)},
+				P2PWriteAttr:  {Bool: pointer.Of(true)},
+				P2PNVLinkAttr: {Bool: pointer.Of(true)},
+			},
+		},
+		{
+			Name:    "capabilities shared by all pairs",
+			Devices: []*nvml.FingerprintDeviceData{gpu("UUID1", ""), gpu("UUID2", ""), gpu("UUID3", "")},
+			Expected: map[string]*structs.Attribute{
+				P2PReadAttr:   {Bool: pointer.Of(true)},
+				P2PWriteAttr:  {Bool: pointer.Of(false)},
+				P2PNVLinkAttr: {Bool: pointer.Of(false)},
+			},
+		},
+		{
+			Name:    "single device",
+			Devices: []*nvml.FingerprintDeviceData{gpu("UUID1", "")},
+		},
+		{
+			Name:    "unknown pair",
+			Devices: []*nvml.FingerprintDeviceData{gpu("UUID1", ""), gpu("UUID4", "")},
+		},
+		{
+			Name:    "MIG devices",
+			Devices: []*nvml.FingerprintDeviceData{gpu("MIG-1", "UUID1"), gpu("MIG-2", "UUID2")},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			must.Eq(t, c.Expected, p2pAttributes(c.Devices, statuses))
+		})
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import "github.com/hashicorp/nomad-device-nvidia/nvml"
+
+// PerformanceScoreAttr is the relative performance of the devices of a group,
+// which affinities can prefer when the groups of several models match
+const PerformanceScoreAttr = "performance_score"
+
+// performanceScores are the default performance scores of device models,
+// keyed by device name: their dense FP16 tensor throughput in TFLOPS, as
+// published by Nvidia. MIG devices have no default score.
+var performanceScores = map[string]int64{
+	"Tesla T4":                       65,
+	"Tesla V100-PCIE-16GB":           112,
+	"Tesla V100-PCIE-32GB":           112,
+	"Tesla V100-SXM2-16GB":           125,
+	"Tesla V100-SXM2-32GB":           125,
+	"NVIDIA A10":                     125,
+	"NVIDIA A30":                     165,
+	"NVIDIA A40":                     150,
+	"NVIDIA A100-PCIE-40GB":          312,
+	"NVIDIA A100 80GB PCIe":          312,
+	"NVIDIA A100-SXM4-40GB":          312,
+	"NVIDIA A100-SXM4-80GB":          312,
+	"NVIDIA L4":                      121,
+	"NVIDIA L40":                     181,
+	"NVIDIA L40S":                    362,
+	"NVIDIA H100 PCIe":               756,
+	"NVIDIA H100 NVL":                835,
+	"NVIDIA H100 80GB HBM3":          989,
+	"NVIDIA H200":                    989,
+	"NVIDIA GH200 480GB":             989,
+	"NVIDIA GeForce RTX 4090":        165,
+	"NVIDIA RTX 6000 Ada Generation": 182,
+}
+
+// performanceScore returns the performance score of the device group with the
+// given name and devices, and whether the group has one. Scores configured
+// with the performance_scores option are keyed by group name, while default
+// scores are looked up by the model of the devices, so that renamed groups
+// keep them. Flattened groups mixing models have no default score.
+func (d *NvidiaDevice) performanceScore(groupName string, deviceList []*nvml.FingerprintDeviceData) (int64, bool) {
+	if score, ok := d.performanceScores[groupName]; ok {
+		return score, true
+	}
+	if len(deviceList) == 0 || deviceList[0].DeviceName == nil {
+		return 0, false
+	}
+	model := *deviceList[0].DeviceName
+	for _, dev := range deviceList[1:] {
+		if dev.DeviceName == nil || *dev.DeviceName != model {
+			return 0, false
+		}
+	}
+	score, ok := performanceScores[model]
+	return score, ok
+}
@@ -0,0 +1,122 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+	"github.com/shoenig/test/must"
+)
+
+func TestPerformanceScore(t *testing.T) {
+	d := &NvidiaDevice{
+		performanceScores: map[string]int64{
+			"Tesla T4":     80,
+			"Custom Board": 42,
+		},
+	}
+	devices := func(names ...string) []*nvml.FingerprintDeviceData {
+		var deviceList []*nvml.FingerprintDeviceData
+		for _, name := range names {
+			deviceList = append(deviceList, &nvml.FingerprintDeviceData{
+				DeviceData: &nvml.DeviceData{DeviceName: pointer.Of(name)},
+			})
+		}
+		return deviceList
+	}
+
+	cases := []struct {
+		Name      string
+		GroupName string
+		Devices   []*nvml.FingerprintDeviceData
+		Score     int64
+		Found     bool
+	}{
+		{
+			Name:      "configured score overrides default",
+			GroupName: "Tesla T4",
+			Devices:   devices("Tesla T4"),
+			Score:     80,
+			Found:     true,
+		},
+		{
+			Name:      "configured score",
+			GroupName: "Custom Board",
+			Devices:   devices("Custom Board"),
+			Score:     42,
+			Found:     true,
+		},
+		{
+			Name:      "default score",
+			GroupName: "NVIDIA H100 80GB HBM3",
+			Devices:   devices("NVIDIA H100 80GB HBM3", "NVIDIA H100 80GB HBM3"),
+			Score:     989,
+			Found:     true,
+		},
+		{
+			Name:      "renamed group keeps the default score of its model",
+			GroupName: "h100",
+			Devices:   devices("NVIDIA H100 80GB HBM3"),
+			Score:     989,
+			Found:     true,
+		},
+		{
+			Name:      "flattened group of a single model",
+			GroupName: FlatGroupName,
+			Devices:   devices("NVIDIA L4", "NVIDIA L4"),
+			Score:     121,
+			Found:     true,
+		},
+		{
+			Name:      "flattened group mixing models has no default score",
+			GroupName: FlatGroupName,
+			Devices:   devices("NVIDIA L4", "Tesla T4"),
+		},
+		{
+			Name:      "MIG devices have no default score",
+			GroupName: "NVIDIA A100-SXM4-40GB MIG 3g.20gb",
+			Devices:   devices("NVIDIA A100-SXM4-40GB MIG 3g.20gb"),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			score, ok := d.performanceScore(c.GroupName, c.Devices)
+			must.Eq(t, c.Found, ok)
+			must.Eq(t, c.Score, score)
+		})
+	}
+}
+
+func TestPerformanceScoresConfig(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := newReplayDevice(t)
+	must.NoError(t, setPluginConfig(t, d, `
+config {
+  performance_scores {
+    "Tesla T4" = 80
+  }
+}
+`))
+	fingerprints, err := d.Fingerprint(ctx)
+	must.NoError(t, err)
+	fingerprint := <-fingerprints
+	must.NoError(t, fingerprint.Error)
+	must.Eq(t, &structs.Attribute{Int: pointer.Of(int64(80))},
+		fingerprint.Devices[0].Attributes[PerformanceScoreAttr])
+
+	err = setPluginConfig(t, newReplayDevice(t), `
+config {
+  performance_scores {
+    "Tesla T4" = -1
+  }
+}
+`)
+	must.ErrorContains(t, err, `invalid performance score -1 of "Tesla T4"`)
+}
@@ -0,0 +1,95 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+)
+
+const (
+	// PersistencedRunningAttr is whether the Nvidia persistence daemon runs
+	// on the node, it is reported on every device group
+	PersistencedRunningAttr = "persistenced_running"
+
+	// persistencedName is the command name of the Nvidia persistence daemon
+	persistencedName = "nvidia-persistenced"
+
+	// persistenceModeWarningDesc is the health description of healthy
+	// datacenter GPUs running with persistence mode disabled
+	persistenceModeWarningDesc = "persistence mode is disabled, GPU processes may start slowly"
+)
+
+// datacenterBrands are the brands of datacenter GPUs, which are expected to
+// run with persistence mode enabled
+var datacenterBrands = map[string]struct{}{
+	"tesla":  {},
+	"nvidia": {},
+}
+
+// persistencedRunning reports whether a nvidia-persistenced process runs,
+// looking up the command names of the processes in procfs
+func persistencedRunning() bool {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil || !entry.IsDir() {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join(procRoot, entry.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+		if string(bytes.TrimSpace(comm)) == persistencedName {
+			return true
+		}
+	}
+	return false
+}
+
+// persistenceModeDisabled reports whether dev is a datacenter GPU running
+// with persistence mode disabled, in which case the driver tears the GPU
+// state down whenever no process uses it, slowing down the next start of a
+// GPU process and the fingerprints of the device. MIG devices are covered by
+// their physical GPU.
+func persistenceModeDisabled(dev *nvml.FingerprintDeviceData) bool {
+	if dev.ParentUUID != "" || dev.Brand == nil {
+		return false
+	}
+	if _, ok := datacenterBrands[*dev.Brand]; !ok {
+		return false
+	}
+	return dev.PersistenceMode == "Disabled"
+}
+
+// checkPersistenceMode records the datacenter GPUs running with persistence
+// mode disabled, which are reported healthy with a warning as their health
+// description, and logs a warning when a device is first found disabled
+func (d *NvidiaDevice) checkPersistenceMode(devices []*nvml.FingerprintDeviceData, persistenced bool) {
+	if !d.persistenceModeWarning {
+		return
+	}
+
+	warnings := make(map[string]struct{})
+	for _, dev := range devices {
+		if !persistenceModeDisabled(dev) {
+			continue
+		}
+		warnings[dev.UUID] = struct{}{}
+		if _, ok := d.persistenceWarnings[dev.UUID]; !ok {
+			d.logger.Warn("persistence mode is disabled on datacenter GPU, GPU processes may start slowly",
+				"uuid", dev.UUID, "persistenced_running", persistenced)
+		}
+	}
+
+	d.deviceLock.Lock()
+	d.persistenceWarnings = warnings
+	d.deviceLock.Unlock()
+}
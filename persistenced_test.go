@@ -0,0 +1,135 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/shoenig/test/must"
+)
+
+// setupProcComm points procRoot to a temporary directory holding the command
+// names of the given processes
+func setupProcComm(t *testing.T, comms map[int]string) {
+	setupProcRoot(t, nil)
+	for pid, comm := range comms {
+		dir := filepath.Join(procRoot, fmt.Sprint(pid))
+		must.NoError(t, os.Mkdir(dir, 0o755))
+		must.NoError(t, os.WriteFile(filepath.Join(dir, "comm"), []byte(comm+"\n"), 0o644))
+	}
+}
+
+func TestPersistencedRunning(t *testing.T) {
+	setupProcComm(t, map[int]string{1: "systemd", 812: "sshd"})
+	must.False(t, persistencedRunning())
+
+	setupProcComm(t, map[int]string{1: "systemd", 1404: "nvidia-persistenced"})
+	must.True(t, persistencedRunning())
+}
+
+func TestPersistenceModeDisabled(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Device   *nvml.FingerprintDeviceData
+		Expected bool
+	}{
+		{
+			Name: "datacenter GPU disabled",
+			Device: &nvml.FingerprintDeviceData{
+				DeviceData:      &nvml.DeviceData{UUID: "UUID1"},
+				Brand:           pointer.Of("tesla"),
+				PersistenceMode: "Disabled",
+			},
+			Expected: true,
+		},
+		{
+			Name: "datacenter GPU enabled",
+			Device: &nvml.FingerprintDeviceData{
+				DeviceData:      &nvml.DeviceData{UUID: "UUID1"},
+				Brand:           pointer.Of("nvidia"),
+				PersistenceMode: "Enabled",
+			},
+			Expected: false,
+		},
+		{
+			Name: "consumer GPU disabled",
+			Device: &nvml.FingerprintDeviceData{
+				DeviceData:      &nvml.DeviceData{UUID: "UUID1"},
+				Brand:           pointer.Of("geforce_rtx"),
+				PersistenceMode: "Disabled",
+			},
+			Expected: false,
+		},
+		{
+			Name: "unknown brand",
+			Device: &nvml.FingerprintDeviceData{
+				DeviceData:      &nvml.DeviceData{UUID: "UUID1"},
+				PersistenceMode: "Disabled",
+			},
+			Expected: false,
+		},
+		{
+			Name: "MIG device",
+			Device: &nvml.FingerprintDeviceData{
+				DeviceData:      &nvml.DeviceData{UUID: "MIG1"},
+				ParentUUID:      "UUID1",
+				Brand:           pointer.Of("nvidia"),
+				PersistenceMode: "Disabled",
+			},
+			Expected: false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			must.Eq(t, c.Expected, persistenceModeDisabled(c.Device))
+		})
+	}
+}
+
+func TestCheckPersistenceMode(t *testing.T) {
+	devices := []*nvml.FingerprintDeviceData{
+		{
+			DeviceData:      &nvml.DeviceData{UUID: "UUID1"},
+			Brand:           pointer.Of("tesla"),
+			PersistenceMode: "Disabled",
+		},
+		{
+			DeviceData:      &nvml.DeviceData{UUID: "UUID2"},
+			Brand:           pointer.Of("tesla"),
+			PersistenceMode: "Enabled",
+		},
+	}
+	d := &NvidiaDevice{logger: hclog.NewNullLogger()}
+	d.checkPersistenceMode(devices, false)
+	must.MapEmpty(t, d.persistenceWarnings)
+
+	d.persistenceModeWarning = true
+	d.checkPersistenceMode(devices, false)
+	must.MapLen(t, 1, d.persistenceWarnings)
+	must.MapContainsKey(t, d.persistenceWarnings, "UUID1")
+
+	// the warning is reported as the health description of healthy devices
+	groups := []*device.DeviceGroup{{
+		Devices: []*device.Device{
+			{ID: "UUID1", Healthy: true},
+			{ID: "UUID2", Healthy: true},
+		},
+	}}
+	d.applyDeviceHealth(groups)
+	must.True(t, groups[0].Devices[0].Healthy)
+	must.Eq(t, persistenceModeWarningDesc, groups[0].Devices[0].HealthDesc)
+	must.Eq(t, "", groups[0].Devices[1].HealthDesc)
+
+	// the warning is cleared once persistence mode is enabled
+	devices[0].PersistenceMode = "Enabled"
+	d.checkPersistenceMode(devices, true)
+	must.MapEmpty(t, d.persistenceWarnings)
+}
@@ -0,0 +1,78 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package plugin is the entrypoint of the Nvidia device plugin for binaries
+// embedding it, such as custom Nomad builds registering it as a built-in
+// device plugin, which avoids managing an external plugin binary on immutable
+// images. Importing the package registers the net/http/pprof and expvar
+// handlers on http.DefaultServeMux, as the debug_listen endpoint imports
+// those packages, even though the plugin serves them on its own mux.
+//
+// Nomad builds register the plugin in the catalog of internal plugins:
+//
+//	plugin.Register(catalog.Register)
+//
+// and handle the NVML worker processes of the isolate_nvml option first thing
+// in main:
+//
+//	plugin.RunWorker()
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+	nvidia "github.com/hashicorp/nomad-device-nvidia"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pluginutils/loader"
+	"github.com/hashicorp/nomad/plugins"
+)
+
+// ID identifies the plugin in the plugin catalog of Nomad
+var ID = nvidia.PluginID
+
+// Factory returns the factory creating instances of the plugin with the given
+// options
+func Factory(opts ...nvidia.Option) plugins.PluginCtxFactory {
+	return func(ctx context.Context, log hclog.Logger) interface{} {
+		return nvidia.NewNvidiaDevice(ctx, log, opts...)
+	}
+}
+
+// Config returns the configuration registering the plugin as an internal
+// plugin of Nomad, creating instances with the given options
+func Config(opts ...nvidia.Option) *loader.InternalPluginConfig {
+	return &loader.InternalPluginConfig{
+		Factory: Factory(opts...),
+	}
+}
+
+// Register registers the plugin as an internal plugin with register, which is
+// catalog.Register of Nomad, creating instances with the given options
+func Register(register func(loader.PluginID, *loader.InternalPluginConfig), opts ...nvidia.Option) {
+	register(ID, Config(opts...))
+}
+
+// Serve serves the plugin as an external plugin binary, creating instances
+// with the given options
+func Serve(opts ...nvidia.Option) {
+	RunWorker()
+	plugins.ServeCtx(Factory(opts...))
+}
+
+// RunWorker serves NVML calls and exits when the process was started as an
+// NVML worker of the isolate_nvml option, and returns otherwise. The workers
+// are started from the executable of the process, so binaries embedding the
+// plugin must call it before anything else for isolate_nvml to work.
+func RunWorker() {
+	if !nvml.IsIsolatedWorker() {
+		return
+	}
+	if err := nvml.RunIsolatedWorker(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,39 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	nvidia "github.com/hashicorp/nomad-device-nvidia"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pluginutils/loader"
+	"github.com/hashicorp/nomad/plugins/base"
+	"github.com/shoenig/test/must"
+)
+
+func TestRegister(t *testing.T) {
+	registered := make(map[loader.PluginID]*loader.InternalPluginConfig)
+	register := func(id loader.PluginID, config *loader.InternalPluginConfig) {
+		registered[id] = config
+	}
+
+	client := nvml.NewReplayClient(&nvml.Recording{DriverVersion: "550.54.15"})
+	Register(register, nvidia.WithNvmlClient(client))
+	must.MapLen(t, 1, registered)
+	must.Eq(t, "nvidia-gpu", ID.Name)
+	must.Eq(t, base.PluginTypeDevice, ID.PluginType)
+
+	config := registered[ID]
+	must.NotNil(t, config)
+	instance := config.Factory(context.Background(), hclog.NewNullLogger())
+	d, ok := instance.(*nvidia.NvidiaDevice)
+	must.True(t, ok)
+
+	info, err := d.PluginInfo()
+	must.NoError(t, err)
+	must.Eq(t, "nvidia-gpu", info.Name)
+}
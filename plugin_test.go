@@ -0,0 +1,194 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pluginutils/hclspecutils"
+	"github.com/hashicorp/nomad/helper/pluginutils/hclutils"
+	"github.com/hashicorp/nomad/plugins/base"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/shoenig/test/must"
+	"github.com/zclconf/go-cty/cty/msgpack"
+)
+
+// pluginConfig is the plugin configuration of the end-to-end tests, in the
+// syntax of the plugin block of Nomad client agents
+const pluginConfig = `
+config {
+  enabled            = true
+  fingerprint_period = "1m"
+}
+`
+
+// newReplayDevice returns a device plugin replaying the T4 recording
+func newReplayDevice(t *testing.T) *NvidiaDevice {
+	recording, err := nvml.ReadRecording("testdata/recordings/t4.json")
+	must.NoError(t, err)
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return NewNvidiaDevice(context.Background(), hclog.NewNullLogger(),
+		WithNvmlClient(nvml.NewReplayClient(recording)),
+		WithClock(ClockFunc(func() time.Time { return at })),
+	)
+}
+
+// dispensePlugin serves d over the gRPC protocol of Nomad device plugins and
+// returns the client Nomad uses to talk to it
+func dispensePlugin(t *testing.T, d *NvidiaDevice) device.DevicePlugin {
+	// closing the client stops the server through the plugin controller,
+	// stopping the server as well races with it
+	client, _ := plugin.TestPluginGRPCConn(t, true, map[string]plugin.Plugin{
+		base.PluginTypeBase:   &base.PluginBase{Impl: d},
+		base.PluginTypeDevice: &device.PluginDevice{Impl: d},
+	})
+	t.Cleanup(func() {
+		client.Close()
+	})
+
+	raw, err := client.Dispense(base.PluginTypeDevice)
+	must.NoError(t, err)
+	impl, ok := raw.(device.DevicePlugin)
+	must.True(t, ok)
+	return impl
+}
+
+// setPluginConfig configures the plugin the way Nomad client agents do, by
+// decoding config with the schema of the plugin and encoding it to msgpack
+func setPluginConfig(t *testing.T, impl base.BasePlugin, config string) error {
+	schema, err := impl.ConfigSchema()
+	must.NoError(t, err)
+	spec, diags := hclspecutils.Convert(schema)
+	must.SliceEmpty(t, diags)
+
+	value, diags, errs := hclutils.ParseHclInterface(hclutils.HclConfigToInterface(t, config), spec, nil)
+	must.SliceEmpty(t, errs)
+	must.SliceEmpty(t, diags)
+	data, err := msgpack.Marshal(value, value.Type())
+	must.NoError(t, err)
+
+	return impl.SetConfig(&base.Config{PluginConfig: data})
+}
+
+// withoutEmptyNested replaces the empty nested stats of groups decoded from
+// the plugin protocol with nil, as they are emitted
+func withoutEmptyNested(groups []*device.DeviceGroupStats) []*device.DeviceGroupStats {
+	for _, group := range groups {
+		for _, instance := range group.InstanceStats {
+			if instance.Stats != nil && len(instance.Stats.Nested) == 0 {
+				instance.Stats.Nested = nil
+			}
+		}
+	}
+	return groups
+}
+
+func TestPlugin_PluginInfo(t *testing.T) {
+	impl := dispensePlugin(t, newReplayDevice(t))
+
+	info, err := impl.PluginInfo()
+	must.NoError(t, err)
+	must.Eq(t, pluginInfo, info)
+}
+
+func TestPlugin_SetConfig(t *testing.T) {
+	impl := dispensePlugin(t, newReplayDevice(t))
+
+	must.NoError(t, setPluginConfig(t, impl, pluginConfig))
+
+	// validation errors are returned to Nomad
+	err := setPluginConfig(t, impl, `
+config {
+  power_unit = "kW"
+}
+`)
+	must.ErrorContains(t, err, `invalid power unit "kW"`)
+
+	// milliwatts are only reported where NVML is queried
+	err = setPluginConfig(t, impl, `
+config {
+  power_unit = "mW"
+}
+`)
+	if runtime.GOOS == "linux" {
+		must.NoError(t, err)
+	} else {
+		must.ErrorContains(t, err, "milliwatts are only reported on Linux")
+	}
+
+	// agents negotiating an API version the plugin does not serve are
+	// rejected
+	err = impl.SetConfig(&base.Config{ApiVersion: "v0.2.0"})
+	must.ErrorContains(t, err, `unsupported device plugin API version "v0.2.0"`)
+}
+
+// TestPlugin_FingerprintStatsReserve checks that the fingerprint, stats and
+// reservations received by Nomad over the plugin protocol are those of the
+// plugin, so that no data is lost in serialization
+func TestPlugin_FingerprintStatsReserve(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local := newReplayDevice(t)
+	must.NoError(t, setPluginConfig(t, local, pluginConfig))
+	impl := dispensePlugin(t, newReplayDevice(t))
+	must.NoError(t, setPluginConfig(t, impl, pluginConfig))
+
+	localFingerprints, err := local.Fingerprint(ctx)
+	must.NoError(t, err)
+	fingerprints, err := impl.Fingerprint(ctx)
+	must.NoError(t, err)
+
+	expectedFingerprint := <-localFingerprints
+	must.NoError(t, expectedFingerprint.Error)
+	var fingerprint *device.FingerprintResponse
+	select {
+	case fingerprint = <-fingerprints:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for fingerprint")
+	}
+	must.NoError(t, fingerprint.Error)
+	must.Eq(t, expectedFingerprint.Devices, fingerprint.Devices)
+	must.Len(t, 1, fingerprint.Devices)
+	must.Eq(t, "Tesla T4", fingerprint.Devices[0].Name)
+
+	localStats, err := local.Stats(ctx, time.Minute)
+	must.NoError(t, err)
+	stats, err := impl.Stats(ctx, time.Minute)
+	must.NoError(t, err)
+
+	expectedStats := <-localStats
+	must.NoError(t, expectedStats.Error)
+	var statsResponse *device.StatsResponse
+	select {
+	case statsResponse = <-stats:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for stats")
+	}
+	must.NoError(t, statsResponse.Error)
+	must.Eq(t, expectedStats.Groups, withoutEmptyNested(statsResponse.Groups))
+
+	uuid := fingerprint.Devices[0].Devices[0].ID
+	expectedReservation, err := local.Reserve([]string{uuid})
+	must.NoError(t, err)
+	reservation, err := impl.Reserve([]string{uuid})
+	must.NoError(t, err)
+	must.Eq(t, expectedReservation, reservation)
+	must.Eq(t, uuid, reservation.Envs[NvidiaVisibleDevices])
+
+	_, err = impl.Reserve([]string{"GPU-unknown"})
+	must.ErrorContains(t, err, "GPU-unknown")
+
+	// the configuration can not change under the running fingerprint and
+	// stats goroutines
+	err = setPluginConfig(t, impl, pluginConfig)
+	must.ErrorContains(t, err, "plugin configuration can not be changed once fingerprinting or stats started")
+}
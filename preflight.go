@@ -0,0 +1,44 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+)
+
+// DevicesPreflightFailedAttr is the number of devices of the node that failed
+// the preflight check and are not advertised, it is reported on every device
+// group when the preflight check is enabled
+const DevicesPreflightFailedAttr = "devices_preflight_failed"
+
+// preflightDevices runs the preflight check on the devices that were not
+// checked before and returns the devices that passed it. Devices are checked
+// once, when they are first fingerprinted, and devices that failed are not
+// advertised until the plugin restarts.
+func (d *NvidiaDevice) preflightDevices(deviceData []*nvml.FingerprintDeviceData) []*nvml.FingerprintDeviceData {
+	if !d.preflightCheck {
+		return deviceData
+	}
+	if d.preflightResults == nil {
+		d.preflightResults = make(map[string]error)
+	}
+
+	var passed []*nvml.FingerprintDeviceData
+	for _, dev := range deviceData {
+		err, checked := d.preflightResults[dev.UUID]
+		if !checked {
+			err = d.collector.Preflight(dev.UUID)
+			if err != nil {
+				d.logger.Error("device failed preflight check and will not be advertised", "uuid", dev.UUID, "error", err)
+			} else {
+				d.logger.Info("device passed preflight check", "uuid", dev.UUID)
+			}
+			d.preflightResults[dev.UUID] = err
+		}
+		if err == nil {
+			passed = append(passed, dev)
+		}
+	}
+	return passed
+}
@@ -0,0 +1,60 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/shoenig/test/must"
+)
+
+func TestPreflightDevices(t *testing.T) {
+	client := &MockNvmlClient{
+		FingerprintResponseReturned: &nvml.FingerprintData{
+			DriverVersion: "1",
+			Devices: []*nvml.FingerprintDeviceData{
+				{
+					DeviceData: &nvml.DeviceData{
+						UUID:       "1",
+						DeviceName: pointer.Of("Name1"),
+					},
+				},
+				{
+					DeviceData: &nvml.DeviceData{
+						UUID:       "2",
+						DeviceName: pointer.Of("Name1"),
+					},
+				},
+			},
+		},
+		PreflightErrors: map[string]error{
+			"2": errors.New("device compute mode prohibits compute work"),
+		},
+	}
+	d := &NvidiaDevice{
+		collector:      client,
+		preflightCheck: true,
+		logger:         hclog.NewNullLogger(),
+	}
+
+	channel := make(chan *device.FingerprintResponse, 1)
+	hash := d.writeFingerprintToChannel(channel, "")
+	result := <-channel
+	must.Len(t, 1, result.Devices)
+	must.Len(t, 1, result.Devices[0].Devices)
+	must.Eq(t, "1", result.Devices[0].Devices[0].ID)
+	must.Eq(t, int64(1), *result.Devices[0].Attributes[DevicesPreflightFailedAttr].Int)
+	must.MapNotContainsKey(t, d.devices, "2")
+
+	// devices are only checked when first fingerprinted
+	client.PreflightErrors = nil
+	d.writeFingerprintToChannel(channel, hash)
+	must.Eq(t, 0, len(channel))
+	must.MapNotContainsKey(t, d.devices, "2")
+}
@@ -0,0 +1,116 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+)
+
+const (
+	// Attribute names counting the GPUs of the node seen only by the kernel
+	// driver or only by NVML, they are reported on every device group when
+	// the device presence check is enabled
+	DevicesKernelOnlyAttr = "devices_kernel_only"
+	DevicesNVMLOnlyAttr   = "devices_nvml_only"
+)
+
+// presenceMismatch holds the PCI addresses of the GPUs seen only by the
+// kernel driver or only by NVML
+type presenceMismatch struct {
+	kernelOnly []string
+	nvmlOnly   []string
+}
+
+// kernelGPUs returns the sysfs PCI addresses of the GPUs the Nvidia kernel
+// driver lists in /proc/driver/nvidia/gpus
+func kernelGPUs() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(procRoot, "driver", "nvidia", "gpus"))
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			addresses = append(addresses, entry.Name())
+		}
+	}
+	return addresses, nil
+}
+
+// compareDevicePresence compares the physical GPUs reported by NVML with
+// the GPUs listed by the kernel driver. MIG devices count for the PCI address
+// of their physical GPU and devices without a PCI bus ID are left out.
+func compareDevicePresence(deviceData []*nvml.FingerprintDeviceData, kernelAddresses []string) *presenceMismatch {
+	nvmlAddresses := make(map[string]struct{})
+	for _, dev := range deviceData {
+		if dev.PCIBusID == "" {
+			continue
+		}
+		address, err := sysfsPCIAddress(dev.PCIBusID)
+		if err != nil {
+			continue
+		}
+		nvmlAddresses[address] = struct{}{}
+	}
+
+	mismatch := &presenceMismatch{}
+	kernel := make(map[string]struct{}, len(kernelAddresses))
+	for _, address := range kernelAddresses {
+		kernel[address] = struct{}{}
+		if _, ok := nvmlAddresses[address]; !ok {
+			mismatch.kernelOnly = append(mismatch.kernelOnly, address)
+		}
+	}
+	for address := range nvmlAddresses {
+		if _, ok := kernel[address]; !ok {
+			mismatch.nvmlOnly = append(mismatch.nvmlOnly, address)
+		}
+	}
+	slices.Sort(mismatch.kernelOnly)
+	slices.Sort(mismatch.nvmlOnly)
+	return mismatch
+}
+
+// checkDevicePresence cross-checks the devices reported by NVML with the
+// GPUs known to the kernel driver and returns the attributes counting the
+// discrepancies. A warning is logged whenever the discrepancies change. No
+// attributes are returned when the check is disabled or when the kernel
+// driver does not list its GPUs.
+func (d *NvidiaDevice) checkDevicePresence(deviceData []*nvml.FingerprintDeviceData) map[string]*structs.Attribute {
+	if !d.devicePresenceCheck {
+		return nil
+	}
+
+	kernelAddresses, err := kernelGPUs()
+	if err != nil {
+		d.errorLog.Error(d.logger, "failed to list GPUs of the Nvidia kernel driver", err)
+		return nil
+	}
+	mismatch := compareDevicePresence(deviceData, kernelAddresses)
+
+	last := d.lastPresenceMismatch
+	d.lastPresenceMismatch = mismatch
+	changed := last == nil ||
+		!slices.Equal(last.kernelOnly, mismatch.kernelOnly) ||
+		!slices.Equal(last.nvmlOnly, mismatch.nvmlOnly)
+	if changed && (len(mismatch.kernelOnly) != 0 || len(mismatch.nvmlOnly) != 0) {
+		d.logger.Warn("GPUs seen by the Nvidia kernel driver and NVML differ",
+			"kernel_only", mismatch.kernelOnly, "nvml_only", mismatch.nvmlOnly)
+	}
+
+	return map[string]*structs.Attribute{
+		DevicesKernelOnlyAttr: {
+			Int: pointer.Of(int64(len(mismatch.kernelOnly))),
+		},
+		DevicesNVMLOnlyAttr: {
+			Int: pointer.Of(int64(len(mismatch.nvmlOnly))),
+		},
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/shoenig/test/must"
+)
+
+// setupKernelGPUs points procRoot to a temporary directory in which the
+// Nvidia kernel driver lists the GPUs with the given PCI addresses
+func setupKernelGPUs(t *testing.T, addresses ...string) {
+	setupProcRoot(t, nil)
+	for _, address := range addresses {
+		dir := filepath.Join(procRoot, "driver", "nvidia", "gpus", address)
+		must.NoError(t, os.MkdirAll(dir, 0o755))
+	}
+}
+
+func fingerprintDeviceWithBusID(uuid, parentUUID, busID string) *nvml.FingerprintDeviceData {
+	return &nvml.FingerprintDeviceData{
+		DeviceData: &nvml.DeviceData{UUID: uuid},
+		ParentUUID: parentUUID,
+		PCIBusID:   busID,
+	}
+}
+
+func TestCompareDevicePresence(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Devices  []*nvml.FingerprintDeviceData
+		Kernel   []string
+		Expected *presenceMismatch
+	}{
+		{
+			Name: "same devices",
+			Devices: []*nvml.FingerprintDeviceData{
+				fingerprintDeviceWithBusID("UUID1", "", "00000000:3B:00.0"),
+				fingerprintDeviceWithBusID("UUID2", "", "00000000:AF:00.0"),
+			},
+			Kernel:   []string{"0000:3b:00.0", "0000:af:00.0"},
+			Expected: &presenceMismatch{},
+		},
+		{
+			Name: "MIG devices share the address of their parent",
+			Devices: []*nvml.FingerprintDeviceData{
+				fingerprintDeviceWithBusID("MIG1", "UUID1", "00000000:3B:00.0"),
+				fingerprintDeviceWithBusID("MIG2", "UUID1", "00000000:3B:00.0"),
+			},
+			Kernel:   []string{"0000:3b:00.0"},
+			Expected: &presenceMismatch{},
+		},
+		{
+			Name: "MIG parents",
+			Devices: []*nvml.FingerprintDeviceData{
+				fingerprintDeviceWithBusID("UUID1", "", "00000000:3B:00.0"),
+				fingerprintDeviceWithBusID("MIG1", "UUID1", ""),
+			},
+			Kernel:   []string{"0000:3b:00.0"},
+			Expected: &presenceMismatch{},
+		},
+		{
+			Name: "devices missing from NVML",
+			Devices: []*nvml.FingerprintDeviceData{
+				fingerprintDeviceWithBusID("UUID1", "", "00000000:3B:00.0"),
+			},
+			Kernel: []string{"0000:d8:00.0", "0000:3b:00.0", "0000:af:00.0"},
+			Expected: &presenceMismatch{
+				kernelOnly: []string{"0000:af:00.0", "0000:d8:00.0"},
+			},
+		},
+		{
+			Name: "devices missing from the kernel",
+			Devices: []*nvml.FingerprintDeviceData{
+				fingerprintDeviceWithBusID("UUID1", "", "00000000:3B:00.0"),
+				fingerprintDeviceWithBusID("UUID2", "", "00000000:AF:00.0"),
+				fingerprintDeviceWithBusID("UUID3", "", ""),
+			},
+			Kernel: []string{"0000:3b:00.0"},
+			Expected: &presenceMismatch{
+				nvmlOnly: []string{"0000:af:00.0"},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			must.Eq(t, c.Expected, compareDevicePresence(c.Devices, c.Kernel))
+		})
+	}
+}
+
+func TestCheckDevicePresence(t *testing.T) {
+	setupKernelGPUs(t, "0000:3b:00.0", "0000:af:00.0")
+	devices := []*nvml.FingerprintDeviceData{
+		fingerprintDeviceWithBusID("UUID1", "", "00000000:3B:00.0"),
+	}
+
+	d := &NvidiaDevice{
+		logger:   hclog.NewNullLogger(),
+		errorLog: newErrorLogLimiter(0, nil),
+	}
+	must.MapEmpty(t, d.checkDevicePresence(devices))
+
+	d.devicePresenceCheck = true
+	attrs := d.checkDevicePresence(devices)
+	must.Eq(t, int64(1), *attrs[DevicesKernelOnlyAttr].Int)
+	must.Eq(t, int64(0), *attrs[DevicesNVMLOnlyAttr].Int)
+	must.Eq(t, []string{"0000:af:00.0"}, d.lastPresenceMismatch.kernelOnly)
+
+	// no attributes are reported when the kernel driver lists no GPUs
+	setupProcRoot(t, nil)
+	must.MapEmpty(t, d.checkDevicePresence(devices))
+}
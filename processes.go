@@ -0,0 +1,85 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// handleLeftoverProcesses looks for compute processes still running on
+// deviceIDs. Nomad does not notify device plugins when an allocation exits,
+// so processes found on a device being reserved were left over by a previous
+// tenant. Depending on the leftover_processes action they are logged, the
+// device is marked unhealthy and the reservation rejected, or they are killed.
+func (d *NvidiaDevice) handleLeftoverProcesses(deviceIDs []string) error {
+	if d.leftoverProcesses == "" {
+		return nil
+	}
+
+	var busyIDs []string
+	for _, id := range deviceIDs {
+		pids, err := d.collector.GetComputeProcesses(id)
+		if err != nil {
+			d.logger.Warn("failed to get device compute processes", "uuid", id, "error", err)
+			continue
+		}
+		if len(pids) == 0 {
+			continue
+		}
+
+		d.logger.Warn("found leftover compute processes on device", "uuid", id, "pids", pids, "action", d.leftoverProcesses)
+		switch d.leftoverProcesses {
+		case leftoverProcessesUnhealthy:
+			d.setDeviceUnhealthy(id, healthCauseLeftoverProcesses, leftoverProcessesReason(pids))
+			busyIDs = append(busyIDs, id)
+		case leftoverProcessesKill:
+			d.killProcesses(id, pids)
+		}
+	}
+
+	if len(busyIDs) != 0 {
+		return &reservationError{ReservationErrBusy, busyIDs}
+	}
+	return nil
+}
+
+// recheckLeftoverProcesses marks devices that were marked unhealthy because
+// of leftover processes healthy again once those processes are gone
+func (d *NvidiaDevice) recheckLeftoverProcesses() {
+	for _, id := range d.unhealthyDevices(healthCauseLeftoverProcesses) {
+		pids, err := d.collector.GetComputeProcesses(id)
+		if err != nil {
+			d.logger.Warn("failed to get device compute processes", "uuid", id, "error", err)
+			continue
+		}
+		if len(pids) == 0 {
+			d.setDeviceHealthy(id, healthCauseLeftoverProcesses)
+		}
+	}
+}
+
+// leftoverProcessesReason returns the health description of a device with
+// the given leftover processes
+func leftoverProcessesReason(pids []int) string {
+	s := make([]string, len(pids))
+	for i, pid := range pids {
+		s[i] = fmt.Sprint(pid)
+	}
+	return fmt.Sprintf("leftover compute processes: %s", strings.Join(s, ","))
+}
+
+// killProcesses kills the given leftover processes of the device id
+func (d *NvidiaDevice) killProcesses(id string, pids []int) {
+	for _, pid := range pids {
+		process, err := os.FindProcess(pid)
+		if err == nil {
+			err = process.Kill()
+		}
+		if err != nil {
+			d.logger.Warn("failed to kill leftover compute process", "uuid", id, "pid", pid, "error", err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/shoenig/test/must"
+)
+
+func TestHandleLeftoverProcesses(t *testing.T) {
+	for _, testCase := range []struct {
+		Name              string
+		Action            string
+		ProcessesError    error
+		ExpectedError     error
+		ExpectedUnhealthy []string
+	}{
+		{
+			Name:   "log",
+			Action: leftoverProcessesLog,
+		},
+		{
+			Name:              "unhealthy",
+			Action:            leftoverProcessesUnhealthy,
+			ExpectedError:     &reservationError{ReservationErrBusy, []string{"UUID2"}},
+			ExpectedUnhealthy: []string{"UUID2"},
+		},
+		{
+			Name:           "query failures are ignored",
+			Action:         leftoverProcessesUnhealthy,
+			ProcessesError: errors.New("nvml failure"),
+		},
+	} {
+		t.Run(testCase.Name, func(t *testing.T) {
+			d := &NvidiaDevice{
+				collector: &MockNvmlClient{
+					ProcessesError: testCase.ProcessesError,
+					ProcessesReturned: map[string][]int{
+						"UUID2": {1234, 5678},
+					},
+				},
+				leftoverProcesses: testCase.Action,
+				logger:            hclog.NewNullLogger(),
+			}
+
+			err := d.handleLeftoverProcesses([]string{"UUID1", "UUID2"})
+			must.Eq(t, testCase.ExpectedError, err)
+			must.Eq(t, testCase.ExpectedUnhealthy, d.unhealthyDevices(healthCauseLeftoverProcesses))
+		})
+	}
+}
+
+func TestRecheckLeftoverProcesses(t *testing.T) {
+	client := &MockNvmlClient{
+		ProcessesReturned: map[string][]int{
+			"UUID1": {1234},
+		},
+	}
+	d := &NvidiaDevice{
+		collector:         client,
+		leftoverProcesses: leftoverProcessesUnhealthy,
+		logger:            hclog.NewNullLogger(),
+	}
+
+	must.Error(t, d.handleLeftoverProcesses([]string{"UUID1"}))
+	must.Eq(t, "leftover compute processes: 1234", d.unhealthy["UUID1"].reason())
+
+	// the device stays unhealthy while the process runs
+	d.recheckLeftoverProcesses()
+	must.Eq(t, []string{"UUID1"}, d.unhealthyDevices(healthCauseLeftoverProcesses))
+
+	// and becomes healthy once it exited
+	client.ProcessesReturned = nil
+	d.recheckLeftoverProcesses()
+	must.SliceEmpty(t, d.unhealthyDevices(healthCauseLeftoverProcesses))
+}
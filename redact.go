@@ -0,0 +1,35 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"github.com/hashicorp/nomad/plugins/device"
+)
+
+// redactPCIBusID is the name redact_attributes accepts for the PCI bus IDs of
+// devices, which are reported in their locality rather than as an attribute
+const redactPCIBusID = "pci_bus_id"
+
+// redactAttributes removes the attributes configured in redact_attributes
+// from groups right before they are sent, after health checks and status
+// files used them
+func (d *NvidiaDevice) redactAttributes(groups []*device.DeviceGroup) {
+	if len(d.redactedAttributes) == 0 {
+		return
+	}
+
+	_, redactBusIDs := d.redactedAttributes[redactPCIBusID]
+	for _, group := range groups {
+		for attr := range d.redactedAttributes {
+			delete(group.Attributes, attr)
+		}
+		if !redactBusIDs {
+			continue
+		}
+		// the locality of devices only holds their PCI bus ID
+		for _, dev := range group.Devices {
+			dev.HwLocality = nil
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestRedactAttributes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := newReplayDevice(t)
+	must.NoError(t, setPluginConfig(t, d, `
+config {
+  redact_attributes = ["pci_bus_id", "index", "driver_version"]
+}
+`))
+	fingerprints, err := d.Fingerprint(ctx)
+	must.NoError(t, err)
+	fingerprint := <-fingerprints
+	must.NoError(t, fingerprint.Error)
+	must.Len(t, 1, fingerprint.Devices)
+
+	group := fingerprint.Devices[0]
+	must.MapNotContainsKey(t, group.Attributes, IndexAttr)
+	must.MapNotContainsKey(t, group.Attributes, DriverVersionAttr)
+	must.MapContainsKey(t, group.Attributes, DriverBranchAttr)
+	for _, dev := range group.Devices {
+		must.Nil(t, dev.HwLocality)
+	}
+
+	// the plugin still knows the PCI bus IDs of the devices
+	d.deviceLock.RLock()
+	must.MapLen(t, len(group.Devices), d.pciBusIDs)
+	d.deviceLock.RUnlock()
+
+	err = setPluginConfig(t, newReplayDevice(t), `
+config {
+  redact_attributes = [""]
+}
+`)
+	must.ErrorContains(t, err, `invalid redacted attribute ""`)
+}
@@ -0,0 +1,86 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/shoenig/test/must"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the replay tests")
+
+// replayResult is the output of the plugin for a recording, compared against
+// its golden file
+type replayResult struct {
+	Fingerprint *device.FingerprintResponse
+	Stats       []*device.StatsResponse
+}
+
+// TestReplay replays the NVML responses recorded on nodes with various GPU
+// models in testdata/recordings, recorded with "nomad-device-nvidia record",
+// and compares the fingerprint and stats of every status sample with the
+// golden files in testdata/golden. Run with -update to regenerate the golden
+// files after intended changes.
+func TestReplay(t *testing.T) {
+	setupProcRoot(t, nil)
+
+	paths, err := filepath.Glob("testdata/recordings/*.json")
+	must.NoError(t, err)
+	must.SliceNotEmpty(t, paths)
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		t.Run(name, func(t *testing.T) {
+			recording, err := nvml.ReadRecording(path)
+			must.NoError(t, err)
+
+			at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+			d := NewNvidiaDevice(context.Background(), hclog.NewNullLogger(),
+				WithNvmlClient(nvml.NewReplayClient(recording)),
+				WithClock(ClockFunc(func() time.Time { return at })),
+			)
+			d.enabled = true
+			d.fingerprintPeriod = time.Minute
+
+			var result replayResult
+			fingerprints := make(chan *device.FingerprintResponse, 1)
+			d.writeFingerprintToChannel(fingerprints, "")
+			result.Fingerprint = <-fingerprints
+
+			samples := 1
+			for _, statuses := range recording.DeviceStatus {
+				samples = max(samples, len(statuses))
+			}
+			for range samples {
+				stats := make(chan *device.StatsResponse, 1)
+				d.writeStatsToChannel(stats, d.now(), 0)
+				result.Stats = append(result.Stats, <-stats)
+			}
+
+			actual, err := json.MarshalIndent(result, "", "  ")
+			must.NoError(t, err)
+			actual = append(actual, '\n')
+
+			golden := filepath.Join("testdata", "golden", name+".json")
+			if *update {
+				must.NoError(t, os.MkdirAll(filepath.Dir(golden), 0o755))
+				must.NoError(t, os.WriteFile(golden, actual, 0o644))
+			}
+			expected, err := os.ReadFile(golden)
+			must.NoError(t, err)
+			must.Eq(t, string(expected), string(actual))
+		})
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/hashicorp/nomad/plugins/device"
+)
+
+// Reservation modes select what reservations hand to the task driver
+const (
+	// reservationModeEnv only sets NVIDIA_VISIBLE_DEVICES, which the Nvidia
+	// container runtime hook turns into device nodes and driver mounts
+	reservationModeEnv = "env"
+
+	// reservationModeDevices returns the device nodes, and with CDI specs
+	// the driver mounts, of the reserved GPUs, for container stacks that can
+	// not run the Nvidia runtime hook such as rootless Podman
+	reservationModeDevices = "devices"
+
+	// deviceCgroupPerms are the cgroup permissions of reserved device nodes,
+	// without mknod which rootless containers can not use
+	deviceCgroupPerms = "rw"
+)
+
+// devRoot is the directory holding the device nodes of the host, swapped in
+// tests
+var devRoot = "/dev"
+
+// controlDeviceNodes are the device nodes every GPU process needs next to
+// the nodes of its GPUs, they are only reserved when present on the host
+var controlDeviceNodes = []string{"nvidiactl", "nvidia-uvm", "nvidia-uvm-tools"}
+
+// ReservationConfig configures what reservations hand to the task driver
+type ReservationConfig struct {
+	// Mode is either "env" (the default) or "devices"
+	Mode string `codec:"mode"`
+
+	// UID and GID are the host user and group the rootless container
+	// runtime runs as, device nodes it can not read and write are reported
+	// when reserved. Negative values disable the check.
+	UID int `codec:"uid"`
+	GID int `codec:"gid"`
+
+	// MinComputeCapability and MinCUDAVersion are the oldest compute
+	// capability of reserved devices and CUDA version of the driver the
+	// workloads of the node run with, reservations not meeting them fail
+	// instead of the CUDA runtime of the task. Empty values disable the
+	// checks.
+	MinComputeCapability string `codec:"min_compute_capability"`
+	MinCUDAVersion       string `codec:"min_cuda_version"`
+}
+
+// containerReservation returns the reservation of deviceIDs according to the
+// configured reservation mode
+func (d *NvidiaDevice) containerReservation(deviceIDs []string) (*device.ContainerReservation, error) {
+	if d.reservation.Mode == reservationModeDevices {
+		return d.deviceNodesReservation(deviceIDs)
+	}
+
+	visible, err := visibleDevices(deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+	return &device.ContainerReservation{
+		Envs: map[string]string{
+			Vendor.VisibleDevicesEnv: visible,
+		},
+	}, nil
+}
+
+// deviceNodesReservation returns the device nodes of deviceIDs instead of
+// NVIDIA_VISIBLE_DEVICES, so that the task does not depend on the Nvidia
+// runtime hook. With CDI specs the nodes and driver mounts of the specs are
+// used, otherwise the nodes of the GPUs are found in procfs and the task
+// image must provide driver libraries matching the host driver.
+func (d *NvidiaDevice) deviceNodesReservation(deviceIDs []string) (*device.ContainerReservation, error) {
+	reservation := &device.ContainerReservation{}
+	if d.cdiSpecDir != "" {
+		edits, err := readCDIContainerEdits(d.cdiSpecDir, deviceIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range edits.DeviceNodes {
+			hostPath := node.HostPath
+			if hostPath == "" {
+				hostPath = node.Path
+			}
+			reservation.Devices = appendDeviceSpec(reservation.Devices, node.Path, hostPath)
+		}
+		for _, mount := range edits.Mounts {
+			reservation.Mounts = append(reservation.Mounts, &device.Mount{
+				TaskPath: mount.ContainerPath,
+				HostPath: mount.HostPath,
+				ReadOnly: slices.Contains(mount.Options, "ro"),
+			})
+		}
+	} else {
+		nodes, err := d.gpuDeviceNodes(deviceIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range nodes {
+			reservation.Devices = appendDeviceSpec(reservation.Devices, "/dev/"+node, filepath.Join(devRoot, node))
+		}
+	}
+
+	d.checkDeviceAccess(reservation.Devices)
+	return reservation, nil
+}
+
+// gpuDeviceNodes returns the names of the device nodes of deviceIDs, along
+// with the control device nodes present on the host. MIG devices are
+// rejected, their access is granted by capability device nodes.
+func (d *NvidiaDevice) gpuDeviceNodes(deviceIDs []string) ([]string, error) {
+	d.deviceLock.RLock()
+	var migIDs []string
+	for _, id := range deviceIDs {
+		if _, ok := d.migParents[id]; ok {
+			migIDs = append(migIDs, id)
+		}
+	}
+	d.deviceLock.RUnlock()
+	if len(migIDs) != 0 {
+		return nil, fmt.Errorf("MIG devices %v can not be reserved in %q reservation mode", migIDs, reservationModeDevices)
+	}
+
+	var nodes []string
+	for _, id := range deviceIDs {
+		minor, err := d.gpuMinor(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find device node of %q: %v", id, err)
+		}
+		nodes = append(nodes, fmt.Sprintf("nvidia%d", minor))
+	}
+	for _, node := range controlDeviceNodes {
+		_, err := os.Stat(filepath.Join(devRoot, node))
+		switch {
+		case err == nil:
+			nodes = append(nodes, node)
+		case !errors.Is(err, os.ErrNotExist):
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// appendDeviceSpec appends the device node at hostPath to specs unless a
+// node with the same task path was already added
+func appendDeviceSpec(specs []*device.DeviceSpec, taskPath, hostPath string) []*device.DeviceSpec {
+	for _, spec := range specs {
+		if spec.TaskPath == taskPath {
+			return specs
+		}
+	}
+	return append(specs, &device.DeviceSpec{
+		TaskPath:    taskPath,
+		HostPath:    hostPath,
+		CgroupPerms: deviceCgroupPerms,
+	})
+}
+
+// checkDeviceAccess logs a warning for each reserved device node the
+// configured rootless user can not read and write. Rootless container
+// runtimes can not change the ownership of the device nodes they pass to
+// tasks, so such nodes are unusable in the task.
+func (d *NvidiaDevice) checkDeviceAccess(specs []*device.DeviceSpec) {
+	if d.reservation.UID < 0 && d.reservation.GID < 0 {
+		return
+	}
+
+	var denied []string
+	for _, spec := range specs {
+		info, err := os.Stat(spec.HostPath)
+		if err != nil {
+			d.logger.Warn("failed to check access to device node", "path", spec.HostPath, "error", err)
+			continue
+		}
+		if !deviceAccessible(info, d.reservation.UID, d.reservation.GID) {
+			denied = append(denied, spec.HostPath)
+		}
+	}
+	if len(denied) != 0 {
+		d.logger.Warn("rootless container user can not access reserved device nodes",
+			"uid", d.reservation.UID, "gid", d.reservation.GID, "devices", strings.Join(denied, ","))
+	}
+}
+
+// deviceAccessible reports whether the user with the given uid and gid can
+// read and write the device node described by info, according to its owner
+// and permission bits
+func deviceAccessible(info os.FileInfo, uid, gid int) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+
+	perm := info.Mode().Perm()
+	switch {
+	case uid >= 0 && int(stat.Uid) == uid:
+		return perm&0o600 == 0o600
+	case gid >= 0 && int(stat.Gid) == gid:
+		return perm&0o060 == 0o060
+	default:
+		return perm&0o006 == 0o006
+	}
+}
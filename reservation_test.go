@@ -0,0 +1,142 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/shoenig/test/must"
+)
+
+// setupDevRoot points devRoot to a temporary directory holding files named
+// after the given device nodes, with the given permissions
+func setupDevRoot(t *testing.T, nodes map[string]os.FileMode) {
+	oldRoot := devRoot
+	devRoot = t.TempDir()
+	t.Cleanup(func() { devRoot = oldRoot })
+
+	for node, perm := range nodes {
+		path := filepath.Join(devRoot, node)
+		must.NoError(t, os.WriteFile(path, nil, perm))
+		must.NoError(t, os.Chmod(path, perm))
+	}
+}
+
+func TestDeviceNodesReservation(t *testing.T) {
+	setupIsolation(t, map[string]int{"0000:3b:00.0": 0, "0000:af:00.0": 1}, "")
+	setupDevRoot(t, map[string]os.FileMode{"nvidia0": 0o666, "nvidia1": 0o666, "nvidiactl": 0o666, "nvidia-uvm": 0o666})
+
+	d := &NvidiaDevice{
+		logger:      hclog.NewNullLogger(),
+		reservation: ReservationConfig{Mode: reservationModeDevices, UID: -1, GID: -1},
+		pciBusIDs: map[string]string{
+			"UUID1": "00000000:3B:00.0",
+			"UUID2": "00000000:AF:00.0",
+		},
+		migParents: map[string]string{"MIG1": "UUID1"},
+	}
+
+	reservation, err := d.containerReservation([]string{"UUID2"})
+	must.NoError(t, err)
+	must.MapEmpty(t, reservation.Envs)
+	must.Eq(t, []*device.DeviceSpec{
+		{TaskPath: "/dev/nvidia1", HostPath: filepath.Join(devRoot, "nvidia1"), CgroupPerms: "rw"},
+		{TaskPath: "/dev/nvidiactl", HostPath: filepath.Join(devRoot, "nvidiactl"), CgroupPerms: "rw"},
+		{TaskPath: "/dev/nvidia-uvm", HostPath: filepath.Join(devRoot, "nvidia-uvm"), CgroupPerms: "rw"},
+	}, reservation.Devices)
+
+	_, err = d.containerReservation([]string{"MIG1"})
+	must.ErrorContains(t, err, "can not be reserved")
+
+	_, err = d.containerReservation([]string{"UUID3"})
+	must.ErrorContains(t, err, "failed to find device node")
+}
+
+func TestDeviceNodesReservationCDI(t *testing.T) {
+	dir := t.TempDir()
+	spec := `{
+  "kind": "nvidia.com/gpu",
+  "devices": [
+    {"name": "0", "containerEdits": {"deviceNodes": [{"path": "/dev/nvidia0"}]}},
+    {"name": "1", "containerEdits": {"deviceNodes": [{"path": "/dev/nvidia1", "hostPath": "/host/dev/nvidia1"}]}}
+  ],
+  "containerEdits": {
+    "deviceNodes": [{"path": "/dev/nvidiactl"}],
+    "mounts": [{"hostPath": "/usr/lib64/libcuda.so.550.54.15", "containerPath": "/usr/lib64/libcuda.so.550.54.15", "options": ["ro", "nosuid", "nodev", "bind"]}]
+  }
+}`
+	must.NoError(t, os.WriteFile(filepath.Join(dir, "nvidia.json"), []byte(spec), 0o644))
+
+	d := &NvidiaDevice{
+		logger:      hclog.NewNullLogger(),
+		reservation: ReservationConfig{Mode: reservationModeDevices, UID: -1, GID: -1},
+		cdiSpecDir:  dir,
+	}
+
+	reservation, err := d.containerReservation([]string{"nvidia.com/gpu=1"})
+	must.NoError(t, err)
+	must.Eq(t, []*device.DeviceSpec{
+		{TaskPath: "/dev/nvidia1", HostPath: "/host/dev/nvidia1", CgroupPerms: "rw"},
+		{TaskPath: "/dev/nvidiactl", HostPath: "/dev/nvidiactl", CgroupPerms: "rw"},
+	}, reservation.Devices)
+	must.Eq(t, []*device.Mount{
+		{TaskPath: "/usr/lib64/libcuda.so.550.54.15", HostPath: "/usr/lib64/libcuda.so.550.54.15", ReadOnly: true},
+	}, reservation.Mounts)
+
+	_, err = d.containerReservation([]string{"nvidia.com/gpu=2"})
+	must.ErrorContains(t, err, "not found")
+}
+
+func TestCheckDeviceAccess(t *testing.T) {
+	setupDevRoot(t, map[string]os.FileMode{"nvidia0": 0o600, "nvidiactl": 0o666})
+	specs := []*device.DeviceSpec{
+		{TaskPath: "/dev/nvidia0", HostPath: filepath.Join(devRoot, "nvidia0")},
+		{TaskPath: "/dev/nvidiactl", HostPath: filepath.Join(devRoot, "nvidiactl")},
+	}
+
+	cases := []struct {
+		Name     string
+		UID      int
+		GID      int
+		Expected string
+	}{
+		{
+			Name: "unchecked",
+			UID:  -1,
+			GID:  -1,
+		},
+		{
+			Name: "owner",
+			UID:  os.Getuid(),
+			GID:  -1,
+		},
+		{
+			Name:     "other user",
+			UID:      os.Getuid() + 1000,
+			GID:      os.Getgid() + 1000,
+			Expected: "nvidia0",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			var out bytes.Buffer
+			d := &NvidiaDevice{
+				logger:      hclog.New(&hclog.LoggerOptions{Output: &out}),
+				reservation: ReservationConfig{Mode: reservationModeDevices, UID: c.UID, GID: c.GID},
+			}
+			d.checkDeviceAccess(specs)
+			if c.Expected == "" {
+				must.Eq(t, "", out.String())
+				return
+			}
+			must.StrContains(t, out.String(), c.Expected)
+			must.StrNotContains(t, out.String(), "nvidiactl")
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+)
+
+// statsSnapshot is the content of the stats snapshot file, which holds the
+// stats of the latest collection so that node local agents can read them
+// without polling NVML themselves
+type statsSnapshot struct {
+	Timestamp time.Time             `json:"timestamp"`
+	Groups    []*statsSnapshotGroup `json:"groups"`
+}
+
+// statsSnapshotGroup holds the stats of the instances of a stats group, such
+// as the devices of a model or the node level aggregate stats
+type statsSnapshotGroup struct {
+	Name      string                   `json:"name"`
+	Instances []*statsSnapshotInstance `json:"instances"`
+}
+
+// statsSnapshotInstance holds the stats of a device, or of a node level
+// instance
+type statsSnapshotInstance struct {
+	ID      string                         `json:"id"`
+	Summary *statsSnapshotValue            `json:"summary,omitempty"`
+	Stats   map[string]*statsSnapshotValue `json:"stats"`
+}
+
+// statsSnapshotValue is a single stats value. Numeric values are reported in
+// value, along with the maximum they are relative to if any, other values
+// such as "N/A" in string.
+type statsSnapshotValue struct {
+	Value  *float64 `json:"value,omitempty"`
+	Max    *float64 `json:"max,omitempty"`
+	String *string  `json:"string,omitempty"`
+	Unit   string   `json:"unit,omitempty"`
+}
+
+// snapshotValue converts a stats value to its snapshot representation
+func snapshotValue(value *structs.StatValue) *statsSnapshotValue {
+	if value == nil {
+		return nil
+	}
+	snapshot := &statsSnapshotValue{Unit: value.Unit}
+	if numerator, denominator, ok := statNumbers(value); ok {
+		snapshot.Value = numerator
+		snapshot.Max = denominator
+	} else if value.StringVal != nil {
+		snapshot.String = value.StringVal
+	}
+	return snapshot
+}
+
+// statsSnapshotOf returns the snapshot of the stats groups collected at
+// timestamp, with groups and instances sorted by name
+func statsSnapshotOf(groups []*device.DeviceGroupStats, timestamp time.Time) *statsSnapshot {
+	snapshot := &statsSnapshot{
+		Timestamp: timestamp,
+		Groups:    make([]*statsSnapshotGroup, 0, len(groups)),
+	}
+	for _, group := range groups {
+		snapshotGroup := &statsSnapshotGroup{
+			Name:      group.Name,
+			Instances: make([]*statsSnapshotInstance, 0, len(group.InstanceStats)),
+		}
+		for id, deviceStats := range group.InstanceStats {
+			instance := &statsSnapshotInstance{
+				ID:      id,
+				Summary: snapshotValue(deviceStats.Summary),
+				Stats:   make(map[string]*statsSnapshotValue),
+			}
+			if deviceStats.Stats != nil {
+				for attr, value := range deviceStats.Stats.Attributes {
+					instance.Stats[attr] = snapshotValue(value)
+				}
+			}
+			snapshotGroup.Instances = append(snapshotGroup.Instances, instance)
+		}
+		sort.Slice(snapshotGroup.Instances, func(i, j int) bool {
+			return snapshotGroup.Instances[i].ID < snapshotGroup.Instances[j].ID
+		})
+		snapshot.Groups = append(snapshot.Groups, snapshotGroup)
+	}
+	sort.Slice(snapshot.Groups, func(i, j int) bool {
+		return snapshot.Groups[i].Name < snapshot.Groups[j].Name
+	})
+	return snapshot
+}
+
+// writeStatsSnapshot writes the stats groups collected at timestamp to the
+// stats snapshot file, if configured. The file is replaced atomically so that
+// readers never see a partial write and need no locking.
+func (d *NvidiaDevice) writeStatsSnapshot(groups []*device.DeviceGroupStats, timestamp time.Time) {
+	if d.statsSnapshotFile == "" {
+		return
+	}
+
+	if err := writeFileAtomic(d.statsSnapshotFile, statsSnapshotOf(groups, timestamp)); err != nil {
+		d.errorLog.Error(d.logger, "failed to write stats snapshot file", err)
+	}
+}
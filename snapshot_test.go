@@ -0,0 +1,71 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/shoenig/test/must"
+)
+
+func TestWriteStatsSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	d := &NvidiaDevice{
+		logger:            hclog.NewNullLogger(),
+		devices:           map[string]struct{}{"UUID1": {}},
+		statsSnapshotFile: path,
+		collector: &MockNvmlClient{
+			StatsResponseReturned: []*nvml.StatsData{
+				{
+					DeviceData: &nvml.DeviceData{
+						UUID:       "UUID1",
+						DeviceName: pointer.Of("Tesla T4"),
+						MemoryMiB:  pointer.Of(uint64(15360)),
+					},
+					GPUUtilization: pointer.Of(uint(42)),
+					UsedMemoryMiB:  pointer.Of(uint64(1024)),
+				},
+			},
+		},
+	}
+
+	timestamp := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	channel := make(chan *device.StatsResponse, 1)
+	d.writeStatsToChannel(channel, timestamp, 0)
+	<-channel
+
+	content, err := os.ReadFile(path)
+	must.NoError(t, err)
+
+	var snapshot statsSnapshot
+	must.NoError(t, json.Unmarshal(content, &snapshot))
+	must.True(t, timestamp.Equal(snapshot.Timestamp))
+	must.Len(t, 1, snapshot.Groups)
+	must.Eq(t, "Tesla T4", snapshot.Groups[0].Name)
+	must.Len(t, 1, snapshot.Groups[0].Instances)
+
+	instance := snapshot.Groups[0].Instances[0]
+	must.Eq(t, "UUID1", instance.ID)
+	must.Eq(t, &statsSnapshotValue{
+		Value: pointer.Of(float64(1024)),
+		Max:   pointer.Of(float64(15360)),
+		Unit:  MemoryStateUnit,
+	}, instance.Summary)
+	must.Eq(t, &statsSnapshotValue{
+		Value: pointer.Of(float64(42)),
+		Unit:  GPUUtilizationUnit,
+	}, instance.Stats[GPUUtilizationAttr])
+	must.Eq(t, &statsSnapshotValue{
+		String: pointer.Of(notAvailable),
+		Unit:   TemperatureUnit,
+	}, instance.Stats[TemperatureAttr])
+}
@@ -0,0 +1,118 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+)
+
+// SRIOVVFsAttr is the attribute reporting the number of SR-IOV virtual
+// functions enabled on the physical GPUs of a group
+const SRIOVVFsAttr = "sriov_vfs"
+
+// sriovCheckPeriod is the interval at which the SR-IOV virtual functions of
+// the physical GPUs are checked between fingerprints, so that creating or
+// destroying them on vGPU hosts is reported without waiting for the next one
+const sriovCheckPeriod = 5 * time.Second
+
+// readSRIOVNumVFs reads the number of SR-IOV virtual functions enabled on the
+// PCI device with the given bus ID. The file does not exist for devices that
+// are not SR-IOV physical functions.
+func readSRIOVNumVFs(busID string) (uint, error) {
+	address, err := sysfsPCIAddress(busID)
+	if err != nil {
+		return 0, err
+	}
+	path := filepath.Join(sysfsRoot, "bus", "pci", "devices", address, "sriov_numvfs")
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	vfs, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid virtual function count in %q: %v", path, err)
+	}
+	return uint(vfs), nil
+}
+
+// readSRIOVVFs returns the number of SR-IOV virtual functions enabled on the
+// given PCI devices, keyed by bus ID. Devices that are not SR-IOV physical
+// functions are left out.
+func (d *NvidiaDevice) readSRIOVVFs(busIDs []string) map[string]uint {
+	vfs := make(map[string]uint)
+	for _, busID := range busIDs {
+		count, err := readSRIOVNumVFs(busID)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			d.errorLog.Error(d.logger, "failed to read device SR-IOV virtual functions", err, "pci_bus_id", busID)
+			continue
+		}
+		vfs[busID] = count
+	}
+	return vfs
+}
+
+// setSRIOVVFs records the SR-IOV virtual functions enabled on the physical
+// GPUs in deviceList at fingerprint time, which are watched for changes
+func (d *NvidiaDevice) setSRIOVVFs(deviceList []*nvml.FingerprintDeviceData) {
+	busIDs := make([]string, 0, len(deviceList))
+	for _, dev := range deviceList {
+		if dev.ParentUUID == "" && dev.PCIBusID != "" {
+			busIDs = append(busIDs, dev.PCIBusID)
+		}
+	}
+	d.sriovVFs = d.readSRIOVVFs(busIDs)
+}
+
+// sriovVFsChanged reports whether the SR-IOV virtual functions enabled on the
+// physical GPUs changed since the last fingerprint
+func (d *NvidiaDevice) sriovVFsChanged() bool {
+	d.fingerprintLock.Lock()
+	defer d.fingerprintLock.Unlock()
+
+	if len(d.sriovVFs) == 0 {
+		return false
+	}
+	vfs := d.readSRIOVVFs(slices.Collect(maps.Keys(d.sriovVFs)))
+	if maps.Equal(vfs, d.sriovVFs) {
+		return false
+	}
+	d.logger.Info("SR-IOV virtual functions changed, refreshing fingerprint")
+	return true
+}
+
+// sriovAttributes returns the SR-IOV attributes of a group of devices, nil
+// when none of them is an SR-IOV physical function
+func (d *NvidiaDevice) sriovAttributes(deviceList []*nvml.FingerprintDeviceData) map[string]*structs.Attribute {
+	var total uint
+	var found bool
+	for _, dev := range deviceList {
+		if dev.ParentUUID != "" {
+			continue
+		}
+		if vfs, ok := d.sriovVFs[dev.PCIBusID]; ok {
+			total += vfs
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return map[string]*structs.Attribute{
+		SRIOVVFsAttr: {Int: pointer.Of(int64(total))},
+	}
+}
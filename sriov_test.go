@@ -0,0 +1,74 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+	"github.com/shoenig/test/must"
+)
+
+func TestReadSRIOVNumVFs(t *testing.T) {
+	setupSysfsRoot(t, map[string]map[string]string{
+		"0000:3b:00.0": {"sriov_numvfs": "16\n"},
+		"0000:af:00.0": {"sriov_numvfs": "many\n"},
+	})
+
+	vfs, err := readSRIOVNumVFs("00000000:3B:00.0")
+	must.NoError(t, err)
+	must.Eq(t, 16, vfs)
+
+	_, err = readSRIOVNumVFs("00000000:AF:00.0")
+	must.ErrorContains(t, err, "invalid virtual function count")
+
+	// devices that are not SR-IOV physical functions have no count
+	_, err = readSRIOVNumVFs("00000000:D8:00.0")
+	must.True(t, os.IsNotExist(err))
+}
+
+func TestSRIOVVFs(t *testing.T) {
+	setupSysfsRoot(t, map[string]map[string]string{
+		"0000:3b:00.0": {"sriov_numvfs": "16\n"},
+		"0000:af:00.0": {"sriov_numvfs": "0\n"},
+	})
+
+	gpu := func(uuid, busID, parentUUID string) *nvml.FingerprintDeviceData {
+		return &nvml.FingerprintDeviceData{
+			DeviceData: &nvml.DeviceData{UUID: uuid},
+			PCIBusID:   busID,
+			ParentUUID: parentUUID,
+		}
+	}
+	d := &NvidiaDevice{logger: hclog.NewNullLogger()}
+	devices := []*nvml.FingerprintDeviceData{
+		gpu("UUID1", "00000000:3B:00.0", ""),
+		gpu("UUID2", "00000000:AF:00.0", ""),
+		gpu("UUID3", "00000000:D8:00.0", ""),
+	}
+	d.setSRIOVVFs(devices)
+	must.Eq(t, map[string]uint{"00000000:3B:00.0": 16, "00000000:AF:00.0": 0}, d.sriovVFs)
+	must.False(t, d.sriovVFsChanged())
+
+	must.Eq(t, map[string]*structs.Attribute{
+		SRIOVVFsAttr: {Int: pointer.Of(int64(16))},
+	}, d.sriovAttributes(devices))
+	must.Nil(t, d.sriovAttributes(devices[2:]))
+	must.Nil(t, d.sriovAttributes([]*nvml.FingerprintDeviceData{gpu("MIG-1", "00000000:3B:00.0", "UUID1")}))
+
+	// creating virtual functions refreshes the fingerprint
+	path := filepath.Join(sysfsRoot, "bus", "pci", "devices", "0000:af:00.0", "sriov_numvfs")
+	must.NoError(t, os.WriteFile(path, []byte("4\n"), 0o644))
+	must.True(t, d.sriovVFsChanged())
+
+	// nodes without SR-IOV physical functions are not checked
+	d.setSRIOVVFs(devices[2:])
+	must.MapEmpty(t, d.sriovVFs)
+	must.False(t, d.sriovVFsChanged())
+}
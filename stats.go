@@ -5,6 +5,11 @@ package nvidia
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/nomad-device-nvidia/nvml"
@@ -13,63 +18,565 @@ import (
 	"github.com/hashicorp/nomad/plugins/shared/structs"
 )
 
+const (
+	// Units of stats values that have no structs.Unit* equivalent
+	UnitCelsius    = "C" // Celsius degrees
+	UnitFahrenheit = "F" // Fahrenheit degrees
+	UnitPercent    = "%"
+	UnitCount      = "#" // number of occurrences
+	UnitMillis     = "ms"
+	UnitSeconds    = "s"
+	UnitWattHour   = "Wh"
+	UnitMiBPerS    = "MiB/s"
+)
+
 const (
 	// Attribute names for reporting stats output
 	PowerUsageAttr = "Power usage"
-	PowerUsageUnit = "W"
+	PowerUsageUnit = structs.UnitW
 	PowerUsageDesc = "Power usage for this GPU in watts and " +
 		"its associated circuitry (e.g. memory) / Maximum GPU Power"
+	PowerUsageMilliwattsUnit = structs.UnitmW
+	PowerUsageMilliwattsDesc = "Power usage for this GPU in milliwatts and " +
+		"its associated circuitry (e.g. memory) / Maximum GPU Power"
 	GPUUtilizationAttr = "GPU utilization"
-	GPUUtilizationUnit = "%"
+	GPUUtilizationUnit = UnitPercent
 	GPUUtilizationDesc = "Percent of time over the past sample period " +
 		"during which one or more kernels were executing on the GPU."
-	MemoryUtilizationAttr  = "Memory utilization"
-	MemoryUtilizationUnit  = "%"
-	MemoryUtilizationDesc  = "Percentage of bandwidth used during the past sample period"
+	MemoryUtilizationAttr = "Memory utilization"
+	MemoryUtilizationUnit = UnitPercent
+	MemoryUtilizationDesc = "Percentage of bandwidth used during the past sample period"
+
+	// Memory bandwidth utilization duplicates memory utilization under a name
+	// that can not be mistaken for memory occupancy, which is reported as
+	// memory used
+	MemoryBandwidthUtilizationAttr = "Memory bandwidth utilization"
+	MemoryBandwidthUtilizationDesc = "Percent of time over the past sample period " +
+		"during which device memory was being read or written"
+	MemoryUsedAttr = "Memory used"
+	MemoryUsedDesc = "Percentage of the device memory in use, UsedMemory / TotalMemory"
+
 	EncoderUtilizationAttr = "Encoder utilization"
-	EncoderUtilizationUnit = "%"
+	EncoderUtilizationUnit = UnitPercent
 	EncoderUtilizationDesc = "Percent of time over the past sample period " +
 		"during which GPU Encoder was used"
 	DecoderUtilizationAttr = "Decoder utilization"
-	DecoderUtilizationUnit = "%"
+	DecoderUtilizationUnit = UnitPercent
 	DecoderUtilizationDesc = "Percent of time over the past sample period " +
 		"during which GPU Decoder was used"
+	JPEGUtilizationAttr  = "JPEG utilization"
+	JPEGUtilizationDesc  = "Percent of time over the past sample period during which the GPU JPEG decoders were used"
+	OFAUtilizationAttr   = "OFA utilization"
+	OFAUtilizationDesc   = "Percent of time over the past sample period during which the GPU optical flow accelerator was used"
 	TemperatureAttr      = "Temperature"
-	TemperatureUnit      = "C" // Celsius degrees
+	TemperatureUnit      = UnitCelsius
 	TemperatureDesc      = "Temperature of the Unit"
 	MemoryStateAttr      = "Memory state"
-	MemoryStateUnit      = "MiB" // Mebibytes
+	MemoryStateUnit      = structs.UnitMiB
 	MemoryStateDesc      = "UsedMemory / TotalMemory"
 	BAR1StateAttr        = "BAR1 buffer state"
-	BAR1StateUnit        = "MiB" // Mebibytes
+	BAR1StateUnit        = structs.UnitMiB
 	BAR1StateDesc        = "UsedBAR1 / TotalBAR1"
+	BAR1UsedAttr         = "BAR1 used"
+	BAR1UsedDesc         = "Percentage of the BAR1 buffer in use, UsedBAR1 / TotalBAR1"
 	ECCErrorsL1CacheAttr = "ECC L1 errors"
-	ECCErrorsL1CacheUnit = "#" // number of errors
+	ECCErrorsL1CacheUnit = UnitCount
 	ECCErrorsL1CacheDesc = "Requested L1Cache error counter for the device"
 	ECCErrorsL2CacheAttr = "ECC L2 errors"
-	ECCErrorsL2CacheUnit = "#" // number of errors
+	ECCErrorsL2CacheUnit = UnitCount
 	ECCErrorsL2CacheDesc = "Requested L2Cache error counter for the device"
 	ECCErrorsDeviceAttr  = "ECC memory errors"
-	ECCErrorsDeviceUnit  = "#" // number of errors
+	ECCErrorsDeviceUnit  = UnitCount
 	ECCErrorsDeviceDesc  = "Requested memory error counter for the device"
+
+	// Aggregate ECC error counters persist across driver reloads and reboots
+	ECCErrorsL1CacheAggregateAttr = "ECC L1 aggregate errors"
+	ECCErrorsL1CacheAggregateDesc = "Lifetime L1Cache error counter for the device"
+	ECCErrorsL2CacheAggregateAttr = "ECC L2 aggregate errors"
+	ECCErrorsL2CacheAggregateDesc = "Lifetime L2Cache error counter for the device"
+	ECCErrorsDeviceAggregateAttr  = "ECC memory aggregate errors"
+	ECCErrorsDeviceAggregateDesc  = "Lifetime memory error counter for the device"
+
+	// Utilization sampled by NVML over the stats interval, which is less
+	// noisy than the instantaneous GPU utilization
+	GPUUtilizationAverageAttr = "GPU utilization average"
+	GPUUtilizationAverageDesc = "Average of the GPU utilization samples taken since the previous stats collection"
+	GPUUtilizationMaxAttr     = "GPU utilization max"
+	GPUUtilizationMaxDesc     = "Maximum of the GPU utilization samples taken since the previous stats collection"
+
+	// Power usage averaged over the stats interval from the energy counter,
+	// which does not miss the spikes between instantaneous readings
+	AveragePowerUsageAttr = "Power usage average"
+	AveragePowerUsageDesc = "Average power usage for this GPU in watts since " +
+		"the previous stats collection / Maximum GPU Power"
+	AveragePowerUsageMilliwattsDesc = "Average power usage for this GPU in milliwatts " +
+		"since the previous stats collection / Maximum GPU Power"
+	EnergyConsumedAttr = "Energy consumed"
+	EnergyConsumedDesc = "Energy consumed by this GPU since the driver was last loaded"
+
+	// Fan speeds of actively cooled GPUs, a fan running well below its target
+	// speed has likely failed
+	FanSpeedAttr       = "Fan speed"
+	FanSpeedDesc       = "Speed of the slowest fan of this GPU as a percentage of its maximum speed"
+	FanTargetSpeedAttr = "Fan target speed"
+	FanTargetSpeedDesc = "Highest speed the fans of this GPU are driven to, as a percentage of their maximum speed"
+
+	// Distribution of the values sampled between stats collections with the
+	// sample_interval option, which catches the spikes that long collection
+	// intervals miss
+	GPUUtilizationP50Attr  = "GPU utilization p50"
+	GPUUtilizationP50Desc  = "Median GPU utilization sampled since the previous stats collection"
+	GPUUtilizationP95Attr  = "GPU utilization p95"
+	GPUUtilizationP95Desc  = "95th percentile of the GPU utilization sampled since the previous stats collection"
+	GPUUtilizationPeakAttr = "GPU utilization peak"
+	GPUUtilizationPeakDesc = "Highest GPU utilization sampled since the previous stats collection"
+	PowerUsageP50Attr      = "Power usage p50"
+	PowerUsageP50Desc      = "Median power usage sampled since the previous stats collection"
+	PowerUsageP95Attr      = "Power usage p95"
+	PowerUsageP95Desc      = "95th percentile of the power usage sampled since the previous stats collection"
+	PowerUsagePeakAttr     = "Power usage peak"
+	PowerUsagePeakDesc     = "Highest power usage sampled since the previous stats collection"
+	TemperatureP50Attr     = "Temperature p50"
+	TemperatureP50Desc     = "Median temperature sampled since the previous stats collection"
+	TemperatureP95Attr     = "Temperature p95"
+	TemperatureP95Desc     = "95th percentile of the temperature sampled since the previous stats collection"
+	TemperaturePeakAttr    = "Temperature peak"
+	TemperaturePeakDesc    = "Highest temperature sampled since the previous stats collection"
+
+	// NVLink throughput computed from the NVLink data counters, emitted for
+	// devices with NVLinks only
+	NVLinkTxThroughputAttr = "NVLink TX throughput"
+	NVLinkTxThroughputDesc = "Data sent over all NVLinks of this GPU per second since the previous stats collection"
+	NVLinkRxThroughputAttr = "NVLink RX throughput"
+	NVLinkRxThroughputDesc = "Data received over all NVLinks of this GPU per second since the previous stats collection"
+
+	// GPU Performance Monitoring metrics of Hopper and later GPUs, emitted
+	// with the gpm_metrics option
+	SMActivityAttr               = "SM activity"
+	SMActivityDesc               = "Percent of time the streaming multiprocessors were busy since the previous stats collection"
+	TensorActivityAttr           = "Tensor activity"
+	TensorActivityDesc           = "Percent of time the tensor cores were busy since the previous stats collection"
+	DRAMBandwidthUtilizationAttr = "DRAM bandwidth utilization"
+	DRAMBandwidthUtilizationDesc = "Percent of the DRAM bandwidth used since the previous stats collection"
+	NVLinkBandwidthAttr          = "NVLink bandwidth utilization"
+	NVLinkBandwidthDesc          = "Percent of the bandwidth of all NVLinks of this GPU used in both directions since the previous stats collection"
+
+	// Group, instance and descriptions of node level aggregate stats
+	AggregateStatsGroupName    = "aggregate"
+	AggregateStatsInstanceName = "node"
+	AggregatePowerUsageDesc    = "Total power usage of all GPUs in watts / " +
+		"Total maximum power of all GPUs"
+	AggregatePowerUsageMilliwattsDesc = "Total power usage of all GPUs in milliwatts / " +
+		"Total maximum power of all GPUs"
+	AggregateGPUUtilizationDesc = "Average GPU utilization of all GPUs"
+	AggregateTemperatureDesc    = "Maximum temperature of all GPUs"
+	AggregateMemoryStateDesc    = "Total UsedMemory / Total TotalMemory of all GPUs"
+
+	// Group, instance and attributes of stats collection diagnostics
+	DiagnosticsStatsGroupName    = "diagnostics"
+	DiagnosticsStatsInstanceName = "node"
+	CollectionDurationAttr       = "Collection duration"
+	CollectionDurationDesc       = "Time taken to collect the stats of all GPUs"
+	TimestampSkewAttr            = "Timestamp skew"
+	TimestampSkewDesc            = "Delay of this stats collection past its scheduled time"
+	QueryDurationAttr            = "Query duration"
+	QueryDurationDesc            = "Time taken to query the stats of the GPU"
+	DroppedStatsAttr             = "Dropped stats"
+	DroppedStatsDesc             = "Stats responses dropped since the plugin started because the stats consumer was not keeping up"
+
+	// Reported in place of the stats of a device whose query failed, so that
+	// a single failing device does not fail the stats of the whole node
+	DeviceErrorAttr = "Error"
+	DeviceErrorDesc = "Reason the stats of this GPU could not be collected"
 )
 
+// errStatsChannelFull is logged when a stats response is dropped because the
+// stats channel buffer is full
+var errStatsChannelFull = errors.New("stats channel is full, the stats consumer is not keeping up")
+
+// statsOptions controls how stats values are reported
+type statsOptions struct {
+	// powerUnit is the unit of power usage values, either structs.UnitW
+	// (the default) or structs.UnitmW
+	powerUnit string
+
+	// enabledMetrics is the set of stats attribute names to emit, all
+	// attributes are emitted when nil
+	enabledMetrics map[string]struct{}
+
+	// transforms holds the conversions applied to stats values before they
+	// are emitted, keyed by stats attribute name
+	transforms map[string][]statTransform
+
+	// eccCounters selects the ECC error counters to emit, one of
+	// eccCountersVolatile (the default), eccCountersAggregate or
+	// eccCountersBoth
+	eccCounters string
+
+	// utilizationSampling indicates whether the average and maximum of the
+	// GPU utilization samples are emitted
+	utilizationSampling bool
+
+	// summary is the stats attribute reported as the summary of every
+	// device, memory state when empty
+	summary string
+
+	// metricKeys indicates whether the descriptions of stats values are
+	// replaced with their metric keys
+	metricKeys bool
+}
+
+// summaryAttr returns the stats attribute reported as the device summary
+func (o statsOptions) summaryAttr() string {
+	if o.summary == "" {
+		return MemoryStateAttr
+	}
+	return o.summary
+}
+
+const (
+	eccCountersVolatile  = "volatile"
+	eccCountersAggregate = "aggregate"
+	eccCountersBoth      = "both"
+)
+
+// volatileECC reports whether volatile ECC error counters are emitted
+func (o statsOptions) volatileECC() bool {
+	return o.eccCounters != eccCountersAggregate
+}
+
+// aggregateECC reports whether aggregate ECC error counters are emitted
+func (o statsOptions) aggregateECC() bool {
+	return o.eccCounters == eccCountersAggregate || o.eccCounters == eccCountersBoth
+}
+
+// statTransform converts a stats value before it is emitted
+type statTransform func(*structs.StatValue) *structs.StatValue
+
+// parseStatTransforms returns the stats value conversions configured in the
+// stats block, keyed by stats attribute name
+func parseStatTransforms(config StatsConfig) (map[string][]statTransform, error) {
+	transforms := make(map[string][]statTransform)
+
+	switch config.TemperatureUnit {
+	case "", UnitCelsius:
+	case UnitFahrenheit:
+		for _, attr := range []string{TemperatureAttr, TemperatureP50Attr, TemperatureP95Attr, TemperaturePeakAttr} {
+			transforms[attr] = append(transforms[attr], celsiusToFahrenheit)
+		}
+	default:
+		return nil, fmt.Errorf("invalid temperature unit %q, must be one of %q or %q",
+			config.TemperatureUnit, UnitCelsius, UnitFahrenheit)
+	}
+
+	for _, scale := range config.Scale {
+		attr, ok := statsMetrics[scale.Metric]
+		if !ok {
+			return nil, fmt.Errorf("unknown metric %q in scale", scale.Metric)
+		}
+		if scale.Factor == 0 {
+			return nil, fmt.Errorf("scale factor of metric %q must not be zero", scale.Metric)
+		}
+		transforms[attr] = append(transforms[attr], scaleStat(scale.Factor, scale.Unit))
+	}
+
+	if len(transforms) == 0 {
+		return nil, nil
+	}
+	return transforms, nil
+}
+
+// statNumbers returns the numeric numerator and denominator of value as
+// floats, ok is false for values that are not numeric, such as notAvailable
+func statNumbers(value *structs.StatValue) (numerator, denominator *float64, ok bool) {
+	switch {
+	case value.IntNumeratorVal != nil:
+		numerator = pointer.Of(float64(*value.IntNumeratorVal))
+	case value.FloatNumeratorVal != nil:
+		numerator = pointer.Of(*value.FloatNumeratorVal)
+	default:
+		return nil, nil, false
+	}
+
+	switch {
+	case value.IntDenominatorVal != nil:
+		denominator = pointer.Of(float64(*value.IntDenominatorVal))
+	case value.FloatDenominatorVal != nil:
+		denominator = pointer.Of(*value.FloatDenominatorVal)
+	}
+	return numerator, denominator, true
+}
+
+// celsiusToFahrenheit converts a temperature in Celsius degrees to Fahrenheit
+// degrees
+func celsiusToFahrenheit(value *structs.StatValue) *structs.StatValue {
+	if value.StringVal != nil {
+		return &structs.StatValue{Unit: UnitFahrenheit, Desc: value.Desc, StringVal: value.StringVal}
+	}
+
+	celsius, _, ok := statNumbers(value)
+	if !ok {
+		return value
+	}
+	return &structs.StatValue{
+		Unit:              UnitFahrenheit,
+		Desc:              value.Desc,
+		FloatNumeratorVal: pointer.Of(*celsius*9/5 + 32),
+	}
+}
+
+// scaleStat returns a statTransform multiplying values by factor and
+// replacing their unit with unit, if set
+func scaleStat(factor float64, unit string) statTransform {
+	return func(value *structs.StatValue) *structs.StatValue {
+		scaled := &structs.StatValue{
+			Unit:      value.Unit,
+			Desc:      value.Desc,
+			StringVal: value.StringVal,
+		}
+		if unit != "" {
+			scaled.Unit = unit
+		}
+
+		numerator, denominator, ok := statNumbers(value)
+		if !ok {
+			return scaled
+		}
+		scaled.FloatNumeratorVal = pointer.Of(*numerator * factor)
+		if denominator != nil {
+			scaled.FloatDenominatorVal = pointer.Of(*denominator * factor)
+		}
+		return scaled
+	}
+}
+
+// transformStats applies the configured transforms to the attributes and
+// summary of deviceStats
+func (o statsOptions) transformStats(deviceStats *device.DeviceStats) {
+	if len(o.transforms) == 0 {
+		return
+	}
+
+	for attr, transforms := range o.transforms {
+		value, ok := deviceStats.Stats.Attributes[attr]
+		if !ok {
+			continue
+		}
+		for _, transform := range transforms {
+			value = transform(value)
+		}
+		deviceStats.Stats.Attributes[attr] = value
+	}
+
+	if transforms, ok := o.transforms[o.summaryAttr()]; ok && deviceStats.Summary != nil {
+		for _, transform := range transforms {
+			deviceStats.Summary = transform(deviceStats.Summary)
+		}
+	}
+}
+
+// statsMetrics maps the metric names accepted by the enabled_metrics option
+// to the stats attribute names
+var statsMetrics = map[string]string{
+	"power_usage":          PowerUsageAttr,
+	"gpu_utilization":      GPUUtilizationAttr,
+	"memory_utilization":   MemoryUtilizationAttr,
+	"memory_bandwidth_pct": MemoryBandwidthUtilizationAttr,
+	"memory_used_pct":      MemoryUsedAttr,
+	"encoder_utilization":  EncoderUtilizationAttr,
+	"decoder_utilization":  DecoderUtilizationAttr,
+	"jpeg_utilization":     JPEGUtilizationAttr,
+	"ofa_utilization":      OFAUtilizationAttr,
+	"temperature":          TemperatureAttr,
+	"memory_state":         MemoryStateAttr,
+	"bar1_state":           BAR1StateAttr,
+	"bar1_used_pct":        BAR1UsedAttr,
+	"ecc_l1_errors":        ECCErrorsL1CacheAttr,
+	"ecc_l2_errors":        ECCErrorsL2CacheAttr,
+	"ecc_memory_errors":    ECCErrorsDeviceAttr,
+
+	"ecc_l1_aggregate_errors":     ECCErrorsL1CacheAggregateAttr,
+	"ecc_l2_aggregate_errors":     ECCErrorsL2CacheAggregateAttr,
+	"ecc_memory_aggregate_errors": ECCErrorsDeviceAggregateAttr,
+
+	"gpu_utilization_avg": GPUUtilizationAverageAttr,
+	"gpu_utilization_max": GPUUtilizationMaxAttr,
+
+	"power_usage_avg": AveragePowerUsageAttr,
+	"energy_consumed": EnergyConsumedAttr,
+
+	"fan_speed":        FanSpeedAttr,
+	"fan_target_speed": FanTargetSpeedAttr,
+
+	"nvlink_tx_throughput": NVLinkTxThroughputAttr,
+	"nvlink_rx_throughput": NVLinkRxThroughputAttr,
+
+	"sm_activity":          SMActivityAttr,
+	"tensor_activity":      TensorActivityAttr,
+	"dram_bandwidth_pct":   DRAMBandwidthUtilizationAttr,
+	"nvlink_bandwidth_pct": NVLinkBandwidthAttr,
+
+	"gpu_utilization_p50":  GPUUtilizationP50Attr,
+	"gpu_utilization_p95":  GPUUtilizationP95Attr,
+	"gpu_utilization_peak": GPUUtilizationPeakAttr,
+	"power_usage_p50":      PowerUsageP50Attr,
+	"power_usage_p95":      PowerUsageP95Attr,
+	"power_usage_peak":     PowerUsagePeakAttr,
+	"temperature_p50":      TemperatureP50Attr,
+	"temperature_p95":      TemperatureP95Attr,
+	"temperature_peak":     TemperaturePeakAttr,
+
+	"foreign_process_count": ForeignProcessCountAttr,
+	"reservation_energy":    ReservationEnergyAttr,
+	"idle_reserved_seconds": IdleReservedAttr,
+
+	"pcie_correctable_errors":   PCIeCorrectableErrorsAttr,
+	"pcie_uncorrectable_errors": PCIeUncorrectableErrorsAttr,
+}
+
+// diagnosticMetrics maps the metric keys of the diagnostics stats and device
+// errors, which can not be disabled, to the stats attribute names
+var diagnosticMetrics = map[string]string{
+	"collection_duration": CollectionDurationAttr,
+	"timestamp_skew":      TimestampSkewAttr,
+	"query_duration":      QueryDurationAttr,
+	"dropped_stats":       DroppedStatsAttr,
+	"error":               DeviceErrorAttr,
+}
+
+// metricKeys maps stats attribute names to the metric keys that replace
+// their descriptions with the metric_keys option
+var metricKeys = func() map[string]string {
+	keys := make(map[string]string, len(statsMetrics)+len(diagnosticMetrics))
+	for _, metrics := range []map[string]string{statsMetrics, diagnosticMetrics} {
+		for key, attr := range metrics {
+			keys[attr] = key
+		}
+	}
+	return keys
+}()
+
+// setMetricKeys replaces the descriptions of the stats values of groups with
+// their metric keys, which unlike descriptions do not change between
+// releases. Summaries take the key of the attribute they report, which is
+// summaryAttr for device stats.
+func setMetricKeys(groups []*device.DeviceGroupStats, summaryAttr string) {
+	for _, group := range groups {
+		for instance, deviceStats := range group.InstanceStats {
+			if deviceStats.Stats == nil {
+				continue
+			}
+			for attr, value := range deviceStats.Stats.Attributes {
+				if key, ok := metricKeys[attr]; ok {
+					value.Desc = key
+				}
+			}
+			if summary := deviceStats.Summary; summary != nil {
+				if key, ok := metricKeys[instanceSummaryAttr(group.Name, instance, deviceStats, summaryAttr)]; ok {
+					summary.Desc = key
+				}
+			}
+		}
+	}
+}
+
+// instanceSummaryAttr returns the stats attribute reported as the summary of
+// the instance of the group with the given name and stats. Devices whose
+// stats could not be collected are summarized by their error, and the node
+// level groups have summaries of their own.
+func instanceSummaryAttr(groupName, instance string, deviceStats *device.DeviceStats, summaryAttr string) string {
+	if _, ok := deviceStats.Stats.Attributes[DeviceErrorAttr]; ok {
+		return DeviceErrorAttr
+	}
+	switch groupName {
+	case DiagnosticsStatsGroupName:
+		if instance == DiagnosticsStatsInstanceName {
+			return CollectionDurationAttr
+		}
+		return QueryDurationAttr
+	case AggregateStatsGroupName:
+		// metrics that are not aggregated are summarized by the memory state
+		if _, ok := deviceStats.Stats.Attributes[summaryAttr]; !ok {
+			return MemoryStateAttr
+		}
+	}
+	return summaryAttr
+}
+
+// summaryMetrics are the metrics accepted by the summary_metric option,
+// which are emitted for every device
+var summaryMetrics = []string{
+	"power_usage",
+	"gpu_utilization",
+	"memory_utilization",
+	"memory_bandwidth_pct",
+	"memory_used_pct",
+	"encoder_utilization",
+	"decoder_utilization",
+	"temperature",
+	"memory_state",
+	"bar1_state",
+	"bar1_used_pct",
+}
+
+// parseSummaryMetric converts the summary metric name to the stats attribute
+// reported as the summary of every device
+func parseSummaryMetric(metric string) (string, error) {
+	if metric == "" {
+		return MemoryStateAttr, nil
+	}
+	if !slices.Contains(summaryMetrics, metric) {
+		return "", fmt.Errorf("invalid summary metric %q, must be one of %s", metric, strings.Join(summaryMetrics, ", "))
+	}
+	return statsMetrics[metric], nil
+}
+
+// parseEnabledMetrics converts metric names to the set of stats attribute
+// names to emit, returning nil when all metrics are enabled
+func parseEnabledMetrics(metrics []string) (map[string]struct{}, error) {
+	if len(metrics) == 0 {
+		return nil, nil
+	}
+
+	enabled := make(map[string]struct{}, len(metrics))
+	for _, metric := range metrics {
+		attr, ok := statsMetrics[metric]
+		if !ok {
+			return nil, fmt.Errorf("unknown metric %q in enabled_metrics", metric)
+		}
+		enabled[attr] = struct{}{}
+	}
+	return enabled, nil
+}
+
 // stats is the long running goroutine that streams device statistics
 func (d *NvidiaDevice) stats(ctx context.Context, stats chan<- *device.StatsResponse, interval time.Duration) {
 	defer close(stats)
 
 	if d.initErr != nil {
-		if d.initErr.Error() != nvml.UnavailableLib.Error() {
+		if !nvml.IsPermanent(d.initErr) {
 			d.logger.Error("exiting stats due to problems with NVML loading", "error", d.initErr)
-			stats <- device.NewStatsError(d.initErr)
+			d.sendStats(stats, device.NewStatsError(d.initErr))
 		}
 
 		return
 	}
 
+	// Stats are only known for fingerprinted devices, so wait for the first
+	// fingerprint rather than emitting empty stats
+	if !d.waitForFirstFingerprint(ctx) {
+		return
+	}
+
+	if d.statsSampleInterval > 0 {
+		go d.sampleStats(ctx)
+	}
+
 	// Create a timer that will fire immediately for the first detection
 	ticker := time.NewTimer(0)
+	scheduled := d.now()
 
+	warmingUp := true
 	for {
 		select {
 		case <-ctx.Done():
@@ -77,9 +584,62 @@ func (d *NvidiaDevice) stats(ctx context.Context, stats chan<- *device.StatsResp
 		case <-ticker.C:
 			ticker.Reset(interval)
 		}
+		now := d.now()
+		skew := max(now.Sub(scheduled), 0)
+		scheduled = now.Add(interval)
+
+		if warmingUp {
+			select {
+			case <-d.firstFingerprint():
+				warmingUp = false
+			default:
+				d.logger.Debug("first fingerprint has not completed yet, no device stats are available")
+			}
+		}
+
+		d.errorLog.flush()
+		d.writeStatsToChannel(stats, now, skew)
+	}
+}
+
+// sampleStats samples the GPU utilization, power usage and temperature of
+// devices at the configured sample interval until ctx is done, so that stats
+// collections report their percentiles since the previous collection
+func (d *NvidiaDevice) sampleStats(ctx context.Context) {
+	ticker := time.NewTicker(d.statsSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := d.collector.SampleStats(); err != nil {
+			d.errorLog.Error(d.logger, "failed to sample nvidia stats", err)
+		}
+	}
+}
+
+// waitForFirstFingerprint blocks until the first fingerprint completed, the
+// stats warmup timeout elapsed or ctx is done, in which case false is returned
+func (d *NvidiaDevice) waitForFirstFingerprint(ctx context.Context) bool {
+	if d.statsWarmupTimeout <= 0 {
+		return true
+	}
 
-		d.writeStatsToChannel(stats, time.Now())
+	timer := time.NewTimer(d.statsWarmupTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-d.firstFingerprint():
+	case <-timer.C:
+		d.logger.Warn("first fingerprint did not complete before the stats warmup timeout, stats will be empty until it does",
+			"timeout", d.statsWarmupTimeout)
 	}
+	return true
 }
 
 // filterStatsByID accepts list of StatsData and set of IDs
@@ -94,16 +654,51 @@ func filterStatsByID(stats []*nvml.StatsData, ids map[string]struct{}) []*nvml.S
 	return filteredStats
 }
 
+// checkMissingStats logs the eligible devices missing from statsData, except
+// the ones without stats by design and the unhealthy ones, whose health cause
+// already explains why they have no stats
+func (d *NvidiaDevice) checkMissingStats(statsData []*nvml.StatsData) {
+	// CDI specs describe no device usage, so no device has stats
+	if d.cdiSpecDir != "" {
+		return
+	}
+
+	reported := make(map[string]struct{}, len(statsData))
+	for _, statsItem := range statsData {
+		reported[statsItem.UUID] = struct{}{}
+	}
+
+	d.deviceLock.RLock()
+	var missing []string
+	for uuid := range d.devices {
+		if _, ok := reported[uuid]; ok {
+			continue
+		}
+		if _, unhealthy := d.unhealthy[uuid]; unhealthy || d.noStatsByDesign(uuid) {
+			continue
+		}
+		missing = append(missing, uuid)
+	}
+	d.deviceLock.RUnlock()
+
+	for _, uuid := range missing {
+		d.errorLog.Error(d.logger, "device missing from nvidia stats", fmt.Errorf("no stats reported for device %s", uuid), "uuid", uuid)
+	}
+}
+
 // writeStatsToChannel collects StatsData from NVML backend, groups StatsData
 // by DeviceName attribute, populates DeviceGroupStats structure for every group
-// and sends data over provided channel
-func (d *NvidiaDevice) writeStatsToChannel(stats chan<- *device.StatsResponse, timestamp time.Time) {
-	statsData, err := d.nvmlClient.GetStatsData()
+// and sends data over provided channel. skew is how late the collection
+// started past its scheduled time.
+func (d *NvidiaDevice) writeStatsToChannel(stats chan<- *device.StatsResponse, timestamp time.Time, skew time.Duration) {
+	start := d.now()
+	statsData, err := d.collector.GetStatsData()
+	collectionDuration := d.now().Sub(start)
 	if err != nil {
-		d.logger.Error("failed to get nvidia stats", "error", err)
-		stats <- &device.StatsResponse{
+		d.errorLog.Error(d.logger, "failed to get nvidia stats", err)
+		d.sendStats(stats, &device.StatsResponse{
 			Error: err,
-		}
+		})
 		return
 	}
 
@@ -111,29 +706,77 @@ func (d *NvidiaDevice) writeStatsToChannel(stats chan<- *device.StatsResponse, t
 	d.deviceLock.RLock()
 	statsData = filterStatsByID(statsData, d.devices)
 	d.deviceLock.RUnlock()
+	d.checkMissingStats(statsData)
+	for _, statsItem := range statsData {
+		if statsItem.Error != "" {
+			d.errorLog.Error(d.logger, "failed to get nvidia device stats", errors.New(statsItem.Error), "uuid", statsItem.UUID)
+		}
+	}
+	d.checkBAR1Usage(statsData)
 
 	// group stats by DeviceName struct field
 	statsListByDeviceName := make(map[string][]*nvml.StatsData)
 	for _, statsItem := range statsData {
-		deviceName := statsItem.DeviceName
-		if deviceName == nil {
-			// nvml driver was not able to detect device name. This kind
-			// of devices are placed to single group with 'notAvailable' name
-			notAvailableCopy := notAvailable
-			deviceName = &notAvailableCopy
-		}
-
-		statsListByDeviceName[*deviceName] = append(statsListByDeviceName[*deviceName], statsItem)
+		groupName := d.groupName(statsItem.DeviceName)
+		statsListByDeviceName[groupName] = append(statsListByDeviceName[groupName], statsItem)
 	}
 
 	// place data device.DeviceGroupStats struct for every group of stats
 	deviceGroupsStats := make([]*device.DeviceGroupStats, 0, len(statsListByDeviceName))
 	for groupName, groupStats := range statsListByDeviceName {
-		deviceGroupsStats = append(deviceGroupsStats, statsForGroup(groupName, groupStats, timestamp))
+		deviceGroupsStats = append(deviceGroupsStats, statsForGroup(groupName, groupStats, timestamp, d.statsOptions))
+	}
+	if d.foreignProcessStats {
+		d.addForeignProcessStats(deviceGroupsStats)
+	}
+	if d.energyAccounting {
+		d.addReservationEnergyStats(deviceGroupsStats, statsData)
+	}
+	if d.pcieErrorStats {
+		d.addPCIeErrorStats(deviceGroupsStats)
+	}
+	if d.idleReservedWindow != 0 {
+		d.addIdleReservedStats(deviceGroupsStats, statsData)
+	}
+	if d.aggregateStats && len(statsData) != 0 {
+		deviceGroupsStats = append(deviceGroupsStats, aggregateStatsGroup(statsData, timestamp, d.statsOptions))
+	}
+	if d.diagnosticStats {
+		deviceGroupsStats = append(deviceGroupsStats, diagnosticsStatsGroup(statsData, collectionDuration, skew, d.statsDropped.Load(), timestamp))
 	}
+	if d.statsOptions.metricKeys {
+		setMetricKeys(deviceGroupsStats, d.statsOptions.summaryAttr())
+	}
+	// stats are matched with device groups by vendor, type and name
+	vendor := d.vendor()
+	for _, group := range deviceGroupsStats {
+		group.Vendor = vendor.Name
+		group.Type = vendor.DeviceType
+	}
+	// sort groups so that responses are deterministic
+	sort.Slice(deviceGroupsStats, func(i, j int) bool {
+		return deviceGroupsStats[i].Name < deviceGroupsStats[j].Name
+	})
 
-	stats <- &device.StatsResponse{
+	d.writeStatsSnapshot(deviceGroupsStats, timestamp)
+	if err := d.audit.record(deviceGroupsStats, timestamp); err != nil {
+		d.errorLog.Error(d.logger, "failed to write audit record", err)
+	}
+
+	d.sendStats(stats, &device.StatsResponse{
 		Groups: deviceGroupsStats,
+	})
+}
+
+// sendStats writes response to the stats channel without blocking, so that
+// a stalled consumer does not wedge stats collection. Responses that do not
+// fit in the channel buffer are dropped and counted.
+func (d *NvidiaDevice) sendStats(stats chan<- *device.StatsResponse, response *device.StatsResponse) {
+	select {
+	case stats <- response:
+	default:
+		dropped := d.statsDropped.Add(1)
+		d.errorLog.Error(d.logger, "dropped stats response", errStatsChannelFull, "dropped", dropped)
 	}
 }
 
@@ -143,23 +786,206 @@ func newNotAvailableDeviceStats(unit, desc string) *structs.StatValue {
 
 // statsForGroup is a helper function that populates device.DeviceGroupStats
 // for given groupName with groupStats list
-func statsForGroup(groupName string, groupStats []*nvml.StatsData, timestamp time.Time) *device.DeviceGroupStats {
+func statsForGroup(groupName string, groupStats []*nvml.StatsData, timestamp time.Time, options statsOptions) *device.DeviceGroupStats {
 	instanceStats := make(map[string]*device.DeviceStats)
 	for _, statsItem := range groupStats {
-		instanceStats[statsItem.UUID] = statsForItem(statsItem, timestamp)
+		if statsItem.Error != "" {
+			instanceStats[statsItem.UUID] = errorStatsForItem(statsItem, timestamp)
+			continue
+		}
+		instanceStats[statsItem.UUID] = statsForItem(statsItem, timestamp, options)
 	}
 
 	return &device.DeviceGroupStats{
-		Vendor:        vendor,
-		Type:          deviceType,
+		Vendor:        Vendor.Name,
+		Type:          Vendor.DeviceType,
 		Name:          groupName,
 		InstanceStats: instanceStats,
 	}
 }
 
+// errorStatsForItem populates device.DeviceStats reporting why the stats of
+// the device in statsItem could not be collected
+func errorStatsForItem(statsItem *nvml.StatsData, timestamp time.Time) *device.DeviceStats {
+	errorStat := &structs.StatValue{Desc: DeviceErrorDesc, StringVal: pointer.Of(statsItem.Error)}
+	return &device.DeviceStats{
+		Summary: errorStat,
+		Stats: &structs.StatObject{
+			Attributes: map[string]*structs.StatValue{DeviceErrorAttr: errorStat},
+		},
+		Timestamp: timestamp,
+	}
+}
+
+// aggregateStatsGroup is a helper function that populates a single
+// device.DeviceGroupStats summarizing the stats of all devices on the node:
+// total memory usage, average utilization, maximum temperature and total
+// power draw. Devices missing a value are left out of that value aggregate
+func aggregateStatsGroup(statsData []*nvml.StatsData, timestamp time.Time, options statsOptions) *device.DeviceGroupStats {
+	var (
+		usedMemoryMiB, memoryMiB uint64
+		memoryCount              int
+		powerUsage, power        uint
+		powerCount               int
+		utilizationTotal         uint
+		utilizationCount         int
+		temperatureMax           uint
+		temperatureCount         int
+	)
+
+	for _, statsItem := range statsData {
+		if statsItem.UsedMemoryMiB != nil && statsItem.MemoryMiB != nil {
+			usedMemoryMiB += *statsItem.UsedMemoryMiB
+			memoryMiB += *statsItem.MemoryMiB
+			memoryCount++
+		}
+		if options.powerUnit == structs.UnitmW {
+			if statsItem.PowerUsageMW != nil && statsItem.PowerMW != nil {
+				powerUsage += *statsItem.PowerUsageMW
+				power += *statsItem.PowerMW
+				powerCount++
+			}
+		} else if statsItem.PowerUsageW != nil && statsItem.PowerW != nil {
+			powerUsage += *statsItem.PowerUsageW
+			power += *statsItem.PowerW
+			powerCount++
+		}
+		if statsItem.GPUUtilization != nil {
+			utilizationTotal += *statsItem.GPUUtilization
+			utilizationCount++
+		}
+		if statsItem.TemperatureC != nil {
+			temperatureMax = max(temperatureMax, *statsItem.TemperatureC)
+			temperatureCount++
+		}
+	}
+
+	memoryStateStat := newNotAvailableDeviceStats(MemoryStateUnit, AggregateMemoryStateDesc)
+	if memoryCount != 0 {
+		memoryStateStat = &structs.StatValue{
+			Unit:              MemoryStateUnit,
+			Desc:              AggregateMemoryStateDesc,
+			IntNumeratorVal:   pointer.Of(int64(usedMemoryMiB)),
+			IntDenominatorVal: pointer.Of(int64(memoryMiB)),
+		}
+	}
+
+	powerUnit, powerDesc := PowerUsageUnit, AggregatePowerUsageDesc
+	if options.powerUnit == structs.UnitmW {
+		powerUnit, powerDesc = PowerUsageMilliwattsUnit, AggregatePowerUsageMilliwattsDesc
+	}
+	powerUsageStat := newNotAvailableDeviceStats(powerUnit, powerDesc)
+	if powerCount != 0 {
+		powerUsageStat = &structs.StatValue{
+			Unit:              powerUnit,
+			Desc:              powerDesc,
+			IntNumeratorVal:   pointer.Of(int64(powerUsage)),
+			IntDenominatorVal: pointer.Of(int64(power)),
+		}
+	}
+
+	GPUUtilizationStat := newNotAvailableDeviceStats(GPUUtilizationUnit, AggregateGPUUtilizationDesc)
+	if utilizationCount != 0 {
+		GPUUtilizationStat = &structs.StatValue{
+			Unit:            GPUUtilizationUnit,
+			Desc:            AggregateGPUUtilizationDesc,
+			IntNumeratorVal: pointer.Of(int64(utilizationTotal / uint(utilizationCount))),
+		}
+	}
+
+	temperatureStat := newNotAvailableDeviceStats(TemperatureUnit, AggregateTemperatureDesc)
+	if temperatureCount != 0 {
+		temperatureStat = &structs.StatValue{
+			Unit:            TemperatureUnit,
+			Desc:            AggregateTemperatureDesc,
+			IntNumeratorVal: pointer.Of(int64(temperatureMax)),
+		}
+	}
+
+	attributes := map[string]*structs.StatValue{
+		PowerUsageAttr:     powerUsageStat,
+		GPUUtilizationAttr: GPUUtilizationStat,
+		TemperatureAttr:    temperatureStat,
+		MemoryStateAttr:    memoryStateStat,
+	}
+	// metrics that are not aggregated are summarized by the memory state
+	summary, ok := attributes[options.summaryAttr()]
+	if !ok {
+		summary = memoryStateStat
+	}
+
+	deviceStats := &device.DeviceStats{
+		Summary: summary,
+		Stats: &structs.StatObject{
+			Attributes: attributes,
+		},
+		Timestamp: timestamp,
+	}
+	options.transformStats(deviceStats)
+
+	return &device.DeviceGroupStats{
+		Vendor: Vendor.Name,
+		Type:   Vendor.DeviceType,
+		Name:   AggregateStatsGroupName,
+		InstanceStats: map[string]*device.DeviceStats{
+			AggregateStatsInstanceName: deviceStats,
+		},
+	}
+}
+
+// diagnosticsStatsGroup is a helper function that populates a single
+// device.DeviceGroupStats reporting how long the stats collection and the
+// query of every device took, so that slow NVML calls can be spotted
+func diagnosticsStatsGroup(statsData []*nvml.StatsData, collectionDuration, skew time.Duration, dropped uint64, timestamp time.Time) *device.DeviceGroupStats {
+	instanceStats := map[string]*device.DeviceStats{
+		DiagnosticsStatsInstanceName: {
+			Summary: durationStat(collectionDuration, CollectionDurationDesc),
+			Stats: &structs.StatObject{
+				Attributes: map[string]*structs.StatValue{
+					CollectionDurationAttr: durationStat(collectionDuration, CollectionDurationDesc),
+					TimestampSkewAttr:      durationStat(skew, TimestampSkewDesc),
+					DroppedStatsAttr: {
+						Unit:            UnitCount,
+						Desc:            DroppedStatsDesc,
+						IntNumeratorVal: pointer.Of(int64(dropped)),
+					},
+				},
+			},
+			Timestamp: timestamp,
+		},
+	}
+	for _, statsItem := range statsData {
+		instanceStats[statsItem.UUID] = &device.DeviceStats{
+			Summary: durationStat(statsItem.QueryDuration, QueryDurationDesc),
+			Stats: &structs.StatObject{
+				Attributes: map[string]*structs.StatValue{
+					QueryDurationAttr: durationStat(statsItem.QueryDuration, QueryDurationDesc),
+				},
+			},
+			Timestamp: timestamp,
+		}
+	}
+
+	return &device.DeviceGroupStats{
+		Vendor:        Vendor.Name,
+		Type:          Vendor.DeviceType,
+		Name:          DiagnosticsStatsGroupName,
+		InstanceStats: instanceStats,
+	}
+}
+
+// durationStat reports a duration in milliseconds
+func durationStat(duration time.Duration, desc string) *structs.StatValue {
+	return &structs.StatValue{
+		Unit:              UnitMillis,
+		Desc:              desc,
+		FloatNumeratorVal: pointer.Of(float64(duration) / float64(time.Millisecond)),
+	}
+}
+
 // statsForItem is a helper function that populates device.DeviceStats for given
 // nvml.StatsData
-func statsForItem(statsItem *nvml.StatsData, timestamp time.Time) *device.DeviceStats {
+func statsForItem(statsItem *nvml.StatsData, timestamp time.Time, options statsOptions) *device.DeviceStats {
 	// nvml.StatsData holds pointers to values that can be nil
 	// In case they are nil return stats with 'notAvailable' constant
 	var (
@@ -176,7 +1002,18 @@ func statsForItem(statsItem *nvml.StatsData, timestamp time.Time) *device.Device
 		ECCErrorsDeviceStat    *structs.StatValue
 	)
 
-	if statsItem.PowerUsageW == nil || statsItem.PowerW == nil {
+	if options.powerUnit == structs.UnitmW {
+		if statsItem.PowerUsageMW == nil || statsItem.PowerMW == nil {
+			powerUsageStat = newNotAvailableDeviceStats(PowerUsageMilliwattsUnit, PowerUsageMilliwattsDesc)
+		} else {
+			powerUsageStat = &structs.StatValue{
+				Unit:              PowerUsageMilliwattsUnit,
+				Desc:              PowerUsageMilliwattsDesc,
+				IntNumeratorVal:   uintToInt64Ptr(statsItem.PowerUsageMW),
+				IntDenominatorVal: uintToInt64Ptr(statsItem.PowerMW),
+			}
+		}
+	} else if statsItem.PowerUsageW == nil || statsItem.PowerW == nil {
 		powerUsageStat = newNotAvailableDeviceStats(PowerUsageUnit, PowerUsageDesc)
 	} else {
 		powerUsageStat = &structs.StatValue{
@@ -288,25 +1125,222 @@ func statsForItem(statsItem *nvml.StatsData, timestamp time.Time) *device.Device
 			IntNumeratorVal: uint64ToInt64Ptr(statsItem.ECCErrorsDevice),
 		}
 	}
-	return &device.DeviceStats{
-		Summary: memoryStateStat,
+	attributes := map[string]*structs.StatValue{
+		PowerUsageAttr:                 powerUsageStat,
+		GPUUtilizationAttr:             GPUUtilizationStat,
+		MemoryUtilizationAttr:          memoryUtilizationStat,
+		MemoryBandwidthUtilizationAttr: utilizationStat(statsItem.MemoryUtilization, MemoryBandwidthUtilizationDesc),
+		MemoryUsedAttr:                 percentUsedStat(statsItem.UsedMemoryMiB, statsItem.MemoryMiB, MemoryUsedDesc),
+		EncoderUtilizationAttr:         encoderUtilizationStat,
+		DecoderUtilizationAttr:         decoderUtilizationStat,
+		TemperatureAttr:                temperatureStat,
+		MemoryStateAttr:                memoryStateStat,
+		BAR1StateAttr:                  BAR1StateStat,
+		BAR1UsedAttr:                   percentUsedStat(statsItem.BAR1UsedMiB, statsItem.BAR1MiB, BAR1UsedDesc),
+		ECCErrorsL1CacheAttr:           ECCErrorsL1CacheStat,
+		ECCErrorsL2CacheAttr:           ECCErrorsL2CacheStat,
+		ECCErrorsDeviceAttr:            ECCErrorsDeviceStat,
+	}
+	if !options.volatileECC() {
+		delete(attributes, ECCErrorsL1CacheAttr)
+		delete(attributes, ECCErrorsL2CacheAttr)
+		delete(attributes, ECCErrorsDeviceAttr)
+	}
+	if options.aggregateECC() {
+		attributes[ECCErrorsL1CacheAggregateAttr] = countStat(statsItem.ECCErrorsL1CacheAggregate, ECCErrorsL1CacheAggregateDesc)
+		attributes[ECCErrorsL2CacheAggregateAttr] = countStat(statsItem.ECCErrorsL2CacheAggregate, ECCErrorsL2CacheAggregateDesc)
+		attributes[ECCErrorsDeviceAggregateAttr] = countStat(statsItem.ECCErrorsDeviceAggregate, ECCErrorsDeviceAggregateDesc)
+	}
+	if options.utilizationSampling {
+		attributes[GPUUtilizationAverageAttr] = utilizationStat(statsItem.GPUUtilizationAverage, GPUUtilizationAverageDesc)
+		attributes[GPUUtilizationMaxAttr] = utilizationStat(statsItem.GPUUtilizationMax, GPUUtilizationMaxDesc)
+	}
+	// devices older than Ampere have neither JPEG decoders nor optical flow
+	// accelerator
+	if statsItem.JPEGUtilization != nil {
+		attributes[JPEGUtilizationAttr] = utilizationStat(statsItem.JPEGUtilization, JPEGUtilizationDesc)
+	}
+	if statsItem.OFAUtilization != nil {
+		attributes[OFAUtilizationAttr] = utilizationStat(statsItem.OFAUtilization, OFAUtilizationDesc)
+	}
+	// devices without energy counter, older than Volta, have no average
+	// power usage
+	if statsItem.EnergyMJ != nil {
+		attributes[AveragePowerUsageAttr] = averagePowerUsageStat(statsItem, options.powerUnit)
+		attributes[EnergyConsumedAttr] = &structs.StatValue{
+			Unit:              UnitWattHour,
+			Desc:              EnergyConsumedDesc,
+			FloatNumeratorVal: pointer.Of(wattHours(*statsItem.EnergyMJ)),
+		}
+	}
+	// passively cooled devices have no fans
+	if statsItem.FanSpeed != nil || statsItem.FanTargetSpeed != nil {
+		attributes[FanSpeedAttr] = utilizationStat(statsItem.FanSpeed, FanSpeedDesc)
+		attributes[FanTargetSpeedAttr] = utilizationStat(statsItem.FanTargetSpeed, FanTargetSpeedDesc)
+	}
+	// histograms are only reported when stats are sampled
+	addHistogramStats(attributes, statsItem.GPUUtilizationHistogram,
+		[3]string{GPUUtilizationP50Attr, GPUUtilizationP95Attr, GPUUtilizationPeakAttr},
+		[3]string{GPUUtilizationP50Desc, GPUUtilizationP95Desc, GPUUtilizationPeakDesc}, UnitPercent, 1)
+	powerUnit, powerDivisor := PowerUsageUnit, uint(1000)
+	if options.powerUnit == structs.UnitmW {
+		powerUnit, powerDivisor = PowerUsageMilliwattsUnit, 1
+	}
+	addHistogramStats(attributes, statsItem.PowerUsageMWHistogram,
+		[3]string{PowerUsageP50Attr, PowerUsageP95Attr, PowerUsagePeakAttr},
+		[3]string{PowerUsageP50Desc, PowerUsageP95Desc, PowerUsagePeakDesc}, powerUnit, powerDivisor)
+	addHistogramStats(attributes, statsItem.TemperatureCHistogram,
+		[3]string{TemperatureP50Attr, TemperatureP95Attr, TemperaturePeakAttr},
+		[3]string{TemperatureP50Desc, TemperatureP95Desc, TemperaturePeakDesc}, TemperatureUnit, 1)
+	// devices without NVLinks have no NVLink counters
+	if statsItem.NVLinkTxKiB != nil || statsItem.NVLinkRxKiB != nil {
+		attributes[NVLinkTxThroughputAttr] = throughputStat(statsItem.NVLinkTxKiBps, NVLinkTxThroughputDesc)
+		attributes[NVLinkRxThroughputAttr] = throughputStat(statsItem.NVLinkRxKiBps, NVLinkRxThroughputDesc)
+	}
+	// GPM metrics are only collected on Hopper and later GPUs
+	if statsItem.GPM != nil {
+		attributes[SMActivityAttr] = percentStat(statsItem.GPM.SMActivity, SMActivityDesc)
+		attributes[TensorActivityAttr] = percentStat(statsItem.GPM.TensorActivity, TensorActivityDesc)
+		attributes[DRAMBandwidthUtilizationAttr] = percentStat(statsItem.GPM.DRAMBandwidth, DRAMBandwidthUtilizationDesc)
+		if statsItem.GPM.NVLinkBandwidth != nil {
+			attributes[NVLinkBandwidthAttr] = percentStat(statsItem.GPM.NVLinkBandwidth, NVLinkBandwidthDesc)
+		}
+	}
+	// the summary is reported even when its metric is not enabled
+	summary := attributes[options.summaryAttr()]
+	if options.enabledMetrics != nil {
+		for attr := range attributes {
+			if _, ok := options.enabledMetrics[attr]; !ok {
+				delete(attributes, attr)
+			}
+		}
+	}
+
+	deviceStats := &device.DeviceStats{
+		Summary: summary,
 		Stats: &structs.StatObject{
-			Attributes: map[string]*structs.StatValue{
-				PowerUsageAttr:         powerUsageStat,
-				GPUUtilizationAttr:     GPUUtilizationStat,
-				MemoryUtilizationAttr:  memoryUtilizationStat,
-				EncoderUtilizationAttr: encoderUtilizationStat,
-				DecoderUtilizationAttr: decoderUtilizationStat,
-				TemperatureAttr:        temperatureStat,
-				MemoryStateAttr:        memoryStateStat,
-				BAR1StateAttr:          BAR1StateStat,
-				ECCErrorsL1CacheAttr:   ECCErrorsL1CacheStat,
-				ECCErrorsL2CacheAttr:   ECCErrorsL2CacheStat,
-				ECCErrorsDeviceAttr:    ECCErrorsDeviceStat,
-			},
+			Attributes: attributes,
 		},
 		Timestamp: timestamp,
 	}
+	options.transformStats(deviceStats)
+	return deviceStats
+}
+
+// averagePowerUsageStat returns the average power usage of the device in the
+// given unit, or a not available value on the first stats collection
+func averagePowerUsageStat(statsItem *nvml.StatsData, powerUnit string) *structs.StatValue {
+	if powerUnit == structs.UnitmW {
+		if statsItem.AveragePowerUsageMW == nil || statsItem.PowerMW == nil {
+			return newNotAvailableDeviceStats(PowerUsageMilliwattsUnit, AveragePowerUsageMilliwattsDesc)
+		}
+		return &structs.StatValue{
+			Unit:              PowerUsageMilliwattsUnit,
+			Desc:              AveragePowerUsageMilliwattsDesc,
+			IntNumeratorVal:   uintToInt64Ptr(statsItem.AveragePowerUsageMW),
+			IntDenominatorVal: uintToInt64Ptr(statsItem.PowerMW),
+		}
+	}
+	if statsItem.AveragePowerUsageMW == nil || statsItem.PowerW == nil {
+		return newNotAvailableDeviceStats(PowerUsageUnit, AveragePowerUsageDesc)
+	}
+	return &structs.StatValue{
+		Unit:              PowerUsageUnit,
+		Desc:              AveragePowerUsageDesc,
+		IntNumeratorVal:   pointer.Of(int64(*statsItem.AveragePowerUsageMW / 1000)),
+		IntDenominatorVal: uintToInt64Ptr(statsItem.PowerW),
+	}
+}
+
+// addHistogramStats adds the median, 95th percentile and peak of histogram,
+// divided by divisor, to attributes under the given names, if histogram is
+// not nil
+func addHistogramStats(attributes map[string]*structs.StatValue, histogram *nvml.Histogram, attrs, descs [3]string, unit string, divisor uint) {
+	if histogram == nil {
+		return
+	}
+	for i, value := range []uint{histogram.P50, histogram.P95, histogram.Max} {
+		attributes[attrs[i]] = &structs.StatValue{
+			Unit:            unit,
+			Desc:            descs[i],
+			IntNumeratorVal: pointer.Of(int64(value / divisor)),
+		}
+	}
+}
+
+// throughputStat returns a stats value of a throughput in MiB/s from a
+// throughput in KiB/s, or a not available value if it is nil
+func throughputStat(kibps *uint64, desc string) *structs.StatValue {
+	if kibps == nil {
+		return newNotAvailableDeviceStats(UnitMiBPerS, desc)
+	}
+	return &structs.StatValue{
+		Unit:              UnitMiBPerS,
+		Desc:              desc,
+		FloatNumeratorVal: pointer.Of(float64(*kibps) / 1024),
+	}
+}
+
+// countStat returns a stats value counting occurrences, or a not available
+// value if count is nil
+func countStat(count *uint64, desc string) *structs.StatValue {
+	if count == nil {
+		return newNotAvailableDeviceStats(UnitCount, desc)
+	}
+	return &structs.StatValue{
+		Unit:            UnitCount,
+		Desc:            desc,
+		IntNumeratorVal: uint64ToInt64Ptr(count),
+	}
+}
+
+// utilizationStat returns a stats value holding a utilization percentage, or
+// a not available value if utilization is nil
+func utilizationStat(utilization *uint, desc string) *structs.StatValue {
+	if utilization == nil {
+		return newNotAvailableDeviceStats(UnitPercent, desc)
+	}
+	return &structs.StatValue{
+		Unit:            UnitPercent,
+		Desc:            desc,
+		IntNumeratorVal: uintToInt64Ptr(utilization),
+	}
+}
+
+// percentStat returns a stats value holding a fractional percentage, or a not
+// available value if percent is nil
+func percentStat(percent *float64, desc string) *structs.StatValue {
+	if percent == nil {
+		return newNotAvailableDeviceStats(UnitPercent, desc)
+	}
+	return &structs.StatValue{
+		Unit:              UnitPercent,
+		Desc:              desc,
+		FloatNumeratorVal: pointer.Of(*percent),
+	}
+}
+
+// percentUsedStat returns a stats value holding the percentage of a memory
+// in use, or a not available value if it is unknown
+func percentUsedStat(usedMiB, totalMiB *uint64, desc string) *structs.StatValue {
+	percent, ok := percentUsed(usedMiB, totalMiB)
+	if !ok {
+		return newNotAvailableDeviceStats(UnitPercent, desc)
+	}
+	return &structs.StatValue{
+		Unit:            UnitPercent,
+		Desc:            desc,
+		IntNumeratorVal: pointer.Of(int64(percent)),
+	}
+}
+
+// percentUsed returns the rounded percentage of a memory in use, ok is false
+// if it is unknown
+func percentUsed(usedMiB, totalMiB *uint64) (uint64, bool) {
+	if usedMiB == nil || totalMiB == nil || *totalMiB == 0 {
+		return 0, false
+	}
+	return (*usedMiB*100 + *totalMiB/2) / *totalMiB, true
 }
 
 func uintToInt64Ptr(u *uint) *int64 {
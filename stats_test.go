@@ -4,8 +4,9 @@
 package nvidia
 
 import (
+	"bytes"
+	"context"
 	"errors"
-	"sort"
 	"testing"
 	"time"
 
@@ -473,6 +474,11 @@ func TestStatsForItem(t *testing.T) {
 							Desc:            MemoryUtilizationDesc,
 							IntNumeratorVal: pointer.Of(int64(1)),
 						},
+						MemoryBandwidthUtilizationAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryBandwidthUtilizationDesc,
+							IntNumeratorVal: pointer.Of(int64(1)),
+						},
 						EncoderUtilizationAttr: {
 							Unit:            EncoderUtilizationUnit,
 							Desc:            EncoderUtilizationDesc,
@@ -494,12 +500,22 @@ func TestStatsForItem(t *testing.T) {
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(1)),
 						},
+						MemoryUsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryUsedDesc,
+							IntNumeratorVal: pointer.Of(int64(100)),
+						},
 						BAR1StateAttr: {
 							Unit:              BAR1StateUnit,
 							Desc:              BAR1StateDesc,
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(256)),
 						},
+						BAR1UsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            BAR1UsedDesc,
+							IntNumeratorVal: pointer.Of(int64(0)),
+						},
 						ECCErrorsL1CacheAttr: {
 							Unit:            ECCErrorsL1CacheUnit,
 							Desc:            ECCErrorsL1CacheDesc,
@@ -567,6 +583,11 @@ func TestStatsForItem(t *testing.T) {
 							Desc:            MemoryUtilizationDesc,
 							IntNumeratorVal: pointer.Of(int64(1)),
 						},
+						MemoryBandwidthUtilizationAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryBandwidthUtilizationDesc,
+							IntNumeratorVal: pointer.Of(int64(1)),
+						},
 						EncoderUtilizationAttr: {
 							Unit:            EncoderUtilizationUnit,
 							Desc:            EncoderUtilizationDesc,
@@ -588,12 +609,22 @@ func TestStatsForItem(t *testing.T) {
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(1)),
 						},
+						MemoryUsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryUsedDesc,
+							IntNumeratorVal: pointer.Of(int64(100)),
+						},
 						BAR1StateAttr: {
 							Unit:              BAR1StateUnit,
 							Desc:              BAR1StateDesc,
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(256)),
 						},
+						BAR1UsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            BAR1UsedDesc,
+							IntNumeratorVal: pointer.Of(int64(0)),
+						},
 						ECCErrorsL1CacheAttr: {
 							Unit:            ECCErrorsL1CacheUnit,
 							Desc:            ECCErrorsL1CacheDesc,
@@ -661,6 +692,11 @@ func TestStatsForItem(t *testing.T) {
 							Desc:            MemoryUtilizationDesc,
 							IntNumeratorVal: pointer.Of(int64(1)),
 						},
+						MemoryBandwidthUtilizationAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryBandwidthUtilizationDesc,
+							IntNumeratorVal: pointer.Of(int64(1)),
+						},
 						EncoderUtilizationAttr: {
 							Unit:            EncoderUtilizationUnit,
 							Desc:            EncoderUtilizationDesc,
@@ -682,12 +718,22 @@ func TestStatsForItem(t *testing.T) {
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(1)),
 						},
+						MemoryUsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryUsedDesc,
+							IntNumeratorVal: pointer.Of(int64(100)),
+						},
 						BAR1StateAttr: {
 							Unit:              BAR1StateUnit,
 							Desc:              BAR1StateDesc,
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(256)),
 						},
+						BAR1UsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            BAR1UsedDesc,
+							IntNumeratorVal: pointer.Of(int64(0)),
+						},
 						ECCErrorsL1CacheAttr: {
 							Unit:            ECCErrorsL1CacheUnit,
 							Desc:            ECCErrorsL1CacheDesc,
@@ -756,6 +802,11 @@ func TestStatsForItem(t *testing.T) {
 							Desc:            MemoryUtilizationDesc,
 							IntNumeratorVal: pointer.Of(int64(1)),
 						},
+						MemoryBandwidthUtilizationAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryBandwidthUtilizationDesc,
+							IntNumeratorVal: pointer.Of(int64(1)),
+						},
 						EncoderUtilizationAttr: {
 							Unit:            EncoderUtilizationUnit,
 							Desc:            EncoderUtilizationDesc,
@@ -777,12 +828,22 @@ func TestStatsForItem(t *testing.T) {
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(1)),
 						},
+						MemoryUsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryUsedDesc,
+							IntNumeratorVal: pointer.Of(int64(100)),
+						},
 						BAR1StateAttr: {
 							Unit:              BAR1StateUnit,
 							Desc:              BAR1StateDesc,
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(256)),
 						},
+						BAR1UsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            BAR1UsedDesc,
+							IntNumeratorVal: pointer.Of(int64(0)),
+						},
 						ECCErrorsL1CacheAttr: {
 							Unit:            ECCErrorsL1CacheUnit,
 							Desc:            ECCErrorsL1CacheDesc,
@@ -851,6 +912,11 @@ func TestStatsForItem(t *testing.T) {
 							Desc:      MemoryUtilizationDesc,
 							StringVal: pointer.Of(notAvailable),
 						},
+						MemoryBandwidthUtilizationAttr: {
+							Unit:      UnitPercent,
+							Desc:      MemoryBandwidthUtilizationDesc,
+							StringVal: pointer.Of(notAvailable),
+						},
 						EncoderUtilizationAttr: {
 							Unit:            EncoderUtilizationUnit,
 							Desc:            EncoderUtilizationDesc,
@@ -872,12 +938,22 @@ func TestStatsForItem(t *testing.T) {
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(1)),
 						},
+						MemoryUsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryUsedDesc,
+							IntNumeratorVal: pointer.Of(int64(100)),
+						},
 						BAR1StateAttr: {
 							Unit:              BAR1StateUnit,
 							Desc:              BAR1StateDesc,
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(256)),
 						},
+						BAR1UsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            BAR1UsedDesc,
+							IntNumeratorVal: pointer.Of(int64(0)),
+						},
 						ECCErrorsL1CacheAttr: {
 							Unit:            ECCErrorsL1CacheUnit,
 							Desc:            ECCErrorsL1CacheDesc,
@@ -946,6 +1022,11 @@ func TestStatsForItem(t *testing.T) {
 							Desc:            MemoryUtilizationDesc,
 							IntNumeratorVal: pointer.Of(int64(1)),
 						},
+						MemoryBandwidthUtilizationAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryBandwidthUtilizationDesc,
+							IntNumeratorVal: pointer.Of(int64(1)),
+						},
 						EncoderUtilizationAttr: {
 							Unit:      EncoderUtilizationUnit,
 							Desc:      EncoderUtilizationDesc,
@@ -967,12 +1048,22 @@ func TestStatsForItem(t *testing.T) {
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(1)),
 						},
+						MemoryUsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryUsedDesc,
+							IntNumeratorVal: pointer.Of(int64(100)),
+						},
 						BAR1StateAttr: {
 							Unit:              BAR1StateUnit,
 							Desc:              BAR1StateDesc,
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(256)),
 						},
+						BAR1UsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            BAR1UsedDesc,
+							IntNumeratorVal: pointer.Of(int64(0)),
+						},
 						ECCErrorsL1CacheAttr: {
 							Unit:            ECCErrorsL1CacheUnit,
 							Desc:            ECCErrorsL1CacheDesc,
@@ -1041,6 +1132,11 @@ func TestStatsForItem(t *testing.T) {
 							Desc:            MemoryUtilizationDesc,
 							IntNumeratorVal: pointer.Of(int64(1)),
 						},
+						MemoryBandwidthUtilizationAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryBandwidthUtilizationDesc,
+							IntNumeratorVal: pointer.Of(int64(1)),
+						},
 						EncoderUtilizationAttr: {
 							Unit:            EncoderUtilizationUnit,
 							Desc:            EncoderUtilizationDesc,
@@ -1062,12 +1158,22 @@ func TestStatsForItem(t *testing.T) {
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(1)),
 						},
+						MemoryUsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryUsedDesc,
+							IntNumeratorVal: pointer.Of(int64(100)),
+						},
 						BAR1StateAttr: {
 							Unit:              BAR1StateUnit,
 							Desc:              BAR1StateDesc,
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(256)),
 						},
+						BAR1UsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            BAR1UsedDesc,
+							IntNumeratorVal: pointer.Of(int64(0)),
+						},
 						ECCErrorsL1CacheAttr: {
 							Unit:            ECCErrorsL1CacheUnit,
 							Desc:            ECCErrorsL1CacheDesc,
@@ -1136,6 +1242,11 @@ func TestStatsForItem(t *testing.T) {
 							Desc:            MemoryUtilizationDesc,
 							IntNumeratorVal: pointer.Of(int64(1)),
 						},
+						MemoryBandwidthUtilizationAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryBandwidthUtilizationDesc,
+							IntNumeratorVal: pointer.Of(int64(1)),
+						},
 						EncoderUtilizationAttr: {
 							Unit:            EncoderUtilizationUnit,
 							Desc:            EncoderUtilizationDesc,
@@ -1157,12 +1268,22 @@ func TestStatsForItem(t *testing.T) {
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(1)),
 						},
+						MemoryUsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryUsedDesc,
+							IntNumeratorVal: pointer.Of(int64(100)),
+						},
 						BAR1StateAttr: {
 							Unit:              BAR1StateUnit,
 							Desc:              BAR1StateDesc,
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(256)),
 						},
+						BAR1UsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            BAR1UsedDesc,
+							IntNumeratorVal: pointer.Of(int64(0)),
+						},
 						ECCErrorsL1CacheAttr: {
 							Unit:            ECCErrorsL1CacheUnit,
 							Desc:            ECCErrorsL1CacheDesc,
@@ -1230,6 +1351,11 @@ func TestStatsForItem(t *testing.T) {
 							Desc:            MemoryUtilizationDesc,
 							IntNumeratorVal: pointer.Of(int64(1)),
 						},
+						MemoryBandwidthUtilizationAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryBandwidthUtilizationDesc,
+							IntNumeratorVal: pointer.Of(int64(1)),
+						},
 						EncoderUtilizationAttr: {
 							Unit:            EncoderUtilizationUnit,
 							Desc:            EncoderUtilizationDesc,
@@ -1250,12 +1376,22 @@ func TestStatsForItem(t *testing.T) {
 							Desc:      MemoryStateDesc,
 							StringVal: pointer.Of(notAvailable),
 						},
+						MemoryUsedAttr: {
+							Unit:      UnitPercent,
+							Desc:      MemoryUsedDesc,
+							StringVal: pointer.Of(notAvailable),
+						},
 						BAR1StateAttr: {
 							Unit:              BAR1StateUnit,
 							Desc:              BAR1StateDesc,
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(256)),
 						},
+						BAR1UsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            BAR1UsedDesc,
+							IntNumeratorVal: pointer.Of(int64(0)),
+						},
 						ECCErrorsL1CacheAttr: {
 							Unit:            ECCErrorsL1CacheUnit,
 							Desc:            ECCErrorsL1CacheDesc,
@@ -1323,6 +1459,11 @@ func TestStatsForItem(t *testing.T) {
 							Desc:            MemoryUtilizationDesc,
 							IntNumeratorVal: pointer.Of(int64(1)),
 						},
+						MemoryBandwidthUtilizationAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryBandwidthUtilizationDesc,
+							IntNumeratorVal: pointer.Of(int64(1)),
+						},
 						EncoderUtilizationAttr: {
 							Unit:            EncoderUtilizationUnit,
 							Desc:            EncoderUtilizationDesc,
@@ -1343,12 +1484,22 @@ func TestStatsForItem(t *testing.T) {
 							Desc:      MemoryStateDesc,
 							StringVal: pointer.Of(notAvailable),
 						},
+						MemoryUsedAttr: {
+							Unit:      UnitPercent,
+							Desc:      MemoryUsedDesc,
+							StringVal: pointer.Of(notAvailable),
+						},
 						BAR1StateAttr: {
 							Unit:              BAR1StateUnit,
 							Desc:              BAR1StateDesc,
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(256)),
 						},
+						BAR1UsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            BAR1UsedDesc,
+							IntNumeratorVal: pointer.Of(int64(0)),
+						},
 						ECCErrorsL1CacheAttr: {
 							Unit:            ECCErrorsL1CacheUnit,
 							Desc:            ECCErrorsL1CacheDesc,
@@ -1417,6 +1568,11 @@ func TestStatsForItem(t *testing.T) {
 							Desc:            MemoryUtilizationDesc,
 							IntNumeratorVal: pointer.Of(int64(1)),
 						},
+						MemoryBandwidthUtilizationAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryBandwidthUtilizationDesc,
+							IntNumeratorVal: pointer.Of(int64(1)),
+						},
 						EncoderUtilizationAttr: {
 							Unit:            EncoderUtilizationUnit,
 							Desc:            EncoderUtilizationDesc,
@@ -1438,11 +1594,21 @@ func TestStatsForItem(t *testing.T) {
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(1)),
 						},
+						MemoryUsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryUsedDesc,
+							IntNumeratorVal: pointer.Of(int64(100)),
+						},
 						BAR1StateAttr: {
 							Unit:      BAR1StateUnit,
 							Desc:      BAR1StateDesc,
 							StringVal: pointer.Of(notAvailable),
 						},
+						BAR1UsedAttr: {
+							Unit:      UnitPercent,
+							Desc:      BAR1UsedDesc,
+							StringVal: pointer.Of(notAvailable),
+						},
 						ECCErrorsL1CacheAttr: {
 							Unit:            ECCErrorsL1CacheUnit,
 							Desc:            ECCErrorsL1CacheDesc,
@@ -1511,6 +1677,11 @@ func TestStatsForItem(t *testing.T) {
 							Desc:            MemoryUtilizationDesc,
 							IntNumeratorVal: pointer.Of(int64(1)),
 						},
+						MemoryBandwidthUtilizationAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryBandwidthUtilizationDesc,
+							IntNumeratorVal: pointer.Of(int64(1)),
+						},
 						EncoderUtilizationAttr: {
 							Unit:            EncoderUtilizationUnit,
 							Desc:            EncoderUtilizationDesc,
@@ -1532,11 +1703,21 @@ func TestStatsForItem(t *testing.T) {
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(1)),
 						},
+						MemoryUsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryUsedDesc,
+							IntNumeratorVal: pointer.Of(int64(100)),
+						},
 						BAR1StateAttr: {
 							Unit:      BAR1StateUnit,
 							Desc:      BAR1StateDesc,
 							StringVal: pointer.Of(notAvailable),
 						},
+						BAR1UsedAttr: {
+							Unit:      UnitPercent,
+							Desc:      BAR1UsedDesc,
+							StringVal: pointer.Of(notAvailable),
+						},
 						ECCErrorsL1CacheAttr: {
 							Unit:            ECCErrorsL1CacheUnit,
 							Desc:            ECCErrorsL1CacheDesc,
@@ -1605,6 +1786,11 @@ func TestStatsForItem(t *testing.T) {
 							Desc:            MemoryUtilizationDesc,
 							IntNumeratorVal: pointer.Of(int64(1)),
 						},
+						MemoryBandwidthUtilizationAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryBandwidthUtilizationDesc,
+							IntNumeratorVal: pointer.Of(int64(1)),
+						},
 						EncoderUtilizationAttr: {
 							Unit:            EncoderUtilizationUnit,
 							Desc:            EncoderUtilizationDesc,
@@ -1626,12 +1812,22 @@ func TestStatsForItem(t *testing.T) {
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(1)),
 						},
+						MemoryUsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryUsedDesc,
+							IntNumeratorVal: pointer.Of(int64(100)),
+						},
 						BAR1StateAttr: {
 							Unit:              BAR1StateUnit,
 							Desc:              BAR1StateDesc,
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(256)),
 						},
+						BAR1UsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            BAR1UsedDesc,
+							IntNumeratorVal: pointer.Of(int64(0)),
+						},
 						ECCErrorsL1CacheAttr: {
 							Unit:      ECCErrorsL1CacheUnit,
 							Desc:      ECCErrorsL1CacheDesc,
@@ -1700,6 +1896,11 @@ func TestStatsForItem(t *testing.T) {
 							Desc:            MemoryUtilizationDesc,
 							IntNumeratorVal: pointer.Of(int64(1)),
 						},
+						MemoryBandwidthUtilizationAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryBandwidthUtilizationDesc,
+							IntNumeratorVal: pointer.Of(int64(1)),
+						},
 						EncoderUtilizationAttr: {
 							Unit:            EncoderUtilizationUnit,
 							Desc:            EncoderUtilizationDesc,
@@ -1721,12 +1922,22 @@ func TestStatsForItem(t *testing.T) {
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(1)),
 						},
+						MemoryUsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryUsedDesc,
+							IntNumeratorVal: pointer.Of(int64(100)),
+						},
 						BAR1StateAttr: {
 							Unit:              BAR1StateUnit,
 							Desc:              BAR1StateDesc,
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(256)),
 						},
+						BAR1UsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            BAR1UsedDesc,
+							IntNumeratorVal: pointer.Of(int64(0)),
+						},
 						ECCErrorsL1CacheAttr: {
 							Unit:            ECCErrorsL1CacheUnit,
 							Desc:            ECCErrorsL1CacheDesc,
@@ -1795,6 +2006,11 @@ func TestStatsForItem(t *testing.T) {
 							Desc:            MemoryUtilizationDesc,
 							IntNumeratorVal: pointer.Of(int64(1)),
 						},
+						MemoryBandwidthUtilizationAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryBandwidthUtilizationDesc,
+							IntNumeratorVal: pointer.Of(int64(1)),
+						},
 						EncoderUtilizationAttr: {
 							Unit:            EncoderUtilizationUnit,
 							Desc:            EncoderUtilizationDesc,
@@ -1816,12 +2032,22 @@ func TestStatsForItem(t *testing.T) {
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(1)),
 						},
+						MemoryUsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            MemoryUsedDesc,
+							IntNumeratorVal: pointer.Of(int64(100)),
+						},
 						BAR1StateAttr: {
 							Unit:              BAR1StateUnit,
 							Desc:              BAR1StateDesc,
 							IntNumeratorVal:   pointer.Of(int64(1)),
 							IntDenominatorVal: pointer.Of(int64(256)),
 						},
+						BAR1UsedAttr: {
+							Unit:            UnitPercent,
+							Desc:            BAR1UsedDesc,
+							IntNumeratorVal: pointer.Of(int64(0)),
+						},
 						ECCErrorsL1CacheAttr: {
 							Unit:            ECCErrorsL1CacheUnit,
 							Desc:            ECCErrorsL1CacheDesc,
@@ -1843,7 +2069,7 @@ func TestStatsForItem(t *testing.T) {
 			},
 		},
 	} {
-		actualResult := statsForItem(testCase.ItemStat, testCase.Timestamp)
+		actualResult := statsForItem(testCase.ItemStat, testCase.Timestamp, statsOptions{})
 		must.Eq(t, testCase.ExpectedResult, actualResult)
 	}
 }
@@ -1923,8 +2149,8 @@ func TestStatsForGroup(t *testing.T) {
 				},
 			},
 			ExpectedResult: &device.DeviceGroupStats{
-				Vendor: vendor,
-				Type:   deviceType,
+				Vendor: Vendor.Name,
+				Type:   Vendor.DeviceType,
 				Name:   "DeviceName1",
 				InstanceStats: map[string]*device.DeviceStats{
 					"UUID1": {
@@ -1952,6 +2178,11 @@ func TestStatsForGroup(t *testing.T) {
 									Desc:            MemoryUtilizationDesc,
 									IntNumeratorVal: pointer.Of(int64(1)),
 								},
+								MemoryBandwidthUtilizationAttr: {
+									Unit:            UnitPercent,
+									Desc:            MemoryBandwidthUtilizationDesc,
+									IntNumeratorVal: pointer.Of(int64(1)),
+								},
 								EncoderUtilizationAttr: {
 									Unit:            EncoderUtilizationUnit,
 									Desc:            EncoderUtilizationDesc,
@@ -1973,12 +2204,22 @@ func TestStatsForGroup(t *testing.T) {
 									IntNumeratorVal:   pointer.Of(int64(1)),
 									IntDenominatorVal: pointer.Of(int64(1)),
 								},
+								MemoryUsedAttr: {
+									Unit:            UnitPercent,
+									Desc:            MemoryUsedDesc,
+									IntNumeratorVal: pointer.Of(int64(100)),
+								},
 								BAR1StateAttr: {
 									Unit:              BAR1StateUnit,
 									Desc:              BAR1StateDesc,
 									IntNumeratorVal:   pointer.Of(int64(1)),
 									IntDenominatorVal: pointer.Of(int64(256)),
 								},
+								BAR1UsedAttr: {
+									Unit:            UnitPercent,
+									Desc:            BAR1UsedDesc,
+									IntNumeratorVal: pointer.Of(int64(0)),
+								},
 								ECCErrorsL1CacheAttr: {
 									Unit:            ECCErrorsL1CacheUnit,
 									Desc:            ECCErrorsL1CacheDesc,
@@ -2023,6 +2264,11 @@ func TestStatsForGroup(t *testing.T) {
 									Desc:            MemoryUtilizationDesc,
 									IntNumeratorVal: pointer.Of(int64(2)),
 								},
+								MemoryBandwidthUtilizationAttr: {
+									Unit:            UnitPercent,
+									Desc:            MemoryBandwidthUtilizationDesc,
+									IntNumeratorVal: pointer.Of(int64(2)),
+								},
 								EncoderUtilizationAttr: {
 									Unit:            EncoderUtilizationUnit,
 									Desc:            EncoderUtilizationDesc,
@@ -2044,12 +2290,22 @@ func TestStatsForGroup(t *testing.T) {
 									IntNumeratorVal:   pointer.Of(int64(2)),
 									IntDenominatorVal: pointer.Of(int64(2)),
 								},
+								MemoryUsedAttr: {
+									Unit:            UnitPercent,
+									Desc:            MemoryUsedDesc,
+									IntNumeratorVal: pointer.Of(int64(100)),
+								},
 								BAR1StateAttr: {
 									Unit:              BAR1StateUnit,
 									Desc:              BAR1StateDesc,
 									IntNumeratorVal:   pointer.Of(int64(2)),
 									IntDenominatorVal: pointer.Of(int64(256)),
 								},
+								BAR1UsedAttr: {
+									Unit:            UnitPercent,
+									Desc:            BAR1UsedDesc,
+									IntNumeratorVal: pointer.Of(int64(1)),
+								},
 								ECCErrorsL1CacheAttr: {
 									Unit:            ECCErrorsL1CacheUnit,
 									Desc:            ECCErrorsL1CacheDesc,
@@ -2094,6 +2350,11 @@ func TestStatsForGroup(t *testing.T) {
 									Desc:            MemoryUtilizationDesc,
 									IntNumeratorVal: pointer.Of(int64(3)),
 								},
+								MemoryBandwidthUtilizationAttr: {
+									Unit:            UnitPercent,
+									Desc:            MemoryBandwidthUtilizationDesc,
+									IntNumeratorVal: pointer.Of(int64(3)),
+								},
 								EncoderUtilizationAttr: {
 									Unit:            EncoderUtilizationUnit,
 									Desc:            EncoderUtilizationDesc,
@@ -2115,12 +2376,22 @@ func TestStatsForGroup(t *testing.T) {
 									IntNumeratorVal:   pointer.Of(int64(3)),
 									IntDenominatorVal: pointer.Of(int64(3)),
 								},
+								MemoryUsedAttr: {
+									Unit:            UnitPercent,
+									Desc:            MemoryUsedDesc,
+									IntNumeratorVal: pointer.Of(int64(100)),
+								},
 								BAR1StateAttr: {
 									Unit:              BAR1StateUnit,
 									Desc:              BAR1StateDesc,
 									IntNumeratorVal:   pointer.Of(int64(3)),
 									IntDenominatorVal: pointer.Of(int64(256)),
 								},
+								BAR1UsedAttr: {
+									Unit:            UnitPercent,
+									Desc:            BAR1UsedDesc,
+									IntNumeratorVal: pointer.Of(int64(1)),
+								},
 								ECCErrorsL1CacheAttr: {
 									Unit:            ECCErrorsL1CacheUnit,
 									Desc:            ECCErrorsL1CacheDesc,
@@ -2144,7 +2415,7 @@ func TestStatsForGroup(t *testing.T) {
 			},
 		},
 	} {
-		actualResult := statsForGroup(testCase.GroupName, testCase.GroupStats, testCase.Timestamp)
+		actualResult := statsForGroup(testCase.GroupName, testCase.GroupStats, testCase.Timestamp, statsOptions{})
 		must.Eq(t, testCase.ExpectedResult, actualResult)
 	}
 }
@@ -2163,7 +2434,7 @@ func TestWriteStatsToChannel(t *testing.T) {
 				Error: errors.New(""),
 			},
 			Device: &NvidiaDevice{
-				nvmlClient: &MockNvmlClient{
+				collector: &MockNvmlClient{
 					StatsError: errors.New(""),
 				},
 				logger: hclog.NewNullLogger(),
@@ -2178,7 +2449,7 @@ func TestWriteStatsToChannel(t *testing.T) {
 					"UUID2": {},
 					"UUID3": {},
 				},
-				nvmlClient: &MockNvmlClient{
+				collector: &MockNvmlClient{
 					StatsResponseReturned: []*nvml.StatsData{
 						{
 							DeviceData: &nvml.DeviceData{
@@ -2247,8 +2518,8 @@ func TestWriteStatsToChannel(t *testing.T) {
 			ExpectedWriteToChannel: &device.StatsResponse{
 				Groups: []*device.DeviceGroupStats{
 					{
-						Vendor: vendor,
-						Type:   deviceType,
+						Vendor: Vendor.Name,
+						Type:   Vendor.DeviceType,
 						Name:   "DeviceName1",
 						InstanceStats: map[string]*device.DeviceStats{
 							"UUID1": {
@@ -2276,6 +2547,11 @@ func TestWriteStatsToChannel(t *testing.T) {
 											Desc:            MemoryUtilizationDesc,
 											IntNumeratorVal: pointer.Of(int64(1)),
 										},
+										MemoryBandwidthUtilizationAttr: {
+											Unit:            UnitPercent,
+											Desc:            MemoryBandwidthUtilizationDesc,
+											IntNumeratorVal: pointer.Of(int64(1)),
+										},
 										EncoderUtilizationAttr: {
 											Unit:            EncoderUtilizationUnit,
 											Desc:            EncoderUtilizationDesc,
@@ -2297,12 +2573,22 @@ func TestWriteStatsToChannel(t *testing.T) {
 											IntNumeratorVal:   pointer.Of(int64(1)),
 											IntDenominatorVal: pointer.Of(int64(1)),
 										},
+										MemoryUsedAttr: {
+											Unit:            UnitPercent,
+											Desc:            MemoryUsedDesc,
+											IntNumeratorVal: pointer.Of(int64(100)),
+										},
 										BAR1StateAttr: {
 											Unit:              BAR1StateUnit,
 											Desc:              BAR1StateDesc,
 											IntNumeratorVal:   pointer.Of(int64(1)),
 											IntDenominatorVal: pointer.Of(int64(256)),
 										},
+										BAR1UsedAttr: {
+											Unit:            UnitPercent,
+											Desc:            BAR1UsedDesc,
+											IntNumeratorVal: pointer.Of(int64(0)),
+										},
 										ECCErrorsL1CacheAttr: {
 											Unit:            ECCErrorsL1CacheUnit,
 											Desc:            ECCErrorsL1CacheDesc,
@@ -2325,8 +2611,8 @@ func TestWriteStatsToChannel(t *testing.T) {
 						},
 					},
 					{
-						Vendor: vendor,
-						Type:   deviceType,
+						Vendor: Vendor.Name,
+						Type:   Vendor.DeviceType,
 						Name:   "DeviceName2",
 						InstanceStats: map[string]*device.DeviceStats{
 							"UUID2": {
@@ -2354,6 +2640,11 @@ func TestWriteStatsToChannel(t *testing.T) {
 											Desc:            MemoryUtilizationDesc,
 											IntNumeratorVal: pointer.Of(int64(2)),
 										},
+										MemoryBandwidthUtilizationAttr: {
+											Unit:            UnitPercent,
+											Desc:            MemoryBandwidthUtilizationDesc,
+											IntNumeratorVal: pointer.Of(int64(2)),
+										},
 										EncoderUtilizationAttr: {
 											Unit:            EncoderUtilizationUnit,
 											Desc:            EncoderUtilizationDesc,
@@ -2375,12 +2666,22 @@ func TestWriteStatsToChannel(t *testing.T) {
 											IntNumeratorVal:   pointer.Of(int64(2)),
 											IntDenominatorVal: pointer.Of(int64(2)),
 										},
+										MemoryUsedAttr: {
+											Unit:            UnitPercent,
+											Desc:            MemoryUsedDesc,
+											IntNumeratorVal: pointer.Of(int64(100)),
+										},
 										BAR1StateAttr: {
 											Unit:              BAR1StateUnit,
 											Desc:              BAR1StateDesc,
 											IntNumeratorVal:   pointer.Of(int64(2)),
 											IntDenominatorVal: pointer.Of(int64(256)),
 										},
+										BAR1UsedAttr: {
+											Unit:            UnitPercent,
+											Desc:            BAR1UsedDesc,
+											IntNumeratorVal: pointer.Of(int64(1)),
+										},
 										ECCErrorsL1CacheAttr: {
 											Unit:            ECCErrorsL1CacheUnit,
 											Desc:            ECCErrorsL1CacheDesc,
@@ -2403,8 +2704,8 @@ func TestWriteStatsToChannel(t *testing.T) {
 						},
 					},
 					{
-						Vendor: vendor,
-						Type:   deviceType,
+						Vendor: Vendor.Name,
+						Type:   Vendor.DeviceType,
 						Name:   "DeviceName3",
 						InstanceStats: map[string]*device.DeviceStats{
 							"UUID3": {
@@ -2432,6 +2733,11 @@ func TestWriteStatsToChannel(t *testing.T) {
 											Desc:            MemoryUtilizationDesc,
 											IntNumeratorVal: pointer.Of(int64(3)),
 										},
+										MemoryBandwidthUtilizationAttr: {
+											Unit:            UnitPercent,
+											Desc:            MemoryBandwidthUtilizationDesc,
+											IntNumeratorVal: pointer.Of(int64(3)),
+										},
 										EncoderUtilizationAttr: {
 											Unit:            EncoderUtilizationUnit,
 											Desc:            EncoderUtilizationDesc,
@@ -2453,12 +2759,22 @@ func TestWriteStatsToChannel(t *testing.T) {
 											IntNumeratorVal:   pointer.Of(int64(3)),
 											IntDenominatorVal: pointer.Of(int64(3)),
 										},
+										MemoryUsedAttr: {
+											Unit:            UnitPercent,
+											Desc:            MemoryUsedDesc,
+											IntNumeratorVal: pointer.Of(int64(100)),
+										},
 										BAR1StateAttr: {
 											Unit:              BAR1StateUnit,
 											Desc:              BAR1StateDesc,
 											IntNumeratorVal:   pointer.Of(int64(3)),
 											IntDenominatorVal: pointer.Of(int64(256)),
 										},
+										BAR1UsedAttr: {
+											Unit:            UnitPercent,
+											Desc:            BAR1UsedDesc,
+											IntNumeratorVal: pointer.Of(int64(1)),
+										},
 										ECCErrorsL1CacheAttr: {
 											Unit:            ECCErrorsL1CacheUnit,
 											Desc:            ECCErrorsL1CacheDesc,
@@ -2492,7 +2808,7 @@ func TestWriteStatsToChannel(t *testing.T) {
 					"UUID2": {},
 					"UUID3": {},
 				},
-				nvmlClient: &MockNvmlClient{
+				collector: &MockNvmlClient{
 					StatsResponseReturned: []*nvml.StatsData{
 						{
 							DeviceData: &nvml.DeviceData{
@@ -2561,8 +2877,8 @@ func TestWriteStatsToChannel(t *testing.T) {
 			ExpectedWriteToChannel: &device.StatsResponse{
 				Groups: []*device.DeviceGroupStats{
 					{
-						Vendor: vendor,
-						Type:   deviceType,
+						Vendor: Vendor.Name,
+						Type:   Vendor.DeviceType,
 						Name:   "DeviceName1",
 						InstanceStats: map[string]*device.DeviceStats{
 							"UUID1": {
@@ -2590,6 +2906,11 @@ func TestWriteStatsToChannel(t *testing.T) {
 											Desc:            MemoryUtilizationDesc,
 											IntNumeratorVal: pointer.Of(int64(1)),
 										},
+										MemoryBandwidthUtilizationAttr: {
+											Unit:            UnitPercent,
+											Desc:            MemoryBandwidthUtilizationDesc,
+											IntNumeratorVal: pointer.Of(int64(1)),
+										},
 										EncoderUtilizationAttr: {
 											Unit:            EncoderUtilizationUnit,
 											Desc:            EncoderUtilizationDesc,
@@ -2611,12 +2932,22 @@ func TestWriteStatsToChannel(t *testing.T) {
 											IntNumeratorVal:   pointer.Of(int64(1)),
 											IntDenominatorVal: pointer.Of(int64(1)),
 										},
+										MemoryUsedAttr: {
+											Unit:            UnitPercent,
+											Desc:            MemoryUsedDesc,
+											IntNumeratorVal: pointer.Of(int64(100)),
+										},
 										BAR1StateAttr: {
 											Unit:              BAR1StateUnit,
 											Desc:              BAR1StateDesc,
 											IntNumeratorVal:   pointer.Of(int64(1)),
 											IntDenominatorVal: pointer.Of(int64(256)),
 										},
+										BAR1UsedAttr: {
+											Unit:            UnitPercent,
+											Desc:            BAR1UsedDesc,
+											IntNumeratorVal: pointer.Of(int64(0)),
+										},
 										ECCErrorsL1CacheAttr: {
 											Unit:            ECCErrorsL1CacheUnit,
 											Desc:            ECCErrorsL1CacheDesc,
@@ -2639,8 +2970,8 @@ func TestWriteStatsToChannel(t *testing.T) {
 						},
 					},
 					{
-						Vendor: vendor,
-						Type:   deviceType,
+						Vendor: Vendor.Name,
+						Type:   Vendor.DeviceType,
 						Name:   "DeviceName2",
 						InstanceStats: map[string]*device.DeviceStats{
 							"UUID3": {
@@ -2668,6 +2999,11 @@ func TestWriteStatsToChannel(t *testing.T) {
 											Desc:            MemoryUtilizationDesc,
 											IntNumeratorVal: pointer.Of(int64(3)),
 										},
+										MemoryBandwidthUtilizationAttr: {
+											Unit:            UnitPercent,
+											Desc:            MemoryBandwidthUtilizationDesc,
+											IntNumeratorVal: pointer.Of(int64(3)),
+										},
 										EncoderUtilizationAttr: {
 											Unit:            EncoderUtilizationUnit,
 											Desc:            EncoderUtilizationDesc,
@@ -2689,12 +3025,22 @@ func TestWriteStatsToChannel(t *testing.T) {
 											IntNumeratorVal:   pointer.Of(int64(3)),
 											IntDenominatorVal: pointer.Of(int64(3)),
 										},
+										MemoryUsedAttr: {
+											Unit:            UnitPercent,
+											Desc:            MemoryUsedDesc,
+											IntNumeratorVal: pointer.Of(int64(100)),
+										},
 										BAR1StateAttr: {
 											Unit:              BAR1StateUnit,
 											Desc:              BAR1StateDesc,
 											IntNumeratorVal:   pointer.Of(int64(3)),
 											IntDenominatorVal: pointer.Of(int64(256)),
 										},
+										BAR1UsedAttr: {
+											Unit:            UnitPercent,
+											Desc:            BAR1UsedDesc,
+											IntNumeratorVal: pointer.Of(int64(1)),
+										},
 										ECCErrorsL1CacheAttr: {
 											Unit:            ECCErrorsL1CacheUnit,
 											Desc:            ECCErrorsL1CacheDesc,
@@ -2739,6 +3085,11 @@ func TestWriteStatsToChannel(t *testing.T) {
 											Desc:            MemoryUtilizationDesc,
 											IntNumeratorVal: pointer.Of(int64(2)),
 										},
+										MemoryBandwidthUtilizationAttr: {
+											Unit:            UnitPercent,
+											Desc:            MemoryBandwidthUtilizationDesc,
+											IntNumeratorVal: pointer.Of(int64(2)),
+										},
 										EncoderUtilizationAttr: {
 											Unit:            EncoderUtilizationUnit,
 											Desc:            EncoderUtilizationDesc,
@@ -2760,12 +3111,22 @@ func TestWriteStatsToChannel(t *testing.T) {
 											IntNumeratorVal:   pointer.Of(int64(2)),
 											IntDenominatorVal: pointer.Of(int64(2)),
 										},
+										MemoryUsedAttr: {
+											Unit:            UnitPercent,
+											Desc:            MemoryUsedDesc,
+											IntNumeratorVal: pointer.Of(int64(100)),
+										},
 										BAR1StateAttr: {
 											Unit:              BAR1StateUnit,
 											Desc:              BAR1StateDesc,
 											IntNumeratorVal:   pointer.Of(int64(2)),
 											IntDenominatorVal: pointer.Of(int64(256)),
 										},
+										BAR1UsedAttr: {
+											Unit:            UnitPercent,
+											Desc:            BAR1UsedDesc,
+											IntNumeratorVal: pointer.Of(int64(1)),
+										},
 										ECCErrorsL1CacheAttr: {
 											Unit:            ECCErrorsL1CacheUnit,
 											Desc:            ECCErrorsL1CacheDesc,
@@ -2798,7 +3159,7 @@ func TestWriteStatsToChannel(t *testing.T) {
 					"UUID1": {},
 					"UUID2": {},
 				},
-				nvmlClient: &MockNvmlClient{
+				collector: &MockNvmlClient{
 					StatsResponseReturned: []*nvml.StatsData{
 						{
 							DeviceData: &nvml.DeviceData{
@@ -2867,8 +3228,8 @@ func TestWriteStatsToChannel(t *testing.T) {
 			ExpectedWriteToChannel: &device.StatsResponse{
 				Groups: []*device.DeviceGroupStats{
 					{
-						Vendor: vendor,
-						Type:   deviceType,
+						Vendor: Vendor.Name,
+						Type:   Vendor.DeviceType,
 						Name:   "DeviceName1",
 						InstanceStats: map[string]*device.DeviceStats{
 							"UUID1": {
@@ -2896,6 +3257,11 @@ func TestWriteStatsToChannel(t *testing.T) {
 											Desc:            MemoryUtilizationDesc,
 											IntNumeratorVal: pointer.Of(int64(1)),
 										},
+										MemoryBandwidthUtilizationAttr: {
+											Unit:            UnitPercent,
+											Desc:            MemoryBandwidthUtilizationDesc,
+											IntNumeratorVal: pointer.Of(int64(1)),
+										},
 										EncoderUtilizationAttr: {
 											Unit:            EncoderUtilizationUnit,
 											Desc:            EncoderUtilizationDesc,
@@ -2917,12 +3283,22 @@ func TestWriteStatsToChannel(t *testing.T) {
 											IntNumeratorVal:   pointer.Of(int64(1)),
 											IntDenominatorVal: pointer.Of(int64(1)),
 										},
+										MemoryUsedAttr: {
+											Unit:            UnitPercent,
+											Desc:            MemoryUsedDesc,
+											IntNumeratorVal: pointer.Of(int64(100)),
+										},
 										BAR1StateAttr: {
 											Unit:              BAR1StateUnit,
 											Desc:              BAR1StateDesc,
 											IntNumeratorVal:   pointer.Of(int64(1)),
 											IntDenominatorVal: pointer.Of(int64(256)),
 										},
+										BAR1UsedAttr: {
+											Unit:            UnitPercent,
+											Desc:            BAR1UsedDesc,
+											IntNumeratorVal: pointer.Of(int64(0)),
+										},
 										ECCErrorsL1CacheAttr: {
 											Unit:            ECCErrorsL1CacheUnit,
 											Desc:            ECCErrorsL1CacheDesc,
@@ -2945,8 +3321,8 @@ func TestWriteStatsToChannel(t *testing.T) {
 						},
 					},
 					{
-						Vendor: vendor,
-						Type:   deviceType,
+						Vendor: Vendor.Name,
+						Type:   Vendor.DeviceType,
 						Name:   "DeviceName2",
 						InstanceStats: map[string]*device.DeviceStats{
 							"UUID2": {
@@ -2974,6 +3350,11 @@ func TestWriteStatsToChannel(t *testing.T) {
 											Desc:            MemoryUtilizationDesc,
 											IntNumeratorVal: pointer.Of(int64(2)),
 										},
+										MemoryBandwidthUtilizationAttr: {
+											Unit:            UnitPercent,
+											Desc:            MemoryBandwidthUtilizationDesc,
+											IntNumeratorVal: pointer.Of(int64(2)),
+										},
 										EncoderUtilizationAttr: {
 											Unit:            EncoderUtilizationUnit,
 											Desc:            EncoderUtilizationDesc,
@@ -2995,12 +3376,22 @@ func TestWriteStatsToChannel(t *testing.T) {
 											IntNumeratorVal:   pointer.Of(int64(2)),
 											IntDenominatorVal: pointer.Of(int64(2)),
 										},
+										MemoryUsedAttr: {
+											Unit:            UnitPercent,
+											Desc:            MemoryUsedDesc,
+											IntNumeratorVal: pointer.Of(int64(100)),
+										},
 										BAR1StateAttr: {
 											Unit:              BAR1StateUnit,
 											Desc:              BAR1StateDesc,
 											IntNumeratorVal:   pointer.Of(int64(2)),
 											IntDenominatorVal: pointer.Of(int64(256)),
 										},
+										BAR1UsedAttr: {
+											Unit:            UnitPercent,
+											Desc:            BAR1UsedDesc,
+											IntNumeratorVal: pointer.Of(int64(1)),
+										},
 										ECCErrorsL1CacheAttr: {
 											Unit:            ECCErrorsL1CacheUnit,
 											Desc:            ECCErrorsL1CacheDesc,
@@ -3027,18 +3418,925 @@ func TestWriteStatsToChannel(t *testing.T) {
 		},
 	} {
 		channel := make(chan *device.StatsResponse, 1)
-		testCase.Device.writeStatsToChannel(channel, testCase.Timestamp)
+		testCase.Device.writeStatsToChannel(channel, testCase.Timestamp, 0)
 		actualResult := <-channel
-		// writeStatsToChannel iterates over map keys
-		// and insterts results to an array, so order of elements in output array
-		// may be different
-		// actualResult, expectedWriteToChannel arrays has to be sorted firsted
-		sort.Slice(actualResult.Groups, func(i, j int) bool {
-			return actualResult.Groups[i].Name < actualResult.Groups[j].Name
+		must.Eq(t, testCase.ExpectedWriteToChannel, actualResult)
+	}
+}
+
+func TestAggregateStatsGroup(t *testing.T) {
+	timestamp := time.Date(1974, time.May, 19, 1, 2, 3, 4, time.UTC)
+	statsData := []*nvml.StatsData{
+		{
+			DeviceData: &nvml.DeviceData{
+				UUID:      "UUID1",
+				MemoryMiB: pointer.Of(uint64(1000)),
+				PowerW:    pointer.Of(uint(300)),
+			},
+			PowerUsageW:    pointer.Of(uint(100)),
+			GPUUtilization: pointer.Of(uint(20)),
+			TemperatureC:   pointer.Of(uint(60)),
+			UsedMemoryMiB:  pointer.Of(uint64(250)),
+		},
+		{
+			DeviceData: &nvml.DeviceData{
+				UUID:      "UUID2",
+				MemoryMiB: pointer.Of(uint64(1000)),
+				PowerW:    pointer.Of(uint(300)),
+			},
+			PowerUsageW:    pointer.Of(uint(200)),
+			GPUUtilization: pointer.Of(uint(60)),
+			TemperatureC:   pointer.Of(uint(80)),
+			UsedMemoryMiB:  pointer.Of(uint64(500)),
+		},
+		{
+			// devices missing values are left out of the aggregate
+			DeviceData: &nvml.DeviceData{
+				UUID: "UUID3",
+			},
+		},
+	}
+
+	memoryState := &structs.StatValue{
+		Unit:              MemoryStateUnit,
+		Desc:              AggregateMemoryStateDesc,
+		IntNumeratorVal:   pointer.Of(int64(750)),
+		IntDenominatorVal: pointer.Of(int64(2000)),
+	}
+	must.Eq(t, &device.DeviceGroupStats{
+		Vendor: Vendor.Name,
+		Type:   Vendor.DeviceType,
+		Name:   AggregateStatsGroupName,
+		InstanceStats: map[string]*device.DeviceStats{
+			AggregateStatsInstanceName: {
+				Summary: memoryState,
+				Stats: &structs.StatObject{
+					Attributes: map[string]*structs.StatValue{
+						PowerUsageAttr: {
+							Unit:              PowerUsageUnit,
+							Desc:              AggregatePowerUsageDesc,
+							IntNumeratorVal:   pointer.Of(int64(300)),
+							IntDenominatorVal: pointer.Of(int64(600)),
+						},
+						GPUUtilizationAttr: {
+							Unit:            GPUUtilizationUnit,
+							Desc:            AggregateGPUUtilizationDesc,
+							IntNumeratorVal: pointer.Of(int64(40)),
+						},
+						TemperatureAttr: {
+							Unit:            TemperatureUnit,
+							Desc:            AggregateTemperatureDesc,
+							IntNumeratorVal: pointer.Of(int64(80)),
+						},
+						MemoryStateAttr: memoryState,
+					},
+				},
+				Timestamp: timestamp,
+			},
+		},
+	}, aggregateStatsGroup(statsData, timestamp, statsOptions{}))
+}
+
+func TestAggregateStatsGroupMilliwatts(t *testing.T) {
+	timestamp := time.Date(1974, time.May, 19, 1, 2, 3, 4, time.UTC)
+	statsData := []*nvml.StatsData{
+		{
+			DeviceData: &nvml.DeviceData{
+				UUID:   "UUID1",
+				PowerW: pointer.Of(uint(300)),
+			},
+			PowerMW:      pointer.Of(uint(300000)),
+			PowerUsageW:  pointer.Of(uint(100)),
+			PowerUsageMW: pointer.Of(uint(100500)),
+		},
+		{
+			DeviceData: &nvml.DeviceData{
+				UUID:   "UUID2",
+				PowerW: pointer.Of(uint(300)),
+			},
+			PowerMW:      pointer.Of(uint(300000)),
+			PowerUsageW:  pointer.Of(uint(200)),
+			PowerUsageMW: pointer.Of(uint(200250)),
+		},
+	}
+
+	group := aggregateStatsGroup(statsData, timestamp, statsOptions{powerUnit: structs.UnitmW})
+	must.Eq(t, &structs.StatValue{
+		Unit:              PowerUsageMilliwattsUnit,
+		Desc:              AggregatePowerUsageMilliwattsDesc,
+		IntNumeratorVal:   pointer.Of(int64(300750)),
+		IntDenominatorVal: pointer.Of(int64(600000)),
+	}, group.InstanceStats[AggregateStatsInstanceName].Stats.Attributes[PowerUsageAttr])
+}
+
+func TestWriteStatsToChannelAggregate(t *testing.T) {
+	d := &NvidiaDevice{
+		devices: map[string]struct{}{
+			"UUID1": {},
+		},
+		collector: &MockNvmlClient{
+			StatsResponseReturned: []*nvml.StatsData{
+				{
+					DeviceData: &nvml.DeviceData{
+						UUID:       "UUID1",
+						DeviceName: pointer.Of("DeviceName1"),
+					},
+				},
+			},
+		},
+		aggregateStats: true,
+		logger:         hclog.NewNullLogger(),
+	}
+
+	channel := make(chan *device.StatsResponse, 1)
+	d.writeStatsToChannel(channel, time.Now(), 0)
+	result := <-channel
+	must.Len(t, 2, result.Groups)
+
+	must.Eq(t, "DeviceName1", result.Groups[0].Name)
+	must.Eq(t, AggregateStatsGroupName, result.Groups[1].Name)
+}
+
+func TestWriteStatsToChannelDiagnostics(t *testing.T) {
+	d := &NvidiaDevice{
+		devices: map[string]struct{}{
+			"UUID1": {},
+		},
+		collector: &MockNvmlClient{
+			StatsResponseReturned: []*nvml.StatsData{
+				{
+					DeviceData: &nvml.DeviceData{
+						UUID:       "UUID1",
+						DeviceName: pointer.Of("DeviceName1"),
+					},
+					QueryDuration: 1500 * time.Microsecond,
+				},
+			},
+		},
+		diagnosticStats: true,
+		logger:          hclog.NewNullLogger(),
+	}
+
+	channel := make(chan *device.StatsResponse, 1)
+	d.writeStatsToChannel(channel, time.Now(), 2*time.Second)
+	result := <-channel
+	must.Len(t, 2, result.Groups)
+
+	diagnostics := result.Groups[1]
+	must.Eq(t, DiagnosticsStatsGroupName, diagnostics.Name)
+
+	node := diagnostics.InstanceStats[DiagnosticsStatsInstanceName]
+	must.NotNil(t, node)
+	must.NotNil(t, node.Stats.Attributes[CollectionDurationAttr].FloatNumeratorVal)
+	must.Eq(t, 2000, *node.Stats.Attributes[TimestampSkewAttr].FloatNumeratorVal)
+
+	query := diagnostics.InstanceStats["UUID1"].Stats.Attributes[QueryDurationAttr]
+	must.Eq(t, UnitMillis, query.Unit)
+	must.Eq(t, 1.5, *query.FloatNumeratorVal)
+}
+
+func TestWriteStatsToChannelDropped(t *testing.T) {
+	d := &NvidiaDevice{
+		devices: map[string]struct{}{
+			"UUID1": {},
+		},
+		collector: &MockNvmlClient{
+			StatsResponseReturned: []*nvml.StatsData{
+				{
+					DeviceData: &nvml.DeviceData{
+						UUID:       "UUID1",
+						DeviceName: pointer.Of("DeviceName1"),
+					},
+				},
+			},
+		},
+		diagnosticStats: true,
+		logger:          hclog.NewNullLogger(),
+	}
+
+	// a stalled consumer does not block collections
+	channel := make(chan *device.StatsResponse, 1)
+	d.writeStatsToChannel(channel, time.Now(), 0)
+	d.writeStatsToChannel(channel, time.Now(), 0)
+	must.Eq(t, 1, d.statsDropped.Load())
+
+	<-channel
+	d.writeStatsToChannel(channel, time.Now(), 0)
+	result := <-channel
+	dropped := result.Groups[1].InstanceStats[DiagnosticsStatsInstanceName].Stats.Attributes[DroppedStatsAttr]
+	must.Eq(t, &structs.StatValue{
+		Unit:            UnitCount,
+		Desc:            DroppedStatsDesc,
+		IntNumeratorVal: pointer.Of(int64(1)),
+	}, dropped)
+}
+
+func TestWriteStatsToChannelDeviceError(t *testing.T) {
+	d := &NvidiaDevice{
+		devices: map[string]struct{}{
+			"UUID1": {},
+			"UUID2": {},
+		},
+		collector: &MockNvmlClient{
+			StatsResponseReturned: []*nvml.StatsData{
+				{
+					DeviceData: &nvml.DeviceData{
+						UUID:       "UUID1",
+						DeviceName: pointer.Of("DeviceName1"),
+					},
+					GPUUtilization: pointer.Of(uint(87)),
+				},
+				{
+					DeviceData: &nvml.DeviceData{
+						UUID:       "UUID2",
+						DeviceName: pointer.Of("DeviceName1"),
+					},
+					Error: "nvidia nvml DeviceInfoAndStatusByUUID() error: Unknown Error",
+				},
+			},
+		},
+		logger: hclog.NewNullLogger(),
+	}
+
+	// the failing device is reported along with the stats of the others
+	channel := make(chan *device.StatsResponse, 1)
+	timestamp := time.Now()
+	d.writeStatsToChannel(channel, timestamp, 0)
+	result := <-channel
+	must.NoError(t, result.Error)
+	must.Len(t, 1, result.Groups)
+
+	group := result.Groups[0]
+	must.Eq(t, 87, *group.InstanceStats["UUID1"].Stats.Attributes[GPUUtilizationAttr].IntNumeratorVal)
+
+	errorStat := &structs.StatValue{
+		Desc:      DeviceErrorDesc,
+		StringVal: pointer.Of("nvidia nvml DeviceInfoAndStatusByUUID() error: Unknown Error"),
+	}
+	must.Eq(t, &device.DeviceStats{
+		Summary: errorStat,
+		Stats: &structs.StatObject{
+			Attributes: map[string]*structs.StatValue{DeviceErrorAttr: errorStat},
+		},
+		Timestamp: timestamp,
+	}, group.InstanceStats["UUID2"])
+}
+
+func TestCheckMissingStats(t *testing.T) {
+	var out bytes.Buffer
+	d := &NvidiaDevice{
+		devices: map[string]struct{}{
+			"UUID1":     {},
+			"MIG-UUID2": {},
+			"UUID3":     {},
+			"UUID4":     {},
+		},
+		migParents:    map[string]string{"MIG-UUID2": "UUID5"},
+		migParentGPUs: map[string]struct{}{"UUID5": {}},
+		unhealthy:     map[string]*deviceHealth{"UUID3": {}},
+		logger:        hclog.New(&hclog.LoggerOptions{Output: &out}),
+	}
+
+	// MIG devices have no stats by design and unhealthy devices already
+	// report why they have none
+	d.checkMissingStats([]*nvml.StatsData{
+		{DeviceData: &nvml.DeviceData{UUID: "UUID1"}},
+	})
+	must.StrContains(t, out.String(), "uuid=UUID4")
+	must.StrNotContains(t, out.String(), "UUID2")
+	must.StrNotContains(t, out.String(), "UUID3")
+}
+
+func TestWriteStatsToChannelMetricKeys(t *testing.T) {
+	d := &NvidiaDevice{
+		devices: map[string]struct{}{
+			"UUID1": {},
+		},
+		collector: &MockNvmlClient{
+			StatsResponseReturned: []*nvml.StatsData{
+				{
+					DeviceData: &nvml.DeviceData{
+						UUID:       "UUID1",
+						DeviceName: pointer.Of("DeviceName1"),
+						MemoryMiB:  pointer.Of(uint64(1024)),
+					},
+					GPUUtilization: pointer.Of(uint(87)),
+					UsedMemoryMiB:  pointer.Of(uint64(512)),
+				},
+			},
+		},
+		diagnosticStats: true,
+		statsOptions: statsOptions{
+			summary:    GPUUtilizationAttr,
+			transforms: map[string][]statTransform{GPUUtilizationAttr: {scaleStat(0.01, "")}},
+			metricKeys: true,
+		},
+		logger: hclog.NewNullLogger(),
+	}
+
+	channel := make(chan *device.StatsResponse, 1)
+	d.writeStatsToChannel(channel, time.Now(), 0)
+	result := <-channel
+	must.Len(t, 2, result.Groups)
+
+	instance := result.Groups[0].InstanceStats["UUID1"]
+	must.Eq(t, "gpu_utilization", instance.Stats.Attributes[GPUUtilizationAttr].Desc)
+	must.Eq(t, "memory_state", instance.Stats.Attributes[MemoryStateAttr].Desc)
+	must.Eq(t, "temperature", instance.Stats.Attributes[TemperatureAttr].Desc)
+	// transformed summaries are distinct values with the key of their metric
+	must.Eq(t, "gpu_utilization", instance.Summary.Desc)
+
+	diagnostics := result.Groups[1]
+	must.Eq(t, "timestamp_skew", diagnostics.InstanceStats[DiagnosticsStatsInstanceName].Stats.Attributes[TimestampSkewAttr].Desc)
+	must.Eq(t, "collection_duration", diagnostics.InstanceStats[DiagnosticsStatsInstanceName].Summary.Desc)
+	must.Eq(t, "query_duration", diagnostics.InstanceStats["UUID1"].Summary.Desc)
+}
+
+func TestWriteStatsToChannelMetricKeysSummary(t *testing.T) {
+	d := &NvidiaDevice{
+		devices: map[string]struct{}{
+			"UUID1": {},
+		},
+		collector: &MockNvmlClient{
+			StatsResponseReturned: []*nvml.StatsData{
+				{
+					DeviceData: &nvml.DeviceData{
+						UUID:       "UUID1",
+						DeviceName: pointer.Of("DeviceName1"),
+						MemoryMiB:  pointer.Of(uint64(1024)),
+					},
+					GPUUtilization: pointer.Of(uint(87)),
+					UsedMemoryMiB:  pointer.Of(uint64(512)),
+				},
+			},
+		},
+		aggregateStats: true,
+		statsOptions: statsOptions{
+			summary:        GPUUtilizationAttr,
+			enabledMetrics: map[string]struct{}{TemperatureAttr: {}},
+			metricKeys:     true,
+		},
+		logger: hclog.NewNullLogger(),
+	}
+
+	channel := make(chan *device.StatsResponse, 1)
+	d.writeStatsToChannel(channel, time.Now(), 0)
+	result := <-channel
+	must.Len(t, 2, result.Groups)
+
+	// the summary takes the key of its metric even when it is not enabled
+	instance := result.Groups[0].InstanceStats["UUID1"]
+	must.MapNotContainsKey(t, instance.Stats.Attributes, GPUUtilizationAttr)
+	must.Eq(t, "gpu_utilization", instance.Summary.Desc)
+
+	aggregate := result.Groups[1].InstanceStats[AggregateStatsInstanceName]
+	must.Eq(t, "gpu_utilization", aggregate.Summary.Desc)
+}
+
+func TestStatsClock(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	d := &NvidiaDevice{
+		enabled: true,
+		devices: map[string]struct{}{
+			"UUID1": {},
+		},
+		collector: &MockNvmlClient{
+			StatsResponseReturned: []*nvml.StatsData{
+				{
+					DeviceData: &nvml.DeviceData{
+						UUID:       "UUID1",
+						DeviceName: pointer.Of("DeviceName1"),
+					},
+				},
+			},
+		},
+		diagnosticStats: true,
+		clock:           ClockFunc(func() time.Time { return at }),
+		logger:          hclog.NewNullLogger(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	channel, err := d.Stats(ctx, time.Minute)
+	must.NoError(t, err)
+	result := <-channel
+	must.Len(t, 2, result.Groups)
+
+	must.Eq(t, at, result.Groups[0].InstanceStats["UUID1"].Timestamp)
+
+	node := result.Groups[1].InstanceStats[DiagnosticsStatsInstanceName]
+	must.Eq(t, at, node.Timestamp)
+	must.Eq(t, 0, *node.Stats.Attributes[CollectionDurationAttr].FloatNumeratorVal)
+	must.Eq(t, 0, *node.Stats.Attributes[TimestampSkewAttr].FloatNumeratorVal)
+}
+
+func TestStatsUnits(t *testing.T) {
+	// units with a Nomad equivalent must be recognized by Nomad so consumers
+	// can do unit aware conversions
+	for _, unit := range []string{PowerUsageUnit, MemoryStateUnit, BAR1StateUnit} {
+		_, ok := structs.UnitIndex[unit]
+		must.True(t, ok, must.Sprintf("unit %q is not a known Nomad unit", unit))
+	}
+}
+
+func TestStatsForItemPowerUnit(t *testing.T) {
+	statsItem := &nvml.StatsData{
+		DeviceData: &nvml.DeviceData{
+			UUID:   "UUID1",
+			PowerW: pointer.Of(uint(300)),
+		},
+		PowerMW:      pointer.Of(uint(300000)),
+		PowerUsageW:  pointer.Of(uint(71)),
+		PowerUsageMW: pointer.Of(uint(71845)),
+	}
+
+	for _, testCase := range []struct {
+		Name     string
+		Options  statsOptions
+		Item     *nvml.StatsData
+		Expected *structs.StatValue
+	}{
+		{
+			Name:    "default is watts",
+			Options: statsOptions{},
+			Item:    statsItem,
+			Expected: &structs.StatValue{
+				Unit:              PowerUsageUnit,
+				Desc:              PowerUsageDesc,
+				IntNumeratorVal:   pointer.Of(int64(71)),
+				IntDenominatorVal: pointer.Of(int64(300)),
+			},
+		},
+		{
+			Name:    "watts",
+			Options: statsOptions{powerUnit: structs.UnitW},
+			Item:    statsItem,
+			Expected: &structs.StatValue{
+				Unit:              PowerUsageUnit,
+				Desc:              PowerUsageDesc,
+				IntNumeratorVal:   pointer.Of(int64(71)),
+				IntDenominatorVal: pointer.Of(int64(300)),
+			},
+		},
+		{
+			Name:    "milliwatts",
+			Options: statsOptions{powerUnit: structs.UnitmW},
+			Item:    statsItem,
+			Expected: &structs.StatValue{
+				Unit:              PowerUsageMilliwattsUnit,
+				Desc:              PowerUsageMilliwattsDesc,
+				IntNumeratorVal:   pointer.Of(int64(71845)),
+				IntDenominatorVal: pointer.Of(int64(300000)),
+			},
+		},
+		{
+			Name:    "milliwatts not available",
+			Options: statsOptions{powerUnit: structs.UnitmW},
+			Item: &nvml.StatsData{
+				DeviceData:  &nvml.DeviceData{UUID: "UUID1", PowerW: pointer.Of(uint(300))},
+				PowerUsageW: pointer.Of(uint(71)),
+			},
+			Expected: newNotAvailableDeviceStats(PowerUsageMilliwattsUnit, PowerUsageMilliwattsDesc),
+		},
+	} {
+		t.Run(testCase.Name, func(t *testing.T) {
+			result := statsForItem(testCase.Item, time.Time{}, testCase.Options)
+			must.Eq(t, testCase.Expected, result.Stats.Attributes[PowerUsageAttr])
 		})
-		sort.Slice(testCase.ExpectedWriteToChannel.Groups, func(i, j int) bool {
-			return testCase.ExpectedWriteToChannel.Groups[i].Name < testCase.ExpectedWriteToChannel.Groups[j].Name
+	}
+}
+
+func TestParseEnabledMetrics(t *testing.T) {
+	enabled, err := parseEnabledMetrics(nil)
+	must.NoError(t, err)
+	must.Nil(t, enabled)
+
+	enabled, err = parseEnabledMetrics([]string{"temperature", "power_usage"})
+	must.NoError(t, err)
+	must.Eq(t, map[string]struct{}{
+		TemperatureAttr: {},
+		PowerUsageAttr:  {},
+	}, enabled)
+
+	_, err = parseEnabledMetrics([]string{"temperature", "clock_speed"})
+	must.EqError(t, err, `unknown metric "clock_speed" in enabled_metrics`)
+}
+
+func TestStatsForItemSummaryMetric(t *testing.T) {
+	statsItem := &nvml.StatsData{
+		DeviceData: &nvml.DeviceData{
+			UUID:      "UUID1",
+			MemoryMiB: pointer.Of(uint64(1024)),
+		},
+		GPUUtilization: pointer.Of(uint(87)),
+		TemperatureC:   pointer.Of(uint(60)),
+		UsedMemoryMiB:  pointer.Of(uint64(512)),
+	}
+
+	summary, err := parseSummaryMetric("")
+	must.NoError(t, err)
+	must.Eq(t, MemoryStateAttr, summary)
+
+	_, err = parseSummaryMetric("foreign_process_count")
+	must.ErrorContains(t, err, `invalid summary metric "foreign_process_count"`)
+
+	summary, err = parseSummaryMetric("gpu_utilization")
+	must.NoError(t, err)
+	enabled, err := parseEnabledMetrics([]string{"temperature"})
+	must.NoError(t, err)
+
+	// the summary is reported even when its metric is not enabled
+	result := statsForItem(statsItem, time.Time{}, statsOptions{summary: summary, enabledMetrics: enabled})
+	must.Eq(t, pointer.Of(int64(87)), result.Summary.IntNumeratorVal)
+	must.Eq(t, GPUUtilizationUnit, result.Summary.Unit)
+	must.MapNotContainsKey(t, result.Stats.Attributes, GPUUtilizationAttr)
+
+	// the temperature summary is converted like the temperature stat
+	transforms, err := parseStatTransforms(StatsConfig{TemperatureUnit: UnitFahrenheit})
+	must.NoError(t, err)
+	result = statsForItem(statsItem, time.Time{}, statsOptions{summary: TemperatureAttr, transforms: transforms})
+	must.Eq(t, UnitFahrenheit, result.Summary.Unit)
+	must.Eq(t, result.Stats.Attributes[TemperatureAttr], result.Summary)
+}
+
+func TestStatsForItemEnabledMetrics(t *testing.T) {
+	statsItem := &nvml.StatsData{
+		DeviceData: &nvml.DeviceData{
+			UUID:      "UUID1",
+			MemoryMiB: pointer.Of(uint64(1024)),
+		},
+		TemperatureC:  pointer.Of(uint(60)),
+		UsedMemoryMiB: pointer.Of(uint64(512)),
+		EnergyMJ:      pointer.Of(uint64(3600000)),
+		FanSpeed:      pointer.Of(uint(45)),
+		NVLinkTxKiB:   pointer.Of(uint64(1 << 30)),
+		NVLinkRxKiB:   pointer.Of(uint64(1 << 29)),
+
+		JPEGUtilization: pointer.Of(uint(12)),
+		OFAUtilization:  pointer.Of(uint(3)),
+
+		GPM: &nvml.GPMMetrics{NVLinkBandwidth: pointer.Of(12.5)},
+
+		GPUUtilizationHistogram: &nvml.Histogram{P50: 20, P95: 90, Max: 100},
+		PowerUsageMWHistogram:   &nvml.Histogram{P50: 100000, P95: 250000, Max: 300000},
+		TemperatureCHistogram:   &nvml.Histogram{P50: 50, P95: 70, Max: 75},
+	}
+	enabled, err := parseEnabledMetrics([]string{"temperature"})
+	must.NoError(t, err)
+
+	result := statsForItem(statsItem, time.Time{}, statsOptions{enabledMetrics: enabled})
+	must.MapLen(t, 1, result.Stats.Attributes)
+	must.MapContainsKey(t, result.Stats.Attributes, TemperatureAttr)
+	// the summary is always reported
+	must.Eq(t, pointer.Of(int64(512)), result.Summary.IntNumeratorVal)
+
+	// the foreign process count, reservation energy, idle reserved time and
+	// PCIe errors are added by addForeignProcessStats,
+	// addReservationEnergyStats, addIdleReservedStats and addPCIeErrorStats
+	result = statsForItem(statsItem, time.Time{}, statsOptions{eccCounters: eccCountersBoth, utilizationSampling: true})
+	must.MapLen(t, len(statsMetrics)-5, result.Stats.Attributes)
+	must.MapNotContainsKey(t, result.Stats.Attributes, ForeignProcessCountAttr)
+	must.MapNotContainsKey(t, result.Stats.Attributes, ReservationEnergyAttr)
+	must.MapNotContainsKey(t, result.Stats.Attributes, IdleReservedAttr)
+	must.MapNotContainsKey(t, result.Stats.Attributes, PCIeCorrectableErrorsAttr)
+	must.MapNotContainsKey(t, result.Stats.Attributes, PCIeUncorrectableErrorsAttr)
+}
+
+func TestStatsForItemAveragePowerUsage(t *testing.T) {
+	statsItem := &nvml.StatsData{
+		DeviceData: &nvml.DeviceData{
+			UUID:   "UUID1",
+			PowerW: pointer.Of(uint(700)),
+		},
+		PowerMW:             pointer.Of(uint(700000)),
+		AveragePowerUsageMW: pointer.Of(uint(412500)),
+		EnergyMJ:            pointer.Of(uint64(9000000)),
+	}
+
+	result := statsForItem(statsItem, time.Time{}, statsOptions{})
+	must.Eq(t, &structs.StatValue{
+		Unit:              PowerUsageUnit,
+		Desc:              AveragePowerUsageDesc,
+		IntNumeratorVal:   pointer.Of(int64(412)),
+		IntDenominatorVal: pointer.Of(int64(700)),
+	}, result.Stats.Attributes[AveragePowerUsageAttr])
+	must.Eq(t, &structs.StatValue{
+		Unit:              UnitWattHour,
+		Desc:              EnergyConsumedDesc,
+		FloatNumeratorVal: pointer.Of(2.5),
+	}, result.Stats.Attributes[EnergyConsumedAttr])
+
+	result = statsForItem(statsItem, time.Time{}, statsOptions{powerUnit: structs.UnitmW})
+	must.Eq(t, pointer.Of(int64(412500)), result.Stats.Attributes[AveragePowerUsageAttr].IntNumeratorVal)
+	must.Eq(t, PowerUsageMilliwattsUnit, result.Stats.Attributes[AveragePowerUsageAttr].Unit)
+
+	// the average is not available on the first stats collection
+	statsItem.AveragePowerUsageMW = nil
+	result = statsForItem(statsItem, time.Time{}, statsOptions{})
+	must.Eq(t, notAvailable, *result.Stats.Attributes[AveragePowerUsageAttr].StringVal)
+
+	// devices without energy counter report neither
+	statsItem.EnergyMJ = nil
+	result = statsForItem(statsItem, time.Time{}, statsOptions{})
+	must.MapNotContainsKey(t, result.Stats.Attributes, AveragePowerUsageAttr)
+	must.MapNotContainsKey(t, result.Stats.Attributes, EnergyConsumedAttr)
+}
+
+func TestStatsForItemFanSpeed(t *testing.T) {
+	statsItem := &nvml.StatsData{
+		DeviceData:     &nvml.DeviceData{UUID: "UUID1"},
+		FanSpeed:       pointer.Of(uint(0)),
+		FanTargetSpeed: pointer.Of(uint(80)),
+	}
+
+	// a stopped fan is reported rather than omitted
+	result := statsForItem(statsItem, time.Time{}, statsOptions{})
+	must.Eq(t, pointer.Of(int64(0)), result.Stats.Attributes[FanSpeedAttr].IntNumeratorVal)
+	must.Eq(t, pointer.Of(int64(80)), result.Stats.Attributes[FanTargetSpeedAttr].IntNumeratorVal)
+	must.Eq(t, UnitPercent, result.Stats.Attributes[FanSpeedAttr].Unit)
+
+	// passively cooled devices report no fan stats
+	statsItem.FanSpeed, statsItem.FanTargetSpeed = nil, nil
+	result = statsForItem(statsItem, time.Time{}, statsOptions{})
+	must.MapNotContainsKey(t, result.Stats.Attributes, FanSpeedAttr)
+	must.MapNotContainsKey(t, result.Stats.Attributes, FanTargetSpeedAttr)
+}
+
+func TestStatsForItemEngineUtilization(t *testing.T) {
+	statsItem := &nvml.StatsData{
+		DeviceData:      &nvml.DeviceData{UUID: "UUID1"},
+		JPEGUtilization: pointer.Of(uint(12)),
+		OFAUtilization:  pointer.Of(uint(0)),
+	}
+
+	result := statsForItem(statsItem, time.Time{}, statsOptions{})
+	must.Eq(t, &structs.StatValue{
+		Unit:            UnitPercent,
+		Desc:            JPEGUtilizationDesc,
+		IntNumeratorVal: pointer.Of(int64(12)),
+	}, result.Stats.Attributes[JPEGUtilizationAttr])
+	must.Eq(t, pointer.Of(int64(0)), result.Stats.Attributes[OFAUtilizationAttr].IntNumeratorVal)
+
+	// devices without JPEG decoders or OFA report no engine stats
+	statsItem.JPEGUtilization, statsItem.OFAUtilization = nil, nil
+	result = statsForItem(statsItem, time.Time{}, statsOptions{})
+	must.MapNotContainsKey(t, result.Stats.Attributes, JPEGUtilizationAttr)
+	must.MapNotContainsKey(t, result.Stats.Attributes, OFAUtilizationAttr)
+}
+
+func TestStatsForItemGPMMetrics(t *testing.T) {
+	statsItem := &nvml.StatsData{
+		DeviceData: &nvml.DeviceData{UUID: "UUID1"},
+		GPM: &nvml.GPMMetrics{
+			SMActivity:     pointer.Of(75.5),
+			TensorActivity: pointer.Of(40.0),
+		},
+	}
+
+	result := statsForItem(statsItem, time.Time{}, statsOptions{})
+	must.Eq(t, &structs.StatValue{
+		Unit:              UnitPercent,
+		Desc:              SMActivityDesc,
+		FloatNumeratorVal: pointer.Of(75.5),
+	}, result.Stats.Attributes[SMActivityAttr])
+	must.Eq(t, pointer.Of(40.0), result.Stats.Attributes[TensorActivityAttr].FloatNumeratorVal)
+	must.Eq(t, pointer.Of(notAvailable), result.Stats.Attributes[DRAMBandwidthUtilizationAttr].StringVal)
+	// devices without NVLinks report no NVLink bandwidth
+	must.MapNotContainsKey(t, result.Stats.Attributes, NVLinkBandwidthAttr)
+
+	// devices without GPM support report no GPM stats
+	statsItem.GPM = nil
+	result = statsForItem(statsItem, time.Time{}, statsOptions{})
+	must.MapNotContainsKey(t, result.Stats.Attributes, SMActivityAttr)
+	must.MapNotContainsKey(t, result.Stats.Attributes, TensorActivityAttr)
+	must.MapNotContainsKey(t, result.Stats.Attributes, DRAMBandwidthUtilizationAttr)
+}
+
+func TestStatsForItemHistograms(t *testing.T) {
+	statsItem := &nvml.StatsData{
+		DeviceData:              &nvml.DeviceData{UUID: "UUID1"},
+		GPUUtilizationHistogram: &nvml.Histogram{P50: 20, P95: 90, Max: 100},
+		PowerUsageMWHistogram:   &nvml.Histogram{P50: 100500, P95: 250000, Max: 300000},
+		TemperatureCHistogram:   &nvml.Histogram{P50: 50, P95: 70, Max: 75},
+	}
+	transforms, err := parseStatTransforms(StatsConfig{TemperatureUnit: UnitFahrenheit})
+	must.NoError(t, err)
+
+	result := statsForItem(statsItem, time.Time{}, statsOptions{transforms: transforms})
+	must.Eq(t, &structs.StatValue{
+		Unit:            UnitPercent,
+		Desc:            GPUUtilizationP95Desc,
+		IntNumeratorVal: pointer.Of(int64(90)),
+	}, result.Stats.Attributes[GPUUtilizationP95Attr])
+	must.Eq(t, &structs.StatValue{
+		Unit:            PowerUsageUnit,
+		Desc:            PowerUsageP50Desc,
+		IntNumeratorVal: pointer.Of(int64(100)),
+	}, result.Stats.Attributes[PowerUsageP50Attr])
+	must.Eq(t, &structs.StatValue{
+		Unit:              UnitFahrenheit,
+		Desc:              TemperaturePeakDesc,
+		FloatNumeratorVal: pointer.Of(167.0),
+	}, result.Stats.Attributes[TemperaturePeakAttr])
+
+	result = statsForItem(statsItem, time.Time{}, statsOptions{powerUnit: structs.UnitmW})
+	must.Eq(t, pointer.Of(int64(300000)), result.Stats.Attributes[PowerUsagePeakAttr].IntNumeratorVal)
+	must.Eq(t, PowerUsageMilliwattsUnit, result.Stats.Attributes[PowerUsagePeakAttr].Unit)
+
+	// histograms are only reported when stats are sampled
+	statsItem.GPUUtilizationHistogram, statsItem.PowerUsageMWHistogram, statsItem.TemperatureCHistogram = nil, nil, nil
+	result = statsForItem(statsItem, time.Time{}, statsOptions{})
+	must.MapNotContainsKey(t, result.Stats.Attributes, GPUUtilizationP50Attr)
+	must.MapNotContainsKey(t, result.Stats.Attributes, PowerUsageP95Attr)
+	must.MapNotContainsKey(t, result.Stats.Attributes, TemperaturePeakAttr)
+}
+
+func TestPercentUsedStat(t *testing.T) {
+	cases := []struct {
+		Name     string
+		UsedMiB  *uint64
+		TotalMiB *uint64
+		Expected *structs.StatValue
+	}{
+		{
+			Name:     "rounded percentage",
+			UsedMiB:  pointer.Of(uint64(1000)),
+			TotalMiB: pointer.Of(uint64(15360)),
+			Expected: &structs.StatValue{
+				Unit:            UnitPercent,
+				Desc:            MemoryUsedDesc,
+				IntNumeratorVal: pointer.Of(int64(7)),
+			},
+		},
+		{
+			Name:     "unknown used memory",
+			TotalMiB: pointer.Of(uint64(15360)),
+			Expected: newNotAvailableDeviceStats(UnitPercent, MemoryUsedDesc),
+		},
+		{
+			Name:     "no memory",
+			UsedMiB:  pointer.Of(uint64(0)),
+			TotalMiB: pointer.Of(uint64(0)),
+			Expected: newNotAvailableDeviceStats(UnitPercent, MemoryUsedDesc),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			must.Eq(t, c.Expected, percentUsedStat(c.UsedMiB, c.TotalMiB, MemoryUsedDesc))
 		})
-		must.Eq(t, testCase.ExpectedWriteToChannel, actualResult)
 	}
 }
+
+func TestStatsForItemECCCounters(t *testing.T) {
+	statsItem := &nvml.StatsData{
+		DeviceData:               &nvml.DeviceData{UUID: "UUID1"},
+		ECCErrorsDevice:          pointer.Of(uint64(1)),
+		ECCErrorsDeviceAggregate: pointer.Of(uint64(42)),
+	}
+
+	for _, testCase := range []struct {
+		Name        string
+		ECCCounters string
+		Expected    []string
+		NotExpected []string
+	}{
+		{
+			Name:        "default",
+			Expected:    []string{ECCErrorsL1CacheAttr, ECCErrorsL2CacheAttr, ECCErrorsDeviceAttr},
+			NotExpected: []string{ECCErrorsL1CacheAggregateAttr, ECCErrorsL2CacheAggregateAttr, ECCErrorsDeviceAggregateAttr},
+		},
+		{
+			Name:        "aggregate",
+			ECCCounters: eccCountersAggregate,
+			Expected:    []string{ECCErrorsL1CacheAggregateAttr, ECCErrorsL2CacheAggregateAttr, ECCErrorsDeviceAggregateAttr},
+			NotExpected: []string{ECCErrorsL1CacheAttr, ECCErrorsL2CacheAttr, ECCErrorsDeviceAttr},
+		},
+		{
+			Name:        "both",
+			ECCCounters: eccCountersBoth,
+			Expected: []string{
+				ECCErrorsL1CacheAttr, ECCErrorsL2CacheAttr, ECCErrorsDeviceAttr,
+				ECCErrorsL1CacheAggregateAttr, ECCErrorsL2CacheAggregateAttr, ECCErrorsDeviceAggregateAttr,
+			},
+		},
+	} {
+		t.Run(testCase.Name, func(t *testing.T) {
+			result := statsForItem(statsItem, time.Time{}, statsOptions{eccCounters: testCase.ECCCounters})
+			for _, attr := range testCase.Expected {
+				must.MapContainsKey(t, result.Stats.Attributes, attr)
+			}
+			for _, attr := range testCase.NotExpected {
+				must.MapNotContainsKey(t, result.Stats.Attributes, attr)
+			}
+		})
+	}
+
+	result := statsForItem(statsItem, time.Time{}, statsOptions{eccCounters: eccCountersBoth})
+	must.Eq(t, &structs.StatValue{
+		Unit:            UnitCount,
+		Desc:            ECCErrorsDeviceAggregateDesc,
+		IntNumeratorVal: pointer.Of(int64(42)),
+	}, result.Stats.Attributes[ECCErrorsDeviceAggregateAttr])
+	must.Eq(t, pointer.Of(notAvailable), result.Stats.Attributes[ECCErrorsL1CacheAggregateAttr].StringVal)
+}
+
+func TestParseStatTransforms(t *testing.T) {
+	transforms, err := parseStatTransforms(StatsConfig{})
+	must.NoError(t, err)
+	must.Nil(t, transforms)
+
+	transforms, err = parseStatTransforms(StatsConfig{
+		TemperatureUnit: UnitFahrenheit,
+		Scale: []StatsScaleConfig{
+			{Metric: "memory_state", Factor: 1.0 / 1024, Unit: structs.UnitGiB},
+		},
+	})
+	must.NoError(t, err)
+	must.MapContainsKeys(t, transforms, []string{TemperatureAttr, MemoryStateAttr})
+
+	_, err = parseStatTransforms(StatsConfig{TemperatureUnit: "K"})
+	must.Error(t, err)
+
+	_, err = parseStatTransforms(StatsConfig{Scale: []StatsScaleConfig{{Metric: "clock_speed", Factor: 2}}})
+	must.EqError(t, err, `unknown metric "clock_speed" in scale`)
+
+	_, err = parseStatTransforms(StatsConfig{Scale: []StatsScaleConfig{{Metric: "temperature"}}})
+	must.Error(t, err)
+}
+
+func TestStatsForItemTransforms(t *testing.T) {
+	statsItem := &nvml.StatsData{
+		DeviceData: &nvml.DeviceData{
+			UUID:      "UUID1",
+			MemoryMiB: pointer.Of(uint64(2048)),
+		},
+		TemperatureC:  pointer.Of(uint(61)),
+		UsedMemoryMiB: pointer.Of(uint64(512)),
+	}
+	transforms, err := parseStatTransforms(StatsConfig{
+		TemperatureUnit: UnitFahrenheit,
+		Scale: []StatsScaleConfig{
+			{Metric: "memory_state", Factor: 1.0 / 1024, Unit: structs.UnitGiB},
+			{Metric: "power_usage", Factor: 2},
+		},
+	})
+	must.NoError(t, err)
+
+	result := statsForItem(statsItem, time.Time{}, statsOptions{transforms: transforms})
+	must.Eq(t, &structs.StatValue{
+		Unit:              UnitFahrenheit,
+		Desc:              TemperatureDesc,
+		FloatNumeratorVal: pointer.Of(141.8),
+	}, result.Stats.Attributes[TemperatureAttr])
+
+	memoryState := &structs.StatValue{
+		Unit:                structs.UnitGiB,
+		Desc:                MemoryStateDesc,
+		FloatNumeratorVal:   pointer.Of(0.5),
+		FloatDenominatorVal: pointer.Of(2.0),
+	}
+	must.Eq(t, memoryState, result.Stats.Attributes[MemoryStateAttr])
+	must.Eq(t, memoryState, result.Summary)
+
+	// values that are not available are left as is
+	must.Eq(t, newNotAvailableDeviceStats(PowerUsageUnit, PowerUsageDesc), result.Stats.Attributes[PowerUsageAttr])
+}
+
+func TestWaitForFirstFingerprint(t *testing.T) {
+	newDevice := func(timeout time.Duration) *NvidiaDevice {
+		return &NvidiaDevice{
+			statsWarmupTimeout: timeout,
+			logger:             hclog.NewNullLogger(),
+		}
+	}
+
+	t.Run("no timeout does not wait", func(t *testing.T) {
+		must.True(t, newDevice(0).waitForFirstFingerprint(context.Background()))
+	})
+
+	t.Run("fingerprinted", func(t *testing.T) {
+		d := newDevice(time.Hour)
+		d.markFingerprinted()
+		d.markFingerprinted()
+		must.True(t, d.waitForFirstFingerprint(context.Background()))
+	})
+
+	t.Run("timeout elapses", func(t *testing.T) {
+		must.True(t, newDevice(time.Millisecond).waitForFirstFingerprint(context.Background()))
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		must.False(t, newDevice(time.Hour).waitForFirstFingerprint(ctx))
+	})
+
+	t.Run("first fingerprint unblocks stats", func(t *testing.T) {
+		d := newDevice(time.Hour)
+		d.collector = &MockNvmlClient{
+			FingerprintResponseReturned: &nvml.FingerprintData{DriverVersion: "1"},
+		}
+		go d.writeFingerprintToChannel(make(chan *device.FingerprintResponse, 1), "")
+		must.True(t, d.waitForFirstFingerprint(context.Background()))
+	})
+}
@@ -0,0 +1,124 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// Attribute names describing the Nvidia container toolkit of the node,
+	// they are reported on every device group
+	ContainerToolkitVersionAttr = "container_toolkit_version"
+	RuntimeConfiguredAttr       = "runtime_configured"
+
+	// nvidiaRuntime is the name of the Nvidia container runtime binary, and
+	// the name the toolkit registers it under in the container engines
+	nvidiaRuntime = "nvidia-container-runtime"
+
+	// toolkitCommandTimeout bounds the toolkit commands run for detection
+	toolkitCommandTimeout = 5 * time.Second
+)
+
+// Configuration files of the container engines checked for the Nvidia
+// container runtime
+const (
+	dockerConfigPath     = "/etc/docker/daemon.json"
+	containerdConfigPath = "/etc/containerd/config.toml"
+)
+
+// containerToolkit describes the Nvidia container toolkit of the node
+type containerToolkit struct {
+	// version is the toolkit version, empty when it is not installed
+	version string
+
+	// runtimeConfigured is whether Docker or containerd are configured with
+	// the Nvidia container runtime, without which containers can not see GPUs
+	runtimeConfigured bool
+}
+
+// detectContainerToolkit detects the Nvidia container toolkit of the node
+func detectContainerToolkit() *containerToolkit {
+	return &containerToolkit{
+		version:           containerToolkitVersion(),
+		runtimeConfigured: dockerRuntimeConfigured(dockerConfigPath) || containerdRuntimeConfigured(containerdConfigPath),
+	}
+}
+
+// containerToolkitVersion returns the version reported by the toolkit CLIs,
+// or an empty string when none is installed
+func containerToolkitVersion() string {
+	if out, err := runToolkitCommand("nvidia-ctk", "--version"); err == nil {
+		if version := parseToolkitVersion(out); version != "" {
+			return version
+		}
+	}
+	if out, err := runToolkitCommand("nvidia-container-cli", "--version"); err == nil {
+		return parseToolkitVersion(out)
+	}
+	return ""
+}
+
+func runToolkitCommand(name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), toolkitCommandTimeout)
+	defer cancel()
+	return exec.CommandContext(ctx, name, args...).Output()
+}
+
+// parseToolkitVersion extracts the version from the output of
+// "nvidia-ctk --version", such as "NVIDIA Container Toolkit CLI version 1.14.3",
+// or "nvidia-container-cli --version", such as "cli-version: 1.14.3"
+func parseToolkitVersion(out []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "NVIDIA Container Toolkit CLI version "):
+			return strings.TrimPrefix(line, "NVIDIA Container Toolkit CLI version ")
+		case strings.HasPrefix(line, "cli-version:"):
+			return strings.TrimSpace(strings.TrimPrefix(line, "cli-version:"))
+		}
+	}
+	return ""
+}
+
+// dockerRuntimeConfigured reports whether the Docker daemon configuration at
+// path registers the Nvidia container runtime
+func dockerRuntimeConfigured(path string) bool {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	var config struct {
+		Runtimes map[string]struct {
+			Path string `json:"path"`
+		} `json:"runtimes"`
+	}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return false
+	}
+	for _, runtime := range config.Runtimes {
+		if strings.Contains(runtime.Path, nvidiaRuntime) {
+			return true
+		}
+	}
+	return false
+}
+
+// containerdRuntimeConfigured reports whether the containerd configuration at
+// path references the Nvidia container runtime
+func containerdRuntimeConfigured(path string) bool {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(content, []byte(nvidiaRuntime))
+}
@@ -0,0 +1,124 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/shoenig/test/must"
+)
+
+func TestParseToolkitVersion(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Output   string
+		Expected string
+	}{
+		{
+			Name:     "nvidia-ctk",
+			Output:   "NVIDIA Container Toolkit CLI version 1.14.3\ncommit: 53b24618a542025b108239fe602e66e912b7d6e2\n",
+			Expected: "1.14.3",
+		},
+		{
+			Name:     "nvidia-container-cli",
+			Output:   "cli-version: 1.14.3\nlib-version: 1.14.3\nbuild date: 2023-10-19T11:32+00:00\n",
+			Expected: "1.14.3",
+		},
+		{
+			Name:     "unknown output",
+			Output:   "usage: nvidia-ctk [options]\n",
+			Expected: "",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			must.Eq(t, c.Expected, parseToolkitVersion([]byte(c.Output)))
+		})
+	}
+}
+
+func TestRuntimeConfigured(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		must.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		return path
+	}
+
+	cases := []struct {
+		Name       string
+		Configured func(string) bool
+		Path       string
+		Expected   bool
+	}{
+		{
+			Name:       "docker with nvidia runtime",
+			Configured: dockerRuntimeConfigured,
+			Path:       write("daemon.json", `{"runtimes": {"nvidia": {"path": "nvidia-container-runtime", "args": []}}}`),
+			Expected:   true,
+		},
+		{
+			Name:       "docker without nvidia runtime",
+			Configured: dockerRuntimeConfigured,
+			Path:       write("daemon-plain.json", `{"log-driver": "journald"}`),
+			Expected:   false,
+		},
+		{
+			Name:       "docker without config",
+			Configured: dockerRuntimeConfigured,
+			Path:       filepath.Join(dir, "missing.json"),
+			Expected:   false,
+		},
+		{
+			Name:       "containerd with nvidia runtime",
+			Configured: containerdRuntimeConfigured,
+			Path: write("config.toml", `[plugins."io.containerd.grpc.v1.cri".containerd.runtimes.nvidia.options]
+  BinaryName = "/usr/bin/nvidia-container-runtime"`),
+			Expected: true,
+		},
+		{
+			Name:       "containerd without nvidia runtime",
+			Configured: containerdRuntimeConfigured,
+			Path:       write("config-plain.toml", `version = 2`),
+			Expected:   false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			must.Eq(t, c.Expected, c.Configured(c.Path))
+		})
+	}
+}
+
+func TestWriteFingerprintToChannelToolkit(t *testing.T) {
+	d := &NvidiaDevice{
+		collector: &MockNvmlClient{
+			FingerprintResponseReturned: &nvml.FingerprintData{
+				DriverVersion: "1",
+				Devices: []*nvml.FingerprintDeviceData{
+					{
+						DeviceData: &nvml.DeviceData{
+							UUID:       "1",
+							DeviceName: pointer.Of("Name1"),
+						},
+					},
+				},
+			},
+		},
+		toolkit: &containerToolkit{version: "1.14.3"},
+		logger:  hclog.NewNullLogger(),
+	}
+
+	channel := make(chan *device.FingerprintResponse, 1)
+	d.writeFingerprintToChannel(channel, "")
+	attributes := (<-channel).Devices[0].Attributes
+	must.Eq(t, "1.14.3", *attributes[ContainerToolkitVersionAttr].String)
+	must.False(t, *attributes[RuntimeConfiguredAttr].Bool)
+}
@@ -0,0 +1,128 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+)
+
+// topology describes how the GPUs of the node are connected, for topology
+// aware placement tooling. It is written to the topology file and served by
+// the debug endpoint.
+type topology struct {
+	UpdatedAt time.Time      `json:"updated_at"`
+	GPUs      []*topologyGPU `json:"gpus"`
+	P2P       []*topologyP2P `json:"p2p"`
+}
+
+// topologyGPU describes the locality of a physical GPU in the topology
+type topologyGPU struct {
+	UUID           string   `json:"uuid"`
+	Index          *uint    `json:"index,omitempty"`
+	PCIBusID       string   `json:"pci_bus_id,omitempty"`
+	NUMANode       *uint    `json:"numa_node,omitempty"`
+	MemoryNUMANode *uint    `json:"memory_numa_node,omitempty"`
+	MIGDevices     []string `json:"mig_devices,omitempty"`
+}
+
+// topologyP2P holds the peer-to-peer capabilities between two GPUs in the
+// topology, and how they are connected
+type topologyP2P struct {
+	UUID     string `json:"uuid"`
+	PeerUUID string `json:"peer_uuid"`
+	Read     bool   `json:"read"`
+	Write    bool   `json:"write"`
+	NVLink   bool   `json:"nvlink"`
+	PCIePath string `json:"pcie_path,omitempty"`
+	NVLinks  uint   `json:"nvlinks"`
+}
+
+// topologyOf returns the topology of the fingerprinted GPUs at now. MIG
+// devices are listed under their physical GPU.
+func topologyOf(fingerprintData *nvml.FingerprintData, now time.Time) *topology {
+	t := &topology{
+		UpdatedAt: now,
+		GPUs:      []*topologyGPU{},
+		P2P:       make([]*topologyP2P, 0, len(fingerprintData.P2P)),
+	}
+
+	gpus := make(map[string]*topologyGPU)
+	for _, devices := range [][]*nvml.FingerprintDeviceData{fingerprintData.Devices, fingerprintData.MIGParents} {
+		for _, dev := range devices {
+			if dev.ParentUUID != "" {
+				continue
+			}
+			gpu := &topologyGPU{
+				UUID:           dev.UUID,
+				Index:          dev.Index,
+				PCIBusID:       dev.PCIBusID,
+				NUMANode:       dev.NUMANode,
+				MemoryNUMANode: dev.MemoryNUMANode,
+			}
+			gpus[dev.UUID] = gpu
+			t.GPUs = append(t.GPUs, gpu)
+		}
+	}
+	for _, dev := range fingerprintData.Devices {
+		if gpu, ok := gpus[dev.ParentUUID]; ok {
+			gpu.MIGDevices = append(gpu.MIGDevices, dev.UUID)
+		}
+	}
+	slices.SortFunc(t.GPUs, func(a, b *topologyGPU) int {
+		return strings.Compare(a.UUID, b.UUID)
+	})
+
+	for _, status := range fingerprintData.P2P {
+		t.P2P = append(t.P2P, &topologyP2P{
+			UUID:     status.UUID,
+			PeerUUID: status.PeerUUID,
+			Read:     status.Read,
+			Write:    status.Write,
+			NVLink:   status.NVLink,
+			PCIePath: status.PCIePath,
+			NVLinks:  status.NVLinks,
+		})
+	}
+	return t
+}
+
+// updateTopology records the topology of the fingerprinted GPUs for the
+// debug endpoint and writes it to the topology file, if configured. The file
+// is replaced atomically so that readers never see a partial write.
+func (d *NvidiaDevice) updateTopology(fingerprintData *nvml.FingerprintData) {
+	t := topologyOf(fingerprintData, d.now())
+
+	d.deviceLock.Lock()
+	d.topology = t
+	d.deviceLock.Unlock()
+
+	if d.topologyFile == "" {
+		return
+	}
+	if err := writeFileAtomic(d.topologyFile, t); err != nil {
+		d.errorLog.Error(d.logger, "failed to write topology file", err)
+	}
+}
+
+// serveTopology serves the latest topology of the GPUs as compact JSON
+func (d *NvidiaDevice) serveTopology(w http.ResponseWriter, _ *http.Request) {
+	d.deviceLock.RLock()
+	t := d.topology
+	d.deviceLock.RUnlock()
+
+	if t == nil {
+		http.Error(w, "devices not fingerprinted yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(t); err != nil {
+		d.logger.Debug("failed to serve topology", "error", err)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/hashicorp/nomad/helper/pointer"
+	"github.com/shoenig/test/must"
+)
+
+func TestTopologyOf(t *testing.T) {
+	fingerprintData := &nvml.FingerprintData{
+		Devices: []*nvml.FingerprintDeviceData{
+			{
+				DeviceData: &nvml.DeviceData{UUID: "GPU-2"},
+				Index:      pointer.Of(uint(1)),
+				PCIBusID:   "00000000:41:00.0",
+				NUMANode:   pointer.Of(uint(1)),
+			},
+			{
+				DeviceData: &nvml.DeviceData{UUID: "MIG-1"},
+				ParentUUID: "GPU-1",
+			},
+		},
+		MIGParents: []*nvml.FingerprintDeviceData{
+			{
+				DeviceData: &nvml.DeviceData{UUID: "GPU-1"},
+				Index:      pointer.Of(uint(0)),
+				PCIBusID:   "00000000:01:00.0",
+				NUMANode:   pointer.Of(uint(0)),
+				MIGParent:  true,
+			},
+		},
+		P2P: []*nvml.P2PStatus{
+			{UUID: "GPU-1", PeerUUID: "GPU-2", Read: true, Write: true, NVLink: true, PCIePath: "system", NVLinks: 4},
+		},
+	}
+
+	actual := topologyOf(fingerprintData, time.Unix(1700000000, 0))
+	must.Eq(t, &topology{
+		UpdatedAt: time.Unix(1700000000, 0),
+		GPUs: []*topologyGPU{
+			{
+				UUID:       "GPU-1",
+				Index:      pointer.Of(uint(0)),
+				PCIBusID:   "00000000:01:00.0",
+				NUMANode:   pointer.Of(uint(0)),
+				MIGDevices: []string{"MIG-1"},
+			},
+			{
+				UUID:     "GPU-2",
+				Index:    pointer.Of(uint(1)),
+				PCIBusID: "00000000:41:00.0",
+				NUMANode: pointer.Of(uint(1)),
+			},
+		},
+		P2P: []*topologyP2P{
+			{UUID: "GPU-1", PeerUUID: "GPU-2", Read: true, Write: true, NVLink: true, PCIePath: "system", NVLinks: 4},
+		},
+	}, actual)
+}
+
+func TestUpdateTopology(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topology.json")
+	d := &NvidiaDevice{
+		logger:       hclog.NewNullLogger(),
+		topologyFile: path,
+	}
+
+	// the topology is not served before the first fingerprint
+	recorder := httptest.NewRecorder()
+	d.serveTopology(recorder, httptest.NewRequest(http.MethodGet, "/debug/topology", nil))
+	must.Eq(t, http.StatusServiceUnavailable, recorder.Code)
+
+	d.updateTopology(&nvml.FingerprintData{
+		Devices: []*nvml.FingerprintDeviceData{
+			{DeviceData: &nvml.DeviceData{UUID: "UUID1"}},
+			{DeviceData: &nvml.DeviceData{UUID: "UUID2"}},
+		},
+		P2P: []*nvml.P2PStatus{
+			{UUID: "UUID1", PeerUUID: "UUID2", Read: true, Write: true, NVLink: true},
+		},
+	})
+
+	content, err := os.ReadFile(path)
+	must.NoError(t, err)
+
+	var written topology
+	must.NoError(t, json.Unmarshal(content, &written))
+	must.False(t, written.UpdatedAt.IsZero())
+	must.Eq(t, []*topologyGPU{{UUID: "UUID1"}, {UUID: "UUID2"}}, written.GPUs)
+	must.Eq(t, []*topologyP2P{
+		{UUID: "UUID1", PeerUUID: "UUID2", Read: true, Write: true, NVLink: true},
+	}, written.P2P)
+	must.StrContains(t, string(content), `"peer_uuid": "UUID2"`)
+
+	// the debug endpoint serves the same topology as compact JSON
+	recorder = httptest.NewRecorder()
+	d.serveTopology(recorder, httptest.NewRequest(http.MethodGet, "/debug/topology", nil))
+	must.Eq(t, http.StatusOK, recorder.Code)
+	must.Eq(t, "application/json", recorder.Header().Get("Content-Type"))
+	must.StrNotContains(t, recorder.Body.String(), "\n  ")
+
+	var served topology
+	must.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &served))
+	must.Eq(t, written.P2P, served.P2P)
+}
@@ -0,0 +1,68 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+)
+
+// VerifyExpectations are the expectations on the GPUs of a node checked by
+// Verify, such as in image bake pipelines and node bootstrap scripts before
+// the node joins the cluster
+type VerifyExpectations struct {
+	// MinGPUs is the minimum number of physical GPUs of the node, the MIG
+	// devices of a GPU count as one GPU
+	MinGPUs int
+
+	// MinDriver is the minimum version of the Nvidia driver, such as "535" or
+	// "535.104.05", any version is accepted when empty
+	MinDriver string
+}
+
+// VerifyResult is the outcome of Verify
+type VerifyResult struct {
+	GPUs          int
+	DriverVersion string
+
+	// Failures describes each expectation the node does not meet, it is empty
+	// when the node meets all of them
+	Failures []string
+}
+
+// Verify checks that the GPUs reported by client meet expectations
+func Verify(client nvml.NvmlClient, expectations VerifyExpectations) (*VerifyResult, error) {
+	fingerprintData, err := client.GetFingerprintData()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fingerprint GPUs: %v", err)
+	}
+
+	result := &VerifyResult{
+		GPUs:          len(fingerprintData.MIGParents),
+		DriverVersion: fingerprintData.DriverVersion,
+	}
+	for _, dev := range fingerprintData.Devices {
+		if dev.ParentUUID == "" {
+			result.GPUs++
+		}
+	}
+	if result.GPUs < expectations.MinGPUs {
+		result.Failures = append(result.Failures,
+			fmt.Sprintf("found %d GPUs, expected at least %d", result.GPUs, expectations.MinGPUs))
+	}
+
+	if expectations.MinDriver != "" {
+		older, ok := versionOlder(fingerprintData.DriverVersion, expectations.MinDriver)
+		switch {
+		case !ok:
+			result.Failures = append(result.Failures,
+				fmt.Sprintf("failed to compare driver version %q with %q", fingerprintData.DriverVersion, expectations.MinDriver))
+		case older:
+			result.Failures = append(result.Failures,
+				fmt.Sprintf("driver version %s is older than %s", fingerprintData.DriverVersion, expectations.MinDriver))
+		}
+	}
+	return result, nil
+}
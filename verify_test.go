@@ -0,0 +1,72 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package nvidia
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/nomad-device-nvidia/nvml"
+	"github.com/shoenig/test/must"
+)
+
+func TestVerify(t *testing.T) {
+	fingerprintData := &nvml.FingerprintData{
+		DriverVersion: "535.104.05",
+		Devices: []*nvml.FingerprintDeviceData{
+			{DeviceData: &nvml.DeviceData{UUID: "GPU-1"}},
+			{DeviceData: &nvml.DeviceData{UUID: "MIG-1"}, ParentUUID: "GPU-2"},
+			{DeviceData: &nvml.DeviceData{UUID: "MIG-2"}, ParentUUID: "GPU-2"},
+		},
+		MIGParents: []*nvml.FingerprintDeviceData{
+			{DeviceData: &nvml.DeviceData{UUID: "GPU-2"}, MIGParent: true},
+		},
+	}
+
+	cases := []struct {
+		Name         string
+		Expectations VerifyExpectations
+		Failures     []string
+	}{
+		{
+			Name:         "expectations met",
+			Expectations: VerifyExpectations{MinGPUs: 2, MinDriver: "535"},
+		},
+		{
+			Name:         "exact driver version",
+			Expectations: VerifyExpectations{MinDriver: "535.104.05"},
+		},
+		{
+			Name:         "too few GPUs",
+			Expectations: VerifyExpectations{MinGPUs: 4},
+			Failures:     []string{"found 2 GPUs, expected at least 4"},
+		},
+		{
+			Name:         "old driver",
+			Expectations: VerifyExpectations{MinGPUs: 3, MinDriver: "550"},
+			Failures: []string{
+				"found 2 GPUs, expected at least 3",
+				"driver version 535.104.05 is older than 550",
+			},
+		},
+		{
+			Name:         "invalid driver version",
+			Expectations: VerifyExpectations{MinDriver: "r550"},
+			Failures:     []string{`failed to compare driver version "535.104.05" with "r550"`},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			client := &MockNvmlClient{FingerprintResponseReturned: fingerprintData}
+			result, err := Verify(client, c.Expectations)
+			must.NoError(t, err)
+			must.Eq(t, 2, result.GPUs)
+			must.Eq(t, "535.104.05", result.DriverVersion)
+			must.Eq(t, c.Failures, result.Failures)
+		})
+	}
+
+	_, err := Verify(&MockNvmlClient{FingerprintError: errors.New("nvml failed")}, VerifyExpectations{})
+	must.ErrorContains(t, err, "nvml failed")
+}